@@ -0,0 +1,139 @@
+package obs
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// AdaptiveSamplingConfig configures an AdaptiveSampler.
+type AdaptiveSamplingConfig struct {
+	// BoostRate is the probability with which root spans of a misbehaving operation are force-sampled,
+	// on top of the tracer's own sampling, right after the misbehavior is detected.
+	BoostRate float64
+	// ErrorRateThreshold boosts an operation when its recent error rate exceeds it, e.g. 0.05.
+	ErrorRateThreshold float64
+	// LatencyFactor boosts an operation when its recent latency exceeds its long term latency by this factor.
+	LatencyFactor float64
+	// DecayHalfLife controls how fast the boost decays back to nothing once the operation recovers.
+	DecayHalfLife time.Duration
+	// MinObservations is the number of completed spans required before an operation can be boosted.
+	MinObservations int
+}
+
+// DefaultAdaptiveSamplingConfig traces every request of an operation that starts failing more than 5% of
+// the time or slows down to twice its usual latency, decaying with a one minute half-life.
+var DefaultAdaptiveSamplingConfig = AdaptiveSamplingConfig{
+	BoostRate:          1.0,
+	ErrorRateThreshold: 0.05,
+	LatencyFactor:      2.0,
+	DecayHalfLife:      time.Minute,
+	MinObservations:    20,
+}
+
+const (
+	// smoothing factors of the per-operation moving averages, per observation.
+	adaptiveFastAlpha = 0.1
+	adaptiveSlowAlpha = 0.005
+)
+
+type adaptiveOpStats struct {
+	observations int
+	errorRate    float64
+	fastLatency  float64
+	slowLatency  float64
+	boostedAt    time.Time
+}
+
+// AdaptiveSampler watches the outcome of spans per operation and temporarily boosts the sampling
+// probability of operations with elevated error rates or tail latencies, so that traces of an
+// incident are captured without tracing everything all the time. Install it with AdaptiveSampling.
+type AdaptiveSampler struct {
+	cfg AdaptiveSamplingConfig
+	now func() time.Time
+
+	mu  sync.Mutex
+	ops map[string]*adaptiveOpStats
+	rng *rand.Rand
+}
+
+// NewAdaptiveSampler returns an AdaptiveSampler using cfg.
+func NewAdaptiveSampler(cfg AdaptiveSamplingConfig) *AdaptiveSampler {
+	return &AdaptiveSampler{
+		cfg: cfg,
+		now: time.Now,
+		ops: make(map[string]*adaptiveOpStats),
+		rng: rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Observe records the outcome of a finished span. It returns true if the observation caused the
+// operation to become boosted.
+func (s *AdaptiveSampler) Observe(op string, d time.Duration, failed bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats, ok := s.ops[op]
+	if !ok {
+		stats = &adaptiveOpStats{fastLatency: float64(d), slowLatency: float64(d)}
+		s.ops[op] = stats
+	}
+
+	errValue := 0.0
+	if failed {
+		errValue = 1.0
+	}
+	stats.observations++
+	stats.errorRate += adaptiveFastAlpha * (errValue - stats.errorRate)
+	stats.fastLatency += adaptiveFastAlpha * (float64(d) - stats.fastLatency)
+	stats.slowLatency += adaptiveSlowAlpha * (float64(d) - stats.slowLatency)
+
+	if stats.observations < s.cfg.MinObservations {
+		return false
+	}
+
+	elevatedErrors := s.cfg.ErrorRateThreshold > 0 && stats.errorRate > s.cfg.ErrorRateThreshold
+	elevatedLatency := s.cfg.LatencyFactor > 0 && stats.fastLatency > s.cfg.LatencyFactor*stats.slowLatency
+	if !elevatedErrors && !elevatedLatency {
+		return false
+	}
+
+	wasBoosted := s.boostLocked(stats) > 0.5
+	stats.boostedAt = s.now()
+	return !wasBoosted
+}
+
+func (s *AdaptiveSampler) boostLocked(stats *adaptiveOpStats) float64 {
+	if stats.boostedAt.IsZero() {
+		return 0
+	}
+	if s.cfg.DecayHalfLife <= 0 {
+		return 1
+	}
+	elapsed := s.now().Sub(stats.boostedAt)
+	return math.Exp2(-float64(elapsed) / float64(s.cfg.DecayHalfLife))
+}
+
+// Probability returns the current probability with which root spans of op are force-sampled.
+func (s *AdaptiveSampler) Probability(op string) float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats, ok := s.ops[op]
+	if !ok {
+		return 0
+	}
+	return s.cfg.BoostRate * s.boostLocked(stats)
+}
+
+// ShouldSample decides whether a new root span of op should be force-sampled.
+func (s *AdaptiveSampler) ShouldSample(op string) bool {
+	p := s.Probability(op)
+	if p <= 0 {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rng.Float64() < p
+}
@@ -0,0 +1,84 @@
+package obs
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestAdaptiveSampler() (*AdaptiveSampler, *time.Time) {
+	now := time.Unix(1000, 0)
+	s := NewAdaptiveSampler(DefaultAdaptiveSamplingConfig)
+	s.now = func() time.Time { return now }
+	return s, &now
+}
+
+func TestAdaptiveSamplerErrors(t *testing.T) {
+	s, now := newTestAdaptiveSampler()
+
+	for i := 0; i < 100; i++ {
+		assert.False(t, s.Observe("op", time.Millisecond, false))
+	}
+	assert.Equal(t, 0.0, s.Probability("op"))
+	assert.False(t, s.ShouldSample("op"))
+
+	boosted := 0
+	for i := 0; i < 5; i++ {
+		if s.Observe("op", time.Millisecond, true) {
+			boosted++
+		}
+	}
+	assert.Equal(t, 1, boosted)
+	assert.Equal(t, 1.0, s.Probability("op"))
+	assert.True(t, s.ShouldSample("op"))
+	assert.Equal(t, 0.0, s.Probability("other_op"))
+
+	*now = now.Add(time.Minute)
+	assert.InDelta(t, 0.5, s.Probability("op"), 1e-9)
+	*now = now.Add(9 * time.Minute)
+	assert.True(t, s.Probability("op") < 0.001)
+}
+
+func TestAdaptiveSamplerLatency(t *testing.T) {
+	s, _ := newTestAdaptiveSampler()
+
+	for i := 0; i < 100; i++ {
+		s.Observe("op", time.Millisecond, false)
+	}
+	assert.Equal(t, 0.0, s.Probability("op"))
+	for i := 0; i < 10; i++ {
+		s.Observe("op", 10*time.Millisecond, false)
+	}
+	assert.Equal(t, 1.0, s.Probability("op"))
+}
+
+func TestAdaptiveSamplerMinObservations(t *testing.T) {
+	s, _ := newTestAdaptiveSampler()
+	for i := 0; i < DefaultAdaptiveSamplingConfig.MinObservations-1; i++ {
+		s.Observe("op", time.Millisecond, true)
+	}
+	assert.Equal(t, 0.0, s.Probability("op"))
+}
+
+func TestFlightRecorderAdaptiveSampling(t *testing.T) {
+	fr, _, recorder := newTestFlightRecorder()
+	s, _ := newTestAdaptiveSampler()
+	fr.(*flightRecorder).sampler = s
+
+	for i := 0; i < 50; i++ {
+		_, ctx, done := fr.WithNewSpan(context.Background(), "op")
+		markFailed(fr.WithSpan(ctx))
+		done()
+	}
+	assert.Equal(t, 1.0, s.Probability("test.op"))
+
+	recorder.Reset()
+	_, _, done := fr.WithNewSpan(context.Background(), "op")
+	done()
+	spans := recorder.GetSpans()
+	if assert.Len(t, spans, 1) {
+		assert.True(t, spans[0].Context.Sampled)
+	}
+}
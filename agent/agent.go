@@ -0,0 +1,211 @@
+// Package agent implements obs-agent, a per-host sidecar that receives the metrics and logs of the
+// processes of a host over unix domain sockets, aggregates the metrics and forwards them with a single
+// sink, so that dense hosts do not run one connection and one flush loop per process.
+//
+// Processes report metrics with the statsd sink pointed at the metrics socket, for instance with
+// metrics address unixgram:///var/run/obs-agent/metrics.sock, and write log lines to the log socket.
+package agent
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/mixpanel/obs/metrics"
+)
+
+// Config configures an Agent.
+type Config struct {
+	// MetricsSocket is the path of the unixgram socket receiving statsd lines.
+	MetricsSocket string
+	// LogSocket is the path of the unix stream socket receiving log lines. Logs are not received
+	// when it is empty.
+	LogSocket string
+	// Logs receives the log lines, each written whole. Defaults to os.Stdout.
+	Logs io.Writer
+	// Aggregation configures how metrics are aggregated before being forwarded. Defaults to
+	// metrics.DefaultAggregationOptions.
+	Aggregation metrics.AggregationOptions
+}
+
+// Agent receives metrics and logs from local processes.
+type Agent struct {
+	receiver metrics.Receiver
+	self     metrics.Receiver
+	stop     func()
+
+	metricsConn net.PacketConn
+	logListener net.Listener
+	paths       []string
+
+	logMutex sync.Mutex // serializes writes to logs
+	logs     io.Writer
+
+	connMutex sync.Mutex
+	conns     map[net.Conn]struct{}
+	closed    bool
+
+	wg sync.WaitGroup
+}
+
+// Start starts an agent forwarding aggregated metrics to dst. Counters reported by several processes
+// are summed; gauges reported by several processes should carry a tag telling them apart.
+// The agent reports its own metrics under obs_agent.
+func Start(cfg Config, dst metrics.Sink) (*Agent, error) {
+	if cfg.MetricsSocket == "" {
+		return nil, errors.New("agent: metrics socket must be specified")
+	}
+	if cfg.Logs == nil {
+		cfg.Logs = os.Stdout
+	}
+	if cfg.Aggregation.Interval <= 0 {
+		cfg.Aggregation = metrics.DefaultAggregationOptions
+	}
+
+	a := &Agent{logs: cfg.Logs, conns: make(map[net.Conn]struct{})}
+	var err error
+	// a socket left over by a previous agent would fail the listen
+	os.Remove(cfg.MetricsSocket)
+	if a.metricsConn, err = net.ListenPacket("unixgram", cfg.MetricsSocket); err != nil {
+		return nil, err
+	}
+	a.paths = append(a.paths, cfg.MetricsSocket)
+	if cfg.LogSocket != "" {
+		os.Remove(cfg.LogSocket)
+		if a.logListener, err = net.Listen("unix", cfg.LogSocket); err != nil {
+			a.metricsConn.Close()
+			return nil, err
+		}
+		a.paths = append(a.paths, cfg.LogSocket)
+	}
+
+	a.receiver, a.stop = metrics.NewAggregatingReceiver(dst, cfg.Aggregation)
+	a.self = a.receiver.ScopePrefix("obs_agent")
+
+	a.wg.Add(1)
+	go a.receiveMetrics()
+	if a.logListener != nil {
+		a.wg.Add(1)
+		go a.acceptLogs()
+	}
+	return a, nil
+}
+
+func (a *Agent) receiveMetrics() {
+	defer a.wg.Done()
+	buf := make([]byte, 65536)
+	for {
+		n, _, err := a.metricsConn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		for _, line := range strings.Split(string(buf[:n]), "\n") {
+			if line != "" {
+				a.handleMetric(line)
+			}
+		}
+	}
+}
+
+func (a *Agent) handleMetric(line string) {
+	m, err := metrics.ParseStatsdLine(line)
+	if err != nil {
+		a.self.Incr("malformed_metrics")
+		return
+	}
+	r := a.receiver
+	if len(m.Tags) > 0 {
+		r = r.ScopeTags(m.Tags)
+	}
+	switch m.Type {
+	case "ct", "c":
+		r.IncrBy(m.Name, m.Value)
+	case "h", "ms":
+		r.AddStat(m.Name, m.Value)
+	case "g":
+		r.SetGauge(m.Name, m.Value)
+	default:
+		a.self.Incr("malformed_metrics")
+		return
+	}
+	a.self.Incr("received_metrics")
+}
+
+func (a *Agent) acceptLogs() {
+	defer a.wg.Done()
+	for {
+		conn, err := a.logListener.Accept()
+		if err != nil {
+			return
+		}
+		a.connMutex.Lock()
+		if a.closed {
+			a.connMutex.Unlock()
+			conn.Close()
+			return
+		}
+		a.conns[conn] = struct{}{}
+		a.connMutex.Unlock()
+
+		a.wg.Add(1)
+		go a.receiveLogs(conn)
+	}
+}
+
+func (a *Agent) receiveLogs(conn net.Conn) {
+	defer a.wg.Done()
+	defer func() {
+		a.connMutex.Lock()
+		delete(a.conns, conn)
+		a.connMutex.Unlock()
+		conn.Close()
+	}()
+
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			if line[len(line)-1] != '\n' {
+				line = append(line, '\n')
+			}
+			a.logMutex.Lock()
+			if _, werr := a.logs.Write(line); werr != nil {
+				log.Printf("error while writing logs: %v", werr)
+			}
+			a.logMutex.Unlock()
+			a.self.Incr("received_logs")
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Close stops receiving, forwards the pending aggregates and removes the sockets. It does not close
+// the destination sink.
+func (a *Agent) Close() error {
+	a.connMutex.Lock()
+	a.closed = true
+	for conn := range a.conns {
+		conn.Close()
+	}
+	a.connMutex.Unlock()
+
+	err := a.metricsConn.Close()
+	if a.logListener != nil {
+		if lerr := a.logListener.Close(); err == nil {
+			err = lerr
+		}
+	}
+	a.wg.Wait()
+	a.stop()
+	for _, path := range a.paths {
+		os.Remove(path)
+	}
+	return err
+}
@@ -0,0 +1,69 @@
+package agent
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mixpanel/obs/metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+type syncBuffer struct {
+	mutex sync.Mutex
+	bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.Buffer.Write(p)
+}
+
+func TestAgent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "agent")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	dst := metrics.NewMockSink()
+	logs := &syncBuffer{}
+	a, err := Start(Config{
+		MetricsSocket: filepath.Join(dir, "metrics.sock"),
+		LogSocket:     filepath.Join(dir, "logs.sock"),
+		Logs:          logs,
+		Aggregation:   metrics.AggregationOptions{Interval: time.Hour},
+	}, dst)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// two processes reporting the same counter
+	for i := 0; i < 2; i++ {
+		sink, err := metrics.NewStatsdSink("unixgram://" + filepath.Join(dir, "metrics.sock"))
+		if !assert.NoError(t, err) {
+			return
+		}
+		metrics.NewReceiver(sink).ScopeTags(metrics.Tags{"code": "200"}).IncrBy("requests", 2)
+		sink.Close()
+	}
+
+	conn, err := net.Dial("unix", filepath.Join(dir, "logs.sock"))
+	assert.NoError(t, err)
+	conn.Write([]byte("{\"message\":\"first\"}\n{\"message\":\"second\"}"))
+	conn.Close()
+
+	// datagrams and connections are handled asynchronously
+	time.Sleep(100 * time.Millisecond)
+	assert.NoError(t, a.Close())
+
+	assert.Equal(t, 1, dst.Invocations["requests, map[code:200], 4, ct\n"])
+	assert.Equal(t, 1, dst.Invocations["obs_agent.received_metrics, map[], 2, ct\n"])
+	assert.Equal(t, "{\"message\":\"first\"}\n{\"message\":\"second\"}\n", logs.String())
+	_, err = os.Stat(filepath.Join(dir, "metrics.sock"))
+	assert.True(t, os.IsNotExist(err))
+}
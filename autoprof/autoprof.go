@@ -0,0 +1,181 @@
+// Package autoprof captures heap and goroutine profiles when a process crosses memory or goroutine
+// thresholds, so that the state leading to an incident can be inspected after the fact.
+package autoprof
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"sync"
+	"time"
+
+	"github.com/mixpanel/obs"
+)
+
+// Config configures the thresholds watched by Start and where profiles are stored.
+type Config struct {
+	// RSSThreshold is the resident set size, in bytes, above which profiles are captured. Zero disables it.
+	RSSThreshold uint64
+	// GoroutineThreshold is the number of goroutines above which profiles are captured. Zero disables it.
+	GoroutineThreshold int
+
+	// Interval between two checks of the thresholds. Defaults to 10 seconds.
+	Interval time.Duration
+	// Cooldown is the minimum time between two captures. Defaults to 10 minutes.
+	Cooldown time.Duration
+
+	// Dir is the directory profiles are written to. Profiles are not written to disk when it is empty.
+	Dir string
+	// Uploader, when set, receives a copy of every profile.
+	Uploader Uploader
+}
+
+// Uploader stores captured profiles remotely.
+type Uploader interface {
+	Upload(ctx context.Context, name string, data []byte) error
+}
+
+// profiles are the runtime profiles captured when a threshold is crossed.
+var profiles = []string{"heap", "goroutine"}
+
+type watcher struct {
+	fr  obs.FlightRecorder
+	cfg Config
+
+	rss        func() (uint64, error)
+	goroutines func() int
+	now        func() time.Time
+
+	lastCapture time.Time
+	// armed is false while a threshold stays crossed after a capture, so that a process staying above
+	// a threshold is only profiled once.
+	armed bool
+
+	done     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// Start checks the thresholds of cfg every interval, and captures profiles when one of them is crossed.
+// Captures are reported through fr. The returned function stops watching.
+func Start(fr obs.FlightRecorder, cfg Config) func() {
+	w := newWatcher(fr, cfg)
+	w.wg.Add(1)
+	go w.loop()
+	return w.stop
+}
+
+func newWatcher(fr obs.FlightRecorder, cfg Config) *watcher {
+	if cfg.Interval <= 0 {
+		cfg.Interval = 10 * time.Second
+	}
+	if cfg.Cooldown <= 0 {
+		cfg.Cooldown = 10 * time.Minute
+	}
+	return &watcher{
+		fr:         fr.ScopeName("autoprof"),
+		cfg:        cfg,
+		rss:        residentSetSize,
+		goroutines: runtime.NumGoroutine,
+		now:        time.Now,
+		armed:      true,
+		done:       make(chan struct{}),
+	}
+}
+
+func (w *watcher) stop() {
+	w.stopOnce.Do(func() { close(w.done) })
+	w.wg.Wait()
+}
+
+func (w *watcher) loop() {
+	defer w.wg.Done()
+	ticker := time.NewTicker(w.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+			w.check()
+		}
+	}
+}
+
+// check captures profiles if a threshold is crossed, and returns the reason it did.
+func (w *watcher) check() string {
+	fs := w.fr.WithSpan(context.Background())
+
+	reason := ""
+	vals := obs.Vals{}
+	if w.cfg.RSSThreshold > 0 {
+		rss, err := w.rss()
+		if err != nil {
+			fs.Warn("rss", "unable to read resident set size", obs.Vals{}.WithError(err))
+		} else if rss > w.cfg.RSSThreshold {
+			reason = "rss"
+			vals["rss_bytes"] = rss
+			vals["rss_threshold_bytes"] = w.cfg.RSSThreshold
+		}
+	}
+	if w.cfg.GoroutineThreshold > 0 {
+		if n := w.goroutines(); n > w.cfg.GoroutineThreshold {
+			if reason == "" {
+				reason = "goroutines"
+			}
+			vals["goroutines"] = n
+			vals["goroutine_threshold"] = w.cfg.GoroutineThreshold
+		}
+	}
+
+	if reason == "" {
+		w.armed = true
+		return ""
+	}
+	now := w.now()
+	if !w.armed || now.Sub(w.lastCapture) < w.cfg.Cooldown {
+		return ""
+	}
+	w.armed = false
+	w.lastCapture = now
+
+	vals["reason"] = reason
+	vals["profiles"] = w.capture(fs, reason, now)
+	fs.Warn("capture", fmt.Sprintf("%s threshold crossed, captured profiles", reason), vals)
+	return reason
+}
+
+// capture writes and uploads the profiles, and returns their names.
+func (w *watcher) capture(fs obs.FlightSpan, reason string, now time.Time) []string {
+	var names []string
+	for _, profile := range profiles {
+		var buf bytes.Buffer
+		if err := pprof.Lookup(profile).WriteTo(&buf, 0); err != nil {
+			fs.Warn("profile", "unable to capture "+profile+" profile", obs.Vals{}.WithError(err))
+			continue
+		}
+
+		name := fmt.Sprintf("%s-%s-%s.pb.gz", profile, reason, now.UTC().Format("20060102T150405Z"))
+		names = append(names, name)
+		if w.cfg.Dir != "" {
+			if err := os.MkdirAll(w.cfg.Dir, 0755); err != nil {
+				fs.Warn("write", "unable to create profile directory", obs.Vals{"dir": w.cfg.Dir}.WithError(err))
+			} else if err := ioutil.WriteFile(filepath.Join(w.cfg.Dir, name), buf.Bytes(), 0644); err != nil {
+				fs.Warn("write", "unable to write profile", obs.Vals{"profile": name}.WithError(err))
+			}
+		}
+		if w.cfg.Uploader != nil {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			if err := w.cfg.Uploader.Upload(ctx, name, buf.Bytes()); err != nil {
+				fs.Warn("upload", "unable to upload profile", obs.Vals{"profile": name}.WithError(err))
+			}
+			cancel()
+		}
+	}
+	return names
+}
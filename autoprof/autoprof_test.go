@@ -0,0 +1,67 @@
+package autoprof
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mixpanel/obs"
+	"github.com/stretchr/testify/assert"
+)
+
+type testUploader struct {
+	mutex sync.Mutex
+	names []string
+}
+
+func (u *testUploader) Upload(ctx context.Context, name string, data []byte) error {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	u.names = append(u.names, name)
+	return nil
+}
+
+func TestCapture(t *testing.T) {
+	dir, err := ioutil.TempDir("", "autoprof")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	uploader := &testUploader{}
+
+	w := newWatcher(obs.NullFR, Config{
+		RSSThreshold:       1000,
+		GoroutineThreshold: 100,
+		Cooldown:           time.Minute,
+		Dir:                dir,
+		Uploader:           uploader,
+	})
+	rss, goroutines, now := uint64(500), 10, time.Now()
+	w.rss = func() (uint64, error) { return rss, nil }
+	w.goroutines = func() int { return goroutines }
+	w.now = func() time.Time { return now }
+
+	assert.Equal(t, "", w.check())
+
+	rss = 2000
+	assert.Equal(t, "rss", w.check())
+	files, err := ioutil.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, files, 2)
+	assert.Len(t, uploader.names, 2)
+
+	// Still above the threshold: not captured again until it goes back below.
+	now = now.Add(time.Hour)
+	assert.Equal(t, "", w.check())
+	rss = 500
+	assert.Equal(t, "", w.check())
+
+	// Within the cooldown.
+	goroutines = 200
+	assert.Equal(t, "goroutines", w.check())
+	goroutines = 10
+	w.check()
+	goroutines = 200
+	assert.Equal(t, "", w.check())
+}
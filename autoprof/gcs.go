@@ -0,0 +1,38 @@
+package autoprof
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path"
+
+	"golang.org/x/oauth2/google"
+
+	storage "google.golang.org/api/storage/v1"
+)
+
+type gcsUploader struct {
+	objects *storage.ObjectsService
+	bucket  string
+	prefix  string
+}
+
+// NewGCSUploader returns an Uploader storing profiles in a Google Cloud Storage bucket, under prefix,
+// authenticated with the application default credentials.
+func NewGCSUploader(ctx context.Context, bucket, prefix string) (Uploader, error) {
+	client, err := google.DefaultClient(ctx, storage.DevstorageReadWriteScope)
+	if err != nil {
+		return nil, fmt.Errorf("error initializing google.DefaultClient: %v", err)
+	}
+	svc, err := storage.New(client)
+	if err != nil {
+		return nil, fmt.Errorf("error initializing storage Service: %v", err)
+	}
+	return &gcsUploader{objects: storage.NewObjectsService(svc), bucket: bucket, prefix: prefix}, nil
+}
+
+func (u *gcsUploader) Upload(ctx context.Context, name string, data []byte) error {
+	object := &storage.Object{Name: path.Join(u.prefix, name), ContentType: "application/octet-stream"}
+	_, err := u.objects.Insert(u.bucket, object).Media(bytes.NewReader(data)).Context(ctx).Do()
+	return err
+}
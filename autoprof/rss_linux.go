@@ -0,0 +1,20 @@
+package autoprof
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// residentSetSize returns the resident set size of the process, read from /proc.
+func residentSetSize() (uint64, error) {
+	statm, err := ioutil.ReadFile("/proc/self/statm")
+	if err != nil {
+		return 0, err
+	}
+	var size, resident uint64
+	if _, err := fmt.Sscan(string(statm), &size, &resident); err != nil {
+		return 0, fmt.Errorf("unable to parse /proc/self/statm: %v", err)
+	}
+	return resident * uint64(os.Getpagesize()), nil
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package autoprof
+
+import "runtime"
+
+// residentSetSize approximates the resident set size of the process with the memory obtained from the
+// OS by the Go runtime.
+func residentSetSize() (uint64, error) {
+	var memstats runtime.MemStats
+	runtime.ReadMemStats(&memstats)
+	return memstats.Sys, nil
+}
@@ -0,0 +1,99 @@
+package obs
+
+import (
+	"errors"
+
+	"github.com/mixpanel/obs/metrics"
+)
+
+// BaggagePolicy bounds the baggage set with FlightSpan.SetBaggage, which is sent along with every
+// request made in the trace, so that it cannot bloat the headers of downstream calls.
+type BaggagePolicy struct {
+	// MaxBytes bounds the total size of the keys and values of the baggage of a span, including the
+	// baggage it inherited. 0 means no limit.
+	MaxBytes int
+	// AllowedKeys are the keys that can be set. All keys can be set if it is empty.
+	AllowedKeys []string
+}
+
+// DefaultBaggagePolicy allows any key, within 1 KiB.
+var DefaultBaggagePolicy = BaggagePolicy{MaxBytes: 1024}
+
+var (
+	// ErrBaggageKeyNotAllowed is returned by SetBaggage for keys outside of BaggagePolicy.AllowedKeys.
+	ErrBaggageKeyNotAllowed = errors.New("obs: baggage key not allowed")
+	// ErrBaggageTooLarge is returned by SetBaggage when the baggage would exceed
+	// BaggagePolicy.MaxBytes.
+	ErrBaggageTooLarge = errors.New("obs: baggage too large")
+)
+
+// WithBaggagePolicy replaces DefaultBaggagePolicy.
+func WithBaggagePolicy(policy BaggagePolicy) Option {
+	return func(o *obsOptions) {
+		o.baggage = newBaggagePolicy(policy)
+	}
+}
+
+// baggagePolicy is a BaggagePolicy ready for lookups.
+type baggagePolicy struct {
+	maxBytes int
+	allowed  map[string]struct{} // nil if all keys are allowed
+}
+
+func newBaggagePolicy(policy BaggagePolicy) *baggagePolicy {
+	p := &baggagePolicy{maxBytes: policy.MaxBytes}
+	if len(policy.AllowedKeys) > 0 {
+		p.allowed = make(map[string]struct{}, len(policy.AllowedKeys))
+		for _, k := range policy.AllowedKeys {
+			p.allowed[k] = struct{}{}
+		}
+	}
+	return p
+}
+
+func (fs *flightSpan) SetBaggage(key, value string) error {
+	if fs.span == nil {
+		return nil
+	}
+	if err := fs.checkBaggage(key, value); err != nil {
+		reason := "too_large"
+		if err == ErrBaggageKeyNotAllowed {
+			reason = "not_allowed"
+		}
+		fs.receiver().ScopeTags(metrics.Tags{"reason": reason}).Incr("baggage.rejected")
+		return err
+	}
+	fs.span.SetBaggageItem(key, value)
+	return nil
+}
+
+func (fs *flightSpan) checkBaggage(key, value string) error {
+	p := fs.baggage
+	if p == nil {
+		return nil
+	}
+	if _, ok := p.allowed[key]; p.allowed != nil && !ok {
+		return ErrBaggageKeyNotAllowed
+	}
+	if p.maxBytes <= 0 {
+		return nil
+	}
+	size := len(key) + len(value)
+	fs.span.Context().ForeachBaggageItem(func(k, v string) bool {
+		if k != key {
+			size += len(k) + len(v)
+		}
+		return true
+	})
+	if size > p.maxBytes {
+		return ErrBaggageTooLarge
+	}
+	return nil
+}
+
+func (fs *flightSpan) Baggage(key string) string {
+	if fs.span == nil {
+		return ""
+	}
+	return fs.span.BaggageItem(key)
+}
@@ -0,0 +1,33 @@
+package obs
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/mixpanel/obs/logging"
+	"github.com/mixpanel/obs/metrics"
+	basictracer "github.com/opentracing/basictracer-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBaggage(t *testing.T) {
+	sink := metrics.NewMockSink()
+	fr := NewFlightRecorder("test", metrics.NewReceiver(sink), logging.Null, basictracer.New(basictracer.NewInMemoryRecorder())).(*flightRecorder)
+	fr.baggage = newBaggagePolicy(BaggagePolicy{MaxBytes: 32, AllowedKeys: []string{"tenant_id", "source"}})
+
+	fs, ctx, done := fr.WithNewSpan(context.Background(), "parent")
+	defer done()
+	assert.NoError(t, fs.SetBaggage("tenant_id", "42"))
+	assert.Equal(t, ErrBaggageKeyNotAllowed, fs.SetBaggage("user_id", "7"))
+	assert.Equal(t, ErrBaggageTooLarge, fs.SetBaggage("source", strings.Repeat("x", 20)))
+	assert.NoError(t, fs.SetBaggage("tenant_id", strings.Repeat("x", 20)), "replaced items do not count")
+	assert.Equal(t, 1, sink.Invocations["baggage.rejected, map[reason:not_allowed], 1, ct\n"])
+	assert.Equal(t, 1, sink.Invocations["baggage.rejected, map[reason:too_large], 1, ct\n"])
+
+	child, _, childDone := fr.WithNewSpan(ctx, "child")
+	defer childDone()
+	assert.Equal(t, strings.Repeat("x", 20), child.Baggage("tenant_id"))
+	assert.Equal(t, "", child.Baggage("user_id"))
+	assert.Equal(t, "", NullFlightRecorder.WithSpan(context.Background()).Baggage("tenant_id"))
+}
@@ -0,0 +1,122 @@
+package bigquery
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"time"
+
+	"github.com/mixpanel/obs/mixpanel"
+)
+
+// TimestampFormat is the format of TIMESTAMP columns written by the event client.
+const TimestampFormat = "2006-01-02 15:04:05.999999 UTC"
+
+// Schema maps events to the columns of a table.
+type Schema struct {
+	// EventColumn, TimeColumn and DistinctIDColumn hold the name, time and distinct ID of events.
+	// Empty names leave the corresponding value out.
+	EventColumn      string
+	TimeColumn       string
+	DistinctIDColumn string
+	// Columns maps event properties to the column holding them. Properties without a column are
+	// stored in PropertiesColumn, or dropped when it is empty.
+	Columns map[string]string
+	// PropertiesColumn holds the remaining properties of events, encoded as a JSON object.
+	PropertiesColumn string
+}
+
+// DefaultSchema stores events into the event, time, distinct_id and properties columns.
+var DefaultSchema = Schema{
+	EventColumn:      "event",
+	TimeColumn:       "time",
+	DistinctIDColumn: "distinct_id",
+	PropertiesColumn: "properties",
+}
+
+// Row returns the row holding e.
+func (schema Schema) Row(e *mixpanel.TrackedEvent) (Row, error) {
+	row := make(Row, len(schema.Columns)+4)
+	if schema.EventColumn != "" {
+		row[schema.EventColumn] = e.EventName
+	}
+	if schema.TimeColumn != "" {
+		row[schema.TimeColumn] = e.Time.UTC().Format(TimestampFormat)
+	}
+	if schema.DistinctIDColumn != "" && e.DistinctID != "" {
+		row[schema.DistinctIDColumn] = e.DistinctID
+	}
+
+	var rest map[string]interface{}
+	for k, v := range e.Properties {
+		if column, ok := schema.Columns[k]; ok {
+			row[column] = v
+		} else if schema.PropertiesColumn != "" {
+			if rest == nil {
+				rest = make(map[string]interface{}, len(e.Properties))
+			}
+			rest[k] = v
+		}
+	}
+	if rest != nil {
+		encoded, err := json.Marshal(rest)
+		if err != nil {
+			return nil, err
+		}
+		row[schema.PropertiesColumn] = string(encoded)
+	}
+	return row, nil
+}
+
+type eventClient struct {
+	sink   *Sink
+	schema Schema
+}
+
+// NewEventClient returns a mixpanel.Client that stores events into the table of sink according to
+// schema, so that event producers such as topk trackers can report to BigQuery. Events are inserted
+// in the background; errors returned by the client only concern validation and buffering.
+// UrlWithTracking is not supported.
+func NewEventClient(sink *Sink, schema Schema) mixpanel.Client {
+	return &eventClient{sink: sink, schema: schema}
+}
+
+func (c *eventClient) Track(e *mixpanel.TrackedEvent) error {
+	return c.TrackBatched([]*mixpanel.TrackedEvent{e})
+}
+
+func (c *eventClient) TrackBatched(es []*mixpanel.TrackedEvent) error {
+	rows := make([]Row, 0, len(es))
+	for _, e := range es {
+		if err := mixpanel.ValidateEvent(e); err != nil {
+			return err
+		}
+		if e.Time.IsZero() {
+			e.Time = time.Now()
+		}
+		row, err := c.schema.Row(e)
+		if err != nil {
+			return err
+		}
+		rows = append(rows, row)
+	}
+	return c.sink.Insert(rows...)
+}
+
+func (c *eventClient) Import(es []*mixpanel.TrackedEvent) error {
+	return c.TrackBatched(es)
+}
+
+// ImportBatched stamps the events without a Time with ts, and writes them like TrackBatched.
+func (c *eventClient) ImportBatched(es []*mixpanel.TrackedEvent, ts time.Time) error {
+	for _, e := range es {
+		if e != nil && e.Time.IsZero() {
+			e.Time = ts
+		}
+	}
+	return c.TrackBatched(es)
+}
+
+func (c *eventClient) UrlWithTracking(*mixpanel.TrackedEvent, string) (*url.URL, error) {
+	return nil, errors.New("bigquery: UrlWithTracking is not supported")
+}
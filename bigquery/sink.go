@@ -0,0 +1,253 @@
+// Package bigquery streams rows into a BigQuery table, for services whose analytics warehouse is
+// BigQuery. Rows are buffered, inserted in batches with the streaming insert API, and retried when
+// BigQuery reports quota or transient errors.
+package bigquery
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2/google"
+
+	bq "google.golang.org/api/bigquery/v2"
+	"google.golang.org/api/googleapi"
+)
+
+// Row is a row of a table, keyed by column name.
+type Row map[string]interface{}
+
+// Config configures a Sink.
+type Config struct {
+	Project string
+	Dataset string
+	Table   string
+
+	// BatchSize is the maximum number of rows per insert request. Defaults to 500, as recommended by BigQuery.
+	BatchSize int
+	// FlushInterval is the maximum time a row stays buffered. Defaults to 5 seconds.
+	FlushInterval time.Duration
+	// MaxBufferedRows bounds the rows waiting to be inserted, beyond which Insert fails with
+	// ErrBufferFull. Defaults to 20 batches.
+	MaxBufferedRows int
+	// MaxRetries is the number of times an insert failing with a quota or transient error is retried.
+	// Defaults to 5, negative values disable retries.
+	MaxRetries int
+	// RetryBackoff is the wait before the first retry, doubled for every subsequent one. Defaults to 1 second.
+	RetryBackoff time.Duration
+}
+
+// ErrBufferFull is returned by Insert when the sink cannot keep up with inserted rows.
+var ErrBufferFull = errors.New("bigquery: too many buffered rows")
+
+// Sink inserts rows into a BigQuery table in the background.
+type Sink struct {
+	cfg  Config
+	call func(*bq.TableDataInsertAllRequest) (*bq.TableDataInsertAllResponse, error)
+
+	mutex sync.Mutex
+	rows  []*bq.TableDataInsertAllRequestRows
+
+	// serializes inserts, so that Flush returns after the rows buffered before it are inserted
+	flushMutex sync.Mutex
+	kick       chan struct{}
+	done       chan struct{}
+	wg         sync.WaitGroup
+}
+
+// NewSink returns a Sink inserting into the table described by cfg, authenticated with the
+// application default credentials.
+func NewSink(ctx context.Context, cfg Config) (*Sink, error) {
+	client, err := google.DefaultClient(ctx, bq.BigqueryInsertdataScope)
+	if err != nil {
+		return nil, fmt.Errorf("error initializing google.DefaultClient: %v", err)
+	}
+	return newSink(client, "", cfg)
+}
+
+// newSink returns a Sink sending requests with client, to basePath when it is not empty.
+func newSink(client *http.Client, basePath string, cfg Config) (*Sink, error) {
+	if cfg.Project == "" || cfg.Dataset == "" || cfg.Table == "" {
+		return nil, errors.New("bigquery: project, dataset and table must be specified")
+	}
+	svc, err := bq.New(client)
+	if err != nil {
+		return nil, fmt.Errorf("error initializing bigquery Service: %v", err)
+	}
+	if basePath != "" {
+		svc.BasePath = basePath
+	}
+	tabledata := bq.NewTabledataService(svc)
+
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 500
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = 5 * time.Second
+	}
+	if cfg.MaxBufferedRows <= 0 {
+		cfg.MaxBufferedRows = 20 * cfg.BatchSize
+	}
+	if cfg.MaxRetries < 0 {
+		cfg.MaxRetries = 0
+	} else if cfg.MaxRetries == 0 {
+		cfg.MaxRetries = 5
+	}
+	if cfg.RetryBackoff <= 0 {
+		cfg.RetryBackoff = time.Second
+	}
+
+	s := &Sink{
+		cfg: cfg,
+		call: func(req *bq.TableDataInsertAllRequest) (*bq.TableDataInsertAllResponse, error) {
+			return tabledata.InsertAll(cfg.Project, cfg.Dataset, cfg.Table, req).Do()
+		},
+		kick: make(chan struct{}, 1),
+		done: make(chan struct{}),
+	}
+	s.wg.Add(1)
+	go s.flushLoop()
+	return s, nil
+}
+
+// Insert buffers rows for insertion. Every row gets a unique insert ID, so that BigQuery can
+// deduplicate rows inserted again by a retry.
+func (s *Sink) Insert(rows ...Row) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if len(s.rows)+len(rows) > s.cfg.MaxBufferedRows {
+		return ErrBufferFull
+	}
+	for _, row := range rows {
+		json := make(map[string]bq.JsonValue, len(row))
+		for k, v := range row {
+			json[k] = v
+		}
+		s.rows = append(s.rows, &bq.TableDataInsertAllRequestRows{InsertId: insertID(), Json: json})
+	}
+	if len(s.rows) >= s.cfg.BatchSize {
+		select {
+		case s.kick <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// Flush inserts all buffered rows, and returns the first error encountered.
+func (s *Sink) Flush() error {
+	s.flushMutex.Lock()
+	defer s.flushMutex.Unlock()
+
+	s.mutex.Lock()
+	rows := s.rows
+	s.rows = nil
+	s.mutex.Unlock()
+
+	var firstErr error
+	for len(rows) > 0 {
+		n := len(rows)
+		if n > s.cfg.BatchSize {
+			n = s.cfg.BatchSize
+		}
+		if err := s.insert(rows[:n]); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		rows = rows[n:]
+	}
+	return firstErr
+}
+
+// Close inserts the buffered rows and stops the sink.
+func (s *Sink) Close() {
+	close(s.done)
+	s.wg.Wait()
+}
+
+func (s *Sink) flushLoop() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(s.cfg.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			if err := s.Flush(); err != nil {
+				log.Printf("error while inserting rows into bigquery: %v", err)
+			}
+			return
+		case <-ticker.C:
+		case <-s.kick:
+		}
+		if err := s.Flush(); err != nil {
+			log.Printf("error while inserting rows into bigquery: %v", err)
+		}
+	}
+}
+
+// insert inserts a batch of rows, retrying with exponential backoff on quota and transient errors.
+func (s *Sink) insert(rows []*bq.TableDataInsertAllRequestRows) error {
+	req := &bq.TableDataInsertAllRequest{Rows: rows}
+	backoff := s.cfg.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		resp, err := s.call(req)
+		if err == nil {
+			return insertErrors(resp)
+		}
+		if attempt >= s.cfg.MaxRetries || !retryable(err) {
+			return fmt.Errorf("error inserting %d rows into %s.%s: %v", len(rows), s.cfg.Dataset, s.cfg.Table, err)
+		}
+		select {
+		case <-time.After(backoff):
+		case <-s.done:
+			// Closing: make the remaining attempts without waiting.
+		}
+		backoff *= 2
+	}
+}
+
+// insertErrors returns an error describing the rows BigQuery rejected, if any.
+func insertErrors(resp *bq.TableDataInsertAllResponse) error {
+	if resp == nil || len(resp.InsertErrors) == 0 {
+		return nil
+	}
+	first := resp.InsertErrors[0]
+	message := "unknown error"
+	if len(first.Errors) > 0 {
+		message = first.Errors[0].Reason + ": " + first.Errors[0].Message
+	}
+	return fmt.Errorf("bigquery rejected %d rows, row %d: %s", len(resp.InsertErrors), first.Index, message)
+}
+
+// retryable reports whether err is a quota or transient error, after which an insert may succeed.
+func retryable(err error) bool {
+	apiErr, ok := err.(*googleapi.Error)
+	if !ok {
+		// Network errors.
+		return true
+	}
+	switch apiErr.Code {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	case http.StatusForbidden:
+		for _, e := range apiErr.Errors {
+			if e.Reason == "quotaExceeded" || e.Reason == "rateLimitExceeded" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func insertID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
@@ -0,0 +1,134 @@
+package bigquery
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mixpanel/obs/mixpanel"
+	"github.com/stretchr/testify/assert"
+	bq "google.golang.org/api/bigquery/v2"
+)
+
+type testServer struct {
+	*httptest.Server
+
+	mutex    sync.Mutex
+	requests []*bq.TableDataInsertAllRequest
+	failures int
+}
+
+func newTestServer(failures int) *testServer {
+	ts := &testServer{failures: failures}
+	ts.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ts.mutex.Lock()
+		defer ts.mutex.Unlock()
+
+		if r.URL.Path != "/projects/project/datasets/dataset/tables/table/insertAll" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if ts.failures > 0 {
+			ts.failures--
+			w.WriteHeader(http.StatusForbidden)
+			io.WriteString(w, `{"error": {"code": 403, "message": "quota", "errors": [{"reason": "quotaExceeded"}]}}`)
+			return
+		}
+		var req bq.TableDataInsertAllRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		ts.requests = append(ts.requests, &req)
+		io.WriteString(w, `{}`)
+	}))
+	return ts
+}
+
+func newTestSink(t *testing.T, ts *testServer, cfg Config) *Sink {
+	cfg.Project, cfg.Dataset, cfg.Table = "project", "dataset", "table"
+	if cfg.FlushInterval == 0 {
+		cfg.FlushInterval = time.Hour
+	}
+	if cfg.RetryBackoff == 0 {
+		cfg.RetryBackoff = time.Millisecond
+	}
+	sink, err := newSink(ts.Client(), ts.URL+"/", cfg)
+	assert.NoError(t, err)
+	return sink
+}
+
+func TestSinkBatches(t *testing.T) {
+	ts := newTestServer(0)
+	defer ts.Close()
+	sink := newTestSink(t, ts, Config{BatchSize: 2})
+
+	assert.NoError(t, sink.Insert(Row{"a": 1}, Row{"a": 2}, Row{"a": 3}))
+	assert.NoError(t, sink.Flush())
+	sink.Close()
+
+	if assert.Len(t, ts.requests, 2) {
+		assert.Len(t, ts.requests[0].Rows, 2)
+		assert.Len(t, ts.requests[1].Rows, 1)
+		assert.Equal(t, float64(3), ts.requests[1].Rows[0].Json["a"])
+		assert.NotEqual(t, ts.requests[0].Rows[0].InsertId, ts.requests[0].Rows[1].InsertId)
+	}
+}
+
+func TestSinkRetriesQuotaErrors(t *testing.T) {
+	ts := newTestServer(2)
+	defer ts.Close()
+	sink := newTestSink(t, ts, Config{})
+	defer sink.Close()
+
+	assert.NoError(t, sink.Insert(Row{"a": 1}))
+	assert.NoError(t, sink.Flush())
+	assert.Len(t, ts.requests, 1)
+
+	ts.mutex.Lock()
+	ts.failures = 2
+	ts.mutex.Unlock()
+	noRetries := newTestSink(t, ts, Config{MaxRetries: -1})
+	defer noRetries.Close()
+	assert.NoError(t, noRetries.Insert(Row{"a": 1}))
+	assert.Error(t, noRetries.Flush())
+}
+
+func TestSinkBufferFull(t *testing.T) {
+	ts := newTestServer(0)
+	defer ts.Close()
+	sink := newTestSink(t, ts, Config{BatchSize: 10, MaxBufferedRows: 2})
+	defer sink.Close()
+
+	assert.NoError(t, sink.Insert(Row{"a": 1}, Row{"a": 2}))
+	assert.Equal(t, ErrBufferFull, sink.Insert(Row{"a": 3}))
+}
+
+func TestEventClient(t *testing.T) {
+	ts := newTestServer(0)
+	defer ts.Close()
+	sink := newTestSink(t, ts, Config{})
+
+	schema := DefaultSchema
+	schema.Columns = map[string]string{"project_id": "project_id"}
+	client := NewEventClient(sink, schema)
+	assert.NoError(t, client.TrackBatched([]*mixpanel.TrackedEvent{{
+		EventName:  "query",
+		DistinctID: "42",
+		Time:       time.Date(2019, 10, 1, 12, 0, 0, 0, time.UTC),
+		Properties: map[string]interface{}{"project_id": 42, "count": 3},
+	}}))
+	assert.Error(t, client.Track(&mixpanel.TrackedEvent{}))
+	sink.Close()
+
+	if assert.Len(t, ts.requests, 1) && assert.Len(t, ts.requests[0].Rows, 1) {
+		assert.Equal(t, map[string]bq.JsonValue{
+			"event":       "query",
+			"time":        "2019-10-01 12:00:00 UTC",
+			"distinct_id": "42",
+			"project_id":  float64(42),
+			"properties":  `{"count":3}`,
+		}, ts.requests[0].Rows[0].Json)
+	}
+}
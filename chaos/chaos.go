@@ -0,0 +1,114 @@
+// Package chaos injects latency, errors and panics into the requests a service serves, so that teams
+// can verify that their dashboards and alerts fire when the service misbehaves. Faults are only
+// injected when the environment variable EnvEnable is true, so that the interceptors can be left in
+// place in production, and every fault injected is recorded as a tag of the span of the request:
+//
+//	chaos.latency_ms  the latency injected
+//	chaos.error       true if the request was failed
+//	chaos.panic       true if the request panicked
+//
+// Unlike package faultinject, which it builds on to make the telemetry pipeline misbehave, chaos makes
+// the service itself misbehave.
+package chaos
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/mixpanel/obs/faultinject"
+	opentracing "github.com/opentracing/opentracing-go"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// EnvEnable enables the injection of faults when it is true, as parsed by strconv.ParseBool.
+const EnvEnable = "OBS_CHAOS"
+
+// ErrInjected is returned by Inject for the requests it decided to fail. The interceptors fail them with
+// codes.Unavailable, and the middleware with 503 Service Unavailable.
+var ErrInjected = faultinject.ErrInjected
+
+// Config describes the faults to inject, with rates as fractions of requests. Panics are left to the
+// recovery of the server, if any.
+type Config = faultinject.Config
+
+// Injector decides which requests are delayed, failed or panic. A nil *Injector never injects
+// anything.
+type Injector struct {
+	inj *faultinject.Injector
+}
+
+// New returns an Injector for cfg, which injects faults only if EnvEnable is true.
+func New(cfg Config) *Injector {
+	return newInjector(cfg, os.Getenv)
+}
+
+func newInjector(cfg Config, getenv func(string) string) *Injector {
+	i := &Injector{}
+	if enabled, _ := strconv.ParseBool(getenv(EnvEnable)); enabled {
+		i.inj = faultinject.New(cfg)
+	}
+	return i
+}
+
+// Enabled reports whether i injects faults.
+func (i *Injector) Enabled() bool {
+	return i != nil && i.inj != nil
+}
+
+// Inject delays the caller, panics or returns ErrInjected according to the configured rates, and tags
+// the span of ctx with the faults injected. Delays end early when ctx is done.
+func (i *Injector) Inject(ctx context.Context) error {
+	if !i.Enabled() {
+		return nil
+	}
+	faults := i.inj.Decide()
+	if span := opentracing.SpanFromContext(ctx); span != nil {
+		if faults.Latency > 0 {
+			span.SetTag("chaos.latency_ms", int64(faults.Latency/time.Millisecond))
+		}
+		if faults.Panic {
+			span.SetTag("chaos.panic", true)
+		} else if faults.Error {
+			span.SetTag("chaos.error", true)
+		}
+	}
+	return i.inj.InjectFaults(ctx, faults)
+}
+
+// UnaryServerInterceptor injects faults into unary RPCs. Pass it to obs.GRPCServerOptions, so that the
+// faults are recorded in the spans of the RPCs.
+func (i *Injector) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := i.Inject(ctx); err != nil {
+			return nil, status.Error(codes.Unavailable, err.Error())
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor injects faults into streaming RPCs, before they start.
+func (i *Injector) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := i.Inject(ss.Context()); err != nil {
+			return status.Error(codes.Unavailable, err.Error())
+		}
+		return handler(srv, ss)
+	}
+}
+
+// Handler injects faults into the requests of h. To record the faults in the spans of the requests,
+// it should be wrapped by the middleware starting them.
+func (i *Injector) Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := i.Inject(r.Context()); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
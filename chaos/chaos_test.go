@@ -0,0 +1,67 @@
+package chaos
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	basictracer "github.com/opentracing/basictracer-go"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func enabled(string) string { return "true" }
+
+func TestDisabled(t *testing.T) {
+	i := newInjector(Config{ErrorRate: 1, PanicRate: 1}, func(string) string { return "" })
+	assert.False(t, i.Enabled())
+	assert.NoError(t, i.Inject(context.Background()))
+	var nilInjector *Injector
+	assert.NoError(t, nilInjector.Inject(context.Background()))
+}
+
+func TestInjectTagsSpan(t *testing.T) {
+	recorder := basictracer.NewInMemoryRecorder()
+	tracer := basictracer.New(recorder)
+	span := tracer.StartSpan("request")
+	ctx := opentracing.ContextWithSpan(context.Background(), span)
+
+	i := newInjector(Config{LatencyRate: 1, Latency: time.Millisecond, ErrorRate: 1}, enabled)
+	interceptor := i.UnaryServerInterceptor()
+	_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		t.Fatal("the handler of a failed request is not called")
+		return nil, nil
+	})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	span.Finish()
+
+	spans := recorder.GetSpans()
+	if assert.Len(t, spans, 1) {
+		assert.Equal(t, int64(1), spans[0].Tags["chaos.latency_ms"])
+		assert.Equal(t, true, spans[0].Tags["chaos.error"])
+	}
+
+	i = newInjector(Config{PanicRate: 1}, enabled)
+	assert.Panics(t, func() { i.Inject(ctx) })
+}
+
+func TestHandler(t *testing.T) {
+	serve := func(i *Injector) int {
+		w := httptest.NewRecorder()
+		i.Handler(http.NotFoundHandler()).ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		return w.Code
+	}
+	assert.Equal(t, http.StatusServiceUnavailable, serve(newInjector(Config{ErrorRate: 1}, enabled)))
+	assert.Equal(t, http.StatusNotFound, serve(newInjector(Config{ErrorRate: 0.5, Seed: 1}, func(string) string { return "0" })))
+
+	// the same seed injects the same faults
+	first, second := newInjector(Config{ErrorRate: 0.5, Seed: 7}, enabled), newInjector(Config{ErrorRate: 0.5, Seed: 7}, enabled)
+	for n := 0; n < 20; n++ {
+		assert.Equal(t, serve(first), serve(second))
+	}
+}
@@ -0,0 +1,43 @@
+package clock
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFake(t *testing.T) {
+	start := time.Unix(1000, 0)
+	f := NewFake(start)
+	timer := f.After(time.Minute)
+	ticker := f.NewTicker(20 * time.Second)
+
+	f.Advance(30 * time.Second)
+	assert.Equal(t, start.Add(30*time.Second), f.Now())
+	assert.Equal(t, 30*time.Second, f.Since(start))
+	assert.Equal(t, start.Add(20*time.Second), <-ticker.C())
+	assert.Len(t, timer, 0)
+
+	f.Advance(50 * time.Second)
+	assert.Equal(t, start.Add(time.Minute), <-timer)
+	assert.Equal(t, start.Add(40*time.Second), <-ticker.C(), "ticks not received in time are dropped")
+	assert.Len(t, ticker.C(), 0)
+
+	ticker.Stop()
+	f.Advance(time.Minute)
+	assert.Len(t, ticker.C(), 0)
+	assert.Equal(t, start, <-NewFake(start).After(0))
+}
+
+func TestFakeBlockUntil(t *testing.T) {
+	f := NewFake(time.Unix(0, 0))
+	done := make(chan struct{})
+	go func() {
+		<-f.After(time.Second)
+		close(done)
+	}()
+	f.BlockUntil(1)
+	f.Advance(time.Second)
+	<-done
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/mixpanel/obs/agent"
+	"github.com/mixpanel/obs/metrics"
+
+	flags "github.com/jessevdk/go-flags"
+)
+
+type Options struct {
+	MetricsSocket string        `long:"metrics-socket" description:"unixgram socket receiving statsd lines" default:"/var/run/obs-agent/metrics.sock"`
+	LogSocket     string        `long:"log-socket" description:"unix socket receiving log lines, written to stdout" default:"/var/run/obs-agent/logs.sock"`
+	Forward       string        `long:"forward" description:"statsd address aggregated metrics are forwarded to" default:"127.0.0.1:8125"`
+	Interval      time.Duration `long:"interval" description:"aggregation interval" default:"10s"`
+}
+
+func initOptions() *Options {
+	var options Options
+	parser := flags.NewParser(&options, flags.Default)
+
+	if _, err := parser.Parse(); err != nil {
+		os.Exit(1)
+	}
+
+	return &options
+}
+
+func main() {
+	options := initOptions()
+
+	sink, err := metrics.NewStatsdSink(options.Forward)
+	if err != nil {
+		log.Fatalf("error connecting to %s: %v", options.Forward, err)
+	}
+	defer sink.Close()
+
+	aggregation := metrics.DefaultAggregationOptions
+	aggregation.Interval = options.Interval
+	a, err := agent.Start(agent.Config{
+		MetricsSocket: options.MetricsSocket,
+		LogSocket:     options.LogSocket,
+		Aggregation:   aggregation,
+	}, sink)
+	if err != nil {
+		log.Fatalf("error starting agent: %v", err)
+	}
+	defer a.Close()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	<-sig
+}
@@ -0,0 +1,196 @@
+// Package config loads the live configuration of a FlightRecorder from a YAML or JSON file, and applies
+// it again whenever the file changes, such as a Kubernetes ConfigMap mounted as a volume, so that log
+// levels, sampling, metrics and redaction can be changed without restarting the service.
+package config
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mixpanel/obs"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// DefaultInterval is how often Watch checks whether the file changed when Config.Interval is unset.
+const DefaultInterval = 10 * time.Second
+
+// File is the content of a configuration file, for instance:
+//
+//	log_level: DEBUG
+//	sample_rate: 10
+//	denied_metrics:
+//	  - myservice.cache.*
+//	allowed_metrics:
+//	  - myservice.*
+//	redaction:
+//	  keys: (?i)password|card_number
+//	  values:
+//	    - \bsk_live_[0-9a-zA-Z]+
+//
+// Settings missing from the file are the ones the FlightRecorder was initialized with; see
+// obs.Reconfigure.
+type File struct {
+	LogLevel       string    `yaml:"log_level" json:"log_level"`
+	SampleRate     *uint64   `yaml:"sample_rate" json:"sample_rate"`
+	DeniedMetrics  []string  `yaml:"denied_metrics" json:"denied_metrics"`
+	AllowedMetrics []string  `yaml:"allowed_metrics" json:"allowed_metrics"`
+	Redaction      Redaction `yaml:"redaction" json:"redaction"`
+}
+
+// Redaction are the patterns of the secrets masked, as regular expressions. See obs.NewRedactor.
+type Redaction struct {
+	Keys   string   `yaml:"keys" json:"keys"`
+	Values []string `yaml:"values" json:"values"`
+}
+
+// Load reads the file at path, as JSON if its extension is .json, and as YAML otherwise.
+func Load(path string) (*File, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parse(path, data)
+}
+
+func parse(path string, data []byte) (*File, error) {
+	var f File
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&f); err != nil {
+			return nil, fmt.Errorf("invalid configuration %s: %v", path, err)
+		}
+	} else if err := yaml.UnmarshalStrict(data, &f); err != nil {
+		return nil, fmt.Errorf("invalid configuration %s: %v", path, err)
+	}
+	return &f, nil
+}
+
+// LiveConfig returns the configuration of f applied by obs.Reconfigure.
+func (f *File) LiveConfig() (obs.LiveConfig, error) {
+	cfg := obs.LiveConfig{
+		LogLevel:       f.LogLevel,
+		SampleRate:     f.SampleRate,
+		DeniedMetrics:  f.DeniedMetrics,
+		AllowedMetrics: f.AllowedMetrics,
+	}
+	if f.Redaction.Keys != "" {
+		re, err := regexp.Compile(f.Redaction.Keys)
+		if err != nil {
+			return obs.LiveConfig{}, fmt.Errorf("invalid redaction keys: %v", err)
+		}
+		cfg.RedactedKeys = re
+	}
+	for _, v := range f.Redaction.Values {
+		re, err := regexp.Compile(v)
+		if err != nil {
+			return obs.LiveConfig{}, fmt.Errorf("invalid redaction values: %v", err)
+		}
+		cfg.RedactedValues = append(cfg.RedactedValues, re)
+	}
+	return cfg, nil
+}
+
+// Config configures Watch.
+type Config struct {
+	// Path is the path of the configuration file.
+	Path string
+	// Interval between two checks of the file. Defaults to DefaultInterval.
+	Interval time.Duration
+}
+
+type watcher struct {
+	fr  obs.FlightRecorder
+	cfg Config
+
+	// last is the content of the file last applied, or rejected.
+	last []byte
+
+	done     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// Watch applies the configuration file at cfg.Path to fr, and then again whenever its content changes,
+// until the returned function is called. The file is polled rather than watched with file system
+// notifications, which miss the symlinks swapped when a ConfigMap volume is updated. When the file
+// cannot be applied at first, Watch returns the error and does not watch it. Later errors are logged
+// through fr, and the last valid configuration stays in place.
+func Watch(fr obs.FlightRecorder, cfg Config) (func(), error) {
+	w := newWatcher(fr, cfg)
+	if err := w.check(); err != nil {
+		return nil, err
+	}
+	w.wg.Add(1)
+	go w.loop()
+	return w.stop, nil
+}
+
+func newWatcher(fr obs.FlightRecorder, cfg Config) *watcher {
+	if cfg.Interval <= 0 {
+		cfg.Interval = DefaultInterval
+	}
+	return &watcher{
+		fr:   fr.ScopeName("config"),
+		cfg:  cfg,
+		done: make(chan struct{}),
+	}
+}
+
+func (w *watcher) stop() {
+	w.stopOnce.Do(func() { close(w.done) })
+	w.wg.Wait()
+}
+
+func (w *watcher) loop() {
+	defer w.wg.Done()
+	ticker := time.NewTicker(w.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+			if err := w.check(); err != nil {
+				w.fr.WithSpan(context.Background()).Warn("reload", "unable to apply configuration",
+					obs.Vals{"path": w.cfg.Path}.WithError(err))
+			}
+		}
+	}
+}
+
+// check applies the file if its content changed since the last check.
+func (w *watcher) check() error {
+	data, err := ioutil.ReadFile(w.cfg.Path)
+	if err != nil {
+		return err
+	}
+	if w.last != nil && bytes.Equal(data, w.last) {
+		return nil
+	}
+	// an invalid file is only reported once, until it changes again
+	w.last = data
+
+	f, err := parse(w.cfg.Path, data)
+	if err != nil {
+		return err
+	}
+	live, err := f.LiveConfig()
+	if err != nil {
+		return err
+	}
+	if err := obs.Reconfigure(w.fr, live); err != nil {
+		return err
+	}
+	w.fr.WithSpan(context.Background()).Info("applied configuration", obs.Vals{"path": w.cfg.Path})
+	w.fr.GetReceiver().Incr("reloads")
+	return nil
+}
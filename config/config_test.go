@@ -0,0 +1,92 @@
+package config
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mixpanel/obs"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	f, err := parse("obs.yaml", []byte(`
+log_level: DEBUG
+sample_rate: 0
+denied_metrics: [svc.cache.*]
+allowed_metrics: [svc.*]
+redaction:
+  keys: card
+  values: ['\bsk_[a-z]+']
+`))
+	if assert.NoError(t, err) {
+		assert.Equal(t, "DEBUG", f.LogLevel)
+		if assert.NotNil(t, f.SampleRate) {
+			assert.Equal(t, uint64(0), *f.SampleRate)
+		}
+		assert.Equal(t, []string{"svc.cache.*"}, f.DeniedMetrics)
+		assert.Equal(t, []string{"svc.*"}, f.AllowedMetrics)
+		assert.Equal(t, Redaction{Keys: "card", Values: []string{`\bsk_[a-z]+`}}, f.Redaction)
+	}
+
+	f, err = parse("obs.json", []byte(`{"log_level": "WARN", "denied_metrics": ["a.*"]}`))
+	if assert.NoError(t, err) {
+		assert.Equal(t, &File{LogLevel: "WARN", DeniedMetrics: []string{"a.*"}}, f)
+	}
+
+	_, err = parse("obs.yaml", []byte("log_levle: DEBUG"))
+	assert.Error(t, err)
+	_, err = parse("obs.json", []byte(`{"sample_rate": 1, "unknown": true}`))
+	assert.Error(t, err)
+}
+
+func TestLiveConfig(t *testing.T) {
+	f := &File{LogLevel: "INFO", Redaction: Redaction{Keys: "card", Values: []string{"sk_[a-z]+"}}}
+	cfg, err := f.LiveConfig()
+	if assert.NoError(t, err) {
+		assert.Equal(t, "INFO", cfg.LogLevel)
+		assert.Nil(t, cfg.SampleRate)
+		assert.Equal(t, "card", cfg.RedactedKeys.String())
+		assert.Len(t, cfg.RedactedValues, 1)
+	}
+
+	f.Redaction.Values = []string{"sk_[a-z"}
+	_, err = f.LiveConfig()
+	assert.Error(t, err)
+}
+
+func TestWatcher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "obs-config")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "obs.yaml")
+
+	os.Setenv(obs.EnvTracer, "noop")
+	defer os.Unsetenv(obs.EnvTracer)
+	fr, closer := obs.InitGCP(context.Background(), "svc", "INFO", obs.LogFormat("text"))
+	defer closer()
+
+	_, err = Watch(fr, Config{Path: path})
+	assert.Error(t, err)
+
+	w := newWatcher(fr, Config{Path: path})
+	assert.NoError(t, ioutil.WriteFile(path, []byte("log_level: DEBUG\n"), 0644))
+	assert.NoError(t, w.check())
+	assert.True(t, obs.Log.IsDebug())
+
+	assert.NoError(t, ioutil.WriteFile(path, []byte("log_level: WARN\n"), 0644))
+	assert.NoError(t, w.check())
+	assert.False(t, obs.Log.IsInfo())
+
+	assert.NoError(t, ioutil.WriteFile(path, []byte("log_level: [DEBUG\n"), 0644))
+	assert.Error(t, w.check())
+	assert.NoError(t, w.check(), "an invalid file is reported once")
+	assert.False(t, obs.Log.IsInfo())
+
+	assert.NoError(t, ioutil.WriteFile(path, []byte("sample_rate: 1\n"), 0644))
+	assert.NoError(t, w.check())
+	assert.True(t, obs.Log.IsInfo())
+	assert.False(t, obs.Log.IsDebug())
+}
@@ -2,10 +2,13 @@ package obs
 
 import (
 	"fmt"
+	"os"
 	"syscall"
 	"time"
 
+	"github.com/mixpanel/obs/clock"
 	"github.com/mixpanel/obs/closesig"
+	"github.com/mixpanel/obs/faultinject"
 	"github.com/mixpanel/obs/logging"
 	"github.com/mixpanel/obs/metrics"
 	"github.com/mixpanel/obs/tracing"
@@ -27,80 +30,335 @@ type Option func(*obsOptions)
 // SampleRate takes in an int n, and sets the sampling rate of traces to be 1 / n
 func SampleRate(n uint64) Option {
 	return func(o *obsOptions) {
-		o.tracerOpts.ShouldSample = func(traceID uint64) bool { return traceID%n == 0 }
+		o.sampleRate = n
+		o.tracerOpts.ShouldSample = sampleOneIn(n)
 	}
 }
 
 var NoTraces Option = func(o *obsOptions) {
-	o.tracerOpts.ShouldSample = func(traceID uint64) bool { return false }
+	o.sampleRate = 0
+	o.tracerOpts.ShouldSample = sampleOneIn(0)
+}
+
+// sampleOneIn samples one trace in n, or none if n is 0.
+func sampleOneIn(n uint64) func(traceID uint64) bool {
+	if n == 0 {
+		return func(traceID uint64) bool { return false }
+	}
+	return func(traceID uint64) bool { return traceID%n == 0 }
+}
+
+// AdaptiveSampling force-samples root spans of operations that are currently failing or slow,
+// on top of the regular sample rate. See AdaptiveSampler.
+func AdaptiveSampling(cfg AdaptiveSamplingConfig) Option {
+	return func(o *obsOptions) {
+		o.sampler = NewAdaptiveSampler(cfg)
+	}
+}
+
+// MetadataOnlyTracing records the timing and status of every span into ring and into RED metrics
+// (an <op>.errors counter next to the <op>.latency_us stat), while unsampled spans skip collecting
+// tags and logs, so that every request is accounted for without paying for exporting it.
+func MetadataOnlyTracing(ring *SpanRing) Option {
+	return func(o *obsOptions) {
+		o.tracerOpts.TrimUnsampledSpans = true
+		o.spans = ring
+	}
+}
+
+// FaultInjection injects the faults decided by inj into the metrics sink and the trace exporter,
+// to test how a service behaves when its telemetry backends misbehave. Do not use it in production.
+func FaultInjection(inj *faultinject.Injector) Option {
+	return func(o *obsOptions) {
+		o.faults = inj
+	}
+}
+
+// AggregateMetrics aggregates metrics in memory and sends aggregates to statsd once per interval,
+// instead of one packet per metric. See metrics.NewAggregatingReceiver.
+func AggregateMetrics(opts metrics.AggregationOptions) Option {
+	return func(o *obsOptions) {
+		o.aggregation = &opts
+	}
+}
+
+// MetricCardinalityLimit caps the number of distinct tag combinations reported for each metric to n.
+// Beyond it, tag values are replaced by metrics.OverflowTagValue and a cardinality_limited counter is
+// incremented, so that a tag fed with unbounded values cannot overwhelm the metrics backend.
+func MetricCardinalityLimit(n int) Option {
+	return func(o *obsOptions) {
+		o.cardinality = n
+	}
+}
+
+// MetricsSnapshot records the values of at most maxSeries series of metrics, so that
+// FlightRecorder.GetReceiver().Snapshot() returns them, for instance to expose them in an admin
+// endpoint. They are recorded as reported to statsd: after metrics are denied or their cardinality is
+// limited. See metrics.NewSnapshotSink.
+func MetricsSnapshot(maxSeries int) Option {
+	return func(o *obsOptions) {
+		o.snapshotSeries = maxSeries
+	}
+}
+
+// MetricNameValidation configures how the names of metrics are normalized before reaching statsd; see
+// metrics.NewNameValidatingSink. Names are always normalized, and a bad_metric_name counter is
+// incremented for every bad name unless cfg.BadNameCounter is set. Tests can set cfg.Strict to panic
+// instead.
+func MetricNameValidation(cfg metrics.NameValidation) Option {
+	return func(o *obsOptions) {
+		o.nameValidation = cfg
+	}
+}
+
+// WithClock drives the time-dependent components of the FlightRecorder with c instead of the system
+// clock: span latencies, stopwatches and timers, metric aggregation, and the standard metrics
+// reported periodically. Tests can pass a clock.Fake to advance time instead of sleeping.
+func WithClock(c clock.Clock) Option {
+	return func(o *obsOptions) {
+		o.clock = c
+	}
+}
+
+// SpanTagPolicy enforces policy on the tags of the spans started by the FlightRecorder. See
+// tracing.TagPolicy.
+func SpanTagPolicy(policy tracing.TagPolicy) Option {
+	return func(o *obsOptions) {
+		o.tagPolicy = &policy
+	}
 }
 
 type obsOptions struct {
-	tracerOpts basictracer.Options
+	tracerOpts     basictracer.Options
+	sampleRate     uint64
+	sampling       *liveSampling
+	sampler        *AdaptiveSampler
+	spans          *SpanRing
+	faults         *faultinject.Injector
+	aggregation    *metrics.AggregationOptions
+	cardinality    int
+	snapshotSeries int
+	goroutines     *GoroutineReporting
+	nameValidation metrics.NameValidation
+	tagPolicy      *tracing.TagPolicy
+	clock          clock.Clock
+	redactor       *Redactor
+	baggage        *baggagePolicy
+	slowSpans      *SlowSpanThresholds
+	metricsAddr    string
+	logFormat      string
+	logOptions     []logging.Option
+
+	resource *Resource
+	// resourceMetricTags are the attributes of the resource that metrics are tagged with.
+	resourceMetricTags []string
 }
 
-// TODO(shimin): InitGCP should be able to set default tags (project, cluster, host) from metadata service.
-// It should also allow the caller to pass in other tags.
+// TODO(shimin): InitGCP should also tag telemetry with the project and cluster from the metadata service,
+// along with the Resource. It should also allow the caller to pass in other tags.
+//
+// InitGCP can be configured with environment variables, such as OBS_LOG_LEVEL (see EnvStatsdAddr and
+// the following). They take precedence over its arguments and defaults, and Options take precedence
+// over them.
 func InitGCP(ctx context.Context, serviceName, logLevel string, opts ...Option) (FlightRecorder, Closer) {
+	cfg, obsOpts, errs := gcpConfig(serviceName, logLevel, os.Getenv, opts)
 	sig := closesig.Client(closesig.DefaultPort)
-	l := logging.New("NEVER", logLevel, "", "json")
+	l := logging.New("NEVER", cfg.LogLevel, "", cfg.LogFormat, obsOpts.logOptions...)
+	for _, err := range errs {
+		l.Warn("ignoring invalid environment variable", logging.Fields{}.WithError(err))
+	}
+
+	// Components are stopped in reverse registration order: closesig tells the metrics agent the
+	// service is gone, so it goes last, once metrics are flushed.
+	lc := NewLifecycle()
+	lc.RegisterCloser("closesig", sig)
+	var tracer opentracing.Tracer = opentracing.NoopTracer{}
+	var tracerHealth *exportHealth
+	if cfg.Tracer == tracerGCP {
+		var closeTracer func()
+		tracerHealth = &exportHealth{}
+		tracer, closeTracer = tracing.New(obsOpts.tracerOpts,
+			tracing.WithFaults(obsOpts.faults), tracing.OnFlush(tracerHealth.record))
+		lc.RegisterCloser("tracer", closeTracer)
+	}
+	if obsOpts.tagPolicy != nil {
+		var err error
+		if tracer, err = tracing.WithTagPolicy(tracer, *obsOpts.tagPolicy); err != nil {
+			l.Critical("error initializing tracing", logging.Fields{}.WithError(err))
+			panic(fmt.Errorf("error initializing tracing: %v", err))
+		}
+	}
+	fr := initFR(ctx, serviceName, cfg.MetricsAddr, &obsOpts, l, tracer, lc)
+	if tracerHealth != nil {
+		fr.healthChecks["tracer"] = tracerHealth.check
+	}
+	fr.sampler = obsOpts.sampler
+	fr.spans = obsOpts.spans
+	fr.config = &cfg
+	fr.envParent, _ = decodeSpanContext(tracer, os.Getenv(EnvTraceContext))
+	return fr, lc.Closer(l)
+}
+
+// gcpConfig returns the configuration of InitGCP: its arguments and defaults, overridden by the
+// environment variables found with getenv, overridden by opts. It also returns the errors of the
+// invalid environment variables.
+func gcpConfig(serviceName, logLevel string, getenv func(string) string, opts []Option) (recorderConfig, obsOptions, []error) {
+	cfg := recorderConfig{
+		Service:     serviceName,
+		LogLevel:    logLevel,
+		LogFormat:   "json",
+		MetricsAddr: defaultStatsdAddr,
+		Tracer:      tracerGCP,
+		SampleRate:  100,
+	}
+	errs := cfg.applyEnv(getenv)
 
 	obsOpts := obsOptions{tracerOpts: basictracer.DefaultOptions()}
-	SampleRate(100)(&obsOpts)
+	if cfg.SampleRate > 0 {
+		SampleRate(cfg.SampleRate)(&obsOpts)
+	} else {
+		NoTraces(&obsOpts)
+	}
 	for _, o := range opts {
 		o(&obsOpts)
 	}
+	obsOpts.sampling = newLiveSampling(obsOpts.tracerOpts.ShouldSample)
+	obsOpts.tracerOpts.ShouldSample = obsOpts.sampling.shouldSample
 
-	tracer, closeTracer := tracing.New(obsOpts.tracerOpts)
-	fr, closer := initFR(ctx, serviceName, l, tracer)
-	return fr, func() {
-		closeTracer()
-		closer()
-		sig()
+	if obsOpts.metricsAddr != "" {
+		cfg.MetricsAddr = obsOpts.metricsAddr
+	}
+	if obsOpts.logFormat != "" {
+		cfg.LogFormat = obsOpts.logFormat
 	}
+	cfg.SampleRate = obsOpts.sampleRate
+	if cfg.Tracer == tracerNoop {
+		cfg.SampleRate = 0
+	}
+	return cfg, obsOpts, errs
 }
 
 func InitCli(ctx context.Context, name, logLevel string) (FlightRecorder, Closer) {
 	fr := NewFlightRecorder(name, metrics.Null, logging.New(logLevel, logLevel, "", "text"),
-		opentracing.NoopTracer{})
+		opentracing.NoopTracer{}).(*flightRecorder)
+	fr.config = &recorderConfig{
+		Service:   name,
+		LogLevel:  logLevel,
+		LogFormat: "text",
+		Tracer:    tracerNoop,
+	}
 	return fr, func() {}
 }
 
-func initFR(ctx context.Context, serviceName string, l logging.Logger, tr opentracing.Tracer) (FlightRecorder, Closer) {
-	sink, err := metrics.NewStatsdSink("127.0.0.1:8125")
+const defaultStatsdAddr = "127.0.0.1:8125"
+
+// initFR builds a FlightRecorder reporting metrics to metricsAddr, and registers the components it
+// starts with lc.
+func initFR(ctx context.Context, serviceName, metricsAddr string, o *obsOptions, l logging.Logger, tr opentracing.Tracer, lc *Lifecycle) *flightRecorder {
+	sink, err := metrics.NewStatsdSink(metricsAddr,
+		metrics.StatsdConnectionGauge(serviceName+".statsd.connected"))
 	if err != nil {
 		l.Critical("error initializing metrics", logging.Fields{}.WithError(err))
 		panic(fmt.Errorf("error initializing metrics: %v", err))
 	}
+	healthChecks := make(map[string]HealthCheck)
+	if hc, ok := sink.(metrics.HealthChecker); ok {
+		healthChecks["statsd"] = func(context.Context) error { return hc.CheckHealth() }
+	}
+	sink = metrics.NewFaultySink(sink, o.faults)
+	if o.snapshotSeries > 0 {
+		sink = metrics.NewSnapshotSink(sink, o.snapshotSeries)
+	}
+	if o.cardinality > 0 {
+		sink = metrics.NewCardinalityLimitedSink(sink, o.cardinality, serviceName+".cardinality_limited")
+	}
+	// denied metrics are dropped before the cardinality limiter, so that they neither count against
+	// its limit nor overflow it
+	deniedMetrics := metrics.NewDenyListSink(sink)
+	sink = deniedMetrics
+	nameValidation := o.nameValidation
+	if nameValidation.BadNameCounter == "" {
+		nameValidation.BadNameCounter = serviceName + ".bad_metric_name"
+	}
+	sink = metrics.NewNameValidatingSink(sink, nameValidation)
 
-	mr := metrics.NewReceiver(sink).ScopePrefix(serviceName)
+	c := o.clock
+	if c == nil {
+		c = clock.Real
+	}
+	mr := metrics.NewReceiverWithClock(sink, c)
+	stopAggregation := func() {}
+	if o.aggregation != nil {
+		aggregation := *o.aggregation
+		if aggregation.Clock == nil {
+			aggregation.Clock = c
+		}
+		mr, stopAggregation = metrics.NewAggregatingReceiver(sink, aggregation)
+	}
+	var resource Resource
+	if o.resource != nil {
+		resource = *o.resource
+	} else {
+		resource = DetectResource()
+	}
+	mr = mr.Scope(serviceName, resourceMetricTags(resource, o.resourceMetricTags))
 	l = l.Named(serviceName)
 	Metrics = mr
 	Log = l
 
 	done := make(chan struct{})
-	reportStandardMetrics(mr, done)
+	reportStandardMetrics(mr, done, c)
+	goroutines := DefaultGoroutineReporting
+	if o.goroutines != nil {
+		goroutines = *o.goroutines
+	}
+	reportGoroutines(goroutines, done, mr, l, c)
+	unregisterSinkStats := metrics.RegisterSinkStats(mr.ScopePrefix("statsd"), sink)
+	unregisterDenied := deniedMetrics.RegisterDenied(mr, "denied_metrics")
 
-	fr := NewFlightRecorder(serviceName, mr, l, tr)
-	// TODO: make this work. currently obs.logging uses SetOutput on the global logging which makes this a circlular dependency
-	// log.SetOutput(stderrAdapter{fr.WithSpan(ctx)})
+	lc.RegisterCloser("metrics_sink", sink.Close)
+	lc.RegisterCloser("metrics_aggregation", stopAggregation, DependsOn("metrics_sink"))
+	lc.RegisterCloser("standard_metrics", func() { close(done) }, DependsOn("metrics_aggregation"))
+	lc.RegisterCloser("sink_stats", unregisterSinkStats, DependsOn("metrics_aggregation"))
+	lc.RegisterCloser("denied_metrics", unregisterDenied, DependsOn("metrics_aggregation"))
+	if _, ok := logging.SyslogDropped(l); ok {
+		unregisterSyslog := mr.RegisterGauge("syslog_dropped", func() float64 {
+			dropped, _ := logging.SyslogDropped(l)
+			return float64(dropped)
+		})
+		lc.RegisterCloser("syslog_dropped", unregisterSyslog, DependsOn("metrics_aggregation"))
+	}
 
-	return fr, func() {
-		close(done)
-		sink.Close()
+	fr := NewFlightRecorder(serviceName, mr, l, tr).(*flightRecorder)
+	fr.clock = c
+	fr.healthChecks = healthChecks
+	fr.redactor = o.redactor
+	if fr.redactor == nil {
+		fr.redactor = NewRedactor(nil)
 	}
+	if o.baggage != nil {
+		fr.baggage = o.baggage
+	}
+	fr.slowSpans = o.slowSpans
+	fr.resource = resource
+	fr.resourceTags = resource.Tags()
+	fr.live = newLiveSettings(l, o.sampling, deniedMetrics, fr.redactor)
+	// The output of the standard logger is left alone, since obs.logging writes through it: libraries
+	// logging with the log package are given fr.StdLogger() instead.
+
+	return fr
 }
 
-func reportStandardMetrics(mr metrics.Receiver, done <-chan struct{}) {
-	reportGCMetrics(3*time.Second, done, mr)
-	reportVersion(done, mr)
-	reportUptime(done, mr)
-	reportRusage(done, mr)
+func reportStandardMetrics(mr metrics.Receiver, done <-chan struct{}, c clock.Clock) {
+	reportGCMetrics(3*time.Second, done, mr, c)
+	reportVersion(done, mr, c)
+	reportUptime(done, mr, c)
+	reportRusage(done, mr, c)
 }
 
-func reportVersion(done <-chan struct{}, receiver metrics.Receiver) {
+func reportVersion(done <-chan struct{}, receiver metrics.Receiver, c clock.Clock) {
 	go func() {
-		next := time.After(0)
+		next := c.After(0)
 		for {
 			select {
 			case <-done:
@@ -108,33 +366,33 @@ func reportVersion(done <-chan struct{}, receiver metrics.Receiver) {
 			case <-next:
 				// TODO: Add back
 				//receiver.SetGauge("git_version", float64(version.Int()))
-				next = time.After(60 * time.Second)
+				next = c.After(60 * time.Second)
 			}
 		}
 	}()
 }
 
-func reportUptime(done <-chan struct{}, receiver metrics.Receiver) {
-	startTime := time.Now()
+func reportUptime(done <-chan struct{}, receiver metrics.Receiver, c clock.Clock) {
+	startTime := c.Now()
 	go func() {
-		next := time.After(0)
+		next := c.After(0)
 		for {
 			select {
 			case <-done:
 				return
 			case <-next:
-				uptime := time.Now().Sub(startTime)
+				uptime := c.Since(startTime)
 				receiver.SetGauge("uptime_sec", uptime.Seconds())
-				next = time.After(60 * time.Second)
+				next = c.After(60 * time.Second)
 			}
 		}
 	}()
 }
 
-func reportRusage(done <-chan struct{}, receiver metrics.Receiver) {
+func reportRusage(done <-chan struct{}, receiver metrics.Receiver, c clock.Clock) {
 	receiver = receiver.ScopePrefix("rusage")
 	go func() {
-		next := time.After(0)
+		next := c.After(0)
 		for {
 			select {
 			case <-done:
@@ -148,17 +406,8 @@ func reportRusage(done <-chan struct{}, receiver metrics.Receiver) {
 					receiver.SetGauge("voluntary_cs", float64(rusage.Nvcsw))
 					receiver.SetGauge("involuntary_cs", float64(rusage.Nivcsw))
 				}
-				next = time.After(60 * time.Second)
+				next = c.After(60 * time.Second)
 			}
 		}
 	}()
 }
-
-type stderrAdapter struct {
-	fs FlightSpan
-}
-
-func (sa stderrAdapter) Write(bs []byte) (int, error) {
-	sa.fs.Info(string(bs), Vals(getCallerContext(1)))
-	return len(bs), nil
-}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/mixpanel/obs/clock"
 	"github.com/mixpanel/obs/logging"
 	"github.com/mixpanel/obs/metrics"
 
@@ -18,8 +19,11 @@ type ObsOptions struct {
 	SyslogLevel     string `long:"syslog.level" default:"NEVER" description:"One of CRIT, ERR, WARN, INFO, DEBUG, NEVER"`
 	LogLevel        string `long:"log.level" default:"INFO" description:"One of CRIT, ERR, WARN, INFO, DEBUG, NEVER"`
 	LogPath         string `long:"log.path" description:"File path to log. uses stderr if not set"`
-	LogFormat       string `long:"log.format" description:"Format of log output" default:"text" choice:"text" choice:"json"`
-	MetricsEndpoint string `long:"metrics-endpoint" description:"Address (host:port) to send metrics"`
+	LogFormat       string `long:"log.format" description:"Format of log output" default:"text" choice:"text" choice:"json" choice:"console"`
+	LogMaxSizeMB    int    `long:"log.max-size-mb" description:"Rotate the log file when it reaches this size in megabytes. 0 disables rotation"`
+	LogMaxBackups   int    `long:"log.max-backups" description:"Number of rotated log files to keep. 0 keeps all of them"`
+	LogCompress     bool   `long:"log.compress" description:"Gzip rotated log files"`
+	MetricsEndpoint string `long:"metrics-endpoint" description:"Address to send metrics: host:port for UDP, or tcp://host:port, unix:///path, unixgram:///path"`
 }
 
 func NewOptions(parser *flags.Parser) *ObsOptions {
@@ -42,6 +46,14 @@ func (opts *ObsOptions) Init(metricsPrefix string) {
 }
 
 func (opts *ObsOptions) InitLogging() {
+	if opts.LogMaxSizeMB > 0 {
+		Log = logging.NewWithRotation(opts.SyslogLevel, opts.LogLevel, opts.LogPath, opts.LogFormat, logging.RotateOptions{
+			MaxSize:    int64(opts.LogMaxSizeMB) << 20,
+			MaxBackups: opts.LogMaxBackups,
+			Compress:   opts.LogCompress,
+		})
+		return
+	}
 	Log = logging.New(opts.SyslogLevel, opts.LogLevel, opts.LogPath, opts.LogFormat)
 }
 
@@ -50,9 +62,9 @@ func (opts *ObsOptions) InitWithSink(metricsPrefix string, sink metrics.Sink) {
 	Sink = sink
 	receiver := metrics.NewReceiver(Sink)
 	Metrics = receiver.ScopePrefix(metricsPrefix)
-	reportGCMetrics(3*time.Second, nil, Metrics)
-	reportVersion(nil, Metrics)
-	reportUptime(nil, Metrics)
+	reportGCMetrics(3*time.Second, nil, Metrics, clock.Real)
+	reportVersion(nil, Metrics, clock.Real)
+	reportUptime(nil, Metrics, clock.Real)
 }
 
 func RecordError(receiver metrics.Receiver, err error) {
@@ -0,0 +1,152 @@
+package obs
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mixpanel/obs/metrics"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// DeprecationWarningInterval is the minimum time between two warnings logged by FlightSpan.Deprecated
+// for the same API and caller.
+var DeprecationWarningInterval = time.Minute
+
+// ClientVersionHeader is the gRPC metadata key from which FlightSpan.Deprecated reads the caller's version.
+const ClientVersionHeader = "x-client-version"
+
+const (
+	unknownCaller = "unknown"
+	// otherCaller replaces the callers beyond the first maxDeprecationCallers.
+	otherCaller = "other"
+	// maxDeprecationCallers bounds the number of distinct callers deprecated_api.calls is tagged with.
+	maxDeprecationCallers = 100
+	// maxCallerFieldLength truncates the user agents and versions of callers.
+	maxCallerFieldLength = 64
+)
+
+type deprecationKey struct {
+	api, userAgent, clientVersion string
+}
+
+type deprecationCaller struct {
+	userAgent, clientVersion string
+}
+
+// deprecationLimiter remembers when a warning was last logged for each deprecated API and caller, and
+// which callers were seen.
+type deprecationLimiter struct {
+	now func() time.Time
+
+	mu      sync.Mutex
+	logged  map[deprecationKey]time.Time
+	pruned  time.Time
+	callers map[deprecationCaller]struct{}
+}
+
+func newDeprecationLimiter() *deprecationLimiter {
+	return &deprecationLimiter{
+		now:     time.Now,
+		logged:  make(map[deprecationKey]time.Time),
+		callers: make(map[deprecationCaller]struct{}),
+	}
+}
+
+// allow reports whether a warning should be logged for key, and if so records that it was. Keys whose
+// warnings are older than DeprecationWarningInterval are forgotten once per interval.
+func (d *deprecationLimiter) allow(key deprecationKey) bool {
+	now := d.now()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if now.Sub(d.pruned) >= DeprecationWarningInterval {
+		for k, last := range d.logged {
+			if now.Sub(last) >= DeprecationWarningInterval {
+				delete(d.logged, k)
+			}
+		}
+		d.pruned = now
+	}
+	if last, ok := d.logged[key]; ok && now.Sub(last) < DeprecationWarningInterval {
+		return false
+	}
+	d.logged[key] = now
+	return true
+}
+
+// caller returns the caller as it is reported: the first maxDeprecationCallers callers seen are
+// reported as they are, and the next ones as otherCaller.
+func (d *deprecationLimiter) caller(userAgent, clientVersion string) (string, string) {
+	c := deprecationCaller{userAgent, clientVersion}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.callers[c]; ok {
+		return userAgent, clientVersion
+	}
+	if len(d.callers) >= maxDeprecationCallers {
+		return otherCaller, otherCaller
+	}
+	d.callers[c] = struct{}{}
+	return userAgent, clientVersion
+}
+
+// callerField keeps the first word of s, such as client-go/1.0 in the user agent
+// "client-go/1.0 grpc-go/1.50.0", truncated to maxCallerFieldLength.
+func callerField(s string) string {
+	if i := strings.IndexByte(s, ' '); i >= 0 {
+		s = s[:i]
+	}
+	if len(s) > maxCallerFieldLength {
+		s = s[:maxCallerFieldLength]
+	}
+	return s
+}
+
+// callerOf identifies the client of the gRPC call in ctx by its user agent and version, both reduced to
+// their first word and truncated.
+func callerOf(ctx context.Context) (userAgent, clientVersion string) {
+	userAgent, clientVersion = unknownCaller, unknownCaller
+	if ctx == nil {
+		return
+	}
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return
+	}
+	if vs := md.Get("user-agent"); len(vs) > 0 && vs[0] != "" {
+		userAgent = callerField(vs[0])
+	}
+	if vs := md.Get(ClientVersionHeader); len(vs) > 0 && vs[0] != "" {
+		clientVersion = callerField(vs[0])
+	}
+	return
+}
+
+func (fs *flightSpan) Deprecated(api string, vals Vals) {
+	userAgent, clientVersion := callerOf(fs.ctx)
+	if fs.deprecations != nil {
+		userAgent, clientVersion = fs.deprecations.caller(userAgent, clientVersion)
+	}
+	fs.receiver().ScopeTags(metrics.Tags{
+		"api":            api,
+		"user_agent":     userAgent,
+		"client_version": clientVersion,
+	}).Incr("deprecated_api.calls")
+
+	key := deprecationKey{api, userAgent, clientVersion}
+	if fs.deprecations != nil && !fs.deprecations.allow(key) {
+		return
+	}
+	fields := fs.logFields(vals)
+	fields["deprecated_api"] = api
+	fields["user_agent"] = userAgent
+	fields["client_version"] = clientVersion
+	fs.l.Warn("deprecated API called: "+api, fields)
+	fs.logTrace("deprecated API called: "+api, fields)
+}
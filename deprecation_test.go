@@ -0,0 +1,75 @@
+package obs
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/mixpanel/obs/metrics"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestDeprecated(t *testing.T) {
+	sink := &metrics.MockSink{Invocations: make(map[string]int)}
+	l := &testLogger{}
+	fr := NewFlightRecorder("test", metrics.NewReceiver(sink), l, opentracing.NoopTracer{})
+	now := time.Now()
+	fr.(*flightRecorder).deprecations.now = func() time.Time { return now }
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+		"user-agent", "client-go/1.0",
+		ClientVersionHeader, "1.2.3",
+	))
+	fs, _, done := fr.WithNewSpan(ctx, "request")
+	defer done()
+
+	fs.Deprecated("OldEndpoint", Vals{"project_id": 42})
+	fs.Deprecated("OldEndpoint", nil)
+	fr.WithSpan(context.Background()).Deprecated("OldEndpoint", nil)
+	now = now.Add(DeprecationWarningInterval)
+	fs.Deprecated("OldEndpoint", nil)
+
+	assert.Equal(t, 3, sink.Invocations["deprecated_api.calls, map[api:OldEndpoint client_version:1.2.3 user_agent:client-go/1.0], 1, ct\n"])
+	assert.Equal(t, 1, sink.Invocations["deprecated_api.calls, map[api:OldEndpoint client_version:unknown user_agent:unknown], 1, ct\n"])
+	if assert.Len(t, l.entries, 3) {
+		assert.Equal(t, "WARN", l.entries[0].level)
+		assert.Equal(t, "OldEndpoint", l.entries[0].fields["deprecated_api"])
+		assert.Equal(t, 42, l.entries[0].fields["project_id"])
+		assert.Equal(t, "unknown", l.entries[1].fields["user_agent"])
+		assert.Equal(t, "1.2.3", l.entries[2].fields["client_version"])
+	}
+}
+
+func TestDeprecatedCallers(t *testing.T) {
+	sink := metrics.NewMockSink()
+	fr := NewFlightRecorder("test", metrics.NewReceiver(sink), &testLogger{}, opentracing.NoopTracer{})
+	deprecated := func(userAgent string) {
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("user-agent", userAgent))
+		fr.WithSpan(ctx).Deprecated("OldEndpoint", nil)
+	}
+
+	deprecated("client-go/1.0 grpc-go/1.50.0")
+	for i := 0; i < maxDeprecationCallers; i++ {
+		deprecated(fmt.Sprintf("client-%d", i))
+	}
+	deprecated("client-go/1.0 grpc-go/1.51.0")
+	assert.Equal(t, 2, sink.Invocations["deprecated_api.calls, map[api:OldEndpoint client_version:unknown user_agent:client-go/1.0], 1, ct\n"],
+		"user agents are reduced to their first product")
+	assert.Equal(t, 1, sink.Invocations["deprecated_api.calls, map[api:OldEndpoint client_version:other user_agent:other], 1, ct\n"])
+}
+
+func TestDeprecationLimiterPrunes(t *testing.T) {
+	d := newDeprecationLimiter()
+	now := time.Now()
+	d.now = func() time.Time { return now }
+
+	assert.True(t, d.allow(deprecationKey{api: "a"}))
+	assert.True(t, d.allow(deprecationKey{api: "b"}))
+	now = now.Add(DeprecationWarningInterval)
+	assert.True(t, d.allow(deprecationKey{api: "c"}))
+	assert.Len(t, d.logged, 1, "expired warnings are forgotten")
+}
@@ -0,0 +1,92 @@
+package obs
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/mixpanel/obs/logging"
+)
+
+// Environment variables configuring InitGCP, so that the same binary can run in different environments
+// without code changes. They override the arguments and defaults of InitGCP, and are overridden by the
+// Options passed to it.
+const (
+	// EnvStatsdAddr is the address metrics are sent to, in the form accepted by metrics.NewStatsdSink.
+	EnvStatsdAddr = "OBS_STATSD_ADDR"
+	// EnvLogLevel is the level of the logs written to stderr: NEVER, DEBUG, INFO, WARN, ERROR or CRITICAL.
+	EnvLogLevel = "OBS_LOG_LEVEL"
+	// EnvLogFormat is the format of the logs: json, text or console.
+	EnvLogFormat = "OBS_LOG_FORMAT"
+	// EnvTracer is the tracer: gcp to export sampled traces, or noop to disable tracing, for local development.
+	EnvTracer = "OBS_TRACER"
+	// EnvSampleRate is n to sample one trace in n, or 0 to sample none.
+	EnvSampleRate = "OBS_SAMPLE_RATE"
+
+	// The attributes of the Resource found by DetectResource.
+	EnvPodName        = "OBS_POD_NAME"
+	EnvNodeName       = "OBS_NODE_NAME"
+	EnvZone           = "OBS_ZONE"
+	EnvRegion         = "OBS_REGION"
+	EnvServiceVersion = "OBS_SERVICE_VERSION"
+)
+
+// StatsdAddr sends metrics to addr, in the form accepted by metrics.NewStatsdSink, instead of the local statsd.
+func StatsdAddr(addr string) Option {
+	return func(o *obsOptions) {
+		o.metricsAddr = addr
+	}
+}
+
+// LogFormat sets the format of the logs: json, text or console.
+func LogFormat(format string) Option {
+	return func(o *obsOptions) {
+		o.logFormat = format
+	}
+}
+
+// LogOptions configures the logger, for instance with logging.WithJSONSchema to fit the index the logs
+// are shipped to.
+func LogOptions(opts ...logging.Option) Option {
+	return func(o *obsOptions) {
+		o.logOptions = append(o.logOptions, opts...)
+	}
+}
+
+// applyEnv overrides cfg with the environment variables found with getenv. It returns the errors of
+// the variables with invalid values, which are ignored.
+func (cfg *recorderConfig) applyEnv(getenv func(string) string) []error {
+	var errs []error
+	if addr := getenv(EnvStatsdAddr); addr != "" {
+		cfg.MetricsAddr = addr
+	}
+	if level := getenv(EnvLogLevel); level != "" {
+		cfg.LogLevel = level
+	}
+	if format := getenv(EnvLogFormat); format != "" {
+		if isLogFormat(format) {
+			cfg.LogFormat = format
+		} else {
+			errs = append(errs, fmt.Errorf("invalid %s %q: must be json, text or console", EnvLogFormat, format))
+		}
+	}
+	if tracer := strings.ToLower(getenv(EnvTracer)); tracer != "" {
+		if tracer == tracerGCP || tracer == tracerNoop {
+			cfg.Tracer = tracer
+		} else {
+			errs = append(errs, fmt.Errorf("invalid %s %q: must be %s or %s", EnvTracer, tracer, tracerGCP, tracerNoop))
+		}
+	}
+	if rate := getenv(EnvSampleRate); rate != "" {
+		if n, err := strconv.ParseUint(rate, 10, 64); err == nil {
+			cfg.SampleRate = n
+		} else {
+			errs = append(errs, fmt.Errorf("invalid %s %q: %v", EnvSampleRate, rate, err))
+		}
+	}
+	return errs
+}
+
+func isLogFormat(format string) bool {
+	return format == "json" || format == "text" || format == "console"
+}
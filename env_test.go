@@ -0,0 +1,53 @@
+package obs
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGCPConfig(t *testing.T) {
+	env := map[string]string{}
+	getenv := func(k string) string { return env[k] }
+
+	cfg, _, errs := gcpConfig("svc", "INFO", getenv, nil)
+	assert.Empty(t, errs)
+	assert.Equal(t, recorderConfig{
+		Service:     "svc",
+		LogLevel:    "INFO",
+		LogFormat:   "json",
+		MetricsAddr: defaultStatsdAddr,
+		Tracer:      tracerGCP,
+		SampleRate:  100,
+	}, cfg)
+
+	env = map[string]string{
+		EnvStatsdAddr: "unixgram:///var/run/statsd.sock",
+		EnvLogLevel:   "DEBUG",
+		EnvLogFormat:  "console",
+		EnvSampleRate: "10",
+	}
+	cfg, _, errs = gcpConfig("svc", "INFO", getenv, nil)
+	assert.Empty(t, errs)
+	assert.Equal(t, "unixgram:///var/run/statsd.sock", cfg.MetricsAddr)
+	assert.Equal(t, "DEBUG", cfg.LogLevel)
+	assert.Equal(t, "console", cfg.LogFormat)
+	assert.Equal(t, uint64(10), cfg.SampleRate)
+
+	// options take precedence over the environment
+	cfg, _, _ = gcpConfig("svc", "INFO", getenv, []Option{StatsdAddr("statsd:8125"), LogFormat("text"), SampleRate(1000)})
+	assert.Equal(t, "statsd:8125", cfg.MetricsAddr)
+	assert.Equal(t, "text", cfg.LogFormat)
+	assert.Equal(t, uint64(1000), cfg.SampleRate)
+
+	env = map[string]string{EnvTracer: "NOOP", EnvLogFormat: "xml", EnvSampleRate: "often"}
+	cfg, _, errs = gcpConfig("svc", "INFO", getenv, nil)
+	assert.Len(t, errs, 2)
+	assert.Equal(t, tracerNoop, cfg.Tracer)
+	assert.Equal(t, "json", cfg.LogFormat)
+	assert.Equal(t, uint64(0), cfg.SampleRate)
+
+	env = map[string]string{EnvSampleRate: "0"}
+	_, obsOpts, _ := gcpConfig("svc", "INFO", getenv, nil)
+	assert.False(t, obsOpts.tracerOpts.ShouldSample(0))
+}
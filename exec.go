@@ -0,0 +1,157 @@
+package obs
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"os"
+	"os/exec"
+
+	"github.com/mixpanel/obs/logging"
+	"github.com/mixpanel/obs/tracing"
+
+	basictracer "github.com/opentracing/basictracer-go"
+	opentracing "github.com/opentracing/opentracing-go"
+)
+
+// Environment variables used to hand a FlightRecorder over to a child process.
+const (
+	EnvConfig       = "OBS_CONFIG"
+	EnvTraceContext = "OBS_TRACE_CONTEXT"
+)
+
+const (
+	tracerGCP  = "gcp"
+	tracerNoop = "noop"
+)
+
+// recorderConfig is what a FlightRecorder was initialized with. It is serialized into the
+// environment of child processes so that they can build an equivalent FlightRecorder.
+type recorderConfig struct {
+	Service     string `json:"service"`
+	LogLevel    string `json:"log_level"`
+	LogFormat   string `json:"log_format"`
+	MetricsAddr string `json:"metrics_addr,omitempty"`
+	Tracer      string `json:"tracer"`
+	SampleRate  uint64 `json:"sample_rate,omitempty"`
+}
+
+// ChildEnv returns environment variables, in the KEY=value form used by os/exec.Cmd.Env, that let a
+// child process reconstruct fr with InitFromEnv and continue the trace of the span in ctx. For example:
+//
+//	cmd := exec.CommandContext(ctx, "converter", args...)
+//	cmd.Env = append(os.Environ(), obs.ChildEnv(ctx, fr)...)
+func ChildEnv(ctx context.Context, fr FlightRecorder) []string {
+	var env []string
+
+	if r, ok := fr.(*flightRecorder); ok && r.config != nil {
+		if encoded, err := json.Marshal(r.config); err == nil {
+			env = append(env, EnvConfig+"="+string(encoded))
+		}
+	}
+
+	if span := opentracing.SpanFromContext(ctx); span != nil {
+		if encoded, ok := encodeSpanContext(span.Tracer(), span.Context()); ok {
+			env = append(env, EnvTraceContext+"="+encoded)
+		}
+	}
+	return env
+}
+
+// Command is like exec.CommandContext, but the command runs with the environment of the current
+// process and ChildEnv, so that a child process initialized with InitFromEnv or InitGCP reports into
+// the trace of the span in ctx.
+func Command(ctx context.Context, fr FlightRecorder, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	// the variables of ChildEnv come last, so that they replace those the current process was given by
+	// its own parent
+	cmd.Env = append(os.Environ(), ChildEnv(ctx, fr)...)
+	return cmd
+}
+
+// WithNewSpanFromEnv starts a span named opName continuing the trace of the parent process, when fr was
+// built by InitGCP or InitFromEnv in a process started with the environment of ChildEnv, such as by
+// Command. Otherwise, it starts a root span. Tools run by services call it once, to appear in the
+// traces of the services as children of the span that ran them.
+func WithNewSpanFromEnv(ctx context.Context, fr FlightRecorder, opName string) (FlightSpan, context.Context, func()) {
+	var parent opentracing.SpanContext
+	if r, ok := fr.(*flightRecorder); ok {
+		parent = r.envParent
+	}
+	return fr.WithNewSpanContext(ctx, opName, parent)
+}
+
+// InitFromEnv builds a FlightRecorder from the environment prepared by a parent process with ChildEnv.
+// The returned context carries a span named opName that continues the parent's trace; it is finished
+// by the Closer. If the parent did not pass a configuration, the FlightRecorder is built like InitCli
+// with the INFO log level.
+func InitFromEnv(ctx context.Context, opName string) (FlightRecorder, context.Context, Closer) {
+	return initFromEnv(ctx, opName, os.Getenv)
+}
+
+func initFromEnv(ctx context.Context, opName string, getenv func(string) string) (FlightRecorder, context.Context, Closer) {
+	var cfg recorderConfig
+	if err := json.Unmarshal([]byte(getenv(EnvConfig)), &cfg); err != nil {
+		cfg = recorderConfig{Service: opName, LogLevel: "INFO", LogFormat: "text", Tracer: tracerNoop}
+	}
+
+	var fr *flightRecorder
+	var closer Closer
+	switch cfg.Tracer {
+	case tracerGCP:
+		obsOpts := obsOptions{tracerOpts: basictracer.DefaultOptions()}
+		if cfg.SampleRate > 0 {
+			SampleRate(cfg.SampleRate)(&obsOpts)
+		} else {
+			NoTraces(&obsOpts)
+		}
+		lc := NewLifecycle()
+		tracer, closeTracer := tracing.New(obsOpts.tracerOpts)
+		lc.RegisterCloser("tracer", closeTracer)
+		l := logging.New("NEVER", cfg.LogLevel, "", cfg.LogFormat)
+		fr = initFR(ctx, cfg.Service, cfg.MetricsAddr, &obsOpts, l, tracer, lc)
+		closer = lc.Closer(l)
+	default:
+		f, closeFR := InitCli(ctx, cfg.Service, cfg.LogLevel)
+		fr, closer = f.(*flightRecorder), closeFR
+	}
+	fr.config = &cfg
+	fr.envParent, _ = decodeSpanContext(fr.tr, getenv(EnvTraceContext))
+
+	_, ctx, done := WithNewSpanFromEnv(ctx, fr, opName)
+	return fr, ctx, func() {
+		done()
+		closer()
+	}
+}
+
+func encodeSpanContext(tracer opentracing.Tracer, spanCtx opentracing.SpanContext) (string, bool) {
+	carrier := opentracing.TextMapCarrier{}
+	if err := tracer.Inject(spanCtx, opentracing.TextMap, carrier); err != nil || len(carrier) == 0 {
+		return "", false
+	}
+	values := make(url.Values, len(carrier))
+	for k, v := range carrier {
+		values.Set(k, v)
+	}
+	return values.Encode(), true
+}
+
+func decodeSpanContext(tracer opentracing.Tracer, encoded string) (opentracing.SpanContext, bool) {
+	if encoded == "" {
+		return nil, false
+	}
+	values, err := url.ParseQuery(encoded)
+	if err != nil {
+		return nil, false
+	}
+	carrier := opentracing.TextMapCarrier{}
+	for k := range values {
+		carrier[k] = values.Get(k)
+	}
+	spanCtx, err := tracer.Extract(opentracing.TextMap, carrier)
+	if err != nil {
+		return nil, false
+	}
+	return spanCtx, true
+}
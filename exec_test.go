@@ -0,0 +1,101 @@
+package obs
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/mixpanel/obs/metrics"
+	basictracer "github.com/opentracing/basictracer-go"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func envLookup(env []string) func(string) string {
+	return func(key string) string {
+		for _, kv := range env {
+			if strings.HasPrefix(kv, key+"=") {
+				return strings.TrimPrefix(kv, key+"=")
+			}
+		}
+		return ""
+	}
+}
+
+func TestSpanContextRoundTrip(t *testing.T) {
+	tracer := basictracer.New(basictracer.NewInMemoryRecorder())
+	span := tracer.StartSpan("parent")
+	span.SetBaggageItem("request_id", "abc")
+
+	encoded, ok := encodeSpanContext(tracer, span.Context())
+	assert.True(t, ok)
+
+	spanCtx, ok := decodeSpanContext(tracer, encoded)
+	if assert.True(t, ok) {
+		parent := span.Context().(basictracer.SpanContext)
+		decoded := spanCtx.(basictracer.SpanContext)
+		assert.Equal(t, parent.TraceID, decoded.TraceID)
+		assert.Equal(t, parent.SpanID, decoded.SpanID)
+		assert.Equal(t, "abc", decoded.Baggage["request_id"])
+	}
+
+	_, ok = decodeSpanContext(tracer, "")
+	assert.False(t, ok)
+}
+
+func TestChildEnv(t *testing.T) {
+	ctx := context.Background()
+	fr, closer := InitCli(ctx, "parent", "WARN")
+	defer closer()
+
+	tracer := basictracer.New(basictracer.NewInMemoryRecorder())
+	span := tracer.StartSpan("parent")
+	env := ChildEnv(opentracing.ContextWithSpan(ctx, span), fr)
+	getenv := envLookup(env)
+
+	assert.Contains(t, getenv(EnvConfig), `"service":"parent"`)
+	assert.NotEmpty(t, getenv(EnvTraceContext))
+
+	child, _, done := initFromEnv(ctx, "child", getenv)
+	defer done()
+	assert.Equal(t, fr.(*flightRecorder).config, child.(*flightRecorder).config)
+	assert.Equal(t, env[:1], ChildEnv(ctx, child))
+}
+
+func TestInitFromEnvDefaults(t *testing.T) {
+	fr, _, done := initFromEnv(context.Background(), "child", func(string) string { return "" })
+	defer done()
+
+	cfg := fr.(*flightRecorder).config
+	assert.Equal(t, "child", cfg.Service)
+	assert.Equal(t, "INFO", cfg.LogLevel)
+	assert.Equal(t, tracerNoop, cfg.Tracer)
+}
+
+func TestCommandTraceContext(t *testing.T) {
+	ctx := context.Background()
+	parentFR, _, _ := newTestFlightRecorder()
+	fs, spanCtx, done := parentFR.WithNewSpan(ctx, "convert")
+	defer done()
+
+	cmd := Command(spanCtx, parentFR, "converter", "--in", "a.png")
+	assert.Equal(t, []string{"converter", "--in", "a.png"}, cmd.Args)
+	encoded := envLookup(cmd.Env)(EnvTraceContext)
+	assert.NotEmpty(t, encoded)
+
+	fr, _, recorder := newTestFlightRecorder()
+	child := fr.(*flightRecorder)
+	child.envParent, _ = decodeSpanContext(child.tr, encoded)
+	_, _, finish := WithNewSpanFromEnv(ctx, child.ScopeName("tool"), "run")
+	finish()
+	_, _, finish = WithNewSpanFromEnv(ctx, NewFlightRecorder("test", metrics.Null, &testLogger{}, child.tr), "root")
+	finish()
+
+	spans := recorder.GetSpans()
+	if assert.Len(t, spans, 2) {
+		parent := fs.TraceSpan().Context().(basictracer.SpanContext)
+		assert.Equal(t, parent.TraceID, spans[0].Context.TraceID)
+		assert.Equal(t, parent.SpanID, spans[0].ParentSpanID)
+		assert.NotEqual(t, parent.TraceID, spans[1].Context.TraceID)
+	}
+}
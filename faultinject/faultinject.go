@@ -0,0 +1,140 @@
+// Package faultinject injects latency and errors into the telemetry pipeline, so that tests can verify
+// that a service degrades gracefully when its metrics, tracing or event backends misbehave.
+// It is meant for tests and staging environments only, and underlies package chaos, which makes the
+// requests a service serves misbehave.
+package faultinject
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrInjected is returned by calls that an Injector decided to fail.
+var ErrInjected = errors.New("faultinject: injected failure")
+
+// Config describes the faults to inject. Rates are fractions of calls between 0 and 1.
+type Config struct {
+	// ErrorRate is the fraction of calls that fail with ErrInjected.
+	ErrorRate float64
+	// LatencyRate is the fraction of calls that are delayed by Latency before proceeding.
+	LatencyRate float64
+	Latency     time.Duration
+	// PanicRate is the fraction of calls that panic instead of proceeding.
+	PanicRate float64
+	// Seed seeds the random decisions, so that a test injects the same faults on every run.
+	Seed int64
+}
+
+// Injector decides which calls fail or are delayed. A nil *Injector never injects anything, so it
+// can be threaded through unconditionally.
+type Injector struct {
+	cfg   Config
+	sleep func(context.Context, time.Duration)
+
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+// New returns an Injector for cfg.
+func New(cfg Config) *Injector {
+	return &Injector{
+		cfg:   cfg,
+		sleep: sleep,
+		rng:   rand.New(rand.NewSource(cfg.Seed)),
+	}
+}
+
+// sleep waits for d, or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
+
+// Faults are the faults decided for a call.
+type Faults struct {
+	// Latency is the delay of the call, or 0.
+	Latency time.Duration
+	Error   bool
+	Panic   bool
+}
+
+// Decide draws the faults to inject into a call according to the configured rates, so that callers
+// can record them before they take effect with InjectFaults.
+func (i *Injector) Decide() Faults {
+	if i == nil {
+		return Faults{}
+	}
+
+	i.mu.Lock()
+	delay := i.rng.Float64() < i.cfg.LatencyRate
+	fail := i.rng.Float64() < i.cfg.ErrorRate
+	panics := i.rng.Float64() < i.cfg.PanicRate
+	i.mu.Unlock()
+
+	f := Faults{Error: fail, Panic: panics}
+	if delay {
+		f.Latency = i.cfg.Latency
+	}
+	return f
+}
+
+// InjectFaults delays the caller, panics or returns ErrInjected according to f. Delays end early
+// when ctx is done.
+func (i *Injector) InjectFaults(ctx context.Context, f Faults) error {
+	if i == nil {
+		return nil
+	}
+	if f.Latency > 0 {
+		i.sleep(ctx, f.Latency)
+	}
+	if f.Panic {
+		panic("faultinject: injected panic")
+	}
+	if f.Error {
+		return ErrInjected
+	}
+	return nil
+}
+
+// InjectContext delays the caller, panics or returns ErrInjected according to the configured rates.
+// Delays end early when ctx is done.
+func (i *Injector) InjectContext(ctx context.Context) error {
+	return i.InjectFaults(ctx, i.Decide())
+}
+
+// Inject delays the caller, panics or returns ErrInjected according to the configured rates.
+func (i *Injector) Inject() error {
+	return i.InjectContext(context.Background())
+}
+
+// RoundTripper returns an http.RoundTripper that injects faults before passing requests on to next,
+// or http.DefaultTransport if next is nil.
+func (i *Injector) RoundTripper(next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	if i == nil {
+		return next
+	}
+	return roundTripper{i, next}
+}
+
+type roundTripper struct {
+	inj  *Injector
+	next http.RoundTripper
+}
+
+func (rt roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := rt.inj.InjectContext(req.Context()); err != nil {
+		return nil, err
+	}
+	return rt.next.RoundTrip(req)
+}
@@ -0,0 +1,68 @@
+package faultinject
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func injectN(i *Injector, n int) (failures int, delays int) {
+	i.sleep = func(context.Context, time.Duration) { delays++ }
+	for j := 0; j < n; j++ {
+		if i.Inject() != nil {
+			failures++
+		}
+	}
+	return failures, delays
+}
+
+func TestInject(t *testing.T) {
+	cfg := Config{ErrorRate: 0.25, LatencyRate: 0.5, Latency: time.Second, Seed: 42}
+
+	failures, delays := injectN(New(cfg), 10000)
+	assert.InDelta(t, 2500, failures, 250)
+	assert.InDelta(t, 5000, delays, 250)
+
+	// the same seed injects the same faults
+	failuresAgain, delaysAgain := injectN(New(cfg), 10000)
+	assert.Equal(t, failures, failuresAgain)
+	assert.Equal(t, delays, delaysAgain)
+}
+
+func TestInjectContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	start := time.Now()
+	assert.Equal(t, ErrInjected, New(Config{LatencyRate: 1, Latency: time.Minute, ErrorRate: 1}).InjectContext(ctx))
+	assert.True(t, time.Since(start) < time.Minute, "delays end when the context is done")
+
+	assert.Equal(t, Faults{Panic: true}, New(Config{PanicRate: 1, Latency: time.Minute}).Decide())
+	assert.Panics(t, func() { New(Config{PanicRate: 1}).Inject() })
+}
+
+func TestNilInjector(t *testing.T) {
+	var i *Injector
+	assert.NoError(t, i.Inject())
+	assert.Equal(t, Faults{}, i.Decide())
+	assert.Equal(t, http.DefaultTransport, i.RoundTripper(nil))
+}
+
+func TestRoundTripper(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	client := &http.Client{Transport: New(Config{ErrorRate: 1}).RoundTripper(nil)}
+	_, err := client.Get(server.URL)
+	assert.Error(t, err)
+
+	client = &http.Client{Transport: New(Config{}).RoundTripper(nil)}
+	resp, err := client.Get(server.URL)
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+}
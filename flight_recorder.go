@@ -2,14 +2,22 @@ package obs
 
 import (
 	"fmt"
+	"log"
+	"reflect"
 	"runtime"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/mixpanel/obs/clock"
 	"github.com/mixpanel/obs/logging"
 	"github.com/mixpanel/obs/metrics"
+	"github.com/mixpanel/obs/obserr"
+	"github.com/mixpanel/obs/tracing"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
 
 	"context"
 
@@ -29,7 +37,11 @@ func NewFlightRecorder(name string, metrics metrics.Receiver, logger logging.Log
 		l:  logger,
 		tr: tracer,
 
-		scoped: make(map[string]*flightRecorder),
+		scoped:       make(map[string]*flightRecorder),
+		deprecations: newDeprecationLimiter(),
+		globalTags:   newGlobalTags(),
+		clock:        clock.Real,
+		baggage:      newBaggagePolicy(DefaultBaggagePolicy),
 	}
 }
 
@@ -53,14 +65,63 @@ func (v Vals) Dupe() Vals {
 	return res
 }
 
+// Merge returns a copy of v with all of m added to it. Values in m overwrite values in v with the same key.
 func (v Vals) Merge(m Vals) Vals {
+	return v.MergeWith(m, OverwriteConflicts)
+}
+
+// MergePolicy decides what happens when Vals being merged have the same key.
+type MergePolicy int
+
+const (
+	// OverwriteConflicts keeps the value being merged in.
+	OverwriteConflicts MergePolicy = iota
+	// KeepExisting keeps the value that was there before the merge.
+	KeepExisting
+	// RenameConflicts keeps both values, storing the value being merged in under the key suffixed
+	// with _2 (or _3 and so on if that key is taken too). Identical values are not duplicated.
+	RenameConflicts
+)
+
+// MergeWith returns a copy of v with all of m added to it, resolving conflicting keys with policy.
+func (v Vals) MergeWith(m Vals, policy MergePolicy) Vals {
 	new := v.Dupe()
 	for key, val := range m {
-		new[key] = val
+		existing, conflict := new[key]
+		if !conflict {
+			new[key] = val
+			continue
+		}
+		switch policy {
+		case KeepExisting:
+		case RenameConflicts:
+			if reflect.DeepEqual(existing, val) {
+				continue
+			}
+			for i := 2; ; i++ {
+				renamed := fmt.Sprintf("%s_%d", key, i)
+				if _, taken := new[renamed]; !taken {
+					new[renamed] = val
+					break
+				}
+			}
+		default:
+			new[key] = val
+		}
 	}
 	return new
 }
 
+// Prefixed returns a copy of v with prefix prepended to every key, e.g. Vals{"rows": 3}.Prefixed("db_")
+// is Vals{"db_rows": 3}. It is useful to namespace vals contributed by a layer of code before merging them.
+func (v Vals) Prefixed(prefix string) Vals {
+	res := make(Vals, len(v))
+	for key, val := range v {
+		res[prefix+key] = val
+	}
+	return res
+}
+
 type errWithVals interface {
 	Vals() map[string]interface{}
 }
@@ -74,7 +135,7 @@ func (v Vals) WithError(err error) Vals {
 		}
 	}
 
-	code := grpc.Code(err)
+	code := status.Code(err)
 	name := code.String()
 
 	res["err"] = fmt.Sprintf("%v", err)
@@ -135,6 +196,10 @@ type FlightRecorder interface {
 	ScopeTags(tags Tags) FlightRecorder
 	// Scope returns a new FlightRecorder that will report telemetry scoped with the provided name and tags.
 	Scope(name string, tags Tags) FlightRecorder
+	// Component returns a new FlightRecorder for a subsystem of the service. Like ScopeName, its logs are
+	// named service.component and its metrics and span names are prefixed with the component; in
+	// addition, its spans are tagged with component:<name>. Nested components are joined with dots.
+	Component(name string) FlightRecorder
 
 	// WithNewSpan returns a new FlightSpan to which telemetry can be reported, a context.Context that
 	// can be used to propagate this Span, and a DoneFunc that should be called when the caller returns.
@@ -150,29 +215,59 @@ type FlightRecorder interface {
 
 	// GRPCClient returns a grpc.DialOption to use to allow this FlightRecorder to intercept and instrument
 	// Unary RPCs with that particular client. Make sure to also include GRPCStreamClient.
-	GRPCClient() grpc.DialOption
+	GRPCClient(opts ...GRPCOption) grpc.DialOption
 
 	// GRPCStreamClient returns a grpc.DialOption to use to allow this FlightRecorder to intercept and instrument
 	// streaming RPCs with that particular client. Make sure to also include GRPClient.
-	GRPCStreamClient() grpc.DialOption
+	GRPCStreamClient(opts ...GRPCOption) grpc.DialOption
 
 	// GRPCServer returns a grpc.ServerOption to use to allow this FlightRecorder to intercept and instrument
 	// unary RPCs with that particular server. Make sure to also include GRPCStreamServer.
-	GRPCServer() grpc.ServerOption
+	GRPCServer(opts ...GRPCOption) grpc.ServerOption
 
 	// GRPCStreamServer returns a grpc.ServerOption to use to allow this FlightRecorder to intercept and instrument
 	// streaming RPCs with that particular server. Make sure to also include GRPServer.
-	GRPCStreamServer() grpc.ServerOption
+	GRPCStreamServer(opts ...GRPCOption) grpc.ServerOption
 
 	// WithNewSpanContext is like WithNewSpan but allows you to specify the parent SpanContext instead of deriving it
 	// from the context.Context. This is usually only useful for libraries that derive tracing contexts from out-of-process
 	// origins, such as as GRPC request where the tracing context is embeded in GRPC Metadata.
 	WithNewSpanContext(ctx context.Context, opName string, spanCtx opentracing.SpanContext) (FlightSpan, context.Context, DoneFunc)
 
+	// WithFollowsFrom is like WithNewSpan, but for work that the span in ctx starts without waiting
+	// for it, such as a background goroutine. The new span follows from the span in ctx rather than
+	// being its child, and the returned context.Context is not canceled when ctx is.
+	WithFollowsFrom(ctx context.Context, opName string) (FlightSpan, context.Context, DoneFunc)
+
+	// WithLinks is like WithNewSpan, for work processing items that come from other traces, such as a
+	// batch job: the new span is linked to the span of every item, passed as links, for instance with
+	// ExtractSpanContext, rather than being a child of one of them. Links are FollowsFrom references,
+	// except with basictracer, as used by InitGCP, which would make the first one the parent: its links
+	// are tagged as links instead, as a list of trace_id/span_id. Beyond 128 links, links are counted
+	// in the tag links_dropped.
+	WithLinks(ctx context.Context, opName string, links ...opentracing.SpanContext) (FlightSpan, context.Context, DoneFunc)
+
 	// WithRootSpan is like WithNewSpan but allows you to force a root span and set its sample rate.
 	WithRootSpan(ctx context.Context, opName string, sampleOneInN int) (FlightSpan, context.Context, DoneFunc)
 
+	// SetGlobalTag sets a tag applied to the spans started, and the metrics and logs reported, from now on
+	// by the FlightRecorder and every FlightRecorder scoped from it, for instance after a leader election
+	// or a shard assignment. Tags given to Scope or ScopeTags take precedence. It is safe to call
+	// concurrently with reporting, which never waits for it.
+	SetGlobalTag(k, v string)
+	// DeleteGlobalTag removes a tag set with SetGlobalTag.
+	DeleteGlobalTag(k string)
+
 	GetReceiver() metrics.Receiver
+
+	// StdLogger returns a *log.Logger, for libraries that log with the log package, whose lines are
+	// logged by the FlightRecorder at the level their prefix names, such as "[WARN]" or "error:", and
+	// at the info level otherwise. See NewSlogHandler for libraries that log with log/slog.
+	StdLogger() *log.Logger
+
+	// Resource returns where the service runs, whose attributes are attached to its spans and logs.
+	// It is empty unless the FlightRecorder was created by InitGCP.
+	Resource() Resource
 }
 
 type FlightSpan interface {
@@ -181,6 +276,8 @@ type FlightSpan interface {
 	Info(message string, vals Vals)
 
 	Warn(warnType, message string, vals Vals)
+	// Critical logs an error, which is also logged to the span, and marks the span as failed like
+	// ReportError does, so that the trace of the request shows the failure.
 	Critical(critType, message string, vals Vals)
 
 	Incr(name string)
@@ -190,7 +287,66 @@ type FlightSpan interface {
 
 	StartStopwatch(name string) Stopwatch
 
+	// StartTimer starts timing a stage of the operation of the span. The returned function records the
+	// duration of the stage in the <stage>_us stat and as an event of the span, and ends the child span
+	// started with TimerChildSpan, if any.
+	StartTimer(stage string, opts ...TimerOption) (done func())
+	// Time times f as a stage of the operation of the span, like StartTimer.
+	Time(stage string, f func(), opts ...TimerOption)
+
+	// Deprecated records a call to a deprecated API: it counts the call per caller, identified by the
+	// gRPC user agent and client version of the request, and logs a warning at most once per
+	// DeprecationWarningInterval for each caller. User agents are reduced to their first product, such
+	// as client-go/1.0, and callers beyond the first hundred are counted as "other".
+	Deprecated(api string, vals Vals)
+
+	// ReportError records that the operation of the span failed with err: the span is marked failed,
+	// err is logged at ERROR level with its vals, and its obserr.Fingerprint is added to the log and the
+	// span as error_fingerprint. When err has an obserr code, the span is tagged with it as error_code
+	// and errors_total is incremented with the error_code, operation and whether err is
+	// obserr.IsRetryable as tags. The fingerprint is not a tag of errors_total: there is no bound to the
+	// number of distinct errors.
+	ReportError(err error)
+
+	// Go runs f in a new goroutine, in a span named opName that follows from this span. See
+	// FlightRecorder.WithFollowsFrom.
+	Go(opName string, f func(ctx context.Context, fs FlightSpan))
+
+	// WithVals returns a FlightSpan reporting into the same span that merges vals into every
+	// subsequent log call and tags the span with them. Vals passed to a log call take precedence.
+	WithVals(vals Vals) FlightSpan
+
+	// WithMetricTags returns a FlightSpan reporting into the same span whose metrics all carry tags,
+	// in addition to the tags of the FlightRecorder. Tags should be low-cardinality, such as a route,
+	// shard or region.
+	WithMetricTags(tags Tags) FlightSpan
+
+	// WithMetricTimestamp returns a FlightSpan reporting into the same span whose metrics are recorded
+	// at t instead of now, for jobs reprocessing historical data. Only sinks supporting explicit
+	// timestamps honor it; see metrics.TimestampedSink.
+	WithMetricTimestamp(t time.Time) FlightSpan
+
+	// SetSamplingPriority forces the trace of the span to be kept or dropped. The decision applies to
+	// child spans started afterwards, including in downstream services, so it should be made as early
+	// as possible; see ContextWithSamplingPriority to make it before the span starts.
+	SetSamplingPriority(p SamplingPriority)
+
+	// SetBaggage sets baggage on the span, which propagates to its children and to downstream
+	// services, for business context such as the tenant or the source of a request. Keys and sizes
+	// are checked against the BaggagePolicy of the FlightRecorder: rejected items are counted in
+	// baggage.rejected and return ErrBaggageKeyNotAllowed or ErrBaggageTooLarge.
+	SetBaggage(key, value string) error
+	// Baggage returns the baggage item key of the span, set locally or upstream, or "".
+	Baggage(key string) string
+
+	// IsNoop reports whether all telemetry reported through the FlightSpan is discarded: metrics go to a
+	// null sink, logging is disabled and the span is not sampled. Callers can then skip preparing
+	// expensive Vals or payloads.
+	IsNoop() bool
+
 	TraceSpan() opentracing.Span
+	// TraceID returns the trace ID of the span as 32 hexadecimal digits, or false if there is no span
+	// or its tracer is neither basictracer nor jaeger.
 	TraceID() (string, bool)
 }
 
@@ -201,12 +357,35 @@ type Stopwatch interface {
 type flightRecorder struct {
 	serviceName string
 	name        string
+	component   string
 	tags        Tags
 
 	mr metrics.Receiver
 	l  logging.Logger
 	tr opentracing.Tracer
 
+	sampler      *AdaptiveSampler
+	spans        *SpanRing
+	config       *recorderConfig
+	deprecations *deprecationLimiter
+	globalTags   *globalTags
+	redactor     *Redactor
+	// healthChecks are the checks of the observability pipeline, run by HealthServers.
+	healthChecks map[string]HealthCheck
+	// live changes the pipeline while it runs, with Reconfigure.
+	live *liveSettings
+	// clock times spans, stopwatches and timers.
+	clock   clock.Clock
+	baggage *baggagePolicy
+	// slowSpans, if set, reports the spans lasting longer than their threshold.
+	slowSpans *SlowSpanThresholds
+	resource  Resource
+	// resourceTags are the attributes of resource, set on spans and logs.
+	resourceTags Tags
+	// envParent is the span of the parent process, passed in EnvTraceContext, that
+	// WithNewSpanFromEnv continues.
+	envParent opentracing.SpanContext
+
 	mu     sync.Mutex
 	scoped map[string]*flightRecorder
 }
@@ -229,22 +408,22 @@ func joinNames(lhs, rhs string) string {
 }
 
 func (fr *flightRecorder) GetReceiver() metrics.Receiver {
-	return fr.mr
+	return fr.globalTags.load().receiver(fr.mr, fr.tags)
 }
 
-func (fr *flightRecorder) GRPCClient() grpc.DialOption {
-	return grpc.WithUnaryInterceptor(tracingUnaryClientInterceptor(fr, fr.tr))
+func (fr *flightRecorder) GRPCClient(opts ...GRPCOption) grpc.DialOption {
+	return grpc.WithChainUnaryInterceptor(tracingUnaryClientInterceptor(fr, fr.tr, newGRPCOptions(opts)))
 }
 
-func (fr *flightRecorder) GRPCStreamClient() grpc.DialOption {
-	return grpc.WithStreamInterceptor(tracingStreamClientInterceptor(fr, fr.tr))
+func (fr *flightRecorder) GRPCStreamClient(opts ...GRPCOption) grpc.DialOption {
+	return grpc.WithChainStreamInterceptor(tracingStreamClientInterceptor(fr, fr.tr, newGRPCOptions(opts)))
 }
 
-func (fr *flightRecorder) GRPCServer() grpc.ServerOption {
-	return grpc.UnaryInterceptor(tracingUnaryServerInterceptor(fr, fr.tr))
+func (fr *flightRecorder) GRPCServer(opts ...GRPCOption) grpc.ServerOption {
+	return grpc.UnaryInterceptor(tracingUnaryServerInterceptor(fr, fr.tr, newGRPCOptions(opts)))
 }
-func (fr *flightRecorder) GRPCStreamServer() grpc.ServerOption {
-	return grpc.StreamInterceptor(tracingStreamServerInterceptor(fr, fr.tr))
+func (fr *flightRecorder) GRPCStreamServer(opts ...GRPCOption) grpc.ServerOption {
+	return grpc.StreamInterceptor(tracingStreamServerInterceptor(fr, fr.tr, newGRPCOptions(opts)))
 }
 
 func (fr *flightRecorder) mkScoped(name string, tags Tags) *flightRecorder {
@@ -262,12 +441,28 @@ func (fr *flightRecorder) mkScoped(name string, tags Tags) *flightRecorder {
 	return &flightRecorder{
 		serviceName: fr.serviceName,
 		name:        newName,
+		component:   fr.component,
 		tags:        frTags,
 
 		mr: fr.mr.Scope(name, metricTags),
 		l:  fr.l.Named(newName),
 		tr: fr.tr,
 
+		sampler:      fr.sampler,
+		spans:        fr.spans,
+		config:       fr.config,
+		deprecations: fr.deprecations,
+		globalTags:   fr.globalTags,
+		redactor:     fr.redactor,
+		healthChecks: fr.healthChecks,
+		live:         fr.live,
+		clock:        fr.clock,
+		baggage:      fr.baggage,
+		slowSpans:    fr.slowSpans,
+		resource:     fr.resource,
+		resourceTags: fr.resourceTags,
+		envParent:    fr.envParent,
+
 		scoped: make(map[string]*flightRecorder),
 	}
 }
@@ -292,11 +487,31 @@ func (fr *flightRecorder) Scope(name string, tags Tags) FlightRecorder {
 	return sfr
 }
 
+func (fr *flightRecorder) Component(name string) FlightRecorder {
+	if len(name) == 0 {
+		return fr
+	}
+
+	// components are cached apart from scopes of the same name, which do not tag spans
+	key := "component:" + name
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+	if sfr, ok := fr.scoped[key]; ok {
+		return sfr
+	}
+	sfr := fr.mkScoped(name, nil)
+	sfr.component = joinNames(fr.component, name)
+	fr.scoped[key] = sfr
+	return sfr
+}
+
 func (fr *flightRecorder) WithSpan(ctx context.Context) FlightSpan {
 	span := opentracing.SpanFromContext(ctx)
+	state, _ := ctx.Value(spanStateKey{}).(*spanState)
 	return &flightSpan{
 		span:           span,
 		ctx:            ctx,
+		state:          state,
 		flightRecorder: fr,
 	}
 }
@@ -317,28 +532,99 @@ func (fr *flightRecorder) WithNewSpan(ctx context.Context, opName string) (Fligh
 }
 
 func (fr *flightRecorder) WithNewSpanContext(ctx context.Context, opName string, spanCtx opentracing.SpanContext) (FlightSpan, context.Context, DoneFunc) {
-	var span opentracing.Span
-	fullOpName := joinNames(fr.name, opName)
 	if spanCtx != nil {
-		span = fr.tr.StartSpan(fullOpName, opentracing.ChildOf(spanCtx))
-	} else {
-		span = fr.tr.StartSpan(fullOpName)
+		return fr.startSpan(ctx, opName, opentracing.ChildOf(spanCtx))
 	}
+	return fr.startSpan(ctx, opName)
+}
 
+func (fr *flightRecorder) WithFollowsFrom(ctx context.Context, opName string) (FlightSpan, context.Context, DoneFunc) {
+	ctx = detachedContext{ctx}
+	if parentSpan := opentracing.SpanFromContext(ctx); parentSpan != nil {
+		return fr.startSpan(ctx, opName, opentracing.FollowsFrom(parentSpan.Context()))
+	}
+	return fr.startSpan(ctx, opName)
+}
+
+// detachedContext carries the values of a context without its deadline and cancellation.
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool)         { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}               { return nil }
+func (detachedContext) Err() error                          { return nil }
+func (c detachedContext) Value(key interface{}) interface{} { return c.parent.Value(key) }
+
+// startSpan starts a span referencing the spans in refs, or a root span if there are none.
+func (fr *flightRecorder) startSpan(ctx context.Context, opName string, refs ...opentracing.StartSpanOption) (FlightSpan, context.Context, DoneFunc) {
+	fullOpName := joinNames(fr.name, opName)
+	span := fr.tr.StartSpan(fullOpName, refs...)
+
+	for k, v := range fr.resourceTags {
+		span = span.SetTag(k, v)
+	}
+	for k, v := range fr.globalTags.load().tags {
+		if _, ok := fr.tags[k]; !ok {
+			span = span.SetTag(k, v)
+		}
+	}
 	for k, v := range fr.tags {
 		span = span.SetTag(k, v)
 	}
+	if fr.component != "" {
+		span = span.SetTag("component", fr.component)
+	}
+
+	if len(refs) == 0 {
+		if p, ok := samplingPriorityFromContext(ctx); ok {
+			ext.SamplingPriority.Set(span, uint16(p))
+		} else if fr.sampler != nil && fr.sampler.ShouldSample(fullOpName) {
+			ext.SamplingPriority.Set(span, 1)
+		}
+	}
 
+	state := &spanState{operation: fullOpName}
 	ctx = opentracing.ContextWithSpan(ctx, span)
+	ctx = context.WithValue(ctx, spanStateKey{}, state)
 	fs := &flightSpan{
 		span:           span,
 		ctx:            ctx,
+		state:          state,
 		flightRecorder: fr,
 	}
+	start := fr.clock.Now()
 	sw := fs.StartStopwatch(opName + ".latency")
 	return fs, ctx, func() {
 		sw.Stop()
+		d := fr.clock.Since(start)
+		if fr.slowSpans != nil {
+			fs.reportSlow(d)
+		}
+		// the span may be reused once finished, so its context is read beforehand
+		sc := span.Context()
 		span.Finish()
+		if fr.sampler == nil && fr.spans == nil {
+			return
+		}
+		failed := atomic.LoadInt32(&state.failed) != 0
+		if fr.sampler != nil && fr.sampler.Observe(fullOpName, d, failed) {
+			fr.mr.ScopeTags(metrics.Tags{"operation": fullOpName}).Incr("adaptive_sampling.boosted")
+		}
+		if fr.spans != nil {
+			if failed {
+				fs.Incr(opName + ".errors")
+			}
+			rec := SpanRecord{
+				Operation: fullOpName,
+				Start:     start,
+				Duration:  d,
+				Failed:    failed,
+			}
+			rec.Sampled, _ = tracing.IsSampled(sc)
+			rec.TraceID, _ = tracing.TraceID(sc)
+			fr.spans.Add(rec)
+		}
 	}
 }
 
@@ -354,31 +640,161 @@ func (fr *flightRecorder) WithRootSpan(ctx context.Context, opName string, sampl
 }
 
 type flightSpan struct {
-	span opentracing.Span
-	ctx  context.Context
+	span  opentracing.Span
+	ctx   context.Context
+	vals  Vals
+	state *spanState
+
+	// taggedMR replaces the FlightRecorder's receiver when the span has metric tags.
+	taggedMR metrics.Receiver
 
 	*flightRecorder
 }
 
+// spanState is shared by all FlightSpans reporting into a span created by WithNewSpan.
+type spanState struct {
+	failed    int32
+	operation string
+
+	mutex sync.Mutex // guards vals
+	// vals are those given to WithVals, kept for the slow span warning when SlowSpans is set.
+	vals Vals
+}
+
+type spanStateKey struct{}
+
+// markFailed flags the span as failed, both in the tracer and for adaptive sampling.
+func markFailed(fs FlightSpan) {
+	ext.Error.Set(fs.TraceSpan(), true)
+	if f, ok := fs.(*flightSpan); ok && f.state != nil {
+		atomic.StoreInt32(&f.state.failed, 1)
+	}
+}
+
+func (fs *flightSpan) Go(opName string, f func(ctx context.Context, fs FlightSpan)) {
+	childFS, ctx, done := fs.flightRecorder.WithFollowsFrom(fs.ctx, opName)
+	go func() {
+		defer done()
+		f(ctx, childFS)
+	}()
+}
+
+func (fs *flightSpan) WithVals(vals Vals) FlightSpan {
+	if len(vals) == 0 {
+		return fs
+	}
+	vals = fs.redactor.Vals(vals)
+	if fs.span != nil {
+		for k, v := range vals {
+			fs.span.SetTag(k, v)
+		}
+	}
+	if fs.slowSpans != nil && fs.state != nil {
+		fs.state.addVals(vals)
+	}
+	return &flightSpan{
+		span:           fs.span,
+		ctx:            fs.ctx,
+		vals:           fs.vals.Merge(vals),
+		state:          fs.state,
+		taggedMR:       fs.taggedMR,
+		flightRecorder: fs.flightRecorder,
+	}
+}
+
+func (fs *flightSpan) WithMetricTimestamp(t time.Time) FlightSpan {
+	return &flightSpan{
+		span:           fs.span,
+		ctx:            fs.ctx,
+		vals:           fs.vals,
+		state:          fs.state,
+		taggedMR:       fs.receiver().At(t),
+		flightRecorder: fs.flightRecorder,
+	}
+}
+
+func (fs *flightSpan) WithMetricTags(tags Tags) FlightSpan {
+	if len(tags) == 0 {
+		return fs
+	}
+	metricTags := make(metrics.Tags, len(tags))
+	for k, v := range tags {
+		metricTags[k] = v
+	}
+	return &flightSpan{
+		span:           fs.span,
+		ctx:            fs.ctx,
+		vals:           fs.vals,
+		state:          fs.state,
+		taggedMR:       fs.receiver().ScopeTags(metricTags),
+		flightRecorder: fs.flightRecorder,
+	}
+}
+
+func (fs *flightSpan) IsNoop() bool {
+	return fs.receiver().IsNull() && !fs.l.IsCritical() && !isRecording(fs.span)
+}
+
+// isRecording reports whether span is sampled, assuming it is for tracers other than basictracer and
+// jaeger.
+func isRecording(span opentracing.Span) bool {
+	if span == nil || isNoopTracer(span.Tracer()) {
+		return false
+	}
+	if sampled, ok := tracing.IsSampled(span.Context()); ok {
+		return sampled
+	}
+	return true
+}
+
+// isNoopTracer reports whether tracer discards spans, so that there is no point in tagging them, logging
+// to them or propagating their context.
+func isNoopTracer(tracer opentracing.Tracer) bool {
+	_, ok := tracer.(opentracing.NoopTracer)
+	return ok
+}
+
+// tracing reports whether the span of fs keeps what is logged to it.
+func (fs *flightSpan) tracing() bool {
+	return fs.span != nil && !isNoopTracer(fs.span.Tracer())
+}
+
+// receiver returns the metrics receiver of the span, including its metric tags.
+func (fs *flightSpan) receiver() metrics.Receiver {
+	if fs.taggedMR != nil {
+		return fs.taggedMR
+	}
+	return fs.globalTags.load().receiver(fs.mr, fs.tags)
+}
+
 func (fs *flightSpan) TraceID() (string, bool) {
 	if fs.span == nil {
 		return "", false
 	}
-	if id, ok := fs.span.Context().(basictracer.SpanContext); ok {
-		return fmt.Sprintf("%032x", id.TraceID), true
-	}
-	return "", false
+	return tracing.TraceID(fs.span.Context())
 }
 
 func (fs *flightSpan) logFields(vals Vals) logging.Fields {
-	fields := make(logging.Fields, len(vals)+len(fs.tags))
+	global := fs.globalTags.load().tags
+	fields := make(logging.Fields, len(vals)+len(fs.vals)+len(fs.tags)+len(global)+len(fs.resourceTags))
+	for k, v := range fs.resourceTags {
+		fields[k] = v
+	}
+	for k, v := range global {
+		fields[k] = v
+	}
 	for k, v := range fs.tags {
 		fields[k] = v
 	}
 
+	for k, v := range fs.vals {
+		fields[k] = v
+	}
+
 	for k, v := range vals {
 		fields[k] = v
 	}
+	fields = fs.redactor.Fields(fields)
 
 	fields["eventTime"] = time.Now().Format(time.RFC3339Nano)
 	fields["serviceContext"] = map[string]interface{}{
@@ -388,8 +804,16 @@ func (fs *flightSpan) logFields(vals Vals) logging.Fields {
 	}
 
 	fields["context"] = getCallerContext(3)
-	if traceID, ok := fs.TraceID(); ok {
-		fields["trace_id"] = traceID
+	if fs.span != nil {
+		// lets logs be joined with their trace
+		sc := fs.span.Context()
+		if traceID, ok := tracing.TraceID(sc); ok {
+			spanID, _ := tracing.SpanID(sc)
+			sampled, _ := tracing.IsSampled(sc)
+			fields["trace_id"] = traceID
+			fields["span_id"] = spanID
+			fields["sampled"] = sampled
+		}
 	}
 	return fields
 }
@@ -404,23 +828,38 @@ func (fs *flightSpan) logTrace(message string, fields logging.Fields) {
 }
 
 func (fs *flightSpan) Trace(message string, vals Vals) {
-	fs.logTrace(message, fs.logFields(vals))
+	if !fs.tracing() {
+		return
+	}
+	fs.logTrace(fs.redactor.String(message), fs.logFields(vals))
 }
 
 func (fs *flightSpan) Debug(message string, vals Vals) {
+	if !fs.l.IsDebug() && !fs.tracing() {
+		return
+	}
+	message = fs.redactor.String(message)
 	fields := fs.logFields(vals)
 	fs.l.Debug(message, fields)
 	fs.logTrace(message, fields)
 }
 
 func (fs *flightSpan) Info(message string, vals Vals) {
+	if !fs.l.IsInfo() && !fs.tracing() {
+		return
+	}
+	message = fs.redactor.String(message)
 	fields := fs.logFields(vals)
 	fs.l.Info(message, fields)
 	fs.logTrace(message, fields)
 }
 
 func (fs *flightSpan) Warn(name, message string, vals Vals) {
-	fs.mr.ScopeTags(metrics.Tags{"error": "warning"}).IncrBy(name+".warning", 1)
+	fs.receiver().ScopeTags(metrics.Tags{"error": "warning"}).IncrBy(name+".warning", 1)
+	if !fs.l.IsWarn() && !fs.tracing() {
+		return
+	}
+	message = fs.redactor.String(message)
 	fields := fs.logFields(vals)
 	fields["warning_log_name"] = name
 	fs.l.Warn(message, fields)
@@ -428,34 +867,89 @@ func (fs *flightSpan) Warn(name, message string, vals Vals) {
 }
 
 func (fs *flightSpan) Critical(name, message string, vals Vals) {
-	fs.mr.ScopeTags(metrics.Tags{"error": "critical"}).IncrBy(name+".critical_error", 1)
+	fs.receiver().ScopeTags(metrics.Tags{"error": "critical"}).IncrBy(name+".critical_error", 1)
+	markFailed(fs)
+	if !fs.l.IsError() && !fs.tracing() {
+		return
+	}
+	message = fs.redactor.String(message)
 	fields := fs.logFields(vals)
 	fields["critical_log_name"] = name
 	fs.l.Error(message, fields)
 	fs.logTrace(message, fields)
 }
 
+func (fs *flightSpan) ReportError(err error) {
+	if err == nil {
+		return
+	}
+	markFailed(fs)
+	recordErrorCode(fs, err)
+	message := fs.redactor.String(err.Error())
+	fields := fs.logFields(Vals{}.WithError(err))
+	fields["error_fingerprint"] = obserr.Fingerprint(err)
+	fs.l.Error(message, fields)
+	fs.logTrace(message, fields)
+}
+
+// recordErrorCode tags the span of fs with the fingerprint of err as error_fingerprint and, if err has
+// an obserr code, with the code as error_code, and increments errors_total tagged with the code, the
+// operation of fs and whether err is retryable.
+func recordErrorCode(fs FlightSpan, err error) {
+	f, ok := fs.(*flightSpan)
+	if !ok {
+		return
+	}
+	fingerprint := obserr.Fingerprint(err)
+	if f.span != nil {
+		f.span.SetTag("error_fingerprint", fingerprint)
+	}
+	code, ok := obserr.CodeOf(err)
+	if !ok {
+		return
+	}
+	operation := f.name
+	if f.state != nil {
+		operation = f.state.operation
+	}
+	if f.span != nil {
+		f.span.SetTag("error_code", string(code))
+	}
+	tags := metrics.Tags{
+		"error_code": string(code),
+		"operation":  operation,
+		"retryable":  strconv.FormatBool(obserr.IsRetryable(err)),
+	}
+	f.receiver().ScopeTags(tags).Incr("errors_total")
+}
+
 func (fs *flightSpan) Incr(name string) {
 	fs.IncrBy(name, 1)
 }
 
 func (fs *flightSpan) IncrBy(name string, amount float64) {
-	fs.mr.IncrBy(name, amount)
-	fs.logTrace(fmt.Sprintf("Incr %s, value: %g", name, amount), nil)
+	fs.receiver().IncrBy(name, amount)
+	if fs.tracing() {
+		fs.logTrace(fmt.Sprintf("Incr %s, value: %g", name, amount), nil)
+	}
 }
 
 func (fs *flightSpan) AddStat(name string, value float64) {
-	fs.mr.AddStat(name, value)
-	fs.logTrace(fmt.Sprintf("AddStat %s, value: %g", name, value), nil)
+	fs.receiver().AddStat(name, value)
+	if fs.tracing() {
+		fs.logTrace(fmt.Sprintf("AddStat %s, value: %g", name, value), nil)
+	}
 }
 
 func (fs *flightSpan) SetGauge(name string, value float64) {
-	fs.mr.SetGauge(name, value)
-	fs.logTrace(fmt.Sprintf("SetGauge %s, value: %g", name, value), nil)
+	fs.receiver().SetGauge(name, value)
+	if fs.tracing() {
+		fs.logTrace(fmt.Sprintf("SetGauge %s, value: %g", name, value), nil)
+	}
 }
 
 func (fs *flightSpan) StartStopwatch(name string) Stopwatch {
-	return &sw{name, fs, time.Now()}
+	return &sw{name, fs, fs.clock.Now()}
 }
 
 type sw struct {
@@ -465,9 +959,11 @@ type sw struct {
 }
 
 func (s *sw) Stop() {
-	d := time.Now().Sub(s.startTime)
+	d := s.fs.clock.Since(s.startTime)
 	s.fs.AddStat(s.name+"_us", float64(d/time.Microsecond))
-	s.fs.TraceSpan().SetTag(s.name, d.String())
+	if s.fs.tracing() {
+		s.fs.span.SetTag(s.name, d.String())
+	}
 }
 
 func (t Tags) update(r Tags) {
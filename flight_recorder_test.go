@@ -1,9 +1,385 @@
 package obs
 
-import "testing"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mixpanel/obs/logging"
+	"github.com/mixpanel/obs/metrics"
+	"github.com/mixpanel/obs/obserr"
+	"github.com/mixpanel/obs/tracing"
+
+	basictracer "github.com/opentracing/basictracer-go"
+	"github.com/opentracing/opentracing-go"
+	"github.com/stretchr/testify/assert"
+)
+
+type testEntry struct {
+	level   string
+	message string
+	fields  logging.Fields
+}
+
+type testLogger struct {
+	mu      sync.Mutex
+	entries []testEntry
+}
+
+func (l *testLogger) log(level, message string, fields logging.Fields) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = append(l.entries, testEntry{level, message, fields})
+}
+
+func (l *testLogger) Debug(message string, fields logging.Fields) { l.log("DEBUG", message, fields) }
+func (l *testLogger) Info(message string, fields logging.Fields)  { l.log("INFO", message, fields) }
+func (l *testLogger) Warn(message string, fields logging.Fields)  { l.log("WARN", message, fields) }
+func (l *testLogger) Error(message string, fields logging.Fields) { l.log("ERROR", message, fields) }
+func (l *testLogger) Critical(message string, fields logging.Fields) {
+	l.log("CRITICAL", message, fields)
+}
+func (l *testLogger) IsDebug() bool                    { return true }
+func (l *testLogger) IsInfo() bool                     { return true }
+func (l *testLogger) IsWarn() bool                     { return true }
+func (l *testLogger) IsError() bool                    { return true }
+func (l *testLogger) IsCritical() bool                 { return true }
+func (l *testLogger) Named(name string) logging.Logger { return l }
+
+func newTestFlightRecorder() (FlightRecorder, *testLogger, *basictracer.InMemorySpanRecorder) {
+	l := &testLogger{}
+	recorder := basictracer.NewInMemoryRecorder()
+	opts := basictracer.DefaultOptions()
+	opts.Recorder = recorder
+	return NewFlightRecorder("test", metrics.Null, l, basictracer.NewWithOptions(opts)), l, recorder
+}
+
+func TestWithVals(t *testing.T) {
+	fr, l, recorder := newTestFlightRecorder()
+
+	fs, _, done := fr.WithNewSpan(context.Background(), "op")
+	child := fs.WithVals(Vals{"user_id": 1, "tenant": "a"})
+	child.Info("first", Vals{"tenant": "b"})
+	child.WithVals(Vals{"shard": 3}).Info("second", nil)
+	fs.Info("third", nil)
+	done()
+
+	if assert.Len(t, l.entries, 3) {
+		assert.Equal(t, 1, l.entries[0].fields["user_id"])
+		assert.Equal(t, "b", l.entries[0].fields["tenant"])
+		assert.Equal(t, "a", l.entries[1].fields["tenant"])
+		assert.Equal(t, 3, l.entries[1].fields["shard"])
+		assert.NotContains(t, l.entries[2].fields, "user_id")
+	}
+
+	spans := recorder.GetSpans()
+	if assert.Len(t, spans, 1) {
+		assert.Equal(t, 1, spans[0].Tags["user_id"])
+		assert.Equal(t, 3, spans[0].Tags["shard"])
+	}
+}
+
+func TestValsMergeWith(t *testing.T) {
+	base := Vals{"a": 1, "b": 2, "b_2": 3}
+	other := Vals{"a": 1, "b": 4, "c": 5}
+
+	assert.Equal(t, Vals{"a": 1, "b": 4, "b_2": 3, "c": 5}, base.Merge(other))
+	assert.Equal(t, Vals{"a": 1, "b": 2, "b_2": 3, "c": 5}, base.MergeWith(other, KeepExisting))
+	assert.Equal(t, Vals{"a": 1, "b": 2, "b_2": 3, "b_3": 4, "c": 5}, base.MergeWith(other, RenameConflicts))
+	assert.Equal(t, Vals{"a": 1, "b": 2, "b_2": 3}, base)
+}
+
+func TestValsPrefixed(t *testing.T) {
+	assert.Equal(t, Vals{"db_rows": 3, "db_table": "t"}, Vals{"rows": 3, "table": "t"}.Prefixed("db_"))
+	assert.Equal(t, Vals{}, Vals(nil).Prefixed("db_"))
+}
+
+func TestGo(t *testing.T) {
+	fr, _, recorder := newTestFlightRecorder()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	fs, ctx, done := fr.WithNewSpan(ctx, "request")
+	release := make(chan struct{})
+	finished := make(chan error)
+	fs.Go("background", func(ctx context.Context, fs FlightSpan) {
+		<-release
+		finished <- ctx.Err()
+	})
+	cancel()
+	done()
+	close(release)
+
+	assert.NoError(t, <-finished, "background context was canceled with the request")
+	assert.Eventually(t, func() bool { return len(recorder.GetSpans()) == 2 }, time.Second, time.Millisecond)
+	spans := recorder.GetSpans()
+	assert.Equal(t, "test.background", spans[1].Operation)
+	assert.Equal(t, spans[0].Context.TraceID, spans[1].Context.TraceID)
+}
+
+func TestWithFollowsFrom(t *testing.T) {
+	fr, _, recorder := newTestFlightRecorder()
+
+	_, ctx, done := fr.WithNewSpan(context.Background(), "request")
+	_, _, asyncDone := fr.WithFollowsFrom(ctx, "async")
+	done()
+	asyncDone()
+
+	spans := recorder.GetSpans()
+	if assert.Len(t, spans, 2) {
+		assert.Equal(t, "test.async", spans[1].Operation)
+		assert.Equal(t, spans[0].Context.TraceID, spans[1].Context.TraceID)
+		assert.Equal(t, spans[0].Context.SpanID, spans[1].ParentSpanID)
+	}
+}
+
+func TestWithMetricTags(t *testing.T) {
+	sink := &metrics.MockSink{Invocations: make(map[string]int)}
+	fr := NewFlightRecorder("test", metrics.NewReceiver(sink), &testLogger{}, opentracing.NoopTracer{})
+
+	fs := fr.WithSpan(context.Background())
+	tagged := fs.WithMetricTags(Tags{"route": "/query"}).WithVals(Vals{"a": 1}).WithMetricTags(Tags{"region": "us"})
+	tagged.Incr("requests")
+	tagged.Warn("slow", "slow request", nil)
+	fs.Incr("requests")
+
+	assert.Equal(t, 1, sink.Invocations["requests, map[region:us route:/query], 1, ct\n"])
+	assert.Equal(t, 1, sink.Invocations["slow.warning, map[error:warning region:us route:/query], 1, ct\n"])
+	assert.Equal(t, 1, sink.Invocations["requests, map[], 1, ct\n"])
+}
+
+func TestComponent(t *testing.T) {
+	sink := &metrics.MockSink{Invocations: make(map[string]int)}
+	recorder := basictracer.NewInMemoryRecorder()
+	opts := basictracer.DefaultOptions()
+	opts.Recorder = recorder
+	fr := NewFlightRecorder("service", metrics.NewReceiver(sink), &testLogger{}, basictracer.NewWithOptions(opts))
+
+	storage := fr.Component("storage")
+	assert.True(t, storage == fr.Component("storage"))
+	assert.False(t, storage == fr.ScopeName("storage"))
+
+	fs, _, done := storage.Component("cache").WithNewSpan(context.Background(), "get")
+	fs.Incr("hits")
+	done()
+
+	assert.Equal(t, 1, sink.Invocations["storage.cache.hits, map[], 1, ct\n"])
+	spans := recorder.GetSpans()
+	if assert.Len(t, spans, 1) {
+		assert.Equal(t, "service.storage.cache.get", spans[0].Operation)
+		assert.Equal(t, "storage.cache", spans[0].Tags["component"])
+	}
+	assert.Equal(t, "service.storage", storage.(*flightRecorder).name)
+}
 
 func BenchmarkGetCallerContext(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		getCallerContext(1)
 	}
 }
+
+// newUntracedFlightRecorder returns a FlightRecorder configured as a deployment without tracing, with
+// info logs disabled, for the benchmarks of the hot paths.
+func newUntracedFlightRecorder() FlightRecorder {
+	return NewFlightRecorder("bench", metrics.NewReceiver(metrics.NullSink), logging.Null, opentracing.NoopTracer{})
+}
+
+func BenchmarkUntracedWithNewSpan(b *testing.B) {
+	fr := newUntracedFlightRecorder()
+	ctx := context.Background()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, _, done := fr.WithNewSpan(ctx, "op")
+		done()
+	}
+}
+
+func BenchmarkUntracedIncr(b *testing.B) {
+	fs := newUntracedFlightRecorder().WithSpan(context.Background())
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		fs.Incr("requests")
+	}
+}
+
+func BenchmarkUntracedAddStat(b *testing.B) {
+	fs := newUntracedFlightRecorder().WithSpan(context.Background())
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		fs.AddStat("size", 42)
+	}
+}
+
+func BenchmarkUntracedDisabledInfo(b *testing.B) {
+	fs := newUntracedFlightRecorder().WithSpan(context.Background())
+	vals := Vals{"user_id": 7, "path": "/track"}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		fs.Info("handled request", vals)
+	}
+}
+
+func BenchmarkTracedWithNewSpan(b *testing.B) {
+	fr, _, _ := newTestFlightRecorder()
+	ctx := context.Background()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, _, done := fr.WithNewSpan(ctx, "op")
+		done()
+	}
+}
+
+func TestIsNoop(t *testing.T) {
+	assert.True(t, NullFR.WithSpan(context.Background()).IsNoop())
+
+	fr, _, _ := newTestFlightRecorder()
+	assert.False(t, fr.WithSpan(context.Background()).IsNoop())
+
+	for _, sampled := range []bool{true, false} {
+		opts := basictracer.DefaultOptions()
+		opts.Recorder = basictracer.NewInMemoryRecorder()
+		opts.ShouldSample = func(traceID uint64) bool { return sampled }
+		fr := NewFlightRecorder("test", metrics.Null, logging.Null, basictracer.NewWithOptions(opts))
+		fs, _, done := fr.WithNewSpan(context.Background(), "op")
+		assert.Equal(t, !sampled, fs.IsNoop())
+		done()
+	}
+}
+
+func TestReportErrorSpanTag(t *testing.T) {
+	fr, _, recorder := newTestFlightRecorder()
+	fs, _, done := fr.WithNewSpan(context.Background(), "load")
+	err := obserr.New("no such user").WithCode(obserr.NotFound)
+	fs.ReportError(err)
+	done()
+
+	spans := recorder.GetSpans()
+	if assert.Len(t, spans, 1) {
+		assert.Equal(t, "not_found", spans[0].Tags["error_code"])
+		assert.Equal(t, obserr.Fingerprint(err), spans[0].Tags["error_fingerprint"])
+	}
+}
+
+func TestReportError(t *testing.T) {
+	sink := &metrics.MockSink{Invocations: make(map[string]int)}
+	l := &testLogger{}
+	fr := NewFlightRecorder("test", metrics.NewReceiver(sink), l, opentracing.NoopTracer{})
+
+	fs, ctx, done := fr.WithNewSpan(context.Background(), "load")
+	err := obserr.New("no such user").WithCode("not_found").Set("user_id", 1)
+	fs.ReportError(err)
+	fr.WithSpan(ctx).ReportError(errors.New("no code"))
+	fs.ReportError(nil)
+	done()
+
+	fingerprint := obserr.Fingerprint(err)
+	assert.Equal(t, 1, sink.Invocations["errors_total, map[error_code:not_found operation:test.load retryable:false], 1, ct\n"])
+	if assert.Len(t, l.entries, 2) {
+		assert.Equal(t, "ERROR", l.entries[0].level)
+		assert.Equal(t, 1, l.entries[0].fields["user_id"])
+		assert.Equal(t, fingerprint, l.entries[0].fields["error_fingerprint"])
+		assert.Equal(t, "no code", l.entries[1].message)
+		assert.Equal(t, obserr.Fingerprint(errors.New("no code")), l.entries[1].fields["error_fingerprint"])
+	}
+}
+
+func TestCriticalMarksSpanFailed(t *testing.T) {
+	fr, _, recorder := newTestFlightRecorder()
+	fs, ctx, done := fr.WithNewSpan(context.Background(), "load")
+	fs.Warn("load", "slow backend", nil)
+	fr.WithSpan(ctx).Critical("load", "no backend", Vals{"backend": "db"})
+	done()
+	fr.WithSpan(context.Background()).Critical("background", "no span", nil)
+
+	_, _, done = fr.WithNewSpan(context.Background(), "ok")
+	done()
+
+	spans := recorder.GetSpans()
+	if assert.Len(t, spans, 2) {
+		assert.Equal(t, true, spans[0].Tags["error"])
+		if assert.True(t, len(spans[0].Logs) > 1) {
+			assert.Equal(t, "no backend", spans[0].Logs[1].Fields[0].Value())
+		}
+		assert.NotContains(t, spans[1].Tags, "error")
+	}
+}
+
+func TestLogTraceFields(t *testing.T) {
+	l := &testLogger{}
+	opts := basictracer.DefaultOptions()
+	opts.Recorder = basictracer.NewInMemoryRecorder()
+	opts.ShouldSample = func(uint64) bool { return true }
+	fr := NewFlightRecorder("test", metrics.Null, l, basictracer.NewWithOptions(opts))
+
+	fs, _, done := fr.WithNewSpan(context.Background(), "load")
+	fs.Info("loading", nil)
+	done()
+	fr.WithSpan(context.Background()).Info("no span", nil)
+
+	if assert.Len(t, l.entries, 2) {
+		sc := fs.TraceSpan().Context().(basictracer.SpanContext)
+		assert.Equal(t, fmt.Sprintf("%032x", sc.TraceID), l.entries[0].fields["trace_id"])
+		assert.Equal(t, fmt.Sprintf("%016x", sc.SpanID), l.entries[0].fields["span_id"])
+		assert.Equal(t, true, l.entries[0].fields["sampled"])
+		assert.NotContains(t, l.entries[1].fields, "trace_id")
+		assert.NotContains(t, l.entries[1].fields, "span_id")
+		assert.NotContains(t, l.entries[1].fields, "sampled")
+	}
+}
+
+func TestLogTraceFieldsJaeger(t *testing.T) {
+	l := &testLogger{}
+	tracer, closeTracer, err := tracing.NewJaeger("test", "", tracing.ConstSampler(false),
+		tracing.JaegerIDs(tracing.SequentialIDs(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeTracer()
+	fr := NewFlightRecorder("test", metrics.Null, l, tracer)
+
+	fs, _, done := fr.WithNewSpan(context.Background(), "load")
+	defer done()
+	fs.Info("loading", nil)
+	assert.False(t, isRecording(fs.TraceSpan()), "unsampled jaeger spans are not recorded")
+	if assert.Len(t, l.entries, 1) {
+		assert.Equal(t, "00000000000000000000000000000002", l.entries[0].fields["trace_id"])
+		assert.Equal(t, "0000000000000002", l.entries[0].fields["span_id"])
+		assert.Equal(t, false, l.entries[0].fields["sampled"])
+	}
+}
+
+func TestGlobalTags(t *testing.T) {
+	sink := metrics.NewMockSink()
+	l := &testLogger{}
+	recorder := basictracer.NewInMemoryRecorder()
+	opts := basictracer.DefaultOptions()
+	opts.Recorder = recorder
+	fr := NewFlightRecorder("test", metrics.NewReceiver(sink), l, basictracer.NewWithOptions(opts))
+	scoped := fr.ScopeTags(Tags{"region": "us"})
+
+	fr.SetGlobalTag("role", "follower")
+	scoped.SetGlobalTag("region", "eu")
+	fs, _, done := scoped.WithNewSpan(context.Background(), "op")
+	fs.Incr("requests")
+	fs.Info("elected", nil)
+	done()
+
+	fr.SetGlobalTag("role", "leader")
+	fs.Incr("requests")
+	fr.DeleteGlobalTag("role")
+	fr.GetReceiver().Incr("requests")
+
+	assert.Equal(t, 1, sink.Invocations["requests, map[region:us role:follower], 1, ct\n"])
+	assert.Equal(t, 1, sink.Invocations["requests, map[region:us role:leader], 1, ct\n"])
+	assert.Equal(t, 1, sink.Invocations["requests, map[region:eu], 1, ct\n"])
+	if assert.Len(t, l.entries, 1) {
+		assert.Equal(t, "follower", l.entries[0].fields["role"])
+		assert.Equal(t, "us", l.entries[0].fields["region"])
+	}
+	if spans := recorder.GetSpans(); assert.Len(t, spans, 1) {
+		assert.Equal(t, "follower", spans[0].Tags["role"])
+		assert.Equal(t, "us", spans[0].Tags["region"])
+	}
+}
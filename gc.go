@@ -4,10 +4,11 @@ import (
 	"runtime"
 	"time"
 
+	"github.com/mixpanel/obs/clock"
 	"github.com/mixpanel/obs/metrics"
 )
 
-func reportGCMetrics(interval time.Duration, done <-chan struct{}, r metrics.Receiver) {
+func reportGCMetrics(interval time.Duration, done <-chan struct{}, r metrics.Receiver, c clock.Clock) {
 	r = r.ScopePrefix("gc")
 	numGCs := uint32(0)
 
@@ -17,7 +18,7 @@ func reportGCMetrics(interval time.Duration, done <-chan struct{}, r metrics.Rec
 			select {
 			case <-done:
 				return
-			case _ = <-time.After(interval):
+			case _ = <-c.After(interval):
 				numGCs = reportGCsSince(memstats, numGCs, r)
 			}
 		}
@@ -0,0 +1,73 @@
+package obs
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/mixpanel/obs/metrics"
+)
+
+// globalTags holds the tags set with SetGlobalTag. They are shared by a FlightRecorder and every
+// FlightRecorder scoped from it. Writers replace the snapshot, so readers never wait.
+type globalTags struct {
+	mu       sync.Mutex // serializes writers
+	snapshot atomic.Value
+}
+
+// tagSnapshot is an immutable set of global tags.
+type tagSnapshot struct {
+	tags Tags
+
+	// receivers caches the receivers scoped with the tags, keyed by the receiver they scope.
+	receivers sync.Map
+}
+
+func newGlobalTags() *globalTags {
+	g := &globalTags{}
+	g.snapshot.Store(&tagSnapshot{})
+	return g
+}
+
+func (g *globalTags) load() *tagSnapshot {
+	return g.snapshot.Load().(*tagSnapshot)
+}
+
+// update replaces the snapshot with a copy of it modified by f.
+func (g *globalTags) update(f func(Tags)) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	tags := make(Tags, len(g.load().tags)+1)
+	tags.update(g.load().tags)
+	f(tags)
+	g.snapshot.Store(&tagSnapshot{tags: tags})
+}
+
+// receiver returns r scoped with the global tags, except those overridden by own.
+func (s *tagSnapshot) receiver(r metrics.Receiver, own Tags) metrics.Receiver {
+	if len(s.tags) == 0 {
+		return r
+	}
+	if scoped, ok := s.receivers.Load(r); ok {
+		return scoped.(metrics.Receiver)
+	}
+	metricTags := make(metrics.Tags, len(s.tags))
+	for k, v := range s.tags {
+		if _, ok := own[k]; !ok {
+			metricTags[k] = v
+		}
+	}
+	scoped, _ := s.receivers.LoadOrStore(r, r.ScopeTags(metricTags))
+	return scoped.(metrics.Receiver)
+}
+
+func (fr *flightRecorder) SetGlobalTag(k, v string) {
+	fr.globalTags.update(func(tags Tags) {
+		tags[k] = v
+	})
+}
+
+func (fr *flightRecorder) DeleteGlobalTag(k string) {
+	fr.globalTags.update(func(tags Tags) {
+		delete(tags, k)
+	})
+}
@@ -0,0 +1,211 @@
+package obs
+
+import (
+	"bytes"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mixpanel/obs/clock"
+	"github.com/mixpanel/obs/logging"
+	"github.com/mixpanel/obs/metrics"
+)
+
+// GoroutineReporting configures how goroutines are reported by InitGCP; see ReportGoroutines.
+type GoroutineReporting struct {
+	// Interval between two samples. Defaults to one minute.
+	Interval time.Duration
+	// TopSites is the number of creation sites whose goroutines are counted. Sampling the sites requires
+	// dumping the stacks of every goroutine, which stops the world for a time proportional to their
+	// number, so it is disabled by default; 0 disables it.
+	TopSites int
+	// GrowthWindow is how long the number of goroutines must grow at every sample before a warning is
+	// logged. Defaults to fifteen minutes.
+	GrowthWindow time.Duration
+}
+
+// DefaultGoroutineReporting is how goroutines are reported unless ReportGoroutines is used.
+var DefaultGoroutineReporting = GoroutineReporting{
+	Interval:     time.Minute,
+	GrowthWindow: 15 * time.Minute,
+}
+
+// ReportGoroutines configures the goroutine metrics reported with the standard metrics:
+//
+//	goroutines_total    the number of goroutines
+//	goroutines_blocked  the goroutines blocked for a minute or more, such as on a channel or a lock
+//	goroutines.by_site  the goroutines started by each of the top functions, tagged with site
+//
+// The last two are only reported when TopSites is set. A warning is logged when the number of
+// goroutines grew at every sample for the growth window, which usually means they leak.
+func ReportGoroutines(cfg GoroutineReporting) Option {
+	return func(o *obsOptions) {
+		o.goroutines = &cfg
+	}
+}
+
+type goroutineReporter struct {
+	cfg GoroutineReporting
+	r   metrics.Receiver
+	l   logging.Logger
+
+	count func() int
+	stack func() []byte
+
+	// samples are the counts of the current growth window, growing at every sample.
+	samples []int
+	// sites were reported by the last sample, to reset those dropping out of the top.
+	sites map[string]bool
+}
+
+func reportGoroutines(cfg GoroutineReporting, done <-chan struct{}, r metrics.Receiver, l logging.Logger, c clock.Clock) {
+	g := newGoroutineReporter(cfg, r, l)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-c.After(g.cfg.Interval):
+				g.report()
+			}
+		}
+	}()
+}
+
+func newGoroutineReporter(cfg GoroutineReporting, r metrics.Receiver, l logging.Logger) *goroutineReporter {
+	if cfg.Interval <= 0 {
+		cfg.Interval = DefaultGoroutineReporting.Interval
+	}
+	if cfg.GrowthWindow <= 0 {
+		cfg.GrowthWindow = DefaultGoroutineReporting.GrowthWindow
+	}
+	return &goroutineReporter{
+		cfg:   cfg,
+		r:     r,
+		l:     l,
+		count: runtime.NumGoroutine,
+		stack: allStacks,
+		sites: make(map[string]bool),
+	}
+}
+
+func (g *goroutineReporter) report() {
+	n := g.count()
+	g.r.SetGauge("goroutines_total", float64(n))
+
+	var top []goroutineSite
+	if g.cfg.TopSites > 0 {
+		sites, blocked := parseGoroutineStacks(g.stack())
+		g.r.SetGauge("goroutines_blocked", float64(blocked))
+		top = topGoroutineSites(sites, g.cfg.TopSites)
+
+		reported := make(map[string]bool, len(top))
+		for _, s := range top {
+			g.r.ScopeTags(metrics.Tags{"site": s.site}).SetGauge("goroutines.by_site", float64(s.count))
+			reported[s.site] = true
+		}
+		for site := range g.sites {
+			if !reported[site] {
+				g.r.ScopeTags(metrics.Tags{"site": site}).SetGauge("goroutines.by_site", 0)
+			}
+		}
+		g.sites = reported
+	}
+
+	if len(g.samples) > 0 && n <= g.samples[len(g.samples)-1] {
+		g.samples = g.samples[:0]
+	}
+	g.samples = append(g.samples, n)
+	if time.Duration(len(g.samples)-1)*g.cfg.Interval < g.cfg.GrowthWindow {
+		return
+	}
+	fields := logging.Fields{
+		"from":             g.samples[0],
+		"to":               n,
+		"growth_window_us": int64(g.cfg.GrowthWindow / time.Microsecond),
+	}
+	if len(top) > 0 {
+		sites := logging.Fields{}
+		for _, s := range top {
+			sites[s.site] = s.count
+		}
+		fields["top_sites"] = sites
+	}
+	g.l.Warn("goroutines keep growing, they may leak", fields)
+	// warn at most once per window
+	g.samples = append(g.samples[:0], n)
+}
+
+type goroutineSite struct {
+	site  string
+	count int
+}
+
+// topGoroutineSites returns the n sites with the most goroutines, in decreasing order.
+func topGoroutineSites(sites map[string]int, n int) []goroutineSite {
+	top := make([]goroutineSite, 0, len(sites))
+	for site, count := range sites {
+		top = append(top, goroutineSite{site, count})
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].count != top[j].count {
+			return top[i].count > top[j].count
+		}
+		return top[i].site < top[j].site
+	})
+	if len(top) > n {
+		top = top[:n]
+	}
+	return top
+}
+
+// parseGoroutineStacks counts the goroutines of a dump of runtime.Stack by the function that created
+// them, and the goroutines blocked for a minute or more, which the runtime reports in their header:
+//
+//	goroutine 8 [chan receive, 3 minutes]:
+//	main.main.func2()
+//		/src/main.go:12 +0x19
+//	created by main.main in goroutine 1
+//		/src/main.go:10 +0x78
+func parseGoroutineStacks(dump []byte) (map[string]int, int) {
+	sites := make(map[string]int)
+	blocked := 0
+	for _, g := range bytes.Split(dump, []byte("\n\n")) {
+		lines := strings.Split(string(bytes.TrimSpace(g)), "\n")
+		if len(lines) == 0 || !strings.HasPrefix(lines[0], "goroutine ") {
+			continue
+		}
+		if strings.Contains(lines[0], " minutes") || strings.Contains(lines[0], " minute]") || strings.Contains(lines[0], " minute,") {
+			blocked++
+		}
+		site := "none"
+		for _, line := range lines[1:] {
+			if strings.HasPrefix(line, "created by ") {
+				site = strings.TrimPrefix(line, "created by ")
+				if i := strings.Index(site, " in goroutine "); i >= 0 {
+					site = site[:i]
+				}
+				break
+			}
+		}
+		sites[site]++
+	}
+	return sites, blocked
+}
+
+// maxGoroutineDump bounds the memory taken by the stacks dumped by allStacks.
+const maxGoroutineDump = 64 << 20
+
+// allStacks returns the stacks of all goroutines, as formatted by runtime.Stack. The dump is truncated
+// to maxGoroutineDump bytes, leaving the goroutines beyond it uncounted.
+func allStacks() []byte {
+	buf := make([]byte, 1<<20)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) || len(buf) >= maxGoroutineDump {
+			return buf[:n]
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
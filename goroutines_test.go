@@ -0,0 +1,69 @@
+package obs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mixpanel/obs/metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+const testGoroutineDump = `goroutine 1 [running]:
+main.main()
+	/src/main.go:3 +0xae
+
+goroutine 7 [sleep, 2 minutes]:
+time.Sleep(0x34630b8a000)
+	/usr/local/go/src/runtime/time.go:368 +0x165
+main.main.func1()
+	/src/main.go:8 +0x1d
+created by main.main in goroutine 1
+	/src/main.go:7 +0x1e
+
+goroutine 8 [chan receive, 1 minute, locked to thread]:
+main.worker()
+	/src/main.go:12 +0x19
+created by main.main
+	/src/main.go:10 +0x78
+
+goroutine 9 [select]:
+main.poll()
+	/src/main.go:20 +0x19
+created by main.start in goroutine 1
+	/src/main.go:18 +0x78
+`
+
+func TestParseGoroutineStacks(t *testing.T) {
+	sites, blocked := parseGoroutineStacks([]byte(testGoroutineDump))
+	assert.Equal(t, map[string]int{"none": 1, "main.main": 2, "main.start": 1}, sites)
+	assert.Equal(t, 2, blocked)
+
+	assert.Equal(t, []goroutineSite{{"main.main", 2}, {"main.start", 1}}, topGoroutineSites(sites, 2))
+}
+
+func TestGoroutineReporter(t *testing.T) {
+	sink := metrics.NewMockSink()
+	l := &testLogger{}
+	g := newGoroutineReporter(GoroutineReporting{Interval: time.Minute, TopSites: 1, GrowthWindow: 3 * time.Minute},
+		metrics.NewReceiver(sink), l)
+	counts := []int{10, 12, 11, 13, 14, 15, 16, 17}
+	g.count = func() int {
+		n := counts[0]
+		counts = counts[1:]
+		return n
+	}
+	g.stack = func() []byte { return []byte(testGoroutineDump) }
+
+	for range counts {
+		g.report()
+	}
+
+	assert.Equal(t, 1, sink.Invocations["goroutines_total, map[], 17, g\n"])
+	assert.Equal(t, 8, sink.Invocations["goroutines_blocked, map[], 2, g\n"])
+	assert.Equal(t, 8, sink.Invocations["goroutines.by_site, map[site:main.main], 2, g\n"])
+	if assert.Len(t, l.entries, 1) {
+		assert.Equal(t, "WARN", l.entries[0].level)
+		assert.Equal(t, 11, l.entries[0].fields["from"])
+		assert.Equal(t, 15, l.entries[0].fields["to"])
+	}
+}
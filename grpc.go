@@ -3,8 +3,12 @@ package obs
 import (
 	"fmt"
 	"io"
-	"os"
+	"net"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/mixpanel/obs/tracing"
 
@@ -13,16 +17,303 @@ import (
 	opentracing "github.com/opentracing/opentracing-go"
 	"github.com/opentracing/opentracing-go/ext"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/stats"
+	"google.golang.org/grpc/status"
 )
 
-var traceHostname string
+// GRPCOption configures the interceptors returned by the GRPC* methods of a FlightRecorder.
+type GRPCOption func(*grpcOptions)
 
-func init() {
-	traceHostname, _ = os.Hostname()
+type grpcOptions struct {
+	skipped      methodMatcher
+	untraced     methodMatcher
+	serverTiming bool
+	payloads     *payloadLogger
+	attempts     bool
+	callerTag    bool
+	// callerApps are the applications named by user agents that the caller tag may take.
+	callerApps map[string]bool
+
+	preRegisteredCodes []codes.Code
+
+	streamMessages *StreamMessageSpans
+}
+
+// methodMatcher matches full method names against a set of names and patterns.
+type methodMatcher struct {
+	methods  map[string]struct{}
+	patterns []*regexp.Regexp
+}
+
+func (m *methodMatcher) add(methods []string) {
+	if m.methods == nil {
+		m.methods = make(map[string]struct{}, len(methods))
+	}
+	for _, method := range methods {
+		m.methods[method] = struct{}{}
+	}
+}
+
+func (m *methodMatcher) match(method string) bool {
+	if _, ok := m.methods[method]; ok {
+		return true
+	}
+	for _, re := range m.patterns {
+		if re.MatchString(method) {
+			return true
+		}
+	}
+	return false
+}
+
+// HealthAndReflectionMethods are the full method names of the standard gRPC health and
+// reflection services, for use with GRPCSkipMethods.
+var HealthAndReflectionMethods = []string{
+	"/grpc.health.v1.Health/Check",
+	"/grpc.health.v1.Health/Watch",
+	"/grpc.reflection.v1alpha.ServerReflection/ServerReflectionInfo",
+	"/grpc.reflection.v1.ServerReflection/ServerReflectionInfo",
+}
+
+// GRPCSkipMethods disables tracing and metrics for the given full method names,
+// for example "/grpc.health.v1.Health/Check".
+func GRPCSkipMethods(methods ...string) GRPCOption {
+	return func(o *grpcOptions) {
+		o.skipped.add(methods)
+	}
+}
+
+// GRPCSkipMethodsMatching disables tracing and metrics for full method names matching re.
+func GRPCSkipMethodsMatching(re *regexp.Regexp) GRPCOption {
+	return func(o *grpcOptions) {
+		o.skipped.patterns = append(o.skipped.patterns, re)
+	}
+}
+
+// GRPCUntracedMethods disables tracing for the given full method names, such as
+// HealthAndReflectionMethods, while still reporting their metrics: their spans get a sampling
+// priority of 0, so that neither they nor the spans started under them are sampled.
+func GRPCUntracedMethods(methods ...string) GRPCOption {
+	return func(o *grpcOptions) {
+		o.untraced.add(methods)
+	}
+}
+
+// GRPCUntracedMethodsMatching disables tracing, but not metrics, for full method names matching re.
+func GRPCUntracedMethodsMatching(re *regexp.Regexp) GRPCOption {
+	return func(o *grpcOptions) {
+		o.untraced.patterns = append(o.untraced.patterns, re)
+	}
+}
+
+// GRPCServerTiming makes server interceptors report how long the server spent on each RPC in the
+// response trailers. Client interceptors tag their spans with these timings, along with the remaining
+// network time, so that client observed latency can be attributed. The time an RPC waited between its
+// arrival and the interceptor is reported as queue time when the server is built with GRPCServerOptions.
+func GRPCServerTiming() GRPCOption {
+	return func(o *grpcOptions) {
+		o.serverTiming = true
+	}
+}
+
+const (
+	serverTimeTrailer  = "obs-server-time-us"
+	serverQueueTrailer = "obs-server-queue-us"
+)
+
+type rpcBeginKey struct{}
+
+// rpcBeginHandler is a stats.Handler that records when each RPC arrived, for queue time.
+type rpcBeginHandler struct{}
+
+func (rpcBeginHandler) TagRPC(ctx context.Context, info *stats.RPCTagInfo) context.Context {
+	return context.WithValue(ctx, rpcBeginKey{}, time.Now())
+}
+
+func (rpcBeginHandler) HandleRPC(context.Context, stats.RPCStats) {}
+
+func (rpcBeginHandler) TagConn(ctx context.Context, info *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (rpcBeginHandler) HandleConn(context.Context, stats.ConnStats) {}
+
+// serverTiming returns the trailers reporting the server time of an RPC that reached the interceptor at start.
+func serverTiming(ctx context.Context, start time.Time) metadata.MD {
+	md := metadata.Pairs(serverTimeTrailer, strconv.FormatInt(int64(time.Since(start)/time.Microsecond), 10))
+	if begin, ok := ctx.Value(rpcBeginKey{}).(time.Time); ok {
+		md.Set(serverQueueTrailer, strconv.FormatInt(int64(start.Sub(begin)/time.Microsecond), 10))
+	}
+	return md
+}
+
+// tagServerTiming tags span with the server timings found in trailer, for an RPC that took elapsed
+// from the client's point of view.
+func tagServerTiming(span opentracing.Span, trailer metadata.MD, elapsed time.Duration) {
+	serverUs, ok := trailerInt(trailer, serverTimeTrailer)
+	if !ok {
+		return
+	}
+	span.SetTag("grpc.server_time_us", serverUs)
+	networkUs := int64(elapsed/time.Microsecond) - serverUs
+	if queueUs, ok := trailerInt(trailer, serverQueueTrailer); ok {
+		span.SetTag("grpc.server_queue_us", queueUs)
+		networkUs -= queueUs
+	}
+	if networkUs < 0 {
+		networkUs = 0
+	}
+	span.SetTag("grpc.network_time_us", networkUs)
+}
+
+func trailerInt(md metadata.MD, key string) (int64, bool) {
+	vs := md.Get(key)
+	if len(vs) == 0 {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(vs[0], 10, 64)
+	return n, err == nil
+}
+
+func newGRPCOptions(opts []GRPCOption) *grpcOptions {
+	o := &grpcOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+func (o *grpcOptions) skip(method string) bool {
+	return o.skipped.match(method)
+}
+
+// parentSpanContext returns the context of the span in ctx, or nil if there is none.
+func parentSpanContext(ctx context.Context) opentracing.SpanContext {
+	if span := opentracing.SpanFromContext(ctx); span != nil {
+		return span.Context()
+	}
+	return nil
+}
+
+// injectSpan returns ctx with the context of the span of fs added to its outgoing metadata, unless
+// tracer discards spans.
+func injectSpan(ctx context.Context, fs FlightSpan, tracer opentracing.Tracer) context.Context {
+	if isNoopTracer(tracer) {
+		return ctx
+	}
+	md, ok := metadata.FromOutgoingContext(ctx)
+	if !ok {
+		md = metadata.New(nil)
+	} else {
+		md = md.Copy()
+	}
+
+	if err := tracer.Inject(fs.TraceSpan().Context(), opentracing.TextMap, grpcTraceMD(md)); err != nil {
+		fs.Warn("tracer_inject", "error injecting trace metadata", Vals{}.WithError(err))
+	}
+	return metadata.NewOutgoingContext(ctx, md)
+}
+
+// extractSpanContext returns the span context in the incoming metadata of ctx, or
+// opentracing.ErrSpanContextNotFound, without reading the metadata if tracer discards spans.
+func extractSpanContext(ctx context.Context, tracer opentracing.Tracer) (opentracing.SpanContext, error) {
+	if isNoopTracer(tracer) {
+		return nil, opentracing.ErrSpanContextNotFound
+	}
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		md = metadata.New(nil)
+	}
+	return tracer.Extract(opentracing.TextMap, grpcTraceMD(md))
+}
+
+// startSpan starts the span of an RPC with fr, with a sampling priority of 0 if method is untraced.
+func (o *grpcOptions) startSpan(ctx context.Context, fr FlightRecorder, method string, spanCtx opentracing.SpanContext) (FlightSpan, context.Context, DoneFunc) {
+	untraced := o.untraced.match(method)
+	if untraced {
+		ctx = ContextWithSamplingPriority(ctx, SamplingPriorityDrop)
+	}
+	fs, ctx, done := fr.WithNewSpanContext(ctx, formatRPCName(method), spanCtx)
+	if untraced && spanCtx != nil {
+		// the priority in ctx only applies to root spans
+		fs.SetSamplingPriority(SamplingPriorityDrop)
+	}
+	return fs, ctx, done
+}
+
+// GRPCDialOptions returns the dial options that instrument both unary and streaming RPCs of a client
+// with fr. The interceptors are chained, so they compose with interceptors installed by other
+// grpc.WithChainUnaryInterceptor and grpc.WithChainStreamInterceptor options.
+func GRPCDialOptions(fr FlightRecorder, opts ...GRPCOption) []grpc.DialOption {
+	o := newGRPCOptions(opts)
+	tracer := tracerOf(fr)
+	dialOpts := []grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(tracingUnaryClientInterceptor(fr, tracer, o)),
+		grpc.WithChainStreamInterceptor(tracingStreamClientInterceptor(fr, tracer, o)),
+	}
+	if o.attempts {
+		dialOpts = append(dialOpts, grpc.WithStatsHandler(rpcAttemptsHandler{}))
+	}
+	return dialOpts
+}
+
+// GRPCServerOptions returns the server options that instrument both unary and streaming RPCs of a server
+// with fr, followed by the given interceptors. A server accepts a single interceptor of each kind, so any
+// other interceptor has to be passed here rather than with grpc.UnaryInterceptor or grpc.StreamInterceptor.
+// The fr interceptors run first, so that the spans they start are visible to the other interceptors.
+// opts configure the fr interceptors.
+func GRPCServerOptions(fr FlightRecorder, unary []grpc.UnaryServerInterceptor, stream []grpc.StreamServerInterceptor, opts ...GRPCOption) []grpc.ServerOption {
+	o := newGRPCOptions(opts)
+	tracer := tracerOf(fr)
+	unary = append([]grpc.UnaryServerInterceptor{tracingUnaryServerInterceptor(fr, tracer, o)}, unary...)
+	stream = append([]grpc.StreamServerInterceptor{tracingStreamServerInterceptor(fr, tracer, o)}, stream...)
+	serverOpts := []grpc.ServerOption{
+		grpc.UnaryInterceptor(chainUnaryServerInterceptors(unary)),
+		grpc.StreamInterceptor(chainStreamServerInterceptors(stream)),
+	}
+	if o.serverTiming {
+		serverOpts = append(serverOpts, grpc.StatsHandler(rpcBeginHandler{}))
+	}
+	return serverOpts
+}
+
+// tracerOf returns the tracer of the spans of fr.
+func tracerOf(fr FlightRecorder) opentracing.Tracer {
+	if f, ok := fr.(*flightRecorder); ok {
+		return f.tr
+	}
+	return fr.WithSpan(context.Background()).TraceSpan().Tracer()
+}
+
+func chainUnaryServerInterceptors(interceptors []grpc.UnaryServerInterceptor) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		chained := handler
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			interceptor, next := interceptors[i], chained
+			chained = func(ctx context.Context, req interface{}) (interface{}, error) {
+				return interceptor(ctx, req, info, next)
+			}
+		}
+		return chained(ctx, req)
+	}
+}
+
+func chainStreamServerInterceptors(interceptors []grpc.StreamServerInterceptor) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		chained := handler
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			interceptor, next := interceptors[i], chained
+			chained = func(srv interface{}, ss grpc.ServerStream) error {
+				return interceptor(srv, ss, info, next)
+			}
+		}
+		return chained(srv, ss)
+	}
 }
 
-func tracingUnaryClientInterceptor(fr FlightRecorder, tracer opentracing.Tracer) grpc.UnaryClientInterceptor {
+func tracingUnaryClientInterceptor(fr FlightRecorder, tracer opentracing.Tracer, o *grpcOptions) grpc.UnaryClientInterceptor {
 	return func(
 		ctx context.Context,
 		method string,
@@ -31,31 +322,40 @@ func tracingUnaryClientInterceptor(fr FlightRecorder, tracer opentracing.Tracer)
 		invoker grpc.UnaryInvoker,
 		opts ...grpc.CallOption,
 	) error {
+		if o.skip(method) {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+
 		obsName := formatRPCName(method)
-		fs, ctx, done := fr.WithNewSpan(ctx, obsName)
+		fs, ctx, done := o.startSpan(ctx, fr, method, parentSpanContext(ctx))
 		defer done()
 		span := fs.TraceSpan()
 		ext.SpanKind.Set(span, ext.SpanKindRPCClientEnum)
 
-		md, ok := metadata.FromOutgoingContext(ctx)
-		if !ok {
-			md = metadata.New(nil)
-		} else {
-			md = md.Copy()
-		}
+		ctx = injectSpan(ctx, fs, tracer)
 
-		if err := tracer.Inject(span.Context(), opentracing.TextMap, grpcTraceMD(md)); err != nil {
-			fs.Warn("tracer_inject", "error injecting trace metadata", Vals{}.WithError(err))
+		logPayloads := o.payloads.enabled(fs, method)
+		if logPayloads {
+			o.payloads.log(fs, method, "request", req)
 		}
 
-		ctx = metadata.NewOutgoingContext(ctx, md)
-
-		err := invoker(ctx, method, req, reply, cc, opts...)
-		fs.Incr(fmt.Sprintf("grpc_client.%s.%s", obsName, grpc.Code(err).String()))
+		ctx, attempts := o.withRPCAttempts(ctx, fr, obsName)
+		var trailer metadata.MD
+		start := time.Now()
+		err := invoker(ctx, method, req, reply, cc, append(opts, grpc.Trailer(&trailer))...)
+		elapsed := time.Since(start)
+		attempts.finish(fs, obsName)
+		tagServerTiming(span, trailer, elapsed)
+		recordClientLatency(fs, clientTarget(cc), obsName, elapsed)
+		if logPayloads && err == nil {
+			o.payloads.log(fs, method, "response", reply)
+		}
+		fs.Incr(fmt.Sprintf("grpc_client.%s.%s", obsName, status.Code(err).String()))
 		if err != nil {
+			recordErrorCode(fs, err)
 			if ctx.Err() == nil {
 				fs.Trace(fmt.Sprintf("error in gRPC %s", method), Vals{}.WithError(err))
-				ext.Error.Set(span, true)
+				markFailed(fs)
 			} else {
 				span.SetTag("canceled", true)
 			}
@@ -66,7 +366,7 @@ func tracingUnaryClientInterceptor(fr FlightRecorder, tracer opentracing.Tracer)
 	}
 }
 
-func tracingStreamClientInterceptor(fr FlightRecorder, tracer opentracing.Tracer) grpc.StreamClientInterceptor {
+func tracingStreamClientInterceptor(fr FlightRecorder, tracer opentracing.Tracer, o *grpcOptions) grpc.StreamClientInterceptor {
 	return func(
 		ctx context.Context,
 		desc *grpc.StreamDesc,
@@ -75,75 +375,102 @@ func tracingStreamClientInterceptor(fr FlightRecorder, tracer opentracing.Tracer
 		streamer grpc.Streamer,
 		opts ...grpc.CallOption,
 	) (grpc.ClientStream, error) {
+		if o.skip(method) {
+			return streamer(ctx, desc, cc, method, opts...)
+		}
+
 		obsName := formatRPCName(method)
-		fs, ctx, done := fr.WithNewSpan(ctx, obsName)
+		fs, ctx, done := o.startSpan(ctx, fr, method, parentSpanContext(ctx))
 		span := fs.TraceSpan()
 		ext.SpanKind.Set(span, ext.SpanKindRPCClientEnum)
 
-		md, ok := metadata.FromOutgoingContext(ctx)
-		if !ok {
-			md = metadata.New(nil)
-		} else {
-			md = md.Copy()
-		}
+		ctx = injectSpan(ctx, fs, tracer)
 
-		if err := tracer.Inject(span.Context(), opentracing.TextMap, grpcTraceMD(md)); err != nil {
-			fs.Warn("tracer_inject", "error injecting trace metadata", Vals{}.WithError(err))
+		ctx, attempts := o.withRPCAttempts(ctx, fr, obsName)
+		if attempts != nil {
+			spanDone := done
+			done = func() {
+				attempts.finish(fs, obsName)
+				spanDone()
+			}
 		}
 
-		ctx = metadata.NewOutgoingContext(ctx, md)
-
+		target := clientTarget(cc)
+		start := time.Now()
 		cs, err := streamer(ctx, desc, cc, method, opts...)
 
-		fs.Incr(fmt.Sprintf("grpc_client.%s.%s", obsName, grpc.Code(err).String()))
+		fs.Incr(fmt.Sprintf("grpc_client.%s.%s", obsName, status.Code(err).String()))
 
 		if err != nil {
-			if ctx.Err() == nil {
-				fs.Trace(fmt.Sprintf("error in gRPC %s", method), Vals{}.WithError(err))
-				ext.Error.Set(span, true)
-			} else {
-				span.SetTag("canceled", true)
-			}
+			recordClientLatency(fs, target, obsName, time.Since(start))
+			recordStreamError(ctx, fs, method, err)
+			done()
+			return nil, err
 		}
 
-		return &clientStreamInterceptor{cs, span, done, 0, 0}, err
+		return &clientStreamInterceptor{cs: cs, ctx: ctx, fs: fs, span: span, done: done, start: start,
+			target: target, method: obsName, fullMethod: method, messages: o.newStreamMessages(tracer, span, method)}, nil
+	}
+}
+
+// recordStreamError records err, which ended a client stream, on its span, unless the stream was
+// canceled.
+func recordStreamError(ctx context.Context, fs FlightSpan, method string, err error) {
+	recordErrorCode(fs, err)
+	if ctx.Err() == nil {
+		fs.Trace(fmt.Sprintf("error in gRPC %s", method), Vals{}.WithError(err))
+		markFailed(fs)
+	} else {
+		fs.TraceSpan().SetTag("canceled", true)
 	}
 }
 
-func tracingUnaryServerInterceptor(fr FlightRecorder, tracer opentracing.Tracer) grpc.UnaryServerInterceptor {
+func tracingUnaryServerInterceptor(fr FlightRecorder, tracer opentracing.Tracer, o *grpcOptions) grpc.UnaryServerInterceptor {
 	return func(
 		ctx context.Context,
 		req interface{},
 		info *grpc.UnaryServerInfo,
 		handler grpc.UnaryHandler,
 	) (resp interface{}, err error) {
-		obsName := formatRPCName(info.FullMethod)
-		md, ok := metadata.FromIncomingContext(ctx)
-		if !ok {
-			md = metadata.New(nil)
+		if o.skip(info.FullMethod) {
+			return handler(ctx, req)
 		}
 
-		spanCtx, err := tracer.Extract(opentracing.TextMap, grpcTraceMD(md))
+		start := time.Now()
+		obsName := formatRPCName(info.FullMethod)
+		spanCtx, err := extractSpanContext(ctx, tracer)
 
-		fs, ctx, done := fr.WithNewSpanContext(ctx, obsName, spanCtx)
+		fs, ctx, done := o.startSpan(ctx, fr, info.FullMethod, spanCtx)
 		defer done()
 		span := fs.TraceSpan()
 		ext.SpanKind.Set(span, ext.SpanKindRPCServerEnum)
-		span.SetTag("grpc.hostname", traceHostname)
+		span.SetTag("grpc.hostname", localHostname)
+		client := o.peerOf(ctx, span)
 
 		if err != nil && err != opentracing.ErrSpanContextNotFound {
 			fs.Warn("tracer_extract", "error extracting trace metadata", Vals{}.WithError(err))
 		}
 
 		ctx = opentracing.ContextWithSpan(ctx, span)
+		logPayloads := o.payloads.enabled(fs, info.FullMethod)
+		if logPayloads {
+			o.payloads.log(fs, info.FullMethod, "request", req)
+		}
 		resp, err = handler(ctx, req)
+		if logPayloads && err == nil {
+			o.payloads.log(fs, info.FullMethod, "response", resp)
+		}
+		if o.serverTiming {
+			grpc.SetTrailer(ctx, serverTiming(ctx, start))
+		}
 
-		fs.Incr(fmt.Sprintf("grpc_server.%s.%s", obsName, grpc.Code(err).String()))
+		o.statusSpan(fs, client).Incr(fmt.Sprintf("grpc_server.%s.%s", obsName, status.Code(err).String()))
 
 		if err != nil {
+			recordErrorCode(fs, err)
 			if ctx.Err() == nil {
 				fs.Trace(fmt.Sprintf("error in gRPC %s", info.FullMethod), Vals{}.WithError(err))
-				ext.Error.Set(span, true)
+				markFailed(fs)
 				span.SetTag(tracing.Label.ErrorMessage, fmt.Sprintf("%v", err))
 			} else {
 				span.SetTag("canceled", true)
@@ -154,40 +481,47 @@ func tracingUnaryServerInterceptor(fr FlightRecorder, tracer opentracing.Tracer)
 	}
 }
 
-func tracingStreamServerInterceptor(fr FlightRecorder, tracer opentracing.Tracer) grpc.StreamServerInterceptor {
+func tracingStreamServerInterceptor(fr FlightRecorder, tracer opentracing.Tracer, o *grpcOptions) grpc.StreamServerInterceptor {
 	return func(
 		srv interface{},
 		ss grpc.ServerStream,
 		info *grpc.StreamServerInfo,
 		handler grpc.StreamHandler,
 	) error {
-		ctx := ss.Context()
-		md, ok := metadata.FromIncomingContext(ctx)
-		if !ok {
-			md = metadata.New(nil)
+		if o.skip(info.FullMethod) {
+			return handler(srv, ss)
 		}
-		spanCtx, err := tracer.Extract(opentracing.TextMap, grpcTraceMD(md))
+
+		start := time.Now()
+		ctx := ss.Context()
+		spanCtx, err := extractSpanContext(ctx, tracer)
 
 		obsName := formatRPCName(info.FullMethod)
-		fs, ctx, done := fr.WithNewSpanContext(ctx, obsName, spanCtx)
+		fs, ctx, done := o.startSpan(ctx, fr, info.FullMethod, spanCtx)
 		span := fs.TraceSpan()
 		ext.SpanKind.Set(span, ext.SpanKindRPCServerEnum)
-		span.SetTag("grpc.hostname", traceHostname)
+		span.SetTag("grpc.hostname", localHostname)
+		client := o.peerOf(ctx, span)
 
 		if err != nil && err != opentracing.ErrSpanContextNotFound {
 			fs.Warn("tracer_extract", "error extracting trace metadata", Vals{}.WithError(err))
 		}
 
 		ctx = opentracing.ContextWithSpan(ctx, span)
-		ssi := &serverStreamInterceptor{ss, span, done, 0, 0, ctx}
+		ssi := &serverStreamInterceptor{ss, span, done, 0, 0, ctx,
+			o.newStreamMessages(tracer, span, info.FullMethod)}
 		defer ssi.finish()
 
 		err = handler(srv, ssi)
-		fs.Incr(fmt.Sprintf("grpc_server.%s.%s", obsName, grpc.Code(err).String()))
+		if o.serverTiming {
+			ss.SetTrailer(serverTiming(ctx, start))
+		}
+		o.statusSpan(fs, client).Incr(fmt.Sprintf("grpc_server.%s.%s", obsName, status.Code(err).String()))
 		if err != nil {
+			recordErrorCode(fs, err)
 			if ctx.Err() == nil {
 				fs.Trace(fmt.Sprintf("error in gRPC %s", info.FullMethod), Vals{}.WithError(err))
-				ext.Error.Set(span, true)
+				markFailed(fs)
 				span.SetTag(tracing.Label.ErrorMessage, fmt.Sprintf("%v", err))
 			} else {
 				span.SetTag("canceled", true)
@@ -200,9 +534,16 @@ func tracingStreamServerInterceptor(fr FlightRecorder, tracer opentracing.Tracer
 
 type clientStreamInterceptor struct {
 	cs                grpc.ClientStream
+	ctx               context.Context // of the call, which the stream's context outlives
+	fs                FlightSpan
 	span              opentracing.Span
 	done              func()
+	start             time.Time
+	target, method    string
+	fullMethod        string
 	inCount, outCount int
+	messages          *streamMessages
+	finished          sync.Once
 }
 
 func (csi *clientStreamInterceptor) Header() (metadata.MD, error) {
@@ -222,16 +563,25 @@ func (csi *clientStreamInterceptor) Context() context.Context {
 }
 
 func (csi *clientStreamInterceptor) SendMsg(m interface{}) error {
+	index := csi.outCount
 	csi.outCount++
-	return csi.cs.SendMsg(m)
+	if !csi.messages.sampled(index) {
+		return csi.cs.SendMsg(m)
+	}
+	start := time.Now()
+	err := csi.cs.SendMsg(m)
+	csi.messages.record("sent", index, m, start, err)
+	return err
 }
 
 func (csi *clientStreamInterceptor) RecvMsg(m interface{}) error {
+	start := time.Now()
 	err := csi.cs.RecvMsg(m)
-	if err == io.EOF {
-		csi.span.SetTag("grpc.stream_received", csi.inCount)
-		csi.span.SetTag("grpc.stream_sent", csi.outCount)
-		csi.done()
+	if err != io.EOF && csi.messages.sampled(csi.inCount) {
+		csi.messages.record("received", csi.inCount, m, start, err)
+	}
+	if err != nil {
+		csi.finish(err)
 		return err
 	}
 
@@ -240,12 +590,56 @@ func (csi *clientStreamInterceptor) RecvMsg(m interface{}) error {
 	return err
 }
 
+// finish ends the stream once RecvMsg returned err, io.EOF if the stream completed.
+func (csi *clientStreamInterceptor) finish(err error) {
+	csi.finished.Do(func() {
+		csi.span.SetTag("grpc.stream_received", csi.inCount)
+		csi.span.SetTag("grpc.stream_sent", csi.outCount)
+		elapsed := time.Since(csi.start)
+		tagServerTiming(csi.span, csi.cs.Trailer(), elapsed)
+		recordClientLatency(csi.fs, csi.target, csi.method, elapsed)
+		if err != io.EOF {
+			recordStreamError(csi.ctx, csi.fs, csi.fullMethod, err)
+		}
+		csi.done()
+	})
+}
+
+// recordClientLatency reports the latency of an RPC as observed by the client, in the grpc_client.latency_us
+// histogram tagged with the target service and method, so that it can be broken down by dependency.
+func recordClientLatency(fs FlightSpan, target, method string, elapsed time.Duration) {
+	fs.WithMetricTags(Tags{"target": target, "method": method}).AddStat("grpc_client.latency_us", float64(elapsed/time.Microsecond))
+}
+
+// clientTarget returns the service cc connects to: the authority gRPC derives from the dial target
+// scheme://authority/endpoint, which is the endpoint, without its port.
+func clientTarget(cc *grpc.ClientConn) string {
+	if cc == nil {
+		return "unknown"
+	}
+	target := cc.Target()
+	if i := strings.Index(target, "://"); i >= 0 {
+		target = target[i+3:]
+		if j := strings.Index(target, "/"); j >= 0 {
+			target = target[j+1:]
+		}
+	}
+	if host, _, err := net.SplitHostPort(target); err == nil {
+		target = host
+	}
+	if target == "" {
+		return "unknown"
+	}
+	return target
+}
+
 type serverStreamInterceptor struct {
 	ss                grpc.ServerStream
 	span              opentracing.Span
 	done              func()
 	inCount, outCount int
 	ctx               context.Context
+	messages          *streamMessages
 }
 
 func (ssi *serverStreamInterceptor) SetHeader(md metadata.MD) error {
@@ -265,13 +659,29 @@ func (ssi *serverStreamInterceptor) Context() context.Context {
 }
 
 func (ssi *serverStreamInterceptor) SendMsg(m interface{}) error {
+	index := ssi.outCount
 	ssi.outCount++
-	return ssi.ss.SendMsg(m)
+	if !ssi.messages.sampled(index) {
+		return ssi.ss.SendMsg(m)
+	}
+	start := time.Now()
+	err := ssi.ss.SendMsg(m)
+	ssi.messages.record("sent", index, m, start, err)
+	return err
 }
 
 func (ssi *serverStreamInterceptor) RecvMsg(m interface{}) error {
+	index := ssi.inCount
 	ssi.inCount++
-	return ssi.ss.RecvMsg(m)
+	if !ssi.messages.sampled(index) {
+		return ssi.ss.RecvMsg(m)
+	}
+	start := time.Now()
+	err := ssi.ss.RecvMsg(m)
+	if err != io.EOF {
+		ssi.messages.record("received", index, m, start, err)
+	}
+	return err
 }
 
 func (ssi *serverStreamInterceptor) finish() {
@@ -289,11 +699,7 @@ func (g grpcTraceMD) Set(key, val string) {
 func (g grpcTraceMD) ForeachKey(handler func(key, val string) error) error {
 	for k, vs := range g {
 		for _, v := range vs {
-			rk, rv, err := metadata.DecodeKeyValue(k, v)
-			if err != nil {
-				return err
-			}
-			if err = handler(rk, rv); err != nil {
+			if err := handler(k, v); err != nil {
 				return err
 			}
 		}
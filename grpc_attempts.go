@@ -0,0 +1,109 @@
+package obs
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"google.golang.org/grpc/stats"
+)
+
+// GRPCRetryAttempts makes client interceptors trace each attempt of the RPCs that gRPC retries under a
+// single call, which would otherwise show up as one span whose latency covers all the attempts. Every
+// attempt gets a child span of the RPC span, tagged with grpc.attempt, which ends when the next attempt
+// starts or the RPC returns. The RPC span is tagged with the number of attempts, including hedged
+// ones, as grpc.attempts, and with grpc.retried when there was more than one. The client also reports:
+//
+//	grpc_client.<Service>.<Method>.attempts  the attempts made per RPC
+//	grpc_client.<Service>.<Method>.retried   the RPCs made of more than one attempt
+//
+// Attempts are observed with a stats handler, which only GRPCDialOptions installs.
+func GRPCRetryAttempts() GRPCOption {
+	return func(o *grpcOptions) {
+		o.attempts = true
+	}
+}
+
+type rpcAttemptsKey struct{}
+
+// rpcAttempts tracks the attempts of a client RPC.
+type rpcAttempts struct {
+	fr     FlightRecorder
+	ctx    context.Context // carries the span of the RPC
+	opName string
+
+	mutex sync.Mutex
+	n     int
+	end   DoneFunc // ends the span of the current attempt
+}
+
+// withRPCAttempts returns a context in which the attempts of the RPC whose span is in ctx are tracked,
+// if enabled.
+func (o *grpcOptions) withRPCAttempts(ctx context.Context, fr FlightRecorder, obsName string) (context.Context, *rpcAttempts) {
+	if !o.attempts {
+		return ctx, nil
+	}
+	a := &rpcAttempts{fr: fr, ctx: ctx, opName: obsName + ".attempt"}
+	return context.WithValue(ctx, rpcAttemptsKey{}, a), a
+}
+
+// begin ends the span of the current attempt, if any, and starts the span of a new one.
+func (a *rpcAttempts) begin() {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.end != nil {
+		a.end()
+	}
+	a.n++
+	fs, _, end := a.fr.WithNewSpan(a.ctx, a.opName)
+	fs.TraceSpan().SetTag("grpc.attempt", a.n)
+	a.end = end
+}
+
+// finish ends the span of the last attempt, and reports the attempts on fs, the span of the RPC.
+func (a *rpcAttempts) finish(fs FlightSpan, obsName string) {
+	if a == nil {
+		return
+	}
+	a.mutex.Lock()
+	n, end := a.n, a.end
+	a.end = nil
+	a.mutex.Unlock()
+
+	if end != nil {
+		end()
+	}
+	if n == 0 {
+		// the RPC failed before reaching a server, such as when no connection was available
+		return
+	}
+	fs.TraceSpan().SetTag("grpc.attempts", n)
+	fs.AddStat(fmt.Sprintf("grpc_client.%s.attempts", obsName), float64(n))
+	if n > 1 {
+		fs.TraceSpan().SetTag("grpc.retried", true)
+		fs.Incr(fmt.Sprintf("grpc_client.%s.retried", obsName))
+	}
+}
+
+// rpcAttemptsHandler is a stats.Handler that starts the span of an attempt whenever gRPC sends the
+// headers of a client RPC, which it does once per attempt.
+type rpcAttemptsHandler struct{}
+
+func (rpcAttemptsHandler) TagRPC(ctx context.Context, info *stats.RPCTagInfo) context.Context {
+	return ctx
+}
+
+func (rpcAttemptsHandler) HandleRPC(ctx context.Context, s stats.RPCStats) {
+	if _, ok := s.(*stats.OutHeader); !ok || !s.IsClient() {
+		return
+	}
+	if a, ok := ctx.Value(rpcAttemptsKey{}).(*rpcAttempts); ok {
+		a.begin()
+	}
+}
+
+func (rpcAttemptsHandler) TagConn(ctx context.Context, info *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (rpcAttemptsHandler) HandleConn(context.Context, stats.ConnStats) {}
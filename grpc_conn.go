@@ -0,0 +1,147 @@
+package obs
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mixpanel/obs/clock"
+	"github.com/mixpanel/obs/metrics"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+)
+
+// grpcConnStates are the states of the connections counted by grpc_client.conn_state.
+var grpcConnStates = []connectivity.State{
+	connectivity.Idle,
+	connectivity.Connecting,
+	connectivity.Ready,
+	connectivity.TransientFailure,
+}
+
+// grpcTargets holds the states of the monitored connections of each target, so that the gauges of a
+// target count all its connections.
+var grpcTargets = struct {
+	sync.Mutex
+	states map[string]*grpcTargetStates
+}{states: make(map[string]*grpcTargetStates)}
+
+type grpcTargetStates struct {
+	conns      int // guarded by grpcTargets
+	counts     [connectivity.Shutdown]int64
+	unregister func()
+}
+
+// GRPCDial creates a client connection to target, instrumented with GRPCDialOptions followed by
+// dialOpts, and reports its connectivity with MonitorGRPCConn.
+func GRPCDial(ctx context.Context, fr FlightRecorder, target string, dialOpts ...grpc.DialOption) (*grpc.ClientConn, error) {
+	cc, err := grpc.DialContext(ctx, target, append(GRPCDialOptions(fr), dialOpts...)...)
+	if err != nil {
+		return nil, err
+	}
+	MonitorGRPCConn(fr, cc)
+	return cc, nil
+}
+
+// MonitorGRPCConn reports the connectivity of cc until it is closed, tagged with its target as
+// grpc_client.latency_us is:
+//
+//	grpc_client.conn_state          gauges of the number of connections to the target in each state,
+//	                                tagged with the state: idle, connecting, ready or transient_failure
+//	grpc_client.transient_failures  connections to the target that failed
+//	grpc_client.reconnect_us        how long connections to the target that were lost took to be
+//	                                ready again, not counting connections that went idle
+//
+// The gauges of a target are reported with the receiver of fr of its first monitored connection.
+// States that cc leaves before it is observed in them are not reported.
+func MonitorGRPCConn(fr FlightRecorder, cc *grpc.ClientConn) {
+	c := clock.Real
+	if f, ok := fr.(*flightRecorder); ok {
+		c = f.clock
+	}
+	target := clientTarget(cc)
+	r := fr.GetReceiver().ScopeTags(metrics.Tags{"target": target})
+	states := addGRPCConn(r, target)
+	state := cc.GetState()
+	states.enter(state)
+	go watchGRPCConn(cc, target, state, states, r, c)
+}
+
+func watchGRPCConn(cc *grpc.ClientConn, target string, state connectivity.State, states *grpcTargetStates, r metrics.Receiver, c clock.Clock) {
+	defer removeGRPCConn(target)
+
+	var lost time.Time
+	for state != connectivity.Shutdown {
+		cc.WaitForStateChange(context.Background(), state)
+		next := cc.GetState()
+		states.leave(state)
+		states.enter(next)
+
+		switch {
+		case next == connectivity.TransientFailure:
+			r.Incr("grpc_client.transient_failures")
+		case next == connectivity.Ready && !lost.IsZero():
+			r.AddStat("grpc_client.reconnect_us", float64(c.Since(lost)/time.Microsecond))
+			lost = time.Time{}
+		}
+		// connections going idle are not lost, they reconnect on the next RPC
+		if state == connectivity.Ready && (next == connectivity.TransientFailure || next == connectivity.Connecting) {
+			lost = c.Now()
+		}
+		state = next
+	}
+}
+
+// addGRPCConn counts a new connection to target, registering the gauges of target with r if it is
+// the first one.
+func addGRPCConn(r metrics.Receiver, target string) *grpcTargetStates {
+	grpcTargets.Lock()
+	defer grpcTargets.Unlock()
+	states, ok := grpcTargets.states[target]
+	if !ok {
+		states = &grpcTargetStates{}
+		var unregisters []func()
+		for _, s := range grpcConnStates {
+			count := &states.counts[s]
+			name := strings.ToLower(s.String())
+			unregisters = append(unregisters, r.ScopeTags(metrics.Tags{"state": name}).RegisterGauge("grpc_client.conn_state", func() float64 {
+				return float64(atomic.LoadInt64(count))
+			}))
+		}
+		states.unregister = func() {
+			for _, unregister := range unregisters {
+				unregister()
+			}
+		}
+		grpcTargets.states[target] = states
+	}
+	states.conns++
+	return states
+}
+
+// removeGRPCConn forgets a closed connection to target, unregistering the gauges of target if it was
+// the last one.
+func removeGRPCConn(target string) {
+	grpcTargets.Lock()
+	defer grpcTargets.Unlock()
+	states := grpcTargets.states[target]
+	states.conns--
+	if states.conns == 0 {
+		states.unregister()
+		delete(grpcTargets.states, target)
+	}
+}
+
+func (s *grpcTargetStates) enter(state connectivity.State) {
+	if state < connectivity.Shutdown {
+		atomic.AddInt64(&s.counts[state], 1)
+	}
+}
+
+func (s *grpcTargetStates) leave(state connectivity.State) {
+	if state < connectivity.Shutdown {
+		atomic.AddInt64(&s.counts[state], -1)
+	}
+}
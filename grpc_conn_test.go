@@ -0,0 +1,72 @@
+package obs
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mixpanel/obs/metrics"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+)
+
+// serveGRPC serves gRPC at addr, and returns the address it listens to.
+func serveGRPC(t *testing.T, addr string) (*grpc.Server, string) {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer()
+	go srv.Serve(lis)
+	return srv, lis.Addr().String()
+}
+
+func waitForState(t *testing.T, cc *grpc.ClientConn, state connectivity.State) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for s := cc.GetState(); s != state; s = cc.GetState() {
+		if !cc.WaitForStateChange(ctx, s) {
+			t.Fatalf("connection is %v, not %v", s, state)
+		}
+	}
+}
+
+func TestMonitorGRPCConn(t *testing.T) {
+	fr := NewFlightRecorder("test", metrics.NewReceiver(metrics.NewSnapshotSink(metrics.NewMockSink(), 0)), &testLogger{}, opentracing.NoopTracer{})
+	r := fr.GetReceiver()
+	srv, addr := serveGRPC(t, "127.0.0.1:0")
+
+	cc, err := GRPCDial(context.Background(), fr, addr, grpc.WithInsecure(), grpc.WithBackoffMaxDelay(10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForState(t, cc, connectivity.Ready)
+	states := func() *grpcTargetStates {
+		grpcTargets.Lock()
+		defer grpcTargets.Unlock()
+		return grpcTargets.states["127.0.0.1"]
+	}
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt64(&states().counts[connectivity.Ready]) == 1
+	}, 5*time.Second, time.Millisecond)
+
+	srv.Stop()
+	waitForState(t, cc, connectivity.TransientFailure)
+	srv, _ = serveGRPC(t, addr)
+	defer srv.Stop()
+	waitForState(t, cc, connectivity.Ready)
+
+	assert.Eventually(t, func() bool {
+		return r.Snapshot().Stats[metrics.SnapshotKey("grpc_client.reconnect_us", metrics.Tags{"target": "127.0.0.1"})].Count == 1
+	}, 5*time.Second, time.Millisecond)
+	assert.True(t, r.Snapshot().Counters[metrics.SnapshotKey("grpc_client.transient_failures", metrics.Tags{"target": "127.0.0.1"})] >= 1)
+	assert.Equal(t, int64(1), atomic.LoadInt64(&states().counts[connectivity.Ready]))
+	assert.Equal(t, int64(0), atomic.LoadInt64(&states().counts[connectivity.TransientFailure]))
+
+	cc.Close()
+	assert.Eventually(t, func() bool { return states() == nil }, 5*time.Second, time.Millisecond)
+}
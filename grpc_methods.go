@@ -0,0 +1,60 @@
+package obs
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// GRPCServiceInfoProvider lists the services registered on a gRPC server, as *grpc.Server does.
+type GRPCServiceInfoProvider interface {
+	GetServiceInfo() map[string]grpc.ServiceInfo
+}
+
+// GRPCPreRegisteredCodes sets the status codes of the counters reported by PreRegisterGRPCMethods,
+// codes.OK by default. Include the codes of the error rates that alerts are set on, such as
+// codes.Internal and codes.Unavailable.
+func GRPCPreRegisteredCodes(codes ...codes.Code) GRPCOption {
+	return func(o *grpcOptions) {
+		o.preRegisteredCodes = append(o.preRegisteredCodes, codes...)
+	}
+}
+
+// PreRegisterGRPCMethods reports the counters that the server interceptors would count the RPCs of
+// every method of server with, grpc_server.<Service>.<Method>.<code>, with a value of 0, so that
+// dashboards and alerts get series for all the methods right at startup instead of "no data" until
+// their first RPC. Call it once the services are registered on server, with the options given to
+// GRPCServerOptions: skipped methods are left out, and the counters are tagged with the "unknown"
+// caller if GRPCCallerMetricTag is set.
+func PreRegisterGRPCMethods(fr FlightRecorder, server GRPCServiceInfoProvider, opts ...GRPCOption) {
+	o := newGRPCOptions(opts)
+	statusCodes := o.preRegisteredCodes
+	if len(statusCodes) == 0 {
+		statusCodes = []codes.Code{codes.OK}
+	}
+	fs := o.statusSpan(fr.WithSpan(context.Background()), rpcPeer{})
+	for _, method := range grpcMethods(server) {
+		if o.skip(method) {
+			continue
+		}
+		obsName := formatRPCName(method)
+		for _, code := range statusCodes {
+			fs.IncrBy(fmt.Sprintf("grpc_server.%s.%s", obsName, code.String()), 0)
+		}
+	}
+}
+
+// grpcMethods returns the full names of the methods of the services of server, sorted.
+func grpcMethods(server GRPCServiceInfoProvider) []string {
+	var methods []string
+	for service, info := range server.GetServiceInfo() {
+		for _, m := range info.Methods {
+			methods = append(methods, "/"+service+"/"+m.Name)
+		}
+	}
+	sort.Strings(methods)
+	return methods
+}
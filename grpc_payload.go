@@ -0,0 +1,149 @@
+package obs
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+)
+
+// PayloadLogging configures GRPCLogPayloads.
+type PayloadLogging struct {
+	// Methods are the full method names whose payloads are logged, for example "/pkg.Service/Method".
+	// When empty, payloads of every method are logged.
+	Methods []string
+	// Redact lists the fields whose values are replaced by RedactedValue, as dot separated paths of
+	// field names as declared in the .proto file, for example "user.email". A path made of a single
+	// name, such as "password", matches that field at any depth.
+	Redact []string
+	// MaxSize is the maximum size in bytes of a logged payload, beyond which it is truncated.
+	// It defaults to DefaultPayloadMaxSize.
+	MaxSize int
+}
+
+// DefaultPayloadMaxSize is the size beyond which payloads are truncated when PayloadLogging.MaxSize is unset.
+const DefaultPayloadMaxSize = 4096
+
+// RedactedValue replaces the value of redacted fields in logged payloads, and the secrets masked by a Redactor.
+const RedactedValue = "[REDACTED]"
+
+// GRPCLogPayloads makes interceptors log the request and response messages of unary RPCs as JSON, at
+// DEBUG level, for the methods configured in cfg. Payloads are only serialized when DEBUG logging is
+// enabled. This is meant for debugging and should not be left on for high traffic methods.
+func GRPCLogPayloads(cfg PayloadLogging) GRPCOption {
+	return func(o *grpcOptions) {
+		o.payloads = newPayloadLogger(cfg)
+	}
+}
+
+type payloadLogger struct {
+	methods  map[string]struct{}
+	anywhere map[string]struct{}
+	paths    map[string]struct{}
+	maxSize  int
+}
+
+func newPayloadLogger(cfg PayloadLogging) *payloadLogger {
+	p := &payloadLogger{
+		anywhere: make(map[string]struct{}),
+		paths:    make(map[string]struct{}),
+		maxSize:  cfg.MaxSize,
+	}
+	if p.maxSize <= 0 {
+		p.maxSize = DefaultPayloadMaxSize
+	}
+	if len(cfg.Methods) > 0 {
+		p.methods = make(map[string]struct{}, len(cfg.Methods))
+		for _, m := range cfg.Methods {
+			p.methods[m] = struct{}{}
+		}
+	}
+	for _, r := range cfg.Redact {
+		if strings.Contains(r, ".") {
+			p.paths[r] = struct{}{}
+		} else {
+			p.anywhere[r] = struct{}{}
+		}
+	}
+	return p
+}
+
+// enabled reports whether payloads of method should be logged into fs.
+func (p *payloadLogger) enabled(fs FlightSpan, method string) bool {
+	if p == nil {
+		return false
+	}
+	if f, ok := fs.(*flightSpan); ok && !f.l.IsDebug() {
+		return false
+	}
+	if p.methods == nil {
+		return true
+	}
+	_, ok := p.methods[method]
+	return ok
+}
+
+// log logs msg, the request or response of method according to kind, at DEBUG level.
+func (p *payloadLogger) log(fs FlightSpan, method, kind string, msg interface{}) {
+	fs.Debug(fmt.Sprintf("gRPC %s %s", kind, method), Vals{
+		"grpc.method":  method,
+		"grpc." + kind: p.format(msg),
+	})
+}
+
+// format returns msg as redacted and truncated JSON.
+func (p *payloadLogger) format(msg interface{}) string {
+	var data []byte
+	var err error
+	if pm, ok := msg.(proto.Message); ok {
+		var s string
+		s, err = (&jsonpb.Marshaler{OrigName: true}).MarshalToString(pm)
+		data = []byte(s)
+	} else {
+		data, err = json.Marshal(msg)
+	}
+	if err != nil {
+		return fmt.Sprintf("<unable to marshal %T: %v>", msg, err)
+	}
+
+	if len(p.anywhere) > 0 || len(p.paths) > 0 {
+		var v interface{}
+		if err := json.Unmarshal(data, &v); err == nil {
+			if redacted, err := json.Marshal(p.redact(v, "")); err == nil {
+				data = redacted
+			}
+		}
+	}
+
+	if len(data) > p.maxSize {
+		return fmt.Sprintf("%s...(truncated %d bytes)", data[:p.maxSize], len(data)-p.maxSize)
+	}
+	return string(data)
+}
+
+// redact replaces the redacted fields of v, found at path, by RedactedValue.
+func (p *payloadLogger) redact(v interface{}, path string) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, field := range v {
+			fieldPath := k
+			if path != "" {
+				fieldPath = path + "." + k
+			}
+			_, anywhere := p.anywhere[k]
+			_, exact := p.paths[fieldPath]
+			if anywhere || exact {
+				v[k] = RedactedValue
+			} else {
+				v[k] = p.redact(field, fieldPath)
+			}
+		}
+	case []interface{}:
+		for i, e := range v {
+			v[i] = p.redact(e, path)
+		}
+	}
+	return v
+}
@@ -0,0 +1,174 @@
+package obs
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"strings"
+
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// XFCCHeader is the metadata key in which service meshes, such as Envoy and Istio, pass the
+// certificate of the client of a request.
+const XFCCHeader = "x-forwarded-client-cert"
+
+// GRPCCallerMetricTag makes server interceptors tag the RPC counters,
+// grpc_server.<Service>.<Method>.<code>, with the caller, for per-caller dashboards. Callers are only
+// identified by the SPIFFE ID of their certificate, as for the peer.service span tag, which the
+// service mesh or TLS vouches for, or by the applications named by their user agents among
+// userAgentApps; the others are tagged "unknown". User agents are set by clients at will, so they
+// must be allowed one by one to keep the tag bounded.
+func GRPCCallerMetricTag(userAgentApps ...string) GRPCOption {
+	return func(o *grpcOptions) {
+		o.callerTag = true
+		if o.callerApps == nil {
+			o.callerApps = make(map[string]bool, len(userAgentApps))
+		}
+		for _, app := range userAgentApps {
+			o.callerApps[app] = true
+		}
+	}
+}
+
+// rpcPeer identifies the client of a gRPC call.
+type rpcPeer struct {
+	service   string
+	spiffeID  string
+	userAgent string
+	address   string
+	// fromUserAgent is set when the service is named by the user agent, rather than by the SPIFFE ID.
+	fromUserAgent bool
+}
+
+// peerOf identifies the client of the gRPC call in ctx. Its service is the last segment of its SPIFFE
+// ID, such as the service account of an Istio workload, read from the certificate forwarded by a
+// service mesh or from the TLS connection; otherwise it is the application named at the start of its
+// user agent, as in "billing/1.2 grpc-go/1.23.1".
+func peerOf(ctx context.Context) rpcPeer {
+	var p rpcPeer
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if vs := md.Get(XFCCHeader); len(vs) > 0 {
+			p.spiffeID = xfccSPIFFEID(vs[len(vs)-1])
+		}
+		if vs := md.Get("user-agent"); len(vs) > 0 {
+			p.userAgent = vs[0]
+		}
+	}
+	if pr, ok := peer.FromContext(ctx); ok {
+		if pr.Addr != nil {
+			p.address = pr.Addr.String()
+		}
+		if tlsInfo, ok := pr.AuthInfo.(credentials.TLSInfo); ok && p.spiffeID == "" {
+			if certs := tlsInfo.State.PeerCertificates; len(certs) > 0 {
+				for _, uri := range certs[0].URIs {
+					if uri.Scheme == "spiffe" {
+						p.spiffeID = uri.String()
+						break
+					}
+				}
+			}
+		}
+	}
+
+	if p.spiffeID != "" {
+		if u, err := url.Parse(p.spiffeID); err == nil {
+			path := strings.TrimRight(u.Path, "/")
+			p.service = path[strings.LastIndex(path, "/")+1:]
+		}
+	}
+	if p.service == "" {
+		p.service = userAgentApp(p.userAgent)
+		p.fromUserAgent = p.service != ""
+	}
+	return p
+}
+
+// xfccSPIFFEID returns the SPIFFE ID of the client in the x-forwarded-client-cert header xfcc, whose
+// last element describes the closest client, as in By=...;Hash=...;URI=spiffe://cluster.local/ns/a/sa/b.
+func xfccSPIFFEID(xfcc string) string {
+	elements := strings.Split(xfcc, ",")
+	for _, pair := range strings.Split(elements[len(elements)-1], ";") {
+		kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(kv) == 2 && strings.EqualFold(kv[0], "URI") {
+			uri := strings.Trim(kv[1], `"`)
+			if strings.HasPrefix(uri, "spiffe://") {
+				return uri
+			}
+		}
+	}
+	return ""
+}
+
+// userAgentApp returns the application at the start of userAgent, without its version, or "" if
+// userAgent only names a gRPC library.
+func userAgentApp(userAgent string) string {
+	fields := strings.Fields(userAgent)
+	if len(fields) == 0 {
+		return ""
+	}
+	app := fields[0]
+	if i := strings.Index(app, "/"); i >= 0 {
+		app = app[:i]
+	}
+	if strings.HasPrefix(app, "grpc-") {
+		return ""
+	}
+	return app
+}
+
+// peerOf identifies the client of the gRPC call in ctx, and tags span with it. The client is left
+// unidentified when neither span nor the metrics need it.
+func (o *grpcOptions) peerOf(ctx context.Context, span opentracing.Span) rpcPeer {
+	if !o.callerTag && isNoopTracer(span.Tracer()) {
+		return rpcPeer{}
+	}
+	p := peerOf(ctx)
+	p.tag(span)
+	return p
+}
+
+// tag tags span with what identifies the peer.
+func (p rpcPeer) tag(span opentracing.Span) {
+	if p.service != "" {
+		ext.PeerService.Set(span, p.service)
+	}
+	if p.spiffeID != "" {
+		span.SetTag("peer.spiffe_id", p.spiffeID)
+	}
+	if p.userAgent != "" {
+		span.SetTag("grpc.user_agent", p.userAgent)
+	}
+	if p.address != "" {
+		ext.PeerAddress.Set(span, p.address)
+		if host, _, err := net.SplitHostPort(p.address); err == nil {
+			if ip := net.ParseIP(host); ip != nil && ip.To4() != nil {
+				span.SetTag("peer.ipv4", ip.String())
+			} else if ip != nil {
+				ext.PeerHostIPv6.Set(span, ip.String())
+			}
+		}
+	}
+}
+
+// caller returns the value of the caller metric tag: the service of p, unless it is named by a user
+// agent not among userAgentApps.
+func (p rpcPeer) caller(userAgentApps map[string]bool) string {
+	if p.service == "" || p.fromUserAgent && !userAgentApps[p.service] {
+		return unknownCaller
+	}
+	return p.service
+}
+
+// statusSpan returns the FlightSpan counting the RPCs of fs by status code, tagged with the caller if
+// enabled.
+func (o *grpcOptions) statusSpan(fs FlightSpan, p rpcPeer) FlightSpan {
+	if !o.callerTag {
+		return fs
+	}
+	return fs.WithMetricTags(Tags{"caller": p.caller(o.callerApps)})
+}
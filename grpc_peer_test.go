@@ -0,0 +1,56 @@
+package obs
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/mixpanel/obs/logging"
+	"github.com/mixpanel/obs/metrics"
+	basictracer "github.com/opentracing/basictracer-go"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+func TestPeerTagging(t *testing.T) {
+	sink := metrics.NewMockSink()
+	recorder := basictracer.NewInMemoryRecorder()
+	tracer := basictracer.New(recorder)
+	fr := NewFlightRecorder("test", metrics.NewReceiver(sink), logging.Null, tracer)
+	interceptor := tracingUnaryServerInterceptor(fr, tracer, newGRPCOptions([]GRPCOption{GRPCCallerMetricTag("search")}))
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) { return nil, nil }
+	info := &grpc.UnaryServerInfo{FullMethod: "/company.Service/Get"}
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+		XFCCHeader, `By=spiffe://cluster.local/ns/a/sa/me;URI=spiffe://cluster.local/ns/a/sa/gateway,By=spiffe://cluster.local/ns/a/sa/me;Hash=abc;URI="spiffe://cluster.local/ns/a/sa/billing"`,
+		"user-agent", "billing/1.2 grpc-go/1.23.1",
+	))
+	ctx = peer.NewContext(ctx, &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.7"), Port: 4242}})
+	interceptor(ctx, nil, info, handler)
+
+	uaCtx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("user-agent", "search/3 grpc-go/1.23.1"))
+	interceptor(uaCtx, nil, info, handler)
+	unlistedCtx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("user-agent", "curl-1234/7 grpc-go/1.23.1"))
+	interceptor(unlistedCtx, nil, info, handler)
+	anonymousCtx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("user-agent", "grpc-go/1.23.1"))
+	interceptor(anonymousCtx, nil, info, handler)
+
+	spans := recorder.GetSpans()
+	if assert.Len(t, spans, 4) {
+		tags := spans[0].Tags
+		assert.Equal(t, "billing", tags["peer.service"])
+		assert.Equal(t, "spiffe://cluster.local/ns/a/sa/billing", tags["peer.spiffe_id"])
+		assert.Equal(t, "billing/1.2 grpc-go/1.23.1", tags["grpc.user_agent"])
+		assert.Equal(t, "10.0.0.7:4242", tags["peer.address"])
+		assert.Equal(t, "10.0.0.7", tags["peer.ipv4"])
+		assert.Equal(t, "search", spans[1].Tags["peer.service"])
+		assert.Equal(t, "curl-1234", spans[2].Tags["peer.service"])
+		assert.NotContains(t, spans[3].Tags, "peer.service")
+	}
+	assert.Equal(t, 1, sink.Invocations["grpc_server.Service.Get.OK, map[caller:billing], 1, ct\n"])
+	assert.Equal(t, 1, sink.Invocations["grpc_server.Service.Get.OK, map[caller:search], 1, ct\n"])
+	assert.Equal(t, 2, sink.Invocations["grpc_server.Service.Get.OK, map[caller:unknown], 1, ct\n"],
+		"user agents not allowed are unknown")
+}
@@ -0,0 +1,98 @@
+package obs
+
+import (
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	opentracing "github.com/opentracing/opentracing-go"
+)
+
+// StreamMessageSpans configures GRPCStreamMessageSpans.
+type StreamMessageSpans struct {
+	// Events logs messages as events of the stream span instead of starting a child span for each,
+	// which is cheaper but does not show how long each send or receive took.
+	Events bool
+	// First is the number of messages recorded in each direction before sampling starts. Defaults to
+	// DefaultStreamMessagesFirst.
+	First int
+	// Every records one message of every Every in each direction after the first ones. Defaults to
+	// DefaultStreamMessagesEvery.
+	Every int
+}
+
+const (
+	// DefaultStreamMessagesFirst is the number of messages recorded before sampling when
+	// StreamMessageSpans.First is unset.
+	DefaultStreamMessagesFirst = 10
+	// DefaultStreamMessagesEvery is the sampling of messages when StreamMessageSpans.Every is unset.
+	DefaultStreamMessagesEvery = 100
+)
+
+// GRPCStreamMessageSpans makes stream interceptors record the messages sent and received on streams,
+// as short child spans of the stream span, or as events, tagged with the index of the message in its
+// direction and its size. Messages are sampled as configured in cfg, to bound the overhead on chatty
+// streams.
+func GRPCStreamMessageSpans(cfg StreamMessageSpans) GRPCOption {
+	if cfg.First <= 0 {
+		cfg.First = DefaultStreamMessagesFirst
+	}
+	if cfg.Every <= 0 {
+		cfg.Every = DefaultStreamMessagesEvery
+	}
+	return func(o *grpcOptions) {
+		o.streamMessages = &cfg
+	}
+}
+
+// streamMessages records the messages of a stream.
+type streamMessages struct {
+	cfg    *StreamMessageSpans
+	tracer opentracing.Tracer
+	span   opentracing.Span
+	method string
+}
+
+// newStreamMessages returns nil if messages are not recorded.
+func (o *grpcOptions) newStreamMessages(tracer opentracing.Tracer, span opentracing.Span, method string) *streamMessages {
+	if o.streamMessages == nil {
+		return nil
+	}
+	return &streamMessages{cfg: o.streamMessages, tracer: tracer, span: span, method: method}
+}
+
+// sampled tells whether the message at index is recorded.
+func (s *streamMessages) sampled(index int) bool {
+	return s != nil && (index < s.cfg.First || (index-s.cfg.First)%s.cfg.Every == 0)
+}
+
+// record records the message m at index in the direction, sent or received, which took from start.
+func (s *streamMessages) record(direction string, index int, m interface{}, start time.Time, err error) {
+	size := -1
+	if msg, ok := m.(proto.Message); ok && err == nil {
+		size = proto.Size(msg)
+	}
+
+	if s.cfg.Events {
+		fields := []interface{}{"event", "message " + direction, "grpc.message_index", index}
+		if size >= 0 {
+			fields = append(fields, "grpc.message_size", size)
+		}
+		if err != nil {
+			fields = append(fields, "error", err.Error())
+		}
+		s.span.LogKV(fields...)
+		return
+	}
+
+	child := s.tracer.StartSpan(s.method+" "+direction,
+		opentracing.ChildOf(s.span.Context()), opentracing.StartTime(start))
+	child.SetTag("grpc.message_index", index)
+	if size >= 0 {
+		child.SetTag("grpc.message_size", size)
+	}
+	if err != nil {
+		child.SetTag("error", true)
+		child.LogKV("error", err.Error())
+	}
+	child.Finish()
+}
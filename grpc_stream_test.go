@@ -0,0 +1,180 @@
+package obs
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/mixpanel/obs/metrics"
+	basictracer "github.com/opentracing/basictracer-go"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// testServerStream receives received messages, then io.EOF.
+type testServerStream struct {
+	ctx      context.Context
+	received int
+}
+
+func (s *testServerStream) SetHeader(metadata.MD) error  { return nil }
+func (s *testServerStream) SendHeader(metadata.MD) error { return nil }
+func (s *testServerStream) SetTrailer(metadata.MD)       {}
+func (s *testServerStream) Context() context.Context     { return s.ctx }
+func (s *testServerStream) SendMsg(m interface{}) error  { return nil }
+
+func (s *testServerStream) RecvMsg(m interface{}) error {
+	if s.received == 0 {
+		return io.EOF
+	}
+	s.received--
+	return nil
+}
+
+// testClientStream receives received messages, then err.
+type testClientStream struct {
+	ctx      context.Context
+	received int
+	err      error
+}
+
+func (s *testClientStream) Header() (metadata.MD, error) { return nil, nil }
+func (s *testClientStream) Trailer() metadata.MD         { return nil }
+func (s *testClientStream) CloseSend() error             { return nil }
+func (s *testClientStream) Context() context.Context     { return s.ctx }
+func (s *testClientStream) SendMsg(m interface{}) error  { return nil }
+
+func (s *testClientStream) RecvMsg(m interface{}) error {
+	if s.received == 0 {
+		return s.err
+	}
+	s.received--
+	return nil
+}
+
+func TestClientStreamFailure(t *testing.T) {
+	sink := metrics.NewMockSink()
+	recorder := basictracer.NewInMemoryRecorder()
+	opts := basictracer.DefaultOptions()
+	opts.Recorder = recorder
+	opts.ShouldSample = func(uint64) bool { return true }
+	fr := NewFlightRecorder("test", metrics.NewReceiver(sink), &testLogger{}, basictracer.NewWithOptions(opts))
+	interceptor := tracingStreamClientInterceptor(fr, fr.(*flightRecorder).tr, newGRPCOptions(nil))
+
+	unavailable := status.Error(codes.Unavailable, "server gone")
+	streamer := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return &testClientStream{ctx: ctx, received: 1, err: unavailable}, nil
+	}
+	cs, err := interceptor(context.Background(), &grpc.StreamDesc{ServerStreams: true}, nil, "/company.Users/Watch", streamer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, cs.RecvMsg(nil))
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, unavailable, cs.RecvMsg(nil))
+	}
+
+	failing := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return nil, unavailable
+	}
+	cs, err = interceptor(context.Background(), &grpc.StreamDesc{ServerStreams: true}, nil, "/company.Users/Watch", failing)
+	assert.Nil(t, cs)
+	assert.Equal(t, unavailable, err)
+
+	spans := recorder.GetSpans()
+	if assert.Len(t, spans, 2, "each stream span is finished once") {
+		for _, span := range spans {
+			assert.Equal(t, true, span.Tags["error"])
+		}
+		assert.Equal(t, 1, spans[0].Tags["grpc.stream_received"])
+	}
+	latencies := 0
+	for k, n := range sink.Invocations {
+		if strings.HasPrefix(k, "grpc_client.latency_us,") {
+			latencies += n
+		}
+	}
+	assert.Equal(t, 2, latencies)
+}
+
+func TestStreamMessageSpans(t *testing.T) {
+	fr, _, recorder := newTestFlightRecorder()
+	interceptor := tracingStreamServerInterceptor(fr, fr.(*flightRecorder).tr, newGRPCOptions([]GRPCOption{
+		GRPCStreamMessageSpans(StreamMessageSpans{First: 2, Every: 5}),
+	}))
+
+	msg := ptypes.DurationProto(time.Second)
+	handler := func(srv interface{}, ss grpc.ServerStream) error {
+		for ss.RecvMsg(msg) == nil {
+		}
+		for i := 0; i < 15; i++ {
+			ss.SendMsg(msg)
+		}
+		return nil
+	}
+	ss := &testServerStream{ctx: context.Background(), received: 3}
+	err := interceptor(nil, ss, &grpc.StreamServerInfo{FullMethod: "/company.Service/Watch"}, handler)
+	assert.NoError(t, err)
+
+	var sent, received []interface{}
+	for _, span := range recorder.GetSpans() {
+		switch span.Operation {
+		case "/company.Service/Watch sent":
+			sent = append(sent, span.Tags["grpc.message_index"])
+			assert.Equal(t, proto.Size(msg), span.Tags["grpc.message_size"])
+		case "/company.Service/Watch received":
+			received = append(received, span.Tags["grpc.message_index"])
+		}
+	}
+	assert.Equal(t, []interface{}{0, 1, 2, 7, 12}, sent)
+	// the final io.EOF is not recorded
+	assert.Equal(t, []interface{}{0, 1, 2}, received)
+}
+
+func TestStreamMessageEvents(t *testing.T) {
+	fr, _, recorder := newTestFlightRecorder()
+	interceptor := tracingStreamServerInterceptor(fr, fr.(*flightRecorder).tr, newGRPCOptions([]GRPCOption{
+		GRPCStreamMessageSpans(StreamMessageSpans{Events: true}),
+	}))
+
+	handler := func(srv interface{}, ss grpc.ServerStream) error {
+		return ss.SendMsg(ptypes.DurationProto(time.Second))
+	}
+	ss := &testServerStream{ctx: context.Background()}
+	assert.NoError(t, interceptor(nil, ss, &grpc.StreamServerInfo{FullMethod: "/company.Service/Watch"}, handler))
+
+	spans := recorder.GetSpans()
+	if assert.Len(t, spans, 1) {
+		events := 0
+		for _, l := range spans[0].Logs {
+			for _, f := range l.Fields {
+				if f.Key() == "event" && f.Value() == "message sent" {
+					events++
+				}
+			}
+		}
+		assert.Equal(t, 1, events)
+	}
+}
+
+func TestStreamMessagesSampled(t *testing.T) {
+	var s *streamMessages
+	assert.False(t, s.sampled(0))
+
+	s = &streamMessages{cfg: &StreamMessageSpans{First: 10, Every: 100}}
+	var sampled []int
+	for i := 0; i < 1000; i++ {
+		if s.sampled(i) {
+			sampled = append(sampled, i)
+		}
+	}
+	assert.Len(t, sampled, 20)
+	assert.Equal(t, 110, sampled[11])
+}
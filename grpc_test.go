@@ -1,8 +1,366 @@
 package obs
 
-import "fmt"
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/mixpanel/obs/metrics"
+	"github.com/mixpanel/obs/obserr"
+	basictracer "github.com/opentracing/basictracer-go"
+	"github.com/opentracing/opentracing-go"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/stats"
+	"google.golang.org/grpc/status"
+)
 
 func Example_formatRPCName() {
 	fmt.Println(formatRPCName("/Company.Service/Method"))
 	// Output: Service.Method
 }
+
+func TestClientInterceptorSkipMethods(t *testing.T) {
+	fr, _, recorder := newTestFlightRecorder()
+	o := newGRPCOptions([]GRPCOption{
+		GRPCSkipMethods(HealthAndReflectionMethods...),
+		GRPCSkipMethodsMatching(regexp.MustCompile(`^/internal\.`)),
+	})
+	interceptor := tracingUnaryClientInterceptor(fr, fr.(*flightRecorder).tr, o)
+
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		return nil
+	}
+	for _, method := range []string{
+		"/grpc.health.v1.Health/Check",
+		"/internal.Debug/Dump",
+		"/company.Service/Method",
+	} {
+		assert.NoError(t, interceptor(context.Background(), method, nil, nil, nil, invoker))
+	}
+
+	spans := recorder.GetSpans()
+	if assert.Len(t, spans, 1) {
+		assert.Equal(t, "test.Service.Method", spans[0].Operation)
+	}
+}
+
+func TestChainUnaryServerInterceptors(t *testing.T) {
+	fr, _, recorder := newTestFlightRecorder()
+
+	var calls []string
+	record := func(name string) grpc.UnaryServerInterceptor {
+		return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			calls = append(calls, name)
+			_, hasSpan := fr.WithSpan(ctx).TraceID()
+			assert.True(t, hasSpan)
+			return handler(ctx, req)
+		}
+	}
+	interceptor := chainUnaryServerInterceptors([]grpc.UnaryServerInterceptor{
+		tracingUnaryServerInterceptor(fr, tracerOf(fr), newGRPCOptions(nil)),
+		record("first"),
+		record("second"),
+	})
+
+	info := &grpc.UnaryServerInfo{FullMethod: "/company.Service/Method"}
+	resp, err := interceptor(context.Background(), "req", info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		calls = append(calls, "handler")
+		return req, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "req", resp)
+	assert.Equal(t, []string{"first", "second", "handler"}, calls)
+	assert.Len(t, recorder.GetSpans(), 1)
+}
+
+func TestServerTiming(t *testing.T) {
+	ctx := rpcBeginHandler{}.TagRPC(context.Background(), &stats.RPCTagInfo{})
+	begin := ctx.Value(rpcBeginKey{}).(time.Time)
+
+	trailer := serverTiming(ctx, begin)
+	assert.Len(t, trailer.Get(serverTimeTrailer), 1)
+	assert.Equal(t, []string{"0"}, trailer.Get(serverQueueTrailer))
+	assert.Empty(t, serverTiming(context.Background(), begin).Get(serverQueueTrailer))
+
+	fr, _, recorder := newTestFlightRecorder()
+	fs, _, done := fr.WithNewSpan(context.Background(), "client")
+	tagServerTiming(fs.TraceSpan(), metadata.Pairs(serverTimeTrailer, "300", serverQueueTrailer, "200"), time.Millisecond)
+	done()
+
+	spans := recorder.GetSpans()
+	if assert.Len(t, spans, 1) {
+		assert.Equal(t, int64(300), spans[0].Tags["grpc.server_time_us"])
+		assert.Equal(t, int64(200), spans[0].Tags["grpc.server_queue_us"])
+		assert.Equal(t, int64(500), spans[0].Tags["grpc.network_time_us"])
+	}
+}
+
+func TestLogPayloads(t *testing.T) {
+	fr, l, _ := newTestFlightRecorder()
+	o := newGRPCOptions([]GRPCOption{GRPCLogPayloads(PayloadLogging{
+		Methods: []string{"/company.Service/Login"},
+		Redact:  []string{"password", "user.email"},
+		MaxSize: 128,
+	})})
+	interceptor := tracingUnaryServerInterceptor(fr, fr.(*flightRecorder).tr, o)
+
+	type user struct {
+		Name  string `json:"name"`
+		Email string `json:"email"`
+	}
+	req := map[string]interface{}{
+		"user":     user{"alice", "alice@example.com"},
+		"password": "hunter2",
+		"email":    "kept@example.com",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return map[string]string{"token": strings.Repeat("x", 200)}, nil
+	}
+	for _, method := range []string{"/company.Service/Login", "/company.Service/Other"} {
+		_, err := interceptor(context.Background(), req, &grpc.UnaryServerInfo{FullMethod: method}, handler)
+		assert.NoError(t, err)
+	}
+
+	if assert.Len(t, l.entries, 2) {
+		assert.Equal(t, "DEBUG", l.entries[0].level)
+		assert.Equal(t, "/company.Service/Login", l.entries[0].fields["grpc.method"])
+		assert.Equal(t,
+			`{"email":"kept@example.com","password":"[REDACTED]","user":{"email":"[REDACTED]","name":"alice"}}`,
+			l.entries[0].fields["grpc.request"])
+		assert.Equal(t, `{"token":"`+strings.Repeat("x", 118)+`...(truncated 84 bytes)`, l.entries[1].fields["grpc.response"])
+	}
+}
+
+func TestFormatProtoPayload(t *testing.T) {
+	p := newPayloadLogger(PayloadLogging{})
+	assert.Equal(t, `"1.500s"`, p.format(ptypes.DurationProto(1500*time.Millisecond)))
+}
+
+func TestInterceptorErrorCodes(t *testing.T) {
+	sink := &metrics.MockSink{Invocations: make(map[string]int)}
+	fr := NewFlightRecorder("test", metrics.NewReceiver(sink), &testLogger{}, opentracing.NoopTracer{})
+	interceptor := tracingUnaryServerInterceptor(fr, opentracing.NoopTracer{}, newGRPCOptions(nil))
+
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, obserr.New("overloaded").WithCode("unavailable")
+	}
+	_, err := interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/company.Service/Get"}, handler)
+	assert.Error(t, err)
+
+	assert.Equal(t, 1, sink.Invocations["errors_total, map[error_code:unavailable operation:test.Service.Get retryable:true], 1, ct\n"])
+}
+
+func TestUntracedMethods(t *testing.T) {
+	sink := metrics.NewMockSink()
+	recorder := basictracer.NewInMemoryRecorder()
+	opts := basictracer.DefaultOptions()
+	opts.Recorder = recorder
+	opts.ShouldSample = func(uint64) bool { return true }
+	tracer := basictracer.NewWithOptions(opts)
+	fr := NewFlightRecorder("test", metrics.NewReceiver(sink), &testLogger{}, tracer)
+	interceptor := tracingUnaryServerInterceptor(fr, tracer, newGRPCOptions([]GRPCOption{
+		GRPCUntracedMethods(HealthAndReflectionMethods...),
+		GRPCSkipMethods("/company.Service/Skipped"),
+	}))
+
+	var sampled []bool
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		if span := opentracing.SpanFromContext(ctx); span != nil {
+			sampled = append(sampled, span.Context().(basictracer.SpanContext).Sampled)
+		}
+		return nil, nil
+	}
+	for _, method := range []string{"/grpc.health.v1.Health/Check", "/company.Service/Get", "/company.Service/Skipped"} {
+		_, err := interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: method}, handler)
+		assert.NoError(t, err)
+	}
+
+	assert.Equal(t, []bool{false, true}, sampled)
+	assert.Equal(t, 1, sink.Invocations["grpc_server.Health.Check.OK, map[], 1, ct\n"])
+	assert.Equal(t, 1, sink.Invocations["grpc_server.Service.Get.OK, map[], 1, ct\n"])
+	assert.Equal(t, 0, sink.Invocations["grpc_server.Service.Skipped.OK, map[], 1, ct\n"])
+}
+
+func TestClientLatency(t *testing.T) {
+	sink := metrics.NewMockSink()
+	fr := NewFlightRecorder("test", metrics.NewReceiver(sink), &testLogger{}, opentracing.NoopTracer{})
+	interceptor := tracingUnaryClientInterceptor(fr, opentracing.NoopTracer{}, newGRPCOptions(nil))
+
+	cc, err := grpc.Dial("dns:///users.internal:443", grpc.WithInsecure())
+	assert.NoError(t, err)
+	defer cc.Close()
+
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		return nil
+	}
+	assert.NoError(t, interceptor(context.Background(), "/company.Users/Get", nil, nil, cc, invoker))
+
+	var latencies []string
+	for k := range sink.Invocations {
+		if strings.HasPrefix(k, "grpc_client.latency_us,") {
+			latencies = append(latencies, k)
+		}
+	}
+	if assert.Len(t, latencies, 1) {
+		assert.Contains(t, latencies[0], "map[method:Users.Get target:users.internal]")
+		assert.True(t, strings.HasSuffix(latencies[0], ", h\n"))
+	}
+}
+
+func TestClientTarget(t *testing.T) {
+	for target, expected := range map[string]string{
+		"users.internal:443":           "users.internal",
+		"dns:///users.internal:443":    "users.internal",
+		"dns://8.8.8.8/users.internal": "users.internal",
+		"passthrough:///":              "unknown",
+	} {
+		cc, err := grpc.Dial(target, grpc.WithInsecure())
+		if assert.NoError(t, err) {
+			assert.Equal(t, expected, clientTarget(cc), target)
+			cc.Close()
+		}
+	}
+	assert.Equal(t, "unknown", clientTarget(nil))
+}
+
+func TestRetryAttempts(t *testing.T) {
+	sink := metrics.NewMockSink()
+	recorder := basictracer.NewInMemoryRecorder()
+	opts := basictracer.DefaultOptions()
+	opts.Recorder = recorder
+	opts.ShouldSample = func(uint64) bool { return true }
+	fr := NewFlightRecorder("test", metrics.NewReceiver(sink), &testLogger{}, basictracer.NewWithOptions(opts))
+	interceptor := tracingUnaryClientInterceptor(fr, fr.(*flightRecorder).tr, newGRPCOptions([]GRPCOption{GRPCRetryAttempts()}))
+
+	for _, attempts := range []int{1, 3} {
+		invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			for i := 0; i < attempts; i++ {
+				rpcAttemptsHandler{}.HandleRPC(ctx, &stats.OutHeader{Client: true})
+			}
+			return nil
+		}
+		assert.NoError(t, interceptor(context.Background(), "/company.Users/Get", nil, nil, nil, invoker))
+	}
+
+	var rpcs, attemptSpans []basictracer.RawSpan
+	for _, span := range recorder.GetSpans() {
+		switch span.Operation {
+		case "test.Users.Get":
+			rpcs = append(rpcs, span)
+		case "test.Users.Get.attempt":
+			attemptSpans = append(attemptSpans, span)
+		}
+	}
+	if assert.Len(t, rpcs, 2) && assert.Len(t, attemptSpans, 4) {
+		assert.Equal(t, 1, rpcs[0].Tags["grpc.attempts"])
+		assert.Nil(t, rpcs[0].Tags["grpc.retried"])
+		assert.Equal(t, 3, rpcs[1].Tags["grpc.attempts"])
+		assert.Equal(t, true, rpcs[1].Tags["grpc.retried"])
+		for i, attempt := range attemptSpans[1:] {
+			assert.Equal(t, i+1, attempt.Tags["grpc.attempt"])
+			assert.Equal(t, rpcs[1].Context.SpanID, attempt.ParentSpanID)
+		}
+	}
+	assert.Equal(t, 1, sink.Invocations["grpc_client.Users.Get.retried, map[], 1, ct\n"])
+	assert.Equal(t, 1, sink.Invocations["grpc_client.Users.Get.attempts, map[], 3, h\n"])
+	assert.Len(t, GRPCDialOptions(fr, GRPCRetryAttempts()), 3)
+}
+
+func TestRetryAttemptsFailedStream(t *testing.T) {
+	sink := metrics.NewMockSink()
+	recorder := basictracer.NewInMemoryRecorder()
+	opts := basictracer.DefaultOptions()
+	opts.Recorder = recorder
+	opts.ShouldSample = func(uint64) bool { return true }
+	fr := NewFlightRecorder("test", metrics.NewReceiver(sink), &testLogger{}, basictracer.NewWithOptions(opts))
+	interceptor := tracingStreamClientInterceptor(fr, fr.(*flightRecorder).tr, newGRPCOptions([]GRPCOption{GRPCRetryAttempts()}))
+
+	streamer := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		rpcAttemptsHandler{}.HandleRPC(ctx, &stats.OutHeader{Client: true})
+		rpcAttemptsHandler{}.HandleRPC(ctx, &stats.OutHeader{Client: true})
+		return &testClientStream{ctx: ctx, err: status.Error(codes.Unavailable, "server gone")}, nil
+	}
+	cs, err := interceptor(context.Background(), &grpc.StreamDesc{ServerStreams: true}, nil, "/company.Users/Watch", streamer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Error(t, cs.RecvMsg(nil))
+
+	var rpcs, attemptSpans []basictracer.RawSpan
+	for _, span := range recorder.GetSpans() {
+		switch span.Operation {
+		case "test.Users.Watch":
+			rpcs = append(rpcs, span)
+		case "test.Users.Watch.attempt":
+			attemptSpans = append(attemptSpans, span)
+		}
+	}
+	if assert.Len(t, rpcs, 1) && assert.Len(t, attemptSpans, 2, "the last attempt ends with the stream") {
+		assert.Equal(t, 2, rpcs[0].Tags["grpc.attempts"])
+		assert.Equal(t, true, rpcs[0].Tags["grpc.retried"])
+	}
+	assert.Equal(t, 1, sink.Invocations["grpc_client.Users.Watch.retried, map[], 1, ct\n"])
+	assert.Equal(t, 1, sink.Invocations["grpc_client.Users.Watch.attempts, map[], 2, h\n"])
+}
+
+func TestPreRegisterGRPCMethods(t *testing.T) {
+	sink := metrics.NewMockSink()
+	fr := NewFlightRecorder("test", metrics.NewReceiver(sink), &testLogger{}, opentracing.NoopTracer{})
+	server := grpc.NewServer()
+	healthpb.RegisterHealthServer(server, NewHealthServer(fr))
+
+	PreRegisterGRPCMethods(fr, server, GRPCSkipMethods("/grpc.health.v1.Health/Watch"),
+		GRPCPreRegisteredCodes(codes.OK, codes.Unavailable))
+	assert.Equal(t, 1, sink.Invocations["grpc_server.Health.Check.OK, map[], 0, ct\n"])
+	assert.Equal(t, 1, sink.Invocations["grpc_server.Health.Check.Unavailable, map[], 0, ct\n"])
+	assert.Equal(t, 2, sink.NumInvocations())
+
+	PreRegisterGRPCMethods(fr, server, GRPCCallerMetricTag())
+	assert.Equal(t, 1, sink.Invocations["grpc_server.Health.Check.OK, map[caller:unknown], 0, ct\n"])
+	assert.Equal(t, 1, sink.Invocations["grpc_server.Health.Watch.OK, map[caller:unknown], 0, ct\n"])
+}
+
+func BenchmarkUntracedUnaryServerInterceptor(b *testing.B) {
+	fr := newUntracedFlightRecorder()
+	interceptor := tracingUnaryServerInterceptor(fr, opentracing.NoopTracer{}, newGRPCOptions(nil))
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("user-agent", "billing/1.2 grpc-go/1.23.1"))
+	info := &grpc.UnaryServerInfo{FullMethod: "/company.Service/Get"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) { return nil, nil }
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		interceptor(ctx, nil, info, handler)
+	}
+}
+
+func BenchmarkUntracedUnaryClientInterceptor(b *testing.B) {
+	fr := newUntracedFlightRecorder()
+	interceptor := tracingUnaryClientInterceptor(fr, opentracing.NoopTracer{}, newGRPCOptions(nil))
+	ctx := metadata.NewOutgoingContext(context.Background(), metadata.Pairs("x-request-id", "1"))
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		return nil
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		interceptor(ctx, "/company.Service/Get", nil, nil, nil, invoker)
+	}
+}
+
+func TestUntracedClientKeepsMetadata(t *testing.T) {
+	fr := newUntracedFlightRecorder()
+	interceptor := tracingUnaryClientInterceptor(fr, opentracing.NoopTracer{}, newGRPCOptions(nil))
+	md := metadata.Pairs("x-request-id", "1")
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		outgoing, _ := metadata.FromOutgoingContext(ctx)
+		assert.Equal(t, md, outgoing)
+		return nil
+	}
+	assert.NoError(t, interceptor(metadata.NewOutgoingContext(context.Background(), md), "/company.Service/Get", nil, nil, nil, invoker))
+}
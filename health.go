@@ -0,0 +1,172 @@
+package obs
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mixpanel/obs/health"
+	"github.com/mixpanel/obs/logging"
+	"github.com/mixpanel/obs/metrics"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// HealthWatchInterval is how often the checks are run for each Watch call of a HealthServer.
+var HealthWatchInterval = 5 * time.Second
+
+// HealthCheck returns an error describing why a dependency of the service is not ready, or nil.
+type HealthCheck func(ctx context.Context) error
+
+// HealthServer implements the grpc.health.v1.Health service, so that Kubernetes probes, and load
+// balancers, only send traffic to services whose dependencies are ready. It serves the readiness
+// checks of a health.Registry, which bounds and caches their runs. The status of the service named ""
+// aggregates the checks added with AddCheck or to the registry, and the checks of the observability
+// pipeline of the FlightRecorder set up by InitGCP: whether statsd is reachable, and whether the last
+// spans were exported. The status of a service named like a check only reflects that check.
+//
+// Register it with healthpb.RegisterHealthServer(s, hs).
+type HealthServer struct {
+	fr       *flightRecorder
+	registry *health.Registry
+
+	mutex    sync.Mutex // guards statuses
+	statuses map[string]healthpb.HealthCheckResponse_ServingStatus
+}
+
+// NewHealthServer returns a HealthServer running the checks of the observability pipeline of fr, and
+// logging changes of status with it. Its registry reports whether the checks failed with the
+// health.check_failed gauges of fr.
+func NewHealthServer(fr FlightRecorder) *HealthServer {
+	var receiver metrics.Receiver
+	if f, ok := fr.(*flightRecorder); ok {
+		receiver = f.mr
+	}
+	return NewHealthServerWithRegistry(fr, health.NewRegistry(health.Config{Receiver: receiver}))
+}
+
+// NewHealthServerWithRegistry is like NewHealthServer, serving the checks of r, to which it adds the
+// checks of the observability pipeline of fr. Pass the registry serving /readyz for both to report the
+// same checks.
+func NewHealthServerWithRegistry(fr FlightRecorder, r *health.Registry) *HealthServer {
+	h := &HealthServer{
+		registry: r,
+		statuses: make(map[string]healthpb.HealthCheckResponse_ServingStatus),
+	}
+	h.fr, _ = fr.(*flightRecorder)
+	if h.fr != nil {
+		for name, check := range h.fr.healthChecks {
+			r.Register(name, health.Check(check))
+		}
+	}
+	return h
+}
+
+// AddCheck adds a readiness check to the registry, replacing the check with the same name if there is
+// one.
+func (h *HealthServer) AddCheck(name string, check HealthCheck) {
+	h.registry.Register(name, health.Check(check))
+}
+
+// Shutdown makes every service not serving from now on, for instance while the server drains.
+func (h *HealthServer) Shutdown() {
+	h.registry.Shutdown()
+}
+
+func (h *HealthServer) Check(ctx context.Context, req *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	st, ok := h.status(ctx, req.Service)
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "unknown service %q", req.Service)
+	}
+	return &healthpb.HealthCheckResponse{Status: st}, nil
+}
+
+func (h *HealthServer) Watch(req *healthpb.HealthCheckRequest, stream healthpb.Health_WatchServer) error {
+	ctx := stream.Context()
+	last := healthpb.HealthCheckResponse_UNKNOWN
+	for {
+		st, ok := h.status(ctx, req.Service)
+		if !ok {
+			st = healthpb.HealthCheckResponse_SERVICE_UNKNOWN
+		}
+		if st != last {
+			if err := stream.Send(&healthpb.HealthCheckResponse{Status: st}); err != nil {
+				return err
+			}
+			last = st
+		}
+
+		select {
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		case <-time.After(HealthWatchInterval):
+		}
+	}
+}
+
+// status runs the checks of service, and returns its status, or false if it is unknown.
+func (h *HealthServer) status(ctx context.Context, service string) (healthpb.HealthCheckResponse_ServingStatus, bool) {
+	var report health.Report
+	if service == "" {
+		report = h.registry.Ready(ctx)
+	} else {
+		var ok bool
+		if report, ok = h.registry.Check(ctx, service); !ok {
+			return healthpb.HealthCheckResponse_SERVICE_UNKNOWN, false
+		}
+	}
+
+	st := healthpb.HealthCheckResponse_SERVING
+	if report.Status != health.StatusOK {
+		st = healthpb.HealthCheckResponse_NOT_SERVING
+	}
+	failures := logging.Fields{}
+	for name, result := range report.Checks {
+		if result.Status != health.StatusOK {
+			failures[name] = result.Error
+		}
+	}
+	h.record(service, st, failures)
+	return st, true
+}
+
+// record logs the changes of status of service.
+func (h *HealthServer) record(service string, st healthpb.HealthCheckResponse_ServingStatus, failures logging.Fields) {
+	h.mutex.Lock()
+	last, known := h.statuses[service]
+	h.statuses[service] = st
+	h.mutex.Unlock()
+	if h.fr == nil || (known && last == st) {
+		return
+	}
+
+	fields := logging.Fields{"service": service, "status": st.String()}
+	if len(failures) > 0 {
+		fields["failed_checks"] = failures
+	}
+	if st == healthpb.HealthCheckResponse_SERVING {
+		h.fr.l.Info("health status changed", fields)
+	} else {
+		h.fr.l.Warn("health status changed", fields)
+	}
+}
+
+// exportHealth records the result of the last export of a pipeline, such as the export of spans.
+type exportHealth struct {
+	err atomic.Value // exportResult
+}
+
+type exportResult struct {
+	err error
+}
+
+func (h *exportHealth) record(err error) {
+	h.err.Store(exportResult{err})
+}
+
+func (h *exportHealth) check(ctx context.Context) error {
+	result, _ := h.err.Load().(exportResult)
+	return result.err
+}
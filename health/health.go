@@ -0,0 +1,330 @@
+// Package health serves the /healthz and /readyz endpoints of HTTP services. obs.HealthServer serves
+// the checks of a Registry over gRPC: give it the Registry serving the endpoints for both to report
+// the same checks. Checks are registered by name, run at most once per Config.CacheTTL
+// however often the endpoints are probed, and bounded by Config.Timeout. The endpoints answer 200, or
+// 503 when a check fails, with the result of every check as JSON:
+//
+//	{"status": "failed", "checks": {"db": {"status": "failed", "error": "connection refused", "latency_ms": 3.2}}}
+//
+// The registry also reports, for every check, whether it failed the last time it ran as the gauge
+// health.check_failed tagged with the check.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/mixpanel/obs/clock"
+	"github.com/mixpanel/obs/metrics"
+)
+
+// Check returns an error describing why a dependency of the service is not healthy, or nil. ctx is
+// canceled when the check times out.
+type Check func(ctx context.Context) error
+
+// Statuses of checks and reports.
+const (
+	StatusOK     = "ok"
+	StatusFailed = "failed"
+)
+
+// Config configures a Registry.
+type Config struct {
+	// Timeout bounds every run of a check. Defaults to 5 seconds.
+	Timeout time.Duration
+	// CacheTTL is how long the result of a check is reused before it is run again. Defaults to 1
+	// second.
+	CacheTTL time.Duration
+	// Receiver gets the health.check_failed gauges. Defaults to metrics.Null.
+	Receiver metrics.Receiver
+	// Clock defaults to clock.Real.
+	Clock clock.Clock
+}
+
+// Result is the outcome of the last run of a check.
+type Result struct {
+	Status    string    `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	LatencyMs float64   `json:"latency_ms"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// Report is the outcome of a set of checks, failed if any of them failed.
+type Report struct {
+	Status string            `json:"status"`
+	Checks map[string]Result `json:"checks"`
+}
+
+// Registry holds the checks of a service.
+type Registry struct {
+	cfg Config
+
+	mutex    sync.Mutex // guards everything below
+	checks   map[string]*entry
+	shutdown bool
+}
+
+// entry is a registered check along with its last result.
+type entry struct {
+	check      Check
+	liveness   bool
+	unregister func()
+
+	mutex   sync.Mutex // guards everything below
+	result  Result
+	running chan struct{} // closed when the current run ends, nil when the check is not running
+}
+
+// Default is the registry of the package functions. Replace it, before registering checks, to
+// configure it.
+var Default = NewRegistry(Config{})
+
+// NewRegistry returns an empty Registry.
+func NewRegistry(cfg Config) *Registry {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 5 * time.Second
+	}
+	if cfg.CacheTTL <= 0 {
+		cfg.CacheTTL = time.Second
+	}
+	if cfg.Receiver == nil {
+		cfg.Receiver = metrics.Null
+	}
+	if cfg.Clock == nil {
+		cfg.Clock = clock.Real
+	}
+	return &Registry{cfg: cfg, checks: make(map[string]*entry)}
+}
+
+// Register adds a readiness check to the Default registry.
+func Register(name string, check Check) {
+	Default.Register(name, check)
+}
+
+// RegisterLiveness adds a liveness check to the Default registry.
+func RegisterLiveness(name string, check Check) {
+	Default.RegisterLiveness(name, check)
+}
+
+// Unregister removes a check from the Default registry.
+func Unregister(name string) {
+	Default.Unregister(name)
+}
+
+// Handle serves the endpoints of the Default registry on mux.
+func Handle(mux *http.ServeMux) {
+	Default.Handle(mux)
+}
+
+// Register adds a readiness check, reported by /readyz, replacing the check with the same name if there
+// is one.
+func (r *Registry) Register(name string, check Check) {
+	r.add(name, check, false)
+}
+
+// RegisterLiveness adds a liveness check, reported by both /healthz and /readyz, replacing the check
+// with the same name if there is one. Liveness checks should only fail when restarting the process
+// would help, as for a deadlock, and not when a dependency is down.
+func (r *Registry) RegisterLiveness(name string, check Check) {
+	r.add(name, check, true)
+}
+
+func (r *Registry) add(name string, check Check, liveness bool) {
+	e := &entry{check: check, liveness: liveness}
+	e.unregister = r.cfg.Receiver.ScopeTags(metrics.Tags{"check": name}).RegisterGauge("health.check_failed", e.failed)
+
+	r.mutex.Lock()
+	old := r.checks[name]
+	r.checks[name] = e
+	r.mutex.Unlock()
+	if old != nil {
+		old.unregister()
+	}
+}
+
+// Unregister removes a check.
+func (r *Registry) Unregister(name string) {
+	r.mutex.Lock()
+	e := r.checks[name]
+	delete(r.checks, name)
+	r.mutex.Unlock()
+	if e != nil {
+		e.unregister()
+	}
+}
+
+// Shutdown makes /readyz fail from now on, for instance while the server drains.
+func (r *Registry) Shutdown() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.shutdown = true
+}
+
+// Live runs the liveness checks.
+func (r *Registry) Live(ctx context.Context) Report {
+	return r.run(ctx, func(_ string, e *entry) bool { return e.liveness }, false)
+}
+
+// Ready runs all the checks. The report is failed after Shutdown.
+func (r *Registry) Ready(ctx context.Context) Report {
+	return r.run(ctx, func(string, *entry) bool { return true }, true)
+}
+
+// Check runs the check registered as name, and returns false if there is none. The report is failed
+// after Shutdown.
+func (r *Registry) Check(ctx context.Context, name string) (Report, bool) {
+	r.mutex.Lock()
+	_, ok := r.checks[name]
+	r.mutex.Unlock()
+	if !ok {
+		return Report{}, false
+	}
+	return r.run(ctx, func(n string, _ *entry) bool { return n == name }, true), true
+}
+
+// Handle serves /healthz and /readyz on mux.
+func (r *Registry) Handle(mux *http.ServeMux) {
+	mux.Handle("/healthz", r.LivenessHandler())
+	mux.Handle("/readyz", r.ReadinessHandler())
+}
+
+// LivenessHandler serves the result of Live.
+func (r *Registry) LivenessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		serveReport(w, r.Live(req.Context()))
+	})
+}
+
+// ReadinessHandler serves the result of Ready.
+func (r *Registry) ReadinessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		serveReport(w, r.Ready(req.Context()))
+	})
+}
+
+func serveReport(w http.ResponseWriter, report Report) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if report.Status != StatusOK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(report)
+}
+
+// run runs the included checks concurrently. The report is failed after Shutdown if readiness.
+func (r *Registry) run(ctx context.Context, include func(name string, e *entry) bool, readiness bool) Report {
+	r.mutex.Lock()
+	names := make([]string, 0, len(r.checks))
+	entries := make([]*entry, 0, len(r.checks))
+	for name, e := range r.checks {
+		if include(name, e) {
+			names = append(names, name)
+			entries = append(entries, e)
+		}
+	}
+	shutdown := r.shutdown && readiness
+	r.mutex.Unlock()
+
+	results := make([]Result, len(entries))
+	var wg sync.WaitGroup
+	for i, e := range entries {
+		wg.Add(1)
+		go func(i int, e *entry) {
+			defer wg.Done()
+			results[i] = r.result(ctx, e)
+		}(i, e)
+	}
+	wg.Wait()
+
+	report := Report{Status: StatusOK, Checks: make(map[string]Result, len(entries))}
+	for i, name := range names {
+		report.Checks[name] = results[i]
+		if results[i].Status != StatusOK {
+			report.Status = StatusFailed
+		}
+	}
+	if shutdown {
+		report.Status = StatusFailed
+	}
+	return report
+}
+
+// result returns the last result of e if it is recent enough, or runs it. Concurrent callers share
+// the same run.
+func (r *Registry) result(ctx context.Context, e *entry) Result {
+	e.mutex.Lock()
+	if !e.result.CheckedAt.IsZero() && r.cfg.Clock.Since(e.result.CheckedAt) < r.cfg.CacheTTL {
+		defer e.mutex.Unlock()
+		return e.result
+	}
+	running := e.running
+	if running == nil {
+		running = make(chan struct{})
+		e.running = running
+		go r.execute(e, running)
+	}
+	e.mutex.Unlock()
+
+	select {
+	case <-running:
+	case <-ctx.Done():
+		return Result{Status: StatusFailed, Error: ctx.Err().Error(), CheckedAt: r.cfg.Clock.Now()}
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.result
+}
+
+// execute runs the check of e, and closes running once its result is recorded. A check that does not
+// return in time fails, and keeps running in the background until it returns.
+func (r *Registry) execute(e *entry, running chan struct{}) {
+	ctx, cancel := context.WithTimeout(context.Background(), r.cfg.Timeout)
+	defer cancel()
+
+	start := r.cfg.Clock.Now()
+	errs := make(chan error, 1)
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				errs <- fmt.Errorf("panic: %v", p)
+			}
+		}()
+		errs <- e.check(ctx)
+	}()
+	var err error
+	select {
+	case err = <-errs:
+	case <-ctx.Done():
+		err = fmt.Errorf("timed out after %v", r.cfg.Timeout)
+	}
+
+	result := Result{
+		Status:    StatusOK,
+		LatencyMs: float64(r.cfg.Clock.Since(start)) / float64(time.Millisecond),
+		CheckedAt: r.cfg.Clock.Now(),
+	}
+	if err != nil {
+		result.Status = StatusFailed
+		result.Error = err.Error()
+	}
+
+	e.mutex.Lock()
+	e.result = result
+	e.running = nil
+	e.mutex.Unlock()
+	close(running)
+}
+
+// failed returns 1 if the last run of the check failed, and 0 otherwise.
+func (e *entry) failed() float64 {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if e.result.Status == StatusFailed {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,82 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mixpanel/obs/clock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistry(t *testing.T) {
+	c := clock.NewFake(time.Unix(0, 0).UTC())
+	r := NewRegistry(Config{CacheTTL: time.Minute, Clock: c})
+	runs := 0
+	dbErr := error(nil)
+	r.Register("db", func(context.Context) error {
+		runs++
+		return dbErr
+	})
+	r.RegisterLiveness("loop", func(context.Context) error { return nil })
+	mux := http.NewServeMux()
+	r.Handle(mux)
+
+	get := func(path string) (int, Report) {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		var report Report
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+		return w.Code, report
+	}
+
+	code, report := get("/readyz")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, StatusOK, report.Status)
+	assert.Len(t, report.Checks, 2)
+
+	dbErr = errors.New("connection refused")
+	code, _ = get("/readyz")
+	assert.Equal(t, http.StatusOK, code, "results are cached")
+	assert.Equal(t, 1, runs)
+
+	c.Advance(time.Minute)
+	code, report = get("/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, Result{Status: StatusFailed, Error: "connection refused", CheckedAt: c.Now()}, report.Checks["db"])
+	assert.Equal(t, 2, runs)
+	assert.Equal(t, 1.0, r.checks["db"].failed())
+	assert.Equal(t, 0.0, r.checks["loop"].failed())
+
+	code, report = get("/healthz")
+	assert.Equal(t, http.StatusOK, code, "only liveness checks are run")
+	assert.Len(t, report.Checks, 1)
+
+	r.Unregister("db")
+	r.Shutdown()
+	code, report = get("/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, StatusOK, report.Checks["loop"].Status)
+	code, _ = get("/healthz")
+	assert.Equal(t, http.StatusOK, code)
+}
+
+func TestRegistryTimeout(t *testing.T) {
+	r := NewRegistry(Config{Timeout: 10 * time.Millisecond})
+	release := make(chan struct{})
+	defer close(release)
+	r.Register("stuck", func(context.Context) error {
+		<-release
+		return nil
+	})
+	r.Register("panicky", func(context.Context) error { panic("boom") })
+
+	report := r.Ready(context.Background())
+	assert.Equal(t, StatusFailed, report.Status)
+	assert.Equal(t, "timed out after 10ms", report.Checks["stuck"].Error)
+	assert.Equal(t, "panic: boom", report.Checks["panicky"].Error)
+}
@@ -0,0 +1,135 @@
+package obs
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mixpanel/obs/health"
+	"github.com/mixpanel/obs/logging"
+	"github.com/mixpanel/obs/metrics"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+func TestHealthServer(t *testing.T) {
+	fr := NewFlightRecorder("test", metrics.Null, logging.Null, opentracing.NoopTracer{}).(*flightRecorder)
+	statsdErr := error(nil)
+	fr.healthChecks = map[string]HealthCheck{
+		"statsd": func(context.Context) error { return statsdErr },
+	}
+	// run the checks every time
+	h := NewHealthServerWithRegistry(fr.ScopeName("scoped"), health.NewRegistry(health.Config{CacheTTL: time.Nanosecond}))
+	dbErr := error(nil)
+	h.AddCheck("db", func(context.Context) error { return dbErr })
+
+	check := func(service string) healthpb.HealthCheckResponse_ServingStatus {
+		resp, err := h.Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+		assert.NoError(t, err)
+		return resp.GetStatus()
+	}
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, check(""))
+
+	dbErr = errors.New("connection refused")
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, check(""))
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, check("db"))
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, check("statsd"))
+
+	dbErr = nil
+	statsdErr = errors.New("not connected to statsd")
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, check(""))
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, check("db"))
+
+	_, err := h.Check(context.Background(), &healthpb.HealthCheckRequest{Service: "unknown"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	statsdErr = nil
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, check(""))
+	h.Shutdown()
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, check(""))
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, check("db"))
+}
+
+func TestHealthServerCheckTimeout(t *testing.T) {
+	h := NewHealthServerWithRegistry(NullFlightRecorder, health.NewRegistry(health.Config{Timeout: 10 * time.Millisecond}))
+	h.AddCheck("hanging", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	h.AddCheck("db", func(context.Context) error { return nil })
+
+	start := time.Now()
+	resp, err := h.Check(context.Background(), &healthpb.HealthCheckRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, resp.GetStatus())
+	assert.True(t, time.Since(start) < time.Second)
+
+	resp, err = h.Check(context.Background(), &healthpb.HealthCheckRequest{Service: "db"})
+	assert.NoError(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, resp.GetStatus())
+}
+
+// watchStream records the statuses sent to a Watch call, and cancels it after the second.
+type watchStream struct {
+	grpc.ServerStream
+	ctx      context.Context
+	cancel   func()
+	statuses []healthpb.HealthCheckResponse_ServingStatus
+}
+
+func (s *watchStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *watchStream) Send(resp *healthpb.HealthCheckResponse) error {
+	s.statuses = append(s.statuses, resp.Status)
+	if len(s.statuses) == 2 {
+		s.cancel()
+	}
+	return nil
+}
+
+func TestHealthServerWatch(t *testing.T) {
+	defer func(interval time.Duration) { HealthWatchInterval = interval }(HealthWatchInterval)
+	HealthWatchInterval = time.Millisecond
+
+	h := NewHealthServerWithRegistry(NullFlightRecorder, health.NewRegistry(health.Config{CacheTTL: time.Nanosecond}))
+	calls := 0
+	h.AddCheck("db", func(context.Context) error {
+		calls++
+		if calls > 3 {
+			return errors.New("connection refused")
+		}
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream := &watchStream{ctx: ctx, cancel: cancel}
+	err := h.Watch(&healthpb.HealthCheckRequest{}, stream)
+	assert.Equal(t, codes.Canceled, status.Code(err))
+	// only changes are sent
+	assert.Equal(t, []healthpb.HealthCheckResponse_ServingStatus{
+		healthpb.HealthCheckResponse_SERVING,
+		healthpb.HealthCheckResponse_NOT_SERVING,
+	}, stream.statuses)
+
+	ctx, cancel = context.WithCancel(context.Background())
+	stream = &watchStream{ctx: ctx, cancel: cancel}
+	cancel()
+	h.Watch(&healthpb.HealthCheckRequest{Service: "unknown"}, stream)
+	assert.Equal(t, []healthpb.HealthCheckResponse_ServingStatus{healthpb.HealthCheckResponse_SERVICE_UNKNOWN}, stream.statuses)
+}
+
+func TestExportHealth(t *testing.T) {
+	h := &exportHealth{}
+	assert.NoError(t, h.check(context.Background()))
+	h.record(errors.New("quota exceeded"))
+	assert.EqualError(t, h.check(context.Background()), "quota exceeded")
+	h.record(nil)
+	assert.NoError(t, h.check(context.Background()))
+}
@@ -0,0 +1,175 @@
+package obs
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"sync"
+
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+)
+
+// NewHTTPTransport returns an http.RoundTripper sending requests with next, or http.DefaultTransport if
+// it is nil, in a span named http_client whose context is injected into the headers of the request. The
+// stages of the connection are timed with httptrace, logged as events of the span and reported as
+// stats tagged with the host of the request:
+//
+//	http_client.dns_us      resolving the host
+//	http_client.connect_us  opening a connection, for each address tried
+//	http_client.tls_us      the TLS handshake
+//	http_client.ttfb_us     waiting for the first byte of the response, once the request is written
+//
+// http_client.connections counts the connections used, tagged with whether they were reused from the
+// pool, which they are not when the stages above happen for every request.
+func NewHTTPTransport(fr FlightRecorder, next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &httpTransport{fr: fr, next: next}
+}
+
+type httpTransport struct {
+	fr   FlightRecorder
+	next http.RoundTripper
+}
+
+func (t *httpTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	fs, ctx, done := t.fr.WithNewSpan(req.Context(), "http_client")
+	defer done()
+
+	host := req.URL.Hostname()
+	if host == "" {
+		host = "unknown"
+	}
+	span := fs.TraceSpan()
+	ext.SpanKind.Set(span, ext.SpanKindRPCClientEnum)
+	ext.HTTPMethod.Set(span, req.Method)
+	ext.HTTPUrl.Set(span, req.URL.Scheme+"://"+req.URL.Host+req.URL.Path)
+	ext.PeerHostname.Set(span, host)
+
+	trace := &httpClientTrace{fs: fs.WithMetricTags(Tags{"host": host}), connectDone: make(map[string]func())}
+	defer trace.finish()
+
+	// the request must not be modified, so it is copied along with its headers
+	req = req.WithContext(httptrace.WithClientTrace(ctx, trace.clientTrace()))
+	header := make(http.Header, len(req.Header))
+	for k, v := range req.Header {
+		header[k] = v
+	}
+	req.Header = header
+	if err := span.Tracer().Inject(span.Context(), opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(header)); err != nil {
+		fs.Warn("tracer_inject", "error injecting trace headers", Vals{}.WithError(err))
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		if ctx.Err() == nil {
+			fs.Trace(fmt.Sprintf("error in HTTP %s %s", req.Method, host), Vals{}.WithError(err))
+			markFailed(fs)
+		} else {
+			span.SetTag("canceled", true)
+		}
+		return nil, err
+	}
+	ext.HTTPStatusCode.Set(span, uint16(resp.StatusCode))
+	if resp.StatusCode >= http.StatusInternalServerError {
+		markFailed(fs)
+	}
+	return resp, nil
+}
+
+// httpClientTrace times the stages of a request. Its hooks may be called from other goroutines, such as
+// the ones dialing, and after the request returned, when they are ignored as the span is finished.
+type httpClientTrace struct {
+	fs FlightSpan
+
+	mutex       sync.Mutex // guards everything below
+	finished    bool
+	dnsDone     func()
+	connectDone map[string]func()
+	tlsDone     func()
+	ttfbDone    func()
+}
+
+func (t *httpClientTrace) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			t.start(&t.dnsDone, "http_client.dns")
+		},
+		DNSDone: func(info httptrace.DNSDoneInfo) {
+			t.done(&t.dnsDone, "DNS lookup failed", info.Err)
+		},
+		ConnectStart: func(network, addr string) {
+			t.mutex.Lock()
+			defer t.mutex.Unlock()
+			if !t.finished {
+				t.connectDone[network+" "+addr] = t.fs.StartTimer("http_client.connect")
+			}
+		},
+		ConnectDone: func(network, addr string, err error) {
+			t.mutex.Lock()
+			done := t.connectDone[network+" "+addr]
+			delete(t.connectDone, network+" "+addr)
+			t.mutex.Unlock()
+			t.done(&done, "connection to "+addr+" failed", err)
+		},
+		TLSHandshakeStart: func() {
+			t.start(&t.tlsDone, "http_client.tls")
+		},
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			t.done(&t.tlsDone, "TLS handshake failed", err)
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.mutex.Lock()
+			defer t.mutex.Unlock()
+			if !t.finished {
+				t.fs.WithMetricTags(Tags{"reused": strconv.FormatBool(info.Reused)}).Incr("http_client.connections")
+				t.fs.TraceSpan().LogKV("event", "got connection", "reused", info.Reused, "was_idle", info.WasIdle)
+			}
+		},
+		WroteRequest: func(info httptrace.WroteRequestInfo) {
+			if info.Err != nil {
+				t.done(nil, "writing the request failed", info.Err)
+				return
+			}
+			t.start(&t.ttfbDone, "http_client.ttfb")
+		},
+		GotFirstResponseByte: func() {
+			t.done(&t.ttfbDone, "", nil)
+		},
+	}
+}
+
+// start starts timing stage, unless the request returned.
+func (t *httpClientTrace) start(done *func(), stage string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if !t.finished {
+		*done = t.fs.StartTimer(stage)
+	}
+}
+
+// done ends the timing started in *done, if any, and logs err with message.
+func (t *httpClientTrace) done(done *func(), message string, err error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.finished {
+		return
+	}
+	if done != nil && *done != nil {
+		(*done)()
+		*done = nil
+	}
+	if err != nil {
+		t.fs.Trace(message, Vals{}.WithError(err))
+	}
+}
+
+func (t *httpClientTrace) finish() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.finished = true
+}
@@ -0,0 +1,74 @@
+package obs
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mixpanel/obs/metrics"
+	basictracer "github.com/opentracing/basictracer-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTTPTransport(t *testing.T) {
+	var traceHeaders []string
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceHeaders = append(traceHeaders, r.Header.Get("Ot-Tracer-Traceid"))
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+	next := server.Client().Transport.(*http.Transport)
+	next.TLSClientConfig.ServerName = "example.com"
+
+	sink := metrics.NewMockSink()
+	recorder := basictracer.NewInMemoryRecorder()
+	opts := basictracer.DefaultOptions()
+	opts.Recorder = recorder
+	opts.ShouldSample = func(uint64) bool { return true }
+	fr := NewFlightRecorder("test", metrics.NewReceiver(sink), &testLogger{}, basictracer.NewWithOptions(opts))
+	client := &http.Client{Transport: NewHTTPTransport(fr, next)}
+
+	url := strings.Replace(server.URL, "127.0.0.1", "localhost", 1)
+	for _, path := range []string{"/ok", "/fail"} {
+		req, err := http.NewRequest("GET", url+path+"?token=secret", nil)
+		assert.NoError(t, err)
+		resp, err := client.Do(req.WithContext(context.Background()))
+		if assert.NoError(t, err) {
+			ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+		}
+		assert.Empty(t, req.Header, "the request is not modified")
+	}
+
+	spans := recorder.GetSpans()
+	if assert.Len(t, spans, 2) {
+		assert.Equal(t, "test.http_client", spans[0].Operation)
+		assert.Equal(t, url+"/ok", spans[0].Tags["http.url"])
+		assert.Equal(t, "localhost", spans[0].Tags["peer.hostname"])
+		assert.Equal(t, uint16(200), spans[0].Tags["http.status_code"])
+		assert.Nil(t, spans[0].Tags["error"])
+		assert.Equal(t, true, spans[1].Tags["error"])
+		assert.NotEmpty(t, spans[0].Logs)
+	}
+	assert.Len(t, traceHeaders, 2)
+	assert.NotEmpty(t, traceHeaders[0])
+
+	counts := map[string]int{}
+	for key, n := range sink.Invocations {
+		name := strings.SplitN(key, ",", 2)[0]
+		if strings.Contains(key, "host:localhost") {
+			counts[name] += n
+		}
+	}
+	assert.Equal(t, 1, counts["http_client.dns_us"])
+	assert.Equal(t, 1, counts["http_client.tls_us"])
+	assert.Equal(t, 2, counts["http_client.ttfb_us"])
+	assert.True(t, counts["http_client.connect_us"] >= 1)
+	assert.Equal(t, 1, sink.Invocations["http_client.connections, map[host:localhost reused:false], 1, ct\n"])
+	assert.Equal(t, 1, sink.Invocations["http_client.connections, map[host:localhost reused:true], 1, ct\n"])
+}
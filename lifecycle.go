@@ -0,0 +1,262 @@
+package obs
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mixpanel/obs/logging"
+	"github.com/mixpanel/obs/obserr"
+)
+
+// DefaultStopTimeout bounds the time a component registered with a Lifecycle may take to stop,
+// unless it was registered with StopTimeout.
+const DefaultStopTimeout = 5 * time.Second
+
+// Lifecycle stops components, such as sinks, exporters, trackers or debug servers, in dependency order:
+// a component is stopped before the components it depends on, and components are otherwise stopped in
+// reverse registration order.
+type Lifecycle struct {
+	mutex      sync.Mutex
+	components []*component
+	byName     map[string]*component
+	stopped    bool
+}
+
+type component struct {
+	name      string
+	stop      func(ctx context.Context) error
+	dependsOn []string
+	timeout   time.Duration
+	// stopped is closed once stop returns, which may be after Shutdown moved on.
+	stopped chan struct{}
+}
+
+// ComponentOption configures a component registered with Lifecycle.Register.
+type ComponentOption func(*component)
+
+// DependsOn declares that the component uses the named components, which are only stopped after it.
+func DependsOn(names ...string) ComponentOption {
+	return func(c *component) {
+		c.dependsOn = append(c.dependsOn, names...)
+	}
+}
+
+// StopTimeout bounds the time the component may take to stop. Shutdown moves on to the next component
+// once it elapses, but still waits, up to their own timeouts, for the component to stop before
+// stopping its dependencies.
+func StopTimeout(d time.Duration) ComponentOption {
+	return func(c *component) {
+		c.timeout = d
+	}
+}
+
+// NewLifecycle returns an empty Lifecycle.
+func NewLifecycle() *Lifecycle {
+	return &Lifecycle{byName: make(map[string]*component)}
+}
+
+// Register adds a component stopped by stop. stop should return once ctx is done.
+func (lc *Lifecycle) Register(name string, stop func(ctx context.Context) error, opts ...ComponentOption) error {
+	c := &component{name: name, stop: stop, timeout: DefaultStopTimeout, stopped: make(chan struct{})}
+	for _, o := range opts {
+		o(c)
+	}
+
+	lc.mutex.Lock()
+	defer lc.mutex.Unlock()
+	if lc.stopped {
+		return fmt.Errorf("cannot register %s: lifecycle already shut down", name)
+	}
+	if _, ok := lc.byName[name]; ok {
+		return fmt.Errorf("component %s already registered", name)
+	}
+	lc.components = append(lc.components, c)
+	lc.byName[name] = c
+	return nil
+}
+
+// RegisterCloser adds a component stopped by closer.
+func (lc *Lifecycle) RegisterCloser(name string, closer Closer, opts ...ComponentOption) error {
+	return lc.Register(name, func(context.Context) error {
+		closer()
+		return nil
+	}, opts...)
+}
+
+// ShutdownReport describes how the components of a Lifecycle stopped.
+type ShutdownReport struct {
+	// Components are in the order they were stopped.
+	Components []ComponentReport
+	Duration   time.Duration
+	// OrderErr is set when dependencies could not be honored, because of a cycle or an unknown component.
+	OrderErr error
+}
+
+// ComponentReport describes how a component stopped.
+type ComponentReport struct {
+	Name     string
+	Duration time.Duration
+	Err      error
+	TimedOut bool
+}
+
+// Err combines the errors of the report, or returns nil if every component stopped cleanly.
+func (r ShutdownReport) Err() error {
+	errs := []error{r.OrderErr}
+	for _, c := range r.Components {
+		if c.Err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", c.Name, c.Err))
+		}
+	}
+	return obserr.Combine(errs...)
+}
+
+// Fields returns the report as log fields.
+func (r ShutdownReport) Fields() logging.Fields {
+	components := make([]map[string]interface{}, len(r.Components))
+	for i, c := range r.Components {
+		components[i] = map[string]interface{}{
+			"name":        c.Name,
+			"duration_ms": c.Duration.Seconds() * 1000,
+			"timed_out":   c.TimedOut,
+		}
+		if c.Err != nil {
+			components[i]["error"] = c.Err.Error()
+		}
+	}
+	fields := logging.Fields{
+		"components":  components,
+		"duration_ms": r.Duration.Seconds() * 1000,
+	}
+	if err := r.Err(); err != nil {
+		fields = fields.WithError(err)
+	}
+	return fields
+}
+
+// Shutdown stops every component, each within its timeout and ctx. It can only be called once; later
+// calls return an empty report.
+func (lc *Lifecycle) Shutdown(ctx context.Context) ShutdownReport {
+	lc.mutex.Lock()
+	if lc.stopped {
+		lc.mutex.Unlock()
+		return ShutdownReport{}
+	}
+	lc.stopped = true
+	order, orderErr := lc.stopOrder()
+	lc.mutex.Unlock()
+
+	start := time.Now()
+	report := ShutdownReport{OrderErr: orderErr}
+	for i, c := range order {
+		waitDependents(ctx, c, order[:i])
+		report.Components = append(report.Components, c.shutdown(ctx))
+	}
+	report.Duration = time.Since(start)
+	return report
+}
+
+// Closer returns a Closer that shuts lc down and logs the report with l.
+func (lc *Lifecycle) Closer(l logging.Logger) Closer {
+	return func() {
+		report := lc.Shutdown(context.Background())
+		if report.Err() != nil {
+			l.Warn("shutdown completed with errors", report.Fields())
+		} else {
+			l.Debug("shutdown completed", report.Fields())
+		}
+	}
+}
+
+func (c *component) shutdown(ctx context.Context) ComponentReport {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		defer close(c.stopped)
+		done <- c.stop(ctx)
+	}()
+
+	report := ComponentReport{Name: c.name}
+	select {
+	case report.Err = <-done:
+	case <-ctx.Done():
+		report.Err = ctx.Err()
+		report.TimedOut = true
+	}
+	report.Duration = time.Since(start)
+	return report
+}
+
+// waitDependents waits for the components depending on c, among the already stopped ones, to be done
+// stopping, so that one that timed out does not use c once it is stopped. It gives up once ctx is done
+// or the stop timeout of c elapses.
+func waitDependents(ctx context.Context, c *component, stopped []*component) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	for _, dependent := range stopped {
+		for _, name := range dependent.dependsOn {
+			if name != c.name {
+				continue
+			}
+			select {
+			case <-dependent.stopped:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// stopOrder returns the components ordered so that each comes before its dependencies. When that is not
+// possible, it falls back to reverse registration order and returns an error.
+func (lc *Lifecycle) stopOrder() ([]*component, error) {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int, len(lc.components))
+	startOrder := make([]*component, 0, len(lc.components))
+
+	var visit func(c *component) error
+	visit = func(c *component) error {
+		switch state[c.name] {
+		case visiting:
+			return fmt.Errorf("dependency cycle through %s", c.name)
+		case visited:
+			return nil
+		}
+		state[c.name] = visiting
+		for _, name := range c.dependsOn {
+			dep, ok := lc.byName[name]
+			if !ok {
+				return fmt.Errorf("%s depends on unknown component %s", c.name, name)
+			}
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		state[c.name] = visited
+		startOrder = append(startOrder, c)
+		return nil
+	}
+
+	var err error
+	for _, c := range lc.components {
+		if err = visit(c); err != nil {
+			startOrder = lc.components
+			break
+		}
+	}
+
+	order := make([]*component, len(startOrder))
+	for i, c := range startOrder {
+		order[len(order)-1-i] = c
+	}
+	return order, err
+}
@@ -0,0 +1,84 @@
+package obs
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLifecycleOrder(t *testing.T) {
+	lc := NewLifecycle()
+	var stopped []string
+	stop := func(name string) Closer {
+		return func() { stopped = append(stopped, name) }
+	}
+	assert.NoError(t, lc.RegisterCloser("tracker", stop("tracker"), DependsOn("sink")))
+	assert.NoError(t, lc.RegisterCloser("sink", stop("sink")))
+	assert.NoError(t, lc.RegisterCloser("debug_server", stop("debug_server")))
+	assert.Error(t, lc.RegisterCloser("sink", stop("sink")))
+
+	report := lc.Shutdown(context.Background())
+	assert.NoError(t, report.Err())
+	assert.Equal(t, []string{"debug_server", "tracker", "sink"}, stopped)
+	assert.Len(t, report.Components, 3)
+
+	assert.Empty(t, lc.Shutdown(context.Background()).Components)
+	assert.Error(t, lc.RegisterCloser("late", stop("late")))
+}
+
+func TestLifecycleErrors(t *testing.T) {
+	lc := NewLifecycle()
+	release := make(chan struct{})
+	defer close(release)
+	lc.Register("slow", func(ctx context.Context) error {
+		<-release
+		return nil
+	}, StopTimeout(time.Millisecond))
+	lc.Register("failing", func(ctx context.Context) error {
+		return errors.New("flush failed")
+	}, DependsOn("unknown"))
+
+	report := lc.Shutdown(context.Background())
+	assert.Error(t, report.OrderErr)
+	if assert.Len(t, report.Components, 2) {
+		assert.Equal(t, "failing", report.Components[0].Name)
+		assert.EqualError(t, report.Components[0].Err, "flush failed")
+		assert.True(t, report.Components[1].TimedOut)
+	}
+	assert.Error(t, report.Err())
+	assert.Contains(t, report.Fields(), "error_message")
+}
+
+func TestLifecycleWaitsForTimedOutDependents(t *testing.T) {
+	lc := NewLifecycle()
+	var mu sync.Mutex
+	var stopped []string
+	release := make(chan struct{})
+	lc.Register("aggregation", func(ctx context.Context) error {
+		<-release
+		mu.Lock()
+		defer mu.Unlock()
+		stopped = append(stopped, "aggregation")
+		return nil
+	}, StopTimeout(time.Millisecond), DependsOn("sink"))
+	lc.Register("sink", func(ctx context.Context) error {
+		mu.Lock()
+		defer mu.Unlock()
+		stopped = append(stopped, "sink")
+		return nil
+	})
+
+	time.AfterFunc(10*time.Millisecond, func() { close(release) })
+	report := lc.Shutdown(context.Background())
+	if assert.Len(t, report.Components, 2) {
+		assert.True(t, report.Components[0].TimedOut)
+		assert.NoError(t, report.Components[1].Err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []string{"aggregation", "sink"}, stopped)
+}
@@ -0,0 +1,56 @@
+package obs
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	basictracer "github.com/opentracing/basictracer-go"
+	opentracing "github.com/opentracing/opentracing-go"
+)
+
+// maxSpanLinks bounds the links of a span, beyond which they are counted in the tag links_dropped.
+const maxSpanLinks = 128
+
+func (fr *flightRecorder) WithLinks(ctx context.Context, opName string, links ...opentracing.SpanContext) (FlightSpan, context.Context, DoneFunc) {
+	var refs []opentracing.StartSpanOption
+	if parentSpan := opentracing.SpanFromContext(ctx); parentSpan != nil {
+		refs = append(refs, opentracing.ChildOf(parentSpan.Context()))
+	}
+
+	// basictracer makes the first reference of a span its parent, so its links are only tagged
+	var tagged []string
+	seen := make(map[string]bool, len(links))
+	linked, dropped := 0, 0
+	for _, link := range links {
+		if link == nil {
+			continue
+		}
+		if linked >= maxSpanLinks {
+			dropped++
+			continue
+		}
+		sc, ok := link.(basictracer.SpanContext)
+		if !ok {
+			refs = append(refs, opentracing.FollowsFrom(link))
+			linked++
+			continue
+		}
+		id := fmt.Sprintf("%032x/%016x", sc.TraceID, sc.SpanID)
+		if !seen[id] {
+			seen[id] = true
+			tagged = append(tagged, id)
+			linked++
+		}
+	}
+
+	fs, ctx, done := fr.startSpan(ctx, opName, refs...)
+	span := fs.TraceSpan()
+	if len(tagged) > 0 {
+		span.SetTag("links", strings.Join(tagged, ","))
+	}
+	if dropped > 0 {
+		span.SetTag("links_dropped", dropped)
+	}
+	return fs, ctx, done
+}
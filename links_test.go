@@ -0,0 +1,51 @@
+package obs
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	basictracer "github.com/opentracing/basictracer-go"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithLinks(t *testing.T) {
+	fr, _, recorder := newTestFlightRecorder()
+	ctx := context.Background()
+	var links []opentracing.SpanContext
+	for i := 0; i < 3; i++ {
+		item, itemCtx, done := fr.WithNewSpan(ctx, "enqueue")
+		carrier := map[string]string{}
+		assert.True(t, InjectSpanContext(itemCtx, carrier))
+		links = append(links, ExtractSpanContext(fr, carrier))
+		if i == 0 {
+			links = append(links, item.TraceSpan().Context(), nil)
+		}
+		done()
+	}
+
+	_, _, done := fr.WithLinks(ctx, "batch", links...)
+	done()
+	spans := recorder.GetSpans()
+	if assert.Len(t, spans, 4) {
+		batch := spans[3]
+		assert.Equal(t, uint64(0), batch.ParentSpanID, "the batch is not a child of an item")
+		var ids []string
+		for _, item := range spans[:3] {
+			assert.NotEqual(t, item.Context.TraceID, batch.Context.TraceID)
+			ids = append(ids, fmt.Sprintf("%032x/%016x", item.Context.TraceID, item.Context.SpanID))
+		}
+		assert.Equal(t, strings.Join(ids, ","), batch.Tags["links"], "links are deduplicated")
+	}
+
+	many := make([]opentracing.SpanContext, maxSpanLinks+2)
+	for i := range many {
+		many[i] = basictracer.SpanContext{TraceID: uint64(i + 1), SpanID: 1}
+	}
+	_, _, done = fr.WithLinks(ctx, "big_batch", many...)
+	done()
+	spans = recorder.GetSpans()
+	assert.Equal(t, 2, spans[4].Tags["links_dropped"])
+}
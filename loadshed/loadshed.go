@@ -0,0 +1,230 @@
+// Package loadshed rejects requests while a server is overloaded, rather than letting every request
+// slow down until they all time out. A Shedder tracks the requests in flight and a moving average of
+// their latency, fed by its gRPC interceptors and HTTP middleware, and sheds requests while either
+// is above its threshold: gRPC requests fail with RESOURCE_EXHAUSTED and HTTP requests with 429 Too
+// Many Requests, so that clients back off or try another replica. It reports:
+//
+//	loadshed.shed        requests shed, tagged with the reason: in_flight or latency
+//	loadshed.in_flight   the number of requests in flight
+//	loadshed.latency_us  the moving average of the latency of the requests
+package loadshed
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mixpanel/obs/clock"
+	"github.com/mixpanel/obs/metrics"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Reasons for shedding requests, as tagged on loadshed.shed.
+const (
+	ReasonInFlight = "in_flight"
+	ReasonLatency  = "latency"
+)
+
+// Config configures a Shedder. At least one of MaxInFlight and MaxLatency should be set.
+type Config struct {
+	// MaxInFlight sheds requests while that many are in flight. Zero disables the limit.
+	MaxInFlight int64
+	// MaxLatency sheds requests while the moving average of the latency is above it. Zero disables the
+	// limit.
+	MaxLatency time.Duration
+	// Weight is the weight of the latest request in the moving average of the latency, between 0 and 1.
+	// Defaults to 0.1.
+	Weight float64
+	// LatencyHalfLife is how long the moving average takes to halve while no request completes, so that
+	// shedding on latency, which lets no request complete, stops on its own. Defaults to 10 seconds.
+	LatencyHalfLife time.Duration
+	// Receiver gets the metrics. Defaults to metrics.Null.
+	Receiver metrics.Receiver
+	// Clock defaults to clock.Real.
+	Clock clock.Clock
+}
+
+// Shedder decides whether to shed requests. It is safe for concurrent use.
+type Shedder struct {
+	cfg      Config
+	inFlight int64
+	// timedInFlight are the requests in flight whose duration feeds the latency, unlike streams.
+	timedInFlight int64
+	unregister    func()
+
+	mutex   sync.Mutex // guards latency and updated
+	latency float64    // the moving average, in nanoseconds
+	updated time.Time  // when latency was last updated
+}
+
+// New returns a Shedder, which reports its gauges until Close is called.
+func New(cfg Config) *Shedder {
+	if cfg.Weight <= 0 || cfg.Weight > 1 {
+		cfg.Weight = 0.1
+	}
+	if cfg.LatencyHalfLife <= 0 {
+		cfg.LatencyHalfLife = 10 * time.Second
+	}
+	if cfg.Receiver == nil {
+		cfg.Receiver = metrics.Null
+	}
+	if cfg.Clock == nil {
+		cfg.Clock = clock.Real
+	}
+	s := &Shedder{cfg: cfg}
+	r := cfg.Receiver.ScopePrefix("loadshed")
+	unregisterInFlight := r.RegisterGauge("in_flight", func() float64 { return float64(s.InFlight()) })
+	unregisterLatency := r.RegisterGauge("latency_us", func() float64 { return float64(s.Latency() / time.Microsecond) })
+	s.unregister = func() {
+		unregisterInFlight()
+		unregisterLatency()
+	}
+	return s
+}
+
+// Close stops reporting the gauges of s.
+func (s *Shedder) Close() {
+	s.unregister()
+}
+
+// InFlight returns the number of requests started and not done.
+func (s *Shedder) InFlight() int64 {
+	return atomic.LoadInt64(&s.inFlight)
+}
+
+// Latency returns the moving average of the latency of the requests, decayed since the last one
+// completed.
+func (s *Shedder) Latency() time.Duration {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return time.Duration(s.decayed(s.cfg.Clock.Now()))
+}
+
+// decayed returns the moving average decayed since it was last updated. s.mutex must be held.
+func (s *Shedder) decayed(now time.Time) float64 {
+	elapsed := now.Sub(s.updated)
+	if s.updated.IsZero() || elapsed <= 0 {
+		return s.latency
+	}
+	return s.latency * math.Exp2(-float64(elapsed)/float64(s.cfg.LatencyHalfLife))
+}
+
+// Start records the start of a request that was not shed, and returns done, to be called when it
+// ends. The interceptors and the middleware of s call it, and other servers can too.
+func (s *Shedder) Start() (done func()) {
+	return s.start(true)
+}
+
+// start is Start, leaving the duration of the request out of the latency unless timed is set.
+func (s *Shedder) start(timed bool) (done func()) {
+	atomic.AddInt64(&s.inFlight, 1)
+	return s.started(timed)
+}
+
+// started is start for a request already counted in flight.
+func (s *Shedder) started(timed bool) (done func()) {
+	if timed {
+		atomic.AddInt64(&s.timedInFlight, 1)
+	}
+	start := s.cfg.Clock.Now()
+	return func() {
+		if timed {
+			s.observe(s.cfg.Clock.Since(start))
+			atomic.AddInt64(&s.timedInFlight, -1)
+		}
+		atomic.AddInt64(&s.inFlight, -1)
+	}
+}
+
+func (s *Shedder) observe(d time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := s.cfg.Clock.Now()
+	if s.updated.IsZero() {
+		s.latency = float64(d)
+	} else {
+		s.latency = s.decayed(now)
+		s.latency += s.cfg.Weight * (float64(d) - s.latency)
+	}
+	s.updated = now
+}
+
+// ShouldShed reports whether a request should be shed now.
+func (s *Shedder) ShouldShed() bool {
+	_, shed := s.shouldShed(s.InFlight())
+	return shed
+}
+
+// shouldShed returns whether a request should be shed while inFlight others are, and why. A request is
+// always let through when none is in flight, or on latency when no timed request is, so that the
+// latency can recover once the server is no longer overloaded, even while streams stay open.
+func (s *Shedder) shouldShed(inFlight int64) (reason string, shed bool) {
+	if inFlight == 0 {
+		return "", false
+	}
+	if s.cfg.MaxInFlight > 0 && inFlight >= s.cfg.MaxInFlight {
+		return ReasonInFlight, true
+	}
+	if s.cfg.MaxLatency > 0 && atomic.LoadInt64(&s.timedInFlight) > 0 && s.Latency() > s.cfg.MaxLatency {
+		return ReasonLatency, true
+	}
+	return "", false
+}
+
+// admit returns done if the request is let through, or counts it as shed and returns false. The
+// request is counted in flight before the limit is checked, so that concurrent requests cannot all be
+// let through past MaxInFlight.
+func (s *Shedder) admit(timed bool) (done func(), ok bool) {
+	others := atomic.AddInt64(&s.inFlight, 1) - 1
+	if reason, shed := s.shouldShed(others); shed {
+		atomic.AddInt64(&s.inFlight, -1)
+		s.cfg.Receiver.ScopeTags(metrics.Tags{"reason": reason}).Incr("loadshed.shed")
+		return nil, false
+	}
+	return s.started(timed), true
+}
+
+// UnaryServerInterceptor sheds unary RPCs, failing them with codes.ResourceExhausted. Pass it to
+// obs.GRPCServerOptions, so that shed RPCs are traced.
+func (s *Shedder) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		done, ok := s.admit(true)
+		if !ok {
+			return nil, status.Error(codes.ResourceExhausted, "server overloaded, request shed")
+		}
+		defer done()
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor sheds streaming RPCs, failing them with codes.ResourceExhausted. Streams
+// count as in flight until they end, but their duration, which says little about the load, is left
+// out of the latency.
+func (s *Shedder) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		done, ok := s.admit(false)
+		if !ok {
+			return status.Error(codes.ResourceExhausted, "server overloaded, request shed")
+		}
+		defer done()
+		return handler(srv, ss)
+	}
+}
+
+// Handler sheds the requests of h, answering them with 429 Too Many Requests.
+func (s *Shedder) Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		done, ok := s.admit(true)
+		if !ok {
+			http.Error(w, "server overloaded, request shed", http.StatusTooManyRequests)
+			return
+		}
+		defer done()
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,130 @@
+package loadshed
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mixpanel/obs/clock"
+	"github.com/mixpanel/obs/metrics"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestShedInFlight(t *testing.T) {
+	sink := metrics.NewMockSink()
+	s := New(Config{MaxInFlight: 2, Receiver: metrics.NewReceiver(sink)})
+	defer s.Close()
+
+	first, second := s.Start(), s.Start()
+	assert.Equal(t, int64(2), s.InFlight())
+	assert.True(t, s.ShouldShed())
+
+	interceptor := s.UnaryServerInterceptor()
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) { return "ok", nil }
+	_, err := interceptor(context.Background(), nil, &grpc.UnaryServerInfo{}, handler)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Equal(t, 1, sink.Invocations["loadshed.shed, map[reason:in_flight], 1, ct\n"])
+
+	first()
+	assert.False(t, s.ShouldShed())
+	resp, err := interceptor(context.Background(), nil, &grpc.UnaryServerInfo{}, handler)
+	assert.NoError(t, err)
+	assert.Equal(t, "ok", resp)
+	second()
+	assert.Equal(t, int64(0), s.InFlight())
+}
+
+func TestShedInFlightConcurrent(t *testing.T) {
+	s := New(Config{MaxInFlight: 4})
+	defer s.Close()
+
+	var (
+		mu       sync.Mutex
+		admitted []func()
+		wg       sync.WaitGroup
+	)
+	for i := 0; i < 64; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if done, ok := s.admit(true); ok {
+				mu.Lock()
+				admitted = append(admitted, done)
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Len(t, admitted, 4)
+	assert.Equal(t, int64(4), s.InFlight())
+	for _, done := range admitted {
+		done()
+	}
+	assert.Equal(t, int64(0), s.InFlight())
+}
+
+func TestShedLatency(t *testing.T) {
+	c := clock.NewFake(time.Unix(0, 0))
+	sink := metrics.NewMockSink()
+	s := New(Config{MaxLatency: 100 * time.Millisecond, Weight: 0.5, Clock: c, Receiver: metrics.NewReceiver(sink)})
+	defer s.Close()
+	handler := s.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Advance(200 * time.Millisecond)
+	}))
+	serve := func() int {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusOK, serve())
+	assert.Equal(t, 200*time.Millisecond, s.Latency())
+	assert.False(t, s.ShouldShed(), "requests are let through when none is in flight")
+
+	done := s.Start()
+	assert.Equal(t, http.StatusTooManyRequests, serve())
+	assert.Equal(t, 1, sink.Invocations["loadshed.shed, map[reason:latency], 1, ct\n"])
+	done()
+	assert.Equal(t, 100*time.Millisecond, s.Latency(), "the latency decays as fast requests complete")
+
+	done = s.Start()
+	assert.False(t, s.ShouldShed())
+	done()
+}
+
+func TestShedLatencyRecovers(t *testing.T) {
+	c := clock.NewFake(time.Unix(0, 0))
+	s := New(Config{MaxLatency: 100 * time.Millisecond, Clock: c})
+	defer s.Close()
+	done := s.Start()
+	c.Advance(time.Second)
+	done()
+
+	// an open stream does not count as a timed request in flight
+	stream := s.StreamServerInterceptor()
+	opened, release := make(chan struct{}), make(chan struct{})
+	go stream(nil, nil, &grpc.StreamServerInfo{}, func(interface{}, grpc.ServerStream) error {
+		close(opened)
+		<-release
+		return nil
+	})
+	<-opened
+	defer close(release)
+	assert.False(t, s.ShouldShed())
+
+	// with a slow request in flight, requests are shed until the average decays
+	slow := s.Start()
+	defer slow()
+	assert.True(t, s.ShouldShed())
+	c.Advance(30 * time.Second)
+	assert.True(t, s.ShouldShed())
+	assert.Equal(t, 125*time.Millisecond, s.Latency())
+	c.Advance(10 * time.Second)
+	assert.False(t, s.ShouldShed())
+}
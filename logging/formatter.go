@@ -5,7 +5,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
 	"sort"
+	"strings"
 	"time"
 )
 
@@ -14,19 +16,24 @@ type format int
 const (
 	formatJSON = format(iota)
 	formatText
+	formatConsole
 )
 
 var myPid = os.Getpid()
 
 const timeFormatStr = "2006-01-02 15:04:05.000"
 
-func jsonFormatter(lvl level, name, message string, fields Fields) string {
+// jsonFormatter formats entries as JSON, following schema if it is not nil.
+func jsonFormatter(lvl level, name, message string, fields Fields, schema *JSONSchema) string {
 	fields = MergeFields(fields, localhostFields)
 	delete(fields, "hostname") // added automatically
-	fields["logger"] = name
-	fields["level"] = levelToString(lvl) // TODO: remove once we're entirely on GCP
-	fields["severity"] = levelToString(lvl)
-	fields["message"] = message
+	fields[FieldLogger] = name
+	fields[FieldLevel] = levelToString(lvl) // TODO: remove once we're entirely on GCP
+	fields[FieldSeverity] = levelToString(lvl)
+	fields[FieldMessage] = message
+	if schema != nil {
+		schema.apply(fields, time.Now())
+	}
 
 	formatted, err := json.Marshal(fields)
 	if err != nil {
@@ -48,6 +55,125 @@ func textFormatter(lvl level, name, message string, fields Fields) string {
 	return buffer.String()
 }
 
+const (
+	consoleTimeFormat    = "15:04:05.000"
+	consoleMessageWidth  = 40
+	consoleColorReset    = "\x1b[0m"
+	consoleColorDim      = "\x1b[2m"
+	consoleColorKey      = "\x1b[36m"
+	consoleColorDebug    = "\x1b[90m"
+	consoleColorInfo     = "\x1b[32m"
+	consoleColorWarn     = "\x1b[33m"
+	consoleColorError    = "\x1b[31m"
+	consoleColorCritical = "\x1b[1;31m"
+)
+
+// consoleHiddenFields are added by FlightRecorders for log aggregation and only clutter the console.
+var consoleHiddenFields = map[string]bool{
+	"context":        true,
+	"eventTime":      true,
+	"serviceContext": true,
+}
+
+// consoleFormatter formats entries for reading during development: the time, the level, the logger
+// name and the message aligned in columns, followed by the fields sorted by key and the short caller.
+// Levels and field keys are colorized if color is true.
+func consoleFormatter(lvl level, name, message string, fields Fields, color bool) string {
+	buffer := bytes.NewBuffer(make([]byte, 0, len(message)*2+64))
+	paint := func(code, s string) {
+		if color {
+			buffer.WriteString(code)
+			buffer.WriteString(s)
+			buffer.WriteString(consoleColorReset)
+		} else {
+			buffer.WriteString(s)
+		}
+	}
+
+	paint(consoleColorDim, time.Now().Format(consoleTimeFormat))
+	buffer.WriteByte(' ')
+	levelName := levelToString(lvl)
+	if lvl == levelCritical {
+		levelName = "CRIT"
+	}
+	paint(consoleLevelColor(lvl), fmt.Sprintf("%-5s", levelName))
+	buffer.WriteByte(' ')
+	if name != "" {
+		paint(consoleColorDim, name)
+		buffer.WriteByte(' ')
+	}
+	buffer.WriteString(message)
+
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		if !consoleHiddenFields[k] {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	caller := shortCaller(fields["context"])
+	if len(keys) == 0 && caller == "" {
+		return buffer.String()
+	}
+
+	if pad := consoleMessageWidth - len(message); pad > 0 {
+		buffer.WriteString(strings.Repeat(" ", pad))
+	}
+	for _, k := range keys {
+		buffer.WriteString("  ")
+		paint(consoleColorKey, k+"=")
+		fmt.Fprintf(buffer, "%v", fields[k])
+	}
+	if caller != "" {
+		buffer.WriteString("  ")
+		paint(consoleColorDim, caller)
+	}
+	return buffer.String()
+}
+
+func consoleLevelColor(lvl level) string {
+	switch lvl {
+	case levelDebug:
+		return consoleColorDebug
+	case levelInfo:
+		return consoleColorInfo
+	case levelWarn:
+		return consoleColorWarn
+	case levelError:
+		return consoleColorError
+	default:
+		return consoleColorCritical
+	}
+}
+
+// shortCaller returns the file name and line of the caller context added by FlightRecorders, or an
+// empty string if there is none.
+func shortCaller(context interface{}) string {
+	c, ok := context.(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	location, ok := c["reportLocation"].(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	file, _ := location["filePath"].(string)
+	if file == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s:%v", filepath.Base(file), location["lineNumber"])
+}
+
+// consoleColor reports whether console output to w should be colorized: w must be a terminal and
+// the NO_COLOR environment variable must not be set.
+func consoleColor(w *os.File) bool {
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	info, err := w.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
 func formatFields(buffer *bytes.Buffer, message string, fields Fields) {
 	buffer.WriteString(message)
 
@@ -100,6 +226,8 @@ func formatToEnum(s string) format {
 		return formatJSON
 	case "text":
 		return formatText
+	case "console":
+		return formatConsole
 	default:
 		panic(fmt.Errorf("error unknown log format type: %s", s))
 	}
@@ -44,11 +44,36 @@ func TestTextFormattingLevel(t *testing.T) {
 func TestFormatToEnum(t *testing.T) {
 	assert.Equal(t, formatJSON, formatToEnum("json"))
 	assert.Equal(t, formatText, formatToEnum("text"))
+	assert.Equal(t, formatConsole, formatToEnum("console"))
 	assert.Panics(t, func() {
 		formatToEnum("blah")
 	})
 }
 
+func TestConsoleFormatting(t *testing.T) {
+	fields := Fields{
+		"user":      42,
+		"eventTime": "2019-01-01T00:00:00Z",
+		"context": map[string]interface{}{
+			"reportLocation": map[string]interface{}{
+				"filePath":     "/src/github.com/mixpanel/obs/server.go",
+				"lineNumber":   12,
+				"functionName": "obs.serve",
+			},
+		},
+	}
+
+	actual := consoleFormatter(levelWarn, "api", "request failed", fields, false)
+	assert.Regexp(t, `^\d{2}:\d{2}:\d{2}\.\d{3} WARN  api request failed {26}  user=42  server\.go:12$`, actual)
+
+	actual = consoleFormatter(levelCritical, "", "message", Fields{}, false)
+	assert.Regexp(t, `^\S+ CRIT  message$`, actual)
+
+	actual = consoleFormatter(levelError, "", "message", Fields{"key": "value"}, true)
+	assert.Contains(t, actual, consoleColorError+"ERROR"+consoleColorReset)
+	assert.Contains(t, actual, consoleColorKey+"key="+consoleColorReset+"value")
+}
+
 func fmtMessage(message string, fields Fields) string {
 	buf := &bytes.Buffer{}
 	formatFields(buf, message, fields)
@@ -0,0 +1,73 @@
+package logging
+
+// Entry is a log entry, as seen by hooks before it is formatted and written.
+type Entry struct {
+	// Level is the level of the entry, such as "WARN" or "CRITICAL".
+	Level string
+	// Logger is the name of the logger, as given to Named.
+	Logger  string
+	Message string
+	// Fields are a copy of the fields of the entry. Hooks may change them, as well as the message and
+	// the name of the logger.
+	Fields Fields
+
+	level level
+}
+
+// Hook is invoked for each log entry written by a logger, before it is formatted, for instance to add
+// or redact fields, to drop noisy entries, or to send critical entries to an alerting system.
+type Hook interface {
+	// Fire is called with the entry, which it may change. The entry is dropped if it returns false,
+	// and the hooks after it are not invoked. Fire is called concurrently by the goroutines logging.
+	Fire(entry *Entry) bool
+}
+
+// HookFunc is a Hook calling itself.
+type HookFunc func(entry *Entry) bool
+
+func (f HookFunc) Fire(entry *Entry) bool {
+	return f(entry)
+}
+
+// WithHooks makes the logger invoke hooks, in order, for each entry at or above the lowest of its
+// levels, before writing it to any of its destinations. They are shared with the loggers derived
+// from it with Named.
+func WithHooks(hooks ...Hook) Option {
+	return func(o *options) {
+		o.hooks = append(o.hooks, hooks...)
+	}
+}
+
+// AtLevel returns a hook invoking hook only for entries at or above lvl, such as "critical", keeping
+// the others.
+func AtLevel(lvl string, hook Hook) Hook {
+	min := levelStringToLevel(lvl)
+	return HookFunc(func(entry *Entry) bool {
+		if entry.level < min {
+			return true
+		}
+		return hook.Fire(entry)
+	})
+}
+
+// fireHooks invokes the hooks of l for an entry, and returns the entry to write, or false if it was
+// dropped.
+func (l *logger) fireHooks(lvl level, message string, fields Fields) (*Entry, bool) {
+	entry := &Entry{
+		Level:   levelToString(lvl),
+		Logger:  l.name,
+		Message: message,
+		Fields:  make(Fields, len(fields)),
+		level:   lvl,
+	}
+	// copied so that hooks do not change the fields of the caller
+	for k, v := range fields {
+		entry.Fields[k] = v
+	}
+	for _, hook := range l.hooks {
+		if !hook.Fire(entry) {
+			return nil, false
+		}
+	}
+	return entry, true
+}
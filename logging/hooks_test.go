@@ -0,0 +1,40 @@
+package logging
+
+import (
+	"bytes"
+	"log"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoggerHooks(t *testing.T) {
+	var alerts []string
+	buf := &bytes.Buffer{}
+	logger := newLogger(levelNever, "", nil, levelDebug, formatText, WithHooks(
+		HookFunc(func(entry *Entry) bool {
+			entry.Fields["env"] = "test"
+			delete(entry.Fields, "password")
+			return entry.Message != "noisy"
+		}),
+		AtLevel("critical", HookFunc(func(entry *Entry) bool {
+			alerts = append(alerts, entry.Logger+": "+entry.Message+" "+entry.Fields["env"].(string))
+			return true
+		})),
+	))
+	log.SetOutput(buf)
+	defer resetLogOutput()
+	named := logger.Named("svc")
+
+	fields := Fields{"password": "secret"}
+	named.Info("login", fields)
+	named.Info("noisy", nil)
+	named.Critical("down", nil)
+
+	assert.Contains(t, buf.String(), "env=test")
+	assert.NotContains(t, buf.String(), "secret")
+	assert.Equal(t, Fields{"password": "secret"}, fields, "the fields of the caller are left alone")
+	assert.NotContains(t, buf.String(), "noisy")
+	assert.Contains(t, buf.String(), "svc: down")
+	assert.Equal(t, []string{"svc: down test"}, alerts)
+}
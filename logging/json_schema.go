@@ -0,0 +1,82 @@
+package logging
+
+import (
+	"time"
+)
+
+// The standard fields of JSON logs, as named by default.
+const (
+	// FieldTime is the time of the entry. FlightRecorders add it as RFC3339 with nanoseconds; with a
+	// JSONSchema, the formatter adds it to every entry, in the format of the schema.
+	FieldTime = "eventTime"
+	// FieldLevel is the level of the entry. It is duplicated in FieldSeverity, for GCP.
+	FieldLevel    = "level"
+	FieldSeverity = "severity"
+	FieldMessage  = "message"
+	// FieldLogger is the name of the logger.
+	FieldLogger = "logger"
+	// FieldCaller is the file, line and function that logged the entry, added by FlightRecorders.
+	FieldCaller = "context"
+)
+
+// TimeFormat is the format of FieldTime.
+type TimeFormat int
+
+const (
+	// TimeRFC3339Nano formats times as strings, such as "2006-01-02T15:04:05.999999999Z07:00".
+	TimeRFC3339Nano TimeFormat = iota
+	// TimeEpochMillis formats times as the number of milliseconds since the Unix epoch.
+	TimeEpochMillis
+)
+
+// JSONSchema adapts JSON logs to what they are indexed with, for instance an Elastic index template.
+type JSONSchema struct {
+	// Version, if set, is added to every entry as VersionField, so that index templates can tell
+	// schemas apart as they change.
+	Version string
+	// VersionField defaults to "schema_version".
+	VersionField string
+	// Names renames the standard fields, from their default name to theirs, such as
+	// {FieldTime: "ts", FieldMessage: "msg", FieldCaller: "caller"}. Fields renamed to an empty name
+	// are left out, such as the duplicate FieldLevel.
+	Names map[string]string
+	// TimeFormat is the format of FieldTime.
+	TimeFormat TimeFormat
+}
+
+// WithJSONSchema makes the logger write the logs of the JSON format, including those sent to syslog and
+// to targets, with schema.
+func WithJSONSchema(schema JSONSchema) Option {
+	return func(o *options) {
+		if schema.VersionField == "" {
+			schema.VersionField = "schema_version"
+		}
+		o.jsonSchema = &schema
+	}
+}
+
+// apply changes the fields of an entry, already holding the standard fields, to follow s.
+func (s *JSONSchema) apply(fields Fields, now time.Time) {
+	switch s.TimeFormat {
+	case TimeEpochMillis:
+		fields[FieldTime] = now.UnixNano() / int64(time.Millisecond)
+	default:
+		fields[FieldTime] = now.Format(time.RFC3339Nano)
+	}
+	// the fields are all taken out before any is put back, so that fields can be swapped
+	renamed := make(Fields, len(s.Names))
+	for from, to := range s.Names {
+		if v, ok := fields[from]; ok {
+			delete(fields, from)
+			if to != "" {
+				renamed[to] = v
+			}
+		}
+	}
+	for k, v := range renamed {
+		fields[k] = v
+	}
+	if s.Version != "" {
+		fields[s.VersionField] = s.Version
+	}
+}
@@ -0,0 +1,54 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJSONSchema(t *testing.T) {
+	var o options
+	WithJSONSchema(JSONSchema{
+		Version:    "3",
+		Names:      map[string]string{FieldTime: "ts", FieldMessage: "msg", FieldCaller: "caller", FieldLevel: ""},
+		TimeFormat: TimeEpochMillis,
+	})(&o)
+	assert.Equal(t, "schema_version", o.jsonSchema.VersionField)
+
+	fields := Fields{"eventTime": "2019-01-01T00:00:00Z", "context": "server.go:12", "user": 42}
+	now := time.Unix(1546300800, 123456789)
+	o.jsonSchema.apply(fields, now)
+	assert.Equal(t, Fields{
+		"ts":             int64(1546300800123),
+		"caller":         "server.go:12",
+		"user":           42,
+		"schema_version": "3",
+	}, fields)
+
+	swapped := Fields{FieldMessage: "hello", FieldLogger: "api"}
+	(&JSONSchema{Names: map[string]string{FieldMessage: FieldLogger, FieldLogger: FieldMessage}}).apply(swapped, now)
+	assert.Equal(t, "api", swapped[FieldMessage])
+	assert.Equal(t, "hello", swapped[FieldLogger])
+	assert.Equal(t, now.Format(time.RFC3339Nano), swapped[FieldTime])
+}
+
+func TestLoggerJSONSchema(t *testing.T) {
+	target := &bytes.Buffer{}
+	logger := New("never", "never", "", "json",
+		WithTargets(Target{Level: "info", Format: "json", Writer: target}),
+		WithJSONSchema(JSONSchema{Version: "1", Names: map[string]string{FieldMessage: "msg"}}),
+	).Named("svc")
+	defer resetLogOutput()
+	logger.Info("started", nil)
+
+	var res map[string]interface{}
+	assert.NoError(t, json.Unmarshal(target.Bytes(), &res))
+	assert.Equal(t, "started", res["msg"])
+	assert.NotContains(t, res, FieldMessage)
+	assert.Equal(t, "1", res["schema_version"])
+	assert.Equal(t, "svc", res[FieldLogger])
+	assert.NotEmpty(t, res[FieldTime])
+}
@@ -17,21 +17,29 @@ var (
 )
 
 func levelStringToLevel(str string) level {
+	lvl, err := parseLevel(str)
+	if err != nil {
+		initError(fmt.Sprintf("Invalid log level %v.", str))
+		return levelWarn
+	}
+	return lvl
+}
+
+func parseLevel(str string) (level, error) {
 	switch strings.ToUpper(str) {
 	case "NEVER":
-		return levelNever
+		return levelNever, nil
 	case "DEBUG":
-		return levelDebug
+		return levelDebug, nil
 	case "INFO":
-		return levelInfo
+		return levelInfo, nil
 	case "WARN":
-		return levelWarn
+		return levelWarn, nil
 	case "ERROR":
-		return levelError
+		return levelError, nil
 	case "CRITICAL":
-		return levelCritical
+		return levelCritical, nil
 	default:
-		initError(fmt.Sprintf("Invalid log level %v.", str))
-		return levelWarn
+		return 0, fmt.Errorf("invalid log level %q", str)
 	}
 }
@@ -5,8 +5,8 @@ import (
 	"io"
 	"io/ioutil"
 	golog "log"
-	"log/syslog"
 	"os"
+	"sync/atomic"
 )
 
 // Logger is the interface to logging
@@ -29,44 +29,104 @@ type Logger interface {
 	Named(name string) Logger
 }
 
+// LevelSetter is implemented by loggers whose level can be changed while they run, such as the ones
+// returned by New.
+type LevelSetter interface {
+	// SetLevel changes the level of the logs written to the file or stderr, by the logger and the
+	// loggers derived from it with Named.
+	SetLevel(level string) error
+}
+
 type logger struct {
-	name          string
-	syslog        io.Writer
-	syslogLevel   level
-	gologgerLevel level
-	format        format
+	name   string
+	syslog syslogWriter
+	levels *levels
+	format format
+	color  bool
+	// targets are the additional destinations configured with WithTargets.
+	targets []*target
+	// hooks are the hooks configured with WithHooks.
+	hooks []Hook
+	// jsonSchema, if set, is the schema of JSON logs.
+	jsonSchema *JSONSchema
+
+	// fileEnabled tells whether logs go to a file or stderr, which can only be decided when the
+	// logger is created.
+	fileEnabled bool
+}
+
+// levels are the syslog and file levels of a logger, shared with the loggers derived from it with
+// Named so that SetLevel changes them all.
+type levels struct {
+	syslog int32
+	file   int32
+	// targets is the lowest level of the targets, which does not change.
+	targets level
+}
+
+func newLevels(syslogLevel, fileLevel level) *levels {
+	return &levels{syslog: int32(syslogLevel), file: int32(fileLevel), targets: levelNever}
+}
 
-	minLevel level
+func (ls *levels) load() (syslogLevel, fileLevel level) {
+	return level(atomic.LoadInt32(&ls.syslog)), level(atomic.LoadInt32(&ls.file))
 }
 
-func newLogger(syslogLevel level, filepath string, fileLevel level, format format) *logger {
-	minLevel := syslogLevel
-	if fileLevel < minLevel {
-		minLevel = fileLevel
+func (ls *levels) min() level {
+	syslogLevel, fileLevel := ls.load()
+	min := ls.targets
+	if fileLevel < min {
+		min = fileLevel
+	}
+	if syslogLevel < min {
+		min = syslogLevel
+	}
+	return min
+}
+
+func newLogger(syslogLevel level, filepath string, rotate *RotateOptions, fileLevel level, format format, opts ...Option) *logger {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
 	}
 
 	log := &logger{
-		name:          "",
-		minLevel:      minLevel,
-		syslogLevel:   syslogLevel,
-		gologgerLevel: fileLevel,
-		format:        format,
+		name:        "",
+		format:      format,
+		fileEnabled: fileLevel != levelNever,
+		hooks:       o.hooks,
 	}
 
 	if syslogLevel != levelNever {
-		syslogger, err := syslog.New(syslog.LOG_USER|syslog.LOG_NOTICE, "")
+		syslogger, err := newSyslogWriter(o.syslog)
 		if err != nil {
 			initError(fmt.Sprintf("Unable to open syslog: %v.", err))
-			log.syslogLevel = levelNever
+		}
+		if syslogger == nil {
+			syslogLevel = levelNever
 		} else {
 			log.syslog = syslogger
 		}
 	}
+	log.levels = newLevels(syslogLevel, fileLevel)
+	log.jsonSchema = o.jsonSchema
+	log.targets = openTargets(o.targets, o.jsonSchema)
+	for _, t := range log.targets {
+		if t.level < log.levels.targets {
+			log.levels.targets = t.level
+		}
+	}
 
 	if fileLevel == levelNever {
 		golog.SetOutput(ioutil.Discard)
 	} else if len(filepath) > 0 {
-		file, err := os.OpenFile(filepath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		var file io.Writer
+		var err error
+		if rotate != nil {
+			file, err = OpenRotatingFile(filepath, *rotate)
+		} else {
+			file, err = os.OpenFile(filepath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		}
 		if err != nil {
 			initError(fmt.Sprintf("Unable to open file for logging: %v.", err))
 			golog.SetOutput(os.Stderr)
@@ -75,9 +135,10 @@ func newLogger(syslogLevel level, filepath string, fileLevel level, format forma
 		}
 	} else {
 		golog.SetOutput(os.Stderr)
+		log.color = format == formatConsole && consoleColor(os.Stderr)
 	}
 
-	if format == formatJSON {
+	if format == formatJSON || format == formatConsole {
 		golog.SetFlags(0)
 	}
 
@@ -86,13 +147,28 @@ func newLogger(syslogLevel level, filepath string, fileLevel level, format forma
 
 func (l *logger) Named(name string) Logger {
 	return &logger{
-		name:          name,
-		syslog:        l.syslog,
-		syslogLevel:   l.syslogLevel,
-		gologgerLevel: l.gologgerLevel,
-		minLevel:      l.minLevel,
-		format:        l.format,
+		name:        name,
+		syslog:      l.syslog,
+		levels:      l.levels,
+		format:      l.format,
+		color:       l.color,
+		targets:     l.targets,
+		hooks:       l.hooks,
+		jsonSchema:  l.jsonSchema,
+		fileEnabled: l.fileEnabled,
+	}
+}
+
+func (l *logger) SetLevel(lvl string) error {
+	fileLevel, err := parseLevel(lvl)
+	if err != nil {
+		return err
 	}
+	if fileLevel != levelNever && !l.fileEnabled {
+		return fmt.Errorf("cannot log at %s, logging to a file was disabled when the logger was created", lvl)
+	}
+	atomic.StoreInt32(&l.levels.file, int32(fileLevel))
+	return nil
 }
 
 func (l *logger) Debug(message string, fields Fields) {
@@ -116,40 +192,57 @@ func (l *logger) Critical(message string, fields Fields) {
 }
 
 func (l *logger) IsDebug() bool {
-	return l.minLevel <= levelDebug
+	return l.levels.min() <= levelDebug
 }
 
 func (l *logger) IsInfo() bool {
-	return l.minLevel <= levelInfo
+	return l.levels.min() <= levelInfo
 }
 
 func (l *logger) IsWarn() bool {
-	return l.minLevel <= levelWarn
+	return l.levels.min() <= levelWarn
 }
 
 func (l *logger) IsError() bool {
-	return l.minLevel <= levelError
+	return l.levels.min() <= levelError
 }
 
 func (l *logger) IsCritical() bool {
-	return l.minLevel <= levelCritical
+	return l.levels.min() <= levelCritical
 }
 
 func (l *logger) logAtLevel(lvl level, message string, fields Fields) {
-	if l.minLevel > lvl {
+	syslogLevel, fileLevel := l.levels.load()
+	if syslogLevel > lvl && fileLevel > lvl && l.levels.targets > lvl {
 		return
 	}
+	name := l.name
+	if len(l.hooks) > 0 {
+		entry, ok := l.fireHooks(lvl, message, fields)
+		if !ok {
+			return
+		}
+		name, message, fields = entry.Logger, entry.Message, entry.Fields
+	}
 
-	if l.gologgerLevel <= lvl {
+	if fileLevel <= lvl {
 		switch l.format {
 		case formatJSON:
-			golog.Println(jsonFormatter(lvl, l.name, message, fields))
+			golog.Println(jsonFormatter(lvl, name, message, fields, l.jsonSchema))
 		case formatText:
-			golog.Println(textFormatter(lvl, l.name, message, fields))
+			golog.Println(textFormatter(lvl, name, message, fields))
+		case formatConsole:
+			golog.Println(consoleFormatter(lvl, name, message, fields, l.color))
 		}
 	}
 
-	if l.syslogLevel <= lvl {
-		io.WriteString(l.syslog, "mixpanel "+jsonFormatter(lvl, l.name, message, fields))
+	if syslogLevel <= lvl {
+		l.syslog.write(lvl, "mixpanel "+jsonFormatter(lvl, name, message, fields, l.jsonSchema))
+	}
+
+	for _, t := range l.targets {
+		if t.level <= lvl {
+			t.write(lvl, name, message, fields)
+		}
 	}
 }
@@ -3,8 +3,11 @@ package logging
 import (
 	"bytes"
 	"encoding/json"
+	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -23,11 +26,31 @@ func TestLoggerNamed(t *testing.T) {
 	assert.Contains(t, buf.String(), "new name")
 }
 
+func TestLoggerSetLevel(t *testing.T) {
+	logger, buf := testLogger(formatText)
+	named := logger.Named("named")
+	defer resetLogOutput()
+
+	assert.NoError(t, logger.(LevelSetter).SetLevel("warn"))
+	assert.False(t, named.IsInfo())
+	named.Info("dropped", nil)
+	named.Warn("kept", nil)
+	assert.NotContains(t, buf.String(), "dropped")
+	assert.Contains(t, buf.String(), "kept")
+
+	assert.Error(t, logger.(LevelSetter).SetLevel("verbose"))
+	assert.True(t, named.IsWarn())
+
+	disabled := newLogger(levelNever, "", nil, levelNever, formatText)
+	assert.Error(t, disabled.SetLevel("DEBUG"))
+	assert.NoError(t, disabled.SetLevel("NEVER"))
+}
+
 func TestSyslog(t *testing.T) {
-	logger := newLogger(levelDebug, "", levelNever, formatText)
+	logger := newLogger(levelDebug, "", nil, levelNever, formatText)
 	buf := &bytes.Buffer{}
-	logger.syslog = buf
-	logger.syslogLevel = levelInfo
+	logger.syslog = &bufferSyslog{buf: buf}
+	logger.levels = newLevels(levelInfo, levelNever)
 
 	logger.Info("test", Fields{"key": "value"})
 	if assert.Equal(t, "mixpanel ", buf.String()[:9]) {
@@ -55,9 +78,43 @@ func TestLoggerJSON(t *testing.T) {
 
 }
 
+func TestLoggerTargets(t *testing.T) {
+	stdout := &bytes.Buffer{}
+	dir, err := ioutil.TempDir("", "targets")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "warnings.log")
+	logger := New("never", "never", "", "json", WithTargets(
+		Target{Level: "info", Format: "json", Writer: stdout},
+		Target{Level: "warn", Format: "text", Path: path},
+	)).Named("svc")
+	defer resetLogOutput()
+
+	assert.False(t, logger.IsDebug())
+	assert.True(t, logger.IsInfo())
+	logger.Debug("dropped", nil)
+	logger.Info("started", Fields{"port": 80})
+	logger.Warn("slow", Fields{"ms": 1200})
+
+	var messages []string
+	for _, line := range strings.Split(strings.TrimSpace(stdout.String()), "\n") {
+		var res map[string]interface{}
+		assert.NoError(t, json.Unmarshal([]byte(line), &res))
+		if res["logger"] == "svc" {
+			messages = append(messages, res["message"].(string))
+		}
+	}
+	assert.Equal(t, []string{"started", "slow"}, messages)
+	file, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(file), "started")
+	assert.Contains(t, string(file), "[WARN] svc: slow")
+	assert.Contains(t, string(file), "ms=1200")
+}
+
 func testLogger(format format) (Logger, *bytes.Buffer) {
 	buf := &bytes.Buffer{}
-	logger := newLogger(levelNever, "", levelDebug, format)
+	logger := newLogger(levelNever, "", nil, levelDebug, format)
 	log.SetOutput(buf)
 	return logger, buf
 }
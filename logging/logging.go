@@ -4,8 +4,23 @@ var initErrors []string
 
 // New creates a new logger, pass in the log levels,
 // and file specifications to create one
-func New(syslogLevel, fileLevel, filePath, format string) Logger {
-	logger := buildLogger(syslogLevel, fileLevel, filePath, format)
+func New(syslogLevel, fileLevel, filePath, format string, opts ...Option) Logger {
+	return reportInitErrors(buildLogger(syslogLevel, fileLevel, filePath, format, opts...))
+}
+
+// NewWithRotation is like New, but rotates the log file at filePath according to rotate.
+func NewWithRotation(syslogLevel, fileLevel, filePath, format string, rotate RotateOptions, opts ...Option) Logger {
+	return reportInitErrors(newLogger(
+		levelStringToLevel(syslogLevel),
+		filePath,
+		&rotate,
+		levelStringToLevel(fileLevel),
+		formatToEnum(format),
+		opts...,
+	))
+}
+
+func reportInitErrors(logger Logger) Logger {
 	for _, message := range initErrors {
 		logger.Error(message, nil)
 	}
@@ -13,12 +28,14 @@ func New(syslogLevel, fileLevel, filePath, format string) Logger {
 	return logger
 }
 
-func buildLogger(syslogLevel, fileLevel, filePath, format string) Logger {
+func buildLogger(syslogLevel, fileLevel, filePath, format string, opts ...Option) Logger {
 	return newLogger(
 		levelStringToLevel(syslogLevel),
 		filePath,
+		nil,
 		levelStringToLevel(fileLevel),
 		formatToEnum(format),
+		opts...,
 	)
 }
 
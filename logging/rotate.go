@@ -0,0 +1,268 @@
+package logging
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const backupTimeFormat = "2006-01-02T15-04-05.000"
+
+// rotateRetryInterval is how long Write keeps writing to the current file after a failed rotation
+// before it tries to rotate again.
+const rotateRetryInterval = time.Minute
+
+// RotateOptions configures when a RotatingFile rotates and which rotated files it keeps.
+// Zero values disable the corresponding behavior.
+type RotateOptions struct {
+	// MaxSize rotates the file before a write would make it larger than MaxSize bytes.
+	MaxSize int64
+	// Interval rotates the file once it has been written to for longer than Interval.
+	Interval time.Duration
+	// MaxBackups is the number of rotated files to keep.
+	MaxBackups int
+	// MaxBackupAge deletes rotated files older than MaxBackupAge.
+	MaxBackupAge time.Duration
+	// Compress gzips rotated files.
+	Compress bool
+}
+
+// RotatingFile is an io.WriteCloser that writes to a file and rotates it according to RotateOptions.
+// Rotated files are renamed to the file path suffixed with the rotation time, e.g.
+// service.log.2019-10-01T12-00-00.000, with a .gz extension once compressed.
+type RotatingFile struct {
+	path string
+	opts RotateOptions
+	now  func() time.Time
+
+	mu       sync.Mutex
+	file     *os.File
+	size     int64
+	openedAt time.Time
+
+	// the last rotation error, reported once, and when Write retries the rotation
+	rotateErr error
+	retryAt   time.Time
+
+	// background compression and cleanup, one rotation at a time
+	wg sync.WaitGroup
+	bg sync.Mutex
+}
+
+// OpenRotatingFile opens or creates the file at path for appending.
+func OpenRotatingFile(path string, opts RotateOptions) (*RotatingFile, error) {
+	f := &RotatingFile{path: path, opts: opts, now: time.Now}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	f.openedAt = f.now()
+	return nil
+}
+
+// Write writes p to the file, rotating it first if needed. If the rotation fails, Write reports the
+// error to stderr once, writes p to the current file anyway and retries the rotation after
+// rotateRetryInterval.
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+	if f.shouldRotate(int64(len(p))) && !f.now().Before(f.retryAt) {
+		if err := f.rotate(); err != nil {
+			if f.rotateErr == nil {
+				fmt.Fprintf(os.Stderr, "error rotating log %s, writing to it unrotated: %v\n", f.path, err)
+			}
+			f.rotateErr = err
+			f.retryAt = f.now().Add(rotateRetryInterval)
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// Rotate rotates the file immediately.
+func (f *RotatingFile) Rotate() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return os.ErrClosed
+	}
+	return f.rotate()
+}
+
+// Close closes the file and waits for pending compression and cleanup.
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	var err error
+	if f.file != nil {
+		err = f.file.Close()
+		f.file = nil
+	}
+	f.mu.Unlock()
+	f.wg.Wait()
+	return err
+}
+
+func (f *RotatingFile) shouldRotate(n int64) bool {
+	if f.size == 0 {
+		return false
+	}
+	if f.opts.MaxSize > 0 && f.size+n > f.opts.MaxSize {
+		return true
+	}
+	return f.opts.Interval > 0 && f.now().Sub(f.openedAt) >= f.opts.Interval
+}
+
+// rotate renames the file while it is still open and only closes it once the new file is open, so
+// that f keeps writing to the old file if rotation fails. If the file was removed, rotate only opens
+// a new one.
+func (f *RotatingFile) rotate() error {
+	old := f.file
+	backup := f.backupName()
+	if err := os.Rename(f.path, backup); err != nil {
+		if !os.IsNotExist(err) {
+			return err
+		}
+		backup = ""
+	}
+	if err := f.open(); err != nil {
+		if backup != "" {
+			os.Rename(backup, f.path)
+		}
+		return err
+	}
+	if err := old.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "error closing rotated log %s: %v\n", f.path, err)
+	}
+	f.rotateErr = nil
+	f.retryAt = time.Time{}
+	if backup == "" {
+		return nil
+	}
+
+	f.wg.Add(1)
+	go func() {
+		defer f.wg.Done()
+		f.bg.Lock()
+		defer f.bg.Unlock()
+		if f.opts.Compress {
+			if err := compressFile(backup); err != nil {
+				fmt.Fprintf(os.Stderr, "error compressing rotated log %s: %v\n", backup, err)
+			}
+		}
+		f.removeOldBackups()
+	}()
+	return nil
+}
+
+func (f *RotatingFile) backupName() string {
+	name := f.path + "." + f.now().Format(backupTimeFormat)
+	candidate := name
+	for i := 1; ; i++ {
+		if _, err := os.Stat(candidate); os.IsNotExist(err) {
+			if _, err := os.Stat(candidate + ".gz"); os.IsNotExist(err) {
+				return candidate
+			}
+		}
+		candidate = fmt.Sprintf("%s-%d", name, i)
+	}
+}
+
+func compressFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(path+".gz", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(dst)
+	if _, err := io.Copy(gz, src); err != nil {
+		dst.Close()
+		os.Remove(path + ".gz")
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		dst.Close()
+		os.Remove(path + ".gz")
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// backups returns the rotated files of f, oldest first.
+func (f *RotatingFile) backups() ([]string, error) {
+	matches, err := filepath.Glob(f.path + ".*")
+	if err != nil {
+		return nil, err
+	}
+	var backups []string
+	for _, m := range matches {
+		suffix := strings.TrimSuffix(strings.TrimPrefix(m, f.path+"."), ".gz")
+		if len(suffix) < len(backupTimeFormat) {
+			continue
+		}
+		if _, err := time.Parse(backupTimeFormat, suffix[:len(backupTimeFormat)]); err == nil {
+			backups = append(backups, m)
+		}
+	}
+	sort.Strings(backups)
+	return backups, nil
+}
+
+func (f *RotatingFile) removeOldBackups() {
+	if f.opts.MaxBackups <= 0 && f.opts.MaxBackupAge <= 0 {
+		return
+	}
+	backups, err := f.backups()
+	if err != nil {
+		return
+	}
+
+	var remove []string
+	if f.opts.MaxBackups > 0 && len(backups) > f.opts.MaxBackups {
+		remove = backups[:len(backups)-f.opts.MaxBackups]
+		backups = backups[len(backups)-f.opts.MaxBackups:]
+	}
+	if f.opts.MaxBackupAge > 0 {
+		cutoff := f.now().Add(-f.opts.MaxBackupAge)
+		for _, b := range backups {
+			if info, err := os.Stat(b); err == nil && info.ModTime().Before(cutoff) {
+				remove = append(remove, b)
+			}
+		}
+	}
+	for _, b := range remove {
+		os.Remove(b)
+	}
+}
@@ -0,0 +1,130 @@
+package logging
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestRotatingFile(t *testing.T, opts RotateOptions) (*RotatingFile, string, func()) {
+	dir, err := ioutil.TempDir("", "rotate")
+	assert.NoError(t, err)
+	path := filepath.Join(dir, "service.log")
+	f, err := OpenRotatingFile(path, opts)
+	assert.NoError(t, err)
+	return f, path, func() {
+		f.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestRotateBySize(t *testing.T) {
+	f, path, cleanup := newTestRotatingFile(t, RotateOptions{MaxSize: 10, MaxBackups: 2})
+	defer cleanup()
+
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		_, err := f.Write([]byte(line))
+		assert.NoError(t, err)
+	}
+	f.wg.Wait()
+
+	current, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "fourth\n", string(current))
+
+	backups, err := f.backups()
+	assert.NoError(t, err)
+	if assert.Len(t, backups, 2) {
+		second, _ := ioutil.ReadFile(backups[0])
+		third, _ := ioutil.ReadFile(backups[1])
+		assert.Equal(t, "second\n", string(second))
+		assert.Equal(t, "third\n", string(third))
+	}
+}
+
+func TestRotateFailure(t *testing.T) {
+	f, path, cleanup := newTestRotatingFile(t, RotateOptions{})
+	defer cleanup()
+
+	os.RemoveAll(filepath.Dir(path))
+	assert.Error(t, f.Rotate())
+	_, err := f.Write([]byte("kept\n"))
+	assert.NoError(t, err, "the file is kept when it cannot be rotated")
+	assert.NoError(t, f.Close())
+}
+
+func TestRotateBySizeFailure(t *testing.T) {
+	f, path, cleanup := newTestRotatingFile(t, RotateOptions{MaxSize: 10})
+	defer cleanup()
+	now := time.Now()
+	f.now = func() time.Time { return now }
+
+	dir := filepath.Dir(path)
+	os.RemoveAll(dir)
+	for _, line := range []string{"first\n", "second\n", "third\n"} {
+		n, err := f.Write([]byte(line))
+		assert.NoError(t, err, "lines are written to the current file when it cannot be rotated")
+		assert.Equal(t, len(line), n)
+	}
+
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("fourth\n"))
+	_, err := os.Stat(path)
+	assert.True(t, os.IsNotExist(err), "rotation is not retried before the retry interval")
+
+	now = now.Add(rotateRetryInterval)
+	f.Write([]byte("fifth\n"))
+	current, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "fifth\n", string(current))
+}
+
+func TestRotateByInterval(t *testing.T) {
+	f, _, cleanup := newTestRotatingFile(t, RotateOptions{Interval: time.Hour})
+	defer cleanup()
+	now := time.Now()
+	f.now = func() time.Time { return now }
+
+	f.Write([]byte("first\n"))
+	f.Write([]byte("second\n"))
+	now = now.Add(time.Hour)
+	f.Write([]byte("third\n"))
+	f.wg.Wait()
+
+	backups, err := f.backups()
+	assert.NoError(t, err)
+	if assert.Len(t, backups, 1) {
+		content, _ := ioutil.ReadFile(backups[0])
+		assert.Equal(t, "first\nsecond\n", string(content))
+	}
+}
+
+func TestRotateCompress(t *testing.T) {
+	f, _, cleanup := newTestRotatingFile(t, RotateOptions{Compress: true})
+	defer cleanup()
+
+	f.Write([]byte("first\n"))
+	assert.NoError(t, f.Rotate())
+	f.wg.Wait()
+
+	backups, err := f.backups()
+	assert.NoError(t, err)
+	if assert.Len(t, backups, 1) && assert.True(t, strings.HasSuffix(backups[0], ".gz")) {
+		file, err := os.Open(backups[0])
+		assert.NoError(t, err)
+		defer file.Close()
+		gz, err := gzip.NewReader(file)
+		assert.NoError(t, err)
+		content, err := ioutil.ReadAll(gz)
+		assert.NoError(t, err)
+		assert.Equal(t, "first\n", string(content))
+	}
+}
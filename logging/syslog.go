@@ -0,0 +1,311 @@
+package logging
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/syslog"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Option configures the logger returned by New or NewWithRotation.
+type Option func(*options)
+
+type options struct {
+	syslog  SyslogOptions
+	targets []Target
+	hooks   []Hook
+	// jsonSchema is set by WithJSONSchema.
+	jsonSchema *JSONSchema
+}
+
+// SyslogOptions configures where logs at or above the syslog level are sent.
+type SyslogOptions struct {
+	// Network and Addr are the address of a remote syslog server, for instance "udp" and
+	// "logs.example.com:514", receiving messages formatted as RFC5424. Messages sent over "tcp" are
+	// framed with their length, as RFC6587 describes. Messages are sent to the local syslog daemon when
+	// Addr is empty.
+	Network string
+	Addr    string
+	// Tag identifies the service in messages, as the APP-NAME of RFC5424. Defaults to the name of the
+	// executable.
+	Tag string
+	// Facility of the messages. Defaults to syslog.LOG_USER.
+	Facility syslog.Priority
+}
+
+// Syslog configures how logs are sent to syslog. By default, they are sent to the local syslog daemon,
+// with the facility user. In any case, the severity of messages follows the level of logs.
+func Syslog(opts SyslogOptions) Option {
+	return func(o *options) {
+		o.syslog = opts
+	}
+}
+
+// syslogWriter writes messages to syslog, with the severity of their level.
+type syslogWriter interface {
+	write(lvl level, message string) error
+}
+
+func newSyslogWriter(opts SyslogOptions) (syslogWriter, error) {
+	if opts.Addr == "" {
+		if opts.Facility == 0 {
+			opts.Facility = syslog.LOG_USER
+		}
+		w, err := syslog.New(opts.Facility|syslog.LOG_NOTICE, opts.Tag)
+		if err != nil {
+			return nil, err
+		}
+		return localSyslog{w}, nil
+	}
+	return dialRFC5424(opts)
+}
+
+// syslogSeverity maps levels to syslog severities.
+func syslogSeverity(lvl level) syslog.Priority {
+	switch {
+	case lvl >= levelCritical:
+		return syslog.LOG_CRIT
+	case lvl >= levelError:
+		return syslog.LOG_ERR
+	case lvl >= levelWarn:
+		return syslog.LOG_WARNING
+	case lvl >= levelInfo:
+		return syslog.LOG_INFO
+	default:
+		return syslog.LOG_DEBUG
+	}
+}
+
+// localSyslog writes to the local syslog daemon.
+type localSyslog struct {
+	w *syslog.Writer
+}
+
+func (s localSyslog) write(lvl level, message string) error {
+	switch syslogSeverity(lvl) {
+	case syslog.LOG_CRIT:
+		return s.w.Crit(message)
+	case syslog.LOG_ERR:
+		return s.w.Err(message)
+	case syslog.LOG_WARNING:
+		return s.w.Warning(message)
+	case syslog.LOG_INFO:
+		return s.w.Info(message)
+	default:
+		return s.w.Debug(message)
+	}
+}
+
+const (
+	// syslogQueueSize is the number of messages waiting to be sent to a remote syslog server, beyond
+	// which messages are dropped rather than blocking the loggers.
+	syslogQueueSize = 1024
+	// syslogTimeout bounds how long connecting to a remote syslog server, and every write to it, takes.
+	syslogTimeout = 5 * time.Second
+	// syslogRedialInterval is how long messages are dropped after a remote syslog server could not be
+	// reached, before connecting again.
+	syslogRedialInterval = time.Second
+	// syslogSyncTimeout bounds how long logging a critical message waits for it to be sent.
+	syslogSyncTimeout = 2 * syslogTimeout
+)
+
+var (
+	errSyslogQueueFull   = errors.New("syslog queue is full, message dropped")
+	errSyslogUnreachable = errors.New("syslog server is unreachable, message dropped")
+	errSyslogTimeout     = errors.New("timed out sending a message to syslog")
+)
+
+// rfc5424Syslog writes RFC5424 messages to a remote syslog server, reconnecting when writes fail.
+// Messages are queued and sent by a goroutine, so that a slow server does not block the loggers,
+// except for critical messages, which are often logged right before the process exits: writing them
+// waits until they are sent, along with the messages queued before them.
+type rfc5424Syslog struct {
+	network  string
+	addr     string
+	facility syslog.Priority
+	hostname string
+	tag      string
+	pid      int
+	now      func() time.Time
+
+	queue   chan syslogMessage
+	dropped int64 // atomic
+
+	// owned by the sending goroutine once started
+	conn     net.Conn
+	nextDial time.Time
+}
+
+// dialRFC5424 returns a writer to the server at opts.Addr. The writer is returned even if the server
+// cannot be reached yet, along with the error, as it connects again when sending the next messages.
+func dialRFC5424(opts SyslogOptions) (syslogWriter, error) {
+	if opts.Network == "" {
+		opts.Network = "udp"
+	}
+	if opts.Facility == 0 {
+		opts.Facility = syslog.LOG_USER
+	}
+	if opts.Tag == "" {
+		opts.Tag = filepath.Base(os.Args[0])
+	}
+	hostname, _ := os.Hostname()
+	s := &rfc5424Syslog{
+		network:  opts.Network,
+		addr:     opts.Addr,
+		facility: opts.Facility,
+		hostname: syslogHeaderField(hostname, 255),
+		tag:      syslogHeaderField(opts.Tag, 48),
+		pid:      os.Getpid(),
+		now:      time.Now,
+		queue:    make(chan syslogMessage, syslogQueueSize),
+	}
+	err := s.connect()
+	go s.send()
+	return s, err
+}
+
+// connect must be called by the sending goroutine.
+func (s *rfc5424Syslog) connect() error {
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+	conn, err := net.DialTimeout(s.network, s.addr, syslogTimeout)
+	if err != nil {
+		s.nextDial = time.Now().Add(syslogRedialInterval)
+		return err
+	}
+	s.conn = conn
+	return nil
+}
+
+// syslogMessage is a formatted message waiting to be sent.
+type syslogMessage struct {
+	text string
+	// sent, if set, receives the result of sending the message.
+	sent chan error
+}
+
+// write queues message, or drops it if the queue is full. Critical messages are sent synchronously.
+func (s *rfc5424Syslog) write(lvl level, message string) error {
+	msg := syslogMessage{text: s.format(lvl, message)}
+	if lvl >= levelCritical {
+		return s.writeSync(msg)
+	}
+	select {
+	case s.queue <- msg:
+		return nil
+	default:
+		atomic.AddInt64(&s.dropped, 1)
+		return errSyslogQueueFull
+	}
+}
+
+// writeSync queues msg and waits until it is sent, for at most syslogSyncTimeout.
+func (s *rfc5424Syslog) writeSync(msg syslogMessage) error {
+	msg.sent = make(chan error, 1)
+	timer := time.NewTimer(syslogSyncTimeout)
+	defer timer.Stop()
+	select {
+	case s.queue <- msg:
+	case <-timer.C:
+		atomic.AddInt64(&s.dropped, 1)
+		return errSyslogQueueFull
+	}
+	select {
+	case err := <-msg.sent:
+		return err
+	case <-timer.C:
+		return errSyslogTimeout
+	}
+}
+
+// send sends the queued messages. Messages that cannot be sent are dropped.
+func (s *rfc5424Syslog) send() {
+	for msg := range s.queue {
+		err := s.sendMessage(msg.text)
+		if err != nil {
+			atomic.AddInt64(&s.dropped, 1)
+		}
+		if msg.sent != nil {
+			msg.sent <- err
+		}
+	}
+}
+
+// sendMessage writes msg to the server, reconnecting once if the write fails.
+func (s *rfc5424Syslog) sendMessage(msg string) error {
+	if s.conn != nil && s.writeConn(msg) == nil {
+		return nil
+	}
+	if time.Now().Before(s.nextDial) {
+		return errSyslogUnreachable
+	}
+	if err := s.connect(); err != nil {
+		return err
+	}
+	return s.writeConn(msg)
+}
+
+// SyslogDropped returns the number of messages l, or the logger it was derived from with Named, dropped
+// instead of sending them to the remote syslog server configured with Syslog, because the server was
+// too slow or unreachable. It returns false if l does not send logs to a remote syslog server.
+func SyslogDropped(l Logger) (int64, bool) {
+	lg, ok := l.(*logger)
+	if !ok {
+		return 0, false
+	}
+	s, ok := lg.syslog.(*rfc5424Syslog)
+	if !ok {
+		return 0, false
+	}
+	return atomic.LoadInt64(&s.dropped), true
+}
+
+func (s *rfc5424Syslog) writeConn(msg string) error {
+	if err := s.conn.SetWriteDeadline(time.Now().Add(syslogTimeout)); err != nil {
+		return err
+	}
+	_, err := io.WriteString(s.conn, msg)
+	return err
+}
+
+// format returns the message framed for the network:
+//
+//	<PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA MSG
+func (s *rfc5424Syslog) format(lvl level, message string) string {
+	message = strings.TrimSuffix(message, "\n")
+	msg := fmt.Sprintf("<%d>1 %s %s %s %d - - %s",
+		s.facility|syslogSeverity(lvl),
+		s.now().Format("2006-01-02T15:04:05.000000Z07:00"),
+		s.hostname, s.tag, s.pid, message)
+	if s.network == "tcp" || s.network == "tcp4" || s.network == "tcp6" {
+		// octet counting, as messages may contain newlines
+		return fmt.Sprintf("%d %s", len(msg), msg)
+	}
+	return msg
+}
+
+// syslogHeaderField returns s as a valid header field of at most max characters, which are printable
+// ASCII characters other than spaces, or - if it is empty.
+func syslogHeaderField(s string, max int) string {
+	b := []byte(s)
+	for i, c := range b {
+		if c < 33 || c > 126 {
+			b[i] = '_'
+		}
+	}
+	if len(b) > max {
+		b = b[:max]
+	}
+	if len(b) == 0 {
+		return "-"
+	}
+	return string(b)
+}
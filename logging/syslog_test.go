@@ -0,0 +1,156 @@
+package logging
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"log/syslog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// bufferSyslog writes messages to buf, and records their levels.
+type bufferSyslog struct {
+	buf    *bytes.Buffer
+	levels []level
+}
+
+func (s *bufferSyslog) write(lvl level, message string) error {
+	s.levels = append(s.levels, lvl)
+	_, err := s.buf.WriteString(message)
+	return err
+}
+
+func TestSyslogSeverity(t *testing.T) {
+	assert.Equal(t, syslog.LOG_DEBUG, syslogSeverity(levelDebug))
+	assert.Equal(t, syslog.LOG_INFO, syslogSeverity(levelInfo))
+	assert.Equal(t, syslog.LOG_WARNING, syslogSeverity(levelWarn))
+	assert.Equal(t, syslog.LOG_ERR, syslogSeverity(levelError))
+	assert.Equal(t, syslog.LOG_CRIT, syslogSeverity(levelCritical))
+}
+
+func TestSyslogLevels(t *testing.T) {
+	logger := newLogger(levelNever, "", nil, levelNever, formatText)
+	s := &bufferSyslog{buf: &bytes.Buffer{}}
+	logger.syslog = s
+	logger.levels = newLevels(levelWarn, levelNever)
+
+	logger.Info("info", nil)
+	logger.Warn("warn", nil)
+	logger.Critical("critical", nil)
+	assert.Equal(t, []level{levelWarn, levelCritical}, s.levels)
+}
+
+func TestRFC5424UDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	w, err := dialRFC5424(SyslogOptions{Addr: conn.LocalAddr().String(), Tag: "my service", Facility: syslog.LOG_LOCAL0})
+	assert.NoError(t, err)
+	s := w.(*rfc5424Syslog)
+	s.hostname = "host"
+	s.now = func() time.Time { return time.Date(2019, 3, 1, 12, 30, 0, 123456000, time.UTC) }
+	assert.NoError(t, w.write(levelError, "mixpanel {}\n"))
+
+	buf := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if assert.NoError(t, err) {
+		// local0 is 16, err is 3: 16*8+3
+		expected := "<131>1 2019-03-01T12:30:00.123456Z host my_service " + strconv.Itoa(os.Getpid()) + " - - mixpanel {}"
+		assert.Equal(t, expected, string(buf[:n]))
+	}
+}
+
+func TestRFC5424TCP(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer l.Close()
+	lines := make(chan string, 2)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		for {
+			length, err := reader.ReadString(' ')
+			if err != nil {
+				return
+			}
+			n, _ := strconv.Atoi(strings.TrimSpace(length))
+			msg := make([]byte, n)
+			if _, err := io.ReadFull(reader, msg); err != nil {
+				return
+			}
+			lines <- string(msg)
+		}
+	}()
+
+	w, err := dialRFC5424(SyslogOptions{Network: "tcp", Addr: l.Addr().String(), Tag: "svc"})
+	assert.NoError(t, err)
+	assert.NoError(t, w.write(levelInfo, "first\nline"))
+	assert.NoError(t, w.write(levelDebug, "second"))
+
+	for _, expected := range []string{"<14>1 ", "<15>1 "} {
+		select {
+		case line := <-lines:
+			assert.True(t, strings.HasPrefix(line, expected), line)
+			assert.Contains(t, line, " svc ")
+		case <-time.After(5 * time.Second):
+			t.Fatal("no message received")
+		}
+	}
+}
+
+func TestRFC5424Full(t *testing.T) {
+	// nothing sends the queued messages, as if the server were stalled
+	s := &rfc5424Syslog{network: "udp", now: time.Now, queue: make(chan syslogMessage, 1)}
+	assert.NoError(t, s.write(levelInfo, "queued"))
+	assert.Equal(t, errSyslogQueueFull, s.write(levelInfo, "dropped"), "writes do not block")
+	assert.Equal(t, int64(1), s.dropped)
+
+	l := &logger{syslog: s}
+	dropped, ok := SyslogDropped(l.Named("child"))
+	assert.True(t, ok)
+	assert.Equal(t, int64(1), dropped)
+	_, ok = SyslogDropped(&logger{syslog: &bufferSyslog{}})
+	assert.False(t, ok)
+}
+
+func TestRFC5424CriticalIsSynchronous(t *testing.T) {
+	// the test sends the queued messages
+	queue := make(chan syslogMessage, 2)
+	s := &rfc5424Syslog{network: "udp", now: time.Now, queue: queue}
+	go func() {
+		assert.NoError(t, s.write(levelInfo, "before"))
+		assert.NoError(t, s.write(levelCritical, "exiting"))
+		close(queue)
+	}()
+	var msgs []syslogMessage
+	for msg := range queue {
+		msgs = append(msgs, msg)
+		if msg.sent != nil {
+			msg.sent <- nil
+		}
+	}
+	if assert.Len(t, msgs, 2) {
+		assert.Nil(t, msgs[0].sent, "other messages are not waited for")
+		assert.NotNil(t, msgs[1].sent)
+		assert.Contains(t, msgs[1].text, "exiting")
+	}
+}
+
+func TestSyslogHeaderField(t *testing.T) {
+	assert.Equal(t, "-", syslogHeaderField("", 48))
+	assert.Equal(t, "a_b", syslogHeaderField("a b", 48))
+	assert.Equal(t, "abc", syslogHeaderField("abcdef", 3))
+}
@@ -0,0 +1,86 @@
+package logging
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// Target is an additional destination of logs, with its own level and format, for instance to write
+// JSON to stdout for a log collector while keeping warnings as text in a local file.
+type Target struct {
+	// Level is the minimum level of the logs written, such as "warn".
+	Level string
+	// Format is "json", "text" or "console".
+	Format string
+	// Writer receives the logs, one per line. When it is nil, logs are appended to the file at Path,
+	// or written to stdout if Path is empty.
+	Writer io.Writer
+	Path   string
+}
+
+// WithTargets makes the logger write its logs to targets, in addition to the file or stderr and syslog
+// configured by the arguments of New. Unlike theirs, the levels of targets cannot be changed with
+// SetLevel.
+func WithTargets(targets ...Target) Option {
+	return func(o *options) {
+		o.targets = append(o.targets, targets...)
+	}
+}
+
+// target is an opened Target.
+type target struct {
+	level  level
+	format format
+	color  bool
+	// jsonSchema, if set, is the schema of JSON logs.
+	jsonSchema *JSONSchema
+
+	mutex sync.Mutex // serializes writes, so that lines do not interleave
+	w     io.Writer
+}
+
+// openTargets opens targets, skipping those that cannot be opened after recording an init error.
+func openTargets(targets []Target, schema *JSONSchema) []*target {
+	var opened []*target
+	for _, t := range targets {
+		lvl := levelStringToLevel(t.Level)
+		if lvl == levelNever {
+			continue
+		}
+		w := t.Writer
+		if w == nil && t.Path != "" {
+			file, err := os.OpenFile(t.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+			if err != nil {
+				initError(fmt.Sprintf("Unable to open file for logging: %v.", err))
+				continue
+			}
+			w = file
+		} else if w == nil {
+			w = os.Stdout
+		}
+		format := formatToEnum(t.Format)
+		color := false
+		if file, ok := w.(*os.File); ok && format == formatConsole {
+			color = consoleColor(file)
+		}
+		opened = append(opened, &target{level: lvl, format: format, color: color, w: w, jsonSchema: schema})
+	}
+	return opened
+}
+
+func (t *target) write(lvl level, name, message string, fields Fields) {
+	var line string
+	switch t.format {
+	case formatJSON:
+		line = jsonFormatter(lvl, name, message, fields, t.jsonSchema)
+	case formatText:
+		line = textFormatter(lvl, name, message, fields)
+	case formatConsole:
+		line = consoleFormatter(lvl, name, message, fields, t.color)
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	io.WriteString(t.w, line+"\n")
+}
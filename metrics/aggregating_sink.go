@@ -0,0 +1,308 @@
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mixpanel/obs/clock"
+)
+
+// AggregationOptions configures NewAggregatingReceiver.
+type AggregationOptions struct {
+	// Interval at which aggregates are handed to the destination sink.
+	Interval time.Duration
+	// Percentiles reported for stats, between 0 and 1, such as 0.999 for the 99.9th percentile.
+	Percentiles []float64
+	// HistogramScale is the scale of the histograms stats are aggregated in, which bounds the error
+	// of the percentiles. Defaults to DefaultHistogramScale.
+	HistogramScale int
+	// Deprecated: MaxSamples is ignored, as stats are aggregated in histograms whose size does not
+	// depend on the number of values.
+	MaxSamples int
+	// Clock drives the flushes. Defaults to clock.Real.
+	Clock clock.Clock
+}
+
+// DefaultAggregationOptions flushes every 10 seconds and reports the median, 90th and 99th percentiles.
+var DefaultAggregationOptions = AggregationOptions{
+	Interval:    10 * time.Second,
+	Percentiles: []float64{0.5, 0.9, 0.99},
+	MaxSamples:  1024,
+}
+
+type aggregateKey struct {
+	name string
+	tags string
+}
+
+type aggregate struct {
+	metricType metricType
+	tags       Tags
+
+	count int64
+	sum   float64
+	min   float64
+	max   float64
+	last  float64
+	hist  *Histogram // for stats
+}
+
+// counterAggregate is the aggregate of a counter.
+type counterAggregate struct {
+	shardedCounter // first for 64-bit alignment
+	key            aggregateKey
+	tags           Tags
+}
+
+type aggregatingSink struct {
+	dst  Sink
+	opts AggregationOptions
+
+	// beforeFlush samples the gauges registered with the receiver.
+	beforeFlush func()
+
+	mutex      sync.Mutex
+	aggregates map[aggregateKey]*aggregate // gauges and stats
+	// retired are the counters removed from counters at the last flush, as they were idle. They are
+	// collected once more, in case they were updated while being removed.
+	retired []*counterAggregate
+
+	// counters maps aggregateKeys to *counterAggregates. Counters are the most frequently updated
+	// metrics, so they are updated without taking the mutex.
+	counters sync.Map
+
+	done     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+
+	stats sinkStats
+}
+
+// NewAggregatingReceiver returns a Receiver that accumulates metrics in memory and hands aggregates to
+// dst once per interval, instead of one sink call per metric. Within an interval, counters are summed,
+// gauges keep their last value, and stats are reported as gauges suffixed with .min, .max, .avg and one
+// per percentile (.median for 0.5, .90percentile for 0.9, .99_9percentile for 0.999, ...), along with a
+// .count counter. Percentiles are computed from a Histogram of every value; sinks implementing
+// HistogramSink are handed the histograms instead, so that they can be merged downstream.
+// Counters are updated without locking, so that concurrent increments scale with the number of CPUs.
+// The returned function flushes pending aggregates and stops the flush loop; it does not close dst.
+func NewAggregatingReceiver(dst Sink, opts AggregationOptions) (Receiver, func()) {
+	sink := newAggregatingSink(dst, opts)
+	r := NewReceiverWithClock(sink, sink.opts.Clock).(*receiver)
+	r.gauges.flushed = true
+	sink.beforeFlush = r.gauges.sample
+	sink.wg.Add(1)
+	go sink.flushLoop()
+	return r, sink.stop
+}
+
+func newAggregatingSink(dst Sink, opts AggregationOptions) *aggregatingSink {
+	if opts.Interval <= 0 {
+		opts.Interval = DefaultAggregationOptions.Interval
+	}
+	if opts.HistogramScale == 0 {
+		opts.HistogramScale = DefaultHistogramScale
+	}
+	if opts.Clock == nil {
+		opts.Clock = clock.Real
+	}
+	return &aggregatingSink{
+		dst:        dst,
+		opts:       opts,
+		aggregates: make(map[aggregateKey]*aggregate),
+		done:       make(chan struct{}),
+	}
+}
+
+func (sink *aggregatingSink) Handle(metric string, tags Tags, value float64, metricType metricType) error {
+	if len(metric) == 0 {
+		return sink.stats.serializationError(errors.New("cannot handle empty metric"))
+	}
+
+	key := aggregateKey{name: metric, tags: FormatTags(tags)}
+	if metricType == metricTypeCounter {
+		return sink.incr(key, tags, value)
+	}
+
+	sink.mutex.Lock()
+	defer sink.mutex.Unlock()
+
+	a, ok := sink.aggregates[key]
+	if !ok {
+		if _, ok := sink.counters.Load(key); ok {
+			return sink.stats.serializationError(fmt.Errorf("metric %s reported as %s and %s", metric, metricTypeCounter, metricType))
+		}
+		a = &aggregate{metricType: metricType, tags: tags, min: value, max: value}
+		if metricType == metricTypeStat {
+			a.hist = NewHistogram(sink.opts.HistogramScale)
+		}
+		sink.aggregates[key] = a
+	}
+	if a.metricType != metricType {
+		return sink.stats.serializationError(fmt.Errorf("metric %s reported as %s and %s", metric, a.metricType, metricType))
+	}
+
+	a.count++
+	a.sum += value
+	a.last = value
+	if value < a.min {
+		a.min = value
+	}
+	if value > a.max {
+		a.max = value
+	}
+	if a.hist != nil {
+		a.hist.Record(value)
+	}
+	return nil
+}
+
+func (sink *aggregatingSink) incr(key aggregateKey, tags Tags, value float64) error {
+	c, ok := sink.counters.Load(key)
+	if !ok {
+		sink.mutex.Lock()
+		a, conflict := sink.aggregates[key]
+		if !conflict {
+			c, _ = sink.counters.LoadOrStore(key, &counterAggregate{key: key, tags: tags})
+		}
+		sink.mutex.Unlock()
+		if conflict {
+			return sink.stats.serializationError(fmt.Errorf("metric %s reported as %s and %s", key.name, a.metricType, metricTypeCounter))
+		}
+	}
+	c.(*counterAggregate).add(value)
+	return nil
+}
+
+func (sink *aggregatingSink) Flush() error {
+	if sink.beforeFlush != nil {
+		sink.beforeFlush()
+	}
+	sink.mutex.Lock()
+	aggregates := sink.aggregates
+	sink.aggregates = make(map[aggregateKey]*aggregate, len(aggregates))
+	retired := sink.retired
+	sink.retired = nil
+	sink.mutex.Unlock()
+
+	for key, total := range sink.collectCounters(retired) {
+		sink.emit(key.name, total.tags, total.sum, metricTypeCounter)
+	}
+	histograms := HandlesHistograms(sink.dst)
+	for key, a := range aggregates {
+		switch {
+		case a.metricType == metricTypeGauge:
+			sink.emit(key.name, a.tags, a.last, metricTypeGauge)
+		case histograms:
+			if err := handleHistogram(sink.dst, key.name, a.tags, a.hist); err != nil {
+				log.Printf("error while flushing aggregated histogram %s: %v", key.name, err)
+			}
+		default:
+			sink.emit(key.name+".count", a.tags, float64(a.count), metricTypeCounter)
+			sink.emit(key.name+".min", a.tags, a.min, metricTypeGauge)
+			sink.emit(key.name+".max", a.tags, a.max, metricTypeGauge)
+			sink.emit(key.name+".avg", a.tags, a.sum/float64(a.count), metricTypeGauge)
+			for _, p := range sink.opts.Percentiles {
+				sink.emit(key.name+"."+percentileSuffix(p), a.tags, a.hist.Quantile(p), metricTypeGauge)
+			}
+		}
+	}
+	return sink.dst.Flush()
+}
+
+type counterTotal struct {
+	tags Tags
+	sum  float64
+}
+
+// collectCounters returns the totals of the counters updated since the last flush, including the
+// retired ones, and retires the counters that were not updated.
+func (sink *aggregatingSink) collectCounters(retired []*counterAggregate) map[aggregateKey]*counterTotal {
+	totals := make(map[aggregateKey]*counterTotal)
+	collect := func(c *counterAggregate) bool {
+		sum, touched := c.collect()
+		if !touched {
+			return false
+		}
+		if total, ok := totals[c.key]; ok {
+			total.sum += sum
+		} else {
+			totals[c.key] = &counterTotal{tags: c.tags, sum: sum}
+		}
+		return true
+	}
+
+	for _, c := range retired {
+		collect(c)
+	}
+	var idle []*counterAggregate
+	sink.counters.Range(func(k, v interface{}) bool {
+		c := v.(*counterAggregate)
+		if !collect(c) {
+			sink.counters.Delete(k)
+			idle = append(idle, c)
+		}
+		return true
+	})
+	if len(idle) > 0 {
+		sink.mutex.Lock()
+		sink.retired = append(sink.retired, idle...)
+		sink.mutex.Unlock()
+	}
+	return totals
+}
+
+func (sink *aggregatingSink) emit(metric string, tags Tags, value float64, metricType metricType) {
+	if err := sink.dst.Handle(metric, tags, value, metricType); err != nil {
+		log.Printf("error while flushing aggregated metric %s: %v", metric, err)
+	}
+}
+
+// SinkStats counts the metrics reported with conflicting types, along with the stats of dst.
+func (sink *aggregatingSink) SinkStats() SinkStats {
+	return sink.stats.get().Add(SinkStatsOf(sink.dst))
+}
+
+// Snapshot returns the snapshot of dst.
+func (sink *aggregatingSink) Snapshot() Snapshot {
+	return SnapshotOf(sink.dst)
+}
+
+// Close flushes pending aggregates and closes the destination sink.
+func (sink *aggregatingSink) Close() {
+	sink.stop()
+	sink.dst.Close()
+}
+
+func (sink *aggregatingSink) stop() {
+	sink.stopOnce.Do(func() { close(sink.done) })
+	sink.wg.Wait()
+}
+
+func (sink *aggregatingSink) flushLoop() {
+	defer sink.wg.Done()
+	ticker := sink.opts.Clock.NewTicker(sink.opts.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-sink.done:
+			sink.Flush()
+			return
+		case <-ticker.C():
+			sink.Flush()
+		}
+	}
+}
+
+func percentileSuffix(p float64) string {
+	if p == 0.5 {
+		return "median"
+	}
+	// the '.' of fractional percentiles would add a segment to the name
+	return strings.Replace(strconv.FormatFloat(p*100, 'f', -1, 64), ".", "_", -1) + "percentile"
+}
@@ -0,0 +1,213 @@
+package metrics
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mixpanel/obs/clock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAggregatingSink(t *testing.T) {
+	dst := &MockSink{Invocations: make(map[string]int)}
+	sink := newAggregatingSink(dst, AggregationOptions{Percentiles: []float64{0.5, 0.99}})
+	r := NewReceiver(sink)
+
+	for i := 0; i < 5; i++ {
+		r.Incr("requests")
+	}
+	r.SetGauge("queue", 3)
+	r.SetGauge("queue", 7)
+	for i := 1; i <= 100; i++ {
+		r.AddStat("latency", float64(i))
+	}
+	r.ScopeTags(Tags{"shard": "1"}).Incr("requests")
+
+	assert.NoError(t, sink.Flush())
+	// percentiles are estimated from histograms
+	assert.InEpsilon(t, 50, popGauge(dst, "latency.median"), 0.011)
+	assert.InEpsilon(t, 99, popGauge(dst, "latency.99percentile"), 0.011)
+	assert.Equal(t, map[string]int{
+		"requests, map[], 5, ct\n":        1,
+		"requests, map[shard:1], 1, ct\n": 1,
+		"queue, map[], 7, g\n":            1,
+		"latency.count, map[], 100, ct\n": 1,
+		"latency.min, map[], 1, g\n":      1,
+		"latency.max, map[], 100, g\n":    1,
+		"latency.avg, map[], 50.5, g\n":   1,
+	}, dst.Invocations)
+
+	// aggregates are reset after each flush
+	dst.Invocations = make(map[string]int)
+	assert.NoError(t, sink.Flush())
+	assert.Empty(t, dst.Invocations)
+}
+
+// popGauge removes the untagged gauge name from the invocations of sink, and returns its value.
+func popGauge(sink *MockSink, name string) float64 {
+	prefix := name + ", map[], "
+	for key := range sink.Invocations {
+		if strings.HasPrefix(key, prefix) && strings.HasSuffix(key, ", g\n") {
+			delete(sink.Invocations, key)
+			value, _ := strconv.ParseFloat(strings.TrimSuffix(strings.TrimPrefix(key, prefix), ", g\n"), 64)
+			return value
+		}
+	}
+	return math.NaN()
+}
+
+func TestAggregatingSinkHistograms(t *testing.T) {
+	dst := &histogramSink{MockSink: NewMockSink(), histograms: make(map[string]*Histogram)}
+	sink := newAggregatingSink(dst, AggregationOptions{Percentiles: []float64{0.5, 0.999}})
+
+	for i := 1; i <= 100000; i++ {
+		sink.Handle("latency", nil, float64(i), metricTypeStat)
+	}
+	assert.NoError(t, sink.Flush())
+	assert.Empty(t, dst.Invocations, "histograms replace the stats")
+	h := dst.histograms["latency"]
+	if assert.NotNil(t, h) {
+		assert.Equal(t, uint64(100000), h.Count())
+		assert.InEpsilon(t, 50000, h.Quantile(0.5), 0.011)
+		assert.InEpsilon(t, 99900, h.Quantile(0.999), 0.011)
+	}
+
+	mock := NewMockSink()
+	sink = newAggregatingSink(mock, AggregationOptions{Percentiles: []float64{0.999}})
+	sink.Handle("latency", nil, 7, metricTypeStat)
+	assert.NoError(t, sink.Flush())
+	assert.Equal(t, 1, mock.Invocations["latency.99_9percentile, map[], 7, g\n"])
+}
+
+type histogramSink struct {
+	*MockSink
+	histograms map[string]*Histogram
+}
+
+func (sink *histogramSink) HandleHistogram(metric string, tags Tags, h *Histogram) error {
+	sink.histograms[metric] = h
+	return nil
+}
+
+func TestAggregatingSinkWrappedHistograms(t *testing.T) {
+	dst := &histogramSink{MockSink: NewMockSink(), histograms: make(map[string]*Histogram)}
+	snapshots := NewSnapshotSink(dst, 0)
+	wrapped := NewDenyListSink(NewNameValidatingSink(NewCardinalityLimitedSink(NewWindowSink(snapshots, time.Minute), 10, "limited"), NameValidation{}))
+	sink := newAggregatingSink(wrapped, AggregationOptions{Percentiles: []float64{0.5}})
+	sink.Handle("latency", nil, 7, metricTypeStat)
+	sink.Handle("latency", nil, 9, metricTypeStat)
+	assert.NoError(t, sink.Flush())
+
+	if assert.Contains(t, dst.histograms, "latency") {
+		assert.Equal(t, uint64(2), dst.histograms["latency"].Count())
+	}
+	assert.Equal(t, 0, dst.NumInvocations())
+	assert.Equal(t, StatSnapshot{Count: 2, Sum: 16, Min: 7, Max: 9}, snapshots.Snapshot().Stats["latency"])
+
+	mock := NewMockSink()
+	sink = newAggregatingSink(NewDenyListSink(mock), AggregationOptions{Percentiles: []float64{0.5}})
+	sink.Handle("latency", nil, 7, metricTypeStat)
+	assert.NoError(t, sink.Flush())
+	assert.Equal(t, 1, mock.Invocations["latency.median, map[], 7, g\n"], "wrappers of sinks without histograms get the percentiles")
+}
+
+func TestNewAggregatingReceiver(t *testing.T) {
+	dst := &MockSink{Invocations: make(map[string]int)}
+	r, stop := NewAggregatingReceiver(dst, DefaultAggregationOptions)
+	r.IncrBy("bytes", 10)
+	r.IncrBy("bytes", 5)
+	stop()
+	stop()
+
+	assert.Equal(t, map[string]int{"bytes, map[], 15, ct\n": 1}, dst.Invocations)
+}
+
+func TestAggregatingReceiverClock(t *testing.T) {
+	dst := NewMockSink()
+	c := clock.NewFake(time.Unix(0, 0))
+	r, stop := NewAggregatingReceiver(dst, AggregationOptions{Interval: time.Minute, Clock: c})
+	defer stop()
+	r.IncrBy("bytes", 10)
+
+	c.BlockUntil(1)
+	c.Advance(time.Minute)
+	deadline := time.Now().Add(time.Second)
+	for invocationCount(dst, "bytes, map[], 10, ct\n") == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	assert.Equal(t, 1, invocationCount(dst, "bytes, map[], 10, ct\n"))
+}
+
+func TestAggregatingSinkConcurrentCounters(t *testing.T) {
+	dst := &MockSink{Invocations: make(map[string]int)}
+	sink := newAggregatingSink(dst, DefaultAggregationOptions)
+	r := NewReceiver(sink)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				r.Incr("requests")
+			}
+		}()
+	}
+	wg.Wait()
+	assert.NoError(t, sink.Flush())
+	assert.Equal(t, map[string]int{"requests, map[], 8000, ct\n": 1}, dst.Invocations)
+}
+
+func TestAggregatingSinkIdleCounters(t *testing.T) {
+	dst := &MockSink{Invocations: make(map[string]int)}
+	sink := newAggregatingSink(dst, DefaultAggregationOptions)
+
+	sink.Handle("requests", nil, 1, metricTypeCounter)
+	assert.NoError(t, sink.Flush())
+	// idle counters are retired, and still collected once
+	assert.NoError(t, sink.Flush())
+	_, ok := sink.counters.Load(aggregateKey{name: "requests", tags: FormatTags(nil)})
+	assert.False(t, ok)
+	assert.Len(t, sink.retired, 1)
+	sink.retired[0].add(2)
+	sink.Handle("requests", nil, 3, metricTypeCounter)
+
+	dst.Invocations = make(map[string]int)
+	assert.NoError(t, sink.Flush())
+	assert.Equal(t, map[string]int{"requests, map[], 5, ct\n": 1}, dst.Invocations)
+	assert.Empty(t, sink.retired)
+}
+
+func TestAggregatingSinkTypeConflict(t *testing.T) {
+	sink := newAggregatingSink(&MockSink{Invocations: make(map[string]int)}, DefaultAggregationOptions)
+	assert.NoError(t, sink.Handle("requests", nil, 1, metricTypeCounter))
+	assert.Error(t, sink.Handle("requests", nil, 1, metricTypeGauge))
+	assert.NoError(t, sink.Handle("queue", nil, 1, metricTypeGauge))
+	assert.Error(t, sink.Handle("queue", nil, 1, metricTypeCounter))
+}
+
+// BenchmarkAggregatingReceiverIncr measures concurrent increments of a counter, which should scale
+// with -cpu as they do not take a lock; compare with BenchmarkAggregatingReceiverAddStat.
+func BenchmarkAggregatingReceiverIncr(b *testing.B) {
+	r, stop := NewAggregatingReceiver(NewMockSink(), DefaultAggregationOptions)
+	defer stop()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			r.Incr("requests")
+		}
+	})
+}
+
+func BenchmarkAggregatingReceiverAddStat(b *testing.B) {
+	r, stop := NewAggregatingReceiver(NewMockSink(), DefaultAggregationOptions)
+	defer stop()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			r.AddStat("latency", 1)
+		}
+	})
+}
@@ -0,0 +1,111 @@
+package metrics
+
+import (
+	"sync"
+	"time"
+)
+
+// OverflowTagValue replaces the tag values of metrics reported with more distinct tag combinations than
+// allowed by NewCardinalityLimitedSink.
+const OverflowTagValue = "__overflow__"
+
+type cardinalityLimitedSink struct {
+	dst            Sink
+	limit          int
+	limitedCounter string
+
+	mutex sync.Mutex
+	seen  map[string]map[string]struct{}
+}
+
+// NewCardinalityLimitedSink returns a Sink that lets at most limit distinct tag combinations of each
+// metric through to dst. Beyond that, new combinations are reported with every tag value replaced by
+// OverflowTagValue, and limitedCounter is incremented with a metric tag naming the offending metric.
+func NewCardinalityLimitedSink(dst Sink, limit int, limitedCounter string) Sink {
+	return &cardinalityLimitedSink{
+		dst:            dst,
+		limit:          limit,
+		limitedCounter: limitedCounter,
+		seen:           make(map[string]map[string]struct{}),
+	}
+}
+
+func (sink *cardinalityLimitedSink) Handle(metric string, tags Tags, value float64, metricType metricType) error {
+	return sink.HandleAt(metric, tags, value, metricType, time.Time{})
+}
+
+func (sink *cardinalityLimitedSink) HandleAt(metric string, tags Tags, value float64, metricType metricType, at time.Time) error {
+	if len(tags) == 0 || sink.allow(metric, tags) {
+		return handleAt(sink.dst, metric, tags, value, metricType, at)
+	}
+
+	overflow := make(Tags, len(tags))
+	for k := range tags {
+		overflow[k] = OverflowTagValue
+	}
+	if err := sink.dst.Handle(sink.limitedCounter, Tags{"metric": metric}, 1, metricTypeCounter); err != nil {
+		return err
+	}
+	return handleAt(sink.dst, metric, overflow, value, metricType, at)
+}
+
+// HandleHistogram passes the histogram on to dst, with its tag values replaced like those of the
+// metrics of HandleAt.
+func (sink *cardinalityLimitedSink) HandleHistogram(metric string, tags Tags, h *Histogram) error {
+	if len(tags) == 0 || sink.allow(metric, tags) {
+		return handleHistogram(sink.dst, metric, tags, h)
+	}
+
+	overflow := make(Tags, len(tags))
+	for k := range tags {
+		overflow[k] = OverflowTagValue
+	}
+	if err := sink.dst.Handle(sink.limitedCounter, Tags{"metric": metric}, 1, metricTypeCounter); err != nil {
+		return err
+	}
+	return handleHistogram(sink.dst, metric, overflow, h)
+}
+
+func (sink *cardinalityLimitedSink) handlesHistograms() bool {
+	return HandlesHistograms(sink.dst)
+}
+
+// allow reports whether tags are one of the first limit combinations seen for metric.
+func (sink *cardinalityLimitedSink) allow(metric string, tags Tags) bool {
+	key := FormatTags(tags)
+
+	sink.mutex.Lock()
+	defer sink.mutex.Unlock()
+
+	combinations, ok := sink.seen[metric]
+	if !ok {
+		combinations = make(map[string]struct{})
+		sink.seen[metric] = combinations
+	}
+	if _, ok := combinations[key]; ok {
+		return true
+	}
+	if len(combinations) >= sink.limit {
+		return false
+	}
+	combinations[key] = struct{}{}
+	return true
+}
+
+func (sink *cardinalityLimitedSink) Flush() error {
+	return sink.dst.Flush()
+}
+
+// SinkStats returns the stats of dst: the overflowing metrics are counted by limitedCounter.
+func (sink *cardinalityLimitedSink) SinkStats() SinkStats {
+	return SinkStatsOf(sink.dst)
+}
+
+// Snapshot returns the snapshot of dst.
+func (sink *cardinalityLimitedSink) Snapshot() Snapshot {
+	return SnapshotOf(sink.dst)
+}
+
+func (sink *cardinalityLimitedSink) Close() {
+	sink.dst.Close()
+}
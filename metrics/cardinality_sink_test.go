@@ -0,0 +1,23 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCardinalityLimitedSink(t *testing.T) {
+	dst := &MockSink{Invocations: make(map[string]int)}
+	r := NewReceiver(NewCardinalityLimitedSink(dst, 2, "cardinality_limited"))
+
+	for _, user := range []string{"a", "b", "a", "c", "d"} {
+		r.ScopeTags(Tags{"user": user}).Incr("requests")
+	}
+	r.Incr("requests")
+
+	assert.Equal(t, 2, dst.Invocations["requests, map[user:a], 1, ct\n"])
+	assert.Equal(t, 1, dst.Invocations["requests, map[user:b], 1, ct\n"])
+	assert.Equal(t, 2, dst.Invocations["requests, map[user:__overflow__], 1, ct\n"])
+	assert.Equal(t, 2, dst.Invocations["cardinality_limited, map[metric:requests], 1, ct\n"])
+	assert.Equal(t, 1, dst.Invocations["requests, map[], 1, ct\n"])
+}
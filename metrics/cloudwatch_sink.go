@@ -0,0 +1,306 @@
+package metrics
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CloudWatch rejects EMF documents with more than 100 metrics or 30 dimensions, or with more than 100
+// values for a metric.
+const (
+	cloudWatchMaxMetrics    = 100
+	cloudWatchMaxDimensions = 30
+	cloudWatchMaxValues     = 100
+)
+
+// defaultCloudWatchFlushInterval is the resolution of standard CloudWatch metrics.
+const defaultCloudWatchFlushInterval = time.Minute
+
+// CloudWatchOption configures a CloudWatch Sink.
+type CloudWatchOption func(*cloudWatchSink)
+
+// CloudWatchFlushInterval sets how often metrics are written. Defaults to a minute. With an interval
+// of 0, metrics are only written when Flush or Close is called, for instance at the end of every
+// Lambda invocation, as a frozen Lambda environment does not run the flush loop.
+func CloudWatchFlushInterval(d time.Duration) CloudWatchOption {
+	return func(sink *cloudWatchSink) {
+		sink.flushInterval = d
+	}
+}
+
+type cloudWatchPoint struct {
+	name       string
+	metricType metricType
+	values     []float64
+	// observed counts the values of a stat, of which values keeps a uniform sample.
+	observed int
+}
+
+type cloudWatchGroup struct {
+	tags   Tags
+	points map[string]*cloudWatchPoint
+	order  []string
+}
+
+type cloudWatchSink struct {
+	namespace     string
+	tags          map[string]string
+	out           io.Writer
+	flushInterval time.Duration
+
+	flushMutex sync.Mutex // serializes writes to out
+
+	mutex  sync.Mutex // protects groups, order, and closed
+	groups map[string]*cloudWatchGroup
+	order  []string
+	closed bool
+
+	done chan struct{}
+	wg   sync.WaitGroup
+
+	stats sinkStats
+}
+
+func (sink *cloudWatchSink) Handle(metric string, tags Tags, value float64, metricType metricType) error {
+	if len(metric) == 0 {
+		return sink.stats.serializationError(errors.New("cannot handle empty metric"))
+	}
+
+	merged := make(Tags, len(sink.tags)+len(tags))
+	for k, v := range sink.tags {
+		merged[k] = v
+	}
+	for k, v := range tags {
+		merged[k] = v
+	}
+	if len(merged) > cloudWatchMaxDimensions {
+		return sink.stats.serializationError(fmt.Errorf("metric %s has %d dimensions, cloudwatch allows at most %d", metric, len(merged), cloudWatchMaxDimensions))
+	}
+	// metrics and dimensions are fields of the same document
+	if _, ok := merged[metric]; ok || metric == "_aws" {
+		return sink.stats.serializationError(fmt.Errorf("metric %s has the name of a dimension or of the _aws field", metric))
+	}
+
+	sink.mutex.Lock()
+	defer sink.mutex.Unlock()
+
+	if sink.closed {
+		sink.stats.drop(1)
+		return errors.New("sink is closed")
+	}
+
+	key := FormatTags(merged)
+	group, ok := sink.groups[key]
+	if !ok {
+		group = &cloudWatchGroup{tags: merged, points: make(map[string]*cloudWatchPoint)}
+		sink.groups[key] = group
+		sink.order = append(sink.order, key)
+	}
+
+	point, ok := group.points[metric]
+	if !ok {
+		point = &cloudWatchPoint{name: metric, metricType: metricType}
+		group.points[metric] = point
+		group.order = append(group.order, metric)
+	}
+
+	switch metricType {
+	case metricTypeCounter:
+		if len(point.values) == 0 {
+			point.values = append(point.values, 0)
+		}
+		point.values[0] += value
+	case metricTypeGauge:
+		point.values = append(point.values[:0], value)
+	case metricTypeStat:
+		// reservoir sampling keeps the values a uniform sample of those observed
+		point.observed++
+		if len(point.values) < cloudWatchMaxValues {
+			point.values = append(point.values, value)
+		} else if i := rand.Intn(point.observed); i < cloudWatchMaxValues {
+			point.values[i] = value
+		}
+	default:
+		return sink.stats.serializationError(fmt.Errorf("unknown metric type: %s", metricType))
+	}
+	return nil
+}
+
+func cloudWatchUnit(point *cloudWatchPoint) string {
+	switch {
+	case point.metricType == metricTypeCounter:
+		return "Count"
+	case strings.HasSuffix(point.name, "_us"):
+		return "Microseconds"
+	default:
+		return "None"
+	}
+}
+
+// encodeGroup renders a group as one or more Embedded Metric Format documents.
+// See https://docs.aws.amazon.com/AmazonCloudWatch/latest/monitoring/CloudWatch_Embedded_Metric_Format_Specification.html
+func (sink *cloudWatchSink) encodeGroup(group *cloudWatchGroup, timestamp int64) ([][]byte, error) {
+	dimensions := make([]string, 0, len(group.tags))
+	for k := range group.tags {
+		dimensions = append(dimensions, k)
+	}
+	sort.Strings(dimensions)
+
+	var docs [][]byte
+	for start := 0; start < len(group.order); start += cloudWatchMaxMetrics {
+		end := start + cloudWatchMaxMetrics
+		if end > len(group.order) {
+			end = len(group.order)
+		}
+
+		doc := make(map[string]interface{}, len(group.tags)+end-start+1)
+		for k, v := range group.tags {
+			doc[k] = v
+		}
+
+		definitions := make([]map[string]string, 0, end-start)
+		for _, name := range group.order[start:end] {
+			point := group.points[name]
+			definitions = append(definitions, map[string]string{
+				"Name": name,
+				"Unit": cloudWatchUnit(point),
+			})
+			if len(point.values) == 1 {
+				doc[name] = point.values[0]
+			} else {
+				doc[name] = point.values
+			}
+		}
+
+		doc["_aws"] = map[string]interface{}{
+			"Timestamp": timestamp,
+			"CloudWatchMetrics": []map[string]interface{}{{
+				"Namespace":  sink.namespace,
+				"Dimensions": [][]string{dimensions},
+				"Metrics":    definitions,
+			}},
+		}
+
+		encoded, err := json.Marshal(doc)
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, encoded)
+	}
+	return docs, nil
+}
+
+func (sink *cloudWatchSink) Flush() error {
+	sink.flushMutex.Lock()
+	defer sink.flushMutex.Unlock()
+
+	sink.mutex.Lock()
+	groups, order := sink.groups, sink.order
+	sink.groups = make(map[string]*cloudWatchGroup, len(groups))
+	sink.order = nil
+	sink.mutex.Unlock()
+
+	if len(order) == 0 {
+		return nil
+	}
+	start := time.Now()
+	defer sink.stats.flushed(start)
+	timestamp := start.UnixNano() / int64(time.Millisecond)
+
+	var firstErr error
+	for _, key := range order {
+		group := groups[key]
+		docs, err := sink.encodeGroup(group, timestamp)
+		if err != nil {
+			sink.stats.serializationError(err)
+			sink.stats.drop(len(group.order))
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		for i, doc := range docs {
+			if _, err := sink.out.Write(append(doc, '\n')); err != nil {
+				sink.stats.writeError()
+				sink.stats.drop(cloudWatchDocMetrics(len(group.order), i))
+				if firstErr == nil {
+					firstErr = err
+				}
+			}
+		}
+	}
+	return firstErr
+}
+
+// cloudWatchDocMetrics returns the number of metrics in the i-th document of a group of n metrics.
+func cloudWatchDocMetrics(n, i int) int {
+	if rest := n - i*cloudWatchMaxMetrics; rest < cloudWatchMaxMetrics {
+		return rest
+	}
+	return cloudWatchMaxMetrics
+}
+
+// SinkStats counts the metrics that could not be encoded or written.
+func (sink *cloudWatchSink) SinkStats() SinkStats {
+	return sink.stats.get()
+}
+
+func (sink *cloudWatchSink) flushLoop() {
+	defer sink.wg.Done()
+	ticker := time.NewTicker(sink.flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-sink.done:
+			return
+		case <-ticker.C:
+			sink.Flush()
+		}
+	}
+}
+
+func (sink *cloudWatchSink) Close() {
+	sink.mutex.Lock()
+	if sink.closed {
+		sink.mutex.Unlock()
+		return
+	}
+	sink.closed = true
+	sink.mutex.Unlock()
+
+	close(sink.done)
+	sink.wg.Wait()
+	sink.Flush()
+}
+
+// NewCloudWatchSink returns a sink that writes metrics to out using the CloudWatch Embedded Metric Format.
+// On Lambda and on Fargate with the awslogs driver, writing to os.Stdout is enough for CloudWatch to extract
+// the metrics. Each metric's tags, merged with the provided default tags, become its dimensions, so a
+// metric cannot be named like one of its dimensions. Counters are summed, gauges keep their last value,
+// and stats are reported as the values observed between flushes, or a uniform sample of 100 of them,
+// as CloudWatch allows no more. Metrics are written every flush interval, and when the sink is closed.
+func NewCloudWatchSink(namespace string, tags map[string]string, out io.Writer, opts ...CloudWatchOption) Sink {
+	sink := &cloudWatchSink{
+		namespace:     namespace,
+		tags:          tags,
+		out:           out,
+		flushInterval: defaultCloudWatchFlushInterval,
+		groups:        make(map[string]*cloudWatchGroup),
+		done:          make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(sink)
+	}
+	if sink.flushInterval > 0 {
+		sink.wg.Add(1)
+		go sink.flushLoop()
+	}
+	return sink
+}
@@ -0,0 +1,183 @@
+package metrics
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func decodeEMF(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	var docs []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var doc map[string]interface{}
+		assert.NoError(t, json.Unmarshal([]byte(line), &doc))
+		docs = append(docs, doc)
+	}
+	return docs
+}
+
+func TestCloudWatchSinkAggregates(t *testing.T) {
+	buf := &bytes.Buffer{}
+	sink := NewCloudWatchSink("my-service", map[string]string{"env": "prod"}, buf)
+
+	sink.Handle("requests", Tags{"route": "a"}, 1, metricTypeCounter)
+	sink.Handle("requests", Tags{"route": "a"}, 2, metricTypeCounter)
+	sink.Handle("queue_depth", Tags{"route": "a"}, 5, metricTypeGauge)
+	sink.Handle("queue_depth", Tags{"route": "a"}, 7, metricTypeGauge)
+	sink.Handle("latency_us", Tags{"route": "a"}, 10, metricTypeStat)
+	sink.Handle("latency_us", Tags{"route": "a"}, 20, metricTypeStat)
+	assert.NoError(t, sink.Flush())
+
+	docs := decodeEMF(t, buf)
+	if !assert.Len(t, docs, 1) {
+		return
+	}
+	doc := docs[0]
+	assert.Equal(t, "prod", doc["env"])
+	assert.Equal(t, "a", doc["route"])
+	assert.Equal(t, 3.0, doc["requests"])
+	assert.Equal(t, 7.0, doc["queue_depth"])
+	assert.Equal(t, []interface{}{10.0, 20.0}, doc["latency_us"])
+
+	directive := doc["_aws"].(map[string]interface{})["CloudWatchMetrics"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "my-service", directive["Namespace"])
+	assert.Equal(t, []interface{}{[]interface{}{"env", "route"}}, directive["Dimensions"])
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"Name": "requests", "Unit": "Count"},
+		map[string]interface{}{"Name": "queue_depth", "Unit": "None"},
+		map[string]interface{}{"Name": "latency_us", "Unit": "Microseconds"},
+	}, directive["Metrics"])
+
+	buf.Reset()
+	assert.NoError(t, sink.Flush())
+	assert.Equal(t, 0, buf.Len())
+}
+
+func TestCloudWatchSinkGroupsByTags(t *testing.T) {
+	buf := &bytes.Buffer{}
+	sink := NewCloudWatchSink("ns", nil, buf)
+
+	sink.Handle("requests", Tags{"route": "a"}, 1, metricTypeCounter)
+	sink.Handle("requests", Tags{"route": "b"}, 1, metricTypeCounter)
+	sink.Handle("requests", nil, 1, metricTypeCounter)
+	sink.Flush()
+
+	docs := decodeEMF(t, buf)
+	assert.Len(t, docs, 3)
+}
+
+func TestCloudWatchSinkSplitsLargeGroups(t *testing.T) {
+	buf := &bytes.Buffer{}
+	sink := NewCloudWatchSink("ns", nil, buf)
+
+	for i := 0; i < cloudWatchMaxMetrics+1; i++ {
+		sink.Handle(fmt.Sprintf("metric_%d", i), nil, 1, metricTypeCounter)
+	}
+	sink.Flush()
+
+	docs := decodeEMF(t, buf)
+	assert.Len(t, docs, 2)
+}
+
+func TestCloudWatchSinkSamplesStats(t *testing.T) {
+	buf := &bytes.Buffer{}
+	sink := NewCloudWatchSink("ns", nil, buf)
+
+	for i := 0; i < 10*cloudWatchMaxValues; i++ {
+		sink.Handle("latency_us", nil, float64(i), metricTypeStat)
+	}
+	assert.NoError(t, sink.Flush())
+
+	docs := decodeEMF(t, buf)
+	if assert.Len(t, docs, 1) {
+		values := docs[0]["latency_us"].([]interface{})
+		assert.Len(t, values, cloudWatchMaxValues)
+		late := 0
+		for _, v := range values {
+			if v.(float64) >= cloudWatchMaxValues {
+				late++
+			}
+		}
+		assert.True(t, late > 0, "the sample includes values observed once it was full")
+	}
+}
+
+// exclusiveWriter fails writes that overlap another one.
+type exclusiveWriter struct {
+	writing int32
+}
+
+func (w *exclusiveWriter) Write(p []byte) (int, error) {
+	if !atomic.CompareAndSwapInt32(&w.writing, 0, 1) {
+		return 0, errors.New("concurrent write")
+	}
+	defer atomic.StoreInt32(&w.writing, 0)
+	time.Sleep(time.Millisecond)
+	return len(p), nil
+}
+
+func TestCloudWatchSinkSerializesFlushes(t *testing.T) {
+	sink := NewCloudWatchSink("ns", nil, &exclusiveWriter{})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				sink.Handle(fmt.Sprintf("metric_%d", i), nil, 1, metricTypeCounter)
+				assert.NoError(t, sink.Flush())
+			}
+		}(i)
+	}
+	wg.Wait()
+	assert.Equal(t, int64(0), SinkStatsOf(sink).WriteErrors)
+}
+
+func TestCloudWatchSinkErrors(t *testing.T) {
+	sink := NewCloudWatchSink("ns", nil, &bytes.Buffer{})
+	assert.Error(t, sink.Handle("", nil, 1, metricTypeCounter))
+
+	tags := make(Tags)
+	for i := 0; i <= cloudWatchMaxDimensions; i++ {
+		tags[fmt.Sprintf("tag_%d", i)] = "v"
+	}
+	assert.Error(t, sink.Handle("metric", tags, 1, metricTypeCounter))
+
+	assert.Error(t, sink.Handle("env", Tags{"env": "prod"}, 1, metricTypeCounter))
+	assert.Error(t, sink.Handle("_aws", nil, 1, metricTypeCounter))
+	assert.Equal(t, int64(4), SinkStatsOf(sink).SerializationErrors)
+
+	sink.Close()
+	assert.Error(t, sink.Handle("metric", nil, 1, metricTypeCounter))
+}
+
+// docWriter sends the documents written to it on a channel.
+type docWriter chan string
+
+func (w docWriter) Write(p []byte) (int, error) {
+	w <- string(p)
+	return len(p), nil
+}
+
+func TestCloudWatchSinkFlushLoop(t *testing.T) {
+	docs := make(docWriter, 10)
+	sink := NewCloudWatchSink("ns", nil, docs, CloudWatchFlushInterval(time.Millisecond))
+	defer sink.Close()
+
+	assert.NoError(t, sink.Handle("requests", nil, 1, metricTypeCounter))
+	select {
+	case doc := <-docs:
+		assert.Contains(t, doc, `"requests":1`)
+	case <-time.After(time.Second):
+		t.Error("metrics were not flushed")
+	}
+}
@@ -0,0 +1,329 @@
+package metrics
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CollectedMetric is a metric received by a Collector.
+type CollectedMetric struct {
+	Name  string
+	Value float64
+	// Type is the statsd type, such as ct, h or g. It is empty for the Graphite protocol.
+	Type string
+	Tags Tags
+	// Timestamp is set by protocols that carry one.
+	Timestamp time.Time
+	// Line is the line as received.
+	Line string
+}
+
+// Collector is an in-process metrics backend listening on the loopback interface, which records what
+// sinks send to it. It is meant for end-to-end tests of what sinks serialize, decoded the way a real
+// backend would.
+type Collector struct {
+	network string
+	addr    string
+	statsd  bool
+	parse   func(line string) (CollectedMetric, error)
+
+	listener   net.Listener
+	packetConn net.PacketConn
+	dir        string
+
+	mutex   sync.Mutex
+	cond    *sync.Cond
+	metrics []CollectedMetric
+	errs    []error
+	conns   map[net.Conn]struct{}
+	closed  bool
+
+	wg sync.WaitGroup
+}
+
+// NewStatsdCollector returns a Collector for the statsd protocol as written by NewStatsdSink, over
+// network: udp, tcp, unix or unixgram. Tags use the DogStatsD syntax.
+func NewStatsdCollector(network string) (*Collector, error) {
+	c, err := newCollector(network, ParseStatsdLine)
+	if err != nil {
+		return nil, err
+	}
+	c.statsd = true
+	return c, nil
+}
+
+// NewGraphiteCollector returns a Collector for the Graphite plaintext protocol over TCP, as written
+// by NewGraphiteSink. Tags written with the Graphite tag syntax are decoded into Tags.
+func NewGraphiteCollector() (*Collector, error) {
+	return newCollector("tcp", ParseGraphiteLine)
+}
+
+func newCollector(network string, parse func(string) (CollectedMetric, error)) (*Collector, error) {
+	c := &Collector{network: network, parse: parse, conns: make(map[net.Conn]struct{})}
+	c.cond = sync.NewCond(&c.mutex)
+
+	var err error
+	switch network {
+	case "udp":
+		c.packetConn, err = net.ListenPacket("udp", "127.0.0.1:0")
+	case "tcp":
+		c.listener, err = net.Listen("tcp", "127.0.0.1:0")
+	case "unix", "unixgram":
+		if c.dir, err = ioutil.TempDir("", "collector"); err != nil {
+			return nil, err
+		}
+		path := filepath.Join(c.dir, "collector.sock")
+		if network == "unix" {
+			c.listener, err = net.Listen("unix", path)
+		} else {
+			c.packetConn, err = net.ListenPacket("unixgram", path)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported collector network %q", network)
+	}
+	if err != nil {
+		os.RemoveAll(c.dir)
+		return nil, err
+	}
+
+	c.wg.Add(1)
+	if c.listener != nil {
+		c.addr = c.listener.Addr().String()
+		go c.accept()
+	} else {
+		c.addr = c.packetConn.LocalAddr().String()
+		go c.readPackets()
+	}
+	return c, nil
+}
+
+// Addr returns the address of the collector. For the statsd protocol, it is in the form expected by
+// NewStatsdSink; for the Graphite protocol, it is host:port.
+func (c *Collector) Addr() string {
+	if c.statsd && c.network != "udp" {
+		return c.network + "://" + c.addr
+	}
+	return c.addr
+}
+
+func (c *Collector) accept() {
+	defer c.wg.Done()
+	for {
+		conn, err := c.listener.Accept()
+		if err != nil {
+			return
+		}
+		c.mutex.Lock()
+		if c.closed {
+			c.mutex.Unlock()
+			conn.Close()
+			return
+		}
+		c.conns[conn] = struct{}{}
+		c.mutex.Unlock()
+		c.wg.Add(1)
+		go func() {
+			defer c.wg.Done()
+			defer func() {
+				c.mutex.Lock()
+				delete(c.conns, conn)
+				c.mutex.Unlock()
+				conn.Close()
+			}()
+			scanner := bufio.NewScanner(conn)
+			for scanner.Scan() {
+				c.record(scanner.Text())
+			}
+		}()
+	}
+}
+
+func (c *Collector) readPackets() {
+	defer c.wg.Done()
+	buf := make([]byte, 65536)
+	for {
+		n, _, err := c.packetConn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		for _, line := range strings.Split(string(buf[:n]), "\n") {
+			c.record(line)
+		}
+	}
+}
+
+func (c *Collector) record(line string) {
+	if line == "" {
+		return
+	}
+	m, err := c.parse(line)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if err != nil {
+		c.errs = append(c.errs, fmt.Errorf("%q: %v", line, err))
+	} else {
+		c.metrics = append(c.metrics, m)
+	}
+	c.cond.Broadcast()
+}
+
+// Metrics returns the metrics received so far, in the order they were received.
+func (c *Collector) Metrics() []CollectedMetric {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return append([]CollectedMetric(nil), c.metrics...)
+}
+
+// Err returns an error describing the lines that could not be decoded, if any.
+func (c *Collector) Err() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if len(c.errs) == 0 {
+		return nil
+	}
+	msgs := make([]string, len(c.errs))
+	for i, err := range c.errs {
+		msgs[i] = err.Error()
+	}
+	return fmt.Errorf("%d malformed lines: %s", len(c.errs), strings.Join(msgs, "; "))
+}
+
+// WaitFor waits until at least n metrics were received, and returns them. It returns an error if the
+// metrics did not arrive within timeout.
+func (c *Collector) WaitFor(n int, timeout time.Duration) ([]CollectedMetric, error) {
+	timer := time.AfterFunc(timeout, func() {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		c.cond.Broadcast()
+	})
+	defer timer.Stop()
+	deadline := time.Now().Add(timeout)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for len(c.metrics) < n && !c.closed && time.Now().Before(deadline) {
+		c.cond.Wait()
+	}
+	metrics := append([]CollectedMetric(nil), c.metrics...)
+	if len(metrics) < n {
+		return metrics, fmt.Errorf("received %d metrics, expected %d", len(metrics), n)
+	}
+	return metrics, nil
+}
+
+// Reset forgets the metrics and errors received so far.
+func (c *Collector) Reset() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.metrics = nil
+	c.errs = nil
+}
+
+// Close stops listening and closes open connections.
+func (c *Collector) Close() error {
+	c.mutex.Lock()
+	c.closed = true
+	c.cond.Broadcast()
+	for conn := range c.conns {
+		conn.Close()
+	}
+	c.mutex.Unlock()
+
+	var err error
+	if c.listener != nil {
+		err = c.listener.Close()
+	} else {
+		err = c.packetConn.Close()
+	}
+	c.wg.Wait()
+	if c.dir != "" {
+		os.RemoveAll(c.dir)
+	}
+	return err
+}
+
+// ParseStatsdLine decodes a statsd line of the form name:value|type, optionally followed by a sample
+// rate (|@0.5), which is ignored, and DogStatsD tags (|#key:value,key2:value2).
+func ParseStatsdLine(line string) (CollectedMetric, error) {
+	m := CollectedMetric{Line: line}
+	colon := strings.LastIndex(strings.SplitN(line, "|", 2)[0], ":")
+	if colon <= 0 {
+		return m, errors.New("missing metric name")
+	}
+	m.Name = line[:colon]
+
+	parts := strings.Split(line[colon+1:], "|")
+	if len(parts) < 2 || parts[1] == "" {
+		return m, errors.New("missing metric type")
+	}
+	value, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil {
+		return m, fmt.Errorf("invalid value: %v", err)
+	}
+	m.Value = value
+	m.Type = parts[1]
+
+	for _, part := range parts[2:] {
+		switch {
+		case strings.HasPrefix(part, "@"):
+		case strings.HasPrefix(part, "#"):
+			m.Tags = make(Tags)
+			for _, tag := range strings.Split(part[1:], ",") {
+				kv := strings.SplitN(tag, ":", 2)
+				if len(kv) == 2 {
+					m.Tags[kv[0]] = kv[1]
+				} else {
+					m.Tags[kv[0]] = ""
+				}
+			}
+		default:
+			return m, fmt.Errorf("unexpected field %q", part)
+		}
+	}
+	return m, nil
+}
+
+// ParseGraphiteLine decodes a Graphite plaintext line of the form path value timestamp, where path
+// may use the Graphite tag syntax (path;key=value;key2=value2).
+func ParseGraphiteLine(line string) (CollectedMetric, error) {
+	m := CollectedMetric{Line: line}
+	fields := strings.Fields(line)
+	if len(fields) != 3 {
+		return m, errors.New("expected path, value and timestamp")
+	}
+
+	path := strings.Split(fields[0], ";")
+	m.Name = path[0]
+	for _, tag := range path[1:] {
+		kv := strings.SplitN(tag, "=", 2)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return m, fmt.Errorf("invalid tag %q", tag)
+		}
+		if m.Tags == nil {
+			m.Tags = make(Tags)
+		}
+		m.Tags[kv[0]] = kv[1]
+	}
+
+	value, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil {
+		return m, fmt.Errorf("invalid value: %v", err)
+	}
+	m.Value = value
+	ts, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return m, fmt.Errorf("invalid timestamp: %v", err)
+	}
+	m.Timestamp = time.Unix(ts, 0)
+	return m, nil
+}
@@ -0,0 +1,83 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseStatsdLine(t *testing.T) {
+	m, err := ParseStatsdLine("api.requests:2|ct|@0.5|#code:200,route:/q")
+	assert.NoError(t, err)
+	assert.Equal(t, "api.requests", m.Name)
+	assert.Equal(t, 2.0, m.Value)
+	assert.Equal(t, "ct", m.Type)
+	assert.Equal(t, Tags{"code": "200", "route": "/q"}, m.Tags)
+
+	for _, line := range []string{"no_value", "name:1", "name:x|g", "name:1|g|bogus"} {
+		_, err := ParseStatsdLine(line)
+		assert.Error(t, err, line)
+	}
+}
+
+func TestParseGraphiteLine(t *testing.T) {
+	m, err := ParseGraphiteLine("app.requests;code=200 2 1500000000")
+	assert.NoError(t, err)
+	assert.Equal(t, "app.requests", m.Name)
+	assert.Equal(t, 2.0, m.Value)
+	assert.Equal(t, Tags{"code": "200"}, m.Tags)
+	assert.Equal(t, time.Unix(1500000000, 0), m.Timestamp)
+
+	_, err = ParseGraphiteLine("app.requests;code= 2 1500000000")
+	assert.Error(t, err)
+}
+
+func TestStatsdCollector(t *testing.T) {
+	for _, network := range []string{"udp", "tcp", "unix", "unixgram"} {
+		c, err := NewStatsdCollector(network)
+		if !assert.NoError(t, err, network) {
+			continue
+		}
+		sink, err := NewStatsdSink(c.Addr())
+		if !assert.NoError(t, err, network) {
+			continue
+		}
+		r := NewReceiver(sink).ScopePrefix("api")
+		r.ScopeTags(Tags{"code": "200"}).Incr("requests")
+		r.SetGauge("inflight", 3)
+		sink.Close()
+
+		metrics, err := c.WaitFor(2, 5*time.Second)
+		assert.NoError(t, err, network)
+		if assert.Len(t, metrics, 2, network) {
+			assert.Equal(t, "api.requests", metrics[0].Name)
+			assert.Equal(t, Tags{"code": "200"}, metrics[0].Tags)
+			assert.Equal(t, "api.inflight", metrics[1].Name)
+			assert.Equal(t, 3.0, metrics[1].Value)
+		}
+		assert.NoError(t, c.Err())
+		c.Close()
+	}
+}
+
+func TestGraphiteCollector(t *testing.T) {
+	c, err := NewGraphiteCollector()
+	assert.NoError(t, err)
+	defer c.Close()
+
+	sink, err := NewGraphiteSink(c.Addr(), "app", GraphiteTagSyntax())
+	assert.NoError(t, err)
+	NewReceiver(sink).ScopeTags(Tags{"code": "200"}).Incr("requests")
+	sink.Close()
+
+	metrics, err := c.WaitFor(1, 5*time.Second)
+	assert.NoError(t, err)
+	if assert.Len(t, metrics, 1) {
+		assert.Equal(t, "app.requests", metrics[0].Name)
+		assert.Equal(t, Tags{"code": "200"}, metrics[0].Tags)
+	}
+
+	_, err = c.WaitFor(2, 10*time.Millisecond)
+	assert.Error(t, err)
+}
@@ -0,0 +1,274 @@
+package metrics
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const defaultConditionWindow = time.Minute
+
+// Condition is a parsed metrics condition that can be evaluated against a WindowSink.
+type Condition struct {
+	expr        string
+	numerator   conditionOperand
+	denominator *conditionOperand
+	window      time.Duration
+	op          string
+	threshold   float64
+}
+
+type conditionOperand struct {
+	fn     string
+	metric string
+}
+
+var conditionFuncs = map[string]bool{
+	"sum":   true,
+	"count": true,
+	"avg":   true,
+	"min":   true,
+	"max":   true,
+	"last":  true,
+	"rate":  true,
+}
+
+var conditionOps = map[string]bool{
+	"<":  true,
+	"<=": true,
+	">":  true,
+	">=": true,
+	"==": true,
+	"!=": true,
+}
+
+// ParseCondition parses a condition over recent metric values. The grammar is:
+//
+//	condition := value [ "over" duration ] op number [ "%" ]
+//	value     := operand [ "/" operand ]
+//	operand   := metric | func "(" metric ")"
+//	func      := sum | count | avg | min | max | last | rate
+//	op        := < | <= | > | >= | == | !=
+//
+// When no function is given, counters are summed, gauges use their last value and
+// stats are averaged. rate is the sum divided by the window in seconds. A ratio with
+// a zero denominator evaluates to 0. The window defaults to one minute, and a
+// trailing % divides the threshold by 100. For example:
+//
+//	my-service.errors / my-service.requests over 1m < 5%
+//	avg(my-service.latency_us) over 30s <= 250000
+func ParseCondition(expr string) (*Condition, error) {
+	tokens, err := tokenizeCondition(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &conditionParser{tokens: tokens}
+	cond := &Condition{expr: expr, window: defaultConditionWindow}
+
+	if cond.numerator, err = p.operand(); err != nil {
+		return nil, err
+	}
+	if p.peek() == "/" {
+		p.next()
+		denominator, err := p.operand()
+		if err != nil {
+			return nil, err
+		}
+		cond.denominator = &denominator
+	}
+	if p.peek() == "over" {
+		p.next()
+		tok := p.next()
+		if cond.window, err = time.ParseDuration(tok); err != nil || cond.window < time.Second {
+			return nil, fmt.Errorf("invalid window %q in %q", tok, expr)
+		}
+	}
+
+	cond.op = p.next()
+	if !conditionOps[cond.op] {
+		return nil, fmt.Errorf("expected comparison operator, got %q in %q", cond.op, expr)
+	}
+
+	tok := p.next()
+	if cond.threshold, err = strconv.ParseFloat(tok, 64); err != nil {
+		return nil, fmt.Errorf("invalid threshold %q in %q", tok, expr)
+	}
+	if p.peek() == "%" {
+		p.next()
+		cond.threshold /= 100
+	}
+	if p.peek() != "" {
+		return nil, fmt.Errorf("unexpected %q in %q", p.peek(), expr)
+	}
+	return cond, nil
+}
+
+// String returns the expression the condition was parsed from.
+func (c *Condition) String() string {
+	return c.expr
+}
+
+// Value computes the left hand side of the condition.
+func (c *Condition) Value(sink *WindowSink) (float64, error) {
+	if int64(c.window/time.Second) > sink.retention {
+		return 0, fmt.Errorf("window %v exceeds retention of %ds", c.window, sink.retention)
+	}
+	num, err := c.numerator.value(sink, c.window)
+	if err != nil {
+		return 0, err
+	}
+	if c.denominator == nil {
+		return num, nil
+	}
+	den, err := c.denominator.value(sink, c.window)
+	if err != nil {
+		return 0, err
+	}
+	if den == 0 {
+		return 0, nil
+	}
+	return num / den, nil
+}
+
+// Eval reports whether the condition currently holds.
+func (c *Condition) Eval(sink *WindowSink) (bool, error) {
+	v, err := c.Value(sink)
+	if err != nil {
+		return false, err
+	}
+	switch c.op {
+	case "<":
+		return v < c.threshold, nil
+	case "<=":
+		return v <= c.threshold, nil
+	case ">":
+		return v > c.threshold, nil
+	case ">=":
+		return v >= c.threshold, nil
+	case "==":
+		return v == c.threshold, nil
+	default:
+		return v != c.threshold, nil
+	}
+}
+
+func (o conditionOperand) value(sink *WindowSink, window time.Duration) (float64, error) {
+	stats, ok := sink.Stats(o.metric, window)
+	if !ok {
+		// a metric that was never reported is treated as zero, so that conditions
+		// such as "errors < 5" hold before the first error happens.
+		return 0, nil
+	}
+
+	fn := o.fn
+	if fn == "" {
+		mt, _ := sink.metricType(o.metric)
+		switch mt {
+		case metricTypeGauge:
+			fn = "last"
+		case metricTypeStat:
+			fn = "avg"
+		default:
+			fn = "sum"
+		}
+	}
+
+	switch fn {
+	case "sum":
+		return stats.Sum, nil
+	case "count":
+		return float64(stats.Count), nil
+	case "avg":
+		if stats.Count == 0 {
+			return 0, nil
+		}
+		return stats.Sum / float64(stats.Count), nil
+	case "min":
+		return stats.Min, nil
+	case "max":
+		return stats.Max, nil
+	case "last":
+		return stats.Last, nil
+	case "rate":
+		return stats.Sum / window.Seconds(), nil
+	default:
+		return 0, fmt.Errorf("unknown function %q", fn)
+	}
+}
+
+type conditionParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *conditionParser) peek() string {
+	if p.pos >= len(p.tokens) {
+		return ""
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *conditionParser) next() string {
+	tok := p.peek()
+	if tok != "" {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *conditionParser) operand() (conditionOperand, error) {
+	tok := p.next()
+	if conditionFuncs[tok] && p.peek() == "(" {
+		p.next()
+		metric := p.next()
+		if !isConditionIdent(metric) || p.next() != ")" {
+			return conditionOperand{}, fmt.Errorf("expected %s(metric)", tok)
+		}
+		return conditionOperand{fn: tok, metric: metric}, nil
+	}
+	if !isConditionIdent(tok) {
+		return conditionOperand{}, fmt.Errorf("expected metric name, got %q", tok)
+	}
+	return conditionOperand{metric: tok}, nil
+}
+
+func isConditionIdent(tok string) bool {
+	return tok != "" && !conditionOps[tok] && strings.IndexAny(tok, "()/%") < 0
+}
+
+func tokenizeCondition(expr string) ([]string, error) {
+	var tokens []string
+	runes := []rune(expr)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case strings.ContainsRune("()/%", r):
+			tokens = append(tokens, string(r))
+			i++
+		case strings.ContainsRune("<>=!", r):
+			if i+1 < len(runes) && runes[i+1] == '=' {
+				tokens = append(tokens, string(runes[i:i+2]))
+				i += 2
+			} else if r == '<' || r == '>' {
+				tokens = append(tokens, string(r))
+				i++
+			} else {
+				return nil, fmt.Errorf("unexpected %q in %q", r, expr)
+			}
+		default:
+			start := i
+			for i < len(runes) && !unicode.IsSpace(runes[i]) && !strings.ContainsRune("()/%<>=!", runes[i]) {
+				i++
+			}
+			tokens = append(tokens, string(runes[start:i]))
+		}
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("empty condition")
+	}
+	return tokens, nil
+}
@@ -0,0 +1,70 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCondition(t *testing.T) {
+	cond, err := ParseCondition("svc.errors / svc.requests over 30s < 5%")
+	if assert.NoError(t, err) {
+		assert.Equal(t, conditionOperand{metric: "svc.errors"}, cond.numerator)
+		assert.Equal(t, &conditionOperand{metric: "svc.requests"}, cond.denominator)
+		assert.Equal(t, 30*time.Second, cond.window)
+		assert.Equal(t, "<", cond.op)
+		assert.Equal(t, 0.05, cond.threshold)
+	}
+
+	cond, err = ParseCondition("max(latency_us)>=100")
+	if assert.NoError(t, err) {
+		assert.Equal(t, conditionOperand{fn: "max", metric: "latency_us"}, cond.numerator)
+		assert.Nil(t, cond.denominator)
+		assert.Equal(t, defaultConditionWindow, cond.window)
+		assert.Equal(t, ">=", cond.op)
+		assert.Equal(t, 100.0, cond.threshold)
+	}
+
+	for _, bad := range []string{
+		"",
+		"errors",
+		"errors <",
+		"errors < five",
+		"errors over forever < 5",
+		"errors < 5 6",
+		"max(errors < 5",
+		"errors = 5",
+		"/ errors < 5",
+	} {
+		_, err := ParseCondition(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestConditionDefaultsByType(t *testing.T) {
+	sink, _ := newTestWindowSink(time.Minute)
+	sink.Handle("requests", nil, 2, metricTypeCounter)
+	sink.Handle("requests", nil, 3, metricTypeCounter)
+	sink.Handle("depth", nil, 2, metricTypeGauge)
+	sink.Handle("depth", nil, 7, metricTypeGauge)
+	sink.Handle("latency", nil, 10, metricTypeStat)
+	sink.Handle("latency", nil, 20, metricTypeStat)
+
+	for expr, expected := range map[string]float64{
+		"requests < 0":           5,
+		"depth < 0":              7,
+		"latency < 0":            15,
+		"count(requests) < 0":    2,
+		"min(latency) < 0":       10,
+		"rate(requests) < 0":     5.0 / 60,
+		"latency / requests < 0": 3,
+	} {
+		cond, err := ParseCondition(expr)
+		if assert.NoError(t, err, expr) {
+			v, err := cond.Value(sink)
+			assert.NoError(t, err)
+			assert.InDelta(t, expected, v, 1e-9, expr)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package metrics
+
+import (
+	"fmt"
+	"path"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DenyListSink drops the metrics whose names match its deny list, or do not match its allow list when
+// it has one. Both lists can be changed while it runs to silence a misbehaving metric without
+// deploying the service again.
+type DenyListSink struct {
+	dst    Sink
+	filter atomic.Value // *metricFilter
+	denied int64        // atomic
+
+	// setMutex serializes the changes of the lists, each of which keeps the other.
+	setMutex sync.Mutex
+}
+
+type metricFilter struct {
+	deny  []string
+	allow []string
+
+	// dropped caches whether metric names are dropped, as there are few distinct names.
+	dropped sync.Map
+}
+
+// NewDenyListSink returns a DenyListSink passing every metric on to dst until SetDenyList or
+// SetAllowList is called.
+func NewDenyListSink(dst Sink) *DenyListSink {
+	sink := &DenyListSink{dst: dst}
+	sink.filter.Store(&metricFilter{})
+	return sink
+}
+
+// SetDenyList replaces the patterns of the names of the metrics dropped. Patterns are matched against
+// full metric names, including the prefixes of scoped receivers, with the syntax of path.Match, where
+// * also matches dots: "myservice.cache.*" drops every metric of the cache scope. The deny list is left
+// unchanged if a pattern is malformed.
+func (sink *DenyListSink) SetDenyList(patterns []string) error {
+	if err := validatePatterns(patterns); err != nil {
+		return err
+	}
+	sink.setMutex.Lock()
+	defer sink.setMutex.Unlock()
+	current := sink.filter.Load().(*metricFilter)
+	sink.filter.Store(&metricFilter{deny: append([]string(nil), patterns...), allow: current.allow})
+	return nil
+}
+
+// SetAllowList replaces the patterns of the names of the metrics let through, which are matched like
+// those of SetDenyList: when the allow list is not empty, only the metrics matching one of its patterns
+// and none of the deny list are let through. An empty allow list lets every metric through. The allow
+// list is left unchanged if a pattern is malformed.
+func (sink *DenyListSink) SetAllowList(patterns []string) error {
+	if err := validatePatterns(patterns); err != nil {
+		return err
+	}
+	sink.setMutex.Lock()
+	defer sink.setMutex.Unlock()
+	current := sink.filter.Load().(*metricFilter)
+	sink.filter.Store(&metricFilter{deny: current.deny, allow: append([]string(nil), patterns...)})
+	return nil
+}
+
+func validatePatterns(patterns []string) error {
+	for _, p := range patterns {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("invalid metric pattern %q: %v", p, err)
+		}
+	}
+	return nil
+}
+
+func (sink *DenyListSink) Handle(metric string, tags Tags, value float64, metricType metricType) error {
+	return sink.HandleAt(metric, tags, value, metricType, time.Time{})
+}
+
+func (sink *DenyListSink) HandleAt(metric string, tags Tags, value float64, metricType metricType, at time.Time) error {
+	if sink.filter.Load().(*metricFilter).drop(metric) {
+		atomic.AddInt64(&sink.denied, 1)
+		return nil
+	}
+	return handleAt(sink.dst, metric, tags, value, metricType, at)
+}
+
+// HandleHistogram passes the histogram on to dst unless its name is dropped.
+func (sink *DenyListSink) HandleHistogram(metric string, tags Tags, h *Histogram) error {
+	if sink.filter.Load().(*metricFilter).drop(metric) {
+		atomic.AddInt64(&sink.denied, 1)
+		return nil
+	}
+	return handleHistogram(sink.dst, metric, tags, h)
+}
+
+func (sink *DenyListSink) handlesHistograms() bool {
+	return HandlesHistograms(sink.dst)
+}
+
+func (sink *DenyListSink) Flush() error {
+	return sink.dst.Flush()
+}
+
+// Denied returns the number of points dropped since the sink was created.
+func (sink *DenyListSink) Denied() int64 {
+	return atomic.LoadInt64(&sink.denied)
+}
+
+// RegisterDenied reports the number of points dropped since the sink was created to r as the gauge
+// name, until unregister is called. It is reported once per interval of the gauges of r rather than
+// once per point, and it is not tagged with the metrics dropped, so that denying a high-volume or
+// high-cardinality metric does not move the load to the gauge.
+func (sink *DenyListSink) RegisterDenied(r Receiver, name string) (unregister func()) {
+	return r.RegisterGauge(name, func() float64 { return float64(sink.Denied()) })
+}
+
+// SinkStats returns the stats of dst: denied metrics are dropped on purpose, and counted by Denied
+// instead.
+func (sink *DenyListSink) SinkStats() SinkStats {
+	return SinkStatsOf(sink.dst)
+}
+
+// Snapshot returns the snapshot of dst.
+func (sink *DenyListSink) Snapshot() Snapshot {
+	return SnapshotOf(sink.dst)
+}
+
+func (sink *DenyListSink) Close() {
+	sink.dst.Close()
+}
+
+func (f *metricFilter) drop(metric string) bool {
+	if len(f.deny) == 0 && len(f.allow) == 0 {
+		return false
+	}
+	if dropped, ok := f.dropped.Load(metric); ok {
+		return dropped.(bool)
+	}
+	dropped := len(f.allow) > 0 && !matchAny(f.allow, metric) || matchAny(f.deny, metric)
+	f.dropped.Store(metric, dropped)
+	return dropped
+}
+
+func matchAny(patterns []string, metric string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, metric); ok {
+			return true
+		}
+	}
+	return false
+}
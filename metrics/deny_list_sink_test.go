@@ -0,0 +1,69 @@
+package metrics
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDenyListSink(t *testing.T) {
+	dst := &MockSink{Invocations: make(map[string]int)}
+	sink := NewDenyListSink(dst)
+	r := NewReceiver(sink).ScopePrefix("service")
+
+	r.Incr("cache.hits")
+	assert.NoError(t, sink.SetDenyList([]string{"service.cache.*"}))
+	r.Incr("cache.hits")
+	r.Incr("requests")
+	assert.Equal(t, 1, dst.Invocations["service.cache.hits, map[], 1, ct\n"])
+	assert.Equal(t, 1, dst.Invocations["service.requests, map[], 1, ct\n"])
+
+	assert.Error(t, sink.SetDenyList([]string{"service.[cache"}))
+	r.Incr("cache.hits")
+	assert.Equal(t, 1, dst.Invocations["service.cache.hits, map[], 1, ct\n"])
+
+	assert.NoError(t, sink.SetDenyList(nil))
+	r.Incr("cache.hits")
+	assert.Equal(t, 2, dst.Invocations["service.cache.hits, map[], 1, ct\n"])
+}
+
+func TestAllowListSink(t *testing.T) {
+	dst := &MockSink{Invocations: make(map[string]int)}
+	sink := NewDenyListSink(dst)
+	r := NewReceiver(sink).ScopePrefix("service")
+
+	assert.NoError(t, sink.SetAllowList([]string{"service.requests*"}))
+	assert.NoError(t, sink.SetDenyList([]string{"service.requests.debug"}))
+	r.Incr("requests")
+	r.Incr("requests.debug")
+	r.Incr("cache.hits")
+	r.Incr("cache.hits")
+	assert.Equal(t, map[string]int{"service.requests, map[], 1, ct\n": 1}, dst.Invocations)
+	assert.Equal(t, int64(3), sink.Denied())
+
+	// each list is kept when the other changes
+	assert.Error(t, sink.SetAllowList([]string{"["}))
+	assert.NoError(t, sink.SetAllowList(nil))
+	r.Incr("cache.hits")
+	r.Incr("requests.debug")
+	assert.Equal(t, 1, dst.Invocations["service.cache.hits, map[], 1, ct\n"])
+	assert.Equal(t, int64(4), sink.Denied())
+}
+
+func TestDenyListSinkRegisterDenied(t *testing.T) {
+	dst := NewMockSink()
+	sink := NewDenyListSink(dst)
+	r := NewReceiver(sink).(*receiver)
+	r.gauges.interval = time.Millisecond
+	defer sink.RegisterDenied(r, "denied")()
+
+	assert.NoError(t, sink.SetDenyList([]string{"cache.*"}))
+	for i := 0; i < 5; i++ {
+		r.ScopeTags(Tags{"key": strconv.Itoa(i)}).Incr("cache.hits")
+	}
+	assert.Eventually(t, func() bool {
+		return invocationCount(dst, "denied, map[], 5, g\n") > 0
+	}, time.Second, time.Millisecond, "the points dropped are reported as an untagged total")
+}
@@ -0,0 +1,59 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/mixpanel/obs/faultinject"
+)
+
+type faultySink struct {
+	dst   Sink
+	inj   *faultinject.Injector
+	stats sinkStats
+}
+
+// NewFaultySink returns a Sink that injects the faults decided by inj into every Handle and Flush
+// before passing the call on to dst. A failed Handle drops the metric. It is meant for tests.
+func NewFaultySink(dst Sink, inj *faultinject.Injector) Sink {
+	if inj == nil {
+		return dst
+	}
+	return &faultySink{dst: dst, inj: inj}
+}
+
+func (sink *faultySink) Handle(metric string, tags Tags, value float64, metricType metricType) error {
+	if err := sink.inj.Inject(); err != nil {
+		sink.stats.drop(1)
+		return err
+	}
+	return sink.dst.Handle(metric, tags, value, metricType)
+}
+
+func (sink *faultySink) HandleAt(metric string, tags Tags, value float64, metricType metricType, at time.Time) error {
+	if err := sink.inj.Inject(); err != nil {
+		sink.stats.drop(1)
+		return err
+	}
+	return handleAt(sink.dst, metric, tags, value, metricType, at)
+}
+
+func (sink *faultySink) Flush() error {
+	if err := sink.inj.Inject(); err != nil {
+		return err
+	}
+	return sink.dst.Flush()
+}
+
+// SinkStats counts the metrics dropped by the faults injected, along with the stats of dst.
+func (sink *faultySink) SinkStats() SinkStats {
+	return sink.stats.get().Add(SinkStatsOf(sink.dst))
+}
+
+// Snapshot returns the snapshot of dst.
+func (sink *faultySink) Snapshot() Snapshot {
+	return SnapshotOf(sink.dst)
+}
+
+func (sink *faultySink) Close() {
+	sink.dst.Close()
+}
@@ -0,0 +1,25 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/mixpanel/obs/faultinject"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFaultySink(t *testing.T) {
+	dst := &MockSink{Invocations: make(map[string]int)}
+	sink := NewFaultySink(dst, faultinject.New(faultinject.Config{ErrorRate: 0.5, Seed: 1}))
+
+	failures := 0
+	for i := 0; i < 100; i++ {
+		if err := sink.Handle("foo", nil, 1, metricTypeCounter); err != nil {
+			assert.Equal(t, faultinject.ErrInjected, err)
+			failures++
+		}
+	}
+	assert.True(t, failures > 0 && failures < 100)
+	assert.Equal(t, 100-failures, dst.Invocations["foo, map[], 1, ct\n"])
+
+	assert.Equal(t, dst, NewFaultySink(dst, nil))
+}
@@ -0,0 +1,94 @@
+package metrics
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/mixpanel/obs/clock"
+)
+
+// GaugeSampleInterval is how often the gauges registered with RegisterGauge are sampled, unless the
+// receiver aggregates metrics, in which case they are sampled right before each flush.
+var GaugeSampleInterval = 10 * time.Second
+
+// gaugeRegistry holds the gauges registered with a receiver and the receivers scoped from it.
+type gaugeRegistry struct {
+	interval time.Duration
+	clock    clock.Clock
+	// flushed is set when sample is called by a sink before each flush, rather than by a ticker.
+	flushed bool
+
+	mutex   sync.Mutex
+	gauges  map[*registeredGauge]struct{}
+	running bool
+}
+
+type registeredGauge struct {
+	r    *receiver
+	name string
+	f    func() float64
+}
+
+func newGaugeRegistry(c clock.Clock) *gaugeRegistry {
+	return &gaugeRegistry{interval: GaugeSampleInterval, clock: c, gauges: make(map[*registeredGauge]struct{})}
+}
+
+// register adds a gauge, and starts sampling if needed.
+func (g *gaugeRegistry) register(gauge *registeredGauge) func() {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.gauges[gauge] = struct{}{}
+	if !g.flushed && !g.running {
+		g.running = true
+		go g.sampleLoop()
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			g.mutex.Lock()
+			defer g.mutex.Unlock()
+			delete(g.gauges, gauge)
+		})
+	}
+}
+
+// sampleLoop samples the gauges every interval, until none is registered.
+func (g *gaugeRegistry) sampleLoop() {
+	ticker := g.clock.NewTicker(g.interval)
+	defer ticker.Stop()
+	for range ticker.C() {
+		g.mutex.Lock()
+		if len(g.gauges) == 0 {
+			g.running = false
+			g.mutex.Unlock()
+			return
+		}
+		g.mutex.Unlock()
+		g.sample()
+	}
+}
+
+// sample reports the current value of every registered gauge.
+func (g *gaugeRegistry) sample() {
+	g.mutex.Lock()
+	gauges := make([]*registeredGauge, 0, len(g.gauges))
+	for gauge := range g.gauges {
+		gauges = append(gauges, gauge)
+	}
+	g.mutex.Unlock()
+
+	for _, gauge := range gauges {
+		gauge.sample()
+	}
+}
+
+func (gauge *registeredGauge) sample() {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("panic while sampling gauge %s: %v", formatName(gauge.r.prefix, gauge.name), r)
+		}
+	}()
+	gauge.r.SetGauge(gauge.name, gauge.f())
+}
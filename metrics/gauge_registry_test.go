@@ -0,0 +1,53 @@
+package metrics
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegisterGauge(t *testing.T) {
+	sink := NewMockSink()
+	r := NewReceiver(sink).(*receiver)
+	r.gauges.interval = time.Millisecond
+
+	var depth int64 = 3
+	unregister := r.ScopePrefix("queue").RegisterGauge("depth", func() float64 {
+		return float64(atomic.LoadInt64(&depth))
+	})
+	defer r.RegisterGauge("broken", func() float64 { panic("oops") })()
+
+	deadline := time.Now().Add(time.Second)
+	for invocationCount(sink, "queue.depth, map[], 3, g\n") == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	assert.NotZero(t, invocationCount(sink, "queue.depth, map[], 3, g\n"))
+
+	unregister()
+	unregister()
+	atomic.StoreInt64(&depth, 4)
+	time.Sleep(20 * time.Millisecond)
+	assert.Zero(t, invocationCount(sink, "queue.depth, map[], 4, g\n"))
+}
+
+func TestRegisterGaugeAggregating(t *testing.T) {
+	dst := NewMockSink()
+	r, stop := NewAggregatingReceiver(dst, AggregationOptions{Interval: time.Hour})
+	size := 1.0
+	r.ScopeTags(Tags{"cache": "users"}).RegisterGauge("size", func() float64 { return size })
+
+	// sampled right before the flush, and not in the meantime
+	size = 2
+	stop()
+	assert.Equal(t, map[string]int{"size, map[cache:users], 2, g\n": 1}, dst.Invocations)
+
+	assert.NotPanics(t, func() { Null.RegisterGauge("size", func() float64 { return 0 })() })
+}
+
+func invocationCount(sink *MockSink, key string) int {
+	sink.mutex.Lock()
+	defer sink.mutex.Unlock()
+	return sink.Invocations[key]
+}
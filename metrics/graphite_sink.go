@@ -0,0 +1,407 @@
+package metrics
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultGraphiteFlushInterval = 5 * time.Second
+	defaultGraphiteMinBackoff    = 100 * time.Millisecond
+	defaultGraphiteMaxBackoff    = 10 * time.Second
+	graphiteDialTimeout          = 5 * time.Second
+	graphiteWriteTimeout         = 5 * time.Second
+)
+
+// GraphiteOption configures a Graphite Sink.
+type GraphiteOption func(*graphiteSink)
+
+// GraphiteTagSyntax writes tags with the Graphite 1.1 tag syntax, as in metric;tag1=value1;tag2=value2,
+// instead of appending them to the path.
+func GraphiteTagSyntax() GraphiteOption {
+	return func(sink *graphiteSink) {
+		sink.tagSyntax = true
+	}
+}
+
+// GraphiteFlushInterval sets how often buffered metrics are sent. Defaults to 5 seconds.
+func GraphiteFlushInterval(d time.Duration) GraphiteOption {
+	return func(sink *graphiteSink) {
+		sink.flushInterval = d
+	}
+}
+
+// GraphiteReconnectBackoff sets the bounds of the exponential backoff between attempts
+// to reconnect to carbon after a write fails.
+func GraphiteReconnectBackoff(min, max time.Duration) GraphiteOption {
+	return func(sink *graphiteSink) {
+		sink.minBackoff = min
+		sink.maxBackoff = max
+	}
+}
+
+// GraphiteTLS connects to carbon over TLS configured with cfg, for instance with RootCAs to trust a
+// private certificate authority, or with Certificates to authenticate with a client certificate for
+// mutual TLS. The server name defaults to the host of the address.
+func GraphiteTLS(cfg *tls.Config) GraphiteOption {
+	return func(sink *graphiteSink) {
+		sink.tlsConfig = cfg
+	}
+}
+
+type graphiteSink struct {
+	prefix        string
+	tagSyntax     bool
+	flushInterval time.Duration
+	now           func() time.Time
+	tlsConfig     *tls.Config
+
+	mutex  sync.Mutex // protects buffer, counters, counterBytes and closed
+	buffer *bytes.Buffer
+	// counters sums the counters until they are flushed, as carbon keeps a single value per path and
+	// timestamp. counterBytes is about the size of their lines, bounded with the buffer.
+	counters     map[graphiteCounterKey]float64
+	counterBytes int
+	closed       bool
+
+	// serializes sends and protects the connection state
+	connMutex  sync.Mutex
+	dial       func() (net.Conn, error)
+	conn       net.Conn // nil while disconnected
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	backoff    time.Duration
+	nextDial   time.Time
+	dialed     bool // set once connected, so that later connections are reconnects
+
+	kick chan struct{}
+	done chan struct{}
+	wg   sync.WaitGroup
+
+	stats sinkStats
+}
+
+type graphiteCounterKey struct {
+	path string
+	// at is the explicit timestamp of the counter in Unix seconds, or 0 if it is stamped when flushed.
+	at int64
+}
+
+// graphiteReplacer replaces the characters that would split a path segment or a tag.
+var graphiteReplacer = strings.NewReplacer(".", "_", "/", "_", " ", "_", ";", "_", "=", "_", "~", "_", "!", "_", "^", "_")
+
+// graphitePath returns the path of metric with tags. Without the tag syntax, tags are appended to the
+// path as key.value segments, sorted by key.
+func (sink *graphiteSink) graphitePath(metric string, tags Tags) string {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	if sink.prefix != "" {
+		b.WriteString(sink.prefix)
+		b.WriteString(".")
+	}
+	b.WriteString(strings.Replace(metric, " ", "_", -1))
+	for _, k := range keys {
+		v := tags[k]
+		if v == "" {
+			// Graphite rejects empty tag values, and an empty path segment is meaningless.
+			continue
+		}
+		if sink.tagSyntax {
+			b.WriteString(";")
+			b.WriteString(graphiteReplacer.Replace(k))
+			b.WriteString("=")
+			b.WriteString(graphiteReplacer.Replace(v))
+		} else {
+			b.WriteString(".")
+			b.WriteString(graphiteReplacer.Replace(k))
+			b.WriteString(".")
+			b.WriteString(graphiteReplacer.Replace(v))
+		}
+	}
+	return b.String()
+}
+
+func (sink *graphiteSink) Handle(metric string, tags Tags, value float64, metricType metricType) error {
+	return sink.handle(metric, tags, value, metricType, time.Time{})
+}
+
+func (sink *graphiteSink) HandleAt(metric string, tags Tags, value float64, metricType metricType, at time.Time) error {
+	return sink.handle(metric, tags, value, metricType, at)
+}
+
+// handle buffers the metric, stamped with at, or now if at is zero. Counters are summed per path and
+// timestamp until flushed, and the counters without an explicit timestamp are stamped when flushed.
+func (sink *graphiteSink) handle(metric string, tags Tags, value float64, metricType metricType, at time.Time) error {
+	if len(metric) == 0 {
+		return sink.stats.serializationError(errors.New("cannot handle empty metric"))
+	}
+	path := sink.graphitePath(metric, tags)
+
+	var line string
+	if metricType != metricTypeCounter {
+		if at.IsZero() {
+			at = sink.now()
+		}
+		// graphite plaintext format: <path> <value> <timestampInEpochSeconds>
+		line = fmt.Sprintf("%s %g %d\n", path, value, at.Unix())
+	}
+
+	sink.mutex.Lock()
+	defer sink.mutex.Unlock()
+
+	if sink.closed {
+		sink.stats.drop(1)
+		return errors.New("sink is closed")
+	}
+	if metricType == metricTypeCounter {
+		key := graphiteCounterKey{path: path}
+		if !at.IsZero() {
+			key.at = at.Unix()
+		}
+		if _, ok := sink.counters[key]; !ok {
+			// the path, the value and the timestamp
+			size := len(path) + 32
+			if sink.buffer.Len()+sink.counterBytes+size > maxPendingBytes {
+				sink.stats.drop(1)
+				return errors.New("graphite buffer is full")
+			}
+			sink.counterBytes += size
+		}
+		sink.counters[key] += value
+		return nil
+	}
+	if sink.buffer.Len()+sink.counterBytes+len(line) > maxPendingBytes {
+		sink.stats.drop(1)
+		return errors.New("graphite buffer is full")
+	}
+	_, _ = sink.buffer.WriteString(line)
+	if sink.buffer.Len() >= batchSizeBytes {
+		select {
+		case sink.kick <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+func (sink *graphiteSink) Flush() error {
+	sink.connMutex.Lock()
+	defer sink.connMutex.Unlock()
+
+	sink.mutex.Lock()
+	data := append([]byte(nil), sink.buffer.Bytes()...)
+	sink.buffer.Reset()
+	data = sink.appendCounters(data)
+	sink.mutex.Unlock()
+
+	if len(data) == 0 {
+		return nil
+	}
+	if sink.conn == nil && !sink.reconnect() {
+		sink.requeue(data)
+		return errors.New("not connected to graphite")
+	}
+	defer sink.stats.flushed(time.Now())
+	n, err := sink.write(data)
+	if err != nil {
+		log.Printf("error while writing to graphite: %v", err)
+		sink.stats.writeError()
+		sink.disconnect()
+		// the lines written are not sent again, except the one the write stopped in
+		sink.requeue(data[bytes.LastIndexByte(data[:n], '\n')+1:])
+		return err
+	}
+	return nil
+}
+
+func (sink *graphiteSink) write(data []byte) (int, error) {
+	if err := sink.conn.SetWriteDeadline(time.Now().Add(graphiteWriteTimeout)); err != nil {
+		return 0, err
+	}
+	return sink.conn.Write(data)
+}
+
+// appendCounters appends the lines of the summed counters to data, sorted, and resets them. It must
+// be called with the mutex held.
+func (sink *graphiteSink) appendCounters(data []byte) []byte {
+	if len(sink.counters) == 0 {
+		return data
+	}
+	keys := make([]graphiteCounterKey, 0, len(sink.counters))
+	for key := range sink.counters {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].path != keys[j].path {
+			return keys[i].path < keys[j].path
+		}
+		return keys[i].at < keys[j].at
+	})
+	now := sink.now().Unix()
+	for _, key := range keys {
+		at := key.at
+		if at == 0 {
+			at = now
+		}
+		data = append(data, fmt.Sprintf("%s %g %d\n", key.path, sink.counters[key], at)...)
+	}
+	sink.counters = make(map[graphiteCounterKey]float64)
+	sink.counterBytes = 0
+	return data
+}
+
+// requeue puts back data that could not be sent ahead of the metrics buffered since, dropping it
+// if the buffer would grow beyond maxPendingBytes.
+func (sink *graphiteSink) requeue(data []byte) {
+	sink.mutex.Lock()
+	defer sink.mutex.Unlock()
+	if len(data)+sink.buffer.Len() > maxPendingBytes {
+		sink.stats.dropLines(data)
+		return
+	}
+	pending := sink.buffer.Bytes()
+	buffer := bytes.NewBuffer(make([]byte, 0, len(data)+len(pending)))
+	buffer.Write(data)
+	buffer.Write(pending)
+	sink.buffer = buffer
+}
+
+// reconnect dials carbon unless the backoff since the last failed attempt has not elapsed yet.
+// It returns whether the sink is connected.
+func (sink *graphiteSink) reconnect() bool {
+	now := time.Now()
+	if now.Before(sink.nextDial) {
+		return false
+	}
+	conn, err := sink.dial()
+	if err != nil {
+		sink.backoff *= 2
+		if sink.backoff < sink.minBackoff {
+			sink.backoff = sink.minBackoff
+		}
+		if sink.backoff > sink.maxBackoff {
+			sink.backoff = sink.maxBackoff
+		}
+		sink.nextDial = now.Add(sink.backoff)
+		return false
+	}
+	if sink.dialed {
+		sink.stats.reconnect()
+	}
+	sink.conn = conn
+	sink.dialed = true
+	sink.backoff = 0
+	return true
+}
+
+func (sink *graphiteSink) disconnect() {
+	if err := sink.conn.Close(); err != nil {
+		log.Printf("error while closing connection to graphite: %v", err)
+	}
+	sink.conn = nil
+}
+
+// SinkStats counts the metrics dropped because the buffer was full, including those kept while
+// carbon was unreachable.
+func (sink *graphiteSink) SinkStats() SinkStats {
+	return sink.stats.get()
+}
+
+func (sink *graphiteSink) flushLoop() {
+	defer sink.wg.Done()
+	ticker := time.NewTicker(sink.flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-sink.done:
+			return
+		case <-ticker.C:
+		case <-sink.kick:
+		}
+		sink.Flush()
+	}
+}
+
+func (sink *graphiteSink) Close() {
+	sink.mutex.Lock()
+	if sink.closed {
+		sink.mutex.Unlock()
+		return
+	}
+	sink.closed = true
+	sink.mutex.Unlock()
+
+	close(sink.done)
+	sink.wg.Wait()
+	sink.Flush()
+
+	sink.connMutex.Lock()
+	defer sink.connMutex.Unlock()
+	if sink.conn != nil {
+		sink.disconnect()
+	}
+}
+
+func newGraphiteSink(dial func() (net.Conn, error), prefix string, opts ...GraphiteOption) Sink {
+	sink := configureGraphiteSink(prefix, opts)
+	sink.dial = dial
+	sink.start()
+	return sink
+}
+
+// configureGraphiteSink returns a sink configured with opts, to be started once it can dial.
+func configureGraphiteSink(prefix string, opts []GraphiteOption) *graphiteSink {
+	sink := &graphiteSink{
+		prefix:        strings.TrimSuffix(prefix, "."),
+		flushInterval: defaultGraphiteFlushInterval,
+		now:           time.Now,
+		buffer:        &bytes.Buffer{},
+		counters:      make(map[graphiteCounterKey]float64),
+		minBackoff:    defaultGraphiteMinBackoff,
+		maxBackoff:    defaultGraphiteMaxBackoff,
+		kick:          make(chan struct{}, 1),
+		done:          make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(sink)
+	}
+	return sink
+}
+
+func (sink *graphiteSink) start() {
+	sink.wg.Add(1)
+	go sink.flushLoop()
+}
+
+// NewGraphiteSink returns a Sink sending metrics to carbon at addr (host:port) with the Graphite
+// plaintext protocol over TCP, or over TLS with GraphiteTLS, under prefix. Metrics are buffered and
+// sent in batches; when a write fails, the sink reconnects with exponential backoff and keeps the
+// unsent metrics, up to a bound. Tags are appended to the path as key.value segments sorted by key,
+// or written with the Graphite tag syntax with GraphiteTagSyntax.
+// Counters are summed per path in every batch, as carbon keeps one value per path and timestamp, and
+// other values are sent as reported; use NewAggregatingReceiver to send one value per interval.
+func NewGraphiteSink(addr, prefix string, opts ...GraphiteOption) (Sink, error) {
+	if addr == "" {
+		return &nullSink{}, nil
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return nil, fmt.Errorf("invalid graphite address %q: %v", addr, err)
+	}
+	sink := configureGraphiteSink(prefix, opts)
+	sink.dial = dialTLS(addr, graphiteDialTimeout, sink.tlsConfig)
+	sink.start()
+	return sink, nil
+}
@@ -0,0 +1,122 @@
+package metrics
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGraphitePath(t *testing.T) {
+	sink := &graphiteSink{prefix: "app"}
+	assert.Equal(t, "app.requests", sink.graphitePath("requests", nil))
+	assert.Equal(t, "app.requests.code.200.route._query_v1", sink.graphitePath("requests", Tags{"route": "/query.v1", "code": "200", "empty": ""}))
+
+	sink.tagSyntax = true
+	assert.Equal(t, "app.requests;code=200;route=a_b", sink.graphitePath("requests", Tags{"route": "a;b", "code": "200"}))
+}
+
+func TestGraphiteSink(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer l.Close()
+
+	sink, err := NewGraphiteSink(l.Addr().String(), "app.", GraphiteFlushInterval(time.Hour))
+	assert.NoError(t, err)
+	sink.(*graphiteSink).now = func() time.Time { return time.Unix(1500000000, 0) }
+	defer sink.Close()
+
+	assert.NoError(t, sink.Handle("requests", Tags{"code": "200"}, 2, metricTypeCounter))
+	assert.NoError(t, sink.Handle("latency", nil, 1.5, metricTypeStat))
+	assert.NoError(t, sink.Handle("requests", Tags{"code": "200"}, 1, metricTypeCounter))
+	NewReceiver(sink).At(time.Unix(1400000000, 0)).SetGauge("backfilled", 3)
+	assert.NoError(t, sink.Flush())
+
+	conn, err := l.Accept()
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(conn)
+	line, _ := r.ReadString('\n')
+	assert.Equal(t, "app.latency 1.5 1500000000\n", line)
+	line, _ = r.ReadString('\n')
+	assert.Equal(t, "app.backfilled 3 1400000000\n", line)
+	// counters are summed, and sent after the other metrics
+	line, _ = r.ReadString('\n')
+	assert.Equal(t, "app.requests.code.200 3 1500000000\n", line)
+}
+
+func TestGraphiteSinkReconnect(t *testing.T) {
+	client, server := net.Pipe()
+	dials := 0
+	sink := newGraphiteSink(func() (net.Conn, error) {
+		dials++
+		if dials == 1 {
+			return nil, errors.New("connection refused")
+		}
+		return client, nil
+	}, "", GraphiteFlushInterval(time.Hour), GraphiteReconnectBackoff(0, 0))
+	defer sink.Close()
+
+	assert.NoError(t, sink.Handle("a", nil, 1, metricTypeCounter))
+	assert.Error(t, sink.Flush())
+
+	// metrics buffered while disconnected are sent once connected
+	assert.NoError(t, sink.Handle("b", nil, 2, metricTypeGauge))
+	go sink.Flush()
+	r := bufio.NewReader(server)
+	line, _ := r.ReadString('\n')
+	assert.Regexp(t, `^a 1 \d+\n$`, line)
+	line, _ = r.ReadString('\n')
+	assert.Regexp(t, `^b 2 \d+\n$`, line)
+	server.Close()
+}
+
+// shortConn writes the first n bytes it is given, then fails.
+type shortConn struct {
+	net.Conn
+	n       int
+	written []byte
+}
+
+func (c *shortConn) SetWriteDeadline(time.Time) error {
+	return nil
+}
+
+func (c *shortConn) Write(b []byte) (int, error) {
+	if len(b) > c.n {
+		c.written = append(c.written, b[:c.n]...)
+		return c.n, errors.New("connection reset")
+	}
+	c.written = append(c.written, b...)
+	return len(b), nil
+}
+
+func (c *shortConn) Close() error {
+	return nil
+}
+
+func TestGraphiteSinkPartialWrite(t *testing.T) {
+	conns := []*shortConn{{n: 20}, {n: 1000}}
+	sink := newGraphiteSink(func() (net.Conn, error) {
+		conn := conns[0]
+		conns = conns[1:]
+		return conn, nil
+	}, "", GraphiteFlushInterval(time.Hour), GraphiteReconnectBackoff(0, 0))
+	sink.(*graphiteSink).now = func() time.Time { return time.Unix(1500000000, 0) }
+	first, second := conns[0], conns[1]
+
+	assert.NoError(t, sink.Handle("a", nil, 1, metricTypeGauge))
+	assert.NoError(t, sink.Handle("b", nil, 2, metricTypeGauge))
+	assert.Error(t, sink.Flush())
+	assert.NoError(t, sink.Flush())
+	sink.Close()
+
+	assert.Equal(t, "a 1 1500000000\nb 2 1", string(first.written))
+	assert.Equal(t, "b 2 1500000000\n", string(second.written), "only the lines not fully written are sent again")
+}
@@ -0,0 +1,96 @@
+package metrics
+
+import (
+	"log"
+	"time"
+)
+
+// Counter is a counter whose full name and tags are resolved when it is created, rather than on every
+// increment as with Receiver.Incr, for hot paths.
+type Counter interface {
+	Incr()
+	IncrBy(amount float64)
+}
+
+// Gauge is a gauge whose full name and tags are resolved when it is created.
+type Gauge interface {
+	Set(value float64)
+}
+
+// Timer records durations like a Stopwatch, with its full name and tags resolved when it is created.
+type Timer interface {
+	// Record records d, in microseconds, as the stat <name>_us, and counts it in the counter
+	// <name>_count.
+	Record(d time.Duration)
+	// Since records the time elapsed since start, as measured by the clock of the receiver.
+	Since(start time.Time)
+}
+
+// handle is a metric of a receiver, resolved once.
+type handle struct {
+	r          *receiver
+	name       string
+	metricType metricType
+}
+
+func (r *receiver) newHandle(name string, tags Tags, metricType metricType) *handle {
+	scoped := r
+	if len(tags) > 0 {
+		scoped = r.ScopeTags(tags).(*receiver)
+	}
+	name = formatName(scoped.prefix, name)
+	return &handle{
+		r:          scoped,
+		name:       name,
+		metricType: metricType,
+	}
+}
+
+func (h *handle) handle(value float64) {
+	if err := handleAt(h.r.sink, h.name, h.r.tags, value, h.metricType, h.r.at); err != nil {
+		log.Printf("error while handling metric type: %s. Error: %v", h.metricType, err)
+	}
+}
+
+func (h *handle) Incr() {
+	h.handle(1)
+}
+
+func (h *handle) IncrBy(amount float64) {
+	h.handle(amount)
+}
+
+func (h *handle) Set(value float64) {
+	h.handle(value)
+}
+
+type timer struct {
+	stat  *handle
+	count *handle
+	clock func(time.Time) time.Duration
+}
+
+func (t *timer) Record(d time.Duration) {
+	t.stat.handle(float64(d / time.Microsecond))
+	t.count.handle(1)
+}
+
+func (t *timer) Since(start time.Time) {
+	t.Record(t.clock(start))
+}
+
+func (r *receiver) Counter(name string, tags Tags) Counter {
+	return r.newHandle(name, tags, metricTypeCounter)
+}
+
+func (r *receiver) Gauge(name string, tags Tags) Gauge {
+	return r.newHandle(name, tags, metricTypeGauge)
+}
+
+func (r *receiver) Timer(name string, tags Tags) Timer {
+	return &timer{
+		stat:  r.newHandle(name+"_us", tags, metricTypeStat),
+		count: r.newHandle(name+"_count", tags, metricTypeCounter),
+		clock: r.clock.Since,
+	}
+}
@@ -0,0 +1,59 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mixpanel/obs/clock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandles(t *testing.T) {
+	sink := NewMockSink()
+	c := clock.NewFake(time.Unix(0, 0))
+	r := NewReceiverWithClock(NewSnapshotSink(sink, 0), c).Scope("api", Tags{"region": "us"})
+
+	requests := r.Counter("requests", Tags{"code": "200"})
+	requests.Incr()
+	requests.IncrBy(2)
+	r.Gauge("depth", nil).Set(4)
+	latency := r.Timer("latency", Tags{"code": "200"})
+	latency.Record(3 * time.Millisecond)
+	start := c.Now()
+	c.Advance(time.Second)
+	latency.Since(start)
+
+	assert.Equal(t, map[string]int{
+		"api.requests, map[code:200 region:us], 1, ct\n":      1,
+		"api.requests, map[code:200 region:us], 2, ct\n":      1,
+		"api.depth, map[region:us], 4, g\n":                   1,
+		"api.latency_us, map[code:200 region:us], 3000, h\n":  1,
+		"api.latency_us, map[code:200 region:us], 1e+06, h\n": 1,
+		"api.latency_count, map[code:200 region:us], 1, ct\n": 2,
+	}, sink.Invocations)
+
+	snap := r.Snapshot()
+	assert.Equal(t, 3.0, snap.Counters[SnapshotKey("api.requests", Tags{"code": "200", "region": "us"})])
+	assert.Equal(t, 4.0, snap.Gauges[SnapshotKey("api.depth", Tags{"region": "us"})])
+	assert.Equal(t, int64(2), snap.Stats[SnapshotKey("api.latency_us", Tags{"code": "200", "region": "us"})].Count)
+
+	r.Counter("never", nil)
+	assert.NotContains(t, r.Snapshot().Counters, "api.never", "handles never used are not in snapshots")
+	assert.NotPanics(t, func() { Null.Counter("requests", Tags{"code": "200"}).Incr() })
+}
+
+func BenchmarkIncr(b *testing.B) {
+	r := NewReceiver(NullSink).Scope("api", Tags{"region": "us"})
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		r.ScopeTags(Tags{"code": "200"}).Incr("requests")
+	}
+}
+
+func BenchmarkCounter(b *testing.B) {
+	c := NewReceiver(NullSink).Scope("api", Tags{"region": "us"}).Counter("requests", Tags{"code": "200"})
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		c.Incr()
+	}
+}
@@ -0,0 +1,408 @@
+package metrics
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// DefaultHistogramScale is the scale of the histograms of NewAggregatingReceiver unless
+// AggregationOptions.HistogramScale is set. Quantiles are estimated within about 1.1% of the actual
+// values.
+const DefaultHistogramScale = 5
+
+// MaxHistogramScale is the finest scale of a Histogram, as in OTLP.
+const MaxHistogramScale = 20
+
+// minHistogramScale is the coarsest scale of a Histogram, at which every finite value fits in a few
+// buckets.
+const minHistogramScale = -10
+
+// MaxHistogramBuckets bounds the buckets of the positive and of the negative values of a Histogram. As
+// OTLP exponential histograms do, a histogram whose values would need more buckets is downscaled until
+// they fit. At DefaultHistogramScale, it spans values 2^64 apart.
+const MaxHistogramBuckets = 2048
+
+// Histogram is a high dynamic range histogram with exponential buckets, laid out as OTLP exponential
+// histograms: at scale s, the bucket of index i holds the values in (base^i, base^(i+1)], where base
+// is 2^(2^-s), so that the relative error of the quantiles it estimates is the same for microseconds
+// and for minutes. Count, sum, min and max are exact. Histograms can be merged, including those of
+// other processes through MarshalBinary, so that quantiles are not averaged across hosts.
+//
+// A Histogram is not safe for concurrent use.
+type Histogram struct {
+	scale     int32
+	count     uint64
+	sum       float64
+	min, max  float64
+	zeroCount uint64
+	positive  histogramBuckets
+	negative  histogramBuckets // by the index of the absolute value
+}
+
+// histogramBuckets are consecutive buckets, the first of which has index offset.
+type histogramBuckets struct {
+	offset int32
+	counts []uint64
+}
+
+// NewHistogram returns an empty histogram of the given scale, between -10 and MaxHistogramScale.
+func NewHistogram(scale int) *Histogram {
+	if scale > MaxHistogramScale {
+		scale = MaxHistogramScale
+	}
+	if scale < minHistogramScale {
+		scale = minHistogramScale
+	}
+	return &Histogram{scale: int32(scale)}
+}
+
+// Record adds value to the histogram. NaN and infinite values are ignored.
+func (h *Histogram) Record(value float64) {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return
+	}
+	if h.count == 0 || value < h.min {
+		h.min = value
+	}
+	if h.count == 0 || value > h.max {
+		h.max = value
+	}
+	h.count++
+	h.sum += value
+	switch {
+	case value > 0:
+		i := h.index(value)
+		h.positive.add(i>>h.fit(&h.positive, i, i), 1)
+	case value < 0:
+		i := h.index(-value)
+		h.negative.add(i>>h.fit(&h.negative, i, i), 1)
+	default:
+		h.zeroCount++
+	}
+}
+
+// index returns the index of the bucket of the positive finite value, which fits in an int32 at
+// every scale up to MaxHistogramScale.
+func (h *Histogram) index(value float64) int32 {
+	return int32(math.Ceil(math.Log2(value)*math.Exp2(float64(h.scale))) - 1)
+}
+
+// fit downscales h until b can hold the buckets of indexes lo to hi, at the current scale, within
+// MaxHistogramBuckets, and returns by how much it was downscaled.
+func (h *Histogram) fit(b *histogramBuckets, lo, hi int32) (diff uint) {
+	for h.scale > minHistogramScale {
+		start, end := lo, hi
+		if len(b.counts) > 0 {
+			if b.offset < start {
+				start = b.offset
+			}
+			if last := b.offset + int32(len(b.counts)) - 1; last > end {
+				end = last
+			}
+		}
+		if int64(end)-int64(start) < MaxHistogramBuckets {
+			break
+		}
+		h.positive.downscale(1)
+		h.negative.downscale(1)
+		h.scale--
+		lo, hi = lo>>1, hi>>1
+		diff++
+	}
+	return diff
+}
+
+func (b *histogramBuckets) add(index int32, n uint64) {
+	switch {
+	case len(b.counts) == 0:
+		b.offset = index
+		b.counts = []uint64{0}
+	case index < b.offset:
+		grown := make([]uint64, int(b.offset-index)+len(b.counts))
+		copy(grown[b.offset-index:], b.counts)
+		b.counts = grown
+		b.offset = index
+	case int(index-b.offset) >= len(b.counts):
+		grown := make([]uint64, int(index-b.offset)+1)
+		copy(grown, b.counts)
+		b.counts = grown
+	}
+	b.counts[index-b.offset] += n
+}
+
+// downscale merges the buckets of b into those of a scale coarser by diff.
+func (b *histogramBuckets) downscale(diff int32) {
+	if diff <= 0 || len(b.counts) == 0 {
+		return
+	}
+	old := *b
+	*b = histogramBuckets{}
+	for i, n := range old.counts {
+		if n > 0 {
+			b.add((old.offset+int32(i))>>uint(diff), n)
+		}
+	}
+}
+
+// Merge adds the values of other to h. If their scales differ, h is downscaled to the coarser one.
+func (h *Histogram) Merge(other *Histogram) {
+	if other == nil || other.count == 0 {
+		return
+	}
+	if other.scale < h.scale {
+		h.positive.downscale(h.scale - other.scale)
+		h.negative.downscale(h.scale - other.scale)
+		h.scale = other.scale
+	}
+	if h.count == 0 || other.min < h.min {
+		h.min = other.min
+	}
+	if h.count == 0 || other.max > h.max {
+		h.max = other.max
+	}
+	h.count += other.count
+	h.sum += other.sum
+	h.zeroCount += other.zeroCount
+	diff := uint(other.scale - h.scale) // other.scale >= h.scale
+	if n := len(other.positive.counts); n > 0 {
+		diff += h.fit(&h.positive, other.positive.offset>>diff, (other.positive.offset+int32(n)-1)>>diff)
+	}
+	if n := len(other.negative.counts); n > 0 {
+		diff += h.fit(&h.negative, other.negative.offset>>diff, (other.negative.offset+int32(n)-1)>>diff)
+	}
+	for i, n := range other.positive.counts {
+		if n > 0 {
+			h.positive.add((other.positive.offset+int32(i))>>diff, n)
+		}
+	}
+	for i, n := range other.negative.counts {
+		if n > 0 {
+			h.negative.add((other.negative.offset+int32(i))>>diff, n)
+		}
+	}
+}
+
+// Count returns the number of values recorded.
+func (h *Histogram) Count() uint64 { return h.count }
+
+// Sum returns the sum of the values recorded.
+func (h *Histogram) Sum() float64 { return h.sum }
+
+// Min returns the smallest value recorded, or 0 if there are none.
+func (h *Histogram) Min() float64 { return h.min }
+
+// Max returns the largest value recorded, or 0 if there are none.
+func (h *Histogram) Max() float64 { return h.max }
+
+// Scale returns the scale of the buckets.
+func (h *Histogram) Scale() int { return int(h.scale) }
+
+// ZeroCount returns the number of values equal to 0.
+func (h *Histogram) ZeroCount() uint64 { return h.zeroCount }
+
+// Positive returns the counts of the buckets of positive values, the first of which has index offset.
+// The returned slice must not be modified.
+func (h *Histogram) Positive() (offset int32, counts []uint64) {
+	return h.positive.offset, h.positive.counts
+}
+
+// Negative is like Positive for the absolute values of negative values.
+func (h *Histogram) Negative() (offset int32, counts []uint64) {
+	return h.negative.offset, h.negative.counts
+}
+
+// Quantile returns an estimate of the nearest-rank quantile q of the values recorded, between 0 and
+// 1: the middle of the bucket holding it, bounded by the min and max. It returns 0 if there are no
+// values.
+func (h *Histogram) Quantile(q float64) float64 {
+	if h.count == 0 {
+		return 0
+	}
+	rank := uint64(q*float64(h.count) + 0.5)
+	if rank < 1 {
+		rank = 1
+	}
+	if rank > h.count {
+		rank = h.count
+	}
+
+	var seen uint64
+	for i := len(h.negative.counts) - 1; i >= 0; i-- {
+		seen += h.negative.counts[i]
+		if seen >= rank {
+			return h.clamp(-h.midpoint(h.negative.offset + int32(i)))
+		}
+	}
+	seen += h.zeroCount
+	if seen >= rank {
+		return h.clamp(0)
+	}
+	for i, n := range h.positive.counts {
+		seen += n
+		if seen >= rank {
+			return h.clamp(h.midpoint(h.positive.offset + int32(i)))
+		}
+	}
+	return h.max
+}
+
+// eachBucket calls f with the middle of every non-empty bucket, bounded by the min and max, and with
+// its count, from the smallest values to the largest.
+func (h *Histogram) eachBucket(f func(value float64, n uint64)) {
+	for i := len(h.negative.counts) - 1; i >= 0; i-- {
+		if n := h.negative.counts[i]; n > 0 {
+			f(h.clamp(-h.midpoint(h.negative.offset+int32(i))), n)
+		}
+	}
+	if h.zeroCount > 0 {
+		f(0, h.zeroCount)
+	}
+	for i, n := range h.positive.counts {
+		if n > 0 {
+			f(h.clamp(h.midpoint(h.positive.offset+int32(i))), n)
+		}
+	}
+}
+
+// midpoint returns the middle of the bucket of index i.
+func (h *Histogram) midpoint(i int32) float64 {
+	base := math.Exp2(math.Exp2(-float64(h.scale)))
+	lower := math.Pow(base, float64(i))
+	return (lower + lower*base) / 2
+}
+
+func (h *Histogram) clamp(value float64) float64 {
+	return math.Max(h.min, math.Min(h.max, value))
+}
+
+const histogramEncodingVersion = 1
+
+var errBadHistogram = errors.New("metrics: malformed histogram encoding")
+
+// MarshalBinary encodes h compactly, for UnmarshalBinary.
+func (h *Histogram) MarshalBinary() ([]byte, error) {
+	buf := make([]byte, 0, 64+len(h.positive.counts)+len(h.negative.counts))
+	buf = append(buf, histogramEncodingVersion)
+	buf = appendVarint(buf, int64(h.scale))
+	buf = appendUvarint(buf, h.count)
+	for _, f := range []float64{h.sum, h.min, h.max} {
+		buf = appendUvarint(buf, math.Float64bits(f))
+	}
+	buf = appendUvarint(buf, h.zeroCount)
+	for _, b := range []histogramBuckets{h.positive, h.negative} {
+		buf = appendVarint(buf, int64(b.offset))
+		buf = appendUvarint(buf, uint64(len(b.counts)))
+		for _, n := range b.counts {
+			buf = appendUvarint(buf, n)
+		}
+	}
+	return buf, nil
+}
+
+// UnmarshalBinary decodes a histogram encoded by MarshalBinary into h.
+func (h *Histogram) UnmarshalBinary(data []byte) error {
+	if len(data) == 0 || data[0] != histogramEncodingVersion {
+		return errBadHistogram
+	}
+	d := histogramDecoder{data: data[1:]}
+	var out Histogram
+	out.scale = int32(d.varint())
+	out.count = d.uvarint()
+	out.sum = math.Float64frombits(d.uvarint())
+	out.min = math.Float64frombits(d.uvarint())
+	out.max = math.Float64frombits(d.uvarint())
+	out.zeroCount = d.uvarint()
+	if out.scale < minHistogramScale || out.scale > MaxHistogramScale {
+		return errBadHistogram
+	}
+	for _, b := range []*histogramBuckets{&out.positive, &out.negative} {
+		b.offset = int32(d.varint())
+		n := d.uvarint()
+		if n > MaxHistogramBuckets || n > uint64(len(d.data)) {
+			return errBadHistogram
+		}
+		if n > 0 {
+			b.counts = make([]uint64, n)
+		}
+		for i := range b.counts {
+			b.counts[i] = d.uvarint()
+		}
+	}
+	if d.err {
+		return errBadHistogram
+	}
+	*h = out
+	return nil
+}
+
+func appendUvarint(buf []byte, v uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	return append(buf, tmp[:binary.PutUvarint(tmp[:], v)]...)
+}
+
+func appendVarint(buf []byte, v int64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	return append(buf, tmp[:binary.PutVarint(tmp[:], v)]...)
+}
+
+type histogramDecoder struct {
+	data []byte
+	err  bool
+}
+
+func (d *histogramDecoder) uvarint() uint64 {
+	v, n := binary.Uvarint(d.data)
+	if n <= 0 {
+		d.err = true
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+func (d *histogramDecoder) varint() int64 {
+	v, n := binary.Varint(d.data)
+	if n <= 0 {
+		d.err = true
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+// HistogramSink is implemented by sinks that record the distribution of stats aggregated by
+// NewAggregatingReceiver as histograms, such as exporters of OTLP exponential histograms. They are
+// handed the histogram of each stat at every flush, instead of its count, min, max, avg and
+// percentiles.
+//
+// The sinks wrapping another sink, such as a DenyListSink, implement HistogramSink too, and pass the
+// histograms on to the sink they wrap if it handles them (see HandlesHistograms).
+type HistogramSink interface {
+	HandleHistogram(metric string, tags Tags, h *Histogram) error
+}
+
+// histogramForwarder is implemented by the sinks wrapping another, which only handle histograms if
+// the sink they wrap does.
+type histogramForwarder interface {
+	handlesHistograms() bool
+}
+
+// HandlesHistograms reports whether sink handles histograms, directly or through the sinks it wraps.
+func HandlesHistograms(sink Sink) bool {
+	if f, ok := sink.(histogramForwarder); ok {
+		return f.handlesHistograms()
+	}
+	_, ok := sink.(HistogramSink)
+	return ok
+}
+
+// handleHistogram passes the histogram on to sink, which must handle histograms.
+func handleHistogram(sink Sink, metric string, tags Tags, h *Histogram) error {
+	hs, ok := sink.(HistogramSink)
+	if !ok {
+		return fmt.Errorf("metrics: %T does not handle histograms", sink)
+	}
+	return hs.HandleHistogram(metric, tags, h)
+}
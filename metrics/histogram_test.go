@@ -0,0 +1,142 @@
+package metrics
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistogram(t *testing.T) {
+	h := NewHistogram(DefaultHistogramScale)
+	assert.Equal(t, 0.0, h.Quantile(0.5))
+
+	rng := rand.New(rand.NewSource(1))
+	values := make([]float64, 10000)
+	for i := range values {
+		// latencies spread over several orders of magnitude
+		values[i] = math.Exp(rng.Float64() * 15)
+		h.Record(values[i])
+	}
+	sort.Float64s(values)
+
+	assert.Equal(t, uint64(len(values)), h.Count())
+	assert.Equal(t, values[0], h.Min())
+	assert.Equal(t, values[len(values)-1], h.Max())
+	for _, q := range []float64{0.01, 0.5, 0.9, 0.99, 0.999} {
+		exact := values[int(q*float64(len(values))+0.5)-1]
+		assert.InEpsilon(t, exact, h.Quantile(q), 0.011, "quantile %v", q)
+	}
+	assert.Equal(t, h.Max(), h.Quantile(1))
+}
+
+func TestHistogramZeroAndNegative(t *testing.T) {
+	h := NewHistogram(3)
+	for _, v := range []float64{-100, -1, 0, 0, 1, 100, math.NaN()} {
+		h.Record(v)
+	}
+	assert.Equal(t, uint64(6), h.Count())
+	assert.Equal(t, uint64(2), h.ZeroCount())
+	assert.Equal(t, -100.0, h.Quantile(0))
+	assert.InEpsilon(t, -1, h.Quantile(0.3), 0.1)
+	assert.Equal(t, 0.0, h.Quantile(0.5))
+	assert.Equal(t, 100.0, h.Quantile(1))
+}
+
+func TestHistogramMerge(t *testing.T) {
+	fine, coarse, all := NewHistogram(8), NewHistogram(4), NewHistogram(4)
+	for i := 1; i <= 1000; i++ {
+		if i%2 == 0 {
+			fine.Record(float64(i))
+		} else {
+			coarse.Record(float64(i))
+		}
+		all.Record(float64(i))
+	}
+
+	fine.Merge(coarse)
+	fine.Merge(nil)
+	assert.Equal(t, 4, fine.Scale(), "merging downscales to the coarser scale")
+	assert.Equal(t, all.Count(), fine.Count())
+	assert.Equal(t, all.Sum(), fine.Sum())
+	assert.Equal(t, 1.0, fine.Min())
+	offset, counts := fine.Positive()
+	allOffset, allCounts := all.Positive()
+	assert.Equal(t, allOffset, offset)
+	assert.Equal(t, allCounts, counts)
+}
+
+func TestHistogramMergeFiner(t *testing.T) {
+	coarse, fine, all := NewHistogram(2), NewHistogram(6), NewHistogram(2)
+	for i := 1; i <= 1000; i++ {
+		if i%2 == 0 {
+			fine.Record(float64(i))
+		} else {
+			coarse.Record(float64(i))
+		}
+		all.Record(float64(i))
+	}
+
+	coarse.Merge(fine)
+	assert.Equal(t, 2, coarse.Scale())
+	offset, counts := coarse.Positive()
+	allOffset, allCounts := all.Positive()
+	assert.Equal(t, allOffset, offset)
+	assert.Equal(t, allCounts, counts)
+}
+
+func TestHistogramBounded(t *testing.T) {
+	h := NewHistogram(DefaultHistogramScale)
+	h.Record(math.Inf(1))
+	h.Record(math.Inf(-1))
+	assert.Equal(t, uint64(0), h.Count())
+
+	// values far apart downscale the histogram rather than allocating a bucket per step between them
+	for _, v := range []float64{1e-300, 1, 1e300, -1e-300, -1e300, math.MaxFloat64, math.SmallestNonzeroFloat64} {
+		h.Record(v)
+	}
+	assert.Equal(t, uint64(7), h.Count())
+	assert.True(t, h.Scale() < DefaultHistogramScale)
+	_, positive := h.Positive()
+	_, negative := h.Negative()
+	assert.True(t, len(positive) <= MaxHistogramBuckets)
+	assert.True(t, len(negative) <= MaxHistogramBuckets)
+	assert.Equal(t, math.MaxFloat64, h.Quantile(1))
+	assert.Equal(t, -1e300, h.Quantile(0))
+
+	other := NewHistogram(MaxHistogramScale)
+	other.Record(1e-200)
+	other.Record(1e200)
+	_, counts := other.Positive()
+	assert.True(t, len(counts) <= MaxHistogramBuckets)
+	h.Merge(other)
+	_, positive = h.Positive()
+	assert.True(t, len(positive) <= MaxHistogramBuckets)
+	assert.Equal(t, uint64(9), h.Count())
+}
+
+func TestHistogramMarshalBinary(t *testing.T) {
+	h := NewHistogram(DefaultHistogramScale)
+	for _, v := range []float64{-3, 0, 0.5, 12, 12, 5000} {
+		h.Record(v)
+	}
+	data, err := h.MarshalBinary()
+	assert.NoError(t, err)
+
+	var decoded Histogram
+	assert.NoError(t, decoded.UnmarshalBinary(data))
+	assert.Equal(t, *h, decoded)
+
+	assert.Error(t, decoded.UnmarshalBinary(nil))
+	assert.Error(t, decoded.UnmarshalBinary(data[:len(data)-1]))
+	assert.Equal(t, *h, decoded, "failed decodes leave the histogram unchanged")
+
+	coarse := Histogram{scale: minHistogramScale - 1}
+	data, _ = coarse.MarshalBinary()
+	assert.Error(t, decoded.UnmarshalBinary(data))
+	wide := Histogram{scale: DefaultHistogramScale, positive: histogramBuckets{counts: make([]uint64, MaxHistogramBuckets+1)}}
+	data, _ = wide.MarshalBinary()
+	assert.Error(t, decoded.UnmarshalBinary(data))
+}
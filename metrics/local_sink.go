@@ -43,11 +43,13 @@ type localSink struct {
 	touched      map[metricKey]int64
 
 	flushLock sync.Mutex
+
+	sinkStats sinkStats
 }
 
 func (sink *localSink) Handle(metric string, tags Tags, value float64, metricType metricType) error {
 	if len(metric) == 0 {
-		return errors.New("cannot handle empty metric")
+		return sink.sinkStats.serializationError(errors.New("cannot handle empty metric"))
 	}
 
 	sink.registerLock.Lock()
@@ -105,7 +107,7 @@ func (sink *localSink) handleLocked(metric string, tags Tags, value float64, met
 			break
 		}
 	default:
-		return fmt.Errorf("unknown metric type: %s", metricType)
+		return sink.sinkStats.serializationError(fmt.Errorf("unknown metric type: %s", metricType))
 	}
 	return nil
 }
@@ -183,6 +185,16 @@ func (sink *localSink) Flush() error {
 	return nil
 }
 
+// SinkStats counts the metrics that could not be recorded, along with the stats of dst.
+func (sink *localSink) SinkStats() SinkStats {
+	return sink.sinkStats.get().Add(SinkStatsOf(sink.dst))
+}
+
+// Snapshot returns the snapshot of dst.
+func (sink *localSink) Snapshot() Snapshot {
+	return SnapshotOf(sink.dst)
+}
+
 func (sink *localSink) Close() {
 	sink.Flush()
 	sink.counters.UnregisterAll()
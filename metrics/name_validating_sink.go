@@ -0,0 +1,133 @@
+package metrics
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultMaxNameLength is the length metric names are truncated to unless NameValidation.MaxLength is
+// set.
+const DefaultMaxNameLength = 200
+
+// NameValidation configures NewNameValidatingSink.
+type NameValidation struct {
+	// MaxLength is the length names are truncated to. Defaults to DefaultMaxNameLength.
+	MaxLength int
+	// BadNameCounter is incremented, with a metric tag holding the normalized name, for every metric
+	// whose name had to be normalized or was dropped.
+	BadNameCounter string
+	// Strict panics on bad names instead of normalizing them, so that tests catch them.
+	Strict bool
+}
+
+type nameValidatingSink struct {
+	dst Sink
+	cfg NameValidation
+
+	// names caches the normalized names, as there are few distinct names.
+	names sync.Map
+}
+
+// NewNameValidatingSink returns a Sink normalizing the names of the metrics passed on to dst, which
+// backends such as statsd would otherwise mangle or reject without any error: names are lowercased,
+// the characters other than letters, digits, '_', '-' and '.' are replaced by '_', empty segments are
+// removed, and names are truncated to the maximum length. Metrics whose name is empty are dropped.
+func NewNameValidatingSink(dst Sink, cfg NameValidation) Sink {
+	if cfg.MaxLength <= 0 {
+		cfg.MaxLength = DefaultMaxNameLength
+	}
+	return &nameValidatingSink{dst: dst, cfg: cfg}
+}
+
+func (sink *nameValidatingSink) Handle(metric string, tags Tags, value float64, metricType metricType) error {
+	return sink.HandleAt(metric, tags, value, metricType, time.Time{})
+}
+
+func (sink *nameValidatingSink) HandleAt(metric string, tags Tags, value float64, metricType metricType, at time.Time) error {
+	name, err := sink.validate(metric)
+	if err != nil || name == "" {
+		return err
+	}
+	return handleAt(sink.dst, name, tags, value, metricType, at)
+}
+
+// HandleHistogram passes the histogram on to dst under its normalized name.
+func (sink *nameValidatingSink) HandleHistogram(metric string, tags Tags, h *Histogram) error {
+	name, err := sink.validate(metric)
+	if err != nil || name == "" {
+		return err
+	}
+	return handleHistogram(sink.dst, name, tags, h)
+}
+
+func (sink *nameValidatingSink) handlesHistograms() bool {
+	return HandlesHistograms(sink.dst)
+}
+
+// validate returns the normalized name of metric, empty if it is dropped, and counts the bad names.
+func (sink *nameValidatingSink) validate(metric string) (string, error) {
+	name := sink.normalize(metric)
+	if name != metric || name == "" {
+		if sink.cfg.Strict {
+			panic(fmt.Sprintf("bad metric name %q, normalized to %q", metric, name))
+		}
+		if sink.cfg.BadNameCounter != "" {
+			if err := sink.dst.Handle(sink.cfg.BadNameCounter, Tags{"metric": name}, 1, metricTypeCounter); err != nil {
+				return "", err
+			}
+		}
+	}
+	return name, nil
+}
+
+func (sink *nameValidatingSink) normalize(metric string) string {
+	if name, ok := sink.names.Load(metric); ok {
+		return name.(string)
+	}
+	name := NormalizeName(metric, sink.cfg.MaxLength)
+	sink.names.Store(metric, name)
+	return name
+}
+
+func (sink *nameValidatingSink) Flush() error {
+	return sink.dst.Flush()
+}
+
+func (sink *nameValidatingSink) SinkStats() SinkStats {
+	return SinkStatsOf(sink.dst)
+}
+
+// Snapshot returns the snapshot of dst.
+func (sink *nameValidatingSink) Snapshot() Snapshot {
+	return SnapshotOf(sink.dst)
+}
+
+func (sink *nameValidatingSink) Close() {
+	sink.dst.Close()
+}
+
+// NormalizeName returns metric normalized as described by NewNameValidatingSink, truncated to
+// maxLength unless it is 0. It returns an empty string if nothing is left of metric.
+func NormalizeName(metric string, maxLength int) string {
+	segments := strings.Split(strings.ToLower(metric), ".")
+	kept := segments[:0]
+	for _, s := range segments {
+		if s != "" {
+			kept = append(kept, strings.Map(normalizeNameRune, s))
+		}
+	}
+	name := strings.Join(kept, ".")
+	if maxLength > 0 && len(name) > maxLength {
+		name = strings.TrimRight(name[:maxLength], ".")
+	}
+	return name
+}
+
+func normalizeNameRune(r rune) rune {
+	if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '_' || r == '-' {
+		return r
+	}
+	return '_'
+}
@@ -0,0 +1,40 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeName(t *testing.T) {
+	assert.Equal(t, "service.cache.hits", NormalizeName("service.cache.hits", 0))
+	assert.Equal(t, "service.cache_hit-rate", NormalizeName("Service.Cache Hit-Rate", 0))
+	assert.Equal(t, "service.user_id_42", NormalizeName(".service..user:id/42.", 0))
+	assert.Equal(t, "a_b", NormalizeName("aéb", 0))
+	assert.Equal(t, "", NormalizeName("..", 0))
+	assert.Equal(t, "abc", NormalizeName("abc.def", 4))
+}
+
+func TestNameValidatingSink(t *testing.T) {
+	dst := NewMockSink()
+	r := NewReceiver(NewNameValidatingSink(dst, NameValidation{BadNameCounter: "bad_metric_name"}))
+
+	r.Incr("requests")
+	r.Incr("Cache Hits")
+	r.Incr("Cache Hits")
+	r.Incr("")
+	r.SetGauge(strings.Repeat("a", 300), 1)
+
+	assert.Equal(t, 1, dst.Invocations["requests, map[], 1, ct\n"])
+	assert.Equal(t, 2, dst.Invocations["cache_hits, map[], 1, ct\n"])
+	assert.Equal(t, 2, dst.Invocations["bad_metric_name, map[metric:cache_hits], 1, ct\n"])
+	assert.Equal(t, 1, dst.Invocations["bad_metric_name, map[metric:], 1, ct\n"])
+	assert.Equal(t, 1, dst.Invocations[strings.Repeat("a", DefaultMaxNameLength)+", map[], 1, g\n"])
+	assert.Equal(t, 1, dst.Invocations["bad_metric_name, map[metric:"+strings.Repeat("a", DefaultMaxNameLength)+"], 1, ct\n"])
+	assert.Len(t, dst.Invocations, 6)
+
+	strict := NewReceiver(NewNameValidatingSink(dst, NameValidation{Strict: true}))
+	assert.NotPanics(t, func() { strict.Incr("requests") })
+	assert.Panics(t, func() { strict.Incr("Requests") })
+}
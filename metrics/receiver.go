@@ -4,6 +4,8 @@ import (
 	"log"
 	"sync"
 	"time"
+
+	"github.com/mixpanel/obs/clock"
 )
 
 // Receiver is the interface to metrics
@@ -20,28 +22,68 @@ type Receiver interface {
 	ScopeTags(tags Tags) Receiver
 	Scope(prefix string, tags Tags) Receiver
 
-	StartStopwatch(name string) Stopwatch
+	// ScopeParts is like ScopePrefix with the parts joined by JoinParts with a '.', instead of
+	// concatenating them by hand.
+	ScopeParts(parts ...string) Receiver
+
+	// Prefix returns the full prefix of the metrics of the receiver, for debugging.
+	Prefix() string
+
+	// StartStopwatch returns a Stopwatch recording the time elapsed until it is stopped, along with a
+	// count, tagged with tags, which are merged when several are given.
+	StartStopwatch(name string, tags ...Tags) Stopwatch
+
+	// Counter, Gauge and Timer return handles on the metric name tagged with tags, whose full name
+	// and tags are resolved once, so that recording them in hot paths skips the work Incr, SetGauge
+	// and stopwatches do on every call.
+	Counter(name string, tags Tags) Counter
+	Gauge(name string, tags Tags) Gauge
+	Timer(name string, tags Tags) Timer
+
+	// RegisterGauge reports the value returned by f as the gauge name every GaugeSampleInterval, or
+	// right before each flush for aggregating receivers, for values such as a queue depth or a cache
+	// size. f must be fast and safe to call from another goroutine. The returned function unregisters
+	// the gauge.
+	RegisterGauge(name string, f func() float64) (unregister func())
+
+	// At returns a Receiver whose metrics are recorded at t instead of now, by the sinks that support
+	// explicit timestamps (see TimestampedSink). Other sinks record them as usual.
+	At(t time.Time) Receiver
+
+	// IsNull reports whether metrics are discarded, so that callers can skip computing them.
+	IsNull() bool
+
+	// Snapshot returns the current values of the metrics recorded by the SnapshotSink that the sink
+	// of the receiver is or wraps, for instance to expose them in an admin endpoint or to assert on
+	// them in tests. It is empty if there is no SnapshotSink (see SnapshotOf).
+	Snapshot() Snapshot
 }
 
 type receiver struct {
-	prefix string
-	tags   Tags
+	prefix  string
+	tags    Tags
+	tagsKey string // tags formatted by FormatTags
+	at      time.Time
 
 	// guards 'scopes'
 	lock   sync.RWMutex
 	scopes map[string]*receiver
 
-	sink Sink
+	sink   Sink
+	gauges *gaugeRegistry
+	clock  clock.Clock
 }
 
 // Null is the no op receiver
 var Null Receiver = &receiver{
 	scopes: make(map[string]*receiver),
 	sink:   NullSink,
+	clock:  clock.Real,
 }
 
 func (r *receiver) handle(name string, value float64, metricType metricType) {
-	if err := r.sink.Handle(formatName(r.prefix, name), r.tags, value, metricType); err != nil {
+	name = formatName(r.prefix, name)
+	if err := handleAt(r.sink, name, r.tags, value, metricType, r.at); err != nil {
 		log.Printf("error while handling metric type: %s. Error: %v", metricType, err)
 	}
 }
@@ -70,6 +112,14 @@ func (r *receiver) ScopePrefix(prefix string) Receiver {
 	return r.Scope(prefix, nil)
 }
 
+func (r *receiver) ScopeParts(parts ...string) Receiver {
+	return r.ScopePrefix(JoinParts(".", parts...))
+}
+
+func (r *receiver) Prefix() string {
+	return r.prefix
+}
+
 func (r *receiver) Scope(prefix string, tags Tags) Receiver {
 	if prefix == "" && tags == nil {
 		return r
@@ -106,31 +156,82 @@ func (r *receiver) Scope(prefix string, tags Tags) Receiver {
 	}
 
 	scoped := &receiver{
-		prefix: newPrefix,
-		tags:   newTags,
-		scopes: make(map[string]*receiver),
-		sink:   r.sink,
+		prefix:  newPrefix,
+		tags:    newTags,
+		tagsKey: FormatTags(newTags),
+		at:      r.at,
+		scopes:  make(map[string]*receiver),
+		sink:    r.sink,
+		gauges:  r.gauges,
+		clock:   r.clock,
 	}
 
 	r.scopes[key] = scoped
 	return scoped
 }
 
-func (r *receiver) StartStopwatch(name string) Stopwatch {
-	return &stopwatch{
+func (r *receiver) At(t time.Time) Receiver {
+	// Timestamped receivers are not cached, since every timestamp is usually only used once.
+	return &receiver{
+		prefix:  r.prefix,
+		tags:    r.tags,
+		tagsKey: r.tagsKey,
+		at:      t,
+		scopes:  make(map[string]*receiver),
+		sink:    r.sink,
+		gauges:  r.gauges,
+		clock:   r.clock,
+	}
+}
+
+func (r *receiver) IsNull() bool {
+	return r.sink == NullSink
+}
+
+func (r *receiver) Snapshot() Snapshot {
+	return SnapshotOf(r.sink)
+}
+
+func (r *receiver) RegisterGauge(name string, f func() float64) func() {
+	if r.IsNull() {
+		return func() {}
+	}
+	return r.gauges.register(&registeredGauge{r: r, name: name, f: f})
+}
+
+func (r *receiver) StartStopwatch(name string, tags ...Tags) Stopwatch {
+	sw := &stopwatch{
 		name:      name,
-		startTime: time.Now(),
+		startTime: r.clock.Now(),
 		receiver:  r,
 	}
+	if len(tags) == 1 {
+		sw.tags = tags[0]
+	} else if len(tags) > 1 {
+		sw.tags = make(Tags)
+		for _, t := range tags {
+			for k, v := range t {
+				sw.tags[k] = v
+			}
+		}
+	}
+	return sw
 }
 
 // NewReceiver returns an implementation
 // of the receiver with the specified sink
 func NewReceiver(sink Sink) Receiver {
+	return NewReceiverWithClock(sink, clock.Real)
+}
+
+// NewReceiverWithClock is like NewReceiver, with stopwatches and registered gauges driven by c.
+func NewReceiverWithClock(sink Sink, c clock.Clock) Receiver {
 	return &receiver{
 		prefix: "",
 		tags:   make(map[string]string),
 		scopes: make(map[string]*receiver),
 		sink:   sink,
+		gauges: newGaugeRegistry(c),
+		clock:  c,
 	}
 }
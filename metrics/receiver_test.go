@@ -9,6 +9,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/mixpanel/obs/clock"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -133,6 +134,38 @@ func TestStopwatch(t *testing.T) {
 	emitted := endpoint.readAll()
 	re := regexp.MustCompile("\\Atest_latency_us:[0-9]+\\.?[0-9]*\\|h\\z")
 	assert.True(t, re.MatchString(emitted))
+	assert.Equal(t, "test_latency_count:1|ct", endpoint.readAll())
+}
+
+func TestStopwatchWithTags(t *testing.T) {
+	sink := NewMockSink()
+	r := NewReceiver(sink)
+
+	sw := r.StartStopwatch("query", Tags{"table": "users"}, Tags{"shard": "1"})
+	sw.StopWithTags(Tags{"outcome": "failure", "shard": "2"})
+	r.StartStopwatch("query").Stop()
+
+	assert.Equal(t, 1, sink.Invocations["query_count, map[outcome:failure shard:2 table:users], 1, ct\n"])
+	assert.Equal(t, 1, sink.Invocations["query_count, map[], 1, ct\n"])
+	stats := 0
+	for k := range sink.Invocations {
+		if strings.HasPrefix(k, "query_us, ") && strings.HasSuffix(k, ", h\n") {
+			stats++
+		}
+	}
+	assert.Equal(t, 2, stats)
+}
+
+func TestStopwatchClock(t *testing.T) {
+	sink := NewMockSink()
+	c := clock.NewFake(time.Unix(0, 0))
+	r := NewReceiverWithClock(sink, c).ScopePrefix("db")
+
+	sw := r.StartStopwatch("query")
+	c.Advance(1500 * time.Microsecond)
+	sw.Stop()
+
+	assert.Equal(t, 1, sink.Invocations["db.query_us, map[], 1500, h\n"])
 }
 
 type testEndpoint struct {
@@ -151,7 +184,8 @@ func (endpoint *testEndpoint) readAll() string {
 func newTestMetrics(t *testing.T) (Receiver, *testEndpoint) {
 	c1, c2 := net.Pipe()
 
-	sink, err := newStatsdSinkFromConn(c1)
+	// every metric is written right away
+	sink, err := newStatsdSinkFromConn(c1, StatsdMaxPacketSize(1))
 	assert.NoError(t, err)
 
 	return NewReceiver(sink), &testEndpoint{c2}
@@ -174,3 +208,50 @@ func parseStatsdTags(line string) Tags {
 	}
 	return tags
 }
+
+func TestIsNull(t *testing.T) {
+	assert.True(t, Null.IsNull())
+	assert.True(t, Null.Scope("prefix", Tags{"k": "v"}).IsNull())
+	assert.False(t, NewReceiver(NewMockSink()).IsNull())
+}
+
+func TestReceiverAt(t *testing.T) {
+	sink := &timestampedSink{MockSink: NewMockSink()}
+	at := time.Unix(1500000000, 0)
+	r := NewReceiver(sink).ScopePrefix("job")
+
+	r.At(at).ScopeTags(Tags{"day": "1"}).Incr("rows")
+	r.Incr("rows")
+
+	assert.Equal(t, []time.Time{at}, sink.times)
+	assert.Equal(t, 1, sink.Invocations["job.rows, map[day:1], 1, ct\n"])
+	assert.Equal(t, 1, sink.Invocations["job.rows, map[], 1, ct\n"])
+
+	// sinks without timestamp support record the metric as usual
+	mock := NewMockSink()
+	NewReceiver(mock).At(at).Incr("rows")
+	assert.Equal(t, 1, mock.Invocations["rows, map[], 1, ct\n"])
+}
+
+type timestampedSink struct {
+	*MockSink
+	times []time.Time
+}
+
+func (sink *timestampedSink) HandleAt(metric string, tags Tags, value float64, metricType metricType, at time.Time) error {
+	sink.times = append(sink.times, at)
+	return sink.Handle(metric, tags, value, metricType)
+}
+
+func TestScopeParts(t *testing.T) {
+	sink := NewMockSink()
+	r := NewReceiver(sink).ScopePrefix("svc")
+	scoped := r.ScopeParts("API", "", "host.example.com", "v1.2")
+	assert.Equal(t, "svc.api.host_example_com.v1_2", scoped.Prefix())
+	scoped.Incr("requests")
+	assert.Equal(t, 1, sink.Invocations["svc.api.host_example_com.v1_2.requests, map[], 1, ct\n"])
+	assert.Equal(t, r, r.ScopeParts(), "no parts is the same receiver")
+
+	assert.Equal(t, "users_get_2xx", JoinParts("_", "users", "GET", "2xx"))
+	assert.Equal(t, "", NewReceiver(sink).Prefix())
+}
@@ -0,0 +1,461 @@
+package metrics
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/snappy"
+)
+
+const (
+	defaultRemoteWriteFlushInterval = 10 * time.Second
+	defaultRemoteWriteMaxRetries    = 3
+	defaultRemoteWriteBackoff       = 500 * time.Millisecond
+	defaultRemoteWriteTimeout       = 10 * time.Second
+	defaultRemoteWriteMaxSeries     = 10000
+	defaultRemoteWriteExpiry        = 30
+)
+
+var errRemoteWriteSeriesLimit = errors.New("too many remote-write series, metric dropped")
+
+// RemoteWriteOption configures a remote-write Sink.
+type RemoteWriteOption func(*remoteWriteSink)
+
+// RemoteWriteHeader sets a header sent with every request, for instance an API key, or X-Scope-OrgID
+// for multi-tenant receivers.
+func RemoteWriteHeader(key, value string) RemoteWriteOption {
+	return func(sink *remoteWriteSink) {
+		sink.headers.Set(key, value)
+	}
+}
+
+// RemoteWriteBasicAuth authenticates every request with HTTP basic authentication.
+func RemoteWriteBasicAuth(username, password string) RemoteWriteOption {
+	return func(sink *remoteWriteSink) {
+		req := http.Request{Header: make(http.Header)}
+		req.SetBasicAuth(username, password)
+		sink.headers.Set("Authorization", req.Header.Get("Authorization"))
+	}
+}
+
+// RemoteWriteBearerToken authenticates every request with a bearer token.
+func RemoteWriteBearerToken(token string) RemoteWriteOption {
+	return func(sink *remoteWriteSink) {
+		sink.headers.Set("Authorization", "Bearer "+token)
+	}
+}
+
+// RemoteWriteTLS sets the TLS configuration of the connections to the receiver, for instance to trust
+// a private certificate authority with RootCAs, or to authenticate with a client certificate with
+// Certificates for mutual TLS. It applies to the client set with RemoteWriteClient, as long as its
+// Transport is nil or an *http.Transport.
+func RemoteWriteTLS(cfg *tls.Config) RemoteWriteOption {
+	return func(sink *remoteWriteSink) {
+		sink.tlsConfig = cfg
+	}
+}
+
+// RemoteWriteLabels adds labels to every series, such as job or instance, which a scraper would
+// otherwise add. Tags of metrics with the same names take precedence.
+func RemoteWriteLabels(labels Tags) RemoteWriteOption {
+	return func(sink *remoteWriteSink) {
+		for k, v := range labels {
+			sink.labels[k] = v
+		}
+	}
+}
+
+// RemoteWriteFlushInterval sets how often series are pushed. Defaults to 10 seconds.
+func RemoteWriteFlushInterval(d time.Duration) RemoteWriteOption {
+	return func(sink *remoteWriteSink) {
+		sink.flushInterval = d
+	}
+}
+
+// RemoteWriteRetries sets how many times a push failing with a 5xx status or a network error is
+// retried, waiting backoff before the first retry and doubling it for each subsequent one.
+// Defaults to 3 retries and 500 milliseconds.
+func RemoteWriteRetries(maxRetries int, backoff time.Duration) RemoteWriteOption {
+	return func(sink *remoteWriteSink) {
+		sink.maxRetries = maxRetries
+		sink.backoff = backoff
+	}
+}
+
+// RemoteWriteClient sets the http.Client used to push. Defaults to a client with a 10 second timeout.
+func RemoteWriteClient(client *http.Client) RemoteWriteOption {
+	return func(sink *remoteWriteSink) {
+		sink.client = client
+	}
+}
+
+// RemoteWriteMaxSeries bounds the number of series kept, so that a metric tagged with unbounded values
+// does not grow the sink without limit. The metrics of new series beyond the limit are dropped and
+// counted as such in SinkStats. Defaults to 10000.
+func RemoteWriteMaxSeries(n int) RemoteWriteOption {
+	return func(sink *remoteWriteSink) {
+		sink.maxSeries = n
+	}
+}
+
+// RemoteWriteExpiry sets after how many flushes without an update a series is forgotten, and stops
+// being pushed. A counter or stat updated again afterwards restarts from zero, which receivers treat
+// as a counter reset. Defaults to 30 flushes, 5 minutes at the default flush interval, after which
+// Prometheus considers series stale anyway.
+func RemoteWriteExpiry(flushes int) RemoteWriteOption {
+	return func(sink *remoteWriteSink) {
+		sink.expiry = flushes
+	}
+}
+
+type remoteWriteLabel struct {
+	name, value string
+}
+
+// remoteWriteSeries is a time series, whose value is cumulative for counters and stats.
+type remoteWriteSeries struct {
+	labels []remoteWriteLabel
+	value  float64
+	// at is the timestamp given to HandleAt, or zero to push the series at the time of the flush.
+	at    time.Time
+	dirty bool
+	// idle is the number of flushes since the series was last updated.
+	idle int
+}
+
+type remoteWriteSink struct {
+	url           string
+	headers       http.Header
+	labels        Tags
+	flushInterval time.Duration
+	maxRetries    int
+	backoff       time.Duration
+	client        *http.Client
+	tlsConfig     *tls.Config
+	maxSeries     int
+	expiry        int
+	now           func() time.Time
+
+	mutex  sync.Mutex // protects series and closed
+	series map[string]*remoteWriteSeries
+	closed bool
+
+	// serializes pushes
+	flushMutex sync.Mutex
+	done       chan struct{}
+	wg         sync.WaitGroup
+
+	stats sinkStats
+}
+
+// NewRemoteWriteSink returns a Sink pushing metrics with the Prometheus remote-write protocol to url,
+// for instance the /api/v1/write endpoint of VictoriaMetrics, Mimir or Thanos, for jobs that cannot
+// be scraped. Series are pushed every flush interval, snappy-compressed, and pushes failing with a
+// 5xx status or a network error are retried.
+//
+// Metric and tag names are converted to valid Prometheus names, replacing invalid characters such as
+// dots with underscores. Counters become cumulative series suffixed with _total, stats become
+// cumulative _sum and _count series, and gauges keep their last value. Use NewAggregatingReceiver to
+// also push percentiles of stats.
+func NewRemoteWriteSink(url string, opts ...RemoteWriteOption) (Sink, error) {
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return nil, fmt.Errorf("invalid remote-write url %q", url)
+	}
+	sink := &remoteWriteSink{
+		url:           url,
+		headers:       make(http.Header),
+		labels:        make(Tags),
+		flushInterval: defaultRemoteWriteFlushInterval,
+		maxRetries:    defaultRemoteWriteMaxRetries,
+		backoff:       defaultRemoteWriteBackoff,
+		client:        &http.Client{Timeout: defaultRemoteWriteTimeout},
+		maxSeries:     defaultRemoteWriteMaxSeries,
+		expiry:        defaultRemoteWriteExpiry,
+		now:           time.Now,
+		series:        make(map[string]*remoteWriteSeries),
+		done:          make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(sink)
+	}
+	if sink.tlsConfig != nil {
+		client, err := withTLS(sink.client, sink.tlsConfig)
+		if err != nil {
+			return nil, err
+		}
+		sink.client = client
+	}
+
+	sink.wg.Add(1)
+	go sink.flushLoop()
+	return sink, nil
+}
+
+func (sink *remoteWriteSink) Handle(metric string, tags Tags, value float64, metricType metricType) error {
+	return sink.HandleAt(metric, tags, value, metricType, time.Time{})
+}
+
+func (sink *remoteWriteSink) HandleAt(metric string, tags Tags, value float64, metricType metricType, at time.Time) error {
+	if len(metric) == 0 {
+		return sink.stats.serializationError(errors.New("cannot handle empty metric"))
+	}
+	name := promName(metric)
+
+	sink.mutex.Lock()
+	defer sink.mutex.Unlock()
+	if sink.closed {
+		sink.stats.drop(1)
+		return errors.New("sink is closed")
+	}
+
+	ok := true
+	switch metricType {
+	case metricTypeCounter:
+		ok = sink.add(strings.TrimSuffix(name, "_total")+"_total", tags, value, at)
+	case metricTypeStat:
+		ok = sink.add(name+"_sum", tags, value, at) && sink.add(name+"_count", tags, 1, at)
+	default:
+		if s := sink.get(name, tags); s != nil {
+			s.value = value
+			s.at = at
+			s.dirty = true
+		} else {
+			ok = false
+		}
+	}
+	if !ok {
+		sink.stats.drop(1)
+		return errRemoteWriteSeriesLimit
+	}
+	return nil
+}
+
+// add adds value to the series of name with tags, and returns false if there are too many series.
+func (sink *remoteWriteSink) add(name string, tags Tags, value float64, at time.Time) bool {
+	s := sink.get(name, tags)
+	if s == nil {
+		return false
+	}
+	s.value += value
+	s.at = at
+	s.dirty = true
+	return true
+}
+
+// get returns the series of name with tags, creating it if needed, or nil if there are already
+// maxSeries series. It must be called with the mutex held.
+func (sink *remoteWriteSink) get(name string, tags Tags) *remoteWriteSeries {
+	key := name + "|" + FormatTags(tags)
+	if s, ok := sink.series[key]; ok {
+		return s
+	}
+	if len(sink.series) >= sink.maxSeries {
+		return nil
+	}
+
+	merged := make(map[string]string, len(sink.labels)+len(tags)+1)
+	for k, v := range sink.labels {
+		merged[promLabelName(k)] = v
+	}
+	for k, v := range tags {
+		merged[promLabelName(k)] = v
+	}
+	merged["__name__"] = name
+
+	s := &remoteWriteSeries{labels: make([]remoteWriteLabel, 0, len(merged))}
+	for k, v := range merged {
+		s.labels = append(s.labels, remoteWriteLabel{k, v})
+	}
+	// receivers require labels sorted by name
+	sort.Slice(s.labels, func(i, j int) bool { return s.labels[i].name < s.labels[j].name })
+	sink.series[key] = s
+	return s
+}
+
+// Flush pushes the series that changed since the last flush, along with the other series without an
+// explicit timestamp, so that receivers see them as continuous, until they expire.
+func (sink *remoteWriteSink) Flush() error {
+	sink.flushMutex.Lock()
+	defer sink.flushMutex.Unlock()
+
+	now := sink.now()
+	sink.mutex.Lock()
+	buf := proto.NewBuffer(nil)
+	n := 0
+	for key, s := range sink.series {
+		if s.dirty {
+			s.idle = 0
+		} else if s.idle++; s.idle >= sink.expiry {
+			delete(sink.series, key)
+			continue
+		}
+		if !s.dirty && !s.at.IsZero() {
+			continue
+		}
+		at := s.at
+		if at.IsZero() {
+			at = now
+		}
+		encodeTimeSeries(buf, s, at)
+		s.dirty = false
+		n++
+	}
+	sink.mutex.Unlock()
+
+	if n == 0 {
+		return nil
+	}
+	defer sink.stats.flushed(time.Now())
+	if err := sink.push(snappy.Encode(nil, buf.Bytes())); err != nil {
+		sink.stats.drop(n)
+		return err
+	}
+	return nil
+}
+
+// SinkStats counts a write error for every failed attempt to push, the series dropped when the
+// retries are exhausted, and the metrics of the series beyond the maximum number of series.
+func (sink *remoteWriteSink) SinkStats() SinkStats {
+	return sink.stats.get()
+}
+
+// push sends a compressed WriteRequest, retrying with exponential backoff on 5xx statuses and network errors.
+func (sink *remoteWriteSink) push(body []byte) error {
+	backoff := sink.backoff
+	for attempt := 0; ; attempt++ {
+		retry, err := sink.send(body)
+		if err == nil {
+			return nil
+		}
+		sink.stats.writeError()
+		if !retry || attempt >= sink.maxRetries {
+			log.Printf("error while pushing metrics to %s: %v", sink.url, err)
+			return err
+		}
+		select {
+		case <-time.After(backoff):
+		case <-sink.done:
+			// Closing: make the remaining attempts without waiting.
+		}
+		backoff *= 2
+	}
+}
+
+// send makes one push, and returns whether it may succeed if retried when it fails.
+func (sink *remoteWriteSink) send(body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, sink.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	for k, v := range sink.headers {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+
+	resp, err := sink.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		io.Copy(ioutil.Discard, resp.Body)
+		return false, nil
+	}
+	message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+	return resp.StatusCode/100 == 5, fmt.Errorf("remote write returned %s: %s", resp.Status, bytes.TrimSpace(message))
+}
+
+func (sink *remoteWriteSink) flushLoop() {
+	defer sink.wg.Done()
+	ticker := time.NewTicker(sink.flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-sink.done:
+			return
+		case <-ticker.C:
+			sink.Flush()
+		}
+	}
+}
+
+func (sink *remoteWriteSink) Close() {
+	sink.mutex.Lock()
+	if sink.closed {
+		sink.mutex.Unlock()
+		return
+	}
+	sink.closed = true
+	sink.mutex.Unlock()
+
+	close(sink.done)
+	sink.wg.Wait()
+	sink.Flush()
+}
+
+// encodeTimeSeries appends to buf a prometheus.WriteRequest field holding s, sampled at at:
+//
+//	message WriteRequest { repeated TimeSeries timeseries = 1; }
+//	message TimeSeries { repeated Label labels = 1; repeated Sample samples = 2; }
+//	message Label { string name = 1; string value = 2; }
+//	message Sample { double value = 1; int64 timestamp = 2; }
+func encodeTimeSeries(buf *proto.Buffer, s *remoteWriteSeries, at time.Time) {
+	series := proto.NewBuffer(nil)
+	field := proto.NewBuffer(nil)
+	for _, l := range s.labels {
+		field.Reset()
+		field.EncodeVarint(1<<3 | proto.WireBytes)
+		field.EncodeStringBytes(l.name)
+		field.EncodeVarint(2<<3 | proto.WireBytes)
+		field.EncodeStringBytes(l.value)
+		series.EncodeVarint(1<<3 | proto.WireBytes)
+		series.EncodeRawBytes(field.Bytes())
+	}
+
+	field.Reset()
+	field.EncodeVarint(1<<3 | proto.WireFixed64)
+	field.EncodeFixed64(math.Float64bits(s.value))
+	field.EncodeVarint(2<<3 | proto.WireVarint)
+	field.EncodeVarint(uint64(at.UnixNano() / int64(time.Millisecond)))
+	series.EncodeVarint(2<<3 | proto.WireBytes)
+	series.EncodeRawBytes(field.Bytes())
+
+	buf.EncodeVarint(1<<3 | proto.WireBytes)
+	buf.EncodeRawBytes(series.Bytes())
+}
+
+// promName replaces the characters not allowed in Prometheus metric names with underscores.
+func promName(name string) string {
+	return promSanitize(name, true)
+}
+
+// promLabelName replaces the characters not allowed in Prometheus label names with underscores.
+func promLabelName(name string) string {
+	return promSanitize(name, false)
+}
+
+func promSanitize(name string, allowColon bool) string {
+	b := []byte(name)
+	for i, c := range b {
+		valid := c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') ||
+			(i > 0 && c >= '0' && c <= '9') || (allowColon && c == ':')
+		if !valid {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
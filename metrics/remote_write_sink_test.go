@@ -0,0 +1,191 @@
+package metrics
+
+import (
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/stretchr/testify/assert"
+)
+
+type decodedSample struct {
+	labels    string
+	value     float64
+	timestamp int64
+}
+
+// decodeWriteRequest decodes a snappy-compressed WriteRequest into one sample per series, with labels
+// formatted as name=value pairs.
+func decodeWriteRequest(t *testing.T, body []byte) []decodedSample {
+	data, err := snappy.Decode(nil, body)
+	if !assert.NoError(t, err) {
+		return nil
+	}
+	var samples []decodedSample
+	req := proto.NewBuffer(data)
+	for {
+		if _, err := req.DecodeVarint(); err != nil {
+			break
+		}
+		series, _ := req.DecodeRawBytes(false)
+		buf := proto.NewBuffer(series)
+		var labels []string
+		var sample decodedSample
+		for {
+			key, err := buf.DecodeVarint()
+			if err != nil {
+				break
+			}
+			field, _ := buf.DecodeRawBytes(false)
+			b := proto.NewBuffer(field)
+			switch key >> 3 {
+			case 1:
+				b.DecodeVarint()
+				name, _ := b.DecodeStringBytes()
+				b.DecodeVarint()
+				value, _ := b.DecodeStringBytes()
+				labels = append(labels, name+"="+value)
+			case 2:
+				b.DecodeVarint()
+				bits, _ := b.DecodeFixed64()
+				sample.value = math.Float64frombits(bits)
+				b.DecodeVarint()
+				ts, _ := b.DecodeVarint()
+				sample.timestamp = int64(ts)
+			}
+		}
+		sample.labels = strings.Join(labels, ",")
+		samples = append(samples, sample)
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i].labels < samples[j].labels })
+	return samples
+}
+
+func TestRemoteWriteSink(t *testing.T) {
+	var mutex sync.Mutex
+	var requests [][]decodedSample
+	failures := 1
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if failures > 0 {
+			failures--
+			http.Error(w, "overloaded", http.StatusServiceUnavailable)
+			return
+		}
+		assert.Equal(t, "snappy", r.Header.Get("Content-Encoding"))
+		assert.Equal(t, "tenant", r.Header.Get("X-Scope-OrgID"))
+		body, _ := ioutil.ReadAll(r.Body)
+		requests = append(requests, decodeWriteRequest(t, body))
+	}))
+	defer server.Close()
+
+	sink, err := NewRemoteWriteSink(server.URL, RemoteWriteFlushInterval(time.Hour), RemoteWriteRetries(1, time.Millisecond),
+		RemoteWriteHeader("X-Scope-OrgID", "tenant"), RemoteWriteLabels(Tags{"job": "backfill"}))
+	assert.NoError(t, err)
+	now := time.Unix(1500000000, 0)
+	sink.(*remoteWriteSink).now = func() time.Time { return now }
+
+	r := NewReceiver(sink).ScopePrefix("api")
+	r.ScopeTags(Tags{"status.code": "200"}).Incr("requests")
+	r.ScopeTags(Tags{"status.code": "200"}).IncrBy("requests", 2)
+	r.AddStat("latency", 3)
+	r.AddStat("latency", 5)
+	r.SetGauge("inflight", 4)
+	r.At(time.Unix(1400000000, 0)).SetGauge("backfilled", 1)
+	assert.NoError(t, sink.Flush())
+
+	now = now.Add(time.Minute)
+	r.ScopeTags(Tags{"status.code": "200"}).Incr("requests")
+	sink.Close()
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if assert.Len(t, requests, 2) {
+		assert.Equal(t, []decodedSample{
+			{"__name__=api_backfilled,job=backfill", 1, 1400000000000},
+			{"__name__=api_inflight,job=backfill", 4, 1500000000000},
+			{"__name__=api_latency_count,job=backfill", 2, 1500000000000},
+			{"__name__=api_latency_sum,job=backfill", 8, 1500000000000},
+			{"__name__=api_requests_total,job=backfill,status_code=200", 3, 1500000000000},
+		}, requests[0])
+		// counters are cumulative, and series with an explicit timestamp are only sent again when updated
+		assert.Equal(t, []decodedSample{
+			{"__name__=api_inflight,job=backfill", 4, 1500000060000},
+			{"__name__=api_latency_count,job=backfill", 2, 1500000060000},
+			{"__name__=api_latency_sum,job=backfill", 8, 1500000060000},
+			{"__name__=api_requests_total,job=backfill,status_code=200", 4, 1500000060000},
+		}, requests[1])
+	}
+}
+
+func TestRemoteWriteSinkClientError(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		http.Error(w, "out of order sample", http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	sink, err := NewRemoteWriteSink(server.URL, RemoteWriteFlushInterval(time.Hour))
+	assert.NoError(t, err)
+	defer sink.Close()
+	sink.Handle("requests", nil, 1, metricTypeCounter)
+	err = sink.Flush()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "out of order sample")
+	}
+	assert.Equal(t, 1, calls)
+
+	_, err = NewRemoteWriteSink("victoria:8428")
+	assert.Error(t, err)
+}
+
+func TestRemoteWriteSinkSeriesBounded(t *testing.T) {
+	var mutex sync.Mutex
+	var requests [][]decodedSample
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		body, _ := ioutil.ReadAll(r.Body)
+		requests = append(requests, decodeWriteRequest(t, body))
+	}))
+	defer server.Close()
+
+	sink, err := NewRemoteWriteSink(server.URL, RemoteWriteFlushInterval(time.Hour), RemoteWriteMaxSeries(2),
+		RemoteWriteExpiry(2))
+	assert.NoError(t, err)
+	defer sink.Close()
+
+	assert.NoError(t, sink.Handle("requests", Tags{"user": "a"}, 1, metricTypeCounter))
+	assert.NoError(t, sink.Handle("requests", Tags{"user": "b"}, 1, metricTypeCounter))
+	assert.Equal(t, errRemoteWriteSeriesLimit, sink.Handle("requests", Tags{"user": "c"}, 1, metricTypeCounter))
+	assert.Equal(t, int64(1), SinkStatsOf(sink).Dropped)
+
+	assert.NoError(t, sink.Flush())
+	assert.NoError(t, sink.Handle("requests", Tags{"user": "a"}, 1, metricTypeCounter))
+	assert.NoError(t, sink.Flush())
+	assert.NoError(t, sink.Flush(), "user b expires after 2 flushes without updates")
+	assert.NoError(t, sink.Handle("requests", Tags{"user": "c"}, 1, metricTypeCounter))
+	assert.NoError(t, sink.Flush())
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if assert.Len(t, requests, 4) {
+		assert.Len(t, requests[0], 2)
+		assert.Len(t, requests[2], 1)
+		assert.Equal(t, "__name__=requests_total,user=a", requests[2][0].labels)
+		// user a has expired too by then
+		if assert.Len(t, requests[3], 1) {
+			assert.Equal(t, "__name__=requests_total,user=c", requests[3][0].labels)
+		}
+	}
+}
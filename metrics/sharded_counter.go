@@ -0,0 +1,81 @@
+package metrics
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+
+	"github.com/mixpanel/obs/util"
+)
+
+// cacheLineSize pads counter shards, so that writers of adjacent shards do not contend on a cache line.
+const cacheLineSize = 64
+
+// counterShards picks the shard of a shardedCounter updated by a goroutine.
+var counterShards = util.NewShards(0)
+
+type counterShard struct {
+	bits uint64 // float64 bits
+	_    [cacheLineSize - 8]byte
+}
+
+// shardedCounter is a counter updated without locking. It starts as a single value, and is split
+// into a shard per CPU the first time concurrent writers collide, so that uncontended counters stay
+// small and contended ones scale with the number of CPUs. Shards are merged when collected.
+type shardedCounter struct {
+	base    uint64 // float64 bits, first for 64-bit alignment
+	touched uint32
+
+	split  sync.Once
+	shards atomic.Value // []counterShard, once split
+}
+
+func (c *shardedCounter) add(value float64) {
+	c.addValue(value)
+	// set after the value is added, so that a collect between the two sees the value and reports it
+	// even though the flag is not set yet. It is only written once per collection, so that the cache
+	// line is not written by every writer.
+	if atomic.LoadUint32(&c.touched) == 0 {
+		atomic.StoreUint32(&c.touched, 1)
+	}
+}
+
+func (c *shardedCounter) addValue(value float64) {
+	if shards, ok := c.shards.Load().([]counterShard); ok {
+		addFloat(&shards[counterShards.Index()].bits, value)
+		return
+	}
+	old := atomic.LoadUint64(&c.base)
+	if atomic.CompareAndSwapUint64(&c.base, old, math.Float64bits(math.Float64frombits(old)+value)) {
+		return
+	}
+
+	c.split.Do(func() {
+		c.shards.Store(make([]counterShard, counterShards.Len()))
+	})
+	shards := c.shards.Load().([]counterShard)
+	addFloat(&shards[counterShards.Index()].bits, value)
+}
+
+// collect returns the sum of the values added since the last collect, and whether any was added. A
+// non-zero sum counts as added even if the flag of the writer that added it is not set yet; the flag
+// then makes the next collect report a zero sum, which is harmless for a counter.
+func (c *shardedCounter) collect() (float64, bool) {
+	touched := atomic.SwapUint32(&c.touched, 0) == 1
+	sum := math.Float64frombits(atomic.SwapUint64(&c.base, 0))
+	if shards, ok := c.shards.Load().([]counterShard); ok {
+		for i := range shards {
+			sum += math.Float64frombits(atomic.SwapUint64(&shards[i].bits, 0))
+		}
+	}
+	return sum, touched || sum != 0
+}
+
+func addFloat(bits *uint64, value float64) {
+	for {
+		old := atomic.LoadUint64(bits)
+		if atomic.CompareAndSwapUint64(bits, old, math.Float64bits(math.Float64frombits(old)+value)) {
+			return
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package metrics
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShardedCounter(t *testing.T) {
+	var c shardedCounter
+	_, touched := c.collect()
+	assert.False(t, touched)
+
+	c.add(1.5)
+	c.add(2)
+	sum, touched := c.collect()
+	assert.Equal(t, 3.5, sum)
+	assert.True(t, touched)
+
+	// collecting resets the counter
+	sum, touched = c.collect()
+	assert.Equal(t, 0.0, sum)
+	assert.False(t, touched)
+
+	// a value collected before its writer sets the flag is still reported
+	c.addValue(2)
+	sum, touched = c.collect()
+	assert.Equal(t, 2.0, sum)
+	assert.True(t, touched)
+}
+
+func TestShardedCounterSplit(t *testing.T) {
+	var c shardedCounter
+	c.add(1)
+	c.split.Do(func() {
+		c.shards.Store(make([]counterShard, counterShards.Len()))
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				c.add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	sum, _ := c.collect()
+	assert.Equal(t, 4001.0, sum)
+}
+
+func BenchmarkShardedCounter(b *testing.B) {
+	var c shardedCounter
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			c.add(1)
+		}
+	})
+}
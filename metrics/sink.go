@@ -1,5 +1,7 @@
 package metrics
 
+import "time"
+
 // Sink is the interface to where the metrics
 // get reported. Sink is the actual output pipe
 // of the metrics reporting.
@@ -10,6 +12,28 @@ type Sink interface {
 	Close()
 }
 
+// TimestampedSink is implemented by sinks that can record metrics at an explicit time instead of
+// the time they are handled, for jobs backfilling historical data.
+type TimestampedSink interface {
+	Sink
+	HandleAt(metric string, tags Tags, value float64, metricType metricType, at time.Time) error
+}
+
+// HealthChecker is implemented by sinks that can tell whether they are able to deliver metrics.
+type HealthChecker interface {
+	// CheckHealth returns an error describing why metrics cannot be delivered, or nil.
+	CheckHealth() error
+}
+
+// handleAt passes the metric on to sink at the given time if it is set and sink supports it,
+// and to Handle otherwise.
+func handleAt(sink Sink, metric string, tags Tags, value float64, metricType metricType, at time.Time) error {
+	if ts, ok := sink.(TimestampedSink); ok && !at.IsZero() {
+		return ts.HandleAt(metric, tags, value, metricType, at)
+	}
+	return sink.Handle(metric, tags, value, metricType)
+}
+
 type nullSink struct{}
 
 func (sink *nullSink) Handle(metric string, tags Tags, value float64, metricType metricType) error {
@@ -0,0 +1,141 @@
+package metrics
+
+import (
+	"bytes"
+	"sync/atomic"
+	"time"
+)
+
+// SinkStats counts the failures of a sink to deliver metrics, which are otherwise invisible: a sink
+// cannot report its own failures through itself reliably.
+type SinkStats struct {
+	// Dropped is the number of metrics that were lost, for instance because they were buffered while
+	// the backend was unreachable for too long, or because the write sending them failed.
+	Dropped int64
+	// SerializationErrors is the number of metrics that could not be encoded, such as metrics without
+	// a name.
+	SerializationErrors int64
+	// WriteErrors is the number of writes to the backend that failed.
+	WriteErrors int64
+	// Reconnects is the number of times the connection to the backend was restored after a failure.
+	Reconnects int64
+	// Flushes is the number of times buffered metrics were sent, and FlushDuration the total time
+	// it took.
+	Flushes       int64
+	FlushDuration time.Duration
+}
+
+// Add returns the sum of s and other.
+func (s SinkStats) Add(other SinkStats) SinkStats {
+	return SinkStats{
+		Dropped:             s.Dropped + other.Dropped,
+		SerializationErrors: s.SerializationErrors + other.SerializationErrors,
+		WriteErrors:         s.WriteErrors + other.WriteErrors,
+		Reconnects:          s.Reconnects + other.Reconnects,
+		Flushes:             s.Flushes + other.Flushes,
+		FlushDuration:       s.FlushDuration + other.FlushDuration,
+	}
+}
+
+// StatsReporter is implemented by sinks that count their failures. The sinks wrapping another sink
+// include the stats of the sink they wrap.
+type StatsReporter interface {
+	SinkStats() SinkStats
+}
+
+// SinkStatsOf returns the stats of sink, or zero stats if it does not count them.
+func SinkStatsOf(sink Sink) SinkStats {
+	if r, ok := sink.(StatsReporter); ok {
+		return r.SinkStats()
+	}
+	return SinkStats{}
+}
+
+// RegisterSinkStats reports the stats of sink to r as gauges, holding the totals since the sink was
+// created, until unregister is called:
+//
+//	sink.dropped               metrics lost
+//	sink.serialization_errors  metrics that could not be encoded
+//	sink.write_errors          writes to the backend that failed
+//	sink.reconnects            connections restored
+//	sink.flushes               flushes sending metrics
+//	sink.flush_duration_us     the mean duration of the flushes
+//
+// Since they are gauges, they are delivered with the other metrics once the sink recovers, and
+// reporting them does not loop when the sink fails.
+func RegisterSinkStats(r Receiver, sink Sink) (unregister func()) {
+	r = r.ScopePrefix("sink")
+	unregisters := []func(){
+		r.RegisterGauge("dropped", func() float64 { return float64(SinkStatsOf(sink).Dropped) }),
+		r.RegisterGauge("serialization_errors", func() float64 { return float64(SinkStatsOf(sink).SerializationErrors) }),
+		r.RegisterGauge("write_errors", func() float64 { return float64(SinkStatsOf(sink).WriteErrors) }),
+		r.RegisterGauge("reconnects", func() float64 { return float64(SinkStatsOf(sink).Reconnects) }),
+		r.RegisterGauge("flushes", func() float64 { return float64(SinkStatsOf(sink).Flushes) }),
+		r.RegisterGauge("flush_duration_us", func() float64 {
+			stats := SinkStatsOf(sink)
+			if stats.Flushes == 0 {
+				return 0
+			}
+			return float64(stats.FlushDuration/time.Microsecond) / float64(stats.Flushes)
+		}),
+	}
+	return func() {
+		for _, unregister := range unregisters {
+			unregister()
+		}
+	}
+}
+
+// sinkStats counts the failures of a sink, safely for concurrent use.
+type sinkStats struct {
+	dropped             int64
+	serializationErrors int64
+	writeErrors         int64
+	reconnects          int64
+	flushes             int64
+	flushNanos          int64
+}
+
+func (s *sinkStats) get() SinkStats {
+	return SinkStats{
+		Dropped:             atomic.LoadInt64(&s.dropped),
+		SerializationErrors: atomic.LoadInt64(&s.serializationErrors),
+		WriteErrors:         atomic.LoadInt64(&s.writeErrors),
+		Reconnects:          atomic.LoadInt64(&s.reconnects),
+		Flushes:             atomic.LoadInt64(&s.flushes),
+		FlushDuration:       time.Duration(atomic.LoadInt64(&s.flushNanos)),
+	}
+}
+
+func (s *sinkStats) drop(n int) {
+	atomic.AddInt64(&s.dropped, int64(n))
+}
+
+// dropLines counts the metrics in data, one per line, as dropped.
+func (s *sinkStats) dropLines(data []byte) {
+	n := bytes.Count(data, []byte("\n"))
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		n++
+	}
+	s.drop(n)
+}
+
+// serializationError counts a metric that could not be encoded, and returns err.
+func (s *sinkStats) serializationError(err error) error {
+	atomic.AddInt64(&s.serializationErrors, 1)
+	return err
+}
+
+func (s *sinkStats) writeError() {
+	atomic.AddInt64(&s.writeErrors, 1)
+}
+
+func (s *sinkStats) reconnect() {
+	atomic.AddInt64(&s.reconnects, 1)
+}
+
+// flushed counts a flush that started at start.
+func (s *sinkStats) flushed(start time.Time) {
+	atomic.AddInt64(&s.flushes, 1)
+	atomic.AddInt64(&s.flushNanos, int64(time.Since(start)))
+}
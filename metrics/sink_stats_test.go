@@ -0,0 +1,64 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mixpanel/obs/faultinject"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSinkStatsOfWrappers(t *testing.T) {
+	assert.Equal(t, SinkStats{}, SinkStatsOf(NullSink))
+
+	dst := &cloudWatchSink{groups: make(map[string]*cloudWatchGroup)}
+	window := NewWindowSink(dst, time.Minute)
+	sink := NewNameValidatingSink(NewCardinalityLimitedSink(NewDenyListSink(window), 10, "limited"), NameValidation{})
+	assert.Error(t, dst.Handle("", nil, 1, metricTypeCounter))
+	assert.Error(t, window.Handle("", nil, 1, metricTypeCounter))
+	assert.Error(t, sink.Handle("tags", manyTags(cloudWatchMaxDimensions+1), 1, metricTypeCounter))
+
+	assert.Equal(t, SinkStats{SerializationErrors: 3}, SinkStatsOf(sink))
+}
+
+func TestFaultySinkStats(t *testing.T) {
+	inj := faultinject.New(faultinject.Config{ErrorRate: 1})
+	sink := NewFaultySink(NewMockSink(), inj)
+	assert.Error(t, sink.Handle("lost", nil, 1, metricTypeCounter))
+	assert.Equal(t, SinkStats{Dropped: 1}, SinkStatsOf(sink))
+}
+
+func TestRegisterSinkStats(t *testing.T) {
+	sink := &wavefrontSink{}
+	sink.stats.drop(3)
+	sink.stats.writeError()
+	sink.stats.flushed(time.Now().Add(-2 * time.Millisecond))
+
+	dst := NewMockSink()
+	r, stop := NewAggregatingReceiver(dst, AggregationOptions{Interval: time.Hour})
+	unregister := RegisterSinkStats(r.ScopePrefix("statsd"), sink)
+	stop()
+	unregister()
+
+	assert.Equal(t, 1, dst.Invocations["statsd.sink.dropped, map[], 3, g\n"])
+	assert.Equal(t, 1, dst.Invocations["statsd.sink.write_errors, map[], 1, g\n"])
+	assert.Equal(t, 1, dst.Invocations["statsd.sink.reconnects, map[], 0, g\n"])
+	assert.Equal(t, 1, dst.Invocations["statsd.sink.flushes, map[], 1, g\n"])
+	assert.Equal(t, 6, dst.NumInvocations())
+}
+
+func TestSinkStatsDropLines(t *testing.T) {
+	var stats sinkStats
+	stats.dropLines([]byte("a:1|c\nb:1|c\n"))
+	stats.dropLines([]byte("c:1|c"))
+	stats.dropLines(nil)
+	assert.Equal(t, SinkStats{Dropped: 3}, stats.get())
+}
+
+func manyTags(n int) Tags {
+	tags := make(Tags, n)
+	for i := 0; i < n; i++ {
+		tags[string(rune('a'+i%26))+string(rune('a'+i/26))] = "v"
+	}
+	return tags
+}
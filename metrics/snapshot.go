@@ -0,0 +1,230 @@
+package metrics
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultSnapshotSeries is the number of series a SnapshotSink records unless told otherwise.
+const DefaultSnapshotSeries = 10000
+
+// Snapshot holds the values of the metrics recorded by a SnapshotSink, keyed as returned by SnapshotKey.
+type Snapshot struct {
+	// Counters holds the totals of the counters.
+	Counters map[string]float64 `json:"counters"`
+	// Gauges holds the last values of the gauges.
+	Gauges map[string]float64 `json:"gauges"`
+	// Stats summarizes the values of the stats, such as those of stopwatches.
+	Stats map[string]StatSnapshot `json:"stats"`
+}
+
+// StatSnapshot summarizes the values recorded for a stat.
+type StatSnapshot struct {
+	Count int64   `json:"count"`
+	Sum   float64 `json:"sum"`
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+}
+
+// Mean returns the mean of the values, or 0 if there are none.
+func (s StatSnapshot) Mean() float64 {
+	if s.Count == 0 {
+		return 0
+	}
+	return s.Sum / float64(s.Count)
+}
+
+// SnapshotKey returns the key of the metric name with tags in a Snapshot: its name followed, if it has
+// tags, by '|' and the tags as formatted by FormatTags.
+func SnapshotKey(name string, tags Tags) string {
+	return snapshotKey(name, FormatTags(tags))
+}
+
+func snapshotKey(name, formattedTags string) string {
+	if formattedTags == "" {
+		return name
+	}
+	return name + "|" + formattedTags
+}
+
+// Snapshotter is implemented by sinks that record snapshots of their metrics. The sinks wrapping
+// another sink return the snapshot of the sink they wrap.
+type Snapshotter interface {
+	Snapshot() Snapshot
+}
+
+// SnapshotOf returns the snapshot of sink, or an empty snapshot if it does not record one.
+func SnapshotOf(sink Sink) Snapshot {
+	if s, ok := sink.(Snapshotter); ok {
+		return s.Snapshot()
+	}
+	return (*snapshotStore)(nil).snapshot()
+}
+
+// SnapshotSink records the values of the metrics it passes on to dst, so that receivers writing to it,
+// directly or through sinks wrapping it, return them from Snapshot. Place it below the sinks dropping
+// or limiting metrics, such as a DenyListSink or a cardinality limited sink, so that it only records
+// the series that are actually reported. It records at most maxSeries series (DefaultSnapshotSeries if
+// maxSeries <= 0): the metrics of newer series are still passed on to dst, but left out of snapshots.
+type SnapshotSink struct {
+	dst   Sink
+	store *snapshotStore
+}
+
+// NewSnapshotSink returns a SnapshotSink recording at most maxSeries series of the metrics passed on
+// to dst.
+func NewSnapshotSink(dst Sink, maxSeries int) *SnapshotSink {
+	if maxSeries <= 0 {
+		maxSeries = DefaultSnapshotSeries
+	}
+	return &SnapshotSink{dst: dst, store: &snapshotStore{maxSeries: int64(maxSeries)}}
+}
+
+func (sink *SnapshotSink) Handle(metric string, tags Tags, value float64, metricType metricType) error {
+	sink.store.record(metricType, snapshotKey(metric, FormatTags(tags)), value)
+	return sink.dst.Handle(metric, tags, value, metricType)
+}
+
+func (sink *SnapshotSink) HandleAt(metric string, tags Tags, value float64, metricType metricType, at time.Time) error {
+	sink.store.record(metricType, snapshotKey(metric, FormatTags(tags)), value)
+	return handleAt(sink.dst, metric, tags, value, metricType, at)
+}
+
+// HandleHistogram records the count, sum, min and max of the histogram as those of a stat, and
+// passes it on to dst.
+func (sink *SnapshotSink) HandleHistogram(metric string, tags Tags, h *Histogram) error {
+	if sv := sink.store.value(metricTypeStat, snapshotKey(metric, FormatTags(tags))); sv != nil {
+		sv.recordHistogram(h)
+	}
+	return handleHistogram(sink.dst, metric, tags, h)
+}
+
+func (sink *SnapshotSink) handlesHistograms() bool {
+	return HandlesHistograms(sink.dst)
+}
+
+func (sink *SnapshotSink) Flush() error {
+	return sink.dst.Flush()
+}
+
+func (sink *SnapshotSink) Close() {
+	sink.dst.Close()
+}
+
+// Snapshot returns the current values of the series recorded. Counters hold their totals since the
+// sink was created.
+func (sink *SnapshotSink) Snapshot() Snapshot {
+	return sink.store.snapshot()
+}
+
+// SinkStats returns the stats of dst.
+func (sink *SnapshotSink) SinkStats() SinkStats {
+	return SinkStatsOf(sink.dst)
+}
+
+// snapshotStore keeps the values of the metrics recorded by a SnapshotSink.
+type snapshotStore struct {
+	// values maps snapshotStoreKeys to *snapshotValues. Metrics are rarely new, so loads seldom
+	// contend.
+	values    sync.Map
+	series    int64 // atomic
+	maxSeries int64
+}
+
+type snapshotStoreKey struct {
+	metricType metricType
+	key        string
+}
+
+type snapshotValue struct {
+	mutex sync.Mutex
+	value float64
+	stat  StatSnapshot
+}
+
+func (s *snapshotStore) record(metricType metricType, key string, value float64) {
+	if sv := s.value(metricType, key); sv != nil {
+		sv.record(metricType, value)
+	}
+}
+
+// value returns the value of the metric of type metricType keyed key, creating it if needed, or nil
+// if the store is full.
+func (s *snapshotStore) value(metricType metricType, key string) *snapshotValue {
+	storeKey := snapshotStoreKey{metricType: metricType, key: key}
+	if v, ok := s.values.Load(storeKey); ok {
+		return v.(*snapshotValue)
+	}
+	if atomic.AddInt64(&s.series, 1) > s.maxSeries {
+		atomic.AddInt64(&s.series, -1)
+		return nil
+	}
+	v, loaded := s.values.LoadOrStore(storeKey, &snapshotValue{})
+	if loaded {
+		atomic.AddInt64(&s.series, -1)
+	}
+	return v.(*snapshotValue)
+}
+
+func (sv *snapshotValue) record(metricType metricType, value float64) {
+	sv.mutex.Lock()
+	defer sv.mutex.Unlock()
+	switch metricType {
+	case metricTypeCounter:
+		sv.value += value
+	case metricTypeGauge:
+		sv.value = value
+	case metricTypeStat:
+		if sv.stat.Count == 0 || value < sv.stat.Min {
+			sv.stat.Min = value
+		}
+		if sv.stat.Count == 0 || value > sv.stat.Max {
+			sv.stat.Max = value
+		}
+		sv.stat.Count++
+		sv.stat.Sum += value
+	}
+}
+
+func (sv *snapshotValue) recordHistogram(h *Histogram) {
+	if h.Count() == 0 {
+		return
+	}
+	sv.mutex.Lock()
+	defer sv.mutex.Unlock()
+	if sv.stat.Count == 0 || h.Min() < sv.stat.Min {
+		sv.stat.Min = h.Min()
+	}
+	if sv.stat.Count == 0 || h.Max() > sv.stat.Max {
+		sv.stat.Max = h.Max()
+	}
+	sv.stat.Count += int64(h.Count())
+	sv.stat.Sum += h.Sum()
+}
+
+func (s *snapshotStore) snapshot() Snapshot {
+	snap := Snapshot{
+		Counters: make(map[string]float64),
+		Gauges:   make(map[string]float64),
+		Stats:    make(map[string]StatSnapshot),
+	}
+	if s == nil {
+		return snap
+	}
+	s.values.Range(func(k, v interface{}) bool {
+		storeKey, sv := k.(snapshotStoreKey), v.(*snapshotValue)
+		sv.mutex.Lock()
+		defer sv.mutex.Unlock()
+		switch storeKey.metricType {
+		case metricTypeCounter:
+			snap.Counters[storeKey.key] = sv.value
+		case metricTypeGauge:
+			snap.Gauges[storeKey.key] = sv.value
+		case metricTypeStat:
+			snap.Stats[storeKey.key] = sv.stat
+		}
+		return true
+	})
+	return snap
+}
@@ -0,0 +1,57 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mixpanel/obs/clock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshot(t *testing.T) {
+	c := clock.NewFake(time.Unix(0, 0))
+	root := NewReceiverWithClock(NewSnapshotSink(NewMockSink(), 0), c)
+	r := root.ScopePrefix("svc")
+
+	r.Incr("requests")
+	r.IncrBy("requests", 2)
+	r.ScopeTags(Tags{"code": "ok"}).Incr("responses")
+	r.SetGauge("depth", 3)
+	r.SetGauge("depth", 5)
+	r.AddStat("size", 10)
+	r.AddStat("size", 30)
+	sw := r.StartStopwatch("call")
+	c.Advance(2 * time.Millisecond)
+	sw.Stop()
+
+	snap := r.Snapshot()
+	assert.Equal(t, snap, root.Snapshot(), "scopes share the values of their root")
+	assert.Equal(t, 3.0, snap.Counters["svc.requests"])
+	assert.Equal(t, 1.0, snap.Counters[SnapshotKey("svc.responses", Tags{"code": "ok"})])
+	assert.Equal(t, 1.0, snap.Counters["svc.call_count"])
+	assert.Equal(t, 5.0, snap.Gauges["svc.depth"])
+	assert.Equal(t, StatSnapshot{Count: 2, Sum: 40, Min: 10, Max: 30}, snap.Stats["svc.size"])
+	assert.Equal(t, 20.0, snap.Stats["svc.size"].Mean())
+	assert.Equal(t, 2000.0, snap.Stats["svc.call_us"].Max)
+
+	assert.Equal(t, "svc.responses|code:ok,", SnapshotKey("svc.responses", Tags{"code": "ok"}))
+	assert.Equal(t, Snapshot{Counters: map[string]float64{}, Gauges: map[string]float64{}, Stats: map[string]StatSnapshot{}},
+		Null.Snapshot())
+}
+
+func TestSnapshotSinkBounded(t *testing.T) {
+	mock := NewMockSink()
+	r := NewReceiver(NewCardinalityLimitedSink(NewSnapshotSink(mock, 2), 2, "limited"))
+
+	for _, user := range []string{"a", "b", "c"} {
+		r.ScopeTags(Tags{"user": user}).Incr("logins")
+	}
+	counters := r.Snapshot().Counters
+	assert.Equal(t, 1.0, counters[SnapshotKey("logins", Tags{"user": "a"})])
+	assert.Equal(t, 1.0, counters[SnapshotKey("logins", Tags{"user": "b"})])
+	assert.Len(t, counters, 2, "series beyond the limit are left out")
+	assert.Equal(t, 1, mock.Invocations["logins, map[user:"+OverflowTagValue+"], 1, ct\n"],
+		"metrics beyond the limit are still passed on")
+
+	assert.Empty(t, NewReceiver(NewMockSink()).Snapshot().Counters, "receivers without a SnapshotSink record nothing")
+}
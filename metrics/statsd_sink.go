@@ -6,7 +6,9 @@ import (
 	"fmt"
 	"log"
 	"net"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/mixpanel/obs/util"
@@ -14,12 +16,105 @@ import (
 
 var batchSizeBytes = 4096
 
+// errStatsdBehind is returned for the metrics dropped because the flusher did not take them in time.
+var errStatsdBehind = errors.New("statsd sink is behind, metric dropped")
+
+// errStatsdClosed is returned for the metrics handled, and the flushes requested, once the sink is closed.
+var errStatsdClosed = errors.New("statsd sink is closed")
+
+// maxPendingBytes bounds how much data is buffered while the sink is disconnected.
+var maxPendingBytes = 64 * batchSizeBytes
+
+const (
+	defaultStatsdMinBackoff = 100 * time.Millisecond
+	defaultStatsdMaxBackoff = 10 * time.Second
+	defaultStatsdTimeout    = time.Second
+)
+
+const (
+	// DefaultStatsdMaxPacketSize fits a statsd packet in the 1500 bytes MTU of Ethernet, along with
+	// the IP and UDP headers.
+	DefaultStatsdMaxPacketSize = 1432
+	// DefaultStatsdFlushWindow is how long metrics wait for more to fill a packet.
+	DefaultStatsdFlushWindow = 100 * time.Millisecond
+)
+
+// StatsdOption configures a statsd Sink.
+type StatsdOption func(*statsdSink)
+
+// StatsdReconnectBackoff sets the bounds of the exponential backoff between attempts
+// to reconnect to the statsd daemon after a write fails.
+func StatsdReconnectBackoff(min, max time.Duration) StatsdOption {
+	return func(sink *statsdSink) {
+		sink.minBackoff = min
+		sink.maxBackoff = max
+	}
+}
+
+// StatsdTimeout sets how long connecting to the statsd daemon, and every write to it, may take before
+// failing, so that a stalled stream socket does not block the sink. Handle waits up to timeout for
+// the sink to take a metric before dropping it.
+func StatsdTimeout(timeout time.Duration) StatsdOption {
+	return func(sink *statsdSink) {
+		sink.timeout = timeout
+	}
+}
+
+// StatsdMaxPacketSize sets the size metrics are packed into before being sent, one packet per write.
+// It should fit the MTU of the network over UDP, so that packets are not fragmented, which drops them
+// all when a fragment is lost. Metrics larger than size are sent alone. Sizes of 0 or less fall back
+// to DefaultStatsdMaxPacketSize.
+func StatsdMaxPacketSize(size int) StatsdOption {
+	return func(sink *statsdSink) {
+		if size <= 0 {
+			size = DefaultStatsdMaxPacketSize
+		}
+		sink.maxPacketSize = size
+	}
+}
+
+// StatsdFlushWindow sets how long metrics wait for more metrics to fill their packet before it is
+// sent anyway.
+func StatsdFlushWindow(window time.Duration) StatsdOption {
+	return func(sink *statsdSink) {
+		sink.flushWindow = window
+	}
+}
+
+// StatsdConnectionGauge makes the sink report the state of its connection as a gauge
+// named metric on every flush interval: 1 when the last write succeeded, 0 otherwise.
+// Values reported while disconnected are delivered once the connection is back.
+func StatsdConnectionGauge(metric string) StatsdOption {
+	return func(sink *statsdSink) {
+		sink.stateGauge = metric
+	}
+}
+
 type statsdSink struct {
 	metrics       chan *bytes.Buffer
 	flushes       chan struct{}
+	done          chan struct{} // closed by Close
+	closeOnce     sync.Once
 	wg            *sync.WaitGroup
-	conn          net.Conn
 	flushInterval time.Duration
+	maxPacketSize int
+	flushWindow   time.Duration
+
+	// owned by the flusher goroutine
+	dial       func(timeout time.Duration) (net.Conn, error)
+	timeout    time.Duration
+	conn       net.Conn // nil while disconnected
+	dialed     bool     // whether a connection was ever made
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	backoff    time.Duration
+	nextDial   time.Time
+	stateGauge string
+
+	// connected is 1 while conn is set, and read by CheckHealth.
+	connected int32
+
+	stats sinkStats
 }
 
 func (sink *statsdSink) Handle(metric string, tags Tags, value float64, metricType metricType) (err error) {
@@ -31,7 +126,7 @@ func (sink *statsdSink) Handle(metric string, tags Tags, value float64, metricTy
 	}()
 
 	if len(metric) == 0 {
-		return errors.New("cannot handle empty metric")
+		return sink.stats.serializationError(errors.New("cannot handle empty metric"))
 	}
 
 	// metric:value|type|#tag1:value1,tag2:value2
@@ -40,7 +135,7 @@ func (sink *statsdSink) Handle(metric string, tags Tags, value float64, metricTy
 	_, _ = buf.WriteString(metric)
 	_, _ = buf.WriteString(":")
 	if _, err := fmt.Fprintf(buf, "%g", value); err != nil {
-		return err
+		return sink.stats.serializationError(err)
 	}
 	_, _ = buf.WriteString("|")
 	_, _ = buf.WriteString(string(metricType))
@@ -61,87 +156,256 @@ func (sink *statsdSink) Handle(metric string, tags Tags, value float64, metricTy
 		}
 	}
 
-	sink.metrics <- buf
-	return nil
+	select {
+	case <-sink.done:
+		sink.stats.drop(1)
+		return errStatsdClosed
+	default:
+	}
+	select {
+	case sink.metrics <- buf:
+		return nil
+	default:
+	}
+	// the flusher is behind, as when a burst of metrics is handed over at once: wait for it, but only
+	// up to the write timeout, so that the caller does not block on a stalled connection
+	timer := time.NewTimer(sink.timeout)
+	defer timer.Stop()
+	select {
+	case sink.metrics <- buf:
+		return nil
+	case <-timer.C:
+		sink.stats.drop(1)
+		return errStatsdBehind
+	}
 }
 
 func (sink *statsdSink) Flush() error {
-	sink.flushes <- struct{}{}
-	return nil
+	select {
+	case sink.flushes <- struct{}{}:
+		return nil
+	case <-sink.done:
+		return errStatsdClosed
+	}
 }
 
 func (sink *statsdSink) flusher() {
 	defer func() {
-		if err := sink.conn.Close(); err != nil {
-			log.Printf("error while closing connection to statsd: %v", err)
+		if sink.conn != nil {
+			if err := sink.conn.Close(); err != nil {
+				log.Printf("error while closing connection to statsd: %v", err)
+			}
 		}
 		sink.wg.Done()
 	}()
 
+	sink.reconnect()
 	nextFlush := time.After(sink.flushInterval)
+	// window is set while the pending packet waits for more metrics.
+	var window <-chan time.Time
 
 	buffer := &bytes.Buffer{}
 	flushBuffer := func() error {
+		window = nil
+		if buffer.Len() == 0 {
+			return nil
+		}
+		if sink.conn == nil && !sink.reconnect() {
+			if buffer.Len() > maxPendingBytes {
+				sink.stats.dropLines(buffer.Bytes())
+				buffer.Reset()
+			}
+			return errors.New("not connected to statsd")
+		}
+
+		start := time.Now()
+		defer sink.stats.flushed(start)
 		data := buffer.Next(buffer.Len())
 		buffer.Reset()
-		for written := 0; written < len(data); {
-			n, err := sink.conn.Write(data[written:])
-			if err != nil {
-				log.Printf("error while writing to statsd: %v", err)
-				return err
+		for len(data) > 0 {
+			var packet []byte
+			packet, data = nextPacket(data, sink.maxPacketSize)
+			for written := 0; written < len(packet); {
+				n, err := sink.write(packet[written:])
+				if err != nil {
+					log.Printf("error while writing to statsd: %v", err)
+					sink.stats.writeError()
+					sink.stats.dropLines(packet)
+					sink.stats.dropLines(data)
+					sink.disconnect()
+					return err
+				}
+				written += n
 			}
-			written += n
 		}
 		return nil
 	}
 
 	for {
+		if window == nil && buffer.Len() > 0 {
+			window = time.After(sink.flushWindow)
+		}
 		select {
 		case stat := <-sink.metrics:
+			// send the pending packet first if the metric does not fit in it
+			if buffer.Len() > 0 && buffer.Len()+stat.Len()+1 > sink.maxPacketSize {
+				flushBuffer()
+			}
 			writeStatToBuffer(stat, buffer)
-			if buffer.Len() >= batchSizeBytes {
+			if buffer.Len() >= sink.maxPacketSize {
 				flushBuffer()
 			}
-		case _, ok := <-sink.flushes:
-			if !ok {
-				// drain the metrics channel
-				for {
-					select {
-					case stat := <-sink.metrics:
-						writeStatToBuffer(stat, buffer)
-					default:
-						flushBuffer()
-						return
-					}
+		case <-window:
+			flushBuffer()
+		case <-sink.done:
+			// drain the metrics channel
+			for {
+				select {
+				case stat := <-sink.metrics:
+					writeStatToBuffer(stat, buffer)
+				default:
+					flushBuffer()
+					return
 				}
 			}
+		case <-sink.flushes:
 			flushBuffer()
 		case _ = <-nextFlush:
+			sink.writeStateGauge(buffer)
 			flushBuffer()
 			nextFlush = time.After(sink.flushInterval)
 		}
 	}
 }
 
+func (sink *statsdSink) write(b []byte) (int, error) {
+	if err := sink.conn.SetWriteDeadline(time.Now().Add(sink.timeout)); err != nil {
+		return 0, err
+	}
+	return sink.conn.Write(b)
+}
+
+// reconnect dials the statsd daemon unless the backoff since the last failed attempt has
+// not elapsed yet. It returns whether the sink is connected.
+func (sink *statsdSink) reconnect() bool {
+	now := time.Now()
+	if now.Before(sink.nextDial) {
+		return false
+	}
+	conn, err := sink.dial(sink.timeout)
+	if err != nil {
+		sink.backoff *= 2
+		if sink.backoff < sink.minBackoff {
+			sink.backoff = sink.minBackoff
+		}
+		if sink.backoff > sink.maxBackoff {
+			sink.backoff = sink.maxBackoff
+		}
+		sink.nextDial = now.Add(sink.backoff)
+		return false
+	}
+	if sink.dialed {
+		log.Printf("reconnected to statsd")
+		sink.stats.reconnect()
+	}
+	sink.dialed = true
+	sink.conn = conn
+	atomic.StoreInt32(&sink.connected, 1)
+	sink.backoff = 0
+	return true
+}
+
+func (sink *statsdSink) disconnect() {
+	if err := sink.conn.Close(); err != nil {
+		log.Printf("error while closing connection to statsd: %v", err)
+	}
+	sink.conn = nil
+	atomic.StoreInt32(&sink.connected, 0)
+}
+
+// CheckHealth returns an error while the sink is disconnected from statsd. Over UDP, the sink only
+// disconnects when writes fail, for instance when the statsd port is closed on localhost.
+func (sink *statsdSink) CheckHealth() error {
+	if atomic.LoadInt32(&sink.connected) == 0 {
+		return errors.New("not connected to statsd")
+	}
+	return nil
+}
+
+// SinkStats counts the metrics dropped while disconnected, by failed writes, or because Handle timed
+// out waiting for the flusher. Over UDP, writes only
+// fail when the packets are refused, not when they are lost on the way.
+func (sink *statsdSink) SinkStats() SinkStats {
+	return sink.stats.get()
+}
+
+func (sink *statsdSink) writeStateGauge(buffer *bytes.Buffer) {
+	if sink.stateGauge == "" {
+		return
+	}
+	state := "0"
+	if sink.conn != nil {
+		state = "1"
+	}
+	_, _ = buffer.WriteString(sink.stateGauge)
+	_, _ = buffer.WriteString(":")
+	_, _ = buffer.WriteString(state)
+	_, _ = buffer.WriteString("|")
+	_, _ = buffer.WriteString(string(metricTypeGauge))
+	_, _ = buffer.WriteString("\n")
+}
+
+// nextPacket splits the first packet of at most size bytes off data, at a line boundary. A line larger
+// than size is a packet of its own.
+func nextPacket(data []byte, size int) (packet, rest []byte) {
+	if len(data) <= size {
+		return data, nil
+	}
+	end := bytes.LastIndexByte(data[:size], '\n') + 1
+	if end == 0 {
+		end = bytes.IndexByte(data, '\n') + 1
+		if end == 0 {
+			end = len(data)
+		}
+	}
+	return data[:end], data[end:]
+}
+
 func writeStatToBuffer(stat, buffer *bytes.Buffer) {
 	_, _ = stat.WriteTo(buffer)
 	_, _ = buffer.WriteString("\n")
 	util.SharedBufferPool.Put(stat)
 }
 
+// Close flushes the pending metrics and closes the connection. Metrics handled and flushes requested
+// afterwards, for instance by a component that did not stop in time, are dropped.
 func (sink *statsdSink) Close() {
-	close(sink.flushes)
+	sink.closeOnce.Do(func() {
+		close(sink.done)
+	})
 	sink.wg.Wait()
 }
 
-func newStatsdSinkFromConn(conn net.Conn) (Sink, error) {
+// newStatsdSink returns a statsd sink connecting with dial. It connects in the background, so that
+// services start while the statsd daemon is unreachable: metrics are buffered in the meantime, up to
+// maxPendingBytes.
+func newStatsdSink(dial func(timeout time.Duration) (net.Conn, error), opts ...StatsdOption) (Sink, error) {
 	wg := &sync.WaitGroup{}
 	sink := &statsdSink{
 		metrics:       make(chan *bytes.Buffer, 128),
 		flushes:       make(chan struct{}),
+		done:          make(chan struct{}),
 		wg:            wg,
-		conn:          conn,
 		flushInterval: 5 * time.Second,
+		maxPacketSize: DefaultStatsdMaxPacketSize,
+		flushWindow:   DefaultStatsdFlushWindow,
+		dial:          dial,
+		timeout:       defaultStatsdTimeout,
+		minBackoff:    defaultStatsdMinBackoff,
+		maxBackoff:    defaultStatsdMaxBackoff,
+	}
+	for _, opt := range opts {
+		opt(sink)
 	}
 
 	wg.Add(1)
@@ -150,16 +414,53 @@ func newStatsdSinkFromConn(conn net.Conn) (Sink, error) {
 	return sink, nil
 }
 
+func newStatsdSinkFromConn(conn net.Conn, opts ...StatsdOption) (Sink, error) {
+	dialed := false
+	return newStatsdSink(func(time.Duration) (net.Conn, error) {
+		if dialed {
+			return nil, errors.New("cannot reconnect a fixed connection")
+		}
+		dialed = true
+		return conn, nil
+	}, opts...)
+}
+
+// parseStatsdAddr splits addr into a network and an address. addr is either host:port for
+// UDP, or one of tcp://host:port, udp://host:port, unix:///path/to/socket (stream) and
+// unixgram:///path/to/socket (datagram).
+func parseStatsdAddr(addr string) (network, address string, err error) {
+	i := strings.Index(addr, "://")
+	if i < 0 {
+		return "udp", addr, nil
+	}
+	network, address = addr[:i], addr[i+3:]
+	switch network {
+	case "udp", "tcp", "unix", "unixgram":
+	default:
+		return "", "", fmt.Errorf("unsupported statsd transport %q", network)
+	}
+	if address == "" {
+		return "", "", fmt.Errorf("missing statsd address in %q", addr)
+	}
+	return network, address, nil
+}
+
 // NewStatsdSink returns a Sink for statsd
-// pass the address of the statsd daemon to it
-func NewStatsdSink(addr string) (Sink, error) {
+// pass the address of the statsd daemon to it, either as host:port for UDP or
+// prefixed with the transport: tcp://host:port, unix:///path or unixgram:///path.
+// The sink connects in the background, and when the connection or a write fails, it reconnects with
+// exponential backoff. Metrics are dropped, and counted in its SinkStats, while it cannot keep up.
+// It only returns an error if addr is malformed.
+func NewStatsdSink(addr string, opts ...StatsdOption) (Sink, error) {
 	if addr == "" {
 		return &nullSink{}, nil
 	}
-	conn, err := net.Dial("udp", addr)
+	network, address, err := parseStatsdAddr(addr)
 	if err != nil {
 		return nil, err
 	}
 
-	return newStatsdSinkFromConn(conn)
+	return newStatsdSink(func(timeout time.Duration) (net.Conn, error) {
+		return net.DialTimeout(network, address, timeout)
+	}, opts...)
 }
@@ -0,0 +1,331 @@
+package metrics
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseStatsdAddr(t *testing.T) {
+	cases := []struct {
+		addr, network, address string
+		err                    bool
+	}{
+		{"127.0.0.1:8125", "udp", "127.0.0.1:8125", false},
+		{"udp://127.0.0.1:8125", "udp", "127.0.0.1:8125", false},
+		{"tcp://127.0.0.1:8125", "tcp", "127.0.0.1:8125", false},
+		{"unix:///var/run/statsd.sock", "unix", "/var/run/statsd.sock", false},
+		{"unixgram:///var/run/statsd.sock", "unixgram", "/var/run/statsd.sock", false},
+		{"http://127.0.0.1:8125", "", "", true},
+		{"tcp://", "", "", true},
+	}
+	for _, c := range cases {
+		network, address, err := parseStatsdAddr(c.addr)
+		if c.err {
+			assert.Error(t, err, c.addr)
+			continue
+		}
+		assert.NoError(t, err, c.addr)
+		assert.Equal(t, c.network, network, c.addr)
+		assert.Equal(t, c.address, address, c.addr)
+	}
+}
+
+func readLine(t *testing.T, l net.Listener) string {
+	conn, err := l.Accept()
+	if !assert.NoError(t, err) {
+		return ""
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	assert.NoError(t, err)
+	return line
+}
+
+func TestStatsdSinkStreamTransports(t *testing.T) {
+	dir, err := ioutil.TempDir("", "statsd")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer tcp.Close()
+
+	sock := filepath.Join(dir, "statsd.sock")
+	unix, err := net.Listen("unix", sock)
+	assert.NoError(t, err)
+	defer unix.Close()
+
+	for addr, l := range map[string]net.Listener{
+		"tcp://" + tcp.Addr().String(): tcp,
+		"unix://" + sock:               unix,
+	} {
+		sink, err := NewStatsdSink(addr)
+		if !assert.NoError(t, err, addr) {
+			continue
+		}
+		assert.NoError(t, sink.Handle("foo", nil, 1, metricTypeCounter))
+		assert.NoError(t, sink.Flush())
+		assert.Equal(t, "foo:1|ct\n", readLine(t, l), addr)
+		sink.Close()
+	}
+}
+
+type flakyConn struct {
+	net.Conn
+
+	mu      sync.Mutex
+	written []string
+	fail    bool
+	closed  bool
+}
+
+func (c *flakyConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.fail {
+		return 0, errors.New("broken pipe")
+	}
+	c.written = append(c.written, string(b))
+	return len(b), nil
+}
+
+func (c *flakyConn) SetWriteDeadline(time.Time) error {
+	return nil
+}
+
+func (c *flakyConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	return nil
+}
+
+func TestStatsdSinkReconnects(t *testing.T) {
+	first := &flakyConn{fail: true}
+	second := &flakyConn{}
+	var mu sync.Mutex
+	dials := 0
+	dial := func(time.Duration) (net.Conn, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		dials++
+		switch dials {
+		case 1:
+			return first, nil
+		case 2:
+			return nil, errors.New("connection refused")
+		default:
+			return second, nil
+		}
+	}
+
+	sink, err := newStatsdSink(dial, StatsdReconnectBackoff(0, 0), StatsdMaxPacketSize(1))
+	assert.NoError(t, err)
+	handle := func(metric string) {
+		sink.Handle(metric, nil, 1, metricTypeCounter)
+		for len(sink.(*statsdSink).metrics) > 0 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	// every metric is written right away, as they do not fit in a packet.
+	// the write on the first connection fails, which closes it
+	handle("lost")
+	// the first redial fails, so the metric stays pending
+	handle("pending")
+	// the second redial succeeds
+	handle("sent")
+	sink.Close()
+
+	assert.True(t, first.closed)
+	assert.True(t, second.closed)
+	assert.Equal(t, 3, dials)
+	// pending metrics are sent in packets of their own, as they do not fit together
+	assert.Equal(t, []string{"pending:1|ct\n", "sent:1|ct\n"}, second.written)
+
+	stats := sink.(StatsReporter).SinkStats()
+	assert.Equal(t, int64(1), stats.WriteErrors)
+	assert.Equal(t, int64(1), stats.Dropped, "the metric whose write failed is lost")
+	assert.Equal(t, int64(1), stats.Reconnects)
+	assert.Equal(t, int64(3), stats.Flushes)
+}
+
+func TestStatsdSinkCheckHealth(t *testing.T) {
+	sink, err := newStatsdSinkFromConn(&flakyConn{fail: true}, StatsdReconnectBackoff(time.Hour, time.Hour),
+		StatsdMaxPacketSize(1))
+	assert.NoError(t, err)
+	defer sink.Close()
+	hc := sink.(HealthChecker)
+	assert.Eventually(t, func() bool { return hc.CheckHealth() == nil }, time.Second, time.Millisecond,
+		"the sink connects in the background")
+
+	// the metric does not fit in a packet, so the failed write disconnects the sink right away
+	sink.Handle("lost", nil, 1, metricTypeCounter)
+	for deadline := time.Now().Add(time.Second); hc.CheckHealth() == nil && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	assert.EqualError(t, hc.CheckHealth(), "not connected to statsd")
+}
+
+func TestStatsdConnectionGauge(t *testing.T) {
+	conn := &flakyConn{}
+	sink, err := newStatsdSinkFromConn(conn, StatsdConnectionGauge("statsd.connected"),
+		func(sink *statsdSink) { sink.flushInterval = time.Millisecond })
+	assert.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		conn.mu.Lock()
+		defer conn.mu.Unlock()
+		return len(conn.written) > 0
+	}, time.Second, time.Millisecond)
+	sink.Close()
+
+	assert.Equal(t, "statsd.connected:1|g\n", conn.written[0])
+}
+
+func TestStatsdSinkPacking(t *testing.T) {
+	conn := &flakyConn{}
+	sink, err := newStatsdSinkFromConn(conn, StatsdMaxPacketSize(20), StatsdFlushWindow(time.Hour))
+	assert.NoError(t, err)
+	for _, metric := range []string{"a", "b", "c", "a_metric_larger_than_a_packet", "d"} {
+		assert.NoError(t, sink.Handle(metric, nil, 1, metricTypeCounter))
+	}
+	sink.Close()
+
+	assert.Equal(t, []string{
+		"a:1|ct\nb:1|ct\n",
+		"c:1|ct\n",
+		"a_metric_larger_than_a_packet:1|ct\n",
+		"d:1|ct\n",
+	}, conn.written)
+}
+
+func TestStatsdSinkClosed(t *testing.T) {
+	conn := &flakyConn{}
+	sink, err := newStatsdSinkFromConn(conn)
+	assert.NoError(t, err)
+	assert.NoError(t, sink.Handle("a", nil, 1, metricTypeCounter))
+	sink.Close()
+	assert.Equal(t, []string{"a:1|ct\n"}, conn.written)
+
+	assert.Equal(t, errStatsdClosed, sink.Handle("b", nil, 1, metricTypeCounter))
+	assert.Equal(t, errStatsdClosed, sink.Flush())
+	sink.Close()
+	assert.Equal(t, int64(1), sink.(StatsReporter).SinkStats().Dropped)
+}
+
+func TestStatsdSinkInvalidPacketSize(t *testing.T) {
+	conn := &flakyConn{}
+	sink, err := newStatsdSinkFromConn(conn, StatsdMaxPacketSize(-1), StatsdFlushWindow(time.Hour))
+	assert.NoError(t, err)
+	assert.NoError(t, sink.Handle("a", nil, 1, metricTypeCounter))
+	assert.NoError(t, sink.Handle("b", nil, 1, metricTypeCounter))
+	sink.Close()
+
+	assert.Equal(t, []string{"a:1|ct\nb:1|ct\n"}, conn.written)
+}
+
+func TestStatsdSinkFlushWindow(t *testing.T) {
+	conn := &flakyConn{}
+	sink, err := newStatsdSinkFromConn(conn, StatsdFlushWindow(time.Millisecond))
+	assert.NoError(t, err)
+	defer sink.Close()
+	assert.NoError(t, sink.Handle("a", nil, 1, metricTypeCounter))
+
+	written := func() []string {
+		conn.mu.Lock()
+		defer conn.mu.Unlock()
+		return append([]string(nil), conn.written...)
+	}
+	for deadline := time.Now().Add(time.Second); len(written()) == 0 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	assert.Equal(t, []string{"a:1|ct\n"}, written())
+}
+
+func TestNextPacket(t *testing.T) {
+	packet, rest := nextPacket([]byte("a:1|ct\nb:1|ct\n"), 10)
+	assert.Equal(t, "a:1|ct\n", string(packet))
+	assert.Equal(t, "b:1|ct\n", string(rest))
+	packet, rest = nextPacket([]byte("abcdefghijkl\nb\n"), 10)
+	assert.Equal(t, "abcdefghijkl\n", string(packet))
+	assert.Equal(t, "b\n", string(rest))
+	packet, rest = nextPacket([]byte("b\n"), 10)
+	assert.Equal(t, "b\n", string(packet))
+	assert.Empty(t, rest)
+}
+
+func TestStatsdSinkLazyDial(t *testing.T) {
+	var mu sync.Mutex
+	var conn *flakyConn
+	dial := func(time.Duration) (net.Conn, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if conn == nil {
+			return nil, errors.New("connection refused")
+		}
+		return conn, nil
+	}
+	sink, err := newStatsdSink(dial, StatsdReconnectBackoff(0, 0))
+	assert.NoError(t, err, "the statsd daemon being down does not fail the sink")
+	assert.NoError(t, sink.Handle("pending", nil, 1, metricTypeCounter))
+	assert.NoError(t, sink.Flush())
+
+	mu.Lock()
+	conn = &flakyConn{}
+	mu.Unlock()
+	sink.Close()
+	assert.Equal(t, []string{"pending:1|ct\n"}, conn.written)
+	assert.Equal(t, int64(0), sink.(StatsReporter).SinkStats().Reconnects)
+}
+
+func TestStatsdSinkDropsWhenFull(t *testing.T) {
+	sink := &statsdSink{metrics: make(chan *bytes.Buffer, 1), timeout: time.Millisecond}
+	assert.NoError(t, sink.Handle("a", nil, 1, metricTypeCounter))
+	assert.Equal(t, errStatsdBehind, sink.Handle("b", nil, 1, metricTypeCounter), "Handle blocks up to the timeout")
+	assert.Equal(t, int64(1), sink.SinkStats().Dropped)
+}
+
+func TestStatsdSinkAggregatedFlush(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go func() {
+		buf := make([]byte, 64<<10)
+		for {
+			if _, _, err := conn.ReadFrom(buf); err != nil {
+				return
+			}
+		}
+	}()
+
+	sink, err := NewStatsdSink(conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, stop := NewAggregatingReceiver(sink, AggregationOptions{Interval: time.Hour})
+	for i := 0; i < 5000; i++ {
+		r.Incr(fmt.Sprintf("counter_%d", i))
+	}
+	for i := 0; i < 500; i++ {
+		r.AddStat(fmt.Sprintf("stat_%d", i), 1)
+	}
+	stop()
+	sink.Close()
+
+	assert.Equal(t, int64(0), SinkStatsOf(sink).Dropped, "a healthy sink takes a whole flush")
+}
@@ -5,16 +5,38 @@ import "time"
 // Stopwatch is used for measuring
 // time spent in an operation
 type Stopwatch interface {
+	// Stop records the time elapsed since the stopwatch started, in microseconds, as the stat
+	// <name>_us, and counts the operation in the counter <name>_count.
 	Stop()
+	// StopWithTags is like Stop, adding tags, such as the outcome of the operation, to the tags the
+	// stopwatch was started with.
+	StopWithTags(tags Tags)
 }
 
 type stopwatch struct {
 	name      string
 	startTime time.Time
-	receiver  Receiver
+	receiver  *receiver
+	tags      Tags
 }
 
 func (stopwatch *stopwatch) Stop() {
-	latencyMicros := time.Now().Sub(stopwatch.startTime) / time.Microsecond
-	stopwatch.receiver.AddStat(stopwatch.name+"_us", float64(latencyMicros))
+	stopwatch.StopWithTags(nil)
+}
+
+func (stopwatch *stopwatch) StopWithTags(tags Tags) {
+	latencyMicros := stopwatch.receiver.clock.Since(stopwatch.startTime) / time.Microsecond
+	var r Receiver = stopwatch.receiver
+	if len(stopwatch.tags) > 0 || len(tags) > 0 {
+		merged := make(Tags, len(stopwatch.tags)+len(tags))
+		for k, v := range stopwatch.tags {
+			merged[k] = v
+		}
+		for k, v := range tags {
+			merged[k] = v
+		}
+		r = r.ScopeTags(merged)
+	}
+	r.AddStat(stopwatch.name+"_us", float64(latencyMicros))
+	r.Incr(stopwatch.name + "_count")
 }
@@ -0,0 +1,41 @@
+package metrics
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/mixpanel/obs/util"
+)
+
+// withTLS returns a copy of client whose connections are configured with cfg.
+func withTLS(client *http.Client, cfg *tls.Config) (*http.Client, error) {
+	var transport *http.Transport
+	switch t := client.Transport.(type) {
+	case nil:
+		transport = util.CloneTransport(nil)
+	case *http.Transport:
+		transport = util.CloneTransport(t)
+	default:
+		return nil, errors.New("cannot configure TLS on a client whose transport is not an *http.Transport")
+	}
+	transport.TLSClientConfig = cfg
+	copied := *client
+	copied.Transport = transport
+	return &copied, nil
+}
+
+// dialTLS returns a dial function connecting to addr with TCP, over TLS configured with cfg if it is
+// not nil. The server name defaults to the host of addr.
+func dialTLS(addr string, timeout time.Duration, cfg *tls.Config) func() (net.Conn, error) {
+	if cfg == nil {
+		return func() (net.Conn, error) {
+			return net.DialTimeout("tcp", addr, timeout)
+		}
+	}
+	return func() (net.Conn, error) {
+		return tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", addr, cfg)
+	}
+}
@@ -0,0 +1,82 @@
+package metrics
+
+import (
+	"bufio"
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newMutualTLSServer returns a server requiring a client certificate, and the configuration of a
+// client trusting it and authenticating with its certificate.
+func newMutualTLSServer(handler http.Handler) (*httptest.Server, *tls.Config) {
+	server := httptest.NewUnstartedServer(handler)
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	server.StartTLS()
+	cfg := server.Client().Transport.(*http.Transport).TLSClientConfig.Clone()
+	cfg.Certificates = server.TLS.Certificates
+	return server, cfg
+}
+
+func TestRemoteWriteSinkAuthentication(t *testing.T) {
+	requests := make(chan *http.Request, 1)
+	server, cfg := newMutualTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests <- r
+	}))
+	defer server.Close()
+
+	sink, err := NewRemoteWriteSink(server.URL, RemoteWriteFlushInterval(time.Hour), RemoteWriteRetries(0, 0),
+		RemoteWriteTLS(cfg), RemoteWriteBasicAuth("user", "secret"))
+	assert.NoError(t, err)
+	defer sink.Close()
+	sink.Handle("requests", nil, 1, metricTypeCounter)
+	assert.NoError(t, sink.Flush())
+
+	r := <-requests
+	username, password, ok := r.BasicAuth()
+	assert.True(t, ok)
+	assert.Equal(t, "user", username)
+	assert.Equal(t, "secret", password)
+	assert.Len(t, r.TLS.PeerCertificates, 1, "the client authenticated with its certificate")
+
+	sink, err = NewRemoteWriteSink(server.URL, RemoteWriteFlushInterval(time.Hour), RemoteWriteRetries(0, 0),
+		RemoteWriteBearerToken("token"))
+	assert.NoError(t, err)
+	defer sink.Close()
+	sink.Handle("requests", nil, 1, metricTypeCounter)
+	assert.Error(t, sink.Flush(), "the certificate of the server is not trusted")
+
+	_, err = NewRemoteWriteSink(server.URL, RemoteWriteTLS(cfg),
+		RemoteWriteClient(&http.Client{Transport: http.NewFileTransport(nil)}))
+	assert.Error(t, err)
+}
+
+func TestGraphiteSinkTLS(t *testing.T) {
+	server, cfg := newMutualTLSServer(http.NotFoundHandler())
+	server.Close()
+	l, err := tls.Listen("tcp", "127.0.0.1:0", server.TLS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	sink, err := NewGraphiteSink(l.Addr().String(), "", GraphiteFlushInterval(time.Hour), GraphiteTLS(cfg))
+	assert.NoError(t, err)
+	defer sink.Close()
+	assert.NoError(t, sink.Handle("requests", nil, 1, metricTypeCounter))
+	go sink.Flush()
+
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	assert.NoError(t, err)
+	assert.Regexp(t, `^requests 1 \d+\n$`, line)
+	assert.Len(t, conn.(*tls.Conn).ConnectionState().PeerCertificates, 1)
+}
@@ -27,6 +27,19 @@ func formatName(prefix string, name string) string {
 	return formatted + name
 }
 
+// JoinParts joins the parts of a metric name with delimiter, leaving out empty parts. Parts are
+// sanitized like the segments of names normalized by NormalizeName, so that a part holding a '.', such
+// as a host name or a version, cannot add segments.
+func JoinParts(delimiter string, parts ...string) string {
+	kept := make([]string, 0, len(parts))
+	for _, part := range parts {
+		if part != "" {
+			kept = append(kept, strings.Map(normalizeNameRune, strings.ToLower(part)))
+		}
+	}
+	return strings.Join(kept, delimiter)
+}
+
 // FormatTags is used by receivers and sinks to convert a map of tags into a string that can be
 // used as a map key
 func FormatTags(tags Tags) string {
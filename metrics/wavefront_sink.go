@@ -20,6 +20,7 @@ type wavefrontSink struct {
 	mutex     sync.Mutex // protects buffer and closed
 	buffer    *bytes.Buffer
 	closed    bool
+	stats     sinkStats
 }
 
 func writeTags(buf *bytes.Buffer, tags Tags) {
@@ -32,8 +33,12 @@ func writeTags(buf *bytes.Buffer, tags Tags) {
 }
 
 func (sink *wavefrontSink) Handle(metric string, tags Tags, value float64, metricType metricType) error {
+	return sink.HandleAt(metric, tags, value, metricType, time.Now())
+}
+
+func (sink *wavefrontSink) HandleAt(metric string, tags Tags, value float64, metricType metricType, at time.Time) error {
 	if len(metric) == 0 {
-		return errors.New("cannot handle empty metric")
+		return sink.stats.serializationError(errors.New("cannot handle empty metric"))
 	}
 
 	buf := util.SharedBufferPool.Get()
@@ -42,9 +47,37 @@ func (sink *wavefrontSink) Handle(metric string, tags Tags, value float64, metri
 	// wavefront format: <metricName> <metricValue> [optionalTimestampInEpochSeconds] host=<host> [tag1=value1 tag2=value2 ... ]
 	_, _ = buf.WriteString(metric)
 	_, _ = buf.WriteString(" ")
-	if _, err := fmt.Fprintf(buf, "%0.6f %d ", value, time.Now().Unix()); err != nil {
-		return err
+	if _, err := fmt.Fprintf(buf, "%0.6f %d ", value, at.Unix()); err != nil {
+		return sink.stats.serializationError(err)
 	}
+	return sink.write(buf, tags)
+}
+
+// HandleHistogram reports the histogram as a Wavefront distribution with minute granularity, made of
+// one centroid per bucket.
+func (sink *wavefrontSink) HandleHistogram(metric string, tags Tags, h *Histogram) error {
+	if len(metric) == 0 {
+		return sink.stats.serializationError(errors.New("cannot handle empty metric"))
+	}
+	if h.Count() == 0 {
+		return nil
+	}
+
+	buf := util.SharedBufferPool.Get()
+	defer util.SharedBufferPool.Put(buf)
+
+	// wavefront distribution format: !M [timestamp] #<count> <value> [#<count> <value> ...] <metricName> host=<host> [tags]
+	fmt.Fprintf(buf, "!M %d ", time.Now().Unix())
+	h.eachBucket(func(value float64, n uint64) {
+		fmt.Fprintf(buf, "#%d %0.6f ", n, value)
+	})
+	_, _ = buf.WriteString(metric)
+	_, _ = buf.WriteString(" ")
+	return sink.write(buf, tags)
+}
+
+// write completes the line in buf with the host and tags, and buffers it until the next flush.
+func (sink *wavefrontSink) write(buf *bytes.Buffer, tags Tags) error {
 	_, _ = buf.WriteString("host=")
 	_, _ = buf.WriteString(sink.origin)
 	_, _ = buf.WriteString(" ")
@@ -65,6 +98,7 @@ func (sink *wavefrontSink) Handle(metric string, tags Tags, value float64, metri
 	defer sink.mutex.Unlock()
 
 	if sink.closed {
+		sink.stats.drop(1)
 		return errors.New("sink is closed")
 	}
 	_, _ = buf.WriteTo(sink.buffer)
@@ -101,17 +135,26 @@ func (sink *wavefrontSink) Flush() error {
 
 	var err error
 
+	defer sink.stats.flushed(time.Now())
 	idx := rand.Intn(len(sink.hostPorts))
 	for i := 0; i < len(sink.hostPorts); i++ {
 		if err = send(sink.hostPorts[idx], sendBuffer.Bytes()); err == nil {
 			return nil
 		}
+		sink.stats.writeError()
 		idx = (idx + 1) % len(sink.hostPorts)
 	}
 
+	sink.stats.dropLines(sendBuffer.Bytes())
 	return err
 }
 
+// SinkStats counts a write error for every proxy that could not be sent to, and the metrics dropped
+// when none could.
+func (sink *wavefrontSink) SinkStats() SinkStats {
+	return sink.stats.get()
+}
+
 func (sink *wavefrontSink) Close() {
 	sink.mutex.Lock()
 	sink.closed = true
@@ -120,7 +163,8 @@ func (sink *wavefrontSink) Close() {
 	sink.Flush()
 }
 
-// NewWavefrontSink returns a sink for wavefront.
+// NewWavefrontSink returns a sink for wavefront. Behind NewAggregatingReceiver, it reports stats as
+// distributions, which the proxies must accept on the ports in hostPorts.
 func NewWavefrontSink(origin string, tags map[string]string, hostPorts []string) Sink {
 	return &wavefrontSink{
 		origin:    origin,
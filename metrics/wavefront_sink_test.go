@@ -73,6 +73,29 @@ func TestWavefrontSinkWithTags(t *testing.T) {
 	assert.True(t, mp[split[6]])
 }
 
+func TestWavefrontSinkHistogram(t *testing.T) {
+	endpoint := newTCPEndpoint()
+	endpoint.wg.Add(1)
+	go newServer(endpoint)
+
+	sink := newSink(endpoint.address)
+	h := NewHistogram(DefaultHistogramScale)
+	h.Record(12)
+	h.Record(12)
+	assert.NoError(t, sink.(HistogramSink).HandleHistogram("test.latency", nil, h))
+	assert.NoError(t, sink.Flush())
+
+	endpoint.wg.Wait()
+
+	split := strings.Split(strings.TrimSpace(string(endpoint.buf.Bytes())), " ")
+	if assert.Len(t, split, 6) {
+		assert.Equal(t, "!M", split[0])
+		assert.Equal(t, []string{"#2", "12.000000"}, split[2:4], "centroids are bounded by the min and max")
+		assert.Equal(t, "test.latency", split[4])
+		assert.Equal(t, "host=localhost", split[5])
+	}
+}
+
 func TestWavefrontSinkRetrySuccess(t *testing.T) {
 	rand.Seed(1)
 	endpoint := newTCPEndpoint()
@@ -0,0 +1,294 @@
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// DefaultWindowSeries is the number of metrics a WindowSink keeps the history of unless told otherwise.
+const DefaultWindowSeries = 1000
+
+type windowBucket struct {
+	second int64
+	count  int64
+	sum    float64
+	min    float64
+	max    float64
+	last   float64
+}
+
+type windowSeries struct {
+	metricType metricType
+	buckets    []windowBucket
+	// latest is the latest second recorded.
+	latest int64
+}
+
+func (s *windowSeries) add(second int64, value float64) {
+	b := s.bucket(second, value, value)
+	b.count++
+	b.sum += value
+	b.last = value
+}
+
+// addHistogram adds the values of h, which have no last value, to the bucket of second.
+func (s *windowSeries) addHistogram(second int64, h *Histogram) {
+	if h.Count() == 0 {
+		return
+	}
+	b := s.bucket(second, h.Min(), h.Max())
+	b.count += int64(h.Count())
+	b.sum += h.Sum()
+}
+
+// bucket returns the bucket of second, reset if it held an older second, with min and max updated.
+func (s *windowSeries) bucket(second int64, min, max float64) *windowBucket {
+	if second > s.latest {
+		s.latest = second
+	}
+	b := &s.buckets[second%int64(len(s.buckets))]
+	if b.second != second || b.count == 0 {
+		*b = windowBucket{second: second, min: min, max: max}
+	}
+	if min < b.min {
+		b.min = min
+	}
+	if max > b.max {
+		b.max = max
+	}
+	return b
+}
+
+// WindowStats summarizes the values a metric received during a window of time.
+type WindowStats struct {
+	Count int64
+	Sum   float64
+	Min   float64
+	Max   float64
+	Last  float64
+}
+
+func (s *windowSeries) stats(from, to int64) WindowStats {
+	var res WindowStats
+	lastSecond := int64(math.MinInt64)
+	for _, b := range s.buckets {
+		if b.count == 0 || b.second < from || b.second > to {
+			continue
+		}
+		if res.Count == 0 || b.min < res.Min {
+			res.Min = b.min
+		}
+		if res.Count == 0 || b.max > res.Max {
+			res.Max = b.max
+		}
+		res.Count += b.count
+		res.Sum += b.sum
+		if b.second > lastSecond {
+			lastSecond = b.second
+			res.Last = b.last
+		}
+	}
+	return res
+}
+
+// WindowSink is a Sink that keeps a short in-memory history of every metric it handles,
+// at one second resolution, before passing the metric on to the destination sink.
+// The history can be queried with Stats or with condition expressions (see ParseCondition),
+// which lets a process make decisions such as readiness from its own recent telemetry.
+//
+// Metrics are recorded by their full name (including any receiver prefix), aggregated
+// across all tag values. The history of at most DefaultWindowSeries metrics is kept, making room for
+// new ones by forgetting those not recorded during the retention: the metrics beyond are still passed
+// on to dst, but left out of the history.
+type WindowSink struct {
+	dst       Sink
+	retention int64
+	maxSeries int
+	now       func() time.Time
+
+	mutex  sync.RWMutex
+	series map[string]*windowSeries
+	// sweptAt is the second expired series were last forgotten at.
+	sweptAt int64
+
+	stats sinkStats
+}
+
+// NewWindowSink returns a WindowSink that remembers metrics for the given retention and
+// forwards them to dst. Queries cannot look further back than the retention.
+func NewWindowSink(dst Sink, retention time.Duration) *WindowSink {
+	return NewWindowSinkWithMaxSeries(dst, retention, DefaultWindowSeries)
+}
+
+// NewWindowSinkWithMaxSeries is like NewWindowSink, keeping the history of at most maxSeries metrics
+// (DefaultWindowSeries if maxSeries <= 0).
+func NewWindowSinkWithMaxSeries(dst Sink, retention time.Duration, maxSeries int) *WindowSink {
+	seconds := int64(retention / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	if maxSeries <= 0 {
+		maxSeries = DefaultWindowSeries
+	}
+	return &WindowSink{
+		dst:       dst,
+		retention: seconds,
+		maxSeries: maxSeries,
+		now:       time.Now,
+		series:    make(map[string]*windowSeries),
+	}
+}
+
+func (sink *WindowSink) Handle(metric string, tags Tags, value float64, metricType metricType) error {
+	return sink.HandleAt(metric, tags, value, metricType, time.Time{})
+}
+
+// HandleAt records the metric in the second of at, if it is set and within the retention, and passes
+// it on to dst. Metrics from before the retention or from the future are only passed on.
+func (sink *WindowSink) HandleAt(metric string, tags Tags, value float64, metricType metricType, at time.Time) error {
+	if len(metric) == 0 {
+		return sink.stats.serializationError(errors.New("cannot handle empty metric"))
+	}
+
+	now := sink.now().Unix()
+	second := now
+	if !at.IsZero() {
+		second = at.Unix()
+	}
+
+	if second > now-sink.retention && second <= now {
+		sink.mutex.Lock()
+		if s := sink.seriesOf(metric, metricType, now); s != nil {
+			s.add(second, value)
+		}
+		sink.mutex.Unlock()
+	}
+
+	return handleAt(sink.dst, metric, tags, value, metricType, at)
+}
+
+// HandleHistogram records the values of the histogram of the stat metric, and passes it on to dst.
+func (sink *WindowSink) HandleHistogram(metric string, tags Tags, h *Histogram) error {
+	if len(metric) == 0 {
+		return sink.stats.serializationError(errors.New("cannot handle empty metric"))
+	}
+
+	second := sink.now().Unix()
+
+	sink.mutex.Lock()
+	if s := sink.seriesOf(metric, metricTypeStat, second); s != nil {
+		s.addHistogram(second, h)
+	}
+	sink.mutex.Unlock()
+
+	return handleHistogram(sink.dst, metric, tags, h)
+}
+
+func (sink *WindowSink) handlesHistograms() bool {
+	return HandlesHistograms(sink.dst)
+}
+
+// seriesOf returns the series of metric, creating it if needed, or nil if there are already maxSeries
+// series recorded during the retention. sink.mutex must be held.
+func (sink *WindowSink) seriesOf(metric string, metricType metricType, now int64) *windowSeries {
+	if s, ok := sink.series[metric]; ok {
+		return s
+	}
+	if len(sink.series) >= sink.maxSeries {
+		// sweep at most once a second, so that a flood of new metrics does not scan the series every time
+		if now == sink.sweptAt {
+			return nil
+		}
+		sink.sweptAt = now
+		for name, s := range sink.series {
+			if s.latest <= now-sink.retention {
+				delete(sink.series, name)
+			}
+		}
+		if len(sink.series) >= sink.maxSeries {
+			return nil
+		}
+	}
+	s := &windowSeries{
+		metricType: metricType,
+		buckets:    make([]windowBucket, sink.retention),
+	}
+	sink.series[metric] = s
+	return s
+}
+
+func (sink *WindowSink) Flush() error {
+	return sink.dst.Flush()
+}
+
+// SinkStats counts the metrics without a name, along with the stats of dst.
+func (sink *WindowSink) SinkStats() SinkStats {
+	return sink.stats.get().Add(SinkStatsOf(sink.dst))
+}
+
+// Snapshot returns the snapshot of dst.
+func (sink *WindowSink) Snapshot() Snapshot {
+	return SnapshotOf(sink.dst)
+}
+
+func (sink *WindowSink) Close() {
+	sink.dst.Close()
+}
+
+// Stats returns a summary of the values recorded for metric during the last window.
+// The boolean is false if the metric has never been recorded.
+func (sink *WindowSink) Stats(metric string, window time.Duration) (WindowStats, bool) {
+	to := sink.now().Unix()
+	from := to - int64(window/time.Second) + 1
+
+	sink.mutex.RLock()
+	defer sink.mutex.RUnlock()
+
+	s, ok := sink.series[metric]
+	if !ok {
+		return WindowStats{}, false
+	}
+	return s.stats(from, to), true
+}
+
+func (sink *WindowSink) metricType(metric string) (metricType, bool) {
+	sink.mutex.RLock()
+	defer sink.mutex.RUnlock()
+
+	s, ok := sink.series[metric]
+	if !ok {
+		return "", false
+	}
+	return s.metricType, true
+}
+
+// Check evaluates a condition expression (see ParseCondition) against the recent history.
+func (sink *WindowSink) Check(expr string) (bool, error) {
+	cond, err := ParseCondition(expr)
+	if err != nil {
+		return false, err
+	}
+	return cond.Eval(sink)
+}
+
+// HealthCheck parses expr and returns a function that returns an error whenever the
+// condition does not hold. It is meant to be registered as a readiness check.
+func (sink *WindowSink) HealthCheck(expr string) (func() error, error) {
+	cond, err := ParseCondition(expr)
+	if err != nil {
+		return nil, err
+	}
+	return func() error {
+		ok, err := cond.Eval(sink)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("condition failed: %s", expr)
+		}
+		return nil
+	}, nil
+}
@@ -0,0 +1,109 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestWindowSink(retention time.Duration) (*WindowSink, *time.Time) {
+	now := time.Unix(1000, 0)
+	sink := NewWindowSink(NullSink, retention)
+	sink.now = func() time.Time { return now }
+	return sink, &now
+}
+
+func TestWindowSinkStats(t *testing.T) {
+	sink, now := newTestWindowSink(time.Minute)
+
+	sink.Handle("latency", nil, 10, metricTypeStat)
+	sink.Handle("latency", nil, 30, metricTypeStat)
+	*now = now.Add(5 * time.Second)
+	sink.Handle("latency", nil, 20, metricTypeStat)
+
+	stats, ok := sink.Stats("latency", time.Minute)
+	assert.True(t, ok)
+	assert.Equal(t, WindowStats{Count: 3, Sum: 60, Min: 10, Max: 30, Last: 20}, stats)
+
+	stats, _ = sink.Stats("latency", 2*time.Second)
+	assert.Equal(t, WindowStats{Count: 1, Sum: 20, Min: 20, Max: 20, Last: 20}, stats)
+
+	_, ok = sink.Stats("missing", time.Minute)
+	assert.False(t, ok)
+}
+
+func TestWindowSinkExpires(t *testing.T) {
+	sink, now := newTestWindowSink(10 * time.Second)
+
+	sink.Handle("requests", nil, 1, metricTypeCounter)
+	*now = now.Add(10 * time.Second)
+	sink.Handle("requests", nil, 1, metricTypeCounter)
+
+	stats, _ := sink.Stats("requests", 10*time.Second)
+	assert.Equal(t, 1.0, stats.Sum)
+}
+
+func TestWindowSinkForwards(t *testing.T) {
+	dst := NewMockSink()
+	sink := NewWindowSink(dst, time.Minute)
+	sink.Handle("requests", Tags{"a": "b"}, 1, metricTypeCounter)
+	assert.Equal(t, 1, dst.NumInvocations())
+}
+
+func TestWindowSinkHandleAt(t *testing.T) {
+	sink, now := newTestWindowSink(10 * time.Second)
+
+	sink.HandleAt("requests", nil, 1, metricTypeCounter, now.Add(-5*time.Second))
+	sink.HandleAt("requests", nil, 2, metricTypeCounter, now.Add(-10*time.Second))
+	sink.HandleAt("requests", nil, 4, metricTypeCounter, now.Add(time.Second))
+
+	stats, _ := sink.Stats("requests", 10*time.Second)
+	assert.Equal(t, 1.0, stats.Sum, "points before the retention or from the future are not recorded")
+	stats, _ = sink.Stats("requests", 5*time.Second)
+	assert.Equal(t, 0.0, stats.Sum)
+}
+
+func TestWindowSinkMaxSeries(t *testing.T) {
+	dst := NewMockSink()
+	sink := NewWindowSinkWithMaxSeries(dst, 10*time.Second, 2)
+	now := time.Unix(1000, 0)
+	sink.now = func() time.Time { return now }
+
+	sink.Handle("a", nil, 1, metricTypeCounter)
+	sink.Handle("b", nil, 1, metricTypeCounter)
+	sink.Handle("c", nil, 1, metricTypeCounter)
+	_, ok := sink.Stats("c", 10*time.Second)
+	assert.False(t, ok)
+	assert.Equal(t, 3, dst.NumInvocations(), "metrics beyond the limit are still passed on")
+
+	now = now.Add(5 * time.Second)
+	sink.Handle("a", nil, 1, metricTypeCounter)
+	now = now.Add(5 * time.Second)
+	sink.Handle("c", nil, 1, metricTypeCounter)
+	_, ok = sink.Stats("c", 10*time.Second)
+	assert.True(t, ok, "c replaces b, which expired")
+	_, ok = sink.Stats("b", 10*time.Second)
+	assert.False(t, ok)
+	_, ok = sink.Stats("a", 10*time.Second)
+	assert.True(t, ok)
+}
+
+func TestWindowSinkHealthCheck(t *testing.T) {
+	sink, _ := newTestWindowSink(time.Minute)
+
+	check, err := sink.HealthCheck("errors / requests over 1m < 5%")
+	assert.NoError(t, err)
+	assert.NoError(t, check())
+
+	sink.Handle("requests", nil, 10, metricTypeCounter)
+	assert.NoError(t, check())
+
+	sink.Handle("errors", nil, 1, metricTypeCounter)
+	assert.Error(t, check())
+
+	_, err = sink.HealthCheck("errors over 2m < 5")
+	assert.NoError(t, err)
+	_, err = sink.Check("errors over 2m < 5")
+	assert.Error(t, err)
+}
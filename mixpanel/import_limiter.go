@@ -0,0 +1,48 @@
+package mixpanel
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultImportRate is the number of events per second sent by ImportBatched by default, below the
+	// import quota of a project, which is about 30000 events per second.
+	DefaultImportRate = 20000
+	// MaxImportRetries is the number of times ImportBatched sends a batch again when the quota is exceeded.
+	MaxImportRetries = 5
+)
+
+// importLimiter paces batches of events, so that at most rate events are sent per second on average.
+type importLimiter struct {
+	rate  float64
+	now   func() time.Time
+	sleep func(time.Duration)
+
+	mutex sync.Mutex // guards next
+	// next is when the next batch may be sent.
+	next time.Time
+}
+
+func newImportLimiter(eventsPerSecond float64) *importLimiter {
+	if eventsPerSecond <= 0 {
+		eventsPerSecond = DefaultImportRate
+	}
+	return &importLimiter{rate: eventsPerSecond, now: time.Now, sleep: time.Sleep}
+}
+
+// wait blocks until a batch of n events may be sent.
+func (l *importLimiter) wait(n int) {
+	l.mutex.Lock()
+	now := l.now()
+	start := l.next
+	if start.Before(now) {
+		start = now
+	}
+	l.next = start.Add(time.Duration(float64(n) / l.rate * float64(time.Second)))
+	l.mutex.Unlock()
+
+	if d := start.Sub(now); d > 0 {
+		l.sleep(d)
+	}
+}
@@ -0,0 +1,128 @@
+package mixpanel
+
+import (
+	"net/url"
+	"sync"
+	"time"
+)
+
+// MockClient is a Client that records events in memory instead of sending them, for use in tests.
+// Events are validated like the real client does, so invalid events fail in tests as well.
+type MockClient struct {
+	// Err, when set, is returned by every call and no event is recorded.
+	Err error
+
+	mutex    sync.Mutex
+	tracked  []*TrackedEvent
+	imported []*TrackedEvent
+	engaged  []*ProfileUpdate
+}
+
+// NewMockClient returns an empty MockClient.
+func NewMockClient() *MockClient {
+	return &MockClient{}
+}
+
+func (m *MockClient) Track(e *TrackedEvent) error {
+	return m.TrackBatched([]*TrackedEvent{e})
+}
+
+func (m *MockClient) TrackBatched(es []*TrackedEvent) error {
+	return m.record(&m.tracked, es)
+}
+
+func (m *MockClient) Import(es []*TrackedEvent) error {
+	return m.record(&m.imported, es)
+}
+
+// ImportBatched records es as imported, stamping the events without a Time with ts.
+func (m *MockClient) ImportBatched(es []*TrackedEvent, ts time.Time) error {
+	for _, e := range es {
+		if e != nil && e.Time.IsZero() {
+			e.Time = ts
+		}
+	}
+	return m.record(&m.imported, es)
+}
+
+func (m *MockClient) UrlWithTracking(e *TrackedEvent, dest string) (*url.URL, error) {
+	if err := m.Track(e); err != nil {
+		return nil, err
+	}
+	return url.Parse(dest)
+}
+
+// Engage records updates, validated like the real client does.
+func (m *MockClient) Engage(updates []*ProfileUpdate) error {
+	if m.Err != nil {
+		return m.Err
+	}
+	for _, u := range updates {
+		if err := ValidateProfileUpdate(u); err != nil {
+			return err
+		}
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.engaged = append(m.engaged, updates...)
+	return nil
+}
+
+// Alias records the $create_alias event the real client sends as tracked.
+func (m *MockClient) Alias(distinctID, alias string) error {
+	e, err := aliasEvent(distinctID, alias)
+	if err != nil {
+		return err
+	}
+	return m.Track(e)
+}
+
+func (m *MockClient) record(dst *[]*TrackedEvent, es []*TrackedEvent) error {
+	if m.Err != nil {
+		return m.Err
+	}
+	for _, e := range es {
+		if err := ValidateEvent(e); err != nil {
+			return err
+		}
+		if e.Time.IsZero() {
+			e.Time = time.Now()
+		}
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	*dst = append(*dst, es...)
+	return nil
+}
+
+// Tracked returns the events sent with Track, TrackBatched and UrlWithTracking so far.
+func (m *MockClient) Tracked() []*TrackedEvent {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return append([]*TrackedEvent(nil), m.tracked...)
+}
+
+// Imported returns the events sent with Import so far.
+func (m *MockClient) Imported() []*TrackedEvent {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return append([]*TrackedEvent(nil), m.imported...)
+}
+
+// Engaged returns the profile updates sent with Engage so far.
+func (m *MockClient) Engaged() []*ProfileUpdate {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return append([]*ProfileUpdate(nil), m.engaged...)
+}
+
+// Reset forgets all recorded events and profile updates.
+func (m *MockClient) Reset() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.tracked = nil
+	m.imported = nil
+	m.engaged = nil
+}
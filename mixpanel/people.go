@@ -0,0 +1,154 @@
+package mixpanel
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+)
+
+// MaxEngageBatchSize is the maximum number of profile updates accepted by a single /engage request.
+const MaxEngageBatchSize = 50
+
+// Operations of profile updates.
+const (
+	// ProfileSet sets properties of the profile, replacing their values.
+	ProfileSet = "$set"
+	// ProfileSetOnce sets properties of the profile that are not set yet.
+	ProfileSetOnce = "$set_once"
+	// ProfileAdd adds numbers to properties of the profile, which are considered 0 when they are not
+	// set.
+	ProfileAdd = "$add"
+)
+
+// PeopleClient updates user profiles with the Mixpanel Engage API. The clients returned by NewClient
+// implement it, as do Null and MockClient.
+type PeopleClient interface {
+	// Engage sends updates, split into batches of at most MaxEngageBatchSize like TrackBatched does.
+	Engage(updates []*ProfileUpdate) error
+	// Alias makes alias another distinct ID of the user identified by distinctID, so that events and
+	// updates sent with either end up in the same profile.
+	Alias(distinctID, alias string) error
+}
+
+// ProfileUpdate is an operation on the profile of a user.
+type ProfileUpdate struct {
+	DistinctID string
+	// Operation is ProfileSet, ProfileSetOnce or ProfileAdd.
+	Operation  string
+	Properties map[string]interface{}
+}
+
+// NewPeopleClient returns a PeopleClient updating the profiles of the project of token. opts configure
+// it as they do NewClient.
+func NewPeopleClient(token, baseUrl string, opts ...ClientOption) PeopleClient {
+	return NewClient(token, "", baseUrl, opts...).(*client)
+}
+
+// ValidateProfileUpdate checks that u can be ingested by Mixpanel: it must have a distinct ID, a known
+// operation, and properties like those of events, which must be numbers for ProfileAdd.
+func ValidateProfileUpdate(u *ProfileUpdate) error {
+	if u == nil {
+		return fmt.Errorf("profile update cannot be nil")
+	}
+	if u.DistinctID == "" {
+		return fmt.Errorf("DistinctID of profile update cannot be empty")
+	}
+	switch u.Operation {
+	case ProfileSet, ProfileSetOnce, ProfileAdd:
+	default:
+		return fmt.Errorf("profile update of %s has unknown operation %q", u.DistinctID, u.Operation)
+	}
+	if len(u.Properties) > MaxProperties {
+		return fmt.Errorf("profile update of %s has %d properties, at most %d are allowed", u.DistinctID, len(u.Properties), MaxProperties)
+	}
+	for k, v := range u.Properties {
+		if k == "" || len(k) > MaxNameLength {
+			return fmt.Errorf("profile update of %s has a property with an empty name or longer than %d characters", u.DistinctID, MaxNameLength)
+		}
+		if u.Operation == ProfileAdd {
+			switch v.(type) {
+			case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64, json.Number:
+			default:
+				return fmt.Errorf("profile update of %s adds %T to property %s, which is not a number", u.DistinctID, v, k)
+			}
+		}
+		if err := validateValue(v); err != nil {
+			return fmt.Errorf("profile update of %s has invalid property %s: %v", u.DistinctID, k, err)
+		}
+	}
+	return nil
+}
+
+func (c *client) Engage(updates []*ProfileUpdate) error {
+	if len(c.token) == 0 {
+		return fmt.Errorf("token is empty")
+	}
+	for _, u := range updates {
+		if err := ValidateProfileUpdate(u); err != nil {
+			return err
+		}
+	}
+
+	for len(updates) > MaxEngageBatchSize {
+		if err := c.engageBatch(updates[:MaxEngageBatchSize]); err != nil {
+			return err
+		}
+		updates = updates[MaxEngageBatchSize:]
+	}
+	return c.engageBatch(updates)
+}
+
+func (c *client) engageBatch(batch []*ProfileUpdate) error {
+	data, err := c.encodeUpdates(batch)
+	if err != nil {
+		return err
+	}
+	params := make(url.Values)
+	params.Set("data", data)
+	return c.post("engage", params, "")
+}
+
+func (c *client) Alias(distinctID, alias string) error {
+	e, err := aliasEvent(distinctID, alias)
+	if err != nil {
+		return err
+	}
+	return c.Track(e)
+}
+
+// aliasEvent returns the event making alias another distinct ID of distinctID.
+func aliasEvent(distinctID, alias string) (*TrackedEvent, error) {
+	if distinctID == "" || alias == "" {
+		return nil, fmt.Errorf("distinct ID and alias cannot be empty")
+	}
+	return &TrackedEvent{
+		EventName:  "$create_alias",
+		DistinctID: distinctID,
+		Properties: map[string]interface{}{"alias": alias},
+	}, nil
+}
+
+func (c *client) encodeUpdates(updates []*ProfileUpdate) (string, error) {
+	list := make([]map[string]interface{}, len(updates))
+	for i, u := range updates {
+		list[i] = map[string]interface{}{
+			"$token":       c.token,
+			"$distinct_id": u.DistinctID,
+			u.Operation:    u.Properties,
+		}
+	}
+	encoded, err := json.Marshal(list)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(encoded), nil
+}
+
+func (n *Null) Engage([]*ProfileUpdate) error {
+	return nil
+}
+
+func (n *Null) Alias(string, string) error {
+	return nil
+}
@@ -0,0 +1,83 @@
+package mixpanel
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEngage(t *testing.T) {
+	var wg sync.WaitGroup
+	wg.Add(3)
+	server := newTestServer(&wg)
+	defer server.httpServer.Close()
+	c := NewPeopleClient("some_token", server.httpServer.URL)
+
+	updates := []*ProfileUpdate{{DistinctID: "user", Operation: ProfileAdd, Properties: map[string]interface{}{"queries": 1}}}
+	for i := 0; i < MaxEngageBatchSize; i++ {
+		updates = append(updates, &ProfileUpdate{DistinctID: "user", Operation: ProfileSetOnce, Properties: map[string]interface{}{"plan": "free"}})
+	}
+	assert.NoError(t, c.Engage(updates))
+	assert.NoError(t, c.Alias("user", "user@example.com"))
+	wg.Wait()
+
+	var batches [][]map[string]interface{}
+	for _, body := range server.requests[:2] {
+		params, err := url.ParseQuery(string(body))
+		assert.NoError(t, err)
+		decoded, err := base64.StdEncoding.DecodeString(params.Get("data"))
+		assert.NoError(t, err)
+		var batch []map[string]interface{}
+		assert.NoError(t, json.Unmarshal(decoded, &batch))
+		batches = append(batches, batch)
+	}
+	if assert.Len(t, batches[0], MaxEngageBatchSize) && assert.Len(t, batches[1], 1) {
+		assert.Equal(t, map[string]interface{}{
+			"$token":       "some_token",
+			"$distinct_id": "user",
+			"$add":         map[string]interface{}{"queries": float64(1)},
+		}, batches[0][0])
+		assert.Equal(t, map[string]interface{}{"plan": "free"}, batches[1][0]["$set_once"])
+	}
+
+	params, _ := url.ParseQuery(string(server.requests[2]))
+	decoded, _ := base64.StdEncoding.DecodeString(params.Get("data"))
+	testEvents(t, decoded, []*TrackedEvent{{EventName: "$create_alias", Properties: map[string]interface{}{
+		"distinct_id": "user",
+		"alias":       "user@example.com",
+	}}}, "some_token", "")
+
+	assert.Error(t, c.Alias("user", ""))
+}
+
+func TestValidateProfileUpdate(t *testing.T) {
+	assert.NoError(t, ValidateProfileUpdate(&ProfileUpdate{DistinctID: "u", Operation: ProfileSet, Properties: map[string]interface{}{"plan": "free"}}))
+	for name, u := range map[string]*ProfileUpdate{
+		"nil":        nil,
+		"no id":      {Operation: ProfileSet},
+		"unknown op": {DistinctID: "u", Operation: "$merge"},
+		"add string": {DistinctID: "u", Operation: ProfileAdd, Properties: map[string]interface{}{"plan": "free"}},
+		"empty name": {DistinctID: "u", Operation: ProfileSet, Properties: map[string]interface{}{"": 1}},
+		"bad value":  {DistinctID: "u", Operation: ProfileSet, Properties: map[string]interface{}{"f": func() {}}},
+	} {
+		assert.Error(t, ValidateProfileUpdate(u), name)
+	}
+}
+
+func TestMockClientPeople(t *testing.T) {
+	mock := NewMockClient()
+	var c PeopleClient = mock
+	assert.NoError(t, c.Engage([]*ProfileUpdate{{DistinctID: "u", Operation: ProfileSet}}))
+	assert.Error(t, c.Engage([]*ProfileUpdate{{DistinctID: "u"}}))
+	assert.NoError(t, c.Alias("u", "alias"))
+	assert.Len(t, mock.Engaged(), 1)
+	if assert.Len(t, mock.Tracked(), 1) {
+		assert.Equal(t, "$create_alias", mock.Tracked()[0].EventName)
+	}
+	mock.Reset()
+	assert.Empty(t, mock.Engaged())
+}
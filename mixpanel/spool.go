@@ -0,0 +1,305 @@
+package mixpanel
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultSpoolMaxBytes      = 64 << 20
+	defaultSpoolRetryInterval = 30 * time.Second
+
+	spoolSuffix = ".batch"
+)
+
+// SpoolOption configures a SpoolingClient.
+type SpoolOption func(*SpoolingClient)
+
+// SpoolMaxBytes bounds the size of the spool directory. Beyond it, the oldest batches are evicted.
+// Defaults to 64MiB.
+func SpoolMaxBytes(n int64) SpoolOption {
+	return func(s *SpoolingClient) {
+		s.maxBytes = n
+	}
+}
+
+// SpoolRetryInterval sets how often spooled batches are replayed. Defaults to 30 seconds.
+func SpoolRetryInterval(d time.Duration) SpoolOption {
+	return func(s *SpoolingClient) {
+		s.retryInterval = d
+	}
+}
+
+// spooledBatch is the content of a spool file.
+type spooledBatch struct {
+	Import bool            `json:"import,omitempty"`
+	Events []*TrackedEvent `json:"events"`
+}
+
+// SpoolingClient is a Client that persists the batches it fails to send to a directory, and replays them
+// in order once Mixpanel is reachable again, so that events such as the counts of a topk.ProjectTracker
+// survive outages and restarts. Each batch is written to its own file, and the oldest are evicted when the
+// directory grows beyond its maximum size.
+//
+// Events are validated before being sent, and invalid events are rejected rather than spooled. Batches are
+// sent in chunks of at most MaxTrackBatchSize or MaxImportBatchSize events, so that a failure spools only
+// the chunks that were not sent.
+type SpoolingClient struct {
+	client        Client
+	dir           string
+	maxBytes      int64
+	retryInterval time.Duration
+
+	mutex sync.Mutex // serializes spool writes and evictions
+	seq   int64
+
+	// replayMutex serializes replays, so that batches are sent once and in order. It is not held
+	// while spooling, so that sending new events does not wait for a replay to post.
+	replayMutex sync.Mutex
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewSpoolingClient returns a SpoolingClient sending events with client, and spooling them to dir, which
+// is created if needed. Batches spooled by a previous process are replayed as well.
+func NewSpoolingClient(client Client, dir string, opts ...SpoolOption) (*SpoolingClient, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	s := &SpoolingClient{
+		client:        client,
+		dir:           dir,
+		maxBytes:      defaultSpoolMaxBytes,
+		retryInterval: defaultSpoolRetryInterval,
+		done:          make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	s.wg.Add(1)
+	go s.replayLoop()
+	return s, nil
+}
+
+func (s *SpoolingClient) Track(e *TrackedEvent) error {
+	return s.TrackBatched([]*TrackedEvent{e})
+}
+
+func (s *SpoolingClient) TrackBatched(es []*TrackedEvent) error {
+	return s.send(es, false)
+}
+
+func (s *SpoolingClient) Import(es []*TrackedEvent) error {
+	return s.send(es, true)
+}
+
+// ImportBatched stamps the events without a Time with ts and imports them like Import. Unlike the
+// ImportBatched of a client, batches are neither paced nor retried, as the ones that fail are spooled
+// and replayed later.
+func (s *SpoolingClient) ImportBatched(es []*TrackedEvent, ts time.Time) error {
+	for _, e := range es {
+		if e != nil && e.Time.IsZero() {
+			e.Time = ts
+		}
+	}
+	return s.send(es, true)
+}
+
+func (s *SpoolingClient) UrlWithTracking(e *TrackedEvent, dest string) (*url.URL, error) {
+	return s.client.UrlWithTracking(e, dest)
+}
+
+// send sends es, and spools the chunks that fail. It only returns an error if es are invalid, are
+// rejected by Mixpanel, or cannot be spooled.
+func (s *SpoolingClient) send(es []*TrackedEvent, isImport bool) error {
+	size := MaxTrackBatchSize
+	if isImport {
+		size = MaxImportBatchSize
+	}
+	for _, e := range es {
+		if err := ValidateEvent(e); err != nil {
+			return err
+		}
+		if e.Time.IsZero() {
+			// stamp events now, rather than when they are replayed
+			e.Time = time.Now()
+		}
+	}
+
+	return batches(es, size, func(batch []*TrackedEvent) error {
+		if err := s.post(batch, isImport); err != nil {
+			if rejected(err) {
+				return err
+			}
+			log.Printf("error while sending events to mixpanel, spooling them: %v", err)
+			return s.spool(spooledBatch{Import: isImport, Events: batch})
+		}
+		return nil
+	})
+}
+
+func (s *SpoolingClient) post(es []*TrackedEvent, isImport bool) error {
+	if isImport {
+		return s.client.Import(es)
+	}
+	return s.client.TrackBatched(es)
+}
+
+// spool writes batch to a new file, named so that files sort in the order they were written.
+func (s *SpoolingClient) spool(batch spooledBatch) error {
+	data, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.seq++
+	name := fmt.Sprintf("%020d-%06d%s", time.Now().UnixNano(), s.seq%1000000, spoolSuffix)
+	tmp := filepath.Join(s.dir, "."+name)
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(s.dir, name)); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	s.evict()
+	return nil
+}
+
+// spooled returns the spool files, oldest first. It must be called with the mutex held.
+func (s *SpoolingClient) spooled() ([]os.FileInfo, error) {
+	infos, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	files := infos[:0]
+	for _, info := range infos {
+		if !info.IsDir() && strings.HasSuffix(info.Name(), spoolSuffix) && !strings.HasPrefix(info.Name(), ".") {
+			files = append(files, info)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name() < files[j].Name() })
+	return files, nil
+}
+
+// evict removes the oldest files until the spool fits in maxBytes. It must be called with the mutex held.
+func (s *SpoolingClient) evict() {
+	files, err := s.spooled()
+	if err != nil {
+		log.Printf("error while listing mixpanel spool: %v", err)
+		return
+	}
+	var total int64
+	for _, f := range files {
+		total += f.Size()
+	}
+	for _, f := range files {
+		if total <= s.maxBytes {
+			return
+		}
+		log.Printf("mixpanel spool is larger than %d bytes, evicting %s", s.maxBytes, f.Name())
+		if err := os.Remove(filepath.Join(s.dir, f.Name())); err == nil {
+			total -= f.Size()
+		}
+	}
+}
+
+// Replay sends the spooled batches in order, until one fails. Sent batches are removed from the spool,
+// and so are the batches Mixpanel rejects with a 4xx status other than 429 Too Many Requests, which
+// would be rejected again, so that they do not hold back the batches spooled after them.
+func (s *SpoolingClient) Replay() error {
+	s.replayMutex.Lock()
+	defer s.replayMutex.Unlock()
+
+	s.mutex.Lock()
+	files, err := s.spooled()
+	s.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		path := filepath.Join(s.dir, f.Name())
+		data, err := ioutil.ReadFile(path)
+		if os.IsNotExist(err) {
+			// evicted since it was listed
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		var batch spooledBatch
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		// keeps integer properties, such as ids, from being sent as floats
+		decoder.UseNumber()
+		if err := decoder.Decode(&batch); err != nil {
+			log.Printf("error while decoding spooled mixpanel batch %s, dropping it: %v", f.Name(), err)
+			os.Remove(path)
+			continue
+		}
+		if err := s.post(batch.Events, batch.Import); err != nil {
+			if !rejected(err) {
+				return err
+			}
+			log.Printf("spooled mixpanel batch %s was rejected, dropping it: %v", f.Name(), err)
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// rejected reports whether err is a response of Mixpanel with a 4xx status other than 429 Too Many
+// Requests, meaning that the request would fail again.
+func rejected(err error) bool {
+	for err != nil {
+		if status, ok := err.(interface{ HTTPStatus() int }); ok {
+			code := status.HTTPStatus()
+			return code >= 400 && code < 500 && code != http.StatusTooManyRequests
+		}
+		wrapper, ok := err.(interface{ Unwrap() error })
+		if !ok {
+			return false
+		}
+		err = wrapper.Unwrap()
+	}
+	return false
+}
+
+func (s *SpoolingClient) replayLoop() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(s.retryInterval)
+	defer ticker.Stop()
+	for {
+		if err := s.Replay(); err != nil {
+			log.Printf("error while replaying spooled mixpanel events: %v", err)
+		}
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Close stops replaying spooled batches. They are replayed by the next SpoolingClient using the same directory.
+func (s *SpoolingClient) Close() {
+	close(s.done)
+	s.wg.Wait()
+}
@@ -0,0 +1,124 @@
+package mixpanel
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestSpool(t *testing.T, client Client, opts ...SpoolOption) (*SpoolingClient, string) {
+	dir, err := ioutil.TempDir("", "spool")
+	assert.NoError(t, err)
+	s, err := NewSpoolingClient(client, dir, append([]SpoolOption{SpoolRetryInterval(time.Hour)}, opts...)...)
+	assert.NoError(t, err)
+	return s, dir
+}
+
+func TestSpoolingClient(t *testing.T) {
+	mock := NewMockClient()
+	mock.Err = errors.New("unreachable")
+	s, dir := newTestSpool(t, mock)
+	defer os.RemoveAll(dir)
+
+	events := make([]*TrackedEvent, MaxTrackBatchSize+1)
+	for i := range events {
+		events[i] = &TrackedEvent{EventName: "counts", Properties: map[string]interface{}{"project_id": int32(i)}}
+	}
+	assert.NoError(t, s.TrackBatched(events))
+	assert.NoError(t, s.Import([]*TrackedEvent{{EventName: "old", Time: time.Unix(1000, 0)}}))
+	assert.Error(t, s.Track(&TrackedEvent{}), "invalid events are not spooled")
+	s.Close()
+
+	files, err := ioutil.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, files, 3)
+
+	// a new client replays the batches spooled by the previous one
+	mock.Err = nil
+	s, err = NewSpoolingClient(mock, dir, SpoolRetryInterval(time.Hour))
+	assert.NoError(t, err)
+	assert.NoError(t, s.Replay())
+	s.Close()
+
+	tracked := mock.Tracked()
+	if assert.Len(t, tracked, len(events)) {
+		assert.Equal(t, json.Number("0"), tracked[0].Properties["project_id"])
+		assert.Equal(t, json.Number("50"), tracked[len(tracked)-1].Properties["project_id"])
+		assert.Equal(t, events[0].Time.Unix(), tracked[0].Time.Unix())
+	}
+	if imported := mock.Imported(); assert.Len(t, imported, 1) {
+		assert.Equal(t, "old", imported[0].EventName)
+		assert.Equal(t, int64(1000), imported[0].Time.Unix())
+	}
+	files, err = ioutil.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Empty(t, files)
+}
+
+func TestSpoolingClientEviction(t *testing.T) {
+	mock := NewMockClient()
+	mock.Err = errors.New("unreachable")
+	s, dir := newTestSpool(t, mock, SpoolMaxBytes(500))
+	defer os.RemoveAll(dir)
+	defer s.Close()
+
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, s.Track(&TrackedEvent{EventName: "event", Properties: map[string]interface{}{"i": i}}))
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	assert.NoError(t, err)
+	var total int64
+	for _, f := range files {
+		total += f.Size()
+	}
+	assert.True(t, total <= 500)
+	assert.True(t, len(files) < 10)
+
+	mock.Err = nil
+	assert.NoError(t, s.Replay())
+	tracked := mock.Tracked()
+	if assert.Len(t, tracked, len(files)) {
+		// the oldest events were evicted
+		assert.Equal(t, json.Number("9"), tracked[len(tracked)-1].Properties["i"])
+	}
+}
+
+// rejectingClient rejects the batches of events named bad, as Mixpanel does with invalid events.
+type rejectingClient struct {
+	*MockClient
+}
+
+func (c rejectingClient) TrackBatched(es []*TrackedEvent) error {
+	if es[0].EventName == "bad" {
+		return &statusError{endpoint: "track", status: "400 Bad Request", code: 400}
+	}
+	return c.MockClient.TrackBatched(es)
+}
+
+func TestSpoolingClientRejected(t *testing.T) {
+	mock := NewMockClient()
+	mock.Err = errors.New("unreachable")
+	s, dir := newTestSpool(t, rejectingClient{mock})
+	defer os.RemoveAll(dir)
+	defer s.Close()
+
+	assert.Error(t, s.Track(&TrackedEvent{EventName: "bad"}), "rejected events are not spooled")
+	// spooled before Mixpanel started rejecting them
+	assert.NoError(t, s.spool(spooledBatch{Events: []*TrackedEvent{{EventName: "bad"}}}))
+	assert.NoError(t, s.Track(&TrackedEvent{EventName: "good"}))
+
+	mock.Err = nil
+	assert.NoError(t, s.Replay(), "rejected batches do not hold back the next ones")
+	if tracked := mock.Tracked(); assert.Len(t, tracked, 1) {
+		assert.Equal(t, "good", tracked[0].EventName)
+	}
+	files, err := ioutil.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Empty(t, files, "rejected batches are dropped")
+}
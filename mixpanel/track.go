@@ -2,6 +2,8 @@ package mixpanel
 
 import (
 	"bytes"
+	"compress/gzip"
+	"crypto/tls"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
@@ -9,13 +11,21 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"os"
+	"strconv"
 	"time"
+
+	"github.com/mixpanel/obs/faultinject"
+	"github.com/mixpanel/obs/util"
 )
 
+// Client sends events to the Mixpanel ingestion API. Batches larger than the API allows are split
+// into several requests, and events are checked with ValidateEvent before being sent.
 type Client interface {
 	Track(e *TrackedEvent) error
 	TrackBatched(es []*TrackedEvent) error
 	Import(es []*TrackedEvent) error
+	ImportBatched(es []*TrackedEvent, ts time.Time) error
 	UrlWithTracking(e *TrackedEvent, dest string) (*url.URL, error)
 }
 
@@ -33,15 +43,114 @@ func (n *Null) Import([]*TrackedEvent) error {
 	return nil
 }
 
+func (n *Null) ImportBatched([]*TrackedEvent, time.Time) error {
+	return nil
+}
+
 func (n *Null) UrlWithTracking(*TrackedEvent, string) (*url.URL, error) {
 	return &url.URL{}, nil
 }
 
 type client struct {
-	token   string
-	apiKey  string
-	baseUrl string
-	api     *http.Client
+	token     string
+	apiKey    string
+	apiSecret string
+	baseUrl   string
+	api       *http.Client
+	gzip      bool
+	headers   http.Header
+	tlsConfig *tls.Config
+	faults    *faultinject.Injector
+	// importLimiter paces ImportBatched, which is not paced when it is nil.
+	importLimiter *importLimiter
+
+	defaultProperties map[string]interface{}
+}
+
+// ClientOption configures optional behavior of a Client returned by NewClient.
+type ClientOption func(*client)
+
+// WithDefaultProperties merges props into the Properties of every event sent by the client.
+// Properties set on the event itself take precedence over the defaults.
+func WithDefaultProperties(props map[string]interface{}) ClientOption {
+	return func(c *client) {
+		if c.defaultProperties == nil {
+			c.defaultProperties = make(map[string]interface{}, len(props))
+		}
+		for k, v := range props {
+			c.defaultProperties[k] = v
+		}
+	}
+}
+
+// WithEnvironment stamps every event with the environment, service and version it was sent from,
+// as well as the local hostname. Empty values are omitted.
+func WithEnvironment(environment, service, version string) ClientOption {
+	props := make(map[string]interface{}, 4)
+	for k, v := range map[string]string{
+		"environment": environment,
+		"service":     service,
+		"version":     version,
+	} {
+		if v != "" {
+			props[k] = v
+		}
+	}
+	if host, err := os.Hostname(); err == nil {
+		props["host"] = host
+	}
+	return WithDefaultProperties(props)
+}
+
+// WithAPISecret authenticates Import requests with the project's API secret, as required by the
+// current /import endpoint, instead of the legacy API key.
+func WithAPISecret(secret string) ClientOption {
+	return func(c *client) {
+		c.apiSecret = secret
+	}
+}
+
+// WithGzip compresses request bodies, which reduces bandwidth for large batches.
+func WithGzip() ClientOption {
+	return func(c *client) {
+		c.gzip = true
+	}
+}
+
+// WithFaultInjection injects the faults decided by inj into every request the client sends.
+// It is meant for tests.
+func WithFaultInjection(inj *faultinject.Injector) ClientOption {
+	return func(c *client) {
+		c.faults = inj
+	}
+}
+
+// WithTLS sets the TLS configuration of the connections to the API, for instance to trust the
+// certificate authority of a proxy in front of it with RootCAs, or to authenticate to it with a
+// client certificate with Certificates for mutual TLS.
+func WithTLS(cfg *tls.Config) ClientOption {
+	return func(c *client) {
+		c.tlsConfig = cfg
+	}
+}
+
+// WithHeader sets a header sent with every request, such as the API key of a gateway in front of the
+// API.
+func WithHeader(key, value string) ClientOption {
+	return func(c *client) {
+		if c.headers == nil {
+			c.headers = make(http.Header)
+		}
+		c.headers.Set(key, value)
+	}
+}
+
+// WithImportRate sets the number of events per second sent by ImportBatched, DefaultImportRate by
+// default.
+func WithImportRate(eventsPerSecond float64) ClientOption {
+	return func(c *client) {
+		c.importLimiter = newImportLimiter(eventsPerSecond)
+	}
 }
 
 type TrackedEvent struct {
@@ -51,13 +160,27 @@ type TrackedEvent struct {
 	Properties map[string]interface{}
 }
 
-func NewClient(token, apiKey, baseUrl string) Client {
-	return &client{
+func NewClient(token, apiKey, baseUrl string, opts ...ClientOption) Client {
+	c := &client{
 		token:   token,
 		apiKey:  apiKey,
 		baseUrl: baseUrl,
 		api:     &http.Client{},
+
+		importLimiter: newImportLimiter(DefaultImportRate),
+	}
+	for _, o := range opts {
+		o(c)
 	}
+	if c.tlsConfig != nil {
+		transport := util.CloneTransport(nil)
+		transport.TLSClientConfig = c.tlsConfig
+		c.api.Transport = transport
+	}
+	if c.faults != nil {
+		c.api.Transport = c.faults.RoundTripper(c.api.Transport)
+	}
+	return c
 }
 
 func (c *client) TrackBatched(es []*TrackedEvent) error {
@@ -74,71 +197,177 @@ func (c *client) track(es []*TrackedEvent) error {
 	}
 
 	for _, e := range es {
-		if e.Time.IsZero() {
+		if e != nil && e.Time.IsZero() {
 			e.Time = time.Now()
 		}
 	}
 
-	data, err := c.encodeEvent(es)
-	if err != nil {
-		return err
+	return batches(es, MaxTrackBatchSize, func(batch []*TrackedEvent) error {
+		data, err := c.encodeEvent(batch)
+		if err != nil {
+			return err
+		}
+
+		params := make(url.Values)
+		params.Set("data", data)
+		return c.post("track", params, "")
+	})
+}
+
+// Import sends events older than 5 days, which /track rejects. Events without a Time are stamped
+// with the current time. The request is authenticated with the project secret when the client was created WithAPISecret,
+// and with the legacy API key otherwise.
+func (c *client) Import(events []*TrackedEvent) error {
+	if len(c.token) == 0 || (len(c.apiKey) == 0 && len(c.apiSecret) == 0) {
+		return fmt.Errorf("both token and API key or secret must be specified")
+	}
+	for _, e := range events {
+		if e != nil && e.Time.IsZero() {
+			e.Time = time.Now()
+		}
 	}
 
-	params := make(url.Values)
-	params.Set("data", data)
+	return batches(events, MaxImportBatchSize, c.importBatch)
+}
 
-	req, err := http.NewRequest("POST", fmt.Sprintf("%s/track/", c.baseUrl), bytes.NewBufferString(params.Encode()))
-	if err != nil {
-		return err
+// ImportBatched imports events for a backfill: events without a Time are stamped with ts rather than
+// the current time, batches are paced to stay under the import quota of the project (see
+// WithImportRate), and batches rejected because the quota is exceeded are sent again after the delay
+// asked for by the API, up to MaxImportRetries times.
+func (c *client) ImportBatched(events []*TrackedEvent, ts time.Time) error {
+	if len(c.token) == 0 || (len(c.apiKey) == 0 && len(c.apiSecret) == 0) {
+		return fmt.Errorf("both token and API key or secret must be specified")
 	}
-	resp, err := c.api.Do(req)
-	if err != nil {
-		return err
+	if ts.IsZero() {
+		ts = time.Now()
 	}
-	if resp.StatusCode != http.StatusOK {
-		body, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			return err
+	for _, e := range events {
+		if e != nil && e.Time.IsZero() {
+			e.Time = ts
 		}
-		return fmt.Errorf("track returned status %s: %q", resp.Status, string(body))
 	}
-	_, _ = io.Copy(ioutil.Discard, resp.Body)
-	resp.Body.Close()
-	return nil
+
+	return batches(events, MaxImportBatchSize, func(batch []*TrackedEvent) error {
+		for retry := 0; ; retry++ {
+			if c.importLimiter != nil {
+				c.importLimiter.wait(len(batch))
+			}
+			err := c.importBatch(batch)
+			statusErr, ok := err.(*statusError)
+			if !ok || statusErr.code != http.StatusTooManyRequests || retry == MaxImportRetries {
+				return err
+			}
+			delay := statusErr.retryAfter
+			if delay <= 0 {
+				delay = time.Duration(1<<uint(retry)) * time.Second
+			}
+			c.sleep(delay)
+		}
+	})
 }
 
-func (c *client) Import(events []*TrackedEvent) error {
-	if len(c.token) == 0 || len(c.apiKey) == 0 {
-		return fmt.Errorf("both token and API key must be specified")
-	}
-	data, err := c.encodeEvent(events)
+func (c *client) importBatch(batch []*TrackedEvent) error {
+	data, err := c.encodeEvent(batch)
 	if err != nil {
 		return err
 	}
 
 	params := make(url.Values)
 	params.Set("data", data)
-	params.Set("api_key", c.apiKey)
-	req, err := http.NewRequest("POST", fmt.Sprintf("%s/import/", c.baseUrl), bytes.NewBufferString(params.Encode()))
+	if len(c.apiSecret) == 0 {
+		params.Set("api_key", c.apiKey)
+	}
+	return c.post("import", params, c.apiSecret)
+}
+
+func (c *client) sleep(d time.Duration) {
+	if c.importLimiter != nil {
+		c.importLimiter.sleep(d)
+		return
+	}
+	time.Sleep(d)
+}
+
+// batches calls send with consecutive slices of at most size events, stopping at the first error.
+func batches(es []*TrackedEvent, size int, send func([]*TrackedEvent) error) error {
+	for len(es) > size {
+		if err := send(es[:size]); err != nil {
+			return err
+		}
+		es = es[size:]
+	}
+	return send(es)
+}
+
+// post sends params as a form to endpoint, authenticated with secret unless it is empty.
+func (c *client) post(endpoint string, params url.Values, secret string) error {
+	var body bytes.Buffer
+	if c.gzip {
+		w := gzip.NewWriter(&body)
+		if _, err := io.WriteString(w, params.Encode()); err != nil {
+			return err
+		}
+		if err := w.Close(); err != nil {
+			return err
+		}
+	} else {
+		body.WriteString(params.Encode())
+	}
+
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s/%s/", c.baseUrl, endpoint), &body)
 	if err != nil {
 		return err
 	}
+	for k, v := range c.headers {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if c.gzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	if len(secret) > 0 {
+		req.SetBasicAuth(secret, "")
+	}
+
 	resp, err := c.api.Do(req)
 	if err != nil {
 		return err
 	}
+	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
 		body, err := ioutil.ReadAll(resp.Body)
 		if err != nil {
 			return err
 		}
-		return fmt.Errorf("track returned status %s: %q", resp.Status, string(body))
+		statusErr := &statusError{endpoint: endpoint, status: resp.Status, code: resp.StatusCode, body: string(body)}
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			statusErr.retryAfter = time.Duration(seconds) * time.Second
+		}
+		return statusErr
 	}
 	_, _ = io.Copy(ioutil.Discard, resp.Body)
-	resp.Body.Close()
 	return nil
 }
 
+// statusError is returned when the API answers with a status other than 200 OK.
+type statusError struct {
+	endpoint string
+	status   string
+	code     int
+	body     string
+	// retryAfter is the delay asked for by the Retry-After header, if any.
+	retryAfter time.Duration
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("%s returned status %s: %q", e.endpoint, e.status, e.body)
+}
+
+// HTTPStatus returns the status code of the response, by which obserr.IsRetryable classifies e.
+func (e *statusError) HTTPStatus() int {
+	return e.code
+}
+
 func (c *client) UrlWithTracking(event *TrackedEvent, dest string) (*url.URL, error) {
 	if event.Time.IsZero() {
 		event.Time = time.Now()
@@ -167,13 +396,18 @@ func (c *client) UrlWithTracking(event *TrackedEvent, dest string) (*url.URL, er
 func (c *client) encodeEvent(es []*TrackedEvent) (string, error) {
 	var list []map[string]interface{}
 	for _, e := range es {
-		if e.EventName == "" {
-			return "", fmt.Errorf("EventName cannot be empty")
+		if err := ValidateEvent(e); err != nil {
+			return "", err
 		}
 
 		properties := e.Properties
 		if properties == nil {
-			properties = make(map[string]interface{})
+			properties = make(map[string]interface{}, len(c.defaultProperties))
+		}
+		for k, v := range c.defaultProperties {
+			if _, ok := properties[k]; !ok {
+				properties[k] = v
+			}
 		}
 		properties["time"] = e.Time.Unix()
 		if len(e.DistinctID) != 0 {
@@ -1,6 +1,8 @@
 package mixpanel
 
 import (
+	"compress/gzip"
+	"crypto/tls"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
@@ -9,10 +11,12 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/mixpanel/obs/faultinject"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -178,3 +182,202 @@ func TestEncodeEvent(t *testing.T) {
 
 	testEvents(t, decoded, events, "some_token", "")
 }
+
+func TestDefaultProperties(t *testing.T) {
+	c := NewClient("some_token", "", "http://0:0",
+		WithEnvironment("staging", "my-service", ""),
+		WithDefaultProperties(map[string]interface{}{"team": "core"}),
+	).(*client)
+
+	events := getEvents(2)
+	events[1].Properties["environment"] = "override"
+
+	encoded, err := c.encodeEvent(events)
+	assert.Nil(t, err)
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	assert.Nil(t, err)
+
+	var list []struct {
+		Properties map[string]interface{} `json:"properties"`
+	}
+	assert.Nil(t, json.Unmarshal(decoded, &list))
+	assert.Equal(t, "staging", list[0].Properties["environment"])
+	assert.Equal(t, "my-service", list[0].Properties["service"])
+	assert.Equal(t, "core", list[0].Properties["team"])
+	assert.NotEmpty(t, list[0].Properties["host"])
+	assert.NotContains(t, list[0].Properties, "version")
+	assert.Equal(t, "override", list[1].Properties["environment"])
+}
+
+func TestFaultInjection(t *testing.T) {
+	wg := &sync.WaitGroup{}
+	ts := newTestServer(wg)
+	defer ts.httpServer.Close()
+
+	c := NewClient("some_token", "", ts.httpServer.URL,
+		WithFaultInjection(faultinject.New(faultinject.Config{ErrorRate: 1})))
+
+	err := c.Track(&TrackedEvent{EventName: "some_event"})
+	assert.Error(t, err)
+	assert.Empty(t, ts.requests)
+}
+
+func TestTLSAndHeaders(t *testing.T) {
+	headers := make(chan http.Header, 1)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Len(t, r.TLS.PeerCertificates, 1, "the client authenticated with its certificate")
+		headers <- r.Header
+		io.WriteString(w, "1")
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	server.StartTLS()
+	defer server.Close()
+	cfg := server.Client().Transport.(*http.Transport).TLSClientConfig.Clone()
+	cfg.Certificates = server.TLS.Certificates
+
+	c := NewClient("some_token", "", server.URL, WithTLS(cfg), WithHeader("X-Api-Key", "key"))
+	assert.NoError(t, c.Track(&TrackedEvent{EventName: "some_event"}))
+	assert.Equal(t, "key", (<-headers).Get("X-Api-Key"))
+
+	c = NewClient("some_token", "", server.URL,
+		WithFaultInjection(faultinject.New(faultinject.Config{ErrorRate: 1})), WithTLS(cfg))
+	assert.Error(t, c.Track(&TrackedEvent{EventName: "some_event"}), "faults are injected whatever the order")
+}
+
+func TestTrackBatchedSplitsBatches(t *testing.T) {
+	events := getEvents(MaxTrackBatchSize + 1)
+
+	wg := &sync.WaitGroup{}
+	wg.Add(2)
+	ts := newTestServer(wg)
+	defer ts.httpServer.Close()
+
+	client := newClient("some_token", "", ts.httpServer.URL)
+	assert.Nil(t, client.TrackBatched(events))
+	wg.Wait()
+
+	if assert.Equal(t, 2, len(ts.requests)) {
+		testRequestBody(t, ts.requests[0], events[:MaxTrackBatchSize], "some_token", "")
+		testRequestBody(t, ts.requests[1], events[MaxTrackBatchSize:], "some_token", "")
+	}
+}
+
+func TestImportWithSecretAndGzip(t *testing.T) {
+	events := getEvents(2)
+
+	var body []byte
+	var user, encoding string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, _, _ = r.BasicAuth()
+		encoding = r.Header.Get("Content-Encoding")
+		gz, err := gzip.NewReader(r.Body)
+		if assert.Nil(t, err) {
+			body, _ = ioutil.ReadAll(gz)
+		}
+		io.WriteString(w, "1")
+	}))
+	defer server.Close()
+
+	c := NewClient("some_token", "", server.URL, WithAPISecret("some_secret"), WithGzip())
+	assert.Nil(t, c.Import(events))
+
+	assert.Equal(t, "some_secret", user)
+	assert.Equal(t, "gzip", encoding)
+	testRequestBody(t, body, events, "some_token", "")
+}
+
+func TestValidateEvent(t *testing.T) {
+	valid := &TrackedEvent{EventName: "some_event", Properties: map[string]interface{}{
+		"count":      3,
+		"tags":       []string{"a", "b"},
+		"nested":     map[string]interface{}{"ok": true},
+		"$insert_id": "abc",
+	}}
+	assert.Nil(t, ValidateEvent(valid))
+
+	for name, e := range map[string]*TrackedEvent{
+		"nil":          nil,
+		"no name":      {},
+		"long name":    {EventName: strings.Repeat("a", MaxNameLength+1)},
+		"empty key":    {EventName: "e", Properties: map[string]interface{}{"": 1}},
+		"bad value":    {EventName: "e", Properties: map[string]interface{}{"f": func() {}}},
+		"bad map key":  {EventName: "e", Properties: map[string]interface{}{"m": map[int]int{1: 1}}},
+		"bad insertid": {EventName: "e", Properties: map[string]interface{}{"$insert_id": strings.Repeat("a", MaxInsertIDLength+1)}},
+	} {
+		assert.Error(t, ValidateEvent(e), name)
+	}
+}
+
+func TestMockClient(t *testing.T) {
+	var c Client = NewMockClient()
+	mock := c.(*MockClient)
+
+	assert.Nil(t, c.TrackBatched(getEvents(3)))
+	assert.Nil(t, c.Import(getEvents(1)))
+	assert.Error(t, c.Track(&TrackedEvent{}))
+	assert.Len(t, mock.Tracked(), 3)
+	assert.Len(t, mock.Imported(), 1)
+	assert.False(t, mock.Tracked()[0].Time.IsZero())
+
+	mock.Reset()
+	mock.Err = fmt.Errorf("unavailable")
+	assert.Error(t, c.Track(getEvents(1)[0]))
+	assert.Empty(t, mock.Tracked())
+}
+
+func TestImportBatched(t *testing.T) {
+	events := getEvents(MaxImportBatchSize + 1)
+	events[0].Time = time.Unix(1500000000, 0)
+	backfill := time.Unix(1400000000, 0)
+
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 2 {
+			w.Header().Set("Retry-After", "3")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		io.WriteString(w, "1")
+	}))
+	defer server.Close()
+
+	c := NewClient("some_token", "", server.URL, WithAPISecret("some_secret"), WithImportRate(1000)).(*client)
+	var slept []time.Duration
+	now := time.Unix(0, 0)
+	c.importLimiter.now = func() time.Time { return now }
+	c.importLimiter.sleep = func(d time.Duration) {
+		slept = append(slept, d)
+		now = now.Add(d)
+	}
+
+	assert.NoError(t, c.ImportBatched(events, backfill))
+	assert.Equal(t, 3, requests)
+	assert.Equal(t, time.Unix(1500000000, 0), events[0].Time)
+	assert.Equal(t, backfill, events[1].Time)
+	// the second batch waits for the first one, and again after being rejected
+	assert.Equal(t, []time.Duration{2 * time.Second, 3 * time.Second}, slept)
+}
+
+func TestImportBatchedGivesUp(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	c := NewClient("some_token", "some_api_key", server.URL).(*client)
+	var slept []time.Duration
+	now := time.Unix(0, 0)
+	c.importLimiter.now = func() time.Time { return now }
+	c.importLimiter.sleep = func(d time.Duration) {
+		slept = append(slept, d)
+		now = now.Add(d)
+	}
+
+	err := c.ImportBatched(getEvents(1), time.Time{})
+	assert.Error(t, err)
+	assert.Equal(t, MaxImportRetries+1, requests)
+	assert.Equal(t, []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 16 * time.Second}, slept)
+}
@@ -0,0 +1,93 @@
+package mixpanel
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// Limits enforced by the Mixpanel ingestion API.
+const (
+	// MaxTrackBatchSize is the maximum number of events accepted by a single /track request.
+	MaxTrackBatchSize = 50
+	// MaxImportBatchSize is the maximum number of events accepted by a single /import request.
+	MaxImportBatchSize = 2000
+	// MaxProperties is the maximum number of properties of an event.
+	MaxProperties = 255
+	// MaxNameLength is the maximum length of event and property names.
+	MaxNameLength = 255
+	// MaxInsertIDLength is the maximum length of the $insert_id property used for deduplication.
+	MaxInsertIDLength = 36
+)
+
+// ValidateEvent checks that e can be ingested by Mixpanel: it must have a name, at most MaxProperties
+// properties with non-empty names, and property values must be JSON scalars, lists or objects.
+func ValidateEvent(e *TrackedEvent) error {
+	if e == nil {
+		return fmt.Errorf("event cannot be nil")
+	}
+	if e.EventName == "" {
+		return fmt.Errorf("EventName cannot be empty")
+	}
+	if len(e.EventName) > MaxNameLength {
+		return fmt.Errorf("EventName %.32q... is longer than %d characters", e.EventName, MaxNameLength)
+	}
+	if len(e.Properties) > MaxProperties {
+		return fmt.Errorf("event %s has %d properties, at most %d are allowed", e.EventName, len(e.Properties), MaxProperties)
+	}
+	for k, v := range e.Properties {
+		if k == "" {
+			return fmt.Errorf("event %s has a property with an empty name", e.EventName)
+		}
+		if len(k) > MaxNameLength {
+			return fmt.Errorf("event %s has property %.32q... longer than %d characters", e.EventName, k, MaxNameLength)
+		}
+		if k == "$insert_id" {
+			if id, ok := v.(string); !ok || len(id) == 0 || len(id) > MaxInsertIDLength {
+				return fmt.Errorf("event %s has an invalid $insert_id %v: must be a string of 1 to %d characters", e.EventName, v, MaxInsertIDLength)
+			}
+		}
+		if err := validateValue(v); err != nil {
+			return fmt.Errorf("event %s has invalid property %s: %v", e.EventName, k, err)
+		}
+	}
+	return nil
+}
+
+func validateValue(v interface{}) error {
+	switch v.(type) {
+	case nil, bool, string, time.Time, json.Number, json.Marshaler,
+		int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		return nil
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Ptr:
+		if rv.IsNil() {
+			return nil
+		}
+		return validateValue(rv.Elem().Interface())
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			if err := validateValue(rv.Index(i).Interface()); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			return fmt.Errorf("map keys must be strings, got %s", rv.Type().Key())
+		}
+		for _, key := range rv.MapKeys() {
+			if err := validateValue(rv.MapIndex(key).Interface()); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Struct:
+		return nil
+	}
+	return fmt.Errorf("unsupported type %T", v)
+}
@@ -0,0 +1,116 @@
+package obserr
+
+import (
+	"net/http"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Codes shared by services, so that failures can be aggregated across them. Services may define more.
+const (
+	// InvalidArgument is for requests that are malformed or fail validation.
+	InvalidArgument Code = "invalid_argument"
+	// Unauthenticated is for requests without valid credentials.
+	Unauthenticated Code = "unauthenticated"
+	// PermissionDenied is for callers not allowed to do what they requested.
+	PermissionDenied Code = "permission_denied"
+	// NotFound is for requested entities that do not exist.
+	NotFound Code = "not_found"
+	// Conflict is for requests conflicting with the current state, such as creating an existing entity
+	// or a failed optimistic concurrency check.
+	Conflict Code = "conflict"
+	// RateLimited is for requests rejected by a rate limit or a quota.
+	RateLimited Code = "rate_limited"
+	// Canceled is for operations canceled by the caller.
+	Canceled Code = "canceled"
+	// DeadlineExceeded is for operations that did not complete in time.
+	DeadlineExceeded Code = "deadline_exceeded"
+	// Unavailable is for transient failures that may succeed if retried, such as an overloaded dependency.
+	Unavailable Code = "unavailable"
+	// Unimplemented is for operations that are not supported.
+	Unimplemented Code = "unimplemented"
+	// Internal is for bugs and broken invariants.
+	Internal Code = "internal"
+)
+
+var codeMappings = []struct {
+	code Code
+	http int
+	grpc codes.Code
+}{
+	{InvalidArgument, http.StatusBadRequest, codes.InvalidArgument},
+	{Unauthenticated, http.StatusUnauthorized, codes.Unauthenticated},
+	{PermissionDenied, http.StatusForbidden, codes.PermissionDenied},
+	{NotFound, http.StatusNotFound, codes.NotFound},
+	{Conflict, http.StatusConflict, codes.AlreadyExists},
+	{RateLimited, http.StatusTooManyRequests, codes.ResourceExhausted},
+	{Canceled, 499, codes.Canceled},
+	{DeadlineExceeded, http.StatusGatewayTimeout, codes.DeadlineExceeded},
+	{Unavailable, http.StatusServiceUnavailable, codes.Unavailable},
+	{Unimplemented, http.StatusNotImplemented, codes.Unimplemented},
+	{Internal, http.StatusInternalServerError, codes.Internal},
+}
+
+// HTTPStatus returns the HTTP status code for c: 500 Internal Server Error for Internal and codes
+// without a standard mapping.
+func (c Code) HTTPStatus() int {
+	for _, m := range codeMappings {
+		if m.code == c {
+			return m.http
+		}
+	}
+	return http.StatusInternalServerError
+}
+
+// GRPCCode returns the gRPC status code for c: Unknown for codes without a standard mapping.
+func (c Code) GRPCCode() codes.Code {
+	for _, m := range codeMappings {
+		if m.code == c {
+			return m.grpc
+		}
+	}
+	return codes.Unknown
+}
+
+// CodeFromHTTPStatus returns the Code for an HTTP status code, or false if it is not an error or has no
+// standard mapping.
+func CodeFromHTTPStatus(status int) (Code, bool) {
+	for _, m := range codeMappings {
+		if m.http == status {
+			return m.code, true
+		}
+	}
+	return "", false
+}
+
+// CodeFromGRPC returns the Code for a gRPC status code, or false if it is OK or has no standard mapping.
+func CodeFromGRPC(c codes.Code) (Code, bool) {
+	switch c {
+	case codes.FailedPrecondition, codes.Aborted:
+		return Conflict, true
+	case codes.OutOfRange:
+		return InvalidArgument, true
+	case codes.DataLoss:
+		return Internal, true
+	}
+	for _, m := range codeMappings {
+		if m.grpc == c {
+			return m.code, true
+		}
+	}
+	return "", false
+}
+
+// GRPCStatus returns the gRPC status of e, so that gRPC servers returning e respond with the status code
+// mapped from its code, as returned by CodeOf. Errors without a code keep the status of the error they
+// were created from, if any.
+func (e *Error) GRPCStatus() *status.Status {
+	if code, ok := CodeOf(e); ok {
+		return status.New(code.GRPCCode(), e.Error())
+	}
+	if s, ok := status.FromError(e.orig); ok && s.Code() != codes.OK {
+		return status.New(s.Code(), e.Error())
+	}
+	return status.New(codes.Unknown, e.Error())
+}
@@ -0,0 +1,45 @@
+package obserr
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestCodeMappings(t *testing.T) {
+	assert.Equal(t, http.StatusNotFound, NotFound.HTTPStatus())
+	assert.Equal(t, http.StatusTooManyRequests, RateLimited.HTTPStatus())
+	assert.Equal(t, http.StatusInternalServerError, Code("custom").HTTPStatus())
+	assert.Equal(t, codes.AlreadyExists, Conflict.GRPCCode())
+	assert.Equal(t, codes.Unknown, Code("custom").GRPCCode())
+
+	for _, m := range codeMappings {
+		code, ok := CodeFromHTTPStatus(m.http)
+		assert.True(t, ok)
+		assert.Equal(t, m.code, code)
+		code, ok = CodeFromGRPC(m.grpc)
+		assert.True(t, ok)
+		assert.Equal(t, m.code, code)
+	}
+	_, ok := CodeFromHTTPStatus(http.StatusOK)
+	assert.False(t, ok)
+	_, ok = CodeFromGRPC(codes.OK)
+	assert.False(t, ok)
+	code, _ := CodeFromGRPC(codes.FailedPrecondition)
+	assert.Equal(t, Conflict, code)
+}
+
+func TestGRPCStatus(t *testing.T) {
+	e := New("no such user").WithCode(NotFound).Annotate("loading user")
+	assert.Equal(t, NotFound, e.Code())
+	assert.Equal(t, codes.NotFound, status.Code(e))
+	assert.Equal(t, "loading user: no such user", status.Convert(e).Message())
+
+	assert.Equal(t, codes.Unavailable, status.Code(Annotate(status.Error(codes.Unavailable, "down"), "calling users")))
+	assert.Equal(t, codes.Unknown, status.Code(New("plain")))
+	assert.Equal(t, codes.Internal, status.Code(Combine(errors.New("first"), New("bug").WithCode(Internal))))
+}
@@ -0,0 +1,85 @@
+package obserr
+
+import (
+	"fmt"
+	"hash/fnv"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Fingerprint returns a stable hash of err, identical for "the same" error wherever and whenever it
+// happens, so that telemetry can group occurrences across hosts. It covers the type of the original
+// error, the code and the annotations, and for errors made by Combine the fingerprints of the
+// children, in any order. The message of the original error is left out, as it often holds IDs, paths
+// or input, and so are vals, the digits of annotations, which tend to hold IDs, addresses and counts,
+// and the args of the annotations made by Wrapf. It returns an empty string for a nil error.
+func Fingerprint(err error) string {
+	if err == nil {
+		return ""
+	}
+	h := fnv.New64a()
+	writeFingerprint(h, err)
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+func writeFingerprint(w io.Writer, err error) {
+	e, ok := err.(*Error)
+	if !ok {
+		// walk down errors wrapped with fmt.Errorf's %w, whose messages include those they wrap
+		for {
+			wrapper, ok := err.(interface{ Unwrap() error })
+			if !ok || wrapper.Unwrap() == nil {
+				break
+			}
+			err = wrapper.Unwrap()
+		}
+		if oe, ok := err.(*Error); ok {
+			writeFingerprint(w, oe)
+			return
+		}
+		fmt.Fprintf(w, "%T\n", err)
+		return
+	}
+
+	fmt.Fprintf(w, "code:%s\n", e.code)
+	for i, a := range e.annotations {
+		if format, ok := e.formats[i]; ok {
+			fmt.Fprintf(w, "annotation:%s\n", format)
+		} else {
+			fmt.Fprintf(w, "annotation:%s\n", maskDigits(a))
+		}
+	}
+	if e.errs == nil {
+		if e.orig == nil {
+			fmt.Fprint(w, "<nil>\n")
+			return
+		}
+		writeFingerprint(w, e.orig)
+		return
+	}
+	children := make([]string, len(e.errs))
+	for i, child := range e.errs {
+		children[i] = Fingerprint(child)
+	}
+	sort.Strings(children)
+	fmt.Fprintf(w, "combined:%s\n", strings.Join(children, ","))
+}
+
+// maskDigits replaces every run of digits in s with '#'.
+func maskDigits(s string) string {
+	var b strings.Builder
+	inDigits := false
+	for _, r := range s {
+		if r >= '0' && r <= '9' {
+			if !inDigits {
+				b.WriteByte('#')
+			}
+			inDigits = true
+			continue
+		}
+		inDigits = false
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,34 @@
+package obserr
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFingerprint(t *testing.T) {
+	load := func(id int) error {
+		return New(fmt.Sprintf("user %d not found", id)).Annotate("loading user").WithCode(NotFound).Set("user_id", id)
+	}
+	fp := Fingerprint(load(1))
+	assert.Len(t, fp, 16)
+	assert.Equal(t, fp, Fingerprint(load(12345)), "vals and digits are ignored")
+	assert.Equal(t, fp, Fingerprint(wrapError{"handler", load(2)}))
+	assert.Equal(t, "", Fingerprint(nil))
+
+	assert.NotEqual(t, fp, Fingerprint(New("user 1 not found").WithCode(NotFound)), "annotations count")
+	assert.NotEqual(t, fp, Fingerprint(New("user 1 not found").Annotate("loading user")), "codes count")
+	assert.NotEqual(t, Fingerprint(errors.New("not found")), Fingerprint(notFoundError{}), "types count")
+	assert.Equal(t, Fingerprint(errors.New("no such file /tmp/a")), Fingerprint(errors.New("bad input 'x'")),
+		"messages are ignored")
+
+	a, b := errors.New("first"), New("second").WithCode(Unavailable)
+	assert.Equal(t, Fingerprint(Combine(a, b)), Fingerprint(Combine(b, a)))
+	assert.NotEqual(t, Fingerprint(Combine(a, b)), Fingerprint(Combine(a)))
+}
+
+type notFoundError struct{}
+
+func (notFoundError) Error() string { return "not found" }
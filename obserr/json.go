@@ -0,0 +1,103 @@
+package obserr
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// jsonError is the JSON representation of an Error.
+type jsonError struct {
+	Message     string                 `json:"message"`
+	Code        Code                   `json:"code,omitempty"`
+	Annotations []string               `json:"annotations"`
+	Vals        map[string]interface{} `json:"vals"`
+	Errors      []*Error               `json:"errors,omitempty"`
+	Retryable   *bool                  `json:"retryable,omitempty"`
+}
+
+// MarkSensitive marks the vals with the given keys as sensitive, so that WithoutSensitive leaves them out.
+func (e *Error) MarkSensitive(keys ...string) *Error {
+	if e.sensitive == nil {
+		e.sensitive = make(map[string]struct{}, len(keys))
+	}
+	for _, k := range keys {
+		e.sensitive[k] = struct{}{}
+	}
+	return e
+}
+
+// WithoutSensitive returns a copy of e without the vals marked sensitive, in e and in the errors
+// combined into it, for instance to return e to API clients.
+func (e *Error) WithoutSensitive() *Error {
+	out := *e
+	out.vals = make(map[string]interface{}, len(e.vals))
+	for k, v := range e.vals {
+		if _, ok := e.sensitive[k]; !ok {
+			out.vals[k] = v
+		}
+	}
+	out.sensitive = nil
+	if e.errs != nil {
+		out.errs = make([]error, len(e.errs))
+		for i, child := range e.errs {
+			if oe, ok := child.(*Error); ok {
+				child = oe.WithoutSensitive()
+			}
+			out.errs[i] = child
+		}
+	}
+	return &out
+}
+
+// MarshalJSON encodes e as {"message", "code", "annotations", "vals", "errors", "retryable"}: the
+// original error message, the code if any, the annotations in the order they were added, the vals, the
+// errors combined into e if any, and whether e was marked retryable with SetRetryable, if it was.
+func (e *Error) MarshalJSON() ([]byte, error) {
+	j := jsonError{
+		Message:     e.orig.Error(),
+		Code:        e.code,
+		Annotations: e.annotations,
+		Vals:        e.vals,
+		Retryable:   e.retryable,
+	}
+	if j.Annotations == nil {
+		j.Annotations = []string{}
+	}
+	if j.Vals == nil {
+		j.Vals = map[string]interface{}{}
+	}
+	for _, child := range e.errs {
+		j.Errors = append(j.Errors, New(child))
+	}
+	return json.Marshal(j)
+}
+
+// UnmarshalJSON decodes an Error encoded by MarshalJSON. Vals are decoded as by encoding/json into an
+// interface{}, so numbers become float64.
+func (e *Error) UnmarshalJSON(data []byte) error {
+	var j jsonError
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	orig := errors.New(j.Message)
+	*e = Error{
+		orig:      orig,
+		err:       orig,
+		vals:      j.Vals,
+		code:      j.Code,
+		retryable: j.Retryable,
+	}
+	if e.vals == nil {
+		e.vals = make(map[string]interface{})
+	}
+	for _, a := range j.Annotations {
+		e.Annotate(a)
+	}
+	if len(j.Errors) > 0 {
+		e.errs = make([]error, len(j.Errors))
+		for i, child := range j.Errors {
+			e.errs[i] = child
+		}
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package obserr
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJSON(t *testing.T) {
+	e := New("no such user").WithCode("not_found").Set("user_id", 7, "token", "secret").
+		Annotate("loading user").Annotate("GET /users/7")
+
+	data, err := json.Marshal(e)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{
+		"message": "no such user",
+		"code": "not_found",
+		"annotations": ["loading user", "GET /users/7"],
+		"vals": {"user_id": 7, "token": "secret"}
+	}`, string(data))
+
+	var decoded *Error
+	assert.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, e.Error(), decoded.Error())
+	assert.Equal(t, Code("not_found"), decoded.Code())
+	assert.Equal(t, 7.0, decoded.Get("user_id"))
+
+	data, err = json.Marshal(New("plain"))
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"message": "plain", "annotations": [], "vals": {}}`, string(data))
+}
+
+func TestJSONCombined(t *testing.T) {
+	e := Combine(errors.New("first"), New("second").Set("a", "b")).(*Error)
+	data, err := json.Marshal(e)
+	assert.NoError(t, err)
+
+	var decoded Error
+	assert.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, e.Error(), decoded.Error())
+	if assert.Len(t, decoded.Unwrap(), 2) {
+		assert.Equal(t, "first", decoded.Unwrap()[0].Error())
+		assert.Equal(t, "b", decoded.Unwrap()[1].(*Error).Get("a"))
+	}
+}
+
+func TestWithoutSensitive(t *testing.T) {
+	child := New("denied").Set("token", "secret", "user_id", 7).MarkSensitive("token")
+	e := Combine(child, errors.New("other")).(*Error)
+
+	redacted := e.WithoutSensitive()
+	assert.Equal(t, map[string]interface{}{"user_id": 7}, redacted.Vals())
+	assert.Equal(t, map[string]interface{}{"user_id": 7}, redacted.Unwrap()[0].(*Error).Vals())
+	assert.Equal(t, e.Error(), redacted.Error())
+
+	// e itself is left untouched
+	assert.Equal(t, "secret", e.Get("token"))
+	assert.Equal(t, "secret", child.Get("token"))
+}
+
+func TestJSONRetryable(t *testing.T) {
+	data, err := json.Marshal(New("flaky").SetRetryable(false))
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), `"retryable":false`)
+	var e Error
+	assert.NoError(t, json.Unmarshal(data, &e))
+	assert.False(t, IsRetryable(&e))
+
+	data, err = json.Marshal(New("flaky"))
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), "retryable")
+}
@@ -3,6 +3,11 @@ package obserr
 import (
 	"errors"
 	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
 )
 
 // Error should be used as a drop-in replacement for Golang's native error type
@@ -12,10 +17,23 @@ import (
 // This should be used in conjunction with go/src/obs/flight_recorder.go's Vals type
 // where the actual telemetry/reporting happens.
 type Error struct {
-	orig, err error
-	vals      map[string]interface{}
+	orig, err   error
+	vals        map[string]interface{}
+	errs        []error
+	code        Code
+	annotations []string
+	sensitive   map[string]struct{}
+	// retryable is set by SetRetryable.
+	retryable *bool
+	// formats holds the formats of the annotations added by Wrapf, by index in annotations.
+	formats map[int]string
+	// wrapfArgs is the number of args set as vals by Wrapf, which numbers the next ones.
+	wrapfArgs int
 }
 
+// Code classifies errors by their meaning, so that telemetry can be aggregated by kind of failure.
+type Code string
+
 func New(e interface{}) *Error {
 	var err error
 
@@ -71,16 +89,151 @@ func (e *Error) Annotate(ann interface{}) *Error {
 	}
 
 	e.err = fmt.Errorf("%s: %s", a, e.err)
+	e.annotations = append(e.annotations, a)
 	return e
 }
 
+// WithCode sets the code of e. The code is kept by Annotate.
+func (e *Error) WithCode(code Code) *Error {
+	e.code = code
+	return e
+}
+
+// Code returns the code of e, or an empty Code if it has none.
+func (e *Error) Code() Code {
+	return e.code
+}
+
+// CodeOf returns the code of err, or of the first error it wraps that has one.
+func CodeOf(err error) (Code, bool) {
+	if e, ok := err.(*Error); ok && e.code != "" {
+		return e.code, true
+	}
+	switch e := err.(type) {
+	case interface{ Unwrap() []error }:
+		for _, child := range e.Unwrap() {
+			if code, ok := CodeOf(child); ok {
+				return code, true
+			}
+		}
+	case interface{ Unwrap() error }:
+		return CodeOf(e.Unwrap())
+	}
+	return "", false
+}
+
+// cause returns the error err wraps, or nil. It unwraps errors with an Unwrap method returning a
+// single error, as fmt.Errorf's %w makes them, errors with a Cause method, as github.com/pkg/errors
+// makes them, and the errors of package net and os, which only have Unwrap methods from Go 1.13 on.
+func cause(err error) error {
+	switch e := err.(type) {
+	case interface{ Unwrap() error }:
+		return e.Unwrap()
+	case interface{ Cause() error }:
+		return e.Cause()
+	case *net.OpError:
+		return e.Err
+	case *os.SyscallError:
+		return e.Err
+	case *os.PathError:
+		return e.Err
+	case *url.Error:
+		return e.Err
+	}
+	return nil
+}
+
 func Annotate(e error, an interface{}) *Error {
 	return New(e).Annotate(an)
 }
 
+// Wrapf annotates err with the message formatted from format and args, and sets each arg as the val
+// argN, unless the val is already set, so that the args can be queried instead of only being part of
+// the message. Args are numbered across the Wrapf calls err went through: the args of the first call
+// are arg0, arg1 and so on, and those of the next call follow. It returns nil if err is nil.
+func Wrapf(err error, format string, args ...interface{}) error {
+	if err == nil {
+		return nil
+	}
+	e := New(err).Annotate(fmt.Sprintf(format, args...))
+	if e.formats == nil {
+		e.formats = make(map[int]string)
+	}
+	e.formats[len(e.annotations)-1] = format
+	for _, arg := range args {
+		key := "arg" + strconv.Itoa(e.wrapfArgs)
+		e.wrapfArgs++
+		if _, ok := e.vals[key]; !ok {
+			e.vals[key] = arg
+		}
+	}
+	return e
+}
+
 func Original(e error) error {
 	if oe, ok := e.(*Error); ok {
 		return oe.orig
 	}
 	return e
 }
+
+// Combine aggregates multiple errors, such as those returned by parallel fan-out calls, into one.
+// nil errors are ignored, and nil is returned if there are no errors left. Each child keeps its
+// own vals and annotations and is returned by Unwrap, so errors.Is and errors.As see all of them.
+// The combined error's vals are the union of the children's vals; when children disagree on a
+// key, the first child's value wins. The combined error is an *Error.
+func Combine(errs ...error) error {
+	var children []error
+	for _, err := range errs {
+		if err != nil {
+			children = append(children, err)
+		}
+	}
+	if len(children) == 0 {
+		return nil
+	}
+
+	msgs := make([]string, len(children))
+	vals := make(map[string]interface{})
+	var sensitive map[string]struct{}
+	for i, child := range children {
+		msgs[i] = child.Error()
+		if oe, ok := child.(*Error); ok {
+			for k, v := range oe.vals {
+				if _, ok := vals[k]; !ok {
+					vals[k] = v
+				}
+			}
+			for k := range oe.sensitive {
+				if sensitive == nil {
+					sensitive = make(map[string]struct{})
+				}
+				sensitive[k] = struct{}{}
+			}
+		}
+	}
+
+	var err error
+	if len(children) == 1 {
+		err = errors.New(msgs[0])
+	} else {
+		err = fmt.Errorf("%d errors: %s", len(children), strings.Join(msgs, "; "))
+	}
+
+	return &Error{
+		orig:      err,
+		err:       err,
+		vals:      vals,
+		errs:      children,
+		sensitive: sensitive,
+	}
+}
+
+// Unwrap returns the errors aggregated by Combine, or the original error for errors
+// created with New or Annotate.
+func (e *Error) Unwrap() []error {
+	if e.errs != nil {
+		return e.errs
+	}
+	return []error{e.orig}
+}
@@ -63,3 +63,71 @@ func TestErrorsOriginal(t *testing.T) {
 	assert.Equal(t, o, Original(e))
 	assert.Equal(t, o, Original(o))
 }
+
+func TestCombine(t *testing.T) {
+	assert.True(t, Combine() == nil)
+	assert.True(t, Combine(nil, nil) == nil)
+
+	sentinel := errors.New("sentinel")
+	first := New("first").Set("a", 1, "shared", "first").Annotate("calling a")
+	second := New(sentinel).Set("b", 2, "shared", "second")
+
+	e := Combine(first, nil, second).(*Error)
+	assert.Equal(t, "2 errors: calling a: first; sentinel", e.Error())
+	assert.Equal(t, []error{first, second}, e.Unwrap())
+	assert.Equal(t, map[string]interface{}{"a": 1, "b": 2, "shared": "first"}, e.Vals())
+	assert.Equal(t, sentinel, Original(e.Unwrap()[1]))
+
+	assert.Equal(t, 1, first.Get("a"))
+	assert.Equal(t, "calling a: first", first.Error())
+
+	single := Combine(nil, sentinel)
+	assert.Equal(t, "sentinel", single.Error())
+	assert.Equal(t, []error{sentinel}, single.(*Error).Unwrap())
+
+	assert.Equal(t, "fan out: 2 errors: calling a: first; sentinel", e.Annotate("fan out").Error())
+}
+
+func TestUnwrap(t *testing.T) {
+	sentinel := errors.New("sentinel")
+	e := Annotate(sentinel, "wrapped").Set("a", 1)
+	assert.Equal(t, sentinel, Original(e))
+	assert.Equal(t, []error{sentinel}, e.Unwrap())
+}
+
+func TestCodeOf(t *testing.T) {
+	_, ok := CodeOf(errors.New("plain"))
+	assert.False(t, ok)
+	_, ok = CodeOf(nil)
+	assert.False(t, ok)
+
+	e := New("not found").WithCode("not_found")
+	code, ok := CodeOf(e)
+	assert.True(t, ok)
+	assert.Equal(t, Code("not_found"), code)
+
+	code, _ = CodeOf(Annotate(e, "loading user"))
+	assert.Equal(t, Code("not_found"), code)
+	code, _ = CodeOf(wrapError{"handler", e})
+	assert.Equal(t, Code("not_found"), code)
+	code, _ = CodeOf(Combine(errors.New("first"), e))
+	assert.Equal(t, Code("not_found"), code)
+}
+
+func TestWrapf(t *testing.T) {
+	sentinel := errors.New("not found")
+	e := Wrapf(sentinel, "loading user %d of %s", 42, "acme").(*Error)
+	assert.Equal(t, "loading user 42 of acme: not found", e.Error())
+	assert.Equal(t, 42, e.Get("arg0"))
+	assert.Equal(t, "acme", e.Get("arg1"))
+	assert.Equal(t, sentinel, Original(e))
+	assert.Equal(t, Fingerprint(e), Fingerprint(Wrapf(sentinel, "loading user %d of %s", 7, "initech")),
+		"args are left out of fingerprints")
+
+	outer := Wrapf(e, "handling %s", "request").(*Error)
+	assert.Equal(t, "handling request: loading user 42 of acme: not found", outer.Error())
+	assert.Equal(t, 42, outer.Get("arg0"))
+	assert.Equal(t, "request", outer.Get("arg2"), "the args of nested calls do not collide")
+	assert.NoError(t, Wrapf(nil, "loading user %d", 42))
+	assert.True(t, Wrapf(nil, "loading user %d", 42) == nil)
+}
@@ -0,0 +1,109 @@
+package obserr
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"syscall"
+
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// SetRetryable marks e as retryable or not, overriding the heuristics of IsRetryable. It is kept by
+// Annotate.
+func (e *Error) SetRetryable(retryable bool) *Error {
+	e.retryable = &retryable
+	return e
+}
+
+// Temporary reports whether e is retryable, as IsRetryable does, so that code checking for the
+// Temporary method of net.Error classifies e too.
+func (e *Error) Temporary() bool {
+	return IsRetryable(e)
+}
+
+// IsRetryable reports whether the operation that failed with err may succeed if retried, so that
+// retry loops and interceptors can decide from the error itself. Errors marked with SetRetryable are
+// what they were marked, and so are the errors wrapping them. Otherwise, err is retryable if it is:
+//
+//   - context.DeadlineExceeded, but not context.Canceled
+//   - a refused or reset connection, or a net.Error that timed out
+//   - an error with a Temporary method that returns true
+//   - an error with the code Unavailable, DeadlineExceeded or RateLimited
+//   - a gRPC status with the code Unavailable, DeadlineExceeded, ResourceExhausted or Aborted
+//   - a *googleapi.Error or an error with an HTTPStatus method, with a 5xx status other than 501 Not
+//     Implemented, or 429 Too Many Requests
+//
+// Errors combined with Combine are retryable if all of their children are. IsRetryable returns false
+// for a nil error.
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	if e, ok := err.(*Error); ok {
+		if e.retryable != nil {
+			return *e.retryable
+		}
+		if e.code != "" {
+			return retryableCode(e.code)
+		}
+		if e.errs != nil {
+			for _, child := range e.errs {
+				if !IsRetryable(child) {
+					return false
+				}
+			}
+			return true
+		}
+		return IsRetryable(e.orig)
+	}
+	// errors wrapping an Error, as with fmt.Errorf's %w, are classified as the Error, which may be marked
+	for c := err; c != nil; c = cause(c) {
+		switch e := c.(type) {
+		case *Error:
+			return IsRetryable(e)
+		case syscall.Errno:
+			if e == syscall.ECONNREFUSED || e == syscall.ECONNRESET {
+				return true
+			}
+		case *googleapi.Error:
+			return retryableHTTPStatus(e.Code)
+		case interface{ HTTPStatus() int }:
+			return retryableHTTPStatus(e.HTTPStatus())
+		}
+		switch c {
+		case context.Canceled:
+			return false
+		case context.DeadlineExceeded:
+			return true
+		}
+		if netErr, ok := c.(net.Error); ok && netErr.Timeout() {
+			return true
+		}
+	}
+	if t, ok := err.(interface{ Temporary() bool }); ok && t.Temporary() {
+		return true
+	}
+	if s, ok := status.FromError(err); ok {
+		switch s.Code() {
+		case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Aborted:
+			return true
+		}
+	}
+	return false
+}
+
+func retryableCode(code Code) bool {
+	switch code {
+	case Unavailable, DeadlineExceeded, RateLimited:
+		return true
+	}
+	return false
+}
+
+func retryableHTTPStatus(status int) bool {
+	return status == http.StatusTooManyRequests ||
+		status >= http.StatusInternalServerError && status != http.StatusNotImplemented && status < 600
+}
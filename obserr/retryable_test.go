@@ -0,0 +1,68 @@
+package obserr
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type httpStatusError int
+
+func (e httpStatusError) Error() string { return http.StatusText(int(e)) }
+
+func (e httpStatusError) HTTPStatus() int { return int(e) }
+
+// wrapError wraps err as fmt.Errorf's %w does from Go 1.13 on.
+type wrapError struct {
+	msg string
+	err error
+}
+
+func (e wrapError) Error() string { return e.msg + ": " + e.err.Error() }
+
+func (e wrapError) Unwrap() error { return e.err }
+
+func TestIsRetryable(t *testing.T) {
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}
+	for _, err := range []error{
+		context.DeadlineExceeded,
+		wrapError{"calling users", context.DeadlineExceeded},
+		refused,
+		New("overloaded").WithCode(Unavailable),
+		Annotate(status.Error(codes.Unavailable, "down"), "calling users"),
+		&googleapi.Error{Code: http.StatusBadGateway},
+		wrapError{"tracking", httpStatusError(http.StatusTooManyRequests)},
+		Combine(context.DeadlineExceeded, refused),
+		New("not found").WithCode(NotFound).SetRetryable(true),
+	} {
+		assert.True(t, IsRetryable(err), "%v", err)
+	}
+	for _, err := range []error{
+		nil,
+		errors.New("plain"),
+		context.Canceled,
+		New("no such user").WithCode(NotFound),
+		status.Error(codes.InvalidArgument, "bad"),
+		&googleapi.Error{Code: http.StatusNotImplemented},
+		httpStatusError(http.StatusBadRequest),
+		Combine(context.DeadlineExceeded, errors.New("plain")),
+		New(context.DeadlineExceeded).SetRetryable(false),
+		wrapError{"handler", New(refused).SetRetryable(false)},
+		wrapError{"calling users", context.Canceled},
+	} {
+		assert.False(t, IsRetryable(err), "%v", err)
+	}
+
+	e := New("flaky").SetRetryable(true).Annotate("loading user")
+	assert.True(t, e.Temporary(), "marks are kept by Annotate")
+	assert.True(t, IsRetryable(wrapError{"wrapped", e}))
+}
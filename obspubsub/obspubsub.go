@@ -0,0 +1,174 @@
+// Package obspubsub instruments Google Cloud Pub/Sub topics and subscriptions with a FlightRecorder,
+// so that asynchronous pipelines show up in traces: publishing injects the context of the span of the
+// publisher into the attributes of the message, and receiving continues its trace in the span of the
+// callback. It reports, tagged with the topic or the subscription:
+//
+//	pubsub.published           messages published, tagged with status:ok or status:error
+//	pubsub.publish_latency_us  the time the server took to acknowledge a publish
+//	pubsub.received            messages received
+//	pubsub.redelivered         messages received again by the same process, see Config.RedeliveryWindow
+//	pubsub.backlog_age_ms      the time messages waited between their publication and their receipt
+//	pubsub.ack_latency_us      the time from receiving a message to acking or nacking it, tagged with
+//	                           result:ack or result:nack
+package obspubsub
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"github.com/mixpanel/obs"
+	"github.com/opentracing/opentracing-go/ext"
+)
+
+// Topic publishes traced messages.
+type Topic struct {
+	*pubsub.Topic
+	fr obs.FlightRecorder
+}
+
+// NewTopic instruments t with fr.
+func NewTopic(fr obs.FlightRecorder, t *pubsub.Topic) *Topic {
+	return &Topic{Topic: t, fr: fr}
+}
+
+// Publish publishes msg like pubsub.Topic.Publish, in a pubsub.publish span that is a child of the
+// span in ctx and ends once the server acknowledges the message. The context of the span is added to
+// the attributes of msg.
+func (t *Topic) Publish(ctx context.Context, msg *pubsub.Message) *pubsub.PublishResult {
+	fs, ctx, done := t.fr.WithNewSpan(ctx, "pubsub.publish")
+	span := fs.TraceSpan()
+	ext.SpanKindProducer.Set(span)
+	ext.MessageBusDestination.Set(span, t.ID())
+
+	attributes := make(map[string]string, len(msg.Attributes)+3)
+	for k, v := range msg.Attributes {
+		attributes[k] = v
+	}
+	if obs.InjectSpanContext(ctx, attributes) {
+		msg.Attributes = attributes
+	}
+
+	start := time.Now()
+	result := t.Topic.Publish(ctx, msg)
+	go func() {
+		defer done()
+		_, err := result.Get(context.Background())
+		fs = fs.WithMetricTags(obs.Tags{"topic": t.ID()})
+		fs.AddStat("pubsub.publish_latency_us", float64(time.Since(start)/time.Microsecond))
+		if err != nil {
+			fs.WithMetricTags(obs.Tags{"status": "error"}).Incr("pubsub.published")
+			fs.ReportError(err)
+			return
+		}
+		fs.WithMetricTags(obs.Tags{"status": "ok"}).Incr("pubsub.published")
+	}()
+	return result
+}
+
+// Config configures a Subscription.
+type Config struct {
+	// RedeliveryWindow is the number of the last message IDs received that are remembered to count
+	// redeliveries. Pub/Sub does not tell how many times a message was delivered, so only the
+	// redeliveries to the same process are counted. Defaults to 10000.
+	RedeliveryWindow int
+}
+
+// Subscription receives traced messages.
+type Subscription struct {
+	*pubsub.Subscription
+	fr   obs.FlightRecorder
+	seen *recentIDs
+}
+
+// NewSubscription instruments s with fr.
+func NewSubscription(fr obs.FlightRecorder, s *pubsub.Subscription, cfg Config) *Subscription {
+	if cfg.RedeliveryWindow <= 0 {
+		cfg.RedeliveryWindow = 10000
+	}
+	return &Subscription{Subscription: s, fr: fr, seen: newRecentIDs(cfg.RedeliveryWindow)}
+}
+
+// Message is a message received by a Subscription, whose Ack and Nack are timed.
+type Message struct {
+	*pubsub.Message
+	fs       obs.FlightSpan
+	received time.Time
+	once     sync.Once
+}
+
+// Ack acknowledges the message, like pubsub.Message.Ack.
+func (m *Message) Ack() {
+	m.done("ack")
+	m.Message.Ack()
+}
+
+// Nack refuses the message, like pubsub.Message.Nack.
+func (m *Message) Nack() {
+	m.done("nack")
+	m.Message.Nack()
+}
+
+func (m *Message) done(result string) {
+	m.once.Do(func() {
+		m.fs.WithMetricTags(obs.Tags{"result": result}).
+			AddStat("pubsub.ack_latency_us", float64(time.Since(m.received)/time.Microsecond))
+	})
+}
+
+// Receive calls f for every message received, like pubsub.Subscription.Receive, in a pubsub.receive
+// span continuing the trace of the publisher of the message, which ends when f returns.
+func (s *Subscription) Receive(ctx context.Context, f func(context.Context, *Message)) error {
+	return s.Subscription.Receive(ctx, func(ctx context.Context, msg *pubsub.Message) {
+		received := time.Now()
+		fs, ctx, done := s.fr.WithNewSpanContext(ctx, "pubsub.receive", obs.ExtractSpanContext(s.fr, msg.Attributes))
+		defer done()
+		span := fs.TraceSpan()
+		ext.SpanKindConsumer.Set(span)
+		ext.MessageBusDestination.Set(span, s.ID())
+		span.SetTag("pubsub.message_id", msg.ID)
+
+		metricFS := fs.WithMetricTags(obs.Tags{"subscription": s.ID()})
+		metricFS.Incr("pubsub.received")
+		if s.seen.add(msg.ID) {
+			metricFS.Incr("pubsub.redelivered")
+			span.SetTag("pubsub.redelivered", true)
+		}
+		if !msg.PublishTime.IsZero() {
+			metricFS.AddStat("pubsub.backlog_age_ms", float64(received.Sub(msg.PublishTime)/time.Millisecond))
+		}
+		f(ctx, &Message{Message: msg, fs: metricFS, received: received})
+	})
+}
+
+// recentIDs remembers the last IDs added.
+type recentIDs struct {
+	mutex sync.Mutex // guards everything below
+	ids   map[string]struct{}
+	ring  []string // the IDs in the order they were added, oldest at next once full
+	next  int
+}
+
+func newRecentIDs(n int) *recentIDs {
+	return &recentIDs{ids: make(map[string]struct{}, n), ring: make([]string, 0, n)}
+}
+
+// add remembers id, forgetting the oldest ID if there are too many, and reports whether it was
+// already remembered.
+func (r *recentIDs) add(id string) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if _, ok := r.ids[id]; ok {
+		return true
+	}
+	if len(r.ring) < cap(r.ring) {
+		r.ring = append(r.ring, id)
+	} else {
+		delete(r.ids, r.ring[r.next])
+		r.ring[r.next] = id
+		r.next = (r.next + 1) % len(r.ring)
+	}
+	r.ids[id] = struct{}{}
+	return false
+}
@@ -0,0 +1,124 @@
+package obspubsub
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"cloud.google.com/go/pubsub/pstest"
+	"github.com/mixpanel/obs"
+	"github.com/mixpanel/obs/logging"
+	"github.com/mixpanel/obs/metrics"
+	basictracer "github.com/opentracing/basictracer-go"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+)
+
+func newTestRecorder() (obs.FlightRecorder, *metrics.MockSink, *basictracer.InMemorySpanRecorder) {
+	sink := metrics.NewMockSink()
+	recorder := basictracer.NewInMemoryRecorder()
+	opts := basictracer.DefaultOptions()
+	opts.Recorder = recorder
+	opts.ShouldSample = func(uint64) bool { return true }
+	fr := obs.NewFlightRecorder("test", metrics.NewReceiver(sink), logging.Null, basictracer.NewWithOptions(opts))
+	return fr, sink, recorder
+}
+
+func newTestClient(t *testing.T) (*pubsub.Client, func()) {
+	srv := pstest.NewServer()
+	conn, err := grpc.Dial(srv.Addr, grpc.WithInsecure())
+	if err != nil {
+		srv.Close()
+		t.Fatal(err)
+	}
+	client, err := pubsub.NewClient(context.Background(), "project", option.WithGRPCConn(conn))
+	if err != nil {
+		conn.Close()
+		srv.Close()
+		t.Fatal(err)
+	}
+	return client, func() {
+		conn.Close()
+		srv.Close()
+	}
+}
+
+func TestPublishReceive(t *testing.T) {
+	fr, sink, recorder := newTestRecorder()
+	client, cleanup := newTestClient(t)
+	defer cleanup()
+	ctx := context.Background()
+	topic, err := client.CreateTopic(ctx, "events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer topic.Stop()
+	sub, err := client.CreateSubscription(ctx, "workers", pubsub.SubscriptionConfig{Topic: topic})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fs, spanCtx, done := fr.WithNewSpan(ctx, "request")
+	requestID := fs.TraceSpan().Context().(basictracer.SpanContext).TraceID
+	msg := &pubsub.Message{Data: []byte("hello"), Attributes: map[string]string{"kind": "greeting"}}
+	_, err = NewTopic(fr, topic).Publish(spanCtx, msg).Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done()
+	assert.Equal(t, "greeting", msg.Attributes["kind"])
+
+	receiveCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	var mutex sync.Mutex
+	deliveries := 0
+	err = NewSubscription(fr, sub, Config{}).Receive(receiveCtx, func(ctx context.Context, msg *Message) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		deliveries++
+		assert.Equal(t, "hello", string(msg.Data))
+		if deliveries == 1 {
+			msg.Nack()
+			return
+		}
+		msg.Ack()
+		cancel()
+	})
+	assert.NoError(t, err)
+
+	assert.Equal(t, 1, sink.Invocations["pubsub.published, map[status:ok topic:events], 1, ct\n"])
+	assert.Equal(t, 2, sink.Invocations["pubsub.received, map[subscription:workers], 1, ct\n"])
+	assert.Equal(t, 1, sink.Invocations["pubsub.redelivered, map[subscription:workers], 1, ct\n"])
+	counts := map[string]int{}
+	for key, n := range sink.Invocations {
+		counts[key[:strings.Index(key, "]")+1]] += n
+	}
+	assert.Equal(t, 1, counts["pubsub.publish_latency_us, map[topic:events]"])
+	assert.Equal(t, 2, counts["pubsub.backlog_age_ms, map[subscription:workers]"])
+	assert.Equal(t, 1, counts["pubsub.ack_latency_us, map[result:ack subscription:workers]"])
+	assert.Equal(t, 1, counts["pubsub.ack_latency_us, map[result:nack subscription:workers]"])
+
+	receives := 0
+	for _, span := range recorder.GetSpans() {
+		if span.Operation != "test.pubsub.receive" {
+			continue
+		}
+		receives++
+		assert.Equal(t, requestID, span.Context.TraceID, "receiving continues the trace of the publisher")
+	}
+	assert.Equal(t, 2, receives)
+}
+
+func TestRecentIDs(t *testing.T) {
+	ids := newRecentIDs(2)
+	assert.False(t, ids.add("a"))
+	assert.False(t, ids.add("b"))
+	assert.True(t, ids.add("a"))
+	assert.False(t, ids.add("c"))
+	assert.False(t, ids.add("a"), "a was forgotten for c")
+	assert.True(t, ids.add("c"))
+}
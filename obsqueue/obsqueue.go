@@ -0,0 +1,193 @@
+// Package obsqueue provides an in-memory job queue instrumented with a FlightRecorder. Jobs are traced
+// as following from the span that enqueued them, and the queue reports, under its name:
+//
+//	depth          the number of jobs waiting, sampled like metrics.Receiver.RegisterGauge
+//	wait_us        the time jobs waited in the queue before a worker picked them up
+//	processing_us  the time spent running jobs, retries included
+//	retries        the attempts that were retried after an error
+//	success        jobs that returned nil
+//	failure        jobs that still failed after their retries, or panicked
+//	rejected       jobs refused by TryEnqueue because the queue was full
+package obsqueue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/mixpanel/obs"
+	"github.com/mixpanel/obs/obserr"
+	opentracing "github.com/opentracing/opentracing-go"
+)
+
+// Job is the work run by a worker. ctx is not canceled when the context given to Enqueue is, and
+// carries the span of the job.
+type Job func(ctx context.Context, fs obs.FlightSpan) error
+
+// Config configures a Queue.
+type Config struct {
+	// Workers is the number of jobs run concurrently. Defaults to 1.
+	Workers int
+	// Capacity is the number of jobs waiting before Enqueue blocks. Defaults to 100.
+	Capacity int
+	// MaxRetries is the number of times a job returning an error is run again. Panics are not retried.
+	MaxRetries int
+	// RetryBackoff is the wait before the first retry, doubled for every subsequent one. Defaults to
+	// 100 milliseconds.
+	RetryBackoff time.Duration
+}
+
+var (
+	// ErrFull is returned by TryEnqueue when the queue is at capacity.
+	ErrFull = errors.New("obsqueue: queue is full")
+	// ErrClosed is returned when enqueueing into a closed queue.
+	ErrClosed = errors.New("obsqueue: queue is closed")
+)
+
+// Queue runs jobs on a fixed number of workers, in the order they were enqueued.
+type Queue struct {
+	fr  obs.FlightRecorder
+	cfg Config
+
+	jobs       chan *item
+	unregister func()
+
+	mutex  sync.RWMutex // guards closed, and sending to jobs
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// item is a job along with a snapshot of where it was enqueued. Only the span is kept, rather than the
+// whole context, so that the values of a request are not retained while the job waits.
+type item struct {
+	job      Job
+	span     opentracing.Span
+	enqueued time.Time
+}
+
+// New starts the workers of a queue whose metrics and spans are named name.
+func New(fr obs.FlightRecorder, name string, cfg Config) *Queue {
+	if cfg.Workers <= 0 {
+		cfg.Workers = 1
+	}
+	if cfg.Capacity <= 0 {
+		cfg.Capacity = 100
+	}
+	if cfg.RetryBackoff <= 0 {
+		cfg.RetryBackoff = 100 * time.Millisecond
+	}
+
+	q := &Queue{
+		fr:   fr.ScopeName(name),
+		cfg:  cfg,
+		jobs: make(chan *item, cfg.Capacity),
+	}
+	q.unregister = q.fr.GetReceiver().RegisterGauge("depth", func() float64 {
+		return float64(q.Len())
+	})
+	q.wg.Add(cfg.Workers)
+	for i := 0; i < cfg.Workers; i++ {
+		go q.work()
+	}
+	return q
+}
+
+// Enqueue adds job to the queue, waiting for room until ctx is done.
+func (q *Queue) Enqueue(ctx context.Context, job Job) error {
+	q.mutex.RLock()
+	defer q.mutex.RUnlock()
+	if q.closed {
+		return ErrClosed
+	}
+	select {
+	case q.jobs <- newItem(ctx, job):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// TryEnqueue adds job to the queue, or returns ErrFull right away if there is no room.
+func (q *Queue) TryEnqueue(ctx context.Context, job Job) error {
+	q.mutex.RLock()
+	defer q.mutex.RUnlock()
+	if q.closed {
+		return ErrClosed
+	}
+	select {
+	case q.jobs <- newItem(ctx, job):
+		return nil
+	default:
+		q.fr.WithSpan(ctx).Incr("rejected")
+		return ErrFull
+	}
+}
+
+func newItem(ctx context.Context, job Job) *item {
+	return &item{job: job, span: opentracing.SpanFromContext(ctx), enqueued: time.Now()}
+}
+
+// Len returns the number of jobs waiting.
+func (q *Queue) Len() int {
+	return len(q.jobs)
+}
+
+// Close stops accepting jobs, and waits for the jobs already enqueued to run.
+func (q *Queue) Close() {
+	q.mutex.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.jobs)
+	}
+	q.mutex.Unlock()
+	q.wg.Wait()
+	q.unregister()
+}
+
+func (q *Queue) work() {
+	defer q.wg.Done()
+	for it := range q.jobs {
+		q.run(it)
+	}
+}
+
+func (q *Queue) run(it *item) {
+	ctx := context.Background()
+	if it.span != nil {
+		ctx = opentracing.ContextWithSpan(ctx, it.span)
+	}
+	fs, ctx, done := q.fr.WithFollowsFrom(ctx, "job")
+	defer done()
+	fs.AddStat("wait_us", float64(time.Since(it.enqueued)/time.Microsecond))
+
+	stop := fs.StartTimer("processing")
+	panicked, err := q.attempt(ctx, fs, it.job)
+	for retry := 0; err != nil && !panicked && retry < q.cfg.MaxRetries; retry++ {
+		fs.Incr("retries")
+		fs.Info("retrying job", obs.Vals{"retry": retry + 1}.WithError(err))
+		time.Sleep(q.cfg.RetryBackoff << uint(retry))
+		panicked, err = q.attempt(ctx, fs, it.job)
+	}
+	stop()
+
+	if err != nil {
+		fs.Incr("failure")
+		fs.ReportError(err)
+		return
+	}
+	fs.Incr("success")
+}
+
+// attempt runs job, and reports whether it panicked.
+func (q *Queue) attempt(ctx context.Context, fs obs.FlightSpan, job Job) (panicked bool, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = obserr.New(fmt.Sprintf("panic in job: %v", r)).Set("stack", string(debug.Stack()))
+			panicked = true
+		}
+	}()
+	return false, job(ctx, fs)
+}
@@ -0,0 +1,105 @@
+package obsqueue
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mixpanel/obs"
+	"github.com/mixpanel/obs/logging"
+	"github.com/mixpanel/obs/metrics"
+	basictracer "github.com/opentracing/basictracer-go"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestRecorder() (obs.FlightRecorder, *metrics.MockSink, *basictracer.InMemorySpanRecorder) {
+	sink := metrics.NewMockSink()
+	recorder := basictracer.NewInMemoryRecorder()
+	opts := basictracer.DefaultOptions()
+	opts.Recorder = recorder
+	opts.ShouldSample = func(uint64) bool { return true }
+	fr := obs.NewFlightRecorder("test", metrics.NewReceiver(sink), logging.Null, basictracer.NewWithOptions(opts))
+	return fr, sink, recorder
+}
+
+func TestQueue(t *testing.T) {
+	fr, sink, recorder := newTestRecorder()
+	q := New(fr, "queue", Config{MaxRetries: 2, RetryBackoff: time.Millisecond})
+
+	fs, ctx, done := fr.WithNewSpan(context.Background(), "request")
+	assert.NoError(t, q.Enqueue(ctx, func(ctx context.Context, fs obs.FlightSpan) error {
+		assert.NotNil(t, opentracing.SpanFromContext(ctx))
+		return nil
+	}))
+	attempts := 0
+	assert.NoError(t, q.Enqueue(ctx, func(ctx context.Context, fs obs.FlightSpan) error {
+		attempts++
+		if attempts < 2 {
+			return errors.New("transient")
+		}
+		return nil
+	}))
+	assert.NoError(t, q.Enqueue(ctx, func(ctx context.Context, fs obs.FlightSpan) error {
+		return errors.New("permanent")
+	}))
+	assert.NoError(t, q.Enqueue(ctx, func(ctx context.Context, fs obs.FlightSpan) error {
+		panic("boom")
+	}))
+	requestID := fs.TraceSpan().Context().(basictracer.SpanContext).SpanID
+	done()
+
+	q.Close()
+	assert.Equal(t, ErrClosed, q.Enqueue(context.Background(), nil))
+
+	assert.Equal(t, 2, sink.Invocations["queue.success, map[], 1, ct\n"])
+	assert.Equal(t, 2, sink.Invocations["queue.failure, map[], 1, ct\n"])
+	assert.Equal(t, 3, sink.Invocations["queue.retries, map[], 1, ct\n"])
+	counts := map[string]int{}
+	for key, n := range sink.Invocations {
+		counts[strings.SplitN(key, ",", 2)[0]] += n
+	}
+	assert.Equal(t, 4, counts["queue.wait_us"])
+	assert.Equal(t, 4, counts["queue.processing_us"])
+
+	jobs := 0
+	for _, span := range recorder.GetSpans() {
+		if span.Operation != "test.queue.job" {
+			continue
+		}
+		jobs++
+		assert.Equal(t, requestID, span.ParentSpanID, "jobs follow from the span that enqueued them")
+	}
+	assert.Equal(t, 4, jobs)
+}
+
+func TestQueueFull(t *testing.T) {
+	fr, sink, _ := newTestRecorder()
+	q := New(fr, "queue", Config{Capacity: 1})
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	release := make(chan struct{})
+	block := func(ctx context.Context, fs obs.FlightSpan) error {
+		wg.Done()
+		<-release
+		return nil
+	}
+	assert.NoError(t, q.Enqueue(context.Background(), block))
+	wg.Wait()
+	assert.NoError(t, q.TryEnqueue(context.Background(), func(context.Context, obs.FlightSpan) error { return nil }))
+	assert.Equal(t, 1, q.Len())
+
+	assert.Equal(t, ErrFull, q.TryEnqueue(context.Background(), nil))
+	assert.Equal(t, 1, sink.Invocations["queue.rejected, map[], 1, ct\n"])
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, q.Enqueue(ctx, nil))
+
+	close(release)
+	q.Close()
+	assert.Equal(t, 2, sink.Invocations["queue.success, map[], 1, ct\n"])
+}
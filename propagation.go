@@ -0,0 +1,33 @@
+package obs
+
+import (
+	"context"
+
+	opentracing "github.com/opentracing/opentracing-go"
+)
+
+// InjectSpanContext writes the context of the span in ctx into carrier, so that the trace can be
+// continued across transports that obs does not instrument, such as the attributes of queued
+// messages. It returns false if there is no span in ctx, or if its tracer does not propagate traces.
+func InjectSpanContext(ctx context.Context, carrier map[string]string) bool {
+	span := opentracing.SpanFromContext(ctx)
+	if span == nil || isNoopTracer(span.Tracer()) {
+		return false
+	}
+	err := span.Tracer().Inject(span.Context(), opentracing.TextMap, opentracing.TextMapCarrier(carrier))
+	return err == nil
+}
+
+// ExtractSpanContext reads the span context written into carrier by InjectSpanContext, with the
+// tracer of fr, for WithNewSpanContext. It returns nil if carrier holds none.
+func ExtractSpanContext(fr FlightRecorder, carrier map[string]string) opentracing.SpanContext {
+	tracer := tracerOf(fr)
+	if len(carrier) == 0 || isNoopTracer(tracer) {
+		return nil
+	}
+	spanCtx, err := tracer.Extract(opentracing.TextMap, opentracing.TextMapCarrier(carrier))
+	if err != nil {
+		return nil
+	}
+	return spanCtx
+}
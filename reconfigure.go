@@ -0,0 +1,133 @@
+package obs
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync/atomic"
+
+	"github.com/mixpanel/obs/logging"
+	"github.com/mixpanel/obs/metrics"
+)
+
+// LiveConfig is the part of the configuration of a FlightRecorder that can be changed while it runs,
+// with Reconfigure. See the config package to load it from a file.
+type LiveConfig struct {
+	// LogLevel is the level of the logs, such as INFO.
+	LogLevel string
+	// SampleRate samples one trace in *SampleRate, or none if it is 0.
+	SampleRate *uint64
+	// DeniedMetrics are the patterns of the names of the metrics dropped, and AllowedMetrics, if any, of
+	// the only metrics let through. The points dropped are counted by the denied_metrics gauge. See
+	// metrics.DenyListSink.
+	DeniedMetrics  []string
+	AllowedMetrics []string
+	// RedactedKeys and RedactedValues are the patterns of the secrets masked. See NewRedactor.
+	RedactedKeys   *regexp.Regexp
+	RedactedValues []*regexp.Regexp
+}
+
+// Reconfigure applies cfg to fr, and to the FlightRecorders and FlightSpans sharing its pipeline, while
+// they run. Settings missing from cfg go back to the ones fr was initialized with, so that removing a
+// setting from a configuration file undoes it: the redaction patterns are the initial ones when neither
+// RedactedKeys nor RedactedValues is set. Invalid settings are ignored and reported by the error, after
+// the valid ones are applied. Only the FlightRecorders built by InitGCP can be reconfigured.
+func Reconfigure(fr FlightRecorder, cfg LiveConfig) error {
+	r, ok := fr.(*flightRecorder)
+	if !ok || r.live == nil || r.config == nil {
+		return errors.New("the FlightRecorder cannot be reconfigured")
+	}
+	return r.live.apply(cfg, *r.config)
+}
+
+// liveSettings are the parts of the pipeline of a FlightRecorder changed by Reconfigure.
+type liveSettings struct {
+	logger        logging.LevelSetter // nil if the logger does not support it
+	sampling      *liveSampling       // nil if traces are not sampled by the FlightRecorder
+	deniedMetrics *metrics.DenyListSink
+	redactor      *Redactor
+	// initialRedaction are the patterns the redactor was created with.
+	initialRedaction *redactionRules
+}
+
+func newLiveSettings(l logging.Logger, sampling *liveSampling, deniedMetrics *metrics.DenyListSink, redactor *Redactor) *liveSettings {
+	s := &liveSettings{
+		sampling:         sampling,
+		deniedMetrics:    deniedMetrics,
+		redactor:         redactor,
+		initialRedaction: redactor.rules.Load().(*redactionRules),
+	}
+	s.logger, _ = l.(logging.LevelSetter)
+	return s
+}
+
+func (s *liveSettings) apply(cfg LiveConfig, initial recorderConfig) error {
+	var errs []string
+
+	logLevel := cfg.LogLevel
+	if logLevel == "" {
+		logLevel = initial.LogLevel
+	}
+	if s.logger == nil {
+		if cfg.LogLevel != "" {
+			errs = append(errs, "the log level cannot be changed")
+		}
+	} else if err := s.logger.SetLevel(logLevel); err != nil {
+		errs = append(errs, err.Error())
+	}
+
+	if s.sampling == nil {
+		if cfg.SampleRate != nil {
+			errs = append(errs, "the sample rate cannot be changed")
+		}
+	} else if cfg.SampleRate != nil {
+		s.sampling.setRate(*cfg.SampleRate)
+	} else {
+		s.sampling.reset()
+	}
+
+	if err := s.deniedMetrics.SetDenyList(cfg.DeniedMetrics); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if err := s.deniedMetrics.SetAllowList(cfg.AllowedMetrics); err != nil {
+		errs = append(errs, err.Error())
+	}
+
+	if cfg.RedactedKeys == nil && len(cfg.RedactedValues) == 0 {
+		s.redactor.SetRules(s.initialRedaction.keys, s.initialRedaction.values...)
+	} else {
+		s.redactor.SetRules(cfg.RedactedKeys, cfg.RedactedValues...)
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// liveSampling decides which traces are sampled, with a rate that can be changed while they are.
+type liveSampling struct {
+	initial func(traceID uint64) bool
+	sample  atomic.Value // func(traceID uint64) bool
+}
+
+// newLiveSampling returns a liveSampling deciding with initial until the rate is set.
+func newLiveSampling(initial func(traceID uint64) bool) *liveSampling {
+	s := &liveSampling{initial: initial}
+	s.sample.Store(initial)
+	return s
+}
+
+func (s *liveSampling) shouldSample(traceID uint64) bool {
+	return s.sample.Load().(func(uint64) bool)(traceID)
+}
+
+func (s *liveSampling) setRate(n uint64) {
+	s.sample.Store(sampleOneIn(n))
+}
+
+// reset goes back to the initial decisions.
+func (s *liveSampling) reset() {
+	s.sample.Store(s.initial)
+}
@@ -0,0 +1,78 @@
+package obs
+
+import (
+	"context"
+	"os"
+	"regexp"
+	"testing"
+
+	"github.com/mixpanel/obs/logging"
+	"github.com/mixpanel/obs/metrics"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReconfigure(t *testing.T) {
+	l := logging.New("NEVER", "INFO", "", "text")
+	sampling := newLiveSampling(sampleOneIn(100))
+	dst := &metrics.MockSink{Invocations: make(map[string]int)}
+	deniedMetrics := metrics.NewDenyListSink(dst)
+	fr := NewFlightRecorder("svc", metrics.NewReceiver(deniedMetrics).ScopePrefix("svc"), l, opentracing.NoopTracer{}).(*flightRecorder)
+	fr.redactor = DefaultRedactor()
+	fr.live = newLiveSettings(l, sampling, deniedMetrics, fr.redactor)
+	fr.config = &recorderConfig{Service: "svc", LogLevel: "INFO", SampleRate: 100}
+	scoped := fr.ScopeName("scoped")
+
+	one := uint64(1)
+	err := Reconfigure(scoped, LiveConfig{
+		LogLevel:      "WARN",
+		SampleRate:    &one,
+		DeniedMetrics: []string{"svc.scoped.*"},
+		RedactedKeys:  regexp.MustCompile(`^card$`),
+	})
+	assert.NoError(t, err)
+	assert.False(t, l.IsInfo())
+	assert.True(t, sampling.shouldSample(3))
+	scoped.GetReceiver().Incr("requests")
+	fr.GetReceiver().Incr("requests")
+	assert.Equal(t, map[string]int{"svc.requests, map[], 1, ct\n": 1}, dst.Invocations)
+	assert.Equal(t, Vals{"card": RedactedValue, "password": "hunter2"},
+		fr.redactor.Vals(Vals{"card": "4242", "password": "hunter2"}))
+
+	assert.NoError(t, Reconfigure(fr, LiveConfig{}))
+	assert.True(t, l.IsInfo())
+	assert.False(t, sampling.shouldSample(3))
+	scoped.GetReceiver().Incr("requests")
+	assert.Equal(t, 1, dst.Invocations["svc.scoped.requests, map[], 1, ct\n"])
+	assert.Equal(t, Vals{"card": "4242", "password": RedactedValue},
+		fr.redactor.Vals(Vals{"card": "4242", "password": "hunter2"}))
+
+	err = Reconfigure(fr, LiveConfig{LogLevel: "LOUD", SampleRate: &one})
+	assert.Error(t, err)
+	assert.True(t, l.IsInfo())
+	assert.True(t, sampling.shouldSample(3))
+
+	assert.NoError(t, Reconfigure(fr, LiveConfig{AllowedMetrics: []string{"svc.scoped.*"}}))
+	fr.GetReceiver().Incr("requests")
+	scoped.GetReceiver().Incr("requests")
+	assert.Equal(t, 1, dst.Invocations["svc.requests, map[], 1, ct\n"])
+	assert.Equal(t, 2, dst.Invocations["svc.scoped.requests, map[], 1, ct\n"])
+
+	assert.Error(t, Reconfigure(NullFR, LiveConfig{}))
+}
+
+func TestDeniedMetricsNotLimited(t *testing.T) {
+	os.Setenv(EnvTracer, "noop")
+	defer os.Unsetenv(EnvTracer)
+	fr, closer := InitGCP(context.Background(), "svc", "INFO", LogFormat("text"), MetricCardinalityLimit(1),
+		MetricsSnapshot(100))
+	defer closer()
+	assert.NoError(t, Reconfigure(fr, LiveConfig{DeniedMetrics: []string{"svc.noisy"}}))
+
+	r := fr.GetReceiver()
+	for _, user := range []string{"a", "b", "c"} {
+		r.ScopeTags(metrics.Tags{"user": user}).Incr("noisy")
+	}
+	assert.NotContains(t, r.Snapshot().Counters, metrics.SnapshotKey("svc.cardinality_limited", metrics.Tags{"metric": "svc.noisy"}),
+		"denied metrics do not overflow the cardinality limit")
+}
@@ -0,0 +1,82 @@
+package obs
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/mixpanel/obs/clock"
+	"github.com/mixpanel/obs/obserr"
+)
+
+// REDRecorder reports the rate, errors and duration of an operation under the same names and tags for
+// every operation, so that they can be graphed and alerted on alike. See RED.
+type REDRecorder struct {
+	fr    FlightRecorder
+	name  string
+	clock clock.Clock
+}
+
+// RED returns a REDRecorder for the operation name, which reports, tagged with the operation:
+//
+//	red.requests     completed operations, tagged with their status: ok, error or canceled
+//	red.errors       failed operations, tagged with the obserr code of the error as error_code, or
+//	                 unknown, and whether the error is obserr.IsRetryable as retryable
+//	red.duration_us  the duration of the operations, tagged with their status
+//
+// Operations failing with context.Canceled, or failing once their context is canceled, are canceled
+// rather than failed, since the caller gave up.
+func RED(fr FlightRecorder, name string) *REDRecorder {
+	c := clock.Real
+	if f, ok := fr.(*flightRecorder); ok {
+		c = f.clock
+	}
+	return &REDRecorder{fr: fr, name: name, clock: c}
+}
+
+// Start starts an operation in a new span named after it, child of the span of ctx if any, and
+// returns done, to be called with the error the operation failed with, or nil. Errors are reported
+// with ReportError.
+func (r *REDRecorder) Start(ctx context.Context) (fs FlightSpan, spanCtx context.Context, done func(err error)) {
+	fs, spanCtx, finish := r.fr.WithNewSpan(ctx, r.name)
+	start := r.clock.Now()
+	return fs, spanCtx, func(err error) {
+		defer finish()
+		d := r.clock.Since(start)
+		status := "ok"
+		if err != nil && (obserr.Original(err) == context.Canceled || ctx.Err() == context.Canceled) {
+			status = "canceled"
+		} else if err != nil {
+			status = "error"
+			fs.WithMetricTags(Tags{
+				"operation":  r.name,
+				"error_code": string(redErrorCode(ctx, err)),
+				"retryable":  strconv.FormatBool(obserr.IsRetryable(err)),
+			}).Incr("red.errors")
+			fs.ReportError(err)
+		}
+		tagged := fs.WithMetricTags(Tags{"operation": r.name, "status": status})
+		tagged.Incr("red.requests")
+		tagged.AddStat("red.duration_us", float64(d/time.Microsecond))
+	}
+}
+
+// Do runs f as an operation, as Start does, and returns its error.
+func (r *REDRecorder) Do(ctx context.Context, f func(ctx context.Context, fs FlightSpan) error) error {
+	fs, ctx, done := r.Start(ctx)
+	err := f(ctx, fs)
+	done(err)
+	return err
+}
+
+// redErrorCode returns the obserr code of err, the code matching context errors, or "unknown". Errors
+// of operations whose ctx expired are deemed caused by the deadline.
+func redErrorCode(ctx context.Context, err error) obserr.Code {
+	if code, ok := obserr.CodeOf(err); ok {
+		return code
+	}
+	if obserr.Original(err) == context.DeadlineExceeded || ctx.Err() == context.DeadlineExceeded {
+		return obserr.DeadlineExceeded
+	}
+	return "unknown"
+}
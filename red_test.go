@@ -0,0 +1,60 @@
+package obs
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mixpanel/obs/clock"
+	"github.com/mixpanel/obs/metrics"
+	"github.com/mixpanel/obs/obserr"
+	basictracer "github.com/opentracing/basictracer-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRED(t *testing.T) {
+	sink := metrics.NewMockSink()
+	c := clock.NewFake(time.Unix(0, 0))
+	fr := NewFlightRecorder("test", metrics.NewReceiverWithClock(sink, c), &testLogger{}, basictracer.New(basictracer.NewInMemoryRecorder())).(*flightRecorder)
+	fr.clock = c
+	red := RED(fr, "load_user")
+	ctx := context.Background()
+
+	_, _, done := red.Start(ctx)
+	c.Advance(2 * time.Millisecond)
+	done(nil)
+	err := red.Do(ctx, func(ctx context.Context, fs FlightSpan) error {
+		c.Advance(time.Millisecond)
+		return obserr.New("overloaded").WithCode(obserr.Unavailable)
+	})
+	assert.Error(t, err)
+	red.Do(ctx, func(ctx context.Context, fs FlightSpan) error {
+		return errors.New("plain")
+	})
+	red.Do(ctx, func(ctx context.Context, fs FlightSpan) error {
+		return context.Canceled
+	})
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	red.Do(canceled, func(ctx context.Context, fs FlightSpan) error {
+		return obserr.Annotate(errors.New("reading body"), "loading user")
+	})
+
+	assert.Equal(t, 1, sink.Invocations["red.requests, map[operation:load_user status:ok], 1, ct\n"])
+	assert.Equal(t, 2, sink.Invocations["red.requests, map[operation:load_user status:error], 1, ct\n"])
+	assert.Equal(t, 2, sink.Invocations["red.requests, map[operation:load_user status:canceled], 1, ct\n"])
+	assert.Equal(t, 1, sink.Invocations["red.errors, map[error_code:unavailable operation:load_user retryable:true], 1, ct\n"])
+	assert.Equal(t, 1, sink.Invocations["red.errors, map[error_code:unknown operation:load_user retryable:false], 1, ct\n"])
+	assert.Equal(t, 1, sink.Invocations["red.duration_us, map[operation:load_user status:ok], 2000, h\n"])
+	assert.Equal(t, 1, sink.Invocations["red.duration_us, map[operation:load_user status:error], 1000, h\n"])
+
+	parent, parentCtx, finish := fr.WithNewSpan(ctx, "handler")
+	defer finish()
+	fs, _, done := red.Start(parentCtx)
+	defer done(nil)
+	parentID := parent.TraceSpan().Context().(basictracer.SpanContext)
+	childID := fs.TraceSpan().Context().(basictracer.SpanContext)
+	assert.Equal(t, parentID.TraceID, childID.TraceID)
+	assert.NotEqual(t, parentID.SpanID, childID.SpanID)
+}
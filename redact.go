@@ -0,0 +1,205 @@
+package obs
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/mixpanel/obs/logging"
+)
+
+// DefaultRedactedKeys matches the keys of vals holding secrets, such as password, access_token,
+// Authorization, api_key or ssn.
+var DefaultRedactedKeys = regexp.MustCompile(`(?i)passw(or)?d|secret|token|authorization|api[_-]?key|credential|cookie|private[_-]?key|(^|[^a-z])ssn([^a-z]|$)`)
+
+// DefaultRedactedValues match credentials embedded in strings: bearer tokens, JWTs, AWS access keys,
+// PEM private keys, passwords in URLs and key=value pairs such as password=hunter2. Only the
+// subexpressions named secret are masked, when there are any.
+var DefaultRedactedValues = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)\bbearer\s+(?P<secret>[a-z0-9\-._~+/]+=*)`),
+	regexp.MustCompile(`\beyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+`),
+	regexp.MustCompile(`\b(AKIA|ASIA)[0-9A-Z]{16}\b`),
+	regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z ]*PRIVATE KEY-----`),
+	regexp.MustCompile(`://[^/\s:@]+:(?P<secret>[^/\s@]+)@`),
+	regexp.MustCompile(`(?i)\b(passw(or)?d|pwd|secret|token|api[_-]?key)\s*[=:]\s*(?P<secret>[^\s&,;"']+)`),
+}
+
+// Redactor masks secrets in vals before they are logged or set as span tags: the values of keys
+// matching its key pattern are replaced by RedactedValue, and so are the parts of string values
+// matching its value patterns, recursively in nested maps.
+type Redactor struct {
+	rules atomic.Value // *redactionRules
+}
+
+type redactionRules struct {
+	keys   *regexp.Regexp
+	values []*regexp.Regexp
+
+	// redactedKeys caches whether keys match, as there are few distinct keys.
+	redactedKeys sync.Map
+}
+
+// NewRedactor returns a Redactor masking the values of the keys matching keys, which may be nil, and
+// the parts of string values matching values.
+func NewRedactor(keys *regexp.Regexp, values ...*regexp.Regexp) *Redactor {
+	r := &Redactor{}
+	r.SetRules(keys, values...)
+	return r
+}
+
+// SetRules replaces the patterns of r while it is in use, for instance when they are reloaded from a
+// configuration file.
+func (r *Redactor) SetRules(keys *regexp.Regexp, values ...*regexp.Regexp) {
+	r.rules.Store(&redactionRules{keys: keys, values: values})
+}
+
+// load returns the rules of r, or nil if it masks nothing.
+func (r *Redactor) load() *redactionRules {
+	if r == nil {
+		return nil
+	}
+	rules := r.rules.Load().(*redactionRules)
+	if rules.keys == nil && len(rules.values) == 0 {
+		return nil
+	}
+	return rules
+}
+
+// DefaultRedactor returns a Redactor using DefaultRedactedKeys and DefaultRedactedValues.
+func DefaultRedactor() *Redactor {
+	return NewRedactor(DefaultRedactedKeys, DefaultRedactedValues...)
+}
+
+// RedactSecrets masks the secrets found by r in the vals and messages logged, and the vals set as span
+// tags, by the FlightRecorder. See DefaultRedactor.
+func RedactSecrets(r *Redactor) Option {
+	return func(o *obsOptions) {
+		o.redactor = r
+	}
+}
+
+// Vals returns a copy of vals with their secrets masked, or vals itself if it has none.
+func (r *Redactor) Vals(vals Vals) Vals {
+	rules := r.load()
+	if rules == nil {
+		return vals
+	}
+	redacted, _ := rules.redactMap(vals)
+	return redacted
+}
+
+// Fields is like Vals, for logging.Fields.
+func (r *Redactor) Fields(fields logging.Fields) logging.Fields {
+	rules := r.load()
+	if rules == nil {
+		return fields
+	}
+	redacted, _ := rules.redactMap(fields)
+	return redacted
+}
+
+// String returns s with the parts matching the value patterns masked.
+func (r *Redactor) String(s string) string {
+	rules := r.load()
+	if rules == nil {
+		return s
+	}
+	return rules.redactString(s)
+}
+
+func (r *redactionRules) redactString(s string) string {
+	for _, re := range r.values {
+		s = redactMatches(re, s)
+	}
+	return s
+}
+
+// redactMap returns a copy of m with its secrets masked and true, or m and false if it has none.
+func (r *redactionRules) redactMap(m map[string]interface{}) (map[string]interface{}, bool) {
+	var res map[string]interface{}
+	for k, v := range m {
+		redacted, changed := r.redactValue(k, v)
+		if !changed {
+			continue
+		}
+		if res == nil {
+			res = make(map[string]interface{}, len(m))
+			for k, v := range m {
+				res[k] = v
+			}
+		}
+		res[k] = redacted
+	}
+	if res == nil {
+		return m, false
+	}
+	return res, true
+}
+
+func (r *redactionRules) redactValue(key string, v interface{}) (interface{}, bool) {
+	if r.isSecretKey(key) {
+		return RedactedValue, true
+	}
+	switch v := v.(type) {
+	case string:
+		redacted := r.redactString(v)
+		return redacted, redacted != v
+	case map[string]interface{}:
+		return r.redactMap(v)
+	case Vals:
+		redacted, changed := r.redactMap(v)
+		return Vals(redacted), changed
+	case logging.Fields:
+		redacted, changed := r.redactMap(v)
+		return logging.Fields(redacted), changed
+	case error:
+		msg := v.Error()
+		if redacted := r.redactString(msg); redacted != msg {
+			return redacted, true
+		}
+	}
+	return v, false
+}
+
+func (r *redactionRules) isSecretKey(key string) bool {
+	if r.keys == nil {
+		return false
+	}
+	if secret, ok := r.redactedKeys.Load(key); ok {
+		return secret.(bool)
+	}
+	secret := r.keys.MatchString(key)
+	r.redactedKeys.Store(key, secret)
+	return secret
+}
+
+// redactMatches masks the matches of re in s, or only their subexpressions named secret if re has any.
+func redactMatches(re *regexp.Regexp, s string) string {
+	matches := re.FindAllStringSubmatchIndex(s, -1)
+	if matches == nil {
+		return s
+	}
+	secret := 0
+	for i, name := range re.SubexpNames() {
+		if name == "secret" {
+			secret = i
+		}
+	}
+	var b strings.Builder
+	last := 0
+	for _, m := range matches {
+		start, end := m[0], m[1]
+		if secret > 0 {
+			start, end = m[2*secret], m[2*secret+1]
+			if start < 0 {
+				continue
+			}
+		}
+		b.WriteString(s[last:start])
+		b.WriteString(RedactedValue)
+		last = end
+	}
+	b.WriteString(s[last:])
+	return b.String()
+}
@@ -0,0 +1,139 @@
+package obs
+
+import (
+	"os"
+	"strings"
+
+	"cloud.google.com/go/compute/metadata"
+	"github.com/mixpanel/obs/metrics"
+)
+
+// The attributes of a Resource, as they are named in spans, logs and metrics.
+const (
+	ResourceHostname = "hostname"
+	ResourcePod      = "pod"
+	ResourceNode     = "node"
+	ResourceZone     = "zone"
+	ResourceRegion   = "region"
+	ResourceVersion  = "service_version"
+)
+
+// localHostname is the hostname of the machine, or empty if it cannot be found.
+var localHostname, _ = os.Hostname()
+
+// Resource describes where a service runs. InitGCP detects it with DetectResource, and attaches its
+// attributes to every span and log, and to metrics with ResourceMetricTags, so that telemetry can be
+// sliced by pod or zone without every service tagging it.
+type Resource struct {
+	Hostname string
+	// Pod is the name of the Kubernetes pod.
+	Pod string
+	// Node is the name of the machine, such as the Kubernetes node or the GCE instance, that runs the
+	// service.
+	Node   string
+	Zone   string
+	Region string
+	// Version is the version of the service, such as its git commit.
+	Version string
+}
+
+// Tags returns the attributes of r that are set, keyed by their Resource* names.
+func (r Resource) Tags() Tags {
+	tags := make(Tags, 6)
+	for k, v := range map[string]string{
+		ResourceHostname: r.Hostname,
+		ResourcePod:      r.Pod,
+		ResourceNode:     r.Node,
+		ResourceZone:     r.Zone,
+		ResourceRegion:   r.Region,
+		ResourceVersion:  r.Version,
+	} {
+		if v != "" {
+			tags[k] = v
+		}
+	}
+	return tags
+}
+
+// DetectResource returns the Resource the service runs on. Its attributes are read from EnvPodName,
+// EnvNodeName, EnvZone, EnvRegion and EnvServiceVersion, which are meant to be set with the Kubernetes
+// downward API. In a Kubernetes pod, the pod defaults to the hostname. On GCP, the zone and the node
+// default to those of the instance, as told by the metadata server. The region defaults to that of
+// the zone.
+func DetectResource() Resource {
+	return detectResource(os.Getenv, gceMetadata{})
+}
+
+// resourceMetadata is the part of the GCE metadata server a Resource is detected with.
+type resourceMetadata interface {
+	OnGCE() bool
+	Zone() (string, error)
+	InstanceName() (string, error)
+}
+
+type gceMetadata struct{}
+
+func (gceMetadata) OnGCE() bool                   { return metadata.OnGCE() }
+func (gceMetadata) Zone() (string, error)         { return metadata.Zone() }
+func (gceMetadata) InstanceName() (string, error) { return metadata.InstanceName() }
+
+func detectResource(getenv func(string) string, md resourceMetadata) Resource {
+	r := Resource{
+		Hostname: localHostname,
+		Pod:      getenv(EnvPodName),
+		Node:     getenv(EnvNodeName),
+		Zone:     getenv(EnvZone),
+		Region:   getenv(EnvRegion),
+		Version:  getenv(EnvServiceVersion),
+	}
+	if r.Pod == "" && getenv("KUBERNETES_SERVICE_HOST") != "" {
+		// pods are named after their hostname
+		r.Pod = r.Hostname
+	}
+	if (r.Zone == "" || r.Node == "") && md.OnGCE() {
+		if r.Zone == "" {
+			r.Zone, _ = md.Zone()
+		}
+		if r.Node == "" {
+			r.Node, _ = md.InstanceName()
+		}
+	}
+	if r.Region == "" {
+		// zones are named after their region, as in us-central1-a
+		if i := strings.LastIndex(r.Zone, "-"); i > 0 {
+			r.Region = r.Zone[:i]
+		}
+	}
+	return r
+}
+
+// WithResource sets the Resource of InitGCP instead of detecting it with DetectResource.
+func WithResource(r Resource) Option {
+	return func(o *obsOptions) {
+		o.resource = &r
+	}
+}
+
+// ResourceMetricTags also tags metrics with the named attributes of the Resource, such as ResourceZone.
+// Metrics are not tagged by default, since attributes such as the pod multiply the number of series.
+func ResourceMetricTags(attributes ...string) Option {
+	return func(o *obsOptions) {
+		o.resourceMetricTags = append(o.resourceMetricTags, attributes...)
+	}
+}
+
+// resourceMetricTags returns the attributes of r to tag metrics with.
+func resourceMetricTags(r Resource, attributes []string) metrics.Tags {
+	all := r.Tags()
+	tags := make(metrics.Tags, len(attributes))
+	for _, k := range attributes {
+		if v, ok := all[k]; ok {
+			tags[k] = v
+		}
+	}
+	return tags
+}
+
+func (fr *flightRecorder) Resource() Resource {
+	return fr.resource
+}
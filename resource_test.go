@@ -0,0 +1,82 @@
+package obs
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/mixpanel/obs/metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeMetadata struct {
+	onGCE bool
+}
+
+func (m fakeMetadata) OnGCE() bool { return m.onGCE }
+
+func (m fakeMetadata) Zone() (string, error) { return "us-central1-b", nil }
+
+func (m fakeMetadata) InstanceName() (string, error) {
+	return "", errors.New("no instance name")
+}
+
+func TestDetectResource(t *testing.T) {
+	env := map[string]string{}
+	getenv := func(k string) string { return env[k] }
+
+	r := detectResource(getenv, fakeMetadata{})
+	assert.Equal(t, Resource{Hostname: localHostname}, r)
+
+	r = detectResource(getenv, fakeMetadata{onGCE: true})
+	assert.Equal(t, Resource{Hostname: localHostname, Zone: "us-central1-b", Region: "us-central1"}, r)
+
+	env[EnvNodeName] = "node-1"
+	env[EnvZone] = "europe-west1-c"
+	env[EnvServiceVersion] = "abc123"
+	env["KUBERNETES_SERVICE_HOST"] = "10.0.0.1"
+	r = detectResource(getenv, fakeMetadata{onGCE: true})
+	assert.Equal(t, Resource{
+		Hostname: localHostname,
+		Pod:      localHostname,
+		Node:     "node-1",
+		Zone:     "europe-west1-c",
+		Region:   "europe-west1",
+		Version:  "abc123",
+	}, r)
+
+	env[EnvPodName] = "api-7f9c"
+	env[EnvRegion] = "eu"
+	r = detectResource(getenv, fakeMetadata{})
+	assert.Equal(t, "api-7f9c", r.Pod)
+	assert.Equal(t, "eu", r.Region)
+}
+
+func TestResourceTags(t *testing.T) {
+	r := Resource{Hostname: "host", Pod: "api-7f9c", Zone: "us-central1-b"}
+	assert.Equal(t, Tags{"hostname": "host", "pod": "api-7f9c", "zone": "us-central1-b"}, r.Tags())
+	assert.Equal(t, metrics.Tags{"zone": "us-central1-b"}, resourceMetricTags(r, []string{ResourceZone, ResourceRegion}))
+}
+
+func TestResourceAttached(t *testing.T) {
+	root, l, recorder := newTestFlightRecorder()
+	fr := root.(*flightRecorder)
+	fr.resource = Resource{Pod: "api-7f9c", Zone: "us-central1-b"}
+	fr.resourceTags = fr.resource.Tags()
+
+	scoped := fr.ScopeTags(Tags{"zone": "override"})
+	assert.Equal(t, fr.resource, scoped.Resource())
+	fs, _, done := scoped.WithNewSpan(context.Background(), "load")
+	fs.Info("loading", nil)
+	done()
+
+	spans := recorder.GetSpans()
+	if assert.Len(t, spans, 1) {
+		assert.Equal(t, "api-7f9c", spans[0].Tags["pod"])
+		assert.Equal(t, "override", spans[0].Tags["zone"], "tags take precedence")
+	}
+	if assert.Len(t, l.entries, 1) {
+		assert.Equal(t, "api-7f9c", l.entries[0].fields["pod"])
+		assert.Equal(t, "override", l.entries[0].fields["zone"])
+	}
+}
@@ -0,0 +1,36 @@
+package obs
+
+import (
+	"context"
+
+	"github.com/opentracing/opentracing-go/ext"
+)
+
+// SamplingPriority overrides the sampling decision of a trace.
+type SamplingPriority uint16
+
+const (
+	// SamplingPriorityDrop discards the trace, for example for health checks.
+	SamplingPriorityDrop SamplingPriority = 0
+	// SamplingPriorityKeep records the trace regardless of the sample rate, for example for payments.
+	SamplingPriorityKeep SamplingPriority = 1
+)
+
+type samplingPriorityKey struct{}
+
+// ContextWithSamplingPriority returns a context in which root spans are started with priority p,
+// instead of being sampled at the configured rate or by the AdaptiveSampler.
+func ContextWithSamplingPriority(ctx context.Context, p SamplingPriority) context.Context {
+	return context.WithValue(ctx, samplingPriorityKey{}, p)
+}
+
+func samplingPriorityFromContext(ctx context.Context) (SamplingPriority, bool) {
+	p, ok := ctx.Value(samplingPriorityKey{}).(SamplingPriority)
+	return p, ok
+}
+
+func (fs *flightSpan) SetSamplingPriority(p SamplingPriority) {
+	if fs.span != nil {
+		ext.SamplingPriority.Set(fs.span, uint16(p))
+	}
+}
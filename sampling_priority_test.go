@@ -0,0 +1,53 @@
+package obs
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mixpanel/obs/metrics"
+
+	basictracer "github.com/opentracing/basictracer-go"
+	"github.com/opentracing/opentracing-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func newSamplingTestRecorder(sampled bool) FlightRecorder {
+	opts := basictracer.DefaultOptions()
+	opts.Recorder = basictracer.NewInMemoryRecorder()
+	opts.ShouldSample = func(traceID uint64) bool { return sampled }
+	return NewFlightRecorder("test", metrics.Null, &testLogger{}, basictracer.NewWithOptions(opts))
+}
+
+func sampled(fs FlightSpan) bool {
+	return fs.TraceSpan().Context().(basictracer.SpanContext).Sampled
+}
+
+func TestSetSamplingPriority(t *testing.T) {
+	fr := newSamplingTestRecorder(false)
+	fs, ctx, done := fr.WithNewSpan(context.Background(), "payment")
+	defer done()
+	fs.SetSamplingPriority(SamplingPriorityKeep)
+	assert.True(t, sampled(fs))
+
+	// Propagated to children and across processes.
+	child, _, childDone := fr.WithNewSpan(ctx, "charge")
+	defer childDone()
+	assert.True(t, sampled(child))
+
+	tracer := fs.TraceSpan().Tracer()
+	carrier := opentracing.TextMapCarrier{}
+	assert.NoError(t, tracer.Inject(child.TraceSpan().Context(), opentracing.TextMap, carrier))
+	remote, err := tracer.Extract(opentracing.TextMap, carrier)
+	assert.NoError(t, err)
+	downstream, _, downstreamDone := newSamplingTestRecorder(false).WithNewSpanContext(context.Background(), "ledger", remote)
+	defer downstreamDone()
+	assert.True(t, sampled(downstream))
+}
+
+func TestContextWithSamplingPriority(t *testing.T) {
+	fr := newSamplingTestRecorder(true)
+	ctx := ContextWithSamplingPriority(context.Background(), SamplingPriorityDrop)
+	fs, _, done := fr.WithNewSpan(ctx, "health")
+	defer done()
+	assert.False(t, sampled(fs))
+}
@@ -0,0 +1,98 @@
+//go:build go1.21
+// +build go1.21
+
+package obs
+
+import (
+	"context"
+	"log/slog"
+	"os"
+)
+
+// slogName names the warning and critical_error counters of the records logged through NewSlogHandler.
+const slogName = "slog"
+
+// slogHandler logs slog records to the span in their context.
+type slogHandler struct {
+	fr    FlightRecorder
+	vals  Vals   // the attrs given to WithAttrs
+	group string // the groups given to WithGroup, joined with dots and followed by one
+}
+
+// NewSlogHandler returns a slog.Handler, for libraries that log with log/slog, logging records to the
+// span in the context they are logged with, if any, or to fr otherwise. Records are logged at the
+// debug level below slog.LevelInfo, at the warning level from slog.LevelWarn, and as critical errors
+// from slog.LevelError, and their attributes become vals, named after their groups, as in
+// "request.method". It can back the default slog logger, and then the log package too: the lines
+// obs.logging itself writes to the standard logger are then written to stderr instead.
+func NewSlogHandler(fr FlightRecorder) slog.Handler {
+	return &slogHandler{fr: fr}
+}
+
+// Enabled tells whether the logger of the FlightRecorder logs level.
+func (h *slogHandler) Enabled(_ context.Context, level slog.Level) bool {
+	if fr, ok := h.fr.(*flightRecorder); ok {
+		return slogLevel(level).enabled(fr.l)
+	}
+	return true
+}
+
+func (h *slogHandler) Handle(ctx context.Context, r slog.Record) error {
+	if fromObsLogging() {
+		_, err := os.Stderr.WriteString(r.Message + "\n")
+		return err
+	}
+	vals := h.vals.Dupe()
+	r.Attrs(func(a slog.Attr) bool {
+		addSlogAttr(vals, h.group, a)
+		return true
+	})
+
+	logTo(h.fr.WithSpan(ctx), slogLevel(r.Level), slogName, r.Message, vals)
+	return nil
+}
+
+func slogLevel(level slog.Level) logLevel {
+	switch {
+	case level < slog.LevelInfo:
+		return logLevelDebug
+	case level < slog.LevelWarn:
+		return logLevelInfo
+	case level < slog.LevelError:
+		return logLevelWarn
+	}
+	return logLevelError
+}
+
+func (h *slogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	vals := h.vals.Dupe()
+	for _, a := range attrs {
+		addSlogAttr(vals, h.group, a)
+	}
+	return &slogHandler{fr: h.fr, vals: vals, group: h.group}
+}
+
+func (h *slogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return &slogHandler{fr: h.fr, vals: h.vals, group: h.group + name + "."}
+}
+
+// addSlogAttr adds a to vals, with its key prefixed by group, and flattens groups.
+func addSlogAttr(vals Vals, group string, a slog.Attr) {
+	v := a.Value.Resolve()
+	if v.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			group += a.Key + "."
+		}
+		for _, member := range v.Group() {
+			addSlogAttr(vals, group, member)
+		}
+		return
+	}
+	if a.Key == "" {
+		return
+	}
+	vals[group+a.Key] = v.Any()
+}
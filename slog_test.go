@@ -0,0 +1,41 @@
+//go:build go1.21
+// +build go1.21
+
+package obs
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSlogHandler(t *testing.T) {
+	fr, l, recorder := newTestFlightRecorder()
+	logger := slog.New(NewSlogHandler(fr)).With("component", "cache")
+
+	_, ctx, done := fr.WithNewSpan(context.Background(), "op")
+	logger.WithGroup("request").InfoContext(ctx, "hit", "key", "k1", slog.Group("size", "bytes", 42))
+	logger.Warn("evicting", "entries", 10)
+	logger.Error("failed")
+	logger.Debug("details")
+	done()
+
+	if assert.Len(t, l.entries, 4) {
+		assert.Equal(t, "INFO", l.entries[0].level)
+		assert.Equal(t, "hit", l.entries[0].message)
+		assert.Equal(t, "cache", l.entries[0].fields["component"])
+		assert.Equal(t, "k1", l.entries[0].fields["request.key"])
+		assert.EqualValues(t, 42, l.entries[0].fields["request.size.bytes"])
+		assert.Equal(t, "WARN", l.entries[1].level)
+		assert.Equal(t, "slog", l.entries[1].fields["warning_log_name"])
+		assert.EqualValues(t, 10, l.entries[1].fields["entries"])
+		assert.Equal(t, "ERROR", l.entries[2].level)
+		assert.Equal(t, "DEBUG", l.entries[3].level)
+	}
+	spans := recorder.GetSpans()
+	if assert.Len(t, spans, 1) {
+		assert.Equal(t, "hit", spans[0].Logs[0].Fields[0].Value())
+	}
+}
@@ -0,0 +1,74 @@
+package obs
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mixpanel/obs/metrics"
+)
+
+// SlowSpanThresholds are the durations past which spans are slow.
+type SlowSpanThresholds struct {
+	// Default is the threshold of the operations missing from Operations. 0 means they are never slow.
+	Default time.Duration
+	// Operations maps full operation names, as in "service.Get", to their thresholds.
+	Operations map[string]time.Duration
+}
+
+// SlowSpans reports the spans started with WithNewSpan and the like that last longer than their
+// threshold, to find the requests in the tail of the latency without searching the tracer UI. A slow
+// span is tagged slow=true, counted in slow_requests tagged with its operation, and logged as a
+// warning with its duration and the vals given to WithVals by the FlightSpans reporting into it.
+func SlowSpans(thresholds SlowSpanThresholds) Option {
+	return func(o *obsOptions) {
+		o.slowSpans = &thresholds
+	}
+}
+
+// threshold returns the threshold of the operation, or 0 if it is never slow.
+func (t *SlowSpanThresholds) threshold(operation string) time.Duration {
+	if d, ok := t.Operations[operation]; ok {
+		return d
+	}
+	return t.Default
+}
+
+// maxSlowSpanVals bounds the vals kept for the slow span warning, as spans calling WithVals in a loop
+// would otherwise grow them without bound.
+const maxSlowSpanVals = 64
+
+// addVals records vals given to WithVals for the slow span warning. Vals already recorded are
+// updated, and new ones are dropped once maxSlowSpanVals are recorded.
+func (s *spanState) addVals(vals Vals) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.vals == nil {
+		s.vals = make(Vals, len(vals))
+	}
+	for k, v := range vals {
+		if _, ok := s.vals[k]; ok || len(s.vals) < maxSlowSpanVals {
+			s.vals[k] = v
+		}
+	}
+}
+
+// reportSlow reports the span of fs if it lasted longer than its threshold.
+func (fs *flightSpan) reportSlow(d time.Duration) {
+	threshold := fs.slowSpans.threshold(fs.state.operation)
+	if threshold <= 0 || d <= threshold {
+		return
+	}
+	fs.span.SetTag("slow", true)
+	fs.receiver().ScopeTags(metrics.Tags{"operation": fs.state.operation}).Incr("slow_requests")
+
+	fs.state.mutex.Lock()
+	vals := fs.state.vals.Dupe()
+	fs.state.mutex.Unlock()
+	vals["operation"] = fs.state.operation
+	vals["duration_ms"] = float64(d) / float64(time.Millisecond)
+	vals["threshold_ms"] = float64(threshold) / float64(time.Millisecond)
+	message := fmt.Sprintf("slow %s: took %v, over %v", fs.state.operation, d, threshold)
+	fields := fs.logFields(vals)
+	fs.l.Warn(message, fields)
+	fs.logTrace(message, fields)
+}
@@ -0,0 +1,66 @@
+package obs
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/mixpanel/obs/clock"
+	"github.com/mixpanel/obs/metrics"
+	basictracer "github.com/opentracing/basictracer-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSlowSpans(t *testing.T) {
+	sink := metrics.NewMockSink()
+	l := &testLogger{}
+	recorder := basictracer.NewInMemoryRecorder()
+	fr := NewFlightRecorder("test", metrics.NewReceiver(sink), l, basictracer.New(recorder)).(*flightRecorder)
+	c := clock.NewFake(time.Unix(0, 0))
+	fr.clock = c
+	fr.slowSpans = &SlowSpanThresholds{
+		Default:    time.Second,
+		Operations: map[string]time.Duration{"test.batch": time.Minute},
+	}
+
+	fs, _, done := fr.WithNewSpan(context.Background(), "get")
+	fs.WithVals(Vals{"user_id": 7}).Info("loading", nil)
+	c.Advance(2 * time.Second)
+	done()
+
+	_, _, done = fr.WithNewSpan(context.Background(), "batch")
+	c.Advance(2 * time.Second)
+	done()
+
+	_, _, done = fr.WithNewSpan(context.Background(), "fast")
+	done()
+
+	assert.Equal(t, 1, sink.Invocations["slow_requests, map[operation:test.get], 1, ct\n"])
+	assert.Equal(t, 0, sink.Invocations["slow_requests, map[operation:test.batch], 1, ct\n"])
+	assert.Equal(t, 0, sink.Invocations["slow_requests, map[operation:test.fast], 1, ct\n"])
+	if assert.Len(t, l.entries, 2) {
+		warning := l.entries[1]
+		assert.Equal(t, "WARN", warning.level)
+		assert.Equal(t, "slow test.get: took 2s, over 1s", warning.message)
+		assert.Equal(t, 7, warning.fields["user_id"])
+		assert.Equal(t, 2000.0, warning.fields["duration_ms"])
+		assert.Equal(t, 1000.0, warning.fields["threshold_ms"])
+	}
+	spans := recorder.GetSpans()
+	if assert.Len(t, spans, 3) {
+		assert.Equal(t, true, spans[0].Tags["slow"])
+		assert.Nil(t, spans[1].Tags["slow"])
+		assert.Nil(t, spans[2].Tags["slow"])
+	}
+}
+
+func TestSlowSpanValsBounded(t *testing.T) {
+	s := &spanState{}
+	for i := 0; i < 2*maxSlowSpanVals; i++ {
+		s.addVals(Vals{fmt.Sprintf("val_%d", i): i, "last": i})
+	}
+	assert.Len(t, s.vals, maxSlowSpanVals)
+	assert.Equal(t, 2*maxSlowSpanVals-1, s.vals["last"], "vals already recorded are updated")
+	assert.NotContains(t, s.vals, fmt.Sprintf("val_%d", maxSlowSpanVals))
+}
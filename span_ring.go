@@ -0,0 +1,59 @@
+package obs
+
+import (
+	"sync"
+	"time"
+)
+
+// SpanRecord is the metadata kept for every span when metadata-only tracing is enabled.
+type SpanRecord struct {
+	TraceID   string
+	Operation string
+	Start     time.Time
+	Duration  time.Duration
+	Failed    bool
+	Sampled   bool
+}
+
+// SpanRing keeps the metadata of the most recently finished spans in a fixed-size ring buffer,
+// whether or not they were sampled for export. Install it with MetadataOnlyTracing.
+type SpanRing struct {
+	mu      sync.Mutex
+	records []SpanRecord
+	next    int
+	full    bool
+}
+
+// NewSpanRing returns a SpanRing that remembers the last size spans.
+func NewSpanRing(size int) *SpanRing {
+	if size < 1 {
+		size = 1
+	}
+	return &SpanRing{records: make([]SpanRecord, size)}
+}
+
+// Add records a finished span, evicting the oldest record if the ring is full.
+func (r *SpanRing) Add(rec SpanRecord) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.records[r.next] = rec
+	r.next++
+	if r.next == len(r.records) {
+		r.next = 0
+		r.full = true
+	}
+}
+
+// Snapshot returns the recorded spans, oldest first.
+func (r *SpanRing) Snapshot() []SpanRecord {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.full {
+		return append([]SpanRecord(nil), r.records[:r.next]...)
+	}
+	res := make([]SpanRecord, 0, len(r.records))
+	res = append(res, r.records[r.next:]...)
+	return append(res, r.records[:r.next]...)
+}
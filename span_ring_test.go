@@ -0,0 +1,59 @@
+package obs
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mixpanel/obs/logging"
+	"github.com/mixpanel/obs/metrics"
+
+	basictracer "github.com/opentracing/basictracer-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSpanRing(t *testing.T) {
+	ring := NewSpanRing(3)
+	assert.Empty(t, ring.Snapshot())
+
+	for _, op := range []string{"a", "b"} {
+		ring.Add(SpanRecord{Operation: op})
+	}
+	assert.Equal(t, []SpanRecord{{Operation: "a"}, {Operation: "b"}}, ring.Snapshot())
+
+	for _, op := range []string{"c", "d", "e"} {
+		ring.Add(SpanRecord{Operation: op})
+	}
+	assert.Equal(t, []SpanRecord{{Operation: "c"}, {Operation: "d"}, {Operation: "e"}}, ring.Snapshot())
+}
+
+func TestMetadataOnlyTracing(t *testing.T) {
+	ring := NewSpanRing(10)
+	o := obsOptions{tracerOpts: basictracer.DefaultOptions()}
+	NoTraces(&o)
+	MetadataOnlyTracing(ring)(&o)
+	recorder := basictracer.NewInMemoryRecorder()
+	o.tracerOpts.Recorder = recorder
+
+	sink := &metrics.MockSink{Invocations: make(map[string]int)}
+	fr := NewFlightRecorder("test", metrics.NewReceiver(sink), logging.Null, basictracer.NewWithOptions(o.tracerOpts)).(*flightRecorder)
+	fr.spans = o.spans
+
+	fs, _, done := fr.WithNewSpan(context.Background(), "op")
+	fs.WithVals(Vals{"user_id": 1})
+	markFailed(fs)
+	done()
+
+	records := ring.Snapshot()
+	if assert.Len(t, records, 1) {
+		assert.Equal(t, "test.op", records[0].Operation)
+		assert.True(t, records[0].Failed)
+		assert.False(t, records[0].Sampled)
+		assert.Len(t, records[0].TraceID, 32)
+	}
+
+	spans := recorder.GetSpans()
+	if assert.Len(t, spans, 1) {
+		assert.Empty(t, spans[0].Tags)
+	}
+	assert.Equal(t, 1, sink.Invocations["op.errors, map[], 1, ct\n"])
+}
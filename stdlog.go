@@ -0,0 +1,139 @@
+package obs
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/mixpanel/obs/logging"
+)
+
+// stdLogName names the warning and critical_error counters of the lines logged through StdLogger.
+const stdLogName = "stdlog"
+
+// logLevel is the level at which a FlightSpan logs a line that comes from another logging library.
+type logLevel int
+
+const (
+	logLevelDebug logLevel = iota
+	logLevelInfo
+	logLevelWarn
+	logLevelError
+)
+
+// enabled tells whether logger logs the level.
+func (level logLevel) enabled(logger logging.Logger) bool {
+	switch level {
+	case logLevelDebug:
+		return logger.IsDebug()
+	case logLevelInfo:
+		return logger.IsInfo()
+	case logLevelWarn:
+		return logger.IsWarn()
+	}
+	return logger.IsError()
+}
+
+// logTo logs message with vals to fs at level, counting warnings and errors under name.
+func logTo(fs FlightSpan, level logLevel, name, message string, vals Vals) {
+	switch level {
+	case logLevelDebug:
+		fs.Debug(message, vals)
+	case logLevelInfo:
+		fs.Info(message, vals)
+	case logLevelWarn:
+		fs.Warn(name, message, vals)
+	default:
+		fs.Critical(name, message, vals)
+	}
+}
+
+// logLevelPrefixes maps the level names that lines are commonly prefixed with to levels.
+var logLevelPrefixes = map[string]logLevel{
+	"trace":    logLevelDebug,
+	"debug":    logLevelDebug,
+	"info":     logLevelInfo,
+	"notice":   logLevelInfo,
+	"warn":     logLevelWarn,
+	"warning":  logLevelWarn,
+	"err":      logLevelError,
+	"error":    logLevelError,
+	"crit":     logLevelError,
+	"critical": logLevelError,
+	"fatal":    logLevelError,
+	"panic":    logLevelError,
+}
+
+// parseLogLevel returns the level named at the start of line, as in "[WARN] message", "ERROR: message"
+// or "debug message", and line without it. Lines without a level are at the info level.
+func parseLogLevel(line string) (logLevel, string) {
+	rest := strings.TrimLeft(line, " \t")
+	closing := ""
+	if strings.HasPrefix(rest, "[") {
+		rest, closing = rest[1:], "]"
+	}
+	end := strings.IndexFunc(rest, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z')
+	})
+	if end < 0 {
+		end = len(rest)
+	}
+	level, ok := logLevelPrefixes[strings.ToLower(rest[:end])]
+	if !ok {
+		return logLevelInfo, line
+	}
+	rest = rest[end:]
+	if closing != "" {
+		if !strings.HasPrefix(rest, closing) {
+			return logLevelInfo, line
+		}
+		rest = strings.TrimPrefix(rest[len(closing):], ":")
+	} else {
+		rest = strings.TrimPrefix(rest, ":")
+		if rest != "" && rest[0] != ' ' && rest[0] != '\t' {
+			// a word that starts with a level, like "information"
+			return logLevelInfo, line
+		}
+	}
+	return level, strings.TrimLeft(rest, " \t")
+}
+
+// fromObsLogging reports whether the caller was called by obs.logging, which writes through the
+// standard logger: logging its lines to a FlightSpan again, should the standard logger have been set
+// to write to a StdLogger or a slog handler, would loop forever.
+func fromObsLogging() bool {
+	pcs := make([]uintptr, 32)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(3, pcs)])
+	for {
+		frame, more := frames.Next()
+		if strings.HasPrefix(frame.Function, "github.com/mixpanel/obs/logging.") {
+			return true
+		}
+		if !more {
+			return false
+		}
+	}
+}
+
+// stdLogWriter logs the lines written by a log.Logger to fr.
+type stdLogWriter struct {
+	fr FlightRecorder
+	// loopback gets the lines written by obs.logging itself.
+	loopback io.Writer
+}
+
+func (fr *flightRecorder) StdLogger() *log.Logger {
+	return log.New(&stdLogWriter{fr: fr, loopback: os.Stderr}, "", 0)
+}
+
+func (w *stdLogWriter) Write(p []byte) (int, error) {
+	if fromObsLogging() {
+		return w.loopback.Write(p)
+	}
+	level, message := parseLogLevel(strings.TrimSuffix(string(p), "\n"))
+	logTo(w.fr.WithSpan(context.Background()), level, stdLogName, message, nil)
+	return len(p), nil
+}
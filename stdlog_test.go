@@ -0,0 +1,69 @@
+package obs
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"testing"
+
+	"github.com/mixpanel/obs/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLogLevel(t *testing.T) {
+	for _, tc := range []struct {
+		line    string
+		level   logLevel
+		message string
+	}{
+		{"[WARN] disk almost full", logLevelWarn, "disk almost full"},
+		{"ERROR: connection refused", logLevelError, "connection refused"},
+		{"debug retrying", logLevelDebug, "retrying"},
+		{"  [info]started", logLevelInfo, "started"},
+		{"[ERROR]: boom", logLevelError, "boom"},
+		{"fatal", logLevelError, ""},
+		{"information is key", logLevelInfo, "information is key"},
+		{"[WARN disk almost full", logLevelInfo, "[WARN disk almost full"},
+		{"no level", logLevelInfo, "no level"},
+	} {
+		level, message := parseLogLevel(tc.line)
+		assert.Equal(t, tc.level, level, tc.line)
+		assert.Equal(t, tc.message, message, tc.line)
+	}
+}
+
+func TestStdLogger(t *testing.T) {
+	fr, l, _ := newTestFlightRecorder()
+	logger := fr.StdLogger()
+	logger.Printf("[WARN] slow %s", "query")
+	logger.Print("hello")
+	logger.Println("error: boom")
+
+	if assert.Len(t, l.entries, 3) {
+		assert.Equal(t, "WARN", l.entries[0].level)
+		assert.Equal(t, "slow query", l.entries[0].message)
+		assert.Equal(t, "stdlog", l.entries[0].fields["warning_log_name"])
+		assert.Equal(t, "INFO", l.entries[1].level)
+		assert.Equal(t, "hello", l.entries[1].message)
+		assert.Equal(t, "ERROR", l.entries[2].level)
+		assert.Equal(t, "boom", l.entries[2].message)
+	}
+}
+
+func TestStdLoggerLoop(t *testing.T) {
+	fr, l, _ := newTestFlightRecorder()
+	obsLogger := logging.New("NEVER", "INFO", "", "text")
+	var loopback bytes.Buffer
+	defer log.SetOutput(os.Stderr)
+	defer log.SetFlags(log.Flags())
+	log.SetOutput(&stdLogWriter{fr: fr, loopback: &loopback})
+	log.SetFlags(0)
+
+	obsLogger.Info("from obs", nil)
+	log.Print("from a library")
+
+	assert.Contains(t, loopback.String(), "from obs")
+	if assert.Len(t, l.entries, 1) {
+		assert.Equal(t, "from a library", l.entries[0].message)
+	}
+}
@@ -0,0 +1,80 @@
+package obs
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mixpanel/obs/obserr"
+)
+
+// InstrumentTicker runs fn right away and then every interval, until the returned Closer is called.
+// Every run gets its own root span named name, whose context is canceled by the Closer, and reports:
+//
+//	<name>.latency  the duration of the run
+//	<name>.success  runs that returned nil
+//	<name>.failure  runs that returned an error or panicked; the error is reported with ReportError
+//	<name>.panics   runs that panicked, which are recovered
+//	<name>.skipped  ticks skipped because the previous run was still going
+//
+// The Closer waits for the current run to return.
+func InstrumentTicker(fr FlightRecorder, name string, interval time.Duration, fn func(ctx context.Context, fs FlightSpan) error) Closer {
+	ctx, cancel := context.WithCancel(context.Background())
+	var running int32
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if atomic.CompareAndSwapInt32(&running, 0, 1) {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					defer atomic.StoreInt32(&running, 0)
+					runJob(ctx, fr, name, fn)
+				}()
+			} else {
+				fr.WithSpan(ctx).Incr(name + ".skipped")
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return func() {
+		cancel()
+		wg.Wait()
+	}
+}
+
+func runJob(ctx context.Context, fr FlightRecorder, name string, fn func(ctx context.Context, fs FlightSpan) error) {
+	fs, ctx, done := fr.WithNewSpanContext(ctx, name, nil)
+	defer done()
+
+	err := func() (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				fs.Incr(name + ".panics")
+				err = obserr.New(fmt.Sprintf("panic in %s: %v", name, r)).Set("stack", string(debug.Stack()))
+			}
+		}()
+		return fn(ctx, fs)
+	}()
+
+	if err != nil {
+		fs.Incr(name + ".failure")
+		fs.ReportError(err)
+		return
+	}
+	fs.Incr(name + ".success")
+}
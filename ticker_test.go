@@ -0,0 +1,55 @@
+package obs
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mixpanel/obs/metrics"
+	"github.com/opentracing/opentracing-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInstrumentTicker(t *testing.T) {
+	sink := metrics.NewMockSink()
+	l := &testLogger{}
+	fr := NewFlightRecorder("test", metrics.NewReceiver(sink), l, opentracing.NoopTracer{})
+
+	var runs int32
+	blocked, block := make(chan struct{}), make(chan struct{})
+	stop := InstrumentTicker(fr, "job", time.Millisecond, func(ctx context.Context, fs FlightSpan) error {
+		switch atomic.AddInt32(&runs, 1) {
+		case 1:
+			return nil
+		case 2:
+			return errors.New("failed")
+		case 3:
+			panic("boom")
+		case 4:
+			// later ticks are skipped until the run returns
+			close(blocked)
+			<-block
+		}
+		return nil
+	})
+
+	<-blocked
+	time.Sleep(10 * time.Millisecond)
+	close(block)
+	stop()
+	n := atomic.LoadInt32(&runs)
+	time.Sleep(5 * time.Millisecond)
+	assert.Equal(t, n, atomic.LoadInt32(&runs), "no run after the Closer returns")
+
+	assert.True(t, sink.Invocations["job.skipped, map[], 1, ct\n"] > 0)
+	assert.Equal(t, 2, sink.Invocations["job.failure, map[], 1, ct\n"])
+	assert.Equal(t, 1, sink.Invocations["job.panics, map[], 1, ct\n"])
+	assert.True(t, sink.Invocations["job.success, map[], 1, ct\n"] >= 2)
+	if assert.True(t, len(l.entries) >= 2) {
+		assert.Equal(t, "failed", l.entries[0].message)
+		assert.Equal(t, "panic in job: boom", l.entries[1].message)
+		assert.Contains(t, l.entries[1].fields["stack"], "runJob")
+	}
+}
@@ -0,0 +1,56 @@
+package obs
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/mixpanel/obs/logging"
+)
+
+// TimerOption configures FlightSpan.StartTimer and FlightSpan.Time.
+type TimerOption func(*timerOptions)
+
+type timerOptions struct {
+	childSpan bool
+}
+
+// TimerChildSpan makes the timer start a child span named after the stage, so that the stage shows up
+// in traces with its own duration.
+func TimerChildSpan() TimerOption {
+	return func(o *timerOptions) {
+		o.childSpan = true
+	}
+}
+
+func (fs *flightSpan) StartTimer(stage string, opts ...TimerOption) func() {
+	var o timerOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	endSpan := func() {}
+	if o.childSpan {
+		ctx := fs.ctx
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		_, _, endSpan = fs.flightRecorder.WithNewSpan(ctx, stage)
+	}
+
+	start := fs.clock.Now()
+	return func() {
+		d := fs.clock.Since(start)
+		endSpan()
+		fs.receiver().AddStat(stage+"_us", float64(d/time.Microsecond))
+		if fs.tracing() {
+			fs.logTrace(fmt.Sprintf("%s took %s", stage, d), logging.Fields{"stage": stage, "duration_us": int64(d / time.Microsecond)})
+		}
+	}
+}
+
+func (fs *flightSpan) Time(stage string, f func(), opts ...TimerOption) {
+	done := fs.StartTimer(stage, opts...)
+	defer done()
+	f()
+}
@@ -0,0 +1,101 @@
+package obs
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mixpanel/obs/clock"
+	"github.com/mixpanel/obs/metrics"
+	basictracer "github.com/opentracing/basictracer-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimer(t *testing.T) {
+	sink := metrics.NewMockSink()
+	recorder := basictracer.NewInMemoryRecorder()
+	opts := basictracer.DefaultOptions()
+	opts.Recorder = recorder
+	fr := NewFlightRecorder("test", metrics.NewReceiver(sink), &testLogger{}, basictracer.NewWithOptions(opts))
+
+	fs, _, done := fr.WithNewSpan(context.Background(), "request")
+	stop := fs.StartTimer("parse")
+	stop()
+	ran := false
+	fs.Time("query", func() { ran = true }, TimerChildSpan())
+	done()
+
+	assert.True(t, ran)
+	var stats []string
+	for k := range sink.Invocations {
+		if strings.HasSuffix(k, ", h\n") {
+			stats = append(stats, strings.SplitN(k, ",", 2)[0])
+		}
+	}
+	assert.ElementsMatch(t, []string{"request.latency_us", "query.latency_us", "parse_us", "query_us"}, stats)
+
+	spans := recorder.GetSpans()
+	if assert.Len(t, spans, 2) {
+		assert.Equal(t, "test.query", spans[0].Operation)
+		assert.Equal(t, "test.request", spans[1].Operation)
+		assert.Equal(t, spans[1].Context.SpanID, spans[0].ParentSpanID)
+		var events []string
+		for _, l := range spans[1].Logs {
+			for _, f := range l.Fields {
+				if f.Key() == "event" {
+					events = append(events, f.Value().(string))
+				}
+			}
+		}
+		if assert.True(t, len(events) >= 2) {
+			assert.True(t, strings.HasPrefix(events[0], "parse took "))
+			assert.True(t, strings.HasPrefix(events[1], "query took "))
+		}
+	}
+}
+
+func TestTimerClock(t *testing.T) {
+	sink := metrics.NewMockSink()
+	c := clock.NewFake(time.Unix(0, 0))
+	fr := NewFlightRecorder("test", metrics.NewReceiverWithClock(sink, c), &testLogger{}, basictracer.New(basictracer.NewInMemoryRecorder())).(*flightRecorder)
+	fr.clock = c
+
+	fs, _, done := fr.WithNewSpan(context.Background(), "request")
+	stop := fs.StartTimer("parse")
+	c.Advance(2 * time.Millisecond)
+	stop()
+	sw := fs.StartStopwatch("query")
+	c.Advance(3 * time.Millisecond)
+	sw.Stop()
+	done()
+
+	assert.Equal(t, 1, sink.Invocations["parse_us, map[], 2000, h\n"])
+	assert.Equal(t, 1, sink.Invocations["query_us, map[], 3000, h\n"])
+	assert.Equal(t, 1, sink.Invocations["request.latency_us, map[], 5000, h\n"])
+}
+
+func TestInitGCPClock(t *testing.T) {
+	os.Setenv(EnvTracer, "noop")
+	defer os.Unsetenv(EnvTracer)
+	c := clock.NewFake(time.Unix(0, 0))
+	fr, closer := InitGCP(context.Background(), "svc", "INFO", LogFormat("text"), WithClock(c))
+	defer closer()
+
+	assert.Equal(t, clock.Clock(c), fr.(*flightRecorder).clock)
+	fs, _, done := fr.WithNewSpan(context.Background(), "request")
+	defer done()
+	assert.Equal(t, clock.Clock(c), fs.(*flightSpan).clock)
+	assert.Equal(t, clock.Clock(c), RED(fr, "load").clock)
+}
+
+func TestInitGCPMetricsSnapshot(t *testing.T) {
+	os.Setenv(EnvTracer, "noop")
+	defer os.Unsetenv(EnvTracer)
+	fr, closer := InitGCP(context.Background(), "svc", "INFO", LogFormat("text"), MetricsSnapshot(100))
+	defer closer()
+
+	fr.GetReceiver().Incr("requests")
+	assert.Equal(t, 1.0, fr.GetReceiver().Snapshot().Counters[metrics.SnapshotKey("svc.requests", nil)])
+}
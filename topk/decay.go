@@ -0,0 +1,99 @@
+package topk
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	// decayUnit is the weight of a value tracked at the landmark of a decayingTracker.
+	decayUnit = 1 << 10
+	// decayRebaseHalfLives is how many half-lives past the landmark weights are rebased, which keeps
+	// frequencies within an int64 for up to 2^32 values tracked per half-life.
+	decayRebaseHalfLives = 20
+	// decayMaxItems bounds the values a decayingTracker remembers; the least frequent is forgotten
+	// to make room for a new one.
+	decayMaxItems = bufferSize
+)
+
+// decayingTracker counts values with an exponential decay, so that a value tracked one half-life ago
+// weighs half as much as one tracked now, and tells whether a value is among the top k by decayed count.
+//
+// Rather than decaying every count as time passes, which would reorder nothing, later values are given
+// exponentially larger weights relative to a landmark time. The weights are rebased on a newer landmark
+// before they get too large, which forgets the values whose counts decayed to nothing.
+type decayingTracker struct {
+	items    map[int32]*item
+	sorted   itemList
+	k        int
+	halfLife time.Duration
+	landmark time.Time
+	now      func() time.Time
+}
+
+func newDecayingTracker(k int, halfLife time.Duration) *decayingTracker {
+	return &decayingTracker{
+		items:    make(map[int32]*item, k),
+		sorted:   make(itemList, 0, k),
+		k:        k,
+		halfLife: halfLife,
+		landmark: time.Now(),
+		now:      time.Now,
+	}
+}
+
+func (t *decayingTracker) track(value int32) bool {
+	now := t.now()
+	halfLives := float64(now.Sub(t.landmark)) / float64(t.halfLife)
+	if halfLives >= decayRebaseHalfLives {
+		t.rebase(math.Exp2(halfLives))
+		t.landmark = now
+		halfLives = 0
+	}
+	weight := int(decayUnit * math.Exp2(halfLives))
+	if weight < 1 {
+		// the clock went backwards
+		weight = 1
+	}
+
+	listItem, ok := t.items[value]
+	if !ok {
+		if len(t.sorted) >= decayMaxItems {
+			last := t.sorted[len(t.sorted)-1]
+			t.sorted.remove(last)
+			delete(t.items, last.value)
+		}
+		listItem = &item{value, 0, 0}
+		t.items[value] = listItem
+		t.sorted.put(listItem)
+	}
+	listItem.frequency += weight
+	t.sorted.fix(listItem)
+	return true
+}
+
+// rebase divides every frequency by factor, and forgets the values whose frequency drops to zero.
+// Dividing keeps the order, so they are all at the end of the sorted list.
+func (t *decayingTracker) rebase(factor float64) {
+	n := 0
+	for _, listItem := range t.sorted {
+		frequency := int(float64(listItem.frequency) / factor)
+		if frequency == 0 {
+			delete(t.items, listItem.value)
+			continue
+		}
+		listItem.frequency = frequency
+		n++
+	}
+	for i := n; i < len(t.sorted); i++ {
+		t.sorted[i] = nil
+	}
+	t.sorted = t.sorted[:n]
+}
+
+func (t *decayingTracker) isTopK(value int32) bool {
+	if item, ok := t.items[value]; ok {
+		return item.index < t.k
+	}
+	return false
+}
@@ -0,0 +1,52 @@
+package topk
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecayingTracker(t *testing.T) {
+	now := time.Unix(1000, 0)
+	tracker := newDecayingTracker(1, time.Minute)
+	tracker.now = func() time.Time { return now }
+	tracker.landmark = now
+
+	for i := 0; i < 100; i++ {
+		tracker.track(1)
+	}
+	assert.True(t, tracker.isTopK(1))
+
+	// 100 values two half-lives ago count as 25
+	now = now.Add(2 * time.Minute)
+	for i := 0; i < 20; i++ {
+		tracker.track(2)
+	}
+	assert.True(t, tracker.isTopK(1))
+	assert.False(t, tracker.isTopK(2))
+	for i := 0; i < 10; i++ {
+		tracker.track(2)
+	}
+	assert.False(t, tracker.isTopK(1))
+	assert.True(t, tracker.isTopK(2))
+
+	// rebasing forgets the values which decayed to nothing
+	now = now.Add(decayRebaseHalfLives * time.Minute)
+	tracker.track(3)
+	assert.True(t, tracker.isTopK(3))
+	assert.Len(t, tracker.items, 1)
+	assert.Len(t, tracker.sorted, 1)
+	assert.Equal(t, decayUnit, tracker.sorted[0].frequency)
+}
+
+func TestDecayingTrackerEviction(t *testing.T) {
+	tracker := newDecayingTracker(2, time.Hour)
+	for i := 0; i < decayMaxItems; i++ {
+		tracker.track(1)
+		tracker.track(int32(i + 2))
+	}
+	assert.True(t, tracker.isTopK(1))
+	assert.Len(t, tracker.items, decayMaxItems)
+	assert.True(t, isSorted(tracker.sorted))
+}
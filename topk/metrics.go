@@ -3,6 +3,7 @@ package topk
 import (
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/mixpanel/obs/metrics"
 )
@@ -24,12 +25,30 @@ type receiver struct {
 	wg      *sync.WaitGroup
 }
 
+// counter tells whether tracked values are among the top K.
+type counter interface {
+	track(int32) bool
+	isTopK(int32) bool
+}
+
+// New returns a Receiver reporting the values among the top k of the last values tracked, sampling
+// values when they are too uniform to fill the top k.
 func New(metrics metrics.Receiver, k int) Receiver {
+	return newReceiver(metrics, newTracker(k))
+}
+
+// NewDecaying returns a Receiver reporting the values among the top k by count decayed with the given
+// half-life, so that the top k reflects recent traffic: a value tracked one half-life ago counts half
+// as much as one tracked now.
+func NewDecaying(metrics metrics.Receiver, k int, halfLife time.Duration) Receiver {
+	return newReceiver(metrics, newDecayingTracker(k, halfLife))
+}
+
+func newReceiver(metrics metrics.Receiver, t counter) Receiver {
 	ch := make(chan int32, chanBufferSize)
 	wg := &sync.WaitGroup{}
 	wg.Add(1)
 	go func() {
-		t := newTracker(k)
 		for value := range ch {
 			if !t.track(value) {
 				metrics.Incr("sampled")
@@ -2,6 +2,7 @@ package topk
 
 import (
 	"testing"
+	"time"
 
 	"github.com/mixpanel/obs/metrics"
 	"github.com/stretchr/testify/mock"
@@ -55,10 +56,46 @@ func (mock *mockMetrics) Scope(prefix string, tags metrics.Tags) metrics.Receive
 	return mock
 }
 
-func (mock *mockMetrics) StartStopwatch(name string) metrics.Stopwatch {
+func (mock *mockMetrics) ScopeParts(parts ...string) metrics.Receiver {
+	return mock
+}
+
+func (mock *mockMetrics) Prefix() string {
+	return ""
+}
+
+func (mock *mockMetrics) StartStopwatch(name string, tags ...metrics.Tags) metrics.Stopwatch {
+	return nil
+}
+
+func (mock *mockMetrics) Counter(name string, tags metrics.Tags) metrics.Counter {
+	return nil
+}
+
+func (mock *mockMetrics) Gauge(name string, tags metrics.Tags) metrics.Gauge {
 	return nil
 }
 
+func (mock *mockMetrics) Timer(name string, tags metrics.Tags) metrics.Timer {
+	return nil
+}
+
+func (mock *mockMetrics) RegisterGauge(name string, f func() float64) func() {
+	return func() {}
+}
+
+func (mock *mockMetrics) At(t time.Time) metrics.Receiver {
+	return mock
+}
+
+func (mock *mockMetrics) IsNull() bool {
+	return false
+}
+
+func (mock *mockMetrics) Snapshot() metrics.Snapshot {
+	return metrics.Snapshot{}
+}
+
 func newMockMetrics() *mockMetrics {
 	return &mockMetrics{}
 }
@@ -5,8 +5,10 @@ import (
 	"sync"
 	"time"
 
+	"github.com/mixpanel/obs/clock"
 	"github.com/mixpanel/obs/metrics"
 	"github.com/mixpanel/obs/mixpanel"
+	"github.com/mixpanel/obs/util"
 )
 
 const (
@@ -26,34 +28,117 @@ type NullProjectTracker struct{}
 func (p *NullProjectTracker) Track(projectId int32, tags ...string) {}
 func (p *NullProjectTracker) Close()                                {}
 
+// Tracker counts keys, along with tags, and sends the counts of each key as an event every flush
+// interval, such as the requests of each endpoint or (project, endpoint) pair. Keys are strings,
+// integers or structs of them: they must be comparable, as map keys are.
+type Tracker interface {
+	Track(key interface{}, tags ...string)
+	Close()
+}
+
+// NullTracker discards the keys it tracks.
+type NullTracker struct{}
+
+func (p *NullTracker) Track(key interface{}, tags ...string) {}
+func (p *NullTracker) Close()                                {}
+
 type projectCounts map[string]int64
 
-type projectTracker struct {
-	ticker    *time.Ticker
-	client    mixpanel.Client
-	eventName string
-	receiver  metrics.Receiver
+// countShard holds the counts tracked by the goroutines of a few CPUs, so that concurrent Track calls
+// do not contend on a single mutex.
+type countShard struct {
+	mutex  sync.Mutex // guards counts
+	counts map[interface{}]projectCounts
+	_      [64]byte // keeps shards on distinct cache lines
+}
+
+type keyTracker struct {
+	ticker     clock.Ticker
+	client     mixpanel.Client
+	eventName  string
+	receiver   metrics.Receiver
+	properties func(key interface{}) map[string]interface{}
+
+	shards   []countShard
+	shardIdx *util.Shards
+}
 
-	mutex  sync.Mutex // guards everything below
-	counts map[int32]projectCounts
+// projectTracker is a keyTracker of int32 project ids.
+type projectTracker struct {
+	*keyTracker
 }
 
+// NewProjectTracker returns a ProjectTracker sending the counts of each project as an event named
+// eventName every flushInterval. Counts are lost if they cannot be sent, unless client is a
+// mixpanel.SpoolingClient.
 func NewProjectTracker(client mixpanel.Client,
 	receiver metrics.Receiver,
 	flushInterval time.Duration,
 	eventName string) ProjectTracker {
-	p := &projectTracker{
-		ticker:    time.NewTicker(flushInterval),
-		client:    client,
-		eventName: eventName,
-		receiver:  receiver,
-		counts:    make(map[int32]projectCounts),
+	return NewProjectTrackerWithClock(client, receiver, flushInterval, eventName, clock.Real)
+}
+
+// NewProjectTrackerWithClock is like NewProjectTracker, with the flushes driven by c.
+func NewProjectTrackerWithClock(client mixpanel.Client,
+	receiver metrics.Receiver,
+	flushInterval time.Duration,
+	eventName string,
+	c clock.Clock) ProjectTracker {
+	return &projectTracker{newKeyTracker(client, receiver, flushInterval, eventName, projectProperties, c)}
+}
+
+// projectProperties are the properties of the events of a ProjectTracker.
+func projectProperties(projectId interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"distinct_id": projectId,
+		"project_id":  projectId,
+	}
+}
+
+func (p *projectTracker) Track(projectId int32, tags ...string) {
+	p.track(projectId, tags)
+}
+
+// NewTracker returns a Tracker sending the counts of each key as an event named eventName every
+// flushInterval, with the properties returned by properties for the key, which should include a
+// distinct_id. Counts are lost if they cannot be sent, unless client is a mixpanel.SpoolingClient.
+func NewTracker(client mixpanel.Client,
+	receiver metrics.Receiver,
+	flushInterval time.Duration,
+	eventName string,
+	properties func(key interface{}) map[string]interface{}) Tracker {
+	return NewTrackerWithClock(client, receiver, flushInterval, eventName, properties, clock.Real)
+}
+
+// NewTrackerWithClock is like NewTracker, with the flushes driven by c.
+func NewTrackerWithClock(client mixpanel.Client,
+	receiver metrics.Receiver,
+	flushInterval time.Duration,
+	eventName string,
+	properties func(key interface{}) map[string]interface{},
+	c clock.Clock) Tracker {
+	return newKeyTracker(client, receiver, flushInterval, eventName, properties, c)
+}
+
+func newKeyTracker(client mixpanel.Client,
+	receiver metrics.Receiver,
+	flushInterval time.Duration,
+	eventName string,
+	properties func(key interface{}) map[string]interface{},
+	c clock.Clock) *keyTracker {
+	p := &keyTracker{
+		ticker:     c.NewTicker(flushInterval),
+		client:     client,
+		eventName:  eventName,
+		receiver:   receiver,
+		properties: properties,
 	}
+	p.initShards(0)
 
 	go func() {
 		for {
 			select {
-			case _, ok := <-p.ticker.C:
+			case _, ok := <-p.ticker.C():
 				if !ok {
 					return
 				}
@@ -65,22 +150,36 @@ func NewProjectTracker(client mixpanel.Client,
 	return p
 }
 
-func (p *projectTracker) Track(projectId int32, tags ...string) {
-	p.mutex.Lock()
-	defer p.mutex.Unlock()
+// initShards splits the counts into n shards, or one per CPU if n is not positive.
+func (p *keyTracker) initShards(n int) {
+	p.shardIdx = util.NewShards(n)
+	p.shards = make([]countShard, p.shardIdx.Len())
+	for i := range p.shards {
+		p.shards[i].counts = make(map[interface{}]projectCounts)
+	}
+}
 
-	if _, ok := p.counts[projectId]; !ok {
-		p.counts[projectId] = make(map[string]int64)
+func (p *keyTracker) Track(key interface{}, tags ...string) {
+	p.track(key, tags)
+}
+
+func (p *keyTracker) track(key interface{}, tags []string) {
+	shard := &p.shards[p.shardIdx.Index()]
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	if _, ok := shard.counts[key]; !ok {
+		shard.counts[key] = make(map[string]int64)
 	}
 
-	count := p.counts[projectId]
+	count := shard.counts[key]
 	count[CountTag]++
 	for _, tag := range tags {
 		count[tag]++
 	}
 }
 
-func (p *projectTracker) send(events []*mixpanel.TrackedEvent) {
+func (p *keyTracker) send(events []*mixpanel.TrackedEvent) {
 	err := p.client.TrackBatched(events)
 	p.receiver.IncrBy("num_sent_events", float64(len(events)))
 	if err != nil {
@@ -91,11 +190,36 @@ func (p *projectTracker) send(events []*mixpanel.TrackedEvent) {
 	}
 }
 
-func (p *projectTracker) flush() {
-	p.mutex.Lock()
-	counts := p.counts
-	p.counts = make(map[int32]projectCounts, len(counts))
-	p.mutex.Unlock()
+// collect returns the counts of all shards, merged, and resets them.
+func (p *keyTracker) collect() map[interface{}]projectCounts {
+	var counts map[interface{}]projectCounts
+	for i := range p.shards {
+		shard := &p.shards[i]
+		shard.mutex.Lock()
+		shardCounts := shard.counts
+		shard.counts = make(map[interface{}]projectCounts, len(shardCounts))
+		shard.mutex.Unlock()
+
+		if counts == nil {
+			counts = shardCounts
+			continue
+		}
+		for key, shardCount := range shardCounts {
+			count, ok := counts[key]
+			if !ok {
+				counts[key] = shardCount
+				continue
+			}
+			for k, v := range shardCount {
+				count[k] += v
+			}
+		}
+	}
+	return counts
+}
+
+func (p *keyTracker) flush() {
+	counts := p.collect()
 
 	if len(counts) == 0 {
 		return
@@ -104,11 +228,8 @@ func (p *projectTracker) flush() {
 	var events []*mixpanel.TrackedEvent
 
 	maxBatchSize := 100
-	for projectId, count := range counts {
-		props := map[string]interface{}{
-			"distinct_id": projectId,
-			"project_id":  projectId,
-		}
+	for key, count := range counts {
+		props := p.properties(key)
 
 		for k, v := range count {
 			props[k] = v
@@ -129,7 +250,7 @@ func (p *projectTracker) flush() {
 	}
 }
 
-func (p *projectTracker) Close() {
+func (p *keyTracker) Close() {
 	p.ticker.Stop()
 	p.flush()
 }
@@ -1,11 +1,10 @@
 package topk
 
 import (
-	"net/url"
-	"sync"
 	"testing"
 	"time"
 
+	"github.com/mixpanel/obs/clock"
 	"github.com/mixpanel/obs/metrics"
 	"github.com/mixpanel/obs/mixpanel"
 	"github.com/stretchr/testify/assert"
@@ -16,44 +15,22 @@ var (
 	PreSampling        = []string{PreSamplingTag}
 )
 
-type mockClient struct {
-	Events []*mixpanel.TrackedEvent
-	mutex  sync.Mutex
+func newProjectTracker() (*projectTracker, *mixpanel.MockClient) {
+	mockMpClient := mixpanel.NewMockClient()
+
+	p := &projectTracker{&keyTracker{
+		ticker:     clock.Real.NewTicker(10 * time.Second),
+		client:     mockMpClient,
+		receiver:   metrics.Null,
+		eventName:  "test_event",
+		properties: projectProperties,
+	}}
+	p.initShards(4)
+	return p, mockMpClient
 }
 
-func (mock *mockClient) TrackBatched(es []*mixpanel.TrackedEvent) error {
-	mock.mutex.Lock()
-	defer mock.mutex.Unlock()
-
-	for _, e := range es {
-		mock.Events = append(mock.Events, e)
-	}
-
-	return nil
-}
-
-func (mock *mockClient) Track(e *mixpanel.TrackedEvent) error     { return nil }
-func (mock *mockClient) Import(es []*mixpanel.TrackedEvent) error { return nil }
-func (mock *mockClient) UrlWithTracking(e *mixpanel.TrackedEvent, dest string) (*url.URL, error) {
-	return nil, nil
-}
-
-func newProjectTracker() (*projectTracker, *mockClient) {
-	mockMpClient := &mockClient{
-		Events: make([]*mixpanel.TrackedEvent, 0),
-	}
-
-	return &projectTracker{
-		ticker:    time.NewTicker(10 * time.Second),
-		client:    mockMpClient,
-		receiver:  metrics.Null,
-		eventName: "test_event",
-		counts:    make(map[int32]projectCounts),
-	}, mockMpClient
-}
-
-func testEvents(t *testing.T, projectIds []int32, tracker *projectTracker, client *mockClient, numEvents int) {
-	client.Events = make([]*mixpanel.TrackedEvent, 0)
+func testEvents(t *testing.T, projectIds []int32, tracker *projectTracker, client *mixpanel.MockClient, numEvents int) {
+	client.Reset()
 
 	for i := 0; i < numEvents; i++ {
 		for _, p := range projectIds {
@@ -67,10 +44,11 @@ func testEvents(t *testing.T, projectIds []int32, tracker *projectTracker, clien
 
 	tracker.flush()
 
-	assert.Equal(t, len(projectIds), len(client.Events))
+	events := client.Tracked()
+	assert.Equal(t, len(projectIds), len(events))
 
 	eventMap := make(map[int32]bool)
-	for _, e := range client.Events {
+	for _, e := range events {
 		eventMap[e.Properties["project_id"].(int32)] = true
 		assert.Equal(t, int64(numEvents), e.Properties[CountTag])
 		assert.Equal(t, int64(numEvents), e.Properties[PreSamplingTag])
@@ -94,9 +72,79 @@ func TestProjectTracker(t *testing.T) {
 
 	testEvents(t, projectIds, tracker, client, 20)
 
-	client.Events = make([]*mixpanel.TrackedEvent, 0)
+	client.Reset()
 	tracker.flush()
-	assert.Equal(t, 0, len(client.Events))
+	assert.Empty(t, client.Tracked())
 
 	testEvents(t, projectIds, tracker, client, 30)
 }
+
+func TestProjectTrackerMergesShards(t *testing.T) {
+	tracker, client := newProjectTracker()
+	tracker.shards[0].counts[int32(1)] = projectCounts{CountTag: 2, PreSamplingTag: 2}
+	tracker.shards[3].counts[int32(1)] = projectCounts{CountTag: 3, PostSamplingTag: 1}
+	tracker.shards[3].counts[int32(2)] = projectCounts{CountTag: 1}
+
+	tracker.flush()
+	counts := make(map[int32]map[string]interface{})
+	for _, e := range client.Tracked() {
+		counts[e.Properties["project_id"].(int32)] = e.Properties
+	}
+	assert.Len(t, counts, 2)
+	assert.Equal(t, int64(5), counts[1][CountTag])
+	assert.Equal(t, int64(2), counts[1][PreSamplingTag])
+	assert.Equal(t, int64(1), counts[1][PostSamplingTag])
+	assert.Equal(t, int64(1), counts[2][CountTag])
+}
+
+func TestProjectTrackerClock(t *testing.T) {
+	client := mixpanel.NewMockClient()
+	c := clock.NewFake(time.Unix(0, 0))
+	tracker := NewProjectTrackerWithClock(client, metrics.Null, time.Minute, "test_event", c)
+	defer tracker.Close()
+	tracker.Track(1)
+
+	c.Advance(time.Minute)
+	deadline := time.Now().Add(time.Second)
+	for len(client.Tracked()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	assert.Len(t, client.Tracked(), 1)
+}
+
+// BenchmarkProjectTrackerTrack measures concurrent Track calls, which should scale with -cpu as
+// goroutines on different CPUs update different shards.
+func BenchmarkProjectTrackerTrack(b *testing.B) {
+	tracker, _ := newProjectTracker()
+	tracker.initShards(0)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			tracker.Track(1, PreSamplingTag)
+		}
+	})
+}
+
+func TestTrackerStructKeys(t *testing.T) {
+	type endpoint struct {
+		project int32
+		path    string
+	}
+	client := mixpanel.NewMockClient()
+	tracker := NewTrackerWithClock(client, metrics.Null, time.Minute, "endpoint_counts",
+		func(key interface{}) map[string]interface{} {
+			e := key.(endpoint)
+			return map[string]interface{}{"distinct_id": e.project, "project_id": e.project, "path": e.path}
+		}, clock.NewFake(time.Unix(0, 0)))
+	tracker.Track(endpoint{1, "/track"}, PreSamplingTag)
+	tracker.Track(endpoint{1, "/track"})
+	tracker.Track(endpoint{1, "/engage"})
+	tracker.Close()
+
+	counts := make(map[string]int64)
+	for _, e := range client.Tracked() {
+		assert.Equal(t, "endpoint_counts", e.EventName)
+		assert.Equal(t, int32(1), e.Properties["project_id"])
+		counts[e.Properties["path"].(string)] = e.Properties[CountTag].(int64)
+	}
+	assert.Equal(t, map[string]int64{"/track": 2, "/engage": 1}, counts)
+}
@@ -0,0 +1,273 @@
+package topk
+
+import (
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/mixpanel/obs/clock"
+	"github.com/mixpanel/obs/metrics"
+)
+
+// OtherTenant is the tenant tag value of the metrics of the tenants beyond the budget of a
+// TenantReceiver.
+const OtherTenant = "other"
+
+// TenantConfig configures a TenantReceiver.
+type TenantConfig struct {
+	// TagKey is the tag holding the tenant. Defaults to "tenant".
+	TagKey string
+	// Budget is how many tenants are tagged by name for each metric. Defaults to 20.
+	Budget int
+	// HalfLife is how fast the volume of a tenant decays, so that the budget goes to the tenants
+	// busiest lately. Defaults to 10 minutes.
+	HalfLife time.Duration
+	// Clock defaults to clock.Real.
+	Clock clock.Clock
+}
+
+// TenantReceiver tags metrics with the tenant they are recorded for, within a budget of tenants per
+// metric, so that multi-tenant services get per-tenant metrics without unbounded cardinality.
+type TenantReceiver interface {
+	// For returns a Receiver tagging its metrics with tenant if it is among the Budget tenants with
+	// the most metrics recorded lately under the same name, and with OtherTenant otherwise. A tenant
+	// gets its own tag as soon as it is busy enough, and loses it once it is not.
+	For(tenant string) metrics.Receiver
+}
+
+type tenantReceiver struct {
+	metrics metrics.Receiver
+	cfg     TenantConfig
+
+	mutex    sync.Mutex // guards trackers
+	trackers map[string]*decayingTracker
+}
+
+// NewTenantReceiver returns a TenantReceiver recording metrics to r.
+func NewTenantReceiver(r metrics.Receiver, cfg TenantConfig) TenantReceiver {
+	if cfg.TagKey == "" {
+		cfg.TagKey = "tenant"
+	}
+	if cfg.Budget <= 0 {
+		cfg.Budget = 20
+	}
+	if cfg.HalfLife <= 0 {
+		cfg.HalfLife = 10 * time.Minute
+	}
+	if cfg.Clock == nil {
+		cfg.Clock = clock.Real
+	}
+	return &tenantReceiver{
+		metrics:  r,
+		cfg:      cfg,
+		trackers: make(map[string]*decayingTracker),
+	}
+}
+
+func (t *tenantReceiver) For(tenant string) metrics.Receiver {
+	return &tenantScope{
+		parent: t,
+		id:     tenantID(tenant),
+		tagged: t.metrics.ScopeTags(metrics.Tags{t.cfg.TagKey: tenant}),
+		other:  t.metrics.ScopeTags(metrics.Tags{t.cfg.TagKey: OtherTenant}),
+	}
+}
+
+// tenantID hashes tenant for the trackers, which count int32 values. Tenants whose hashes collide
+// share their volume, which barely matters for a budget.
+func tenantID(tenant string) int32 {
+	h := fnv.New32a()
+	h.Write([]byte(tenant))
+	return int32(h.Sum32())
+}
+
+// track counts a metric for the tenant id, and tells whether the tenant is within the budget of the
+// metric.
+func (t *tenantReceiver) track(metric string, id int32) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	tracker, ok := t.trackers[metric]
+	if !ok {
+		tracker = newDecayingTracker(t.cfg.Budget, t.cfg.HalfLife)
+		tracker.now = t.cfg.Clock.Now
+		tracker.landmark = t.cfg.Clock.Now()
+		t.trackers[metric] = tracker
+	}
+	tracker.track(id)
+	return tracker.isTopK(id)
+}
+
+// tenantScope is the Receiver of a tenant, which records to tagged or other.
+type tenantScope struct {
+	parent *tenantReceiver
+	id     int32
+	tagged metrics.Receiver
+	other  metrics.Receiver
+}
+
+// pick tracks metric name and returns the receiver to record it to.
+func (s *tenantScope) pick(name string) metrics.Receiver {
+	if s.tracked(name) {
+		return s.tagged
+	}
+	return s.other
+}
+
+func (s *tenantScope) scope(scope func(metrics.Receiver) metrics.Receiver) metrics.Receiver {
+	return &tenantScope{
+		parent: s.parent,
+		id:     s.id,
+		tagged: scope(s.tagged),
+		other:  scope(s.other),
+	}
+}
+
+func (s *tenantScope) Incr(name string) {
+	s.pick(name).Incr(name)
+}
+
+func (s *tenantScope) IncrBy(name string, amount float64) {
+	s.pick(name).IncrBy(name, amount)
+}
+
+func (s *tenantScope) AddStat(name string, value float64) {
+	s.pick(name).AddStat(name, value)
+}
+
+func (s *tenantScope) SetGauge(name string, value float64) {
+	s.pick(name).SetGauge(name, value)
+}
+
+func (s *tenantScope) ScopePrefix(prefix string) metrics.Receiver {
+	return s.scope(func(r metrics.Receiver) metrics.Receiver { return r.ScopePrefix(prefix) })
+}
+
+func (s *tenantScope) ScopeTags(tags metrics.Tags) metrics.Receiver {
+	return s.scope(func(r metrics.Receiver) metrics.Receiver { return r.ScopeTags(tags) })
+}
+
+func (s *tenantScope) Scope(prefix string, tags metrics.Tags) metrics.Receiver {
+	return s.scope(func(r metrics.Receiver) metrics.Receiver { return r.Scope(prefix, tags) })
+}
+
+func (s *tenantScope) ScopeParts(parts ...string) metrics.Receiver {
+	return s.scope(func(r metrics.Receiver) metrics.Receiver { return r.ScopeParts(parts...) })
+}
+
+func (s *tenantScope) Prefix() string {
+	return s.tagged.Prefix()
+}
+
+// StartStopwatch counts the stopwatch when it starts, deciding then how it is tagged.
+func (s *tenantScope) StartStopwatch(name string, tags ...metrics.Tags) metrics.Stopwatch {
+	return s.pick(name).StartStopwatch(name, tags...)
+}
+
+// Counter counts every increment, deciding then how it is tagged, like Incr.
+func (s *tenantScope) Counter(name string, tags metrics.Tags) metrics.Counter {
+	return &tenantCounter{
+		scope:  s,
+		name:   name,
+		tagged: s.tagged.Counter(name, tags),
+		other:  s.other.Counter(name, tags),
+	}
+}
+
+// Gauge counts every value set, deciding then how it is tagged, like SetGauge.
+func (s *tenantScope) Gauge(name string, tags metrics.Tags) metrics.Gauge {
+	return &tenantGauge{
+		scope:  s,
+		name:   name,
+		tagged: s.tagged.Gauge(name, tags),
+		other:  s.other.Gauge(name, tags),
+	}
+}
+
+// Timer counts every duration recorded, deciding then how it is tagged, like StartStopwatch.
+func (s *tenantScope) Timer(name string, tags metrics.Tags) metrics.Timer {
+	return &tenantTimer{
+		scope:  s,
+		name:   name,
+		tagged: s.tagged.Timer(name, tags),
+		other:  s.other.Timer(name, tags),
+	}
+}
+
+// RegisterGauge decides how the gauge is tagged when it is registered, counting it once.
+func (s *tenantScope) RegisterGauge(name string, f func() float64) func() {
+	return s.pick(name).RegisterGauge(name, f)
+}
+
+// tracked tells whether the tenant of s is within the budget of metric name.
+func (s *tenantScope) tracked(name string) bool {
+	return s.parent.track(s.tagged.Prefix()+name, s.id)
+}
+
+type tenantCounter struct {
+	scope         *tenantScope
+	name          string
+	tagged, other metrics.Counter
+}
+
+func (c *tenantCounter) pick() metrics.Counter {
+	if c.scope.tracked(c.name) {
+		return c.tagged
+	}
+	return c.other
+}
+
+func (c *tenantCounter) Incr() {
+	c.pick().Incr()
+}
+
+func (c *tenantCounter) IncrBy(amount float64) {
+	c.pick().IncrBy(amount)
+}
+
+type tenantGauge struct {
+	scope         *tenantScope
+	name          string
+	tagged, other metrics.Gauge
+}
+
+func (g *tenantGauge) Set(value float64) {
+	if g.scope.tracked(g.name) {
+		g.tagged.Set(value)
+		return
+	}
+	g.other.Set(value)
+}
+
+type tenantTimer struct {
+	scope         *tenantScope
+	name          string
+	tagged, other metrics.Timer
+}
+
+func (t *tenantTimer) pick() metrics.Timer {
+	if t.scope.tracked(t.name) {
+		return t.tagged
+	}
+	return t.other
+}
+
+func (t *tenantTimer) Record(d time.Duration) {
+	t.pick().Record(d)
+}
+
+func (t *tenantTimer) Since(start time.Time) {
+	t.pick().Since(start)
+}
+
+func (s *tenantScope) At(t time.Time) metrics.Receiver {
+	return s.scope(func(r metrics.Receiver) metrics.Receiver { return r.At(t) })
+}
+
+func (s *tenantScope) IsNull() bool {
+	return s.tagged.IsNull()
+}
+
+func (s *tenantScope) Snapshot() metrics.Snapshot {
+	return s.tagged.Snapshot()
+}
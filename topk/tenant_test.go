@@ -0,0 +1,73 @@
+package topk
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mixpanel/obs/clock"
+	"github.com/mixpanel/obs/metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTenantReceiver(t *testing.T) {
+	c := clock.NewFake(time.Unix(0, 0).UTC())
+	r := metrics.NewReceiverWithClock(metrics.NewSnapshotSink(metrics.NewMockSink(), 0), c)
+	tr := NewTenantReceiver(r.ScopePrefix("svc"), TenantConfig{Budget: 2, HalfLife: time.Minute, Clock: c})
+
+	for i := 0; i < 10; i++ {
+		tr.For("a").Incr("requests")
+		tr.For("b").Incr("requests")
+	}
+	tr.For("c").Incr("requests")
+	tr.For("c").ScopePrefix("db").AddStat("latency", 3)
+
+	counters := r.Snapshot().Counters
+	assert.Equal(t, 10.0, counters[metrics.SnapshotKey("svc.requests", metrics.Tags{"tenant": "a"})])
+	assert.Equal(t, 10.0, counters[metrics.SnapshotKey("svc.requests", metrics.Tags{"tenant": "b"})])
+	assert.Equal(t, 1.0, counters[metrics.SnapshotKey("svc.requests", metrics.Tags{"tenant": OtherTenant})])
+	assert.NotContains(t, counters, metrics.SnapshotKey("svc.requests", metrics.Tags{"tenant": "c"}))
+
+	// the budget is per metric
+	stats := r.Snapshot().Stats
+	assert.Equal(t, int64(1), stats[metrics.SnapshotKey("svc.db.latency", metrics.Tags{"tenant": "c"})].Count)
+
+	// c takes over from a, which has been idle for a while
+	c.Advance(10 * time.Minute)
+	for i := 0; i < 5; i++ {
+		tr.For("b").Incr("requests")
+		tr.For("c").Incr("requests")
+	}
+	counters = r.Snapshot().Counters
+	assert.Equal(t, 5.0, counters[metrics.SnapshotKey("svc.requests", metrics.Tags{"tenant": "c"})])
+	assert.Equal(t, 15.0, counters[metrics.SnapshotKey("svc.requests", metrics.Tags{"tenant": "b"})])
+}
+
+func TestTenantReceiverTagKey(t *testing.T) {
+	r := metrics.NewReceiver(metrics.NewSnapshotSink(metrics.NewMockSink(), 0))
+	tr := NewTenantReceiver(r, TenantConfig{TagKey: "project_id", Budget: 1})
+
+	tr.For("1").ScopeTags(metrics.Tags{"status": "ok"}).Incr("events")
+	tr.For("2").Incr("events")
+
+	counters := r.Snapshot().Counters
+	assert.Equal(t, 1.0, counters[metrics.SnapshotKey("events", metrics.Tags{"project_id": "1", "status": "ok"})])
+	assert.Equal(t, 1.0, counters[metrics.SnapshotKey("events", metrics.Tags{"project_id": OtherTenant})])
+}
+
+func TestTenantReceiverHandles(t *testing.T) {
+	r := metrics.NewReceiver(metrics.NewSnapshotSink(metrics.NewMockSink(), 0))
+	tr := NewTenantReceiver(r, TenantConfig{Budget: 1})
+
+	a := tr.For("a").Counter("events", metrics.Tags{"status": "ok"})
+	b := tr.For("b").Counter("events", metrics.Tags{"status": "ok"})
+	a.Incr()
+	a.Incr()
+	b.IncrBy(3)
+	tr.For("b").Timer("load", nil).Record(time.Millisecond)
+
+	snap := r.Snapshot()
+	assert.Equal(t, 2.0, snap.Counters[metrics.SnapshotKey("events", metrics.Tags{"status": "ok", "tenant": "a"})])
+	assert.Equal(t, 3.0, snap.Counters[metrics.SnapshotKey("events", metrics.Tags{"status": "ok", "tenant": OtherTenant})])
+	assert.Equal(t, int64(1), snap.Stats[metrics.SnapshotKey("load_us", metrics.Tags{"tenant": "b"})].Count,
+		"the budget is per metric")
+}
@@ -0,0 +1,75 @@
+package tracing
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+
+	basictracer "github.com/opentracing/basictracer-go"
+	opentracing "github.com/opentracing/opentracing-go"
+	jaeger "github.com/uber/jaeger-client-go"
+)
+
+// IDGenerator returns the random numbers trace and span IDs are made of. It must be safe for
+// concurrent use. IDs are never 0, so tracers draw another number when it returns 0.
+type IDGenerator func() uint64
+
+// SeededIDs returns an IDGenerator drawing numbers from a source seeded with seed, so that tests get
+// the same IDs on every run.
+func SeededIDs(seed int64) IDGenerator {
+	var mutex sync.Mutex
+	rng := rand.New(rand.NewSource(seed))
+	return func() uint64 {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return rng.Uint64()
+	}
+}
+
+// SequentialIDs returns an IDGenerator returning start, start+1, start+2 and so on, so that tests can
+// tell which IDs their spans get.
+func SequentialIDs(start uint64) IDGenerator {
+	next := start - 1
+	return func() uint64 {
+		return atomic.AddUint64(&next, 1)
+	}
+}
+
+// TraceID returns the trace ID of sc as 32 hexadecimal digits, as in W3C traceparent headers and
+// X-Cloud-Trace-Context headers, or false if sc is not from a basictracer or jaeger tracer. 64-bit
+// trace IDs are padded with zeros.
+func TraceID(sc opentracing.SpanContext) (string, bool) {
+	switch sc := sc.(type) {
+	case basictracer.SpanContext:
+		return fmt.Sprintf("%032x", sc.TraceID), true
+	case jaeger.SpanContext:
+		id := sc.TraceID()
+		return fmt.Sprintf("%016x%016x", id.High, id.Low), true
+	}
+	return "", false
+}
+
+// SpanID returns the span ID of sc as 16 hexadecimal digits, or false if sc is not from a basictracer
+// or jaeger tracer.
+func SpanID(sc opentracing.SpanContext) (string, bool) {
+	switch sc := sc.(type) {
+	case basictracer.SpanContext:
+		return fmt.Sprintf("%016x", sc.SpanID), true
+	case jaeger.SpanContext:
+		return fmt.Sprintf("%016x", uint64(sc.SpanID())), true
+	}
+	return "", false
+}
+
+// IsSampled reports whether sc is sampled, or false for ok if sc is not from a basictracer or jaeger
+// tracer.
+func IsSampled(sc opentracing.SpanContext) (sampled, ok bool) {
+	switch sc := sc.(type) {
+	case basictracer.SpanContext:
+		return sc.Sampled, true
+	case jaeger.SpanContext:
+		return sc.IsSampled(), true
+	}
+	return false, false
+}
@@ -0,0 +1,198 @@
+package tracing
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	opentracing "github.com/opentracing/opentracing-go"
+	jaeger "github.com/uber/jaeger-client-go"
+	jaegercfg "github.com/uber/jaeger-client-go/config"
+)
+
+// DefaultJaegerAgentAddr is the address of the jaeger-agent sidecar NewJaeger reports to by default.
+const DefaultJaegerAgentAddr = "127.0.0.1:6831"
+
+// JaegerConfig is the configuration of the tracers returned by NewJaeger and NewZipkin.
+type JaegerConfig struct {
+	jaegercfg.Configuration
+	// TraceID128Bit makes trace IDs 128 bits long, like those of W3C traceparent headers, rather than 64.
+	TraceID128Bit bool
+	// IDs generates the trace and span IDs. Defaults to random numbers.
+	IDs IDGenerator
+}
+
+// JaegerOption configures the tracer returned by NewJaeger.
+type JaegerOption func(*JaegerConfig)
+
+// ConstSampler samples all traces if sample is true, and none otherwise.
+func ConstSampler(sample bool) JaegerOption {
+	param := 0.0
+	if sample {
+		param = 1
+	}
+	return sampler(jaeger.SamplerTypeConst, param)
+}
+
+// ProbabilisticSampler samples traces with the given probability, between 0 and 1.
+// This is the default, with a probability of 0.01.
+func ProbabilisticSampler(probability float64) JaegerOption {
+	return sampler(jaeger.SamplerTypeProbabilistic, probability)
+}
+
+// RateLimitingSampler samples at most tracesPerSecond traces per second.
+func RateLimitingSampler(tracesPerSecond float64) JaegerOption {
+	return sampler(jaeger.SamplerTypeRateLimiting, tracesPerSecond)
+}
+
+func sampler(samplerType string, param float64) JaegerOption {
+	return func(c *JaegerConfig) {
+		c.Sampler = &jaegercfg.SamplerConfig{Type: samplerType, Param: param}
+	}
+}
+
+// JaegerCollector sends spans over HTTP directly to the jaeger-collector at endpoint, for example
+// "http://jaeger-collector:14268/api/traces", instead of to the agent.
+func JaegerCollector(endpoint string) JaegerOption {
+	return func(c *JaegerConfig) {
+		c.Reporter.CollectorEndpoint = endpoint
+	}
+}
+
+// JaegerFlushInterval sets how often buffered spans are sent. Defaults to 1 second.
+func JaegerFlushInterval(d time.Duration) JaegerOption {
+	return func(c *JaegerConfig) {
+		c.Reporter.BufferFlushInterval = d
+	}
+}
+
+// JaegerProcessTags adds tags to the process reported with every span, replacing the default
+// version tag if tags has the same key.
+func JaegerProcessTags(tags map[string]string) JaegerOption {
+	return func(c *JaegerConfig) {
+		for i := 0; i < len(c.Tags); i++ {
+			if _, ok := tags[c.Tags[i].Key]; ok {
+				c.Tags = append(c.Tags[:i], c.Tags[i+1:]...)
+				i--
+			}
+		}
+		for k, v := range tags {
+			c.Tags = append(c.Tags, opentracing.Tag{Key: k, Value: v})
+		}
+	}
+}
+
+// JaegerTraceID128Bit makes trace IDs 128 bits long rather than 64, which are padded with zeros in
+// W3C traceparent and X-Cloud-Trace-Context headers.
+func JaegerTraceID128Bit() JaegerOption {
+	return func(c *JaegerConfig) {
+		c.TraceID128Bit = true
+	}
+}
+
+// JaegerIDs makes the tracer generate trace and span IDs with ids, such as SeededIDs in tests.
+func JaegerIDs(ids IDGenerator) JaegerOption {
+	return func(c *JaegerConfig) {
+		c.IDs = ids
+	}
+}
+
+// NewJaeger returns a tracer reporting spans of serviceName to the jaeger-agent at agentAddr, or
+// DefaultJaegerAgentAddr when it is empty. Spans are sampled with a probability of 0.01 unless a
+// sampler option says otherwise. The process is tagged with the hostname and, when the binary was
+// built with module support, the version of its main module. The returned function flushes
+// buffered spans and closes the tracer.
+func NewJaeger(serviceName, agentAddr string, opts ...JaegerOption) (opentracing.Tracer, func(), error) {
+	if agentAddr == "" {
+		agentAddr = DefaultJaegerAgentAddr
+	}
+	cfg := &JaegerConfig{Configuration: jaegercfg.Configuration{
+		ServiceName: serviceName,
+		Sampler:     &jaegercfg.SamplerConfig{Type: jaeger.SamplerTypeProbabilistic, Param: 0.01},
+		Reporter: &jaegercfg.ReporterConfig{
+			LocalAgentHostPort:  agentAddr,
+			BufferFlushInterval: time.Second,
+		},
+		Tags: processTags(),
+	}}
+	for _, o := range opts {
+		o(cfg)
+	}
+
+	tracer, closer, err := cfg.newTracer(nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error initializing jaeger tracer: %v", err)
+	}
+	return tracer, func() { closer.Close() }, nil
+}
+
+// newTracer returns the tracer of c, with the options of opts. The jaeger configuration cannot set how
+// IDs are generated, so when c.IDs is set the tracer is built from the sampler, reporter, tags and
+// headers of the configuration, leaving out its RPC metrics, baggage restrictions and throttler.
+func (c *JaegerConfig) newTracer(opts *tracerOptions) (opentracing.Tracer, io.Closer, error) {
+	if opts == nil {
+		opts = &tracerOptions{}
+	}
+	if c.IDs == nil || c.Disabled {
+		cfgOpts := append(opts.config(), jaegercfg.Gen128Bit(c.TraceID128Bit))
+		return c.NewTracer(cfgOpts...)
+	}
+
+	if c.ServiceName == "" {
+		return nil, nil, fmt.Errorf("no service name provided")
+	}
+	m := jaeger.NewNullMetrics()
+	sampler, err := c.Sampler.NewSampler(c.ServiceName, m)
+	if err != nil {
+		return nil, nil, err
+	}
+	reporter := opts.reporter
+	if reporter == nil {
+		if reporter, err = c.Reporter.NewReporter(c.ServiceName, m, jaeger.NullLogger); err != nil {
+			return nil, nil, err
+		}
+	}
+	tracerOpts := append(opts.tracer(),
+		jaeger.TracerOptions.CustomHeaderKeys(c.Headers),
+		jaeger.TracerOptions.RandomNumber(c.IDs),
+		jaeger.TracerOptions.Gen128Bit(c.TraceID128Bit))
+	for _, tag := range c.Tags {
+		tracerOpts = append(tracerOpts, jaeger.TracerOptions.Tag(tag.Key, tag.Value))
+	}
+	tracer, closer := jaeger.NewTracer(c.ServiceName, sampler, reporter, tracerOpts...)
+	return tracer, closer, nil
+}
+
+// tracerOptions are the options NewZipkin adds to the tracer of a JaegerConfig.
+type tracerOptions struct {
+	reporter            jaeger.Reporter
+	propagators         map[interface{}]b3Propagator
+	zipkinSharedRPCSpan bool
+}
+
+// config returns o as options of jaegercfg.Configuration.NewTracer.
+func (o *tracerOptions) config() []jaegercfg.Option {
+	var opts []jaegercfg.Option
+	if o.reporter != nil {
+		opts = append(opts, jaegercfg.Reporter(o.reporter))
+	}
+	for format, p := range o.propagators {
+		opts = append(opts, jaegercfg.Injector(format, p), jaegercfg.Extractor(format, p))
+	}
+	if o.zipkinSharedRPCSpan {
+		opts = append(opts, jaegercfg.ZipkinSharedRPCSpan(true))
+	}
+	return opts
+}
+
+// tracer returns o as options of jaeger.NewTracer, except the reporter.
+func (o *tracerOptions) tracer() []jaeger.TracerOption {
+	var opts []jaeger.TracerOption
+	for format, p := range o.propagators {
+		opts = append(opts, jaeger.TracerOptions.Injector(format, p), jaeger.TracerOptions.Extractor(format, p))
+	}
+	if o.zipkinSharedRPCSpan {
+		opts = append(opts, jaeger.TracerOptions.ZipkinSharedRPCSpan(true))
+	}
+	return opts
+}
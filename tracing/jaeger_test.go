@@ -0,0 +1,94 @@
+package tracing
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/stretchr/testify/assert"
+	jaeger "github.com/uber/jaeger-client-go"
+	jaegercfg "github.com/uber/jaeger-client-go/config"
+)
+
+func TestNewJaeger(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	tracer, closeTracer, err := NewJaeger("my-service", conn.LocalAddr().String(),
+		ConstSampler(true),
+		JaegerProcessTags(map[string]string{"version": "1.2.3"}))
+	assert.NoError(t, err)
+
+	tracer.StartSpan("my-operation").Finish()
+	closeTracer()
+
+	buf := make([]byte, 65000)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if assert.NoError(t, err) {
+		batch := string(buf[:n])
+		assert.True(t, strings.Contains(batch, "my-service"))
+		assert.True(t, strings.Contains(batch, "my-operation"))
+		assert.True(t, strings.Contains(batch, "1.2.3"))
+	}
+}
+
+func TestJaegerProcessTags(t *testing.T) {
+	cfg := &JaegerConfig{Configuration: jaegercfg.Configuration{Tags: []opentracing.Tag{{Key: "version", Value: "v1.0.0"}}}}
+	JaegerProcessTags(map[string]string{"version": "override", "team": "core"})(cfg)
+
+	tags := make(map[string]interface{})
+	for _, tag := range cfg.Tags {
+		_, dup := tags[tag.Key]
+		assert.False(t, dup, tag.Key)
+		tags[tag.Key] = tag.Value
+	}
+	assert.Equal(t, "override", tags["version"])
+	assert.Equal(t, "core", tags["team"])
+}
+
+func TestJaegerIDs(t *testing.T) {
+	tracer, closeTracer, err := NewJaeger("my-service", "", ConstSampler(true),
+		JaegerTraceID128Bit(), JaegerIDs(SequentialIDs(1)))
+	assert.NoError(t, err)
+	defer closeTracer()
+
+	// the tracer draws the first ID for its own UUID
+	span := tracer.StartSpan("my-operation")
+	defer span.Finish()
+	sc := span.Context().(jaeger.SpanContext)
+	assert.Equal(t, jaeger.TraceID{High: 3, Low: 2}, sc.TraceID())
+	assert.Equal(t, jaeger.SpanID(2), sc.SpanID())
+	id, ok := TraceID(sc)
+	assert.True(t, ok)
+	assert.Equal(t, "00000000000000030000000000000002", id)
+
+	child := tracer.StartSpan("child", opentracing.ChildOf(sc))
+	defer child.Finish()
+	assert.Equal(t, sc.TraceID(), child.Context().(jaeger.SpanContext).TraceID())
+	assert.Equal(t, jaeger.SpanID(4), child.Context().(jaeger.SpanContext).SpanID())
+}
+
+func TestJaegerDefaultIDs(t *testing.T) {
+	tracer, closeTracer, err := NewJaeger("my-service", "", ConstSampler(true))
+	assert.NoError(t, err)
+	defer closeTracer()
+
+	span := tracer.StartSpan("my-operation")
+	defer span.Finish()
+	sc := span.Context().(jaeger.SpanContext)
+	assert.Zero(t, sc.TraceID().High)
+	id, _ := TraceID(sc)
+	assert.Len(t, id, 32)
+}
+
+func TestSeededIDs(t *testing.T) {
+	a, b := SeededIDs(42), SeededIDs(42)
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, a(), b())
+	}
+	assert.NotEqual(t, SeededIDs(1)(), SeededIDs(2)())
+}
@@ -0,0 +1,20 @@
+//go:build go1.12
+// +build go1.12
+
+package tracing
+
+import (
+	"runtime/debug"
+
+	opentracing "github.com/opentracing/opentracing-go"
+)
+
+// processTags returns the process tags reported in addition to the hostname and IP, which the jaeger
+// client reports on its own: the version of the main module, read from the build info of Go 1.12 on.
+func processTags() []opentracing.Tag {
+	var tags []opentracing.Tag
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		tags = append(tags, opentracing.Tag{Key: "version", Value: info.Main.Version})
+	}
+	return tags
+}
@@ -0,0 +1,12 @@
+//go:build !go1.12
+// +build !go1.12
+
+package tracing
+
+import opentracing "github.com/opentracing/opentracing-go"
+
+// processTags returns no tags, as the build info holding the version of the main module is only
+// available from Go 1.12 on.
+func processTags() []opentracing.Tag {
+	return nil
+}
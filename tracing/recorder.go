@@ -7,6 +7,8 @@ import (
 	"sync"
 	"time"
 
+	"github.com/mixpanel/obs/faultinject"
+
 	"cloud.google.com/go/compute/metadata"
 
 	"golang.org/x/oauth2/google"
@@ -17,8 +19,33 @@ import (
 	cloudtrace "google.golang.org/api/cloudtrace/v1"
 )
 
-func New(opts basictracer.Options) (opentracing.Tracer, func()) {
+// Option configures the recorder that exports spans to cloudtrace.
+type Option func(*recorder)
+
+// WithFaults injects the faults decided by inj into every export. A failed export drops its spans.
+// It is meant for tests.
+func WithFaults(inj *faultinject.Injector) Option {
+	return func(r *recorder) {
+		r.faults = inj
+	}
+}
+
+// OnFlush calls f with the result of every export, and with the initialization error of the exporter if
+// it could not be initialized, in which case spans are dropped.
+func OnFlush(f func(error)) Option {
+	return func(r *recorder) {
+		r.onFlush = f
+	}
+}
+
+func New(opts basictracer.Options, recorderOpts ...Option) (opentracing.Tracer, func()) {
 	r := newRecorder()
+	for _, o := range recorderOpts {
+		o(r)
+	}
+	if r.svc == nil && r.onFlush != nil {
+		r.onFlush(r.initErr)
+	}
 	opts.Recorder = r
 	return basictracer.NewWithOptions(opts), r.Close
 }
@@ -27,19 +54,19 @@ func newRecorder() *recorder {
 	client, err := google.DefaultClient(context.Background(), cloudtrace.TraceAppendScope)
 	if err != nil {
 		log.Printf("error initializing google.DefaultClient: %v", err)
-		return &recorder{}
+		return &recorder{initErr: fmt.Errorf("error initializing cloudtrace exporter: %v", err)}
 	}
 	// TODO: If the gRPC client is available, use that. It's not available as of 10/18/2016.
 	service, err := cloudtrace.New(client)
 	if err != nil {
 		log.Printf("error initializing cloudtrace Service: %v", err)
-		return &recorder{}
+		return &recorder{initErr: fmt.Errorf("error initializing cloudtrace exporter: %v", err)}
 	}
 
 	project, err := metadata.ProjectID()
 	if err != nil {
 		log.Printf("error retrieving GCP project: %v", err)
-		return &recorder{}
+		return &recorder{initErr: fmt.Errorf("error initializing cloudtrace exporter: %v", err)}
 	}
 
 	r := &recorder{
@@ -101,6 +128,10 @@ type recorder struct {
 	svc     *cloudtrace.ProjectsService
 	traces  chan *cloudtrace.Trace
 	project string
+	faults  *faultinject.Injector
+	onFlush func(error)
+	// initErr tells why svc is nil.
+	initErr error
 
 	done chan struct{}
 	wg   sync.WaitGroup
@@ -131,11 +162,16 @@ func (r *recorder) flushSpans(traces []*cloudtrace.Trace) {
 	}
 
 	traces = combined
-	_, err := r.svc.PatchTraces(r.project, &cloudtrace.Traces{Traces: traces}).Do()
-
+	err := r.faults.Inject()
+	if err == nil {
+		_, err = r.svc.PatchTraces(r.project, &cloudtrace.Traces{Traces: traces}).Do()
+	}
 	if err != nil {
 		log.Printf("error sending trace to cloudtrace: %v", err)
 	}
+	if r.onFlush != nil {
+		r.onFlush(err)
+	}
 }
 
 func (r *recorder) rawSpanToTrace(raw basictracer.RawSpan) *cloudtrace.Trace {
@@ -0,0 +1,168 @@
+package tracing
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"sync"
+
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+)
+
+// TagPolicy restricts the tags of spans, so that a misbehaving caller cannot leak personal data or
+// blow up the storage of the collector. Keys are matched with the patterns of path.Match, such as
+// "user.*". The sampling.priority tag, which controls sampling rather than being recorded, is exempt.
+type TagPolicy struct {
+	// Allow, when it is not empty, lists the only tags kept.
+	Allow []string
+	// Drop lists the tags removed.
+	Drop []string
+	// Hash lists the tags whose values are replaced by a keyed hash, so that spans with the same value
+	// can still be found together.
+	Hash []string
+	// HashKey is the key of the hashes, which keeps values with few possibilities, such as emails,
+	// from being recovered by hashing candidates.
+	HashKey []byte
+	// MaxValueLength truncates the string values, and errors, longer than it, unless it is 0.
+	MaxValueLength int
+	// MaxTags is the number of tags kept per span, unless it is 0. The span is tagged with
+	// TagsDroppedTag, the number of tags dropped beyond it.
+	MaxTags int
+}
+
+// TagsDroppedTag counts the tags of a span dropped because of TagPolicy.MaxTags.
+const TagsDroppedTag = "tag_policy.dropped"
+
+// WithTagPolicy returns a tracer enforcing policy on the tags of the spans started by tracer. It
+// returns an error if a pattern of policy is malformed.
+func WithTagPolicy(tracer opentracing.Tracer, policy TagPolicy) (opentracing.Tracer, error) {
+	for _, patterns := range [][]string{policy.Allow, policy.Drop, policy.Hash} {
+		for _, p := range patterns {
+			if _, err := path.Match(p, ""); err != nil {
+				return nil, fmt.Errorf("invalid tag pattern %q: %v", p, err)
+			}
+		}
+	}
+	if _, ok := tracer.(opentracing.NoopTracer); ok {
+		return tracer, nil
+	}
+	return &policyTracer{Tracer: tracer, policy: policy}, nil
+}
+
+type policyTracer struct {
+	opentracing.Tracer
+	policy TagPolicy
+}
+
+func (t *policyTracer) StartSpan(operationName string, opts ...opentracing.StartSpanOption) opentracing.Span {
+	var sso opentracing.StartSpanOptions
+	for _, o := range opts {
+		o.Apply(&sso)
+	}
+
+	s := &policySpan{tracer: t, keys: make(map[string]bool)}
+	tags := make(opentracing.Tags, len(sso.Tags))
+	for k, v := range sso.Tags {
+		if v, ok := s.filter(k, v); ok {
+			tags[k] = v
+		}
+	}
+	startOpts := []opentracing.StartSpanOption{opentracing.StartTime(sso.StartTime), tags}
+	for _, ref := range sso.References {
+		startOpts = append(startOpts, ref)
+	}
+	s.Span = t.Tracer.StartSpan(operationName, startOpts...)
+	return s
+}
+
+// policySpan enforces the policy of its tracer on the tags set on a span.
+type policySpan struct {
+	opentracing.Span
+	tracer *policyTracer
+
+	mutex   sync.Mutex // guards keys and dropped
+	keys    map[string]bool
+	dropped int
+}
+
+// filter returns the value of the tag k as allowed by the policy, and false if it is dropped.
+func (s *policySpan) filter(k string, v interface{}) (interface{}, bool) {
+	p := &s.tracer.policy
+	if k == string(ext.SamplingPriority) {
+		return v, true
+	}
+	if (len(p.Allow) > 0 && !matchAny(p.Allow, k)) || matchAny(p.Drop, k) {
+		return nil, false
+	}
+
+	s.mutex.Lock()
+	if p.MaxTags > 0 && !s.keys[k] && len(s.keys) >= p.MaxTags {
+		s.dropped++
+		s.mutex.Unlock()
+		return nil, false
+	}
+	s.keys[k] = true
+	s.mutex.Unlock()
+
+	if matchAny(p.Hash, k) {
+		mac := hmac.New(sha256.New, p.HashKey)
+		fmt.Fprint(mac, v)
+		return hex.EncodeToString(mac.Sum(nil)[:16]), true
+	}
+	if p.MaxValueLength > 0 {
+		if err, ok := v.(error); ok {
+			v = err.Error()
+		}
+		if str, ok := v.(string); ok && len(str) > p.MaxValueLength {
+			v = str[:p.MaxValueLength] + "..."
+		}
+	}
+	return v, true
+}
+
+func (s *policySpan) SetTag(k string, v interface{}) opentracing.Span {
+	if v, ok := s.filter(k, v); ok {
+		s.Span.SetTag(k, v)
+	}
+	return s
+}
+
+func (s *policySpan) SetOperationName(operationName string) opentracing.Span {
+	s.Span.SetOperationName(operationName)
+	return s
+}
+
+func (s *policySpan) SetBaggageItem(restrictedKey, value string) opentracing.Span {
+	s.Span.SetBaggageItem(restrictedKey, value)
+	return s
+}
+
+func (s *policySpan) Tracer() opentracing.Tracer {
+	return s.tracer
+}
+
+func (s *policySpan) Finish() {
+	s.FinishWithOptions(opentracing.FinishOptions{})
+}
+
+func (s *policySpan) FinishWithOptions(opts opentracing.FinishOptions) {
+	s.mutex.Lock()
+	dropped := s.dropped
+	s.mutex.Unlock()
+	if dropped > 0 {
+		s.Span.SetTag(TagsDroppedTag, dropped)
+	}
+	s.Span.FinishWithOptions(opts)
+}
+
+func matchAny(patterns []string, k string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, k); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,78 @@
+package tracing
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	basictracer "github.com/opentracing/basictracer-go"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/stretchr/testify/assert"
+)
+
+func newPolicyTestTracer(t *testing.T, policy TagPolicy) (opentracing.Tracer, *basictracer.InMemorySpanRecorder) {
+	recorder := basictracer.NewInMemoryRecorder()
+	opts := basictracer.DefaultOptions()
+	opts.Recorder = recorder
+	opts.ShouldSample = func(uint64) bool { return true }
+	tracer, err := WithTagPolicy(basictracer.NewWithOptions(opts), policy)
+	assert.NoError(t, err)
+	return tracer, recorder
+}
+
+func TestTagPolicy(t *testing.T) {
+	tracer, recorder := newPolicyTestTracer(t, TagPolicy{
+		Drop:           []string{"user.*"},
+		Hash:           []string{"email"},
+		HashKey:        []byte("key"),
+		MaxValueLength: 5,
+		MaxTags:        3,
+	})
+
+	span := tracer.StartSpan("op", opentracing.Tags{"user.ip": "10.0.0.1", "query": "select 1"})
+	child := span.Tracer().StartSpan("child", opentracing.ChildOf(span.Context()))
+	child.SetTag("email", "a@example.com").SetTag("err", errors.New("connection refused"))
+	child.SetTag("email", "b@example.com")
+	child.SetTag("rows", 3).SetTag("extra", 1)
+	child.Finish()
+	span.Finish()
+
+	spans := recorder.GetSpans()
+	if assert.Len(t, spans, 2) {
+		assert.Equal(t, opentracing.Tags{"query": "selec..."}, spans[1].Tags)
+		tags := spans[0].Tags
+		assert.Equal(t, "conne...", tags["err"])
+		assert.Equal(t, 3, tags["rows"])
+		assert.Len(t, tags["email"], 32)
+		assert.NotContains(t, tags["email"], "example")
+		assert.Nil(t, tags["extra"])
+		assert.Equal(t, 1, tags[TagsDroppedTag])
+		assert.Equal(t, spans[1].Context.SpanID, spans[0].ParentSpanID)
+	}
+}
+
+func TestTagPolicyAllow(t *testing.T) {
+	tracer, recorder := newPolicyTestTracer(t, TagPolicy{Allow: []string{"http.*", "error"}})
+
+	span := tracer.StartSpan("op")
+	ext.HTTPMethod.Set(span, "GET")
+	ext.Error.Set(span, true)
+	span.SetTag("user_id", 42)
+	ext.SamplingPriority.Set(span, 1)
+	span.Finish()
+
+	if spans := recorder.GetSpans(); assert.Len(t, spans, 1) {
+		assert.Equal(t, opentracing.Tags{"http.method": "GET", "error": true}, spans[0].Tags)
+	}
+}
+
+func TestTagPolicyInvalidPattern(t *testing.T) {
+	_, err := WithTagPolicy(opentracing.NoopTracer{}, TagPolicy{Drop: []string{"user.[id"}})
+	if assert.Error(t, err) {
+		assert.True(t, strings.Contains(err.Error(), "user.[id"))
+	}
+	tracer, err := WithTagPolicy(opentracing.NoopTracer{}, TagPolicy{})
+	assert.NoError(t, err)
+	assert.Equal(t, opentracing.NoopTracer{}, tracer)
+}
@@ -0,0 +1,173 @@
+package tracing
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	opentracing "github.com/opentracing/opentracing-go"
+	jaeger "github.com/uber/jaeger-client-go"
+	jaegercfg "github.com/uber/jaeger-client-go/config"
+	zipkintransport "github.com/uber/jaeger-client-go/transport/zipkin"
+	"github.com/uber/jaeger-client-go/zipkin"
+)
+
+// DefaultZipkinEndpoint is the Zipkin collector endpoint NewZipkin reports to by default.
+const DefaultZipkinEndpoint = "http://127.0.0.1:9411/api/v1/spans"
+
+// b3Header is the single header form of B3 propagation: {traceid}-{spanid}[-{sampled}[-{parentspanid}]],
+// or only the sampling state.
+const b3Header = "b3"
+
+// NewZipkin returns a tracer reporting spans of serviceName to the Zipkin collector at endpoint, or
+// DefaultZipkinEndpoint when it is empty. Spans are buffered and sent asynchronously in batches.
+// Spans are sampled as for NewJaeger, and the same options apply, except JaegerCollector.
+//
+// Span contexts are propagated with B3 headers: they are injected both as the single b3 header and
+// as the X-B3-* headers, and extracted from either, preferring the b3 header. As is usual with Zipkin,
+// servers share the span of their clients. The returned function flushes buffered spans and closes
+// the tracer.
+func NewZipkin(serviceName, endpoint string, opts ...JaegerOption) (opentracing.Tracer, func(), error) {
+	if endpoint == "" {
+		endpoint = DefaultZipkinEndpoint
+	}
+	cfg := &JaegerConfig{Configuration: jaegercfg.Configuration{
+		ServiceName: serviceName,
+		Sampler:     &jaegercfg.SamplerConfig{Type: jaeger.SamplerTypeProbabilistic, Param: 0.01},
+		Reporter:    &jaegercfg.ReporterConfig{BufferFlushInterval: time.Second},
+		Tags:        processTags(),
+	}}
+	for _, o := range opts {
+		o(cfg)
+	}
+
+	transport, err := zipkintransport.NewHTTPTransport(endpoint)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error initializing zipkin transport: %v", err)
+	}
+	reporter := jaeger.NewRemoteReporter(transport,
+		jaeger.ReporterOptions.BufferFlushInterval(cfg.Reporter.BufferFlushInterval))
+	propagator := newB3Propagator()
+	tracer, closer, err := cfg.newTracer(&tracerOptions{
+		reporter: reporter,
+		propagators: map[interface{}]b3Propagator{
+			opentracing.HTTPHeaders: propagator,
+			opentracing.TextMap:     propagator,
+		},
+		zipkinSharedRPCSpan: true,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("error initializing zipkin tracer: %v", err)
+	}
+	return tracer, func() { closer.Close() }, nil
+}
+
+// b3Propagator injects and extracts span contexts with both the single and multiple B3 headers.
+type b3Propagator struct {
+	multi zipkin.Propagator
+}
+
+func newB3Propagator() b3Propagator {
+	return b3Propagator{multi: zipkin.NewZipkinB3HTTPHeaderPropagator()}
+}
+
+func (p b3Propagator) Inject(sc jaeger.SpanContext, carrier interface{}) error {
+	writer, ok := carrier.(opentracing.TextMapWriter)
+	if !ok {
+		return opentracing.ErrInvalidCarrier
+	}
+	writer.Set(b3Header, formatB3(sc))
+	return p.multi.Inject(sc, carrier)
+}
+
+func (p b3Propagator) Extract(carrier interface{}) (jaeger.SpanContext, error) {
+	reader, ok := carrier.(opentracing.TextMapReader)
+	if !ok {
+		return jaeger.SpanContext{}, opentracing.ErrInvalidCarrier
+	}
+	var single string
+	reader.ForeachKey(func(k, v string) error {
+		if strings.EqualFold(k, b3Header) {
+			single = v
+		}
+		return nil
+	})
+	if single == "" {
+		return p.multi.Extract(carrier)
+	}
+
+	sc, err := parseB3(single)
+	if err != nil {
+		return jaeger.SpanContext{}, err
+	}
+	// the b3 header carries no baggage, which is still sent as separate headers
+	multi, err := p.multi.Extract(carrier)
+	if err != nil && err != opentracing.ErrSpanContextNotFound {
+		return jaeger.SpanContext{}, err
+	}
+	multi.ForeachBaggageItem(func(k, v string) bool {
+		sc = sc.WithBaggageItem(k, v)
+		return true
+	})
+	return sc, nil
+}
+
+// formatB3 returns the value of the b3 header for sc, with fixed width ids as other implementations expect.
+func formatB3(sc jaeger.SpanContext) string {
+	traceID := sc.TraceID()
+	var b strings.Builder
+	if traceID.High != 0 {
+		fmt.Fprintf(&b, "%016x", traceID.High)
+	}
+	fmt.Fprintf(&b, "%016x-%016x-", traceID.Low, uint64(sc.SpanID()))
+	switch {
+	case sc.IsDebug():
+		b.WriteString("d")
+	case sc.IsSampled():
+		b.WriteString("1")
+	default:
+		b.WriteString("0")
+	}
+	if sc.ParentID() != 0 {
+		fmt.Fprintf(&b, "-%016x", uint64(sc.ParentID()))
+	}
+	return b.String()
+}
+
+// parseB3 parses the value of a b3 header. A header holding only a sampling decision has no span context.
+func parseB3(value string) (jaeger.SpanContext, error) {
+	parts := strings.Split(value, "-")
+	if len(parts) == 1 {
+		return jaeger.SpanContext{}, opentracing.ErrSpanContextNotFound
+	}
+	if len(parts) > 4 {
+		return jaeger.SpanContext{}, opentracing.ErrSpanContextCorrupted
+	}
+
+	traceID, err := jaeger.TraceIDFromString(parts[0])
+	if err != nil || !traceID.IsValid() {
+		return jaeger.SpanContext{}, opentracing.ErrSpanContextCorrupted
+	}
+	spanID, err := strconv.ParseUint(parts[1], 16, 64)
+	if err != nil {
+		return jaeger.SpanContext{}, opentracing.ErrSpanContextCorrupted
+	}
+	sampled := false
+	if len(parts) > 2 {
+		switch parts[2] {
+		case "1", "d", "true":
+			sampled = true
+		case "0", "false":
+		default:
+			return jaeger.SpanContext{}, opentracing.ErrSpanContextCorrupted
+		}
+	}
+	var parentID uint64
+	if len(parts) > 3 {
+		if parentID, err = strconv.ParseUint(parts[3], 16, 64); err != nil {
+			return jaeger.SpanContext{}, opentracing.ErrSpanContextCorrupted
+		}
+	}
+	return jaeger.NewSpanContext(traceID, jaeger.SpanID(spanID), jaeger.SpanID(parentID), sampled, nil), nil
+}
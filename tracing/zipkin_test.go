@@ -0,0 +1,120 @@
+package tracing
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/stretchr/testify/assert"
+	jaeger "github.com/uber/jaeger-client-go"
+)
+
+func TestNewZipkin(t *testing.T) {
+	bodies := make(chan string, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies <- string(body)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	tracer, closeTracer, err := NewZipkin("my-service", server.URL+"/api/v1/spans", ConstSampler(true))
+	assert.NoError(t, err)
+
+	tracer.StartSpan("my-operation").Finish()
+	closeTracer()
+
+	select {
+	case batch := <-bodies:
+		assert.True(t, strings.Contains(batch, "my-service"))
+		assert.True(t, strings.Contains(batch, "my-operation"))
+	case <-time.After(5 * time.Second):
+		t.Fatal("no spans were sent")
+	}
+}
+
+func TestZipkinPropagation(t *testing.T) {
+	tracer, closeTracer, err := NewZipkin("my-service", "http://127.0.0.1:1/api/v1/spans", ConstSampler(true))
+	assert.NoError(t, err)
+	defer closeTracer()
+
+	span := tracer.StartSpan("client")
+	span.SetBaggageItem("tenant", "acme")
+	defer span.Finish()
+	headers := http.Header{}
+	assert.NoError(t, tracer.Inject(span.Context(), opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(headers)))
+
+	sc := span.Context().(jaeger.SpanContext)
+	assert.Equal(t, formatB3(sc), headers.Get("b3"))
+	assert.NotEmpty(t, headers.Get("X-B3-TraceId"))
+
+	// the b3 header takes precedence over the others
+	headers.Set("X-B3-TraceId", "1")
+	extracted, err := tracer.Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(headers))
+	if assert.NoError(t, err) {
+		esc := extracted.(jaeger.SpanContext)
+		assert.Equal(t, sc.TraceID(), esc.TraceID())
+		assert.Equal(t, sc.SpanID(), esc.SpanID())
+		assert.True(t, esc.IsSampled())
+		baggage := map[string]string{}
+		esc.ForeachBaggageItem(func(k, v string) bool {
+			baggage[k] = v
+			return true
+		})
+		assert.Equal(t, map[string]string{"tenant": "acme"}, baggage)
+	}
+
+	// only the X-B3-* headers
+	headers.Del("b3")
+	extracted, err = tracer.Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(headers))
+	if assert.NoError(t, err) {
+		assert.Equal(t, jaeger.TraceID{Low: 1}, extracted.(jaeger.SpanContext).TraceID())
+	}
+}
+
+func TestZipkinIDs(t *testing.T) {
+	tracer, closeTracer, err := NewZipkin("my-service", "http://127.0.0.1:1/api/v1/spans", ConstSampler(true),
+		JaegerTraceID128Bit(), JaegerIDs(SequentialIDs(1)))
+	assert.NoError(t, err)
+	defer closeTracer()
+
+	span := tracer.StartSpan("client")
+	defer span.Finish()
+	headers := http.Header{}
+	assert.NoError(t, tracer.Inject(span.Context(), opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(headers)))
+	assert.Equal(t, "00000000000000030000000000000002-0000000000000002-1", headers.Get("b3"))
+
+	// servers share the span of their clients
+	server := tracer.StartSpan("server", opentracing.ChildOf(span.Context()), opentracing.Tag{Key: "span.kind", Value: "server"})
+	defer server.Finish()
+	assert.Equal(t, span.Context().(jaeger.SpanContext).SpanID(), server.Context().(jaeger.SpanContext).SpanID())
+}
+
+func TestParseB3(t *testing.T) {
+	sc, err := parseB3("80f198ee56343ba864fe8b2a57d3eff7-e457b5a2e4d86bd1-1-05e3ac9a4f6e3b90")
+	if assert.NoError(t, err) {
+		assert.Equal(t, jaeger.TraceID{High: 0x80f198ee56343ba8, Low: 0x64fe8b2a57d3eff7}, sc.TraceID())
+		assert.Equal(t, jaeger.SpanID(0xe457b5a2e4d86bd1), sc.SpanID())
+		assert.Equal(t, jaeger.SpanID(0x05e3ac9a4f6e3b90), sc.ParentID())
+		assert.True(t, sc.IsSampled())
+		assert.Equal(t, "80f198ee56343ba864fe8b2a57d3eff7-e457b5a2e4d86bd1-1-05e3ac9a4f6e3b90", formatB3(sc))
+	}
+
+	sc, err = parseB3("000000000000000a-000000000000000b")
+	if assert.NoError(t, err) {
+		assert.Equal(t, jaeger.TraceID{Low: 10}, sc.TraceID())
+		assert.False(t, sc.IsSampled())
+		assert.Equal(t, "000000000000000a-000000000000000b-0", formatB3(sc))
+	}
+
+	_, err = parseB3("0")
+	assert.Equal(t, opentracing.ErrSpanContextNotFound, err)
+	for _, invalid := range []string{"a-b-x", "zz-b", "0-1", "a-b-1-c-d"} {
+		_, err = parseB3(invalid)
+		assert.Equal(t, opentracing.ErrSpanContextCorrupted, err, invalid)
+	}
+}
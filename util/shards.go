@@ -0,0 +1,43 @@
+package util
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// Shards hands out shard indexes, so that state updated by many goroutines can be split into shards
+// updated without contention and merged when read. Goroutines running on the same processor mostly
+// get the same index, and goroutines running on different processors different ones.
+type Shards struct {
+	n    int
+	next uint32
+	pool sync.Pool
+}
+
+// NewShards returns Shards handing out indexes below n, or below the number of CPUs if n is not positive.
+func NewShards(n int) *Shards {
+	if n <= 0 {
+		n = runtime.NumCPU()
+	}
+	s := &Shards{n: n}
+	// sync.Pool keeps a cache per processor, so each processor keeps getting the index it was given
+	// first, until a GC clears the pool and indexes are handed out again.
+	s.pool.New = func() interface{} {
+		i := int((atomic.AddUint32(&s.next, 1) - 1) % uint32(s.n))
+		return &i
+	}
+	return s
+}
+
+// Len returns the number of shards.
+func (s *Shards) Len() int {
+	return s.n
+}
+
+// Index returns the index of the shard the calling goroutine should update.
+func (s *Shards) Index() int {
+	i := s.pool.Get().(*int)
+	s.pool.Put(i)
+	return *i
+}
@@ -0,0 +1,53 @@
+package util
+
+import (
+	"net"
+	"net/http"
+	"time"
+)
+
+// CloneTransport returns a copy of t, or of a transport configured like http.DefaultTransport if t is
+// nil, to configure without affecting the clients sharing t. It copies the configuration fields, not
+// the idle connections, as http.Transport.Clone does from Go 1.13 on.
+func CloneTransport(t *http.Transport) *http.Transport {
+	if t == nil {
+		return &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: (&net.Dialer{
+				Timeout:   30 * time.Second,
+				KeepAlive: 30 * time.Second,
+				DualStack: true,
+			}).DialContext,
+			MaxIdleConns:          100,
+			IdleConnTimeout:       90 * time.Second,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ExpectContinueTimeout: 1 * time.Second,
+		}
+	}
+	clone := &http.Transport{
+		Proxy:                  t.Proxy,
+		DialContext:            t.DialContext,
+		Dial:                   t.Dial,
+		DialTLS:                t.DialTLS,
+		TLSHandshakeTimeout:    t.TLSHandshakeTimeout,
+		DisableKeepAlives:      t.DisableKeepAlives,
+		DisableCompression:     t.DisableCompression,
+		MaxIdleConns:           t.MaxIdleConns,
+		MaxIdleConnsPerHost:    t.MaxIdleConnsPerHost,
+		MaxConnsPerHost:        t.MaxConnsPerHost,
+		IdleConnTimeout:        t.IdleConnTimeout,
+		ResponseHeaderTimeout:  t.ResponseHeaderTimeout,
+		ExpectContinueTimeout:  t.ExpectContinueTimeout,
+		MaxResponseHeaderBytes: t.MaxResponseHeaderBytes,
+	}
+	if t.TLSClientConfig != nil {
+		clone.TLSClientConfig = t.TLSClientConfig.Clone()
+	}
+	if t.ProxyConnectHeader != nil {
+		clone.ProxyConnectHeader = make(http.Header, len(t.ProxyConnectHeader))
+		for k, v := range t.ProxyConnectHeader {
+			clone.ProxyConnectHeader[k] = v
+		}
+	}
+	return clone
+}
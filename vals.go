@@ -0,0 +1,185 @@
+package obs
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// WithInt returns a copy of v with n at key.
+func (v Vals) WithInt(key string, n int64) Vals {
+	return v.with(key, n)
+}
+
+// WithStr returns a copy of v with s at key.
+func (v Vals) WithStr(key, s string) Vals {
+	return v.with(key, s)
+}
+
+// WithDur returns a copy of v with d at key, in microseconds like the _us stats and the durations
+// logged by StartTimer.
+func (v Vals) WithDur(key string, d time.Duration) Vals {
+	return v.with(key, int64(d/time.Microsecond))
+}
+
+// WithBool returns a copy of v with b at key.
+func (v Vals) WithBool(key string, b bool) Vals {
+	return v.with(key, b)
+}
+
+// WithTime returns a copy of v with t at key, formatted as RFC3339 in UTC.
+func (v Vals) WithTime(key string, t time.Time) Vals {
+	return v.with(key, formatValTime(t))
+}
+
+func (v Vals) with(key string, val interface{}) Vals {
+	res := make(Vals, len(v)+1)
+	for k, existing := range v {
+		res[k] = existing
+	}
+	res[key] = val
+	return res
+}
+
+// Validate returns an error naming the first val, in the order of keys, that cannot be serialized to
+// JSON, such as a channel or a func. Logging such vals as JSON fails, and the whole log line is lost.
+func (v Vals) Validate() error {
+	keys := make([]string, 0, len(v))
+	for k := range v {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if err := validateVal(k, v[k]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateVal(key string, val interface{}) error {
+	if _, err := json.Marshal(val); err != nil {
+		return fmt.Errorf("val %s of type %T cannot be serialized: %v", key, val, err)
+	}
+	return nil
+}
+
+func formatValTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// ValsBuilder builds Vals with typed setters. Unlike the With methods of Vals, it does not copy the
+// vals at each step, and values are kept typed until Vals is called, so that nothing is boxed when
+// the builder ends up unused. Values of other types given to Any are checked when they are set.
+//
+//	vals, err := obs.NewValsBuilder().Str("table", table).Int("rows", n).Dur("elapsed", d).Build()
+type ValsBuilder struct {
+	vals []typedVal
+	err  error
+}
+
+type valKind uint8
+
+const (
+	valInt valKind = iota
+	valFloat
+	valStr
+	valBool
+	valAny
+)
+
+type typedVal struct {
+	key  string
+	kind valKind
+	n    int64
+	f    float64
+	s    string
+	any  interface{}
+}
+
+// NewValsBuilder returns an empty ValsBuilder.
+func NewValsBuilder() *ValsBuilder {
+	return &ValsBuilder{}
+}
+
+// Int sets n at key.
+func (b *ValsBuilder) Int(key string, n int64) *ValsBuilder {
+	b.vals = append(b.vals, typedVal{key: key, kind: valInt, n: n})
+	return b
+}
+
+// Float sets f at key.
+func (b *ValsBuilder) Float(key string, f float64) *ValsBuilder {
+	b.vals = append(b.vals, typedVal{key: key, kind: valFloat, f: f})
+	return b
+}
+
+// Str sets s at key.
+func (b *ValsBuilder) Str(key, s string) *ValsBuilder {
+	b.vals = append(b.vals, typedVal{key: key, kind: valStr, s: s})
+	return b
+}
+
+// Dur sets d at key, in microseconds like Vals.WithDur.
+func (b *ValsBuilder) Dur(key string, d time.Duration) *ValsBuilder {
+	return b.Int(key, int64(d/time.Microsecond))
+}
+
+// Bool sets v at key.
+func (b *ValsBuilder) Bool(key string, v bool) *ValsBuilder {
+	var n int64
+	if v {
+		n = 1
+	}
+	b.vals = append(b.vals, typedVal{key: key, kind: valBool, n: n})
+	return b
+}
+
+// Time sets t at key, formatted like Vals.WithTime.
+func (b *ValsBuilder) Time(key string, t time.Time) *ValsBuilder {
+	return b.Str(key, formatValTime(t))
+}
+
+// Any sets v at key if it can be serialized to JSON. Otherwise, key is set to a description of the
+// error, which is also returned by Err and Build.
+func (b *ValsBuilder) Any(key string, v interface{}) *ValsBuilder {
+	if err := validateVal(key, v); err != nil {
+		if b.err == nil {
+			b.err = err
+		}
+		return b.Str(key, err.Error())
+	}
+	b.vals = append(b.vals, typedVal{key: key, kind: valAny, any: v})
+	return b
+}
+
+// Err returns the error of the first value given to Any that cannot be serialized, or nil.
+func (b *ValsBuilder) Err() error {
+	return b.err
+}
+
+// Vals returns the vals set, the last one winning when a key is set more than once.
+func (b *ValsBuilder) Vals() Vals {
+	vals := make(Vals, len(b.vals))
+	for _, v := range b.vals {
+		switch v.kind {
+		case valInt:
+			vals[v.key] = v.n
+		case valFloat:
+			vals[v.key] = v.f
+		case valStr:
+			vals[v.key] = v.s
+		case valBool:
+			vals[v.key] = v.n == 1
+		default:
+			vals[v.key] = v.any
+		}
+	}
+	return vals
+}
+
+// Build returns Vals and Err.
+func (b *ValsBuilder) Build() (Vals, error) {
+	return b.Vals(), b.err
+}
@@ -0,0 +1,57 @@
+package obs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValsTypedSetters(t *testing.T) {
+	at := time.Date(2019, 3, 1, 12, 30, 0, 0, time.FixedZone("PST", -8*3600))
+	v := Vals{"a": 1}
+	res := v.WithInt("rows", 3).WithStr("table", "events").WithDur("elapsed", 1500*time.Microsecond).
+		WithBool("cached", true).WithTime("at", at)
+
+	assert.Equal(t, Vals{"a": 1}, v)
+	assert.Equal(t, Vals{
+		"a":       1,
+		"rows":    int64(3),
+		"table":   "events",
+		"elapsed": int64(1500),
+		"cached":  true,
+		"at":      "2019-03-01T20:30:00Z",
+	}, res)
+}
+
+func TestValsValidate(t *testing.T) {
+	assert.NoError(t, Vals{"a": 1, "b": []string{"x"}, "c": nil}.Validate())
+	err := Vals{"a": 1, "callback": func() {}, "z": make(chan int)}.Validate()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "val callback of type func()")
+	}
+}
+
+func TestValsBuilder(t *testing.T) {
+	vals, err := NewValsBuilder().Int("rows", 3).Float("ratio", 0.5).Str("table", "events").
+		Dur("elapsed", time.Millisecond).Bool("cached", false).Time("at", time.Unix(0, 0)).
+		Any("ids", []int{1, 2}).Int("rows", 4).Build()
+	assert.NoError(t, err)
+	assert.Equal(t, Vals{
+		"rows":    int64(4),
+		"ratio":   0.5,
+		"table":   "events",
+		"elapsed": int64(1000),
+		"cached":  false,
+		"at":      "1970-01-01T00:00:00Z",
+		"ids":     []int{1, 2},
+	}, vals)
+
+	b := NewValsBuilder().Any("ch", make(chan int)).Str("table", "events")
+	vals, err = b.Build()
+	assert.Error(t, err)
+	assert.Equal(t, err, b.Err())
+	assert.Equal(t, err.Error(), vals["ch"])
+	assert.Equal(t, "events", vals["table"])
+	assert.NoError(t, vals.Validate())
+}